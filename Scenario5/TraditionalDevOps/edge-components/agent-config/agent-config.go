@@ -0,0 +1,438 @@
+package agentconfig
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"sigs.k8s.io/yaml"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// EnvPrefix prefixes the environment variables overriding the file, e.g.
+// EDGE_AGENT_DEVICE_ID or EDGE_AGENT_ROLLOUT_CHECK_INTERVAL
+const EnvPrefix = "EDGE_AGENT_"
+
+// Config is the agent's configuration for both managers
+type Config struct {
+	DeviceID    string            `json:"deviceId"`
+	TenantID    string            `json:"tenantId,omitempty"`
+	DeviceGroup string            `json:"deviceGroup,omitempty"`
+	DeviceTags  map[string]string `json:"deviceTags,omitempty"`
+	DataDir     string            `json:"dataDir"` // base for the default update, cache and store paths; /var/lib/edge-agent by default
+
+	// Reloadable without a restart, together with the rollout polling
+	// settings and the sync interval and upload filter
+	LogLevel       string `json:"logLevel,omitempty"`       // info (default) or error
+	BandwidthLimit int64  `json:"bandwidthLimit,omitempty"` // bytes per second shared by downloads and sync; 0 is unlimited
+	BandwidthCap   int64  `json:"bandwidthCap,omitempty"`   // bytes per billing period, both directions; 0 is unlimited
+
+	// BillingDay is the day of the month, 1 to 28, the metered plan's
+	// billing period starts; defaults to 1 and needs a restart
+	BillingDay int `json:"billingDay,omitempty"`
+
+	// LogSink is where Logger sends agent logs: stderr (the default), or
+	// cloudwatch to also ship them to LogGroup in CloudWatch Logs
+	LogSink  string `json:"logSink,omitempty"`
+	LogGroup string `json:"logGroup,omitempty"`
+
+	AWS     AWSConfig     `json:"aws"`
+	Rollout RolloutConfig `json:"rollout"`
+	Sync    SyncConfig    `json:"sync"`
+}
+
+// AWSConfig selects the AWS region and credentials; the endpoints point the
+// clients at localstack or minio
+type AWSConfig struct {
+	Region         string `json:"region,omitempty"`
+	Profile        string `json:"profile,omitempty"`
+	DynamoEndpoint string `json:"dynamoEndpoint,omitempty"`
+	S3Endpoint     string `json:"s3Endpoint,omitempty"`
+}
+
+// RolloutConfig is the rollout section, see rollout.RolloutConfig
+type RolloutConfig struct {
+	RolloutTable      string   `json:"rolloutTable"`
+	DeviceTable       string   `json:"deviceTable"`
+	ArtifactTable     string   `json:"artifactTable,omitempty"`
+	UpdatePath        string   `json:"updatePath,omitempty"`
+	CheckInterval     Duration `json:"checkInterval,omitempty"`
+	IdleCheckInterval Duration `json:"idleCheckInterval,omitempty"`
+	PollJitter        float64  `json:"pollJitter,omitempty"`
+	PlanCacheTTL      Duration `json:"planCacheTTL,omitempty"`
+	QueryBudget       int      `json:"queryBudget,omitempty"`
+	QueryBudgetWindow Duration `json:"queryBudgetWindow,omitempty"`
+}
+
+// SyncConfig is the sync section, see offlineSync.SyncConfig
+type SyncConfig struct {
+	Bucket         string   `json:"bucket,omitempty"`
+	ProxyURL       string   `json:"proxyUrl,omitempty"` // syncs through a gateway proxy instead of S3
+	Interval       Duration `json:"interval,omitempty"`
+	CachePath      string   `json:"cachePath,omitempty"`
+	StorageBackend string   `json:"storageBackend,omitempty"` // badger, bolt, or memory for laptop development
+	BadgerPath     string   `json:"badgerPath,omitempty"`
+	BoltPath       string   `json:"boltPath,omitempty"`
+	UploadTypes    []string `json:"uploadTypes,omitempty"`   // data types allowed to upload; empty allows all
+	CriticalTypes  []string `json:"criticalTypes,omitempty"` // data types that still sync once bandwidthCap is used up
+}
+
+// Duration is a time.Duration written as a string such as "5m" or "1h30m"
+type Duration time.Duration
+
+// UnmarshalJSON accepts a duration string or a number of seconds
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var value interface{}
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+
+	switch v := value.(type) {
+	case string:
+		parsed, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		*d = Duration(parsed)
+	case float64:
+		*d = Duration(time.Duration(v * float64(time.Second)))
+	default:
+		return fmt.Errorf("invalid duration %s", data)
+	}
+
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// Load reads the configuration from a YAML file, applies environment
+// overrides and defaults, and validates it. An empty path configures the
+// agent from the environment alone.
+func Load(path string) (*Config, error) {
+	cfg := &Config{}
+
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read agent config: %w", err)
+		}
+		if err := yaml.UnmarshalStrict(data, cfg); err != nil {
+			return nil, fmt.Errorf("failed to parse agent config %s: %w", path, err)
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+	cfg.applyDefaults()
+
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid agent config: %w", err)
+	}
+
+	return cfg, nil
+}
+
+// Validate checks that the configuration can build both managers
+func (c *Config) Validate() error {
+	switch {
+	case c.DeviceID == "":
+		return errors.New("deviceId is required")
+	case c.Rollout.RolloutTable == "" || c.Rollout.DeviceTable == "":
+		return errors.New("rollout.rolloutTable and rollout.deviceTable are required")
+	case c.Sync.Bucket == "" && c.Sync.ProxyURL == "":
+		return errors.New("sync.bucket or sync.proxyUrl is required")
+	case c.Rollout.CheckInterval < 0 || c.Rollout.IdleCheckInterval < 0 || c.Sync.Interval < 0:
+		return errors.New("intervals must not be negative")
+	case c.Rollout.PollJitter < 0 || c.Rollout.PollJitter >= 1:
+		return errors.New("rollout.pollJitter must be in [0, 1)")
+	case c.Rollout.QueryBudget < 0:
+		return errors.New("rollout.queryBudget must not be negative")
+	case c.BandwidthLimit < 0:
+		return errors.New("bandwidthLimit must not be negative")
+	case c.BandwidthCap < 0:
+		return errors.New("bandwidthCap must not be negative")
+	case c.BillingDay < 0 || c.BillingDay > 28:
+		return errors.New("billingDay must be in [1, 28]")
+	}
+
+	if _, err := ParseLevel(c.LogLevel); err != nil {
+		return err
+	}
+
+	switch c.LogSink {
+	case LogSinkStderr:
+	case LogSinkCloudWatch:
+		if c.LogGroup == "" {
+			return errors.New("logGroup is required with the cloudwatch logSink")
+		}
+	default:
+		return fmt.Errorf("unknown logSink %q", c.LogSink)
+	}
+
+	switch c.Sync.StorageBackend {
+	case kvstore.BackendBadger, kvstore.BackendBolt, kvstore.BackendMemory:
+	default:
+		return fmt.Errorf("unknown sync.storageBackend %q", c.Sync.StorageBackend)
+	}
+
+	return nil
+}
+
+// Clients creates the DynamoDB and S3 clients, using the default credential
+// chain with the configured region, profile and endpoints
+func (c *Config) Clients(ctx context.Context) (*dynamodb.Client, *s3.Client, error) {
+	awsConfig, err := c.loadAWSConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(awsConfig, func(o *dynamodb.Options) {
+		if c.AWS.DynamoEndpoint != "" {
+			o.BaseEndpoint = aws.String(c.AWS.DynamoEndpoint)
+		}
+	})
+
+	s3Client := s3.NewFromConfig(awsConfig, func(o *s3.Options) {
+		if c.AWS.S3Endpoint != "" {
+			o.BaseEndpoint = aws.String(c.AWS.S3Endpoint)
+			// minio and localstack serve buckets by path rather than virtual host
+			o.UsePathStyle = true
+		}
+	})
+
+	return dynamoClient, s3Client, nil
+}
+
+// loadAWSConfig loads the default credential chain with the configured region and profile
+func (c *Config) loadAWSConfig(ctx context.Context) (aws.Config, error) {
+	var opts []func(*config.LoadOptions) error
+	if c.AWS.Region != "" {
+		opts = append(opts, config.WithRegion(c.AWS.Region))
+	}
+	if c.AWS.Profile != "" {
+		opts = append(opts, config.WithSharedConfigProfile(c.AWS.Profile))
+	}
+
+	awsConfig, err := config.LoadDefaultConfig(ctx, opts...)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	return awsConfig, nil
+}
+
+// RolloutConfig returns the RolloutManager configuration
+func (c *Config) RolloutConfig(dynamoClient *dynamodb.Client, s3Client *s3.Client) rollout.RolloutConfig {
+	return rollout.RolloutConfig{
+		DynamoClient:      dynamoClient,
+		S3Client:          s3Client,
+		DeviceID:          c.DeviceID,
+		TenantID:          c.TenantID,
+		DeviceGroup:       c.DeviceGroup,
+		DeviceTags:        c.DeviceTags,
+		RolloutTableName:  c.Rollout.RolloutTable,
+		DeviceTableName:   c.Rollout.DeviceTable,
+		ArtifactTableName: c.Rollout.ArtifactTable,
+		UpdateBasePath:    c.Rollout.UpdatePath,
+		CheckInterval:     time.Duration(c.Rollout.CheckInterval),
+		IdleCheckInterval: time.Duration(c.Rollout.IdleCheckInterval),
+		PollJitter:        c.Rollout.PollJitter,
+		PlanCacheTTL:      time.Duration(c.Rollout.PlanCacheTTL),
+		QueryBudget:       c.Rollout.QueryBudget,
+		QueryBudgetWindow: time.Duration(c.Rollout.QueryBudgetWindow),
+	}
+}
+
+// SyncConfig returns the SyncManager configuration
+func (c *Config) SyncConfig(s3Client *s3.Client) offlineSync.SyncConfig {
+	return offlineSync.SyncConfig{
+		DeviceID:       c.DeviceID,
+		TenantID:       c.TenantID,
+		LocalCachePath: c.Sync.CachePath,
+		SyncBucket:     c.Sync.Bucket,
+		SyncInterval:   time.Duration(c.Sync.Interval),
+		BadgerDBPath:   c.Sync.BadgerPath,
+		StorageBackend: c.Sync.StorageBackend,
+		BoltDBPath:     c.Sync.BoltPath,
+		S3Client:       s3Client,
+		ProxyURL:       c.Sync.ProxyURL,
+	}
+}
+
+// Build creates the AWS clients and returns both manager configurations
+func (c *Config) Build(ctx context.Context) (rollout.RolloutConfig, offlineSync.SyncConfig, error) {
+	dynamoClient, s3Client, err := c.Clients(ctx)
+	if err != nil {
+		return rollout.RolloutConfig{}, offlineSync.SyncConfig{}, err
+	}
+
+	return c.RolloutConfig(dynamoClient, s3Client), c.SyncConfig(s3Client), nil
+}
+
+// Meter opens the bandwidth meter, kept in dataDir so its counters survive
+// restarts; pass it to both managers with WithMeter
+func (c *Config) Meter() (*bandwidth.Meter, error) {
+	return bandwidth.NewMeter(bandwidth.MeterConfig{
+		Path:     filepath.Join(c.DataDir, "bandwidth.json"),
+		ResetDay: c.BillingDay,
+		Cap:      c.BandwidthCap,
+	})
+}
+
+// applyEnv overrides file values with the EDGE_AGENT_ environment variables
+func (c *Config) applyEnv() error {
+	strs := map[string]*string{
+		"DEVICE_ID":            &c.DeviceID,
+		"TENANT_ID":            &c.TenantID,
+		"DEVICE_GROUP":         &c.DeviceGroup,
+		"DATA_DIR":             &c.DataDir,
+		"LOG_LEVEL":            &c.LogLevel,
+		"LOG_SINK":             &c.LogSink,
+		"LOG_GROUP":            &c.LogGroup,
+		"AWS_REGION":           &c.AWS.Region,
+		"AWS_PROFILE":          &c.AWS.Profile,
+		"DYNAMO_ENDPOINT":      &c.AWS.DynamoEndpoint,
+		"S3_ENDPOINT":          &c.AWS.S3Endpoint,
+		"ROLLOUT_TABLE":        &c.Rollout.RolloutTable,
+		"DEVICE_TABLE":         &c.Rollout.DeviceTable,
+		"ARTIFACT_TABLE":       &c.Rollout.ArtifactTable,
+		"UPDATE_PATH":          &c.Rollout.UpdatePath,
+		"SYNC_BUCKET":          &c.Sync.Bucket,
+		"SYNC_PROXY_URL":       &c.Sync.ProxyURL,
+		"SYNC_CACHE_PATH":      &c.Sync.CachePath,
+		"SYNC_STORAGE_BACKEND": &c.Sync.StorageBackend,
+		"SYNC_BADGER_PATH":     &c.Sync.BadgerPath,
+		"SYNC_BOLT_PATH":       &c.Sync.BoltPath,
+	}
+	for name, field := range strs {
+		if value := os.Getenv(EnvPrefix + name); value != "" {
+			*field = value
+		}
+	}
+
+	durations := map[string]*Duration{
+		"ROLLOUT_CHECK_INTERVAL":      &c.Rollout.CheckInterval,
+		"ROLLOUT_IDLE_CHECK_INTERVAL": &c.Rollout.IdleCheckInterval,
+		"ROLLOUT_PLAN_CACHE_TTL":      &c.Rollout.PlanCacheTTL,
+		"ROLLOUT_QUERY_BUDGET_WINDOW": &c.Rollout.QueryBudgetWindow,
+		"SYNC_INTERVAL":               &c.Sync.Interval,
+	}
+	for name, field := range durations {
+		if value := os.Getenv(EnvPrefix + name); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil {
+				return fmt.Errorf("invalid %s%s: %w", EnvPrefix, name, err)
+			}
+			*field = Duration(parsed)
+		}
+	}
+
+	if value := os.Getenv(EnvPrefix + "ROLLOUT_POLL_JITTER"); value != "" {
+		jitter, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %sROLLOUT_POLL_JITTER: %w", EnvPrefix, err)
+		}
+		c.Rollout.PollJitter = jitter
+	}
+	if value := os.Getenv(EnvPrefix + "BANDWIDTH_LIMIT"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %sBANDWIDTH_LIMIT: %w", EnvPrefix, err)
+		}
+		c.BandwidthLimit = limit
+	}
+	if value := os.Getenv(EnvPrefix + "BANDWIDTH_CAP"); value != "" {
+		limit, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %sBANDWIDTH_CAP: %w", EnvPrefix, err)
+		}
+		c.BandwidthCap = limit
+	}
+	if value := os.Getenv(EnvPrefix + "BILLING_DAY"); value != "" {
+		day, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sBILLING_DAY: %w", EnvPrefix, err)
+		}
+		c.BillingDay = day
+	}
+	if value := os.Getenv(EnvPrefix + "SYNC_CRITICAL_TYPES"); value != "" {
+		c.Sync.CriticalTypes = strings.Split(value, ",")
+	}
+	if value := os.Getenv(EnvPrefix + "SYNC_UPLOAD_TYPES"); value != "" {
+		c.Sync.UploadTypes = strings.Split(value, ",")
+	}
+	if value := os.Getenv(EnvPrefix + "ROLLOUT_QUERY_BUDGET"); value != "" {
+		budget, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("invalid %sROLLOUT_QUERY_BUDGET: %w", EnvPrefix, err)
+		}
+		c.Rollout.QueryBudget = budget
+	}
+
+	// DEVICE_TAGS is a comma-separated list of key=value pairs
+	if value := os.Getenv(EnvPrefix + "DEVICE_TAGS"); value != "" {
+		c.DeviceTags = make(map[string]string)
+		for _, pair := range strings.Split(value, ",") {
+			key, tagValue, ok := strings.Cut(strings.TrimSpace(pair), "=")
+			if !ok || key == "" {
+				return fmt.Errorf("invalid %sDEVICE_TAGS entry %q", EnvPrefix, pair)
+			}
+			c.DeviceTags[key] = tagValue
+		}
+	}
+
+	return nil
+}
+
+// applyDefaults fills the paths and intervals left unset
+func (c *Config) applyDefaults() {
+	if c.DataDir == "" {
+		c.DataDir = "/var/lib/edge-agent"
+	}
+	if c.LogLevel == "" {
+		c.LogLevel = LevelInfo
+	}
+	if c.LogSink == "" {
+		c.LogSink = LogSinkStderr
+	}
+	if c.Rollout.UpdatePath == "" {
+		c.Rollout.UpdatePath = filepath.Join(c.DataDir, "updates")
+	}
+	if c.Rollout.CheckInterval == 0 {
+		c.Rollout.CheckInterval = Duration(5 * time.Minute)
+	}
+	if c.Sync.Interval == 0 {
+		c.Sync.Interval = Duration(15 * time.Minute)
+	}
+	if c.Sync.CachePath == "" {
+		c.Sync.CachePath = filepath.Join(c.DataDir, "cache")
+	}
+	if c.Sync.StorageBackend == "" {
+		c.Sync.StorageBackend = kvstore.BackendBadger
+	}
+	if c.Sync.BadgerPath == "" {
+		c.Sync.BadgerPath = filepath.Join(c.DataDir, "badger")
+	}
+	if c.Sync.BoltPath == "" {
+		c.Sync.BoltPath = filepath.Join(c.DataDir, "sync.db")
+	}
+}
@@ -0,0 +1,144 @@
+package agentconfig
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	logshipper "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/log-shipper"
+)
+
+// Log levels accepted by logLevel
+const (
+	LevelInfo  = "info"
+	LevelError = "error"
+)
+
+// Log sinks accepted by logSink
+const (
+	LogSinkStderr     = "stderr"
+	LogSinkCloudWatch = "cloudwatch"
+)
+
+// LevelLogger is the managers' Logger with a level that can change while
+// running. The managers log with Printf only, so a message is an error when
+// it reports a failure ("Failed to ...", "... failed: ...") and info otherwise.
+type LevelLogger struct {
+	logger    *log.Logger
+	errorOnly atomic.Bool
+	sink      io.Closer
+}
+
+// NewLevelLogger creates a LevelLogger writing to logger, or the standard logger when nil
+func NewLevelLogger(logger *log.Logger, level string) (*LevelLogger, error) {
+	if logger == nil {
+		logger = log.Default()
+	}
+
+	l := &LevelLogger{logger: logger}
+	if err := l.SetLevel(level); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// SetLevel changes the level; empty is info
+func (l *LevelLogger) SetLevel(level string) error {
+	errorOnly, err := ParseLevel(level)
+	if err != nil {
+		return err
+	}
+	l.errorOnly.Store(errorOnly)
+	return nil
+}
+
+// Printf logs the message unless the level is error and it isn't a failure
+func (l *LevelLogger) Printf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	if l.errorOnly.Load() && !isFailure(message) {
+		return
+	}
+	l.logger.Output(2, message)
+}
+
+// Writer returns where the logger writes, e.g. for log.SetOutput so the
+// standard logger reaches the same sink; it isn't filtered by level
+func (l *LevelLogger) Writer() io.Writer {
+	return l.logger.Writer()
+}
+
+// Close sends what the logger's sink still buffers and closes it; loggers
+// without a sink have nothing to close
+func (l *LevelLogger) Close() error {
+	if l.sink == nil {
+		return nil
+	}
+	return l.sink.Close()
+}
+
+// Logger creates the agent's LevelLogger for the configured logSink. With
+// cloudwatch, lines go to stderr and to a per-device CloudWatch Logs stream,
+// buffered under dataDir in the sync storage backend while offline; Close
+// the logger on shutdown.
+func (c *Config) Logger(ctx context.Context) (*LevelLogger, error) {
+	if c.LogSink != LogSinkCloudWatch {
+		return NewLevelLogger(nil, c.LogLevel)
+	}
+
+	awsConfig, err := c.loadAWSConfig(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	bufferPath := filepath.Join(c.DataDir, "logs")
+	if c.Sync.StorageBackend == kvstore.BackendBolt {
+		bufferPath = filepath.Join(c.DataDir, "logs.db")
+	}
+
+	sink, err := logshipper.NewCloudWatchSink(logshipper.CloudWatchSinkConfig{
+		Client:         cloudwatchlogs.NewFromConfig(awsConfig),
+		LogGroupName:   c.LogGroup,
+		DeviceID:       c.DeviceID,
+		TenantID:       c.TenantID,
+		StorageBackend: c.Sync.StorageBackend,
+		StoragePath:    bufferPath,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CloudWatch log sink: %w", err)
+	}
+
+	l, err := NewLevelLogger(log.New(io.MultiWriter(os.Stderr, sink), "", log.LstdFlags), c.LogLevel)
+	if err != nil {
+		sink.Close()
+		return nil, err
+	}
+	l.sink = sink
+	return l, nil
+}
+
+// ParseLevel validates a level, returning whether only errors are logged
+func ParseLevel(level string) (bool, error) {
+	switch strings.ToLower(level) {
+	case "", LevelInfo:
+		return false, nil
+	case LevelError:
+		return true, nil
+	default:
+		return false, fmt.Errorf("unknown logLevel %q", level)
+	}
+}
+
+// Helper functions
+
+func isFailure(message string) bool {
+	lower := strings.ToLower(message)
+	return strings.Contains(lower, "fail") || strings.Contains(lower, "error")
+}
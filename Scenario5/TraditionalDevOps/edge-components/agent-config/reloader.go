@@ -0,0 +1,217 @@
+package agentconfig
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"reflect"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// Targets are the running components a reload reconfigures; nil ones are skipped
+type Targets struct {
+	Rollout   *rollout.RolloutManager
+	Sync      *offlineSync.SyncManager
+	Bandwidth *bandwidth.Limiter
+	Meter     *bandwidth.Meter
+	Logger    *LevelLogger
+}
+
+// Apply sets the reloadable settings on the targets: rollout polling, the
+// sync interval and upload filter, the bandwidth limit and cap and the log level
+func (t Targets) Apply(cfg *Config) error {
+	if t.Rollout != nil {
+		t.Rollout.SetPolling(cfg.RolloutConfig(nil, nil))
+	}
+	if t.Sync != nil {
+		if err := t.Sync.SetSyncInterval(time.Duration(cfg.Sync.Interval)); err != nil {
+			return err
+		}
+		t.Sync.SetUploadFilter(cfg.Sync.UploadTypes)
+	}
+	if t.Bandwidth != nil {
+		t.Bandwidth.SetLimit(cfg.BandwidthLimit)
+	}
+	if t.Meter != nil {
+		t.Meter.SetCap(cfg.BandwidthCap)
+	}
+	if t.Logger != nil {
+		if err := t.Logger.SetLevel(cfg.LogLevel); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Reloader reloads the configuration file on SIGHUP or when it changes and
+// applies it to the running components. In-flight downloads and the
+// pending-change queue are kept; settings that need a restart, such as the
+// device ID, tables or paths, are logged and ignored until then.
+type Reloader struct {
+	path         string
+	current      *Config
+	targets      Targets
+	pollInterval time.Duration
+	modTime      time.Time
+	reloadMutex  sync.Mutex
+}
+
+// ReloaderConfig contains configuration for the Reloader
+type ReloaderConfig struct {
+	Path         string
+	Current      *Config // the configuration the agent started with
+	Targets      Targets
+	PollInterval time.Duration // how often the file is checked for changes; defaults to 10 seconds
+}
+
+// NewReloader creates a new Reloader
+func NewReloader(config ReloaderConfig) (*Reloader, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("config path is required")
+	}
+	if config.Current == nil {
+		return nil, fmt.Errorf("current config is required")
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = 10 * time.Second
+	}
+
+	r := &Reloader{
+		path:         config.Path,
+		current:      config.Current,
+		targets:      config.Targets,
+		pollInterval: config.PollInterval,
+	}
+	if info, err := os.Stat(config.Path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	return r, nil
+}
+
+// Run reloads on SIGHUP and file changes until ctx is done
+func (r *Reloader) Run(ctx context.Context) {
+	hangups := make(chan os.Signal, 1)
+	signal.Notify(hangups, syscall.SIGHUP)
+	defer signal.Stop(hangups)
+
+	ticker := time.NewTicker(r.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangups:
+			log.Printf("Received SIGHUP; reloading %s", r.path)
+		case <-ticker.C:
+			info, err := os.Stat(r.path)
+			if err != nil || info.ModTime().Equal(r.modTime) {
+				continue
+			}
+			log.Printf("%s changed; reloading", r.path)
+		}
+
+		if err := r.Reload(); err != nil {
+			log.Printf("Failed to reload agent config: %v", err)
+		}
+	}
+}
+
+// Reload loads the file and applies its reloadable settings; an invalid file
+// leaves the running configuration in place
+func (r *Reloader) Reload() error {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+
+	next, err := Load(r.path)
+	if err != nil {
+		return err
+	}
+
+	for _, setting := range restartRequired(r.current, next) {
+		log.Printf("Agent config %s changed; the change applies after a restart", setting)
+	}
+
+	if err := r.targets.Apply(next); err != nil {
+		return fmt.Errorf("failed to apply agent config: %w", err)
+	}
+
+	r.current = mergeReloadable(r.current, next)
+	log.Printf("Reloaded agent config from %s", r.path)
+	return nil
+}
+
+// Current returns the configuration in effect
+func (r *Reloader) Current() *Config {
+	r.reloadMutex.Lock()
+	defer r.reloadMutex.Unlock()
+	return r.current
+}
+
+// Helper functions
+
+// restartRequired lists the changed settings a reload can't apply
+func restartRequired(current, next *Config) []string {
+	var changed []string
+	check := func(name string, a, b interface{}) {
+		if !reflect.DeepEqual(a, b) {
+			changed = append(changed, name)
+		}
+	}
+
+	check("deviceId", current.DeviceID, next.DeviceID)
+	check("tenantId", current.TenantID, next.TenantID)
+	check("deviceGroup", current.DeviceGroup, next.DeviceGroup)
+	check("deviceTags", current.DeviceTags, next.DeviceTags)
+	check("dataDir", current.DataDir, next.DataDir)
+	check("logSink", current.LogSink, next.LogSink)
+	check("logGroup", current.LogGroup, next.LogGroup)
+	check("billingDay", current.BillingDay, next.BillingDay)
+	check("aws", current.AWS, next.AWS)
+	check("rollout.rolloutTable", current.Rollout.RolloutTable, next.Rollout.RolloutTable)
+	check("rollout.deviceTable", current.Rollout.DeviceTable, next.Rollout.DeviceTable)
+	check("rollout.artifactTable", current.Rollout.ArtifactTable, next.Rollout.ArtifactTable)
+	check("rollout.updatePath", current.Rollout.UpdatePath, next.Rollout.UpdatePath)
+	check("sync.bucket", current.Sync.Bucket, next.Sync.Bucket)
+	check("sync.proxyUrl", current.Sync.ProxyURL, next.Sync.ProxyURL)
+	check("sync.cachePath", current.Sync.CachePath, next.Sync.CachePath)
+	check("sync.storageBackend", current.Sync.StorageBackend, next.Sync.StorageBackend)
+	check("sync.badgerPath", current.Sync.BadgerPath, next.Sync.BadgerPath)
+	check("sync.boltPath", current.Sync.BoltPath, next.Sync.BoltPath)
+	check("sync.criticalTypes", current.Sync.CriticalTypes, next.Sync.CriticalTypes)
+
+	return changed
+}
+
+// mergeReloadable returns current with the reloadable settings of next
+func mergeReloadable(current, next *Config) *Config {
+	merged := *current
+	merged.LogLevel = next.LogLevel
+	merged.BandwidthLimit = next.BandwidthLimit
+	merged.BandwidthCap = next.BandwidthCap
+
+	rolloutSection := next.Rollout
+	rolloutSection.RolloutTable = current.Rollout.RolloutTable
+	rolloutSection.DeviceTable = current.Rollout.DeviceTable
+	rolloutSection.ArtifactTable = current.Rollout.ArtifactTable
+	rolloutSection.UpdatePath = current.Rollout.UpdatePath
+	merged.Rollout = rolloutSection
+
+	merged.Sync.Interval = next.Sync.Interval
+	merged.Sync.UploadTypes = next.Sync.UploadTypes
+
+	return &merged
+}
@@ -0,0 +1,120 @@
+package agentproto
+
+// AgentMessage is sent from an edge agent to the fleet server; exactly one field is set
+type AgentMessage struct {
+	Hello     *Hello        `json:"hello,omitempty"`
+	Heartbeat *Heartbeat    `json:"heartbeat,omitempty"`
+	Status    *UpdateStatus `json:"status,omitempty"`
+	Metrics   *Metrics      `json:"metrics,omitempty"`
+	Sync      *SyncEvent    `json:"sync,omitempty"`
+}
+
+// ServerMessage is sent from the fleet server to an edge agent; exactly one field is set
+type ServerMessage struct {
+	Command *Command `json:"command,omitempty"`
+	Ack     *Ack     `json:"ack,omitempty"`
+}
+
+// Hello identifies the agent and must be the first message on a stream
+type Hello struct {
+	DeviceID          string            `json:"device_id"`
+	TenantID          string            `json:"tenant_id"`
+	DeviceGroup       string            `json:"device_group"`
+	Region            string            `json:"region"`
+	Timezone          string            `json:"timezone,omitempty"`           // IANA name; rollouts' business hours apply in it
+	MaintenanceWindow string            `json:"maintenance_window,omitempty"` // "HH:MM-HH:MM" local time the device accepts updates in
+	Tags              map[string]string `json:"tags"`
+	CurrentVersion    string            `json:"current_version"`
+	AgentVersion      string            `json:"agent_version"`
+	ProxyID           string            `json:"proxy_id,omitempty"` // gateway device relaying the stream, if any
+	ConfigVersion     string            `json:"config_version,omitempty"`
+
+	// Handlers, package formats and schema versions the agent supports;
+	// rollouts it can't install are not offered. Empty for older agents.
+	Handlers       []string       `json:"handlers,omitempty"`
+	PackageFormats []string       `json:"package_formats,omitempty"`
+	SchemaVersions map[string]int `json:"schema_versions,omitempty"`
+
+	// Architecture is the device's CPU architecture, e.g. arm64; the server
+	// sends it the matching package of multi-architecture rollouts
+	Architecture string `json:"architecture,omitempty"`
+}
+
+// Heartbeat reports liveness and overall health
+type Heartbeat struct {
+	TimestampUnix int64 `json:"timestamp_unix"`
+	Healthy       bool  `json:"healthy"`
+}
+
+// UpdateStatus reports the progress of a command
+type UpdateStatus struct {
+	CommandID       string `json:"command_id"`
+	RolloutID       string `json:"rollout_id"`
+	Version         string `json:"version"`
+	Status          string `json:"status"`
+	Message         string `json:"message"`
+	BytesDownloaded int64  `json:"bytes_downloaded,omitempty"` // package bytes fetched for the command, sent with final statuses
+	Config          bool   `json:"config,omitempty"`           // status of an apply-config command; Version is a config version
+	Sequence        int64  `json:"sequence,omitempty"`         // increases with every report; stale reports are not stored
+
+	// Attestation is the JSON TPM quote bound to this report, or
+	// AttestationError why the agent couldn't quote it
+	Attestation      string `json:"attestation,omitempty"`
+	AttestationError string `json:"attestation_error,omitempty"`
+}
+
+// Metrics carries "name=value" telemetry
+type Metrics struct {
+	Values []string `json:"values"`
+}
+
+// SyncEvent reports the outcome of an offline sync
+type SyncEvent struct {
+	Status         string `json:"status"`
+	PendingChanges int    `json:"pending_changes"`
+	Message        string `json:"message"`
+}
+
+// Command instructs the agent to act
+type Command struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	RolloutID     string `json:"rollout_id"`
+	Version       string `json:"version"`
+	PackageURL    string `json:"package_url"`
+	PackageHash   string `json:"package_hash"`
+	ConfigPayload string `json:"config_payload,omitempty"`
+}
+
+// Ack acknowledges agent messages that need no command in response
+type Ack struct {
+	Message string `json:"message"`
+}
+
+// Command types
+const (
+	CommandApplyUpdate = "apply-update"
+	CommandApplyConfig = "apply-config"
+	CommandRollback    = "rollback"
+	CommandAbort       = "abort" // cancels the in-flight update for RolloutID
+)
+
+// Update statuses reported by agents
+const (
+	StatusDownloading = "downloading"
+	StatusApplying    = "applying"
+	StatusSuccess     = "success"
+	StatusFailed      = "failed"
+	StatusRolledBack  = "rolled-back"
+	StatusAborted     = "aborted"
+
+	// StatusIntegrityFailed means the agent refused the update because its
+	// boot chain failed verification; nothing was applied
+	StatusIntegrityFailed = "integrity-failed"
+)
+
+// Sync statuses reported by agents
+const (
+	SyncSucceeded = "succeeded"
+	SyncFailed    = "failed"
+)
@@ -0,0 +1,100 @@
+package agentproto
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// ServiceName is the fully qualified gRPC service name from agent.proto
+const ServiceName = "edge.agent.v1.FleetAgent"
+
+// FleetAgentServer is implemented by the fleet server
+type FleetAgentServer interface {
+	// Connect serves one agent stream until it ends
+	Connect(stream FleetAgentConnectServer) error
+}
+
+// FleetAgentConnectServer is the server side of a Connect stream
+type FleetAgentConnectServer interface {
+	Send(*ServerMessage) error
+	Recv() (*AgentMessage, error)
+	grpc.ServerStream
+}
+
+// FleetAgentConnectClient is the agent side of a Connect stream
+type FleetAgentConnectClient interface {
+	Send(*AgentMessage) error
+	Recv() (*ServerMessage, error)
+	grpc.ClientStream
+}
+
+// ServiceDesc describes the FleetAgent service for grpc.Server.RegisterService
+var ServiceDesc = grpc.ServiceDesc{
+	ServiceName: ServiceName,
+	HandlerType: (*FleetAgentServer)(nil),
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "Connect",
+			Handler:       connectHandler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
+	Metadata: "agent.proto",
+}
+
+// RegisterFleetAgentServer registers a FleetAgentServer with a gRPC server
+func RegisterFleetAgentServer(s *grpc.Server, srv FleetAgentServer) {
+	s.RegisterService(&ServiceDesc, srv)
+}
+
+// NewConnectStream opens a Connect stream using the JSON codec
+func NewConnectStream(ctx context.Context, conn *grpc.ClientConn, opts ...grpc.CallOption) (FleetAgentConnectClient, error) {
+	opts = append([]grpc.CallOption{grpc.CallContentSubtype(CodecName)}, opts...)
+
+	stream, err := conn.NewStream(ctx, &ServiceDesc.Streams[0], "/"+ServiceName+"/Connect", opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	return &connectClient{stream}, nil
+}
+
+func connectHandler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(FleetAgentServer).Connect(&connectServer{stream})
+}
+
+// connectServer adapts a grpc.ServerStream to FleetAgentConnectServer
+type connectServer struct {
+	grpc.ServerStream
+}
+
+func (s *connectServer) Send(message *ServerMessage) error {
+	return s.ServerStream.SendMsg(message)
+}
+
+func (s *connectServer) Recv() (*AgentMessage, error) {
+	message := new(AgentMessage)
+	if err := s.ServerStream.RecvMsg(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
+
+// connectClient adapts a grpc.ClientStream to FleetAgentConnectClient
+type connectClient struct {
+	grpc.ClientStream
+}
+
+func (c *connectClient) Send(message *AgentMessage) error {
+	return c.ClientStream.SendMsg(message)
+}
+
+func (c *connectClient) Recv() (*ServerMessage, error) {
+	message := new(ServerMessage)
+	if err := c.ClientStream.RecvMsg(message); err != nil {
+		return nil, err
+	}
+	return message, nil
+}
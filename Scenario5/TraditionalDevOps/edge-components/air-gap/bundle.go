@@ -0,0 +1,470 @@
+package airgap
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// FormatVersion is the bundle layout this package writes and reads
+const FormatVersion = 1
+
+// Paths inside a bundle
+const (
+	manifestPath = "manifest.json"
+	packagesDir  = "packages"
+)
+
+// ErrInvalidBundle means a bundle is malformed or its contents don't match its manifest
+var ErrInvalidBundle = errors.New("invalid bundle")
+
+// Manifest describes a bundle: the rollout plan as signed, the registry
+// artifact it resolved to, and the packages the bundle carries
+type Manifest struct {
+	FormatVersion int                 `json:"formatVersion"`
+	Plan          rollout.RolloutPlan `json:"plan"`
+	Artifact      *publisher.Artifact `json:"artifact,omitempty"` // set when the plan references a registry artifact
+	Packages      []PackageEntry      `json:"packages,omitempty"`
+	ExportedAt    time.Time           `json:"exportedAt"`
+	ExportedBy    string              `json:"exportedBy"`
+}
+
+// PackageEntry is a package carried in a bundle under packages/<hash>
+type PackageEntry struct {
+	SHA256 string `json:"sha256"`
+	Size   int64  `json:"size"`
+	Source string `json:"source"` // where it was exported from
+}
+
+// PackageHash returns the hash of the package the plan installs, or "" for a config-only rollout
+func (m Manifest) PackageHash() string {
+	if m.Artifact != nil {
+		return m.Artifact.SHA256
+	}
+	return m.Plan.PackageHash
+}
+
+// Exporter writes rollouts into portable bundles for fleets without cloud access
+type Exporter struct {
+	dynamoClient      *dynamodb.Client
+	s3Client          *s3.Client
+	rolloutTableName  string
+	artifactTableName string
+	httpClient        *http.Client
+}
+
+// ExporterConfig contains configuration for the Exporter
+type ExporterConfig struct {
+	DynamoClient      *dynamodb.Client
+	S3Client          *s3.Client
+	RolloutTableName  string
+	ArtifactTableName string
+	HTTPClient        *http.Client // for packages at http(s) URLs; defaults to a 30 minute timeout
+}
+
+// NewExporter creates a new Exporter
+func NewExporter(config ExporterConfig) *Exporter {
+	e := &Exporter{
+		dynamoClient:      config.DynamoClient,
+		s3Client:          config.S3Client,
+		rolloutTableName:  config.RolloutTableName,
+		artifactTableName: config.ArtifactTableName,
+		httpClient:        config.HTTPClient,
+	}
+	if e.httpClient == nil {
+		e.httpClient = &http.Client{Timeout: 30 * time.Minute}
+	}
+
+	return e
+}
+
+// Export writes a rollout, its registry artifact and its package to w as a
+// gzipped tar. The package is hashed as it is copied, so a bundle never
+// carries bytes other than the ones the plan was signed for.
+func (e *Exporter) Export(ctx context.Context, rolloutID, exportedBy string, w io.Writer) (*Manifest, error) {
+	plan, err := e.getRollout(ctx, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if len(plan.Artifacts) > 0 {
+		return nil, fmt.Errorf("rollout %s is a bundle of %d artifacts; air-gapped rollouts carry one package", rolloutID, len(plan.Artifacts))
+	}
+	if plan.IsMultiArch() {
+		return nil, fmt.Errorf("rollout %s has %d architecture packages; air-gapped rollouts carry one package", rolloutID, len(plan.Packages))
+	}
+
+	manifest := &Manifest{
+		FormatVersion: FormatVersion,
+		Plan:          *plan,
+		ExportedAt:    time.Now().UTC(),
+		ExportedBy:    exportedBy,
+	}
+
+	packageURL, packageHash := plan.PackageURL, plan.PackageHash
+	if plan.ArtifactName != "" {
+		artifact, err := publisher.Resolve(ctx, e.dynamoClient, e.artifactTableName, tenant.Key(plan.TenantID, plan.ArtifactName), plan.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve artifact: %w", err)
+		}
+		manifest.Artifact = artifact
+		packageURL, packageHash = artifact.URL, artifact.SHA256
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	if !plan.IsConfigOnly() {
+		entry, err := e.writePackage(ctx, tw, packageURL, packageHash)
+		if err != nil {
+			return nil, err
+		}
+		manifest.Packages = append(manifest.Packages, *entry)
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	if err := writeEntry(tw, manifestPath, int64(len(data)), strings.NewReader(string(data))); err != nil {
+		return nil, err
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return nil, fmt.Errorf("failed to finish bundle: %w", err)
+	}
+
+	return manifest, nil
+}
+
+// getRollout reads a rollout plan from the rollout table
+func (e *Exporter) getRollout(ctx context.Context, rolloutID string) (*rollout.RolloutPlan, error) {
+	result, err := e.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(e.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rollout: %w", err)
+	}
+	if result.Item == nil {
+		return nil, fmt.Errorf("rollout not found: %s", rolloutID)
+	}
+
+	var plan rollout.RolloutPlan
+	if err := attributevalue.UnmarshalMap(result.Item, &plan); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rollout: %w", err)
+	}
+
+	return &plan, nil
+}
+
+// writePackage downloads a package into the bundle, checking it against its hash.
+// The tar header needs the size up front, so the package is staged in a temporary file.
+func (e *Exporter) writePackage(ctx context.Context, tw *tar.Writer, packageURL, packageHash string) (*PackageEntry, error) {
+	body, err := e.open(ctx, packageURL)
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	staged, err := stagingFile()
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(staged.Name())
+	defer staged.Close()
+
+	hasher := sha256.New()
+	size, err := io.Copy(io.MultiWriter(staged, hasher), body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download package: %w", err)
+	}
+	if actual := hex.EncodeToString(hasher.Sum(nil)); actual != packageHash {
+		return nil, fmt.Errorf("%w: expected %s, got %s", rollout.ErrHashMismatch, packageHash, actual)
+	}
+
+	if _, err := staged.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to read staged package: %w", err)
+	}
+	if err := writeEntry(tw, packagesDir+"/"+packageHash, size, staged); err != nil {
+		return nil, err
+	}
+
+	return &PackageEntry{SHA256: packageHash, Size: size, Source: packageURL}, nil
+}
+
+// open returns the body of a package at an s3:// or http(s) URL
+func (e *Exporter) open(ctx context.Context, packageURL string) (io.ReadCloser, error) {
+	if strings.HasPrefix(packageURL, "s3://") {
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(packageURL, "s3://"), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid S3 URL format: %s", packageURL)
+		}
+
+		result, err := e.s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to download package: %w", err)
+		}
+		return result.Body, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, packageURL, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download package: %w", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, fmt.Errorf("failed to download package: %s", resp.Status)
+	}
+
+	return resp.Body, nil
+}
+
+// Import unpacks a bundle into dir, checking every package against the
+// manifest and, when verifier is set, the plan's and artifact's signatures.
+// The manifest is written last, so a dir with one holds a complete bundle.
+func Import(r io.Reader, dir string, verifier rollout.SignatureVerifier) (*Manifest, error) {
+	if err := os.MkdirAll(filepath.Join(dir, packagesDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create bundle directory: %w", err)
+	}
+
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+	}
+	defer gz.Close()
+
+	var manifestData []byte
+	hashes := make(map[string]string) // package path -> SHA-256 of its contents
+
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrInvalidBundle, err)
+		}
+
+		switch {
+		case header.Name == manifestPath:
+			manifestData, err = io.ReadAll(io.LimitReader(tr, 16<<20))
+			if err != nil {
+				return nil, fmt.Errorf("failed to read manifest: %w", err)
+			}
+
+		case strings.HasPrefix(header.Name, packagesDir+"/"):
+			hash := strings.TrimPrefix(header.Name, packagesDir+"/")
+			if !isHash(hash) {
+				return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidBundle, header.Name)
+			}
+			actual, err := extract(tr, filepath.Join(dir, packagesDir, hash))
+			if err != nil {
+				return nil, err
+			}
+			hashes[hash] = actual
+
+		default:
+			return nil, fmt.Errorf("%w: unexpected entry %s", ErrInvalidBundle, header.Name)
+		}
+	}
+
+	if manifestData == nil {
+		return nil, fmt.Errorf("%w: no manifest", ErrInvalidBundle)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(manifestData, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrInvalidBundle, err)
+	}
+	if manifest.FormatVersion != FormatVersion {
+		return nil, fmt.Errorf("%w: format version %d, expected %d", ErrInvalidBundle, manifest.FormatVersion, FormatVersion)
+	}
+
+	for _, entry := range manifest.Packages {
+		actual, ok := hashes[entry.SHA256]
+		if !ok {
+			return nil, fmt.Errorf("%w: package %s is missing", ErrInvalidBundle, entry.SHA256)
+		}
+		if actual != entry.SHA256 {
+			return nil, fmt.Errorf("%w: expected %s, got %s", rollout.ErrHashMismatch, entry.SHA256, actual)
+		}
+	}
+	if hash := manifest.PackageHash(); !manifest.Plan.IsConfigOnly() && hashes[hash] == "" {
+		return nil, fmt.Errorf("%w: package %s is missing", ErrInvalidBundle, hash)
+	}
+
+	if verifier != nil {
+		if err := verifyManifest(&manifest, verifier); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := writeManifest(dir, manifestData); err != nil {
+		return nil, err
+	}
+
+	return &manifest, nil
+}
+
+// LoadManifest reads the manifest of a bundle imported into dir
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, manifestPath))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read manifest: %w", err)
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("%w: unreadable manifest: %v", ErrInvalidBundle, err)
+	}
+
+	return &manifest, nil
+}
+
+// verifyManifest checks the plan's signature and, for registry artifacts, the artifact's
+func verifyManifest(manifest *Manifest, verifier rollout.SignatureVerifier) error {
+	plan := manifest.Plan
+	if plan.Signature == "" {
+		return fmt.Errorf("rollout %s is not signed", plan.ID)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(plan.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode rollout signature: %w", err)
+	}
+	if err := verifier.Verify(plan.SigningKeyID, plan.SigningPayload(), signature); err != nil {
+		return fmt.Errorf("rollout %s signature verification failed: %w", plan.ID, err)
+	}
+
+	artifact := manifest.Artifact
+	if artifact == nil {
+		return nil
+	}
+	if artifact.Signature == "" {
+		return fmt.Errorf("artifact %s@%s is not signed", artifact.Name, artifact.Version)
+	}
+
+	digest, err := hex.DecodeString(artifact.SHA256)
+	if err != nil {
+		return fmt.Errorf("invalid artifact digest: %w", err)
+	}
+	signature, err = base64.StdEncoding.DecodeString(artifact.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode artifact signature: %w", err)
+	}
+
+	// The publisher signs the raw SHA-256 digest
+	if err := verifier.Verify(artifact.KeyID, digest, signature); err != nil {
+		return fmt.Errorf("artifact %s@%s signature verification failed: %w", artifact.Name, artifact.Version, err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// writeEntry adds a regular file to a bundle
+func writeEntry(tw *tar.Writer, name string, size int64, r io.Reader) error {
+	header := &tar.Header{
+		Name:    name,
+		Mode:    0644,
+		Size:    size,
+		ModTime: time.Now(),
+	}
+	if err := tw.WriteHeader(header); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if _, err := io.Copy(tw, r); err != nil {
+		return fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	return nil
+}
+
+// extract writes a bundle entry to path and returns the SHA-256 of what was written
+func extract(r io.Reader, path string) (string, error) {
+	tempPath := path + ".partial"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+
+	hasher := sha256.New()
+	_, err = io.Copy(io.MultiWriter(file, hasher), r)
+	file.Close()
+	if err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write package file: %w", err)
+	}
+
+	if err := os.Rename(tempPath, path); err != nil {
+		return "", fmt.Errorf("failed to write package file: %w", err)
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// writeManifest atomically writes the manifest of an imported bundle
+func writeManifest(dir string, data []byte) error {
+	tempPath := filepath.Join(dir, manifestPath+".tmp")
+	if err := os.WriteFile(tempPath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	if err := os.Rename(tempPath, filepath.Join(dir, manifestPath)); err != nil {
+		return fmt.Errorf("failed to write manifest: %w", err)
+	}
+	return nil
+}
+
+// stagingFile creates the file a package is staged in during export
+func stagingFile() (*os.File, error) {
+	file, err := os.CreateTemp("", "airgap-package-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create staging file: %w", err)
+	}
+	return file, nil
+}
+
+// isHash reports whether s is a hex SHA-256 digest, so it is safe as a file name
+func isHash(s string) bool {
+	if len(s) != sha256.Size*2 {
+		return false
+	}
+	_, err := hex.DecodeString(s)
+	return err == nil
+}
@@ -0,0 +1,370 @@
+package airgap
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// StatusRecord is a status report a device sent to the local gateway
+type StatusRecord struct {
+	DeviceID    string    `json:"deviceId"`
+	TenantID    string    `json:"tenantId,omitempty"`
+	DeviceGroup string    `json:"deviceGroup,omitempty"`
+	Region      string    `json:"region,omitempty"`
+	RolloutID   string    `json:"rolloutId"`
+	Version     string    `json:"version"`
+	Config      bool      `json:"config,omitempty"`
+	Status      string    `json:"status"`
+	Message     string    `json:"message,omitempty"`
+	Sequence    int64     `json:"sequence,omitempty"`
+	ReportedAt  time.Time `json:"reportedAt"`
+}
+
+// StatusReport is what a local gateway collected, exported for upload to the fleet server
+type StatusReport struct {
+	GatewayID  string         `json:"gatewayId"`
+	RolloutID  string         `json:"rolloutId"`
+	Version    string         `json:"version"`
+	ExportedAt time.Time      `json:"exportedAt"`
+	Statuses   []StatusRecord `json:"statuses"` // each device's latest report, by device ID
+}
+
+// LocalGateway applies an imported bundle to a fleet with no cloud access. It
+// serves the FleetAgent gRPC service to GRPCAgents on the local network,
+// sends the bundle's rollout to each targeted device that isn't on its
+// version yet, serves the package from the bundle and appends every status
+// report to a local log. Phases don't apply: every targeted device that
+// connects is updated. Devices are identified by their mTLS client certificate.
+type LocalGateway struct {
+	gatewayID  string
+	tenantID   string
+	bundleDir  string
+	publicURL  string
+	statusPath string
+	manifest   *Manifest
+	latest     map[string]StatusRecord // device ID -> latest report
+	mutex      sync.Mutex
+}
+
+// LocalGatewayConfig contains configuration for the LocalGateway
+type LocalGatewayConfig struct {
+	GatewayID  string // identifies the site in exported status reports
+	TenantID   string
+	BundleDir  string // a directory a bundle was imported into
+	PublicURL  string // base URL devices use to reach Handler
+	StatusPath string // defaults to status.jsonl in BundleDir
+}
+
+// NewLocalGateway creates a new LocalGateway for the bundle imported into
+// BundleDir; register it with a gRPC server using
+// agentproto.RegisterFleetAgentServer and serve Handler over HTTPS
+func NewLocalGateway(config LocalGatewayConfig) (*LocalGateway, error) {
+	manifest, err := LoadManifest(config.BundleDir)
+	if err != nil {
+		return nil, err
+	}
+	if manifest.Plan.TenantID != config.TenantID {
+		return nil, fmt.Errorf("bundle is for tenant %q, not %q", manifest.Plan.TenantID, config.TenantID)
+	}
+
+	g := &LocalGateway{
+		gatewayID:  config.GatewayID,
+		tenantID:   config.TenantID,
+		bundleDir:  config.BundleDir,
+		publicURL:  strings.TrimSuffix(config.PublicURL, "/"),
+		statusPath: config.StatusPath,
+		manifest:   manifest,
+		latest:     make(map[string]StatusRecord),
+	}
+	if g.statusPath == "" {
+		g.statusPath = filepath.Join(config.BundleDir, "status.jsonl")
+	}
+
+	// Reports from before a restart still count
+	records, err := ReadStatusLog(g.statusPath)
+	if err != nil {
+		return nil, err
+	}
+	for _, record := range records {
+		g.remember(record)
+	}
+
+	return g, nil
+}
+
+// Connect implements agentproto.FleetAgentServer, serving one device's stream
+func (g *LocalGateway) Connect(stream agentproto.FleetAgentConnectServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Hello == nil || first.Hello.DeviceID == "" {
+		return status.Error(codes.InvalidArgument, "first message must be a hello with a device ID")
+	}
+
+	hello := *first.Hello
+	identity, err := rollout.PeerDeviceID(stream.Context())
+	if err != nil {
+		return err
+	}
+	if identity != hello.DeviceID {
+		return status.Errorf(codes.PermissionDenied, "certificate identity %q does not match device %q", identity, hello.DeviceID)
+	}
+	if hello.TenantID != g.tenantID {
+		return status.Errorf(codes.PermissionDenied, "device is not in tenant %q", g.tenantID)
+	}
+
+	log.Printf("Device %s connected (version %s)", hello.DeviceID, hello.CurrentVersion)
+
+	if command := g.commandFor(hello); command != nil {
+		if err := stream.Send(&agentproto.ServerMessage{Command: command}); err != nil {
+			return err
+		}
+		log.Printf("Sent rollout %s to %s", command.RolloutID, hello.DeviceID)
+	}
+
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+
+		if message.Status == nil {
+			continue
+		}
+		if err := g.record(hello, message.Status); err != nil {
+			log.Printf("Failed to record status from %s: %v", hello.DeviceID, err)
+		}
+	}
+}
+
+// commandFor returns the command that moves a device to the bundle's
+// version, or nil when the rollout doesn't target it or it is already there
+func (g *LocalGateway) commandFor(hello agentproto.Hello) *agentproto.Command {
+	plan := g.manifest.Plan
+	if !targetsGroup(plan.TargetGroups, hello.DeviceGroup) || !plan.TargetsRegion(hello.Region) {
+		return nil
+	}
+
+	// A device whose update failed isn't sent it again on every reconnect
+	g.mutex.Lock()
+	last, ok := g.latest[hello.DeviceID]
+	g.mutex.Unlock()
+	if ok && last.RolloutID == plan.ID && last.Status != agentproto.StatusSuccess && finalStatus(last.Status) {
+		return nil
+	}
+
+	if plan.IsConfigOnly() {
+		if hello.ConfigVersion == plan.Version {
+			return nil
+		}
+		return &agentproto.Command{
+			ID:            uuid.New().String(),
+			Type:          agentproto.CommandApplyConfig,
+			RolloutID:     plan.ID,
+			Version:       plan.Version,
+			ConfigPayload: plan.ConfigPayload,
+		}
+	}
+
+	if hello.CurrentVersion == plan.Version {
+		return nil
+	}
+
+	hash := g.manifest.PackageHash()
+	return &agentproto.Command{
+		ID:          uuid.New().String(),
+		Type:        agentproto.CommandApplyUpdate,
+		RolloutID:   plan.ID,
+		Version:     plan.Version,
+		PackageURL:  g.publicURL + "/packages/" + hash,
+		PackageHash: hash,
+	}
+}
+
+// record appends a status report to the status log
+func (g *LocalGateway) record(hello agentproto.Hello, update *agentproto.UpdateStatus) error {
+	record := StatusRecord{
+		DeviceID:    hello.DeviceID,
+		TenantID:    hello.TenantID,
+		DeviceGroup: hello.DeviceGroup,
+		Region:      hello.Region,
+		RolloutID:   update.RolloutID,
+		Version:     update.Version,
+		Config:      update.Config,
+		Status:      update.Status,
+		Message:     update.Message,
+		Sequence:    update.Sequence,
+		ReportedAt:  time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	file, err := os.OpenFile(g.statusPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open status log: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write status log: %w", err)
+	}
+
+	g.rememberLocked(record)
+	if finalStatus(record.Status) {
+		log.Printf("Device %s reported %s for rollout %s", record.DeviceID, record.Status, record.RolloutID)
+	}
+
+	return nil
+}
+
+// Report returns each device's latest status report
+func (g *LocalGateway) Report() StatusReport {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+
+	report := StatusReport{
+		GatewayID:  g.gatewayID,
+		RolloutID:  g.manifest.Plan.ID,
+		Version:    g.manifest.Plan.Version,
+		ExportedAt: time.Now().UTC(),
+		Statuses:   make([]StatusRecord, 0, len(g.latest)),
+	}
+	for _, record := range g.latest {
+		report.Statuses = append(report.Statuses, record)
+	}
+	sort.Slice(report.Statuses, func(i, j int) bool {
+		return report.Statuses[i].DeviceID < report.Statuses[j].DeviceID
+	})
+
+	return report
+}
+
+// ExportStatus writes the status report to w for carrying back to a connected network
+func (g *LocalGateway) ExportStatus(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(g.Report()); err != nil {
+		return fmt.Errorf("failed to write status report: %w", err)
+	}
+	return nil
+}
+
+// Handler serves the bundle's package and the status report to devices and operators
+func (g *LocalGateway) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /packages/{hash}", g.handlePackage)
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := g.ExportStatus(w); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+	})
+	return mux
+}
+
+// handlePackage serves the bundle's package to a device
+func (g *LocalGateway) handlePackage(w http.ResponseWriter, r *http.Request) {
+	if _, err := rollout.RequestDeviceID(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	hash := r.PathValue("hash")
+	if hash != g.manifest.PackageHash() {
+		http.Error(w, "unknown package", http.StatusNotFound)
+		return
+	}
+
+	http.ServeFile(w, r, filepath.Join(g.bundleDir, packagesDir, hash))
+}
+
+// remember keeps a report when it is a device's latest
+func (g *LocalGateway) remember(record StatusRecord) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	g.rememberLocked(record)
+}
+
+// rememberLocked is remember with the mutex held
+func (g *LocalGateway) rememberLocked(record StatusRecord) {
+	if last, ok := g.latest[record.DeviceID]; ok && record.Sequence != 0 && record.Sequence < last.Sequence {
+		return
+	}
+	g.latest[record.DeviceID] = record
+}
+
+// ReadStatusLog reads a local gateway's status log; a missing log is empty
+func ReadStatusLog(path string) ([]StatusRecord, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open status log: %w", err)
+	}
+	defer file.Close()
+
+	var records []StatusRecord
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var record StatusRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// A line cut short by a crash is skipped
+			continue
+		}
+		records = append(records, record)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read status log: %w", err)
+	}
+
+	return records, nil
+}
+
+// Helper functions
+
+// targetsGroup reports whether a device group is one of the rollout's target groups
+func targetsGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group || g == "all" {
+			return true
+		}
+	}
+	return false
+}
+
+// finalStatus reports whether a status ends a command
+func finalStatus(s string) bool {
+	switch s {
+	case agentproto.StatusSuccess, agentproto.StatusFailed, agentproto.StatusRolledBack, agentproto.StatusAborted, agentproto.StatusIntegrityFailed:
+		return true
+	}
+	return false
+}
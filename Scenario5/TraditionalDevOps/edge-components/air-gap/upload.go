@@ -0,0 +1,77 @@
+package airgap
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// UploadResult counts what an upload did with each status in a report
+type UploadResult struct {
+	Updated int      // written to the device table
+	Stale   int      // older than what the device table already had
+	Unknown []string // devices not registered with the fleet
+}
+
+// UploadStatus writes a status report collected by a local gateway to the
+// device table, as if the devices had reported to the fleet server. A status
+// only replaces one that is older, so uploading a report twice, or after the
+// device reconnected, changes nothing.
+func UploadStatus(ctx context.Context, client *dynamodb.Client, tableName string, report StatusReport) (*UploadResult, error) {
+	result := &UploadResult{}
+
+	for _, record := range report.Statuses {
+		expression := "SET UpdateStatus = :status, LastUpdateID = :rolloutID, LastUpdateTime = :time, LastUpdateMessage = :message"
+		values := map[string]types.AttributeValue{
+			":status":    &types.AttributeValueMemberS{Value: record.Status},
+			":rolloutID": &types.AttributeValueMemberS{Value: record.RolloutID},
+			":time":      &types.AttributeValueMemberS{Value: record.ReportedAt.UTC().Format(time.RFC3339)},
+			":message":   &types.AttributeValueMemberS{Value: record.Message},
+		}
+		if record.Status == agentproto.StatusSuccess {
+			if record.Config {
+				expression += ", ConfigVersion = :version"
+			} else {
+				expression += ", CurrentVersion = :version"
+			}
+			values[":version"] = &types.AttributeValueMemberS{Value: record.Version}
+		}
+
+		deviceKey := tenant.Key(record.TenantID, record.DeviceID)
+		_, err := client.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+			TableName: aws.String(tableName),
+			Key: map[string]types.AttributeValue{
+				"DeviceID": &types.AttributeValueMemberS{Value: deviceKey},
+			},
+			UpdateExpression:                    aws.String(expression),
+			ConditionExpression:                 aws.String("attribute_exists(DeviceID) AND (attribute_not_exists(LastUpdateTime) OR LastUpdateTime < :time)"),
+			ExpressionAttributeValues:           values,
+			ReturnValuesOnConditionCheckFailure: types.ReturnValuesOnConditionCheckFailureAllOld,
+		})
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			// The old item tells a stale status from an unregistered device
+			if len(conditionFailed.Item) == 0 {
+				result.Unknown = append(result.Unknown, record.DeviceID)
+			} else {
+				result.Stale++
+			}
+			continue
+		}
+		if err != nil {
+			return result, fmt.Errorf("failed to update device %s: %w", record.DeviceID, err)
+		}
+
+		result.Updated++
+	}
+
+	return result, nil
+}
@@ -0,0 +1,116 @@
+package attestation
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// Errors returned, wrapped, by Verify; match them with errors.Is
+var (
+	// ErrInvalidQuote means the quote isn't signed by the attestation key,
+	// isn't a quote, or doesn't cover its claim or its PCR values
+	ErrInvalidQuote = errors.New("invalid attestation quote")
+
+	// ErrPCRMismatch means a measured PCR differs from its expected value,
+	// i.e. the device booted something other than the approved software
+	ErrPCRMismatch = errors.New("PCR value mismatch")
+)
+
+// Claim is what a quote vouches for besides the PCRs; its digest is the
+// quote's nonce, so a quote can't be replayed for another device, rollout
+// or status report
+type Claim struct {
+	DeviceID  string `json:"deviceId"` // tenant-qualified key
+	RolloutID string `json:"rolloutId"`
+	Status    string `json:"status"`
+	Sequence  int64  `json:"sequence"` // the status report's sequence; increases with every report
+}
+
+// Nonce returns the digest the TPM signs into a quote for the claim
+func (c Claim) Nonce() []byte {
+	data, _ := json.Marshal(c)
+	digest := sha256.Sum256(data)
+	return digest[:]
+}
+
+// Quote is a TPM's signed statement of the device's PCR values
+type Quote struct {
+	Claim     Claim          `json:"claim"`
+	Attest    []byte         `json:"attest"`    // the TPMS_ATTEST structure the TPM signed
+	Signature []byte         `json:"signature"` // ASN.1 ECDSA signature over the SHA-256 of Attest
+	PCRs      map[int][]byte `json:"pcrs"`      // SHA-256 PCR values the quote covers
+	AKPublic  []byte         `json:"akPublic"`  // DER-encoded attestation key
+}
+
+// Verify checks that the quote is signed by akPublic, is bound to its claim
+// and covers its PCR values, and that those match expected, a map of PCR
+// index to hex SHA-256 value. An empty akPublic trusts the quote's own key.
+func Verify(quote *Quote, akPublic []byte, expected map[int]string) error {
+	if len(akPublic) == 0 {
+		akPublic = quote.AKPublic
+	}
+	parsed, err := x509.ParsePKIXPublicKey(akPublic)
+	if err != nil {
+		return fmt.Errorf("%w: failed to parse attestation key: %v", ErrInvalidQuote, err)
+	}
+	key, ok := parsed.(*ecdsa.PublicKey)
+	if !ok {
+		return fmt.Errorf("%w: attestation key is not an ECDSA key", ErrInvalidQuote)
+	}
+
+	digest := sha256.Sum256(quote.Attest)
+	if !ecdsa.VerifyASN1(key, digest[:], quote.Signature) {
+		return fmt.Errorf("%w: signature verification failed", ErrInvalidQuote)
+	}
+
+	attested, err := tpm2.DecodeAttestationData(quote.Attest)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidQuote, err)
+	}
+	if attested.Type != tpm2.TagAttestQuote || attested.AttestedQuoteInfo == nil {
+		return fmt.Errorf("%w: attested data is not a quote", ErrInvalidQuote)
+	}
+	if !bytes.Equal(attested.ExtraData, quote.Claim.Nonce()) {
+		return fmt.Errorf("%w: nonce does not match the claim", ErrInvalidQuote)
+	}
+
+	// The quote signs a digest of the selected PCRs in index order
+	selection := attested.AttestedQuoteInfo.PCRSelection
+	if selection.Hash != tpm2.AlgSHA256 || len(selection.PCRs) != len(quote.PCRs) {
+		return fmt.Errorf("%w: quote does not cover the reported PCRs", ErrInvalidQuote)
+	}
+	indexes := append([]int(nil), selection.PCRs...)
+	sort.Ints(indexes)
+	pcrDigest := sha256.New()
+	for _, index := range indexes {
+		value, ok := quote.PCRs[index]
+		if !ok {
+			return fmt.Errorf("%w: PCR %d is not reported", ErrInvalidQuote, index)
+		}
+		pcrDigest.Write(value)
+	}
+	if !bytes.Equal(pcrDigest.Sum(nil), attested.AttestedQuoteInfo.PCRDigest) {
+		return fmt.Errorf("%w: PCR digest does not match the reported PCRs", ErrInvalidQuote)
+	}
+
+	for index, want := range expected {
+		value, ok := quote.PCRs[index]
+		if !ok {
+			return fmt.Errorf("%w: PCR %d is not quoted", ErrPCRMismatch, index)
+		}
+		if got := hex.EncodeToString(value); got != want {
+			return fmt.Errorf("%w: PCR %d is %s, expected %s", ErrPCRMismatch, index, got, want)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package attestation
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"math/big"
+	"sync"
+
+	"github.com/google/go-tpm/legacy/tpm2"
+)
+
+// akTemplate is the attestation key: a restricted ECDSA P-256 signing key.
+// Created under the endorsement hierarchy from a fixed template, it is the
+// same key on every start, so the fleet can pin it.
+var akTemplate = tpm2.Public{
+	Type:       tpm2.AlgECC,
+	NameAlg:    tpm2.AlgSHA256,
+	Attributes: tpm2.FlagSignerDefault,
+	ECCParameters: &tpm2.ECCParams{
+		Sign: &tpm2.SigScheme{
+			Alg:  tpm2.AlgECDSA,
+			Hash: tpm2.AlgSHA256,
+		},
+		CurveID: tpm2.CurveNISTP256,
+	},
+}
+
+// TPMAttestor quotes the device's PCRs with its TPM
+type TPMAttestor struct {
+	device     string
+	pcrs       []int
+	akPublic   []byte
+	quoteMutex sync.Mutex
+}
+
+// TPMAttestorConfig contains configuration for the TPMAttestor
+type TPMAttestorConfig struct {
+	Device string // defaults to /dev/tpmrm0, the kernel's resource manager
+	PCRs   []int  // defaults to 0-7, the firmware and boot loader measurements
+}
+
+// NewTPMAttestor creates a TPMAttestor, checking that the TPM can create the
+// attestation key
+func NewTPMAttestor(config TPMAttestorConfig) (*TPMAttestor, error) {
+	if config.Device == "" {
+		config.Device = "/dev/tpmrm0"
+	}
+	if len(config.PCRs) == 0 {
+		config.PCRs = []int{0, 1, 2, 3, 4, 5, 6, 7}
+	}
+	// A quote covers PCRs of a single bank, and ReadPCRs returns at most 8
+	if len(config.PCRs) > 8 {
+		return nil, errors.New("at most 8 PCRs can be quoted")
+	}
+
+	ta := &TPMAttestor{device: config.Device, pcrs: config.PCRs}
+
+	rw, err := tpm2.OpenTPM(ta.device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TPM %s: %w", ta.device, err)
+	}
+	defer rw.Close()
+
+	ak, publicKey, err := tpm2.CreatePrimary(rw, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", akTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attestation key: %w", err)
+	}
+	defer tpm2.FlushContext(rw, ak)
+
+	ta.akPublic, err = x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode attestation key: %w", err)
+	}
+
+	return ta, nil
+}
+
+// AKPublic returns the DER-encoded attestation key
+func (ta *TPMAttestor) AKPublic() []byte {
+	return ta.akPublic
+}
+
+// Attest has the TPM quote the PCRs with the claim's nonce
+func (ta *TPMAttestor) Attest(claim Claim) (*Quote, error) {
+	// The TPM handles one command sequence at a time
+	ta.quoteMutex.Lock()
+	defer ta.quoteMutex.Unlock()
+
+	rw, err := tpm2.OpenTPM(ta.device)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open TPM %s: %w", ta.device, err)
+	}
+	defer rw.Close()
+
+	ak, _, err := tpm2.CreatePrimary(rw, tpm2.HandleEndorsement, tpm2.PCRSelection{}, "", "", akTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create attestation key: %w", err)
+	}
+	defer tpm2.FlushContext(rw, ak)
+
+	selection := tpm2.PCRSelection{Hash: tpm2.AlgSHA256, PCRs: ta.pcrs}
+	attest, signature, err := tpm2.Quote(rw, ak, "", "", claim.Nonce(), selection, tpm2.AlgNull)
+	if err != nil {
+		return nil, fmt.Errorf("failed to quote PCRs: %w", err)
+	}
+	if signature.ECC == nil {
+		return nil, errors.New("TPM returned a non-ECDSA signature")
+	}
+
+	// Boot measurements don't change after boot, so PCRs read after the
+	// quote match the values it covers
+	pcrs, err := tpm2.ReadPCRs(rw, selection)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read PCRs: %w", err)
+	}
+
+	encoded, err := asn1.Marshal(struct{ R, S *big.Int }{signature.ECC.R, signature.ECC.S})
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode quote signature: %w", err)
+	}
+
+	return &Quote{
+		Claim:     claim,
+		Attest:    attest,
+		Signature: encoded,
+		PCRs:      pcrs,
+		AKPublic:  ta.akPublic,
+	}, nil
+}
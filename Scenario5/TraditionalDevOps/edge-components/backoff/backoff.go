@@ -0,0 +1,91 @@
+package backoff
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"time"
+)
+
+// Policy retries transient failures with exponentially growing, jittered delays
+type Policy struct {
+	Initial     time.Duration // delay before the first retry
+	Max         time.Duration // cap on any single delay
+	Multiplier  float64       // growth of the delay per attempt
+	Jitter      float64       // random +/- fraction applied to each delay
+	MaxAttempts int           // attempts including the first; 1 disables retries
+}
+
+// Default returns the policy used when none is configured: four attempts
+// over roughly seven seconds
+func Default() Policy {
+	return Policy{
+		Initial:     time.Second,
+		Max:         30 * time.Second,
+		Multiplier:  2,
+		Jitter:      0.2,
+		MaxAttempts: 4,
+	}
+}
+
+// None returns a policy that never retries
+func None() Policy {
+	return Policy{MaxAttempts: 1}
+}
+
+// Validate checks that the policy is usable
+func (p Policy) Validate() error {
+	switch {
+	case p.MaxAttempts < 1:
+		return errors.New("backoff needs at least one attempt")
+	case p.MaxAttempts > 1 && p.Initial <= 0:
+		return errors.New("backoff initial delay must be positive")
+	case p.Max < 0:
+		return errors.New("backoff max delay must not be negative")
+	case p.Multiplier != 0 && p.Multiplier < 1:
+		return errors.New("backoff multiplier must be at least 1")
+	case p.Jitter < 0 || p.Jitter >= 1:
+		return errors.New("backoff jitter must be in [0, 1)")
+	}
+	return nil
+}
+
+// Delay returns the wait before retry attempt n, where n=1 is the first retry
+func (p Policy) Delay(n int) time.Duration {
+	multiplier := p.Multiplier
+	if multiplier == 0 {
+		multiplier = 1
+	}
+
+	delay := float64(p.Initial) * math.Pow(multiplier, float64(n-1))
+	if p.Max > 0 && delay > float64(p.Max) {
+		delay = float64(p.Max)
+	}
+
+	return time.Duration(delay * (1 + p.Jitter*(2*rand.Float64()-1)))
+}
+
+// Retry calls fn until it succeeds, the attempts are used up or ctx is done,
+// and returns fn's last error
+func Retry(ctx context.Context, p Policy, fn func() error) error {
+	attempts := p.MaxAttempts
+	if attempts < 1 {
+		attempts = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil || attempt >= attempts {
+			return err
+		}
+
+		timer := time.NewTimer(p.Delay(attempt))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package bandwidth
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// Limiter caps the combined transfer rate of everything sharing it. It is a
+// token bucket holding up to one second of traffic; waits are taken in
+// chunks of at most that size, so a new limit reaches in-flight transfers
+// within about a second.
+type Limiter struct {
+	rate   int64 // bytes per second; 0 is unlimited
+	tokens float64
+	last   time.Time
+	mutex  sync.Mutex
+}
+
+// NewLimiter creates a Limiter; 0 bytes per second is unlimited
+func NewLimiter(bytesPerSecond int64) *Limiter {
+	l := &Limiter{}
+	l.SetLimit(bytesPerSecond)
+	return l
+}
+
+// SetLimit changes the rate, including for transfers already running
+func (l *Limiter) SetLimit(bytesPerSecond int64) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if bytesPerSecond < 0 {
+		bytesPerSecond = 0
+	}
+	l.rate = bytesPerSecond
+	l.tokens = float64(bytesPerSecond)
+	l.last = time.Now()
+}
+
+// Limit returns the rate in bytes per second; 0 is unlimited
+func (l *Limiter) Limit() int64 {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	return l.rate
+}
+
+// WaitN blocks until n bytes may be transferred or ctx is done
+func (l *Limiter) WaitN(ctx context.Context, n int) error {
+	for n > 0 {
+		chunk, delay := l.reserve(n)
+		if chunk == 0 {
+			return nil
+		}
+		n -= chunk
+
+		if delay <= 0 {
+			continue
+		}
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	return nil
+}
+
+// Reader returns r limited by l
+func (l *Limiter) Reader(ctx context.Context, r io.Reader) io.Reader {
+	return &limitedReader{ctx: ctx, reader: r, limiter: l}
+}
+
+// WriterAt returns w limited by l, for concurrent ranged downloads that
+// write parts at their offsets
+func (l *Limiter) WriterAt(ctx context.Context, w io.WriterAt) io.WriterAt {
+	return &limitedWriterAt{ctx: ctx, writer: w, limiter: l}
+}
+
+// reserve takes up to a second's worth of n from the bucket and returns the
+// amount taken and how long to wait for it; 0 taken means unlimited
+func (l *Limiter) reserve(n int) (int, time.Duration) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	if l.rate == 0 {
+		return 0, 0
+	}
+
+	now := time.Now()
+	l.tokens += now.Sub(l.last).Seconds() * float64(l.rate)
+	if l.tokens > float64(l.rate) {
+		l.tokens = float64(l.rate)
+	}
+	l.last = now
+
+	chunk := n
+	if int64(chunk) > l.rate {
+		chunk = int(l.rate)
+	}
+
+	l.tokens -= float64(chunk)
+	if l.tokens >= 0 {
+		return chunk, 0
+	}
+	return chunk, time.Duration(-l.tokens / float64(l.rate) * float64(time.Second))
+}
+
+// limitedReader waits on the limiter for every read
+type limitedReader struct {
+	ctx     context.Context
+	reader  io.Reader
+	limiter *Limiter
+}
+
+func (r *limitedReader) Read(p []byte) (int, error) {
+	n, err := r.reader.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.WaitN(r.ctx, n); waitErr != nil {
+			return n, waitErr
+		}
+	}
+	return n, err
+}
+
+// limitedWriterAt waits on the limiter before every write
+type limitedWriterAt struct {
+	ctx     context.Context
+	writer  io.WriterAt
+	limiter *Limiter
+}
+
+func (w *limitedWriterAt) WriteAt(p []byte, off int64) (int, error) {
+	if err := w.limiter.WaitN(w.ctx, len(p)); err != nil {
+		return 0, err
+	}
+	return w.writer.WriteAt(p, off)
+}
@@ -0,0 +1,252 @@
+package bandwidth
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Traffic categories counted by a Meter
+const (
+	CategoryRollout = "rollout" // package downloads
+	CategorySync    = "sync"    // sync uploads and downloads
+)
+
+// ErrCapExceeded means the billing period's cap is used up; non-critical
+// transfers wait for the next period
+var ErrCapExceeded = errors.New("bandwidth cap exceeded")
+
+// Counter is the traffic of one category
+type Counter struct {
+	Uploaded   int64 `json:"uploaded"`
+	Downloaded int64 `json:"downloaded"`
+}
+
+// Usage is the traffic counted in the current billing period
+type Usage struct {
+	PeriodStart time.Time          `json:"periodStart"`
+	PeriodEnd   time.Time          `json:"periodEnd"`
+	Categories  map[string]Counter `json:"categories"`
+	Uploaded    int64              `json:"uploaded"`
+	Downloaded  int64              `json:"downloaded"`
+	Cap         int64              `json:"cap,omitempty"` // bytes per period, both directions; 0 is unlimited
+	Capped      bool               `json:"capped"`
+}
+
+// Total returns the bytes moved in both directions
+func (u Usage) Total() int64 {
+	return u.Uploaded + u.Downloaded
+}
+
+// MeterConfig contains configuration for the Meter
+type MeterConfig struct {
+	Path     string         // JSON file the counters persist to; empty keeps them in memory
+	ResetDay int            // day of the month the billing period starts, 1 to 28; defaults to 1
+	Location *time.Location // time zone of the billing period; defaults to local time
+	Cap      int64          // bytes per period, both directions; 0 is unlimited
+}
+
+// Meter counts the agent's traffic per billing period, so metered cellular
+// plans can be tracked and capped. The counters survive restarts; once the
+// cap is reached, non-critical transfers are deferred until the period resets.
+type Meter struct {
+	path     string
+	resetDay int
+	location *time.Location
+	cap      int64
+	state    meterState
+	warned   bool // the cap was logged this period
+	mutex    sync.Mutex
+	now      func() time.Time
+}
+
+// meterState is what the meter persists
+type meterState struct {
+	PeriodStart time.Time          `json:"periodStart"`
+	Categories  map[string]Counter `json:"categories"`
+}
+
+// NewMeter creates a Meter, resuming the counters saved at Path
+func NewMeter(config MeterConfig) (*Meter, error) {
+	if config.ResetDay == 0 {
+		config.ResetDay = 1
+	}
+	if config.ResetDay < 1 || config.ResetDay > 28 {
+		return nil, fmt.Errorf("reset day %d is out of range 1 to 28", config.ResetDay)
+	}
+	if config.Location == nil {
+		config.Location = time.Local
+	}
+
+	m := &Meter{
+		path:     config.Path,
+		resetDay: config.ResetDay,
+		location: config.Location,
+		now:      time.Now,
+		state:    meterState{Categories: make(map[string]Counter)},
+	}
+	m.SetCap(config.Cap)
+
+	if m.path != "" {
+		data, err := os.ReadFile(m.path)
+		switch {
+		case os.IsNotExist(err):
+		case err != nil:
+			return nil, fmt.Errorf("failed to read bandwidth usage: %w", err)
+		default:
+			if err := json.Unmarshal(data, &m.state); err != nil {
+				return nil, fmt.Errorf("failed to parse bandwidth usage: %w", err)
+			}
+			if m.state.Categories == nil {
+				m.state.Categories = make(map[string]Counter)
+			}
+		}
+	}
+
+	m.mutex.Lock()
+	m.roll()
+	m.mutex.Unlock()
+
+	return m, nil
+}
+
+// Add counts a transfer and saves the counters
+func (m *Meter) Add(category string, uploaded, downloaded int64) {
+	if uploaded <= 0 && downloaded <= 0 {
+		return
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.roll()
+	counter := m.state.Categories[category]
+	counter.Uploaded += uploaded
+	counter.Downloaded += downloaded
+	m.state.Categories[category] = counter
+
+	if m.cap > 0 && !m.warned && m.total() >= m.cap {
+		m.warned = true
+		log.Printf("Bandwidth cap of %d bytes reached; deferring non-critical transfers until %s", m.cap, m.periodEnd().Format(time.RFC3339))
+	}
+
+	if err := m.save(); err != nil {
+		log.Printf("Failed to save bandwidth usage: %v", err)
+	}
+}
+
+// Allow returns ErrCapExceeded, wrapped, once the cap is used up, unless
+// the transfer is critical
+func (m *Meter) Allow(critical bool) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.roll()
+	if critical || m.cap == 0 {
+		return nil
+	}
+	if total := m.total(); total >= m.cap {
+		return fmt.Errorf("%w: %d of %d bytes used until %s", ErrCapExceeded, total, m.cap, m.periodEnd().Format(time.RFC3339))
+	}
+	return nil
+}
+
+// SetCap changes the cap, e.g. on a configuration reload; 0 is unlimited
+func (m *Meter) SetCap(bytes int64) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if bytes < 0 {
+		bytes = 0
+	}
+	if bytes != m.cap {
+		m.warned = false
+	}
+	m.cap = bytes
+}
+
+// Usage returns the counters of the current period
+func (m *Meter) Usage() Usage {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.roll()
+	usage := Usage{
+		PeriodStart: m.state.PeriodStart,
+		PeriodEnd:   m.periodEnd(),
+		Categories:  make(map[string]Counter, len(m.state.Categories)),
+		Cap:         m.cap,
+	}
+	for category, counter := range m.state.Categories {
+		usage.Categories[category] = counter
+		usage.Uploaded += counter.Uploaded
+		usage.Downloaded += counter.Downloaded
+	}
+	usage.Capped = m.cap > 0 && usage.Total() >= m.cap
+
+	return usage
+}
+
+// roll starts a new period, with zeroed counters, once the current one ends;
+// the caller holds the mutex
+func (m *Meter) roll() {
+	start := m.periodStart(m.now())
+	if m.state.PeriodStart.Equal(start) {
+		return
+	}
+
+	m.state = meterState{PeriodStart: start, Categories: make(map[string]Counter)}
+	m.warned = false
+	if err := m.save(); err != nil {
+		log.Printf("Failed to save bandwidth usage: %v", err)
+	}
+}
+
+// periodStart returns the start of the billing period containing now
+func (m *Meter) periodStart(now time.Time) time.Time {
+	now = now.In(m.location)
+	start := time.Date(now.Year(), now.Month(), m.resetDay, 0, 0, 0, 0, m.location)
+	if now.Before(start) {
+		start = start.AddDate(0, -1, 0)
+	}
+	return start
+}
+
+// periodEnd returns when the current period resets
+func (m *Meter) periodEnd() time.Time {
+	return m.state.PeriodStart.AddDate(0, 1, 0)
+}
+
+// total returns the bytes moved this period; the caller holds the mutex
+func (m *Meter) total() int64 {
+	var total int64
+	for _, counter := range m.state.Categories {
+		total += counter.Uploaded + counter.Downloaded
+	}
+	return total
+}
+
+// save writes the counters to the file atomically; the caller holds the mutex
+func (m *Meter) save() error {
+	if m.path == "" {
+		return nil
+	}
+
+	data, err := json.Marshal(m.state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal bandwidth usage: %w", err)
+	}
+
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write bandwidth usage: %w", err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to write bandwidth usage: %w", err)
+	}
+	return nil
+}
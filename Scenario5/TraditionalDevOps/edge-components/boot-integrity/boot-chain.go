@@ -0,0 +1,170 @@
+package bootintegrity
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// ErrIntegrityFailed means the device's boot chain can't be trusted: secure
+// boot is off, a dm-verity device found corruption, or the state couldn't be read
+var ErrIntegrityFailed = errors.New("boot integrity check failed")
+
+// UEFI variables of the global variable GUID that describe secure boot
+const (
+	secureBootVar = "SecureBoot-8be4df61-93ca-11d2-aa0d-e0988f4ed8d3"
+	setupModeVar  = "SetupMode-8be4df61-93ca-11d2-aa0d-e0988f4ed8d3"
+)
+
+// BootChainVerifier checks the device booted a verified chain: UEFI secure
+// boot enforcing, and dm-verity devices, such as a read-only root, reporting
+// no corruption. Register it with RegisterPreApplyHook so a tampered device
+// refuses updates.
+type BootChainVerifier struct {
+	requireSecureBoot bool
+	verityDevices     []string
+	efiVarsPath       string
+	dmsetup           string
+	timeout           time.Duration
+}
+
+// BootChainConfig contains configuration for the BootChainVerifier
+type BootChainConfig struct {
+	RequireSecureBoot bool          // UEFI secure boot must be enabled and out of setup mode
+	VerityDevices     []string      // device-mapper names of dm-verity devices, e.g. vroot
+	EFIVarsPath       string        // defaults to /sys/firmware/efi/efivars
+	Dmsetup           string        // defaults to dmsetup on the PATH
+	Timeout           time.Duration // for each dmsetup call; defaults to 10 seconds
+}
+
+// NewBootChainVerifier creates a new BootChainVerifier
+func NewBootChainVerifier(config BootChainConfig) (*BootChainVerifier, error) {
+	if !config.RequireSecureBoot && len(config.VerityDevices) == 0 {
+		return nil, errors.New("nothing to verify: require secure boot or list dm-verity devices")
+	}
+
+	v := &BootChainVerifier{
+		requireSecureBoot: config.RequireSecureBoot,
+		verityDevices:     config.VerityDevices,
+		efiVarsPath:       config.EFIVarsPath,
+		dmsetup:           config.Dmsetup,
+		timeout:           config.Timeout,
+	}
+	if v.efiVarsPath == "" {
+		v.efiVarsPath = "/sys/firmware/efi/efivars"
+	}
+	if v.dmsetup == "" {
+		v.dmsetup = "dmsetup"
+	}
+	if v.timeout == 0 {
+		v.timeout = 10 * time.Second
+	}
+
+	return v, nil
+}
+
+// Verify returns nil when the boot chain is intact, and otherwise
+// ErrIntegrityFailed wrapped with what failed
+func (v *BootChainVerifier) Verify() error {
+	if v.requireSecureBoot {
+		if err := v.verifySecureBoot(); err != nil {
+			return fmt.Errorf("%w: %v", ErrIntegrityFailed, err)
+		}
+	}
+
+	for _, device := range v.verityDevices {
+		if err := v.verifyVerity(device); err != nil {
+			return fmt.Errorf("%w: %v", ErrIntegrityFailed, err)
+		}
+	}
+
+	return nil
+}
+
+// PreApply verifies the boot chain before an update is installed; it
+// satisfies the rollout package's PreApplyHook
+func (v *BootChainVerifier) PreApply(rolloutID, version string) error {
+	return v.Verify()
+}
+
+// CheckHealth reports a tampered boot chain as unhealthy, so it can also
+// run as a health check
+func (v *BootChainVerifier) CheckHealth() (bool, error) {
+	err := v.Verify()
+	if errors.Is(err, ErrIntegrityFailed) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// verifySecureBoot checks the SecureBoot and SetupMode UEFI variables
+func (v *BootChainVerifier) verifySecureBoot() error {
+	enabled, err := v.efiVar(secureBootVar)
+	if err != nil {
+		return fmt.Errorf("failed to read secure boot state: %w", err)
+	}
+	if enabled != 1 {
+		return errors.New("secure boot is disabled")
+	}
+
+	// In setup mode anyone can enroll keys, so secure boot enforces nothing
+	setupMode, err := v.efiVar(setupModeVar)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read setup mode: %w", err)
+	}
+	if err == nil && setupMode != 0 {
+		return errors.New("secure boot is in setup mode")
+	}
+
+	return nil
+}
+
+// verifyVerity checks a dm-verity device; its status line ends in V while
+// every block read so far verified, and C once corruption was detected
+func (v *BootChainVerifier) verifyVerity(device string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), v.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, v.dmsetup, "status", device)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to read dm-verity status of %s: %v: %s", device, err, strings.TrimSpace(stderr.String()))
+	}
+
+	fields := strings.Fields(stdout.String())
+	if len(fields) < 4 || fields[2] != "verity" {
+		return fmt.Errorf("%s is not a dm-verity device", device)
+	}
+	switch fields[3] {
+	case "V":
+		return nil
+	case "C":
+		return fmt.Errorf("dm-verity detected corruption on %s", device)
+	default:
+		return fmt.Errorf("unknown dm-verity status %q on %s", fields[3], device)
+	}
+}
+
+// Helper functions
+
+// efiVar reads a one-byte UEFI variable; efivarfs prefixes the value with
+// four bytes of attributes
+func (v *BootChainVerifier) efiVar(name string) (byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(v.efiVarsPath, name))
+	if err != nil {
+		return 0, err
+	}
+	if len(data) < 5 {
+		return 0, fmt.Errorf("%s is truncated", name)
+	}
+	return data[4], nil
+}
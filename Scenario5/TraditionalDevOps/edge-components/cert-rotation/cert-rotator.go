@@ -0,0 +1,286 @@
+package certrotation
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Renewer is an interface for obtaining a signed certificate from a CA
+type Renewer interface {
+	// RequestRenewal submits a CSR and returns the signed certificate chain in PEM form
+	RequestRenewal(ctx context.Context, csrPEM []byte) ([]byte, error)
+}
+
+// ConnectivityCheck is an interface for verifying connectivity with the installed certificate
+type ConnectivityCheck interface {
+	// CheckConnectivity verifies the device can still authenticate using the certificate
+	CheckConnectivity(certPath, keyPath string) error
+}
+
+// CertStatus describes the certificate currently installed on the device
+type CertStatus struct {
+	Subject       string    `json:"subject"`
+	SerialNumber  string    `json:"serialNumber"`
+	NotAfter      time.Time `json:"notAfter"`
+	LastRotation  time.Time `json:"lastRotation"`
+	LastError     string    `json:"lastError,omitempty"`
+	RotationCount int       `json:"rotationCount"`
+}
+
+// CertRotator tracks device certificate expiry and rotates certificates before they expire
+type CertRotator struct {
+	certPath           string
+	keyPath            string
+	renewer            Renewer
+	connectivityChecks []ConnectivityCheck
+	renewBefore        time.Duration
+	checkInterval      time.Duration
+	checkTimer         *time.Timer
+	status             CertStatus
+	rotateMutex        sync.Mutex
+}
+
+// RotatorConfig contains configuration for the CertRotator
+type RotatorConfig struct {
+	CertPath      string
+	KeyPath       string
+	Renewer       Renewer
+	RenewBefore   time.Duration
+	CheckInterval time.Duration
+}
+
+// NewCertRotator creates a new CertRotator
+func NewCertRotator(config RotatorConfig) (*CertRotator, error) {
+	if config.Renewer == nil {
+		return nil, fmt.Errorf("a renewer is required")
+	}
+
+	cr := &CertRotator{
+		certPath:           config.CertPath,
+		keyPath:            config.KeyPath,
+		renewer:            config.Renewer,
+		connectivityChecks: make([]ConnectivityCheck, 0),
+		renewBefore:        config.RenewBefore,
+		checkInterval:      config.CheckInterval,
+	}
+
+	if cert, err := loadCertificate(cr.certPath); err == nil {
+		cr.status.Subject = cert.Subject.String()
+		cr.status.SerialNumber = cert.SerialNumber.String()
+		cr.status.NotAfter = cert.NotAfter
+	}
+
+	// Start the check timer
+	cr.checkTimer = time.AfterFunc(cr.checkInterval, cr.checkExpiry)
+
+	return cr, nil
+}
+
+// RegisterConnectivityCheck registers a check run after each rotation
+func (cr *CertRotator) RegisterConnectivityCheck(check ConnectivityCheck) {
+	cr.connectivityChecks = append(cr.connectivityChecks, check)
+}
+
+// checkExpiry rotates the certificate once it is within the renewal window
+func (cr *CertRotator) checkExpiry() {
+	defer func() {
+		// Reschedule the check
+		cr.checkTimer.Reset(cr.checkInterval)
+	}()
+
+	cert, err := loadCertificate(cr.certPath)
+	if err != nil {
+		log.Printf("Failed to load device certificate: %v", err)
+		return
+	}
+
+	if time.Until(cert.NotAfter) > cr.renewBefore {
+		return
+	}
+
+	log.Printf("Device certificate expires at %s, rotating", cert.NotAfter.Format(time.RFC3339))
+
+	if err := cr.Rotate(context.Background()); err != nil {
+		log.Printf("Failed to rotate device certificate: %v", err)
+	}
+}
+
+// Rotate renews the device certificate, installs it and rolls back if connectivity checks fail
+func (cr *CertRotator) Rotate(ctx context.Context) error {
+	cr.rotateMutex.Lock()
+	defer cr.rotateMutex.Unlock()
+
+	err := cr.rotate(ctx)
+	if err != nil {
+		cr.status.LastError = err.Error()
+	}
+	return err
+}
+
+// rotate performs a single rotation
+func (cr *CertRotator) rotate(ctx context.Context) error {
+	current, err := loadCertificate(cr.certPath)
+	if err != nil {
+		return fmt.Errorf("failed to load current certificate: %w", err)
+	}
+
+	// Generate a new key pair and CSR with the current subject
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	csrDER, err := x509.CreateCertificateRequest(rand.Reader, &x509.CertificateRequest{
+		Subject:  current.Subject,
+		DNSNames: current.DNSNames,
+	}, key)
+	if err != nil {
+		return fmt.Errorf("failed to create CSR: %w", err)
+	}
+
+	certPEM, err := cr.renewer.RequestRenewal(ctx, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csrDER}))
+	if err != nil {
+		return fmt.Errorf("failed to renew certificate: %w", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key: %w", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER})
+
+	// Make sure the CA returned a certificate for our key before touching the installed one
+	if _, err := tls.X509KeyPair(certPEM, keyPEM); err != nil {
+		return fmt.Errorf("renewed certificate does not match key: %w", err)
+	}
+
+	if err := cr.install(certPEM, keyPEM); err != nil {
+		return err
+	}
+
+	// Verify connectivity with the new certificate
+	for _, check := range cr.connectivityChecks {
+		if err := check.CheckConnectivity(cr.certPath, cr.keyPath); err != nil {
+			log.Printf("Connectivity check failed after rotation, rolling back: %v", err)
+
+			if rbErr := cr.restorePrevious(); rbErr != nil {
+				return fmt.Errorf("connectivity check failed (%v) and rollback failed: %w", err, rbErr)
+			}
+			return fmt.Errorf("connectivity check failed after rotation: %w", err)
+		}
+	}
+
+	newCert, err := loadCertificate(cr.certPath)
+	if err != nil {
+		return fmt.Errorf("failed to load installed certificate: %w", err)
+	}
+
+	cr.status.Subject = newCert.Subject.String()
+	cr.status.SerialNumber = newCert.SerialNumber.String()
+	cr.status.NotAfter = newCert.NotAfter
+	cr.status.LastRotation = time.Now().UTC()
+	cr.status.LastError = ""
+	cr.status.RotationCount++
+
+	return nil
+}
+
+// install atomically replaces the certificate and key, keeping the previous pair for rollback
+func (cr *CertRotator) install(certPEM, keyPEM []byte) error {
+	if err := ioutil.WriteFile(cr.certPath+".new", certPEM, 0644); err != nil {
+		return fmt.Errorf("failed to write new certificate: %w", err)
+	}
+
+	if err := ioutil.WriteFile(cr.keyPath+".new", keyPEM, 0600); err != nil {
+		os.Remove(cr.certPath + ".new")
+		return fmt.Errorf("failed to write new key: %w", err)
+	}
+
+	if err := copyFile(cr.certPath, cr.certPath+".prev", 0644); err != nil {
+		return fmt.Errorf("failed to back up certificate: %w", err)
+	}
+
+	if err := copyFile(cr.keyPath, cr.keyPath+".prev", 0600); err != nil {
+		return fmt.Errorf("failed to back up key: %w", err)
+	}
+
+	if err := os.Rename(cr.keyPath+".new", cr.keyPath); err != nil {
+		return fmt.Errorf("failed to install key: %w", err)
+	}
+
+	if err := os.Rename(cr.certPath+".new", cr.certPath); err != nil {
+		// Put the old key back so the pair stays consistent
+		cr.restorePrevious()
+		return fmt.Errorf("failed to install certificate: %w", err)
+	}
+
+	return nil
+}
+
+// restorePrevious reinstates the certificate and key from before the last rotation
+func (cr *CertRotator) restorePrevious() error {
+	if err := copyFile(cr.keyPath+".prev", cr.keyPath, 0600); err != nil {
+		return fmt.Errorf("failed to restore key: %w", err)
+	}
+
+	if err := copyFile(cr.certPath+".prev", cr.certPath, 0644); err != nil {
+		return fmt.Errorf("failed to restore certificate: %w", err)
+	}
+
+	return nil
+}
+
+// GetStatus returns the status of the installed certificate
+func (cr *CertRotator) GetStatus() CertStatus {
+	cr.rotateMutex.Lock()
+	defer cr.rotateMutex.Unlock()
+	return cr.status
+}
+
+// Close stops the cert rotator
+func (cr *CertRotator) Close() {
+	if cr.checkTimer != nil {
+		cr.checkTimer.Stop()
+	}
+}
+
+// Helper functions
+
+func loadCertificate(path string) (*x509.Certificate, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return nil, fmt.Errorf("no certificate found in %s", path)
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+func copyFile(src, dst string, perm os.FileMode) error {
+	data, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+
+	tmp := dst + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, dst)
+}
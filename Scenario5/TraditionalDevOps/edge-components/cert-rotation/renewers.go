@@ -0,0 +1,158 @@
+package certrotation
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca"
+	"github.com/aws/aws-sdk-go-v2/service/acmpca/types"
+	"github.com/google/uuid"
+)
+
+// ACMPCARenewer issues certificates from an AWS Private CA
+type ACMPCARenewer struct {
+	client       *acmpca.Client
+	caARN        string
+	validityDays int64
+	issueTimeout time.Duration
+}
+
+// NewACMPCARenewer creates a new ACMPCARenewer
+func NewACMPCARenewer(client *acmpca.Client, caARN string, validityDays int64) *ACMPCARenewer {
+	return &ACMPCARenewer{
+		client:       client,
+		caARN:        caARN,
+		validityDays: validityDays,
+		issueTimeout: 2 * time.Minute,
+	}
+}
+
+// RequestRenewal issues a certificate for the CSR and waits for it to be available
+func (r *ACMPCARenewer) RequestRenewal(ctx context.Context, csrPEM []byte) ([]byte, error) {
+	issued, err := r.client.IssueCertificate(ctx, &acmpca.IssueCertificateInput{
+		CertificateAuthorityArn: aws.String(r.caARN),
+		Csr:                     csrPEM,
+		SigningAlgorithm:        types.SigningAlgorithmSha256withecdsa,
+		Validity: &types.Validity{
+			Type:  types.ValidityPeriodTypeDays,
+			Value: aws.Int64(r.validityDays),
+		},
+		IdempotencyToken: aws.String(uuid.New().String()[:32]),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to issue certificate: %w", err)
+	}
+
+	getInput := &acmpca.GetCertificateInput{
+		CertificateAuthorityArn: aws.String(r.caARN),
+		CertificateArn:          issued.CertificateArn,
+	}
+
+	waiter := acmpca.NewCertificateIssuedWaiter(r.client)
+	if err := waiter.Wait(ctx, getInput, r.issueTimeout); err != nil {
+		return nil, fmt.Errorf("certificate was not issued in time: %w", err)
+	}
+
+	result, err := r.client.GetCertificate(ctx, getInput)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get issued certificate: %w", err)
+	}
+
+	chain := aws.ToString(result.Certificate)
+	if result.CertificateChain != nil {
+		chain += "\n" + aws.ToString(result.CertificateChain)
+	}
+
+	return []byte(chain), nil
+}
+
+// HTTPRenewer requests certificates from an internal CA endpoint that accepts a
+// PEM CSR in the request body and responds with the PEM certificate chain
+type HTTPRenewer struct {
+	endpoint   string
+	httpClient *http.Client
+}
+
+// NewHTTPRenewer creates a new HTTPRenewer that authenticates with the current device certificate
+func NewHTTPRenewer(endpoint, certPath, keyPath string) *HTTPRenewer {
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			// Load the client certificate per request so the latest installed pair is always used
+			GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+				cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+				if err != nil {
+					return nil, err
+				}
+				return &cert, nil
+			},
+		},
+	}
+
+	return &HTTPRenewer{
+		endpoint: endpoint,
+		httpClient: &http.Client{
+			Transport: transport,
+			Timeout:   30 * time.Second,
+		},
+	}
+}
+
+// RequestRenewal posts the CSR to the CA endpoint
+func (r *HTTPRenewer) RequestRenewal(ctx context.Context, csrPEM []byte) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.endpoint, bytes.NewReader(csrPEM))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create renewal request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/pkcs10")
+
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to contact CA: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA response: %w", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CA returned %s: %s", resp.Status, string(body))
+	}
+
+	return body, nil
+}
+
+// TLSConnectivityCheck verifies that a TLS endpoint accepts the device certificate
+type TLSConnectivityCheck struct {
+	Address string
+	Timeout time.Duration
+}
+
+// CheckConnectivity performs a mutual TLS handshake with the configured address
+func (c *TLSConnectivityCheck) CheckConnectivity(certPath, keyPath string) error {
+	cert, err := tls.LoadX509KeyPair(certPath, keyPath)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	dialer := &tls.Dialer{
+		Config: &tls.Config{Certificates: []tls.Certificate{cert}},
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.Timeout)
+	defer cancel()
+
+	conn, err := dialer.DialContext(ctx, "tcp", c.Address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", c.Address, err)
+	}
+
+	return conn.Close()
+}
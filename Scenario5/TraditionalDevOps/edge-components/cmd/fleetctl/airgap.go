@@ -0,0 +1,323 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+	airgap "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/air-gap"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/keymanager"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// runAirgap dispatches the airgap subcommands
+func runAirgap(args []string) error {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "export":
+		return airgapExport(args[1:])
+	case "import":
+		return airgapImport(args[1:])
+	case "serve":
+		return airgapServe(args[1:])
+	case "status":
+		return airgapStatus(args[1:])
+	case "upload":
+		return airgapUpload(args[1:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+	return nil
+}
+
+// airgapExport writes a rollout and its package into a bundle file
+func airgapExport(args []string) error {
+	fs := flag.NewFlagSet("airgap export", flag.ExitOnError)
+	rolloutID := fs.String("rollout", "", "rollout ID")
+	output := fs.String("o", "", "bundle file to write")
+	rolloutTable := fs.String("rollout-table", envOr("FLEET_ROLLOUT_TABLE", "edge-rollouts"), "rollout table")
+	artifactTable := fs.String("artifact-table", envOr("FLEET_ARTIFACT_TABLE", "edge-artifacts"), "artifact registry table")
+	user := fs.String("user", envOr("FLEET_USER", os.Getenv("USER")), "identity recorded as exporter")
+	fs.Parse(args)
+
+	if *rolloutID == "" || *output == "" {
+		return fmt.Errorf("-rollout and -o are required")
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create bundle: %w", err)
+	}
+	defer file.Close()
+
+	exporter := airgap.NewExporter(airgap.ExporterConfig{
+		DynamoClient:      dynamodb.NewFromConfig(cfg),
+		S3Client:          s3.NewFromConfig(cfg),
+		RolloutTableName:  *rolloutTable,
+		ArtifactTableName: *artifactTable,
+	})
+
+	manifest, err := exporter.Export(ctx, *rolloutID, *user, file)
+	if err != nil {
+		file.Close()
+		os.Remove(*output)
+		return err
+	}
+
+	fmt.Printf("exported rollout %s (version %s) to %s\n", manifest.Plan.ID, manifest.Plan.Version, *output)
+	for _, entry := range manifest.Packages {
+		fmt.Printf("  package %s (%d bytes)\n", entry.SHA256, entry.Size)
+	}
+	if manifest.Plan.Signature == "" {
+		fmt.Println("warning: the rollout is not signed; importers can't verify it")
+	}
+	return nil
+}
+
+// airgapImport unpacks and verifies a bundle for a local gateway
+func airgapImport(args []string) error {
+	fs := flag.NewFlagSet("airgap import", flag.ExitOnError)
+	input := fs.String("f", "", "bundle file")
+	dir := fs.String("dir", "", "directory to import into")
+	keyRing := fs.String("keyring", "", "key ring file of trusted signing keys, as kept by devices")
+	insecure := fs.Bool("insecure-skip-verify", false, "import without checking signatures")
+	fs.Parse(args)
+
+	if *input == "" || *dir == "" {
+		return fmt.Errorf("-f and -dir are required")
+	}
+	if *keyRing == "" && !*insecure {
+		return fmt.Errorf("-keyring is required to verify signatures; pass -insecure-skip-verify to import without")
+	}
+
+	var verifier rollout.SignatureVerifier
+	if *keyRing != "" {
+		kr, err := keymanager.NewKeyRing(*keyRing)
+		if err != nil {
+			return err
+		}
+		if len(kr.KeyIDs()) == 0 {
+			return fmt.Errorf("key ring %s holds no keys", *keyRing)
+		}
+		verifier = kr
+	}
+
+	file, err := os.Open(*input)
+	if err != nil {
+		return fmt.Errorf("failed to open bundle: %w", err)
+	}
+	defer file.Close()
+
+	manifest, err := airgap.Import(file, *dir, verifier)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("imported rollout %s (version %s), exported %s by %s\n", manifest.Plan.ID, manifest.Plan.Version, manifest.ExportedAt.Format("2006-01-02 15:04"), manifest.ExportedBy)
+	return nil
+}
+
+// airgapServe runs a local gateway that applies an imported bundle to devices
+func airgapServe(args []string) error {
+	fs := flag.NewFlagSet("airgap serve", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory a bundle was imported into")
+	gatewayID := fs.String("gateway", envOr("FLEET_GATEWAY_ID", hostname()), "site identity recorded in status reports")
+	grpcAddr := fs.String("grpc", ":8443", "gRPC listen address for agents")
+	httpAddr := fs.String("http", ":8444", "HTTPS listen address for package downloads")
+	publicURL := fs.String("public-url", "", "base URL devices use to reach the HTTPS address")
+	certFile := fs.String("cert", "", "gateway TLS certificate")
+	keyFile := fs.String("key", "", "gateway TLS key")
+	caFile := fs.String("ca", "", "CA that issued device certificates")
+	fs.Parse(args)
+
+	if *dir == "" || *publicURL == "" || *certFile == "" || *keyFile == "" || *caFile == "" {
+		return fmt.Errorf("-dir, -public-url, -cert, -key and -ca are required")
+	}
+
+	tlsConfig, err := mutualTLS(*certFile, *keyFile, *caFile)
+	if err != nil {
+		return err
+	}
+
+	gateway, err := airgap.NewLocalGateway(airgap.LocalGatewayConfig{
+		GatewayID: *gatewayID,
+		TenantID:  os.Getenv("FLEET_TENANT"),
+		BundleDir: *dir,
+		PublicURL: *publicURL,
+	})
+	if err != nil {
+		return err
+	}
+
+	listener, err := net.Listen("tcp", *grpcAddr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	grpcServer := grpc.NewServer(grpc.Creds(credentials.NewTLS(tlsConfig)))
+	agentproto.RegisterFleetAgentServer(grpcServer, gateway)
+
+	httpServer := &http.Server{
+		Addr:      *httpAddr,
+		Handler:   gateway.Handler(),
+		TLSConfig: tlsConfig,
+	}
+
+	go func() {
+		if err := grpcServer.Serve(listener); err != nil {
+			log.Printf("gRPC server stopped: %v", err)
+		}
+	}()
+	go func() {
+		if err := httpServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
+			log.Printf("HTTPS server stopped: %v", err)
+		}
+	}()
+
+	log.Printf("Serving air-gapped rollout from %s on %s and %s", *dir, *grpcAddr, *httpAddr)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	<-signals
+
+	grpcServer.GracefulStop()
+	return httpServer.Close()
+}
+
+// airgapStatus exports what a local gateway collected, for carrying back and uploading
+func airgapStatus(args []string) error {
+	fs := flag.NewFlagSet("airgap status", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory a bundle was imported into")
+	gatewayID := fs.String("gateway", envOr("FLEET_GATEWAY_ID", hostname()), "site identity recorded in the report")
+	output := fs.String("o", "", "status file to write; prints a summary when empty")
+	fs.Parse(args)
+
+	if *dir == "" {
+		return fmt.Errorf("-dir is required")
+	}
+
+	gateway, err := airgap.NewLocalGateway(airgap.LocalGatewayConfig{
+		GatewayID: *gatewayID,
+		TenantID:  os.Getenv("FLEET_TENANT"),
+		BundleDir: *dir,
+	})
+	if err != nil {
+		return err
+	}
+
+	if *output == "" {
+		report := gateway.Report()
+		counts := make(map[string]int)
+		for _, record := range report.Statuses {
+			counts[record.Status]++
+		}
+		fmt.Printf("rollout %s (version %s): %d devices reported\n", report.RolloutID, report.Version, len(report.Statuses))
+		for status, count := range counts {
+			fmt.Printf("  %-18s %d\n", status, count)
+		}
+		return nil
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return fmt.Errorf("failed to create status file: %w", err)
+	}
+	defer file.Close()
+
+	return gateway.ExportStatus(file)
+}
+
+// airgapUpload writes an exported status report to the device table
+func airgapUpload(args []string) error {
+	fs := flag.NewFlagSet("airgap upload", flag.ExitOnError)
+	input := fs.String("f", "", "status file from airgap status")
+	table := fs.String("table", envOr("FLEET_DEVICE_TABLE", "edge-devices"), "device table")
+	fs.Parse(args)
+
+	if *input == "" {
+		return fmt.Errorf("-f is required")
+	}
+
+	data, err := os.ReadFile(*input)
+	if err != nil {
+		return fmt.Errorf("failed to read status file: %w", err)
+	}
+
+	var report airgap.StatusReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return fmt.Errorf("failed to parse status file: %w", err)
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	result, err := airgap.UploadStatus(ctx, dynamodb.NewFromConfig(cfg), *table, report)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("uploaded %d statuses from %s, %d older than the fleet's\n", result.Updated, report.GatewayID, result.Stale)
+	for _, deviceID := range result.Unknown {
+		fmt.Printf("unknown device: %s\n", deviceID)
+	}
+	return nil
+}
+
+// mutualTLS loads a server certificate and requires client certificates issued by the CA
+func mutualTLS(certFile, keyFile, caFile string) (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load certificate: %w", err)
+	}
+
+	caData, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CA: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caData) {
+		return nil, fmt.Errorf("no certificates in %s", caFile)
+	}
+
+	return &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		ClientCAs:    pool,
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		MinVersion:   tls.VersionTLS12,
+	}, nil
+}
+
+func hostname() string {
+	name, _ := os.Hostname()
+	return name
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/experiments"
+)
+
+// experimentResults mirrors the fleet server's experiment results representation
+type experimentResults struct {
+	ExperimentID string    `json:"experimentId"`
+	Since        time.Time `json:"since"`
+	Control      string    `json:"control"`
+	Variants     []struct {
+		Name        string `json:"name"`
+		Devices     int    `json:"devices"`
+		Comparisons []struct {
+			Metric        string  `json:"metric"`
+			CanaryMean    float64 `json:"canaryMean"`
+			ControlMean   float64 `json:"controlMean"`
+			RelativeDelta float64 `json:"relativeDelta"`
+			PValue        float64 `json:"pValue"`
+			Regression    bool    `json:"regression"`
+		} `json:"comparisons"`
+	} `json:"variants"`
+}
+
+// runExperiments dispatches the experiments subcommands
+func runExperiments(args []string) error {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("experiments "+args[0], flag.ExitOnError)
+	server := serverFlag(fs)
+	user := fs.String("user", envOr("FLEET_USER", os.Getenv("USER")), "identity recorded as the actor")
+	file := fs.String("f", "", "experiment definition file (YAML or JSON)")
+	id := fs.String("id", "", "experiment ID")
+	fs.Parse(args[1:])
+
+	c := newClient(*server)
+
+	switch args[0] {
+	case "create":
+		if *file == "" {
+			return fmt.Errorf("-f is required")
+		}
+		data, err := os.ReadFile(*file)
+		if err != nil {
+			return fmt.Errorf("failed to read experiment file: %w", err)
+		}
+		var experiment experiments.Experiment
+		if err := yaml.Unmarshal(data, &experiment); err != nil {
+			return fmt.Errorf("failed to parse experiment file: %w", err)
+		}
+		experiment.CreatedBy = *user
+
+		var created experiments.Experiment
+		if err := c.do(http.MethodPost, "/api/experiments", experiment, &created); err != nil {
+			return err
+		}
+		fmt.Println(created.ID)
+		return nil
+
+	case "list":
+		var list []experiments.Experiment
+		if err := c.do(http.MethodGet, "/api/experiments", nil, &list); err != nil {
+			return err
+		}
+		printExperiments(list)
+		return nil
+
+	case "start", "stop":
+		if *id == "" {
+			return fmt.Errorf("-id is required")
+		}
+		var experiment experiments.Experiment
+		err := c.do(http.MethodPost, "/api/experiments/"+url.PathEscape(*id)+"/"+args[0], map[string]string{"actor": *user}, &experiment)
+		if err != nil {
+			return err
+		}
+		printExperiments([]experiments.Experiment{experiment})
+		return nil
+
+	case "results":
+		if *id == "" {
+			return fmt.Errorf("-id is required")
+		}
+		var results experimentResults
+		if err := c.do(http.MethodGet, "/api/experiments/"+url.PathEscape(*id)+"/results", nil, &results); err != nil {
+			return err
+		}
+		return printExperimentResults(results)
+	}
+
+	usage()
+	os.Exit(2)
+	return nil
+}
+
+func printExperiments(list []experiments.Experiment) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tNAME\tSTATUS\tEXPOSURE\tVARIANTS")
+	for _, e := range list {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%.0f%%\t%d\n", e.ID, e.Name, e.Status, e.Percentage, len(e.Variants))
+	}
+	w.Flush()
+}
+
+func printExperimentResults(results experimentResults) error {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Control:\t%s\n", results.Control)
+	fmt.Fprintln(w, "VARIANT\tDEVICES\tMETRIC\tMEAN\tCONTROL\tDELTA\tP\tSIGNIFICANT")
+	for _, v := range results.Variants {
+		if len(v.Comparisons) == 0 {
+			fmt.Fprintf(w, "%s\t%d\t\t\t\t\t\t\n", v.Name, v.Devices)
+			continue
+		}
+		for _, c := range v.Comparisons {
+			fmt.Fprintf(w, "%s\t%d\t%s\t%.4g\t%.4g\t%+.1f%%\t%.3f\t%t\n",
+				v.Name, v.Devices, c.Metric, c.CanaryMean, c.ControlMean, c.RelativeDelta*100, c.PValue, c.Regression)
+		}
+	}
+	return w.Flush()
+}
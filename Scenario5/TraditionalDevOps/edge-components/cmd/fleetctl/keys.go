@@ -0,0 +1,90 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/keymanager"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+)
+
+// runKeys manages the KMS signing keys devices verify rollouts with
+func runKeys(args []string) error {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("keys "+args[0], flag.ExitOnError)
+	alias := fs.String("alias", envOr("FLEET_SIGNING_KEY", "alias/edge-signing"), "KMS alias of the current signing key")
+	bucket := fs.String("bucket", os.Getenv("FLEET_SYNC_BUCKET"), "sync bucket devices read")
+	tenantID := fs.String("tenant", os.Getenv("FLEET_TENANT"), "tenant that owns the keys")
+	devices := fs.String("devices", "", "comma-separated device IDs to deliver the key set to")
+	fs.Parse(args[1:])
+
+	if *bucket == "" {
+		return fmt.Errorf("-bucket is required")
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	km, err := keymanager.NewKeyManager(keymanager.KeyManagerConfig{
+		KMSClient: kms.NewFromConfig(cfg),
+		Transport: offlineSync.NewS3Transport(s3.NewFromConfig(cfg), *bucket),
+		KeyAlias:  *alias,
+		TenantID:  *tenantID,
+	})
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "list":
+		keySet, err := km.KeySet(ctx)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "KEY ID\tCREATED\tRETIRED")
+		for _, key := range keySet.Keys {
+			retired := "-"
+			if key.RetiredAt != nil {
+				retired = key.RetiredAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\n", key.KeyID, key.CreatedAt.Format(time.RFC3339), retired)
+		}
+		return w.Flush()
+
+	case "rotate":
+		keyID, err := km.Rotate(ctx)
+		if err != nil {
+			return err
+		}
+		fmt.Println(keyID)
+		return nil
+
+	case "distribute":
+		if *devices == "" {
+			return fmt.Errorf("-devices is required")
+		}
+		return km.Distribute(ctx, strings.Split(*devices, ","))
+	}
+
+	usage()
+	os.Exit(2)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/entitlements"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/keymanager"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+)
+
+// runLicense signs licenses with the current signing key and delivers them to devices
+func runLicense(args []string) error {
+	if len(args) < 1 || args[0] != "issue" {
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("license issue", flag.ExitOnError)
+	alias := fs.String("alias", envOr("FLEET_SIGNING_KEY", "alias/edge-signing"), "KMS alias of the current signing key")
+	bucket := fs.String("bucket", os.Getenv("FLEET_SYNC_BUCKET"), "sync bucket devices read")
+	tenantID := fs.String("tenant", os.Getenv("FLEET_TENANT"), "tenant the license is issued to")
+	devices := fs.String("devices", "", "comma-separated device IDs to deliver the license to")
+	features := fs.String("features", "", "comma-separated features to grant")
+	expires := fs.String("expires", "", "expiry, as a date (2006-01-02) or a duration from now (8760h)")
+	grace := fs.String("grace", "", "grace period after expiry, e.g. 168h; defaults to the device's setting")
+	tenantWide := fs.Bool("tenant-wide", false, "bind the license to every device of the tenant instead of -devices")
+	fs.Parse(args[1:])
+
+	switch {
+	case *bucket == "":
+		return fmt.Errorf("-bucket is required")
+	case *devices == "":
+		return fmt.Errorf("-devices is required")
+	case *features == "":
+		return fmt.Errorf("-features is required")
+	}
+
+	expiresAt, err := parseExpiry(*expires)
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	transport := offlineSync.NewS3Transport(s3.NewFromConfig(cfg), *bucket)
+	km, err := keymanager.NewKeyManager(keymanager.KeyManagerConfig{
+		KMSClient: kms.NewFromConfig(cfg),
+		Transport: transport,
+		KeyAlias:  *alias,
+		TenantID:  *tenantID,
+	})
+	if err != nil {
+		return err
+	}
+	signer, err := km.Signer(ctx)
+	if err != nil {
+		return err
+	}
+
+	issuer, err := entitlements.NewIssuer(entitlements.IssuerConfig{Signer: signer, Transport: transport})
+	if err != nil {
+		return err
+	}
+
+	deviceIDs := strings.Split(*devices, ",")
+	license := entitlements.License{
+		TenantID:    *tenantID,
+		Features:    strings.Split(*features, ","),
+		ExpiresAt:   expiresAt,
+		GracePeriod: *grace,
+	}
+	if !*tenantWide {
+		license.DeviceIDs = deviceIDs
+	}
+
+	signed, err := issuer.Issue(license)
+	if err != nil {
+		return err
+	}
+	if err := issuer.Deliver(ctx, signed, deviceIDs); err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(signed)
+}
+
+// parseExpiry accepts a date or a duration from now
+func parseExpiry(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, fmt.Errorf("-expires is required")
+	}
+	if date, err := time.Parse("2006-01-02", value); err == nil {
+		return date.UTC(), nil
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid -expires %q: use a date (2006-01-02) or a duration", value)
+	}
+	return time.Now().Add(duration).UTC().Truncate(time.Second), nil
+}
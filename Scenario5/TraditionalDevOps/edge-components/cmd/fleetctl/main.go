@@ -0,0 +1,287 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// approvalRequest mirrors the fleet server's approval request representation
+type approvalRequest struct {
+	ID         string    `json:"id"`
+	RolloutID  string    `json:"rolloutId"`
+	PhaseIndex int       `json:"phaseIndex"`
+	PhaseID    string    `json:"phaseId"`
+	Role       string    `json:"role"`
+	Quorum     int       `json:"quorum"`
+	Eligible   int       `json:"eligible"`
+	Status     string    `json:"status"`
+	ExpiresAt  time.Time `json:"expiresAt"`
+	Decisions  []struct {
+		Approver string `json:"approver"`
+		Approved bool   `json:"approved"`
+		Comment  string `json:"comment"`
+	} `json:"decisions"`
+}
+
+// client calls the fleet server API
+type client struct {
+	server     string
+	tenant     string
+	apiKey     string
+	token      string
+	httpClient *http.Client
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "approvals":
+		err = runApprovals(os.Args[2:])
+	case "publish":
+		err = runPublish(os.Args[2:])
+	case "package":
+		err = runPackage(os.Args[2:])
+	case "devices":
+		err = runDevices(os.Args[2:])
+	case "failed":
+		err = runFailed(os.Args[2:])
+	case "skew":
+		err = runSkew(os.Args[2:])
+	case "rollout":
+		err = runRollout(os.Args[2:])
+	case "keys":
+		err = runKeys(os.Args[2:])
+	case "license":
+		err = runLicense(os.Args[2:])
+	case "experiments":
+		err = runExperiments(os.Args[2:])
+	case "shadow":
+		err = runShadow(os.Args[2:])
+	case "audit":
+		err = runAudit(os.Args[2:])
+	case "airgap":
+		err = runAirgap(os.Args[2:])
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "fleetctl: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage: fleetctl <command> [flags]
+
+commands:
+  approvals request -rollout ID -phase N -role ROLE -quorum N [-ttl 24h]
+  approvals list    -rollout ID
+  approvals approve -id ID [-comment TEXT]
+  approvals reject  -id ID [-comment TEXT]
+  publish -name NAME -version VERSION -file PATH -bucket BUCKET -table TABLE [-signing-key key.pem | -kms-key ALIAS] [-tenant ID]
+  package -dir DIR -name NAME -version VERSION -bucket BUCKET [-signing-key key.pem | -kms-key ALIAS] [-groups G[,G...]] [-phases 10,50,100]
+  devices -version VERSION
+  failed  -rollout ID
+  skew    -groups GROUP[,GROUP...]
+  rollout plan  -f rollouts.yaml
+  rollout create -template NAME [-id ID] -set version=V -set groups=G[,G...] [-set name=value...]
+  rollout templates
+  rollout cost -id ID
+  rollout apply -f rollouts.yaml [-auto-approve]
+  keys list       -alias ALIAS -bucket BUCKET
+  keys rotate     -alias ALIAS -bucket BUCKET
+  keys distribute -alias ALIAS -bucket BUCKET -devices ID[,ID...]
+  license issue   -bucket BUCKET -devices ID[,ID...] -features F[,F...] -expires DATE|DURATION [-grace 168h] [-tenant-wide]
+  experiments create  -f experiment.yaml
+  experiments list
+  experiments start   -id ID
+  experiments stop    -id ID
+  experiments results -id ID
+  shadow get   -device ID
+  shadow set   -device ID -version VERSION (-artifact NAME | -url URL -hash SHA256 | -config FILE) [-reason TEXT]
+  shadow clear -device ID
+  audit verify
+  airgap export -rollout ID -o bundle.tar.gz
+  airgap import -f bundle.tar.gz -dir DIR (-keyring FILE | -insecure-skip-verify)
+  airgap serve  -dir DIR -public-url URL -cert FILE -key FILE -ca FILE [-grpc :8443] [-http :8444]
+  airgap status -dir DIR [-o status.json]
+  airgap upload -f status.json
+
+FLEET_TENANT scopes server requests and published artifacts to a tenant.
+FLEET_API_KEY or FLEET_TOKEN (an OIDC ID token) authenticates server requests.`)
+}
+
+// runApprovals dispatches the approvals subcommands
+func runApprovals(args []string) error {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("approvals "+args[0], flag.ExitOnError)
+	server := serverFlag(fs)
+	user := fs.String("user", envOr("FLEET_USER", os.Getenv("USER")), "identity recorded as requester or approver")
+	rolloutID := fs.String("rollout", "", "rollout ID")
+	phase := fs.Int("phase", 0, "phase index")
+	role := fs.String("role", "", "approver role")
+	quorum := fs.Int("quorum", 1, "approvals required")
+	ttl := fs.Duration("ttl", 24*time.Hour, "time before the request expires")
+	id := fs.String("id", "", "approval request ID")
+	comment := fs.String("comment", "", "comment recorded with the decision")
+	fs.Parse(args[1:])
+
+	c := newClient(*server)
+
+	switch args[0] {
+	case "request":
+		var created approvalRequest
+		err := c.do(http.MethodPost, "/api/approvals", map[string]interface{}{
+			"rolloutId":   *rolloutID,
+			"phaseIndex":  *phase,
+			"role":        *role,
+			"quorum":      *quorum,
+			"ttl":         ttl.String(),
+			"requestedBy": *user,
+		}, &created)
+		if err != nil {
+			return err
+		}
+		fmt.Println(created.ID)
+		return nil
+
+	case "list":
+		var requests []approvalRequest
+		if err := c.do(http.MethodGet, "/api/approvals?rolloutId="+url.QueryEscape(*rolloutID), nil, &requests); err != nil {
+			return err
+		}
+		printApprovals(requests)
+		return nil
+
+	case "approve", "reject":
+		if *id == "" {
+			return fmt.Errorf("-id is required")
+		}
+		var decided approvalRequest
+		err := c.do(http.MethodPost, "/api/approvals/"+url.PathEscape(*id)+"/"+args[0], map[string]string{
+			"approver": *user,
+			"comment":  *comment,
+		}, &decided)
+		if err != nil {
+			return err
+		}
+		printApprovals([]approvalRequest{decided})
+		return nil
+	}
+
+	usage()
+	os.Exit(2)
+	return nil
+}
+
+// apiError is a non-2xx response from the fleet server
+type apiError struct {
+	Status     string
+	StatusCode int
+	Message    string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("fleet server returned %s: %s", e.Status, e.Message)
+}
+
+// do sends a JSON request to the fleet server and decodes the JSON response
+func (c *client) do(method, path string, body, out interface{}) error {
+	return c.send(method, path, nil, body, out)
+}
+
+// send is do with extra request headers
+func (c *client) send(method, path string, header http.Header, body, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %w", err)
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, c.server+path, reader)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if c.tenant != "" {
+		req.Header.Set(tenant.Header, c.tenant)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("X-API-Key", c.apiKey)
+	} else if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call fleet server: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr struct {
+			Error string `json:"error"`
+		}
+		json.NewDecoder(resp.Body).Decode(&apiErr)
+		return &apiError{Status: resp.Status, StatusCode: resp.StatusCode, Message: apiErr.Error}
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return nil
+}
+
+// printApprovals writes approval requests as a table
+func printApprovals(requests []approvalRequest) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tROLLOUT\tPHASE\tROLE\tVOTES\tSTATUS\tEXPIRES")
+	for _, r := range requests {
+		approvals := 0
+		for _, d := range r.Decisions {
+			if d.Approved {
+				approvals++
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%d/%d of %d\t%s\t%s\n",
+			r.ID, r.RolloutID, r.PhaseID, r.Role, approvals, r.Quorum, r.Eligible, r.Status, r.ExpiresAt.Format(time.RFC3339))
+	}
+	w.Flush()
+}
+
+// Helper functions
+
+func envOr(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"sigs.k8s.io/yaml"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// planSnippet is a rollout file entry referencing a freshly published
+// artifact; it only sets the fields a new rollout needs
+type planSnippet struct {
+	ID           string         `json:"id"`
+	Name         string         `json:"name"`
+	Version      string         `json:"version"`
+	ArtifactName string         `json:"artifactName"`
+	TargetGroups []string       `json:"targetGroups"`
+	Phases       []phaseSnippet `json:"phases"`
+
+	TargetRegions []string `json:"targetRegions,omitempty"`
+	BusinessHours string   `json:"businessHours,omitempty"`
+}
+
+// phaseSnippet is a rollout phase in a planSnippet
+type phaseSnippet struct {
+	ID              string   `json:"id"`
+	Percentage      float64  `json:"percentage"`
+	Duration        string   `json:"duration"`
+	RequireApproval bool     `json:"requireApproval,omitempty"`
+	Regions         []string `json:"regions,omitempty"`
+}
+
+// runPackage tars a directory, then hashes, signs, uploads and registers it as
+// an artifact, and prints a rollout snippet for `rollout plan`/`rollout apply`
+func runPackage(args []string) error {
+	fs := flag.NewFlagSet("package", flag.ExitOnError)
+	dir := fs.String("dir", "", "directory to package")
+	name := fs.String("name", "", "artifact name")
+	version := fs.String("version", "", "artifact version")
+	bucket := fs.String("bucket", os.Getenv("FLEET_ARTIFACT_BUCKET"), "artifact S3 bucket")
+	table := fs.String("table", envOr("FLEET_ARTIFACT_TABLE", "edge-artifacts"), "artifact registry table")
+	signingKey := fs.String("signing-key", "", "PEM-encoded PKCS#8 Ed25519 private key")
+	keyID := fs.String("key-id", "", "identifier recorded for the signing key")
+	kmsKey := fs.String("kms-key", "", "sign with this KMS key or alias instead of a local key")
+	groups := fs.String("groups", "", "comma-separated target groups for the rollout snippet")
+	phases := fs.String("phases", "10,50,100", "comma-separated phase percentages for the rollout snippet")
+	phaseDuration := fs.String("phase-duration", "1h", "duration of each phase in the rollout snippet")
+	regions := fs.String("regions", "", "comma-separated region=timezone pairs; the snippet rolls out region by region")
+	businessHours := fs.String("business-hours", "", "device-local hours (08:00-18:00) to avoid; orders -regions follow-the-sun")
+	user := fs.String("user", envOr("FLEET_USER", os.Getenv("USER")), "identity recorded as publisher")
+	tenantID := fs.String("tenant", os.Getenv("FLEET_TENANT"), "tenant that owns the artifact")
+	fs.Parse(args)
+
+	if *dir == "" || *name == "" || *version == "" {
+		return fmt.Errorf("-dir, -name and -version are required")
+	}
+
+	snippetPhases, err := parsePhases(*phases, *phaseDuration)
+	if err != nil {
+		return err
+	}
+
+	targetRegions, err := orderRegions(*regions, *businessHours)
+	if err != nil {
+		return err
+	}
+	snippetPhases = regionalPhases(snippetPhases, targetRegions, *phaseDuration)
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	signer, err := loadSigner(ctx, cfg, *kmsKey, *signingKey, *keyID)
+	if err != nil {
+		return err
+	}
+
+	// The archive name becomes the object name under the versioned key
+	workDir, err := os.MkdirTemp("", "fleetctl-package-")
+	if err != nil {
+		return fmt.Errorf("failed to create work directory: %w", err)
+	}
+	defer os.RemoveAll(workDir)
+
+	archivePath := filepath.Join(workDir, fmt.Sprintf("%s-%s.tar.gz", *name, *version))
+	if err := tarDirectory(*dir, archivePath); err != nil {
+		return err
+	}
+
+	p, err := publisher.NewPublisher(publisher.PublisherConfig{
+		S3Client:          s3.NewFromConfig(cfg),
+		DynamoClient:      dynamodb.NewFromConfig(cfg),
+		BucketName:        *bucket,
+		ArtifactTableName: *table,
+		TenantID:          *tenantID,
+		Signer:            signer,
+	})
+	if err != nil {
+		return err
+	}
+
+	artifact, err := p.Publish(ctx, publisher.PublishInput{
+		Name:        *name,
+		Version:     *version,
+		FilePath:    archivePath,
+		PublishedBy: *user,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(os.Stderr, "Published %s@%s (%d bytes, sha256 %s) to %s\n", *name, *version, artifact.Size, artifact.SHA256, artifact.URL)
+
+	snippet := struct {
+		Rollouts []planSnippet `json:"rollouts"`
+	}{
+		Rollouts: []planSnippet{{
+			ID:           fmt.Sprintf("%s-%s", *name, *version),
+			Name:         fmt.Sprintf("Roll out %s %s", *name, *version),
+			Version:      *version,
+			ArtifactName: *name,
+			TargetGroups: splitList(*groups),
+			Phases:       snippetPhases,
+
+			TargetRegions: targetRegions,
+			BusinessHours: *businessHours,
+		}},
+	}
+
+	data, err := yaml.Marshal(snippet)
+	if err != nil {
+		return fmt.Errorf("failed to render rollout snippet: %w", err)
+	}
+
+	_, err = os.Stdout.Write(data)
+	return err
+}
+
+// tarDirectory writes the regular files and directories under dir to a gzipped tar
+func tarDirectory(dir, archivePath string) error {
+	file, err := os.Create(archivePath)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %w", err)
+	}
+	defer file.Close()
+
+	gz := gzip.NewWriter(file)
+	tw := tar.NewWriter(gz)
+
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil || rel == "." {
+			return err
+		}
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return fmt.Errorf("%s is not a regular file or directory", rel)
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(rel)
+		// Archives of the same tree should hash the same wherever they are built
+		header.Uid, header.Gid, header.Uname, header.Gname = 0, 0, "", ""
+		header.ModTime = time.Unix(0, 0)
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to archive %s: %w", dir, err)
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to finish archive: %w", err)
+	}
+
+	return nil
+}
+
+// parsePhases builds snippet phases from comma-separated percentages
+func parsePhases(percentages, duration string) ([]phaseSnippet, error) {
+	var phases []phaseSnippet
+	for i, value := range splitList(percentages) {
+		percentage, err := strconv.ParseFloat(value, 64)
+		if err != nil || percentage <= 0 || percentage > 100 {
+			return nil, fmt.Errorf("invalid phase percentage %q", value)
+		}
+		phases = append(phases, phaseSnippet{
+			ID:         fmt.Sprintf("phase-%d", i+1),
+			Percentage: percentage,
+			Duration:   duration,
+		})
+	}
+
+	if len(phases) == 0 {
+		return nil, fmt.Errorf("at least one phase is required")
+	}
+
+	return phases, nil
+}
+
+// orderRegions parses region=timezone pairs and returns the regions in
+// follow-the-sun order from now when business hours are given, otherwise as listed
+func orderRegions(value, businessHours string) ([]string, error) {
+	var regions []string
+	timezones := make(map[string]string)
+	for _, pair := range splitList(value) {
+		region, timezone, ok := strings.Cut(pair, "=")
+		if !ok || region == "" || timezone == "" {
+			return nil, fmt.Errorf("invalid region %q: want region=timezone", pair)
+		}
+		if _, err := time.LoadLocation(timezone); err != nil {
+			return nil, fmt.Errorf("unknown timezone %q for region %s: %w", timezone, region, err)
+		}
+		regions = append(regions, region)
+		timezones[region] = timezone
+	}
+
+	if len(regions) == 0 || businessHours == "" {
+		return regions, nil
+	}
+	return rollout.FollowTheSun(timezones, businessHours, time.Now())
+}
+
+// regionalPhases ramps the percentage phases up in the first region, then
+// adds a full phase for each further region
+func regionalPhases(phases []phaseSnippet, regions []string, duration string) []phaseSnippet {
+	if len(regions) == 0 {
+		return phases
+	}
+
+	phases[0].Regions = regions[:1]
+	for i, region := range regions[1:] {
+		phases = append(phases, phaseSnippet{
+			ID:         fmt.Sprintf("region-%d-%s", i+2, region),
+			Percentage: 100,
+			Duration:   duration,
+			Regions:    []string{region},
+		})
+	}
+	return phases
+}
+
+// splitList splits a comma-separated flag value, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/keymanager"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/reporting"
+)
+
+// runPublish uploads and registers a built artifact
+func runPublish(args []string) error {
+	fs := flag.NewFlagSet("publish", flag.ExitOnError)
+	name := fs.String("name", "", "artifact name")
+	version := fs.String("version", "", "artifact version")
+	file := fs.String("file", "", "path to the built artifact")
+	bucket := fs.String("bucket", os.Getenv("FLEET_ARTIFACT_BUCKET"), "artifact S3 bucket")
+	table := fs.String("table", envOr("FLEET_ARTIFACT_TABLE", "edge-artifacts"), "artifact registry table")
+	signingKey := fs.String("signing-key", "", "PEM-encoded PKCS#8 Ed25519 private key")
+	keyID := fs.String("key-id", "", "identifier recorded for the signing key")
+	kmsKey := fs.String("kms-key", "", "sign with this KMS key or alias instead of a local key")
+	user := fs.String("user", envOr("FLEET_USER", os.Getenv("USER")), "identity recorded as publisher")
+	tenantID := fs.String("tenant", os.Getenv("FLEET_TENANT"), "tenant that owns the artifact")
+	fs.Parse(args)
+
+	if *name == "" || *version == "" || *file == "" {
+		return fmt.Errorf("-name, -version and -file are required")
+	}
+
+	ctx := context.Background()
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	signer, err := loadSigner(ctx, cfg, *kmsKey, *signingKey, *keyID)
+	if err != nil {
+		return err
+	}
+
+	p, err := publisher.NewPublisher(publisher.PublisherConfig{
+		S3Client:          s3.NewFromConfig(cfg),
+		DynamoClient:      dynamodb.NewFromConfig(cfg),
+		BucketName:        *bucket,
+		ArtifactTableName: *table,
+		TenantID:          *tenantID,
+		Signer:            signer,
+	})
+	if err != nil {
+		return err
+	}
+
+	artifact, err := p.Publish(ctx, publisher.PublishInput{
+		Name:        *name,
+		Version:     *version,
+		FilePath:    *file,
+		PublishedBy: *user,
+	})
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(artifact)
+}
+
+// loadSigner returns the KMS or local signer selected by the publish flags, or nil to publish unsigned
+func loadSigner(ctx context.Context, cfg aws.Config, kmsKey, signingKey, keyID string) (publisher.Signer, error) {
+	if kmsKey != "" {
+		km, err := keymanager.NewKeyManager(keymanager.KeyManagerConfig{KMSClient: kms.NewFromConfig(cfg), KeyAlias: kmsKey})
+		if err != nil {
+			return nil, err
+		}
+		return km.Signer(ctx)
+	}
+
+	if signingKey != "" {
+		privateKey, err := loadSigningKey(signingKey)
+		if err != nil {
+			return nil, err
+		}
+		return reporting.NewEd25519Signer(privateKey, keyID), nil
+	}
+
+	return nil, nil
+}
+
+// loadSigningKey reads a PEM-encoded PKCS#8 Ed25519 private key
+func loadSigningKey(path string) (ed25519.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("signing key is not PEM encoded")
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	privateKey, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("signing key is not an Ed25519 key")
+	}
+
+	return privateKey, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// deviceRecord mirrors the fleet server's device representation
+type deviceRecord struct {
+	DeviceID          string `json:"deviceId"`
+	DeviceGroup       string `json:"deviceGroup"`
+	Region            string `json:"region"`
+	CurrentVersion    string `json:"currentVersion"`
+	UpdateStatus      string `json:"updateStatus"`
+	LastUpdateTime    string `json:"lastUpdateTime"`
+	LastUpdateMessage string `json:"lastUpdateMessage"`
+}
+
+// groupSkew mirrors the fleet server's version skew representation
+type groupSkew struct {
+	Group         string         `json:"group"`
+	TotalDevices  int            `json:"totalDevices"`
+	Versions      map[string]int `json:"versions"`
+	LatestVersion string         `json:"latestVersion"`
+	BehindLatest  int            `json:"behindLatest"`
+}
+
+// runDevices lists devices on a version
+func runDevices(args []string) error {
+	fs := flag.NewFlagSet("devices", flag.ExitOnError)
+	server := serverFlag(fs)
+	version := fs.String("version", "", "version to list devices for")
+	fs.Parse(args)
+
+	if *version == "" {
+		return fmt.Errorf("-version is required")
+	}
+
+	var devices []deviceRecord
+	if err := newClient(*server).do(http.MethodGet, "/api/devices?version="+url.QueryEscape(*version), nil, &devices); err != nil {
+		return err
+	}
+
+	printDevices(devices)
+	return nil
+}
+
+// runFailed lists devices that failed a rollout
+func runFailed(args []string) error {
+	fs := flag.NewFlagSet("failed", flag.ExitOnError)
+	server := serverFlag(fs)
+	rolloutID := fs.String("rollout", "", "rollout ID")
+	fs.Parse(args)
+
+	if *rolloutID == "" {
+		return fmt.Errorf("-rollout is required")
+	}
+
+	var devices []deviceRecord
+	if err := newClient(*server).do(http.MethodGet, "/api/rollouts/"+url.PathEscape(*rolloutID)+"/failed-devices", nil, &devices); err != nil {
+		return err
+	}
+
+	printDevices(devices)
+	return nil
+}
+
+// runSkew prints the version skew of one or more device groups
+func runSkew(args []string) error {
+	fs := flag.NewFlagSet("skew", flag.ExitOnError)
+	server := serverFlag(fs)
+	groups := fs.String("groups", "", "comma-separated device groups")
+	fs.Parse(args)
+
+	if *groups == "" {
+		return fmt.Errorf("-groups is required")
+	}
+
+	c := newClient(*server)
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "GROUP\tDEVICES\tLATEST\tBEHIND\tVERSIONS")
+	for _, group := range strings.Split(*groups, ",") {
+		var skew groupSkew
+		if err := c.do(http.MethodGet, "/api/groups/"+url.PathEscape(strings.TrimSpace(group))+"/skew", nil, &skew); err != nil {
+			return err
+		}
+
+		versions := make([]string, 0, len(skew.Versions))
+		for version, count := range skew.Versions {
+			versions = append(versions, fmt.Sprintf("%s=%d", version, count))
+		}
+		sort.Strings(versions)
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%d\t%s\n", skew.Group, skew.TotalDevices, skew.LatestVersion, skew.BehindLatest, strings.Join(versions, " "))
+	}
+	return w.Flush()
+}
+
+// printDevices writes device records as a table
+func printDevices(devices []deviceRecord) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "DEVICE\tGROUP\tREGION\tVERSION\tSTATUS\tLAST UPDATE\tMESSAGE")
+	for _, d := range devices {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+			d.DeviceID, d.DeviceGroup, d.Region, d.CurrentVersion, d.UpdateStatus, d.LastUpdateTime, d.LastUpdateMessage)
+	}
+	w.Flush()
+}
+
+// Helper functions
+
+func serverFlag(fs *flag.FlagSet) *string {
+	return fs.String("server", envOr("FLEET_SERVER", "http://localhost:8080"), "fleet server URL")
+}
+
+func newClient(server string) *client {
+	return &client{
+		server:     strings.TrimSuffix(server, "/"),
+		tenant:     os.Getenv("FLEET_TENANT"),
+		apiKey:     os.Getenv("FLEET_API_KEY"),
+		token:      os.Getenv("FLEET_TOKEN"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+}
@@ -0,0 +1,532 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"sigs.k8s.io/yaml"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// rolloutFile is a declarative set of rollout definitions, in YAML or JSON
+// using the same field names as the fleet API
+type rolloutFile struct {
+	Rollouts []rollout.RolloutPlan `json:"rollouts"`
+}
+
+// rolloutCost is the fleet server's usage and cost estimate for a rollout
+type rolloutCost struct {
+	RolloutID      string  `json:"rolloutId"`
+	Devices        int     `json:"devices"`
+	S3Bytes        int64   `json:"s3Bytes"`
+	ReadUnits      float64 `json:"readUnits"`
+	WriteUnits     float64 `json:"writeUnits"`
+	S3TransferCost float64 `json:"s3TransferCost"`
+	S3RequestCost  float64 `json:"s3RequestCost"`
+	DynamoCost     float64 `json:"dynamoCost"`
+	TotalCost      float64 `json:"totalCost"`
+	Currency       string  `json:"currency"`
+}
+
+// rolloutChange is the planned action for one rollout in the file
+type rolloutChange struct {
+	desired rollout.RolloutPlan
+	current *rollout.RolloutPlan // nil when the rollout does not exist yet
+	diffs   []string
+}
+
+// runRollout dispatches the rollout subcommands
+func runRollout(args []string) error {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	switch args[0] {
+	case "create":
+		return runRolloutCreate(args[1:])
+	case "templates":
+		return runRolloutTemplates(args[1:])
+	case "cost":
+		return runRolloutCost(args[1:])
+	case "plan", "apply":
+	default:
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("rollout "+args[0], flag.ExitOnError)
+	server := serverFlag(fs)
+	file := fs.String("f", "", "rollout definition file")
+	autoApprove := fs.Bool("auto-approve", false, "apply without asking for confirmation")
+	fs.Parse(args[1:])
+
+	if *file == "" {
+		return fmt.Errorf("-f is required")
+	}
+
+	desired, err := loadRolloutFile(*file)
+	if err != nil {
+		return err
+	}
+
+	c := newClient(*server)
+	changes, err := planRollouts(c, desired)
+	if err != nil {
+		return err
+	}
+
+	pending := printPlan(changes)
+	if args[0] == "plan" || pending == 0 {
+		return nil
+	}
+
+	if !*autoApprove && !confirm("Apply these changes? Only 'yes' will be accepted: ") {
+		return fmt.Errorf("apply cancelled")
+	}
+
+	for _, change := range changes {
+		if err := applyChange(c, change); err != nil {
+			return err
+		}
+	}
+
+	fmt.Printf("Apply complete: %d rollout(s) changed.\n", pending)
+	return nil
+}
+
+// runRolloutCreate creates a rollout from a server-side template
+func runRolloutCreate(args []string) error {
+	fs := flag.NewFlagSet("rollout create", flag.ExitOnError)
+	server := serverFlag(fs)
+	templateName := fs.String("template", "", "template name (see rollout templates)")
+	id := fs.String("id", "", "rollout ID (generated when empty)")
+	params := paramFlag{}
+	fs.Var(params, "set", "template parameter as name=value (repeatable)")
+	fs.Parse(args)
+
+	if *templateName == "" {
+		return fmt.Errorf("-template is required")
+	}
+
+	var created rollout.RolloutPlan
+	err := newClient(*server).do(http.MethodPost, "/api/templates/"+url.PathEscape(*templateName)+"/rollouts", map[string]interface{}{
+		"id":         *id,
+		"parameters": params,
+	}, &created)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("rollout %q created from template %s\n", created.ID, *templateName)
+	for _, diff := range describeNew(created) {
+		fmt.Printf("    %s\n", diff)
+	}
+	return nil
+}
+
+// runRolloutTemplates lists the server's rollout templates
+func runRolloutTemplates(args []string) error {
+	fs := flag.NewFlagSet("rollout templates", flag.ExitOnError)
+	server := serverFlag(fs)
+	fs.Parse(args)
+
+	var templates []rollout.RolloutTemplate
+	if err := newClient(*server).do(http.MethodGet, "/api/templates", nil, &templates); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TEMPLATE\tPHASES\tREQUIRED\tOPTIONAL\tDESCRIPTION")
+	for _, t := range templates {
+		phases := make([]string, 0, len(t.Plan.Phases))
+		for _, phase := range t.Plan.Phases {
+			phases = append(phases, fmt.Sprintf("%g%%", phase.Percentage))
+		}
+		optional := make([]string, 0, len(t.Defaults))
+		for name := range t.Defaults {
+			optional = append(optional, name)
+		}
+		sort.Strings(optional)
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", t.Name, strings.Join(phases, ","), strings.Join(t.Parameters, ","), strings.Join(optional, ","), t.Description)
+	}
+	return w.Flush()
+}
+
+// runRolloutCost prints the usage and estimated cost of a rollout
+func runRolloutCost(args []string) error {
+	fs := flag.NewFlagSet("rollout cost", flag.ExitOnError)
+	server := serverFlag(fs)
+	id := fs.String("id", "", "rollout ID")
+	fs.Parse(args)
+
+	if *id == "" {
+		return fmt.Errorf("-id is required")
+	}
+
+	var cost rolloutCost
+	if err := newClient(*server).do(http.MethodGet, "/api/rollouts/"+url.PathEscape(*id)+"/cost", nil, &cost); err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Devices reporting:\t%d\n", cost.Devices)
+	fmt.Fprintf(w, "S3 transfer:\t%.2f MiB\t%.4f %s\n", float64(cost.S3Bytes)/(1<<20), cost.S3TransferCost, cost.Currency)
+	fmt.Fprintf(w, "S3 requests:\t%d\t%.4f %s\n", cost.Devices, cost.S3RequestCost, cost.Currency)
+	fmt.Fprintf(w, "DynamoDB:\t%.1f read / %.1f write units\t%.4f %s\n", cost.ReadUnits, cost.WriteUnits, cost.DynamoCost, cost.Currency)
+	fmt.Fprintf(w, "Total:\t\t%.4f %s\n", cost.TotalCost, cost.Currency)
+	return w.Flush()
+}
+
+// loadRolloutFile reads and validates a rollout definition file
+func loadRolloutFile(path string) ([]rollout.RolloutPlan, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rollout file: %w", err)
+	}
+
+	data, err = yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse rollout file: %w", err)
+	}
+
+	var file rolloutFile
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("failed to parse rollout file: %w", err)
+	}
+
+	seen := make(map[string]bool)
+	for i, plan := range file.Rollouts {
+		if plan.ID == "" {
+			return nil, fmt.Errorf("rollout %d: id is required so it can be matched to the server", i)
+		}
+		if seen[plan.ID] {
+			return nil, fmt.Errorf("rollout %s is defined twice", plan.ID)
+		}
+		seen[plan.ID] = true
+	}
+
+	return file.Rollouts, nil
+}
+
+// planRollouts fetches the current state of each rollout and diffs it against the file
+func planRollouts(c *client, desired []rollout.RolloutPlan) ([]rolloutChange, error) {
+	changes := make([]rolloutChange, 0, len(desired))
+
+	for _, plan := range desired {
+		change := rolloutChange{desired: plan}
+
+		var current rollout.RolloutPlan
+		err := c.do(http.MethodGet, "/api/rollouts/"+url.PathEscape(plan.ID), nil, &current)
+		var apiErr *apiError
+		switch {
+		case errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusNotFound:
+			change.diffs = describeNew(plan)
+		case err != nil:
+			return nil, fmt.Errorf("failed to read rollout %s: %w", plan.ID, err)
+		default:
+			change.current = &current
+			change.diffs = diffRollout(current, plan)
+		}
+
+		changes = append(changes, change)
+	}
+
+	return changes, nil
+}
+
+// printPlan writes the planned changes and returns how many rollouts would change
+func printPlan(changes []rolloutChange) int {
+	created, updated := 0, 0
+
+	for _, change := range changes {
+		if len(change.diffs) == 0 {
+			continue
+		}
+
+		if change.current == nil {
+			created++
+			fmt.Printf("+ rollout %q will be created\n", change.desired.ID)
+		} else {
+			updated++
+			fmt.Printf("~ rollout %q will be updated (%s, phase %d of %d)\n",
+				change.desired.ID, change.current.Status, change.current.CurrentPhase+1, len(change.current.Phases))
+		}
+		for _, diff := range change.diffs {
+			fmt.Printf("    %s\n", diff)
+		}
+		fmt.Println()
+	}
+
+	if created+updated == 0 {
+		fmt.Println("No changes. Rollouts match the definition file.")
+		return 0
+	}
+
+	fmt.Printf("Plan: %d to create, %d to update.\n", created, updated)
+	return created + updated
+}
+
+// applyChange creates or updates one rollout; updates fail if the rollout
+// changed on the server after it was planned
+func applyChange(c *client, change rolloutChange) error {
+	if len(change.diffs) == 0 {
+		return nil
+	}
+
+	var result rollout.RolloutPlan
+	if change.current == nil {
+		if err := c.do(http.MethodPost, "/api/rollouts", change.desired, &result); err != nil {
+			return fmt.Errorf("failed to create rollout %s: %w", change.desired.ID, err)
+		}
+		fmt.Printf("rollout %q: created\n", result.ID)
+		return nil
+	}
+
+	header := http.Header{}
+	header.Set("If-Unmodified-Since", change.current.UpdatedAt.Format(time.RFC3339Nano))
+	if change.current.Revision != 0 {
+		header.Set("If-Match", strconv.Quote(strconv.FormatInt(change.current.Revision, 10)))
+	}
+	if err := c.send(http.MethodPut, "/api/rollouts/"+url.PathEscape(change.desired.ID), header, change.desired, &result); err != nil {
+		return fmt.Errorf("failed to update rollout %s: %w", change.desired.ID, err)
+	}
+	fmt.Printf("rollout %q: updated\n", result.ID)
+	return nil
+}
+
+// describeNew lists the definition of a rollout that will be created
+func describeNew(plan rollout.RolloutPlan) []string {
+	diffs := []string{
+		fmt.Sprintf("+ version: %q", plan.Version),
+		fmt.Sprintf("+ targetGroups: %s", strings.Join(plan.TargetGroups, ", ")),
+	}
+	if plan.IsConfigOnly() {
+		diffs = append(diffs, fmt.Sprintf("+ configPayload: %d bytes", len(plan.ConfigPayload)))
+	}
+	for _, phase := range plan.Phases {
+		diffs = append(diffs, "+ phase "+describePhase(phase))
+	}
+	return diffs
+}
+
+// diffRollout lists the differences between the server's rollout and the file
+func diffRollout(current, desired rollout.RolloutPlan) []string {
+	diffs := make([]string, 0)
+
+	fields := []struct {
+		name          string
+		before, after string
+	}{
+		{"name", current.Name, desired.Name},
+		{"description", current.Description, desired.Description},
+		{"version", current.Version, desired.Version},
+		{"artifactName", current.ArtifactName, desired.ArtifactName},
+		{"packageUrl", current.PackageURL, desired.PackageURL},
+		{"packageHash", current.PackageHash, desired.PackageHash},
+		{"configPayload", current.ConfigPayload, desired.ConfigPayload},
+		{"rollbackPlan", current.RollbackPlan, desired.RollbackPlan},
+		{"scheduledStart", current.ScheduledStart, desired.ScheduledStart},
+		{"scheduleTimezone", current.ScheduleTimezone, desired.ScheduleTimezone},
+		{"blackoutDates", strings.Join(current.BlackoutDates, ", "), strings.Join(desired.BlackoutDates, ", ")},
+		{"minHealthScore", fmt.Sprint(current.MinHealthScore), fmt.Sprint(desired.MinHealthScore)},
+		{"targetRegions", strings.Join(current.TargetRegions, ", "), strings.Join(desired.TargetRegions, ", ")},
+		{"businessHours", current.BusinessHours, desired.BusinessHours},
+		{"pollInterval", current.PollInterval, desired.PollInterval},
+		{"cohortBy", current.CohortBy, desired.CohortBy},
+		{"packageFormat", current.PackageFormat, desired.PackageFormat},
+		{"schemaVersion", fmt.Sprint(current.SchemaVersion), fmt.Sprint(desired.SchemaVersion)},
+		{"artifacts", describeArtifacts(current.Artifacts), describeArtifacts(desired.Artifacts)},
+		{"packages", describePackages(current.Packages), describePackages(desired.Packages)},
+	}
+	for _, field := range fields {
+		if field.before != field.after {
+			diffs = append(diffs, fmt.Sprintf("~ %s: %q -> %q", field.name, field.before, field.after))
+		}
+	}
+
+	added, removed := setDiff(current.TargetGroups, desired.TargetGroups)
+	for _, group := range added {
+		diffs = append(diffs, "+ targetGroup: "+group)
+	}
+	for _, group := range removed {
+		diffs = append(diffs, "- targetGroup: "+group)
+	}
+
+	return append(diffs, diffPhases(current, desired)...)
+}
+
+// diffPhases matches phases by ID and flags changes to phases that have
+// already started, which the server will reject
+func diffPhases(current, desired rollout.RolloutPlan) []string {
+	diffs := make([]string, 0)
+
+	started := make(map[string]bool)
+	existing := make(map[string]rollout.RolloutPhase)
+	for i, phase := range current.Phases {
+		existing[phase.ID] = phase
+		started[phase.ID] = current.Status == "in-progress" && i <= current.CurrentPhase
+	}
+
+	wanted := make(map[string]bool)
+	for _, phase := range desired.Phases {
+		wanted[phase.ID] = true
+
+		before, ok := existing[phase.ID]
+		if !ok {
+			diffs = append(diffs, "+ phase "+describePhase(phase))
+			continue
+		}
+
+		changed := make([]string, 0)
+		if before.Percentage != phase.Percentage {
+			changed = append(changed, fmt.Sprintf("percentage %g -> %g", before.Percentage, phase.Percentage))
+		}
+		if before.Duration != phase.Duration {
+			changed = append(changed, fmt.Sprintf("duration %q -> %q", before.Duration, phase.Duration))
+		}
+		if before.RequireApproval != phase.RequireApproval {
+			changed = append(changed, fmt.Sprintf("requireApproval %t -> %t", before.RequireApproval, phase.RequireApproval))
+		}
+		if strings.Join(before.Metrics, ",") != strings.Join(phase.Metrics, ",") {
+			changed = append(changed, fmt.Sprintf("metrics [%s] -> [%s]", strings.Join(before.Metrics, ", "), strings.Join(phase.Metrics, ", ")))
+		}
+		if !reflect.DeepEqual(before.Thresholds, phase.Thresholds) && (len(before.Thresholds) > 0 || len(phase.Thresholds) > 0) {
+			changed = append(changed, fmt.Sprintf("thresholds %v -> %v", before.Thresholds, phase.Thresholds))
+		}
+		if before.Window != phase.Window {
+			changed = append(changed, fmt.Sprintf("window %q -> %q", before.Window, phase.Window))
+		}
+		if before.Gate != phase.Gate {
+			changed = append(changed, fmt.Sprintf("gate %q -> %q", before.Gate, phase.Gate))
+		}
+		if strings.Join(before.Regions, ",") != strings.Join(phase.Regions, ",") {
+			changed = append(changed, fmt.Sprintf("regions [%s] -> [%s]", strings.Join(before.Regions, ", "), strings.Join(phase.Regions, ", ")))
+		}
+
+		if len(changed) > 0 {
+			diff := fmt.Sprintf("~ phase %q: %s", phase.ID, strings.Join(changed, ", "))
+			if started[phase.ID] {
+				diff += " (already started; will be rejected)"
+			}
+			diffs = append(diffs, diff)
+		}
+	}
+
+	for _, phase := range current.Phases {
+		if wanted[phase.ID] {
+			continue
+		}
+		diff := fmt.Sprintf("- phase %q", phase.ID)
+		if started[phase.ID] {
+			diff += " (already started; will be rejected)"
+		}
+		diffs = append(diffs, diff)
+	}
+
+	return diffs
+}
+
+// Helper functions
+
+func describePhase(phase rollout.RolloutPhase) string {
+	description := fmt.Sprintf("%q (%g%%", phase.ID, phase.Percentage)
+	if phase.Duration != "" {
+		description += ", " + phase.Duration
+	}
+	if phase.RequireApproval {
+		description += ", requires approval"
+	}
+	return description + ")"
+}
+
+// describeArtifacts summarizes a bundle's artifacts, e.g. "app=api@1.2.0, model=s3://..."
+func describeArtifacts(artifacts []rollout.BundleArtifact) string {
+	descriptions := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		source := artifact.PackageURL
+		if artifact.ArtifactName != "" {
+			source = artifact.ArtifactName
+		}
+		if artifact.Version != "" {
+			source += "@" + artifact.Version
+		}
+		if len(artifact.DependsOn) > 0 {
+			source += " after " + strings.Join(artifact.DependsOn, "+")
+		}
+		descriptions = append(descriptions, artifact.Kind+"="+source)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// describePackages summarizes a multi-architecture rollout's packages, e.g. "arm64=app@1.2.0-arm64, amd64=s3://..."
+func describePackages(packages []rollout.ArchPackage) string {
+	descriptions := make([]string, 0, len(packages))
+	for _, pkg := range packages {
+		source := pkg.PackageURL
+		if pkg.ArtifactName != "" {
+			source = pkg.ArtifactName
+		}
+		descriptions = append(descriptions, pkg.Architecture+"="+source)
+	}
+	return strings.Join(descriptions, ", ")
+}
+
+// setDiff returns the values only in after and the values only in before
+func setDiff(before, after []string) (added, removed []string) {
+	inBefore := make(map[string]bool, len(before))
+	for _, value := range before {
+		inBefore[value] = true
+	}
+	inAfter := make(map[string]bool, len(after))
+	for _, value := range after {
+		inAfter[value] = true
+		if !inBefore[value] {
+			added = append(added, value)
+		}
+	}
+	for _, value := range before {
+		if !inAfter[value] {
+			removed = append(removed, value)
+		}
+	}
+	return added, removed
+}
+
+// paramFlag collects repeated -set name=value flags
+type paramFlag map[string]string
+
+func (p paramFlag) String() string {
+	return fmt.Sprint(map[string]string(p))
+}
+
+func (p paramFlag) Set(value string) error {
+	name, v, ok := strings.Cut(value, "=")
+	if !ok || name == "" {
+		return fmt.Errorf("expected name=value, got %q", value)
+	}
+	p[name] = v
+	return nil
+}
+
+func confirm(prompt string) bool {
+	fmt.Print(prompt)
+	answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+	return strings.TrimSpace(answer) == "yes"
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"text/tabwriter"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// deviceShadow mirrors the fleet server's device shadow representation
+type deviceShadow struct {
+	DeviceID string                `json:"deviceId"`
+	Desired  *rollout.DesiredState `json:"desired"`
+	Reported struct {
+		CurrentVersion    string `json:"currentVersion"`
+		ConfigVersion     string `json:"configVersion"`
+		UpdateStatus      string `json:"updateStatus"`
+		LastUpdateID      string `json:"lastUpdateId"`
+		LastUpdateMessage string `json:"lastUpdateMessage"`
+		LastSeen          string `json:"lastSeen"`
+	} `json:"reported"`
+	State string `json:"state"`
+}
+
+// runShadow dispatches the shadow subcommands
+func runShadow(args []string) error {
+	if len(args) < 1 {
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet("shadow "+args[0], flag.ExitOnError)
+	server := serverFlag(fs)
+	user := fs.String("user", envOr("FLEET_USER", os.Getenv("USER")), "identity recorded as the actor")
+	device := fs.String("device", "", "device ID")
+	version := fs.String("version", "", "desired version")
+	artifact := fs.String("artifact", "", "registry artifact to install")
+	packageURL := fs.String("url", "", "package URL to install")
+	packageHash := fs.String("hash", "", "SHA-256 of the package at -url")
+	configFile := fs.String("config", "", "config payload file to apply instead of a package")
+	reason := fs.String("reason", "", "why the device is set apart from fleet rollouts")
+	fs.Parse(args[1:])
+
+	if *device == "" {
+		return fmt.Errorf("-device is required")
+	}
+
+	c := newClient(*server)
+	path := "/api/devices/" + url.PathEscape(*device) + "/shadow"
+
+	switch args[0] {
+	case "get":
+		var shadow deviceShadow
+		if err := c.do(http.MethodGet, path, nil, &shadow); err != nil {
+			return err
+		}
+		printShadow(shadow)
+		return nil
+
+	case "set":
+		if *version == "" {
+			return fmt.Errorf("-version is required")
+		}
+		desired := rollout.DesiredState{
+			Version:      *version,
+			PackageURL:   *packageURL,
+			PackageHash:  *packageHash,
+			ArtifactName: *artifact,
+			SetBy:        *user,
+			Reason:       *reason,
+		}
+		if *configFile != "" {
+			data, err := os.ReadFile(*configFile)
+			if err != nil {
+				return fmt.Errorf("failed to read config file: %w", err)
+			}
+			desired.ConfigPayload = string(data)
+		}
+
+		var stored rollout.DesiredState
+		if err := c.do(http.MethodPut, path+"/desired", desired, &stored); err != nil {
+			return err
+		}
+		fmt.Println(stored.ID)
+		return nil
+
+	case "clear":
+		var result map[string]string
+		if err := c.do(http.MethodDelete, path+"/desired", nil, &result); err != nil {
+			return err
+		}
+		fmt.Println(result["status"])
+		return nil
+	}
+
+	usage()
+	os.Exit(2)
+	return nil
+}
+
+func printShadow(shadow deviceShadow) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Device:\t%s\n", shadow.DeviceID)
+	fmt.Fprintf(w, "State:\t%s\n", shadow.State)
+	if shadow.Desired != nil {
+		fmt.Fprintf(w, "Desired:\t%s (%s, set by %s)\n", shadow.Desired.Version, shadow.Desired.ID, shadow.Desired.SetBy)
+	} else {
+		fmt.Fprintln(w, "Desired:\tnone, following fleet rollouts")
+	}
+	fmt.Fprintf(w, "Reported:\t%s\n", shadow.Reported.CurrentVersion)
+	if shadow.Reported.ConfigVersion != "" {
+		fmt.Fprintf(w, "Config:\t%s\n", shadow.Reported.ConfigVersion)
+	}
+	fmt.Fprintf(w, "Last update:\t%s %s %s\n", shadow.Reported.LastUpdateID, shadow.Reported.UpdateStatus, shadow.Reported.LastUpdateMessage)
+	fmt.Fprintf(w, "Last seen:\t%s\n", shadow.Reported.LastSeen)
+	w.Flush()
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/simulator"
+)
+
+func main() {
+	planFile := flag.String("plan", "", "path to a rollout plan JSON file")
+	devices := flag.Int("devices", 1000, "number of virtual devices")
+	groups := flag.String("groups", "default", "comma-separated device groups")
+	regions := flag.String("regions", "", "comma-separated device regions, for plans with regional phases")
+	initialVersion := flag.String("initial-version", "1.0.0", "version devices start on")
+	failureRate := flag.Float64("failure-rate", 0.01, "probability that an update fails")
+	syncBytes := flag.Int("sync-bytes", 0, "bytes uploaded per offline-sync cycle")
+	checkInterval := flag.Duration("check-interval", 5*time.Minute, "simulated rollout check interval")
+	syncInterval := flag.Duration("sync-interval", 15*time.Minute, "simulated offline-sync interval")
+	phaseDuration := flag.Duration("phase-duration", time.Hour, "simulated time spent in each phase")
+	autoApprove := flag.Bool("auto-approve", true, "approve phases that require approval")
+	concurrency := flag.Int("concurrency", 64, "concurrent virtual devices")
+	useDynamo := flag.Bool("dynamo", false, "send traffic to real DynamoDB tables instead of the in-memory store")
+	rolloutTable := flag.String("rollout-table", "edge-rollouts-sim", "rollout table used with -dynamo")
+	deviceTable := flag.String("device-table", "edge-devices-sim", "device table used with -dynamo")
+	flag.Parse()
+
+	if *planFile == "" {
+		log.Fatal("-plan is required")
+	}
+
+	data, err := os.ReadFile(*planFile)
+	if err != nil {
+		log.Fatalf("Failed to read plan: %v", err)
+	}
+
+	var plan rollout.RolloutPlan
+	if err := json.Unmarshal(data, &plan); err != nil {
+		log.Fatalf("Failed to parse plan: %v", err)
+	}
+	plan.Status = "in-progress"
+
+	ctx := context.Background()
+
+	var store simulator.Store = simulator.NewMemoryStore(plan)
+	if *useDynamo {
+		cfg, err := config.LoadDefaultConfig(ctx)
+		if err != nil {
+			log.Fatalf("Failed to load AWS config: %v", err)
+		}
+		store = simulator.NewDynamoStore(dynamodb.NewFromConfig(cfg), *rolloutTable, *deviceTable)
+	}
+
+	var deviceRegions []string
+	if *regions != "" {
+		deviceRegions = strings.Split(*regions, ",")
+	}
+
+	sim, err := simulator.NewSimulator(simulator.SimulatorConfig{
+		Store:          store,
+		Devices:        *devices,
+		Groups:         strings.Split(*groups, ","),
+		Regions:        deviceRegions,
+		InitialVersion: *initialVersion,
+		FailureRate:    *failureRate,
+		SyncBytes:      *syncBytes,
+		CheckInterval:  *checkInterval,
+		SyncInterval:   *syncInterval,
+		PhaseDuration:  *phaseDuration,
+		AutoApprove:    *autoApprove,
+		Concurrency:    *concurrency,
+		Seed:           time.Now().UnixNano(),
+	})
+	if err != nil {
+		log.Fatalf("Failed to create simulator: %v", err)
+	}
+
+	result, err := sim.Run(ctx, plan)
+	if err != nil {
+		log.Fatalf("Simulation failed: %v", err)
+	}
+
+	encoder := json.NewEncoder(os.Stdout)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(result); err != nil {
+		log.Fatalf("Failed to write result: %v", err)
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+
+	"github.com/aws/aws-lambda-go/lambda"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+
+	fleetserver "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/fleet-server"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/notify"
+)
+
+// main runs the fleet server's phase controller as a Lambda function,
+// configured from the environment: DEVICE_TABLE and ROLLOUT_TABLE are
+// required; NOTIFICATION_TABLE, TELEMETRY_TABLE (enables canary analysis and
+// anomaly detection) and EVENT_BUS_NAME (sends notifications to EventBridge)
+// are optional
+func main() {
+	ctx := context.Background()
+
+	deviceTable := os.Getenv("DEVICE_TABLE")
+	rolloutTable := os.Getenv("ROLLOUT_TABLE")
+	if deviceTable == "" || rolloutTable == "" {
+		log.Fatal("DEVICE_TABLE and ROLLOUT_TABLE are required")
+	}
+
+	cfg, err := config.LoadDefaultConfig(ctx)
+	if err != nil {
+		log.Fatalf("Failed to load AWS config: %v", err)
+	}
+	dynamoClient := dynamodb.NewFromConfig(cfg)
+
+	var analyzer *fleetserver.CanaryAnalyzer
+	var detector *fleetserver.AnomalyDetector
+	if telemetryTable := os.Getenv("TELEMETRY_TABLE"); telemetryTable != "" {
+		analyzer = fleetserver.NewCanaryAnalyzer(fleetserver.CanaryAnalyzerConfig{
+			DynamoClient:       dynamoClient,
+			DeviceTableName:    deviceTable,
+			RolloutTableName:   rolloutTable,
+			TelemetryTableName: telemetryTable,
+		})
+		detector = fleetserver.NewAnomalyDetector(fleetserver.AnomalyDetectorConfig{
+			DynamoClient:       dynamoClient,
+			DeviceTableName:    deviceTable,
+			RolloutTableName:   rolloutTable,
+			TelemetryTableName: telemetryTable,
+		})
+	}
+
+	var notifier notify.Notifier
+	if busName := os.Getenv("EVENT_BUS_NAME"); busName != "" {
+		notifier = notify.NewEventBridgeNotifier(eventbridge.NewFromConfig(cfg), busName, os.Getenv("EVENT_SOURCE"))
+	}
+
+	// Created once per cold start and reused by warm invocations
+	controller := fleetserver.NewPhaseController(fleetserver.PhaseControllerConfig{
+		DynamoClient:          dynamoClient,
+		DeviceTableName:       deviceTable,
+		RolloutTableName:      rolloutTable,
+		NotificationTableName: os.Getenv("NOTIFICATION_TABLE"),
+		Notifier:              notifier,
+		Analyzer:              analyzer,
+		Detector:              detector,
+	})
+
+	lambda.Start(fleetserver.NewLambdaHandler(controller).Handle)
+}
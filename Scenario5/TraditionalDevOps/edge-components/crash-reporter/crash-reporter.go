@@ -0,0 +1,332 @@
+package crashreporter
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// indexFile records every fingerprint seen on this device
+	indexFile = "index.json"
+
+	// pendingSuffix marks persisted reports not yet handed to the sync queue
+	pendingSuffix = ".crash.json"
+)
+
+// CrashReport is a captured agent panic
+type CrashReport struct {
+	Fingerprint string    `json:"fingerprint"`
+	DeviceID    string    `json:"deviceId"`
+	Version     string    `json:"version,omitempty"`
+	Panic       string    `json:"panic"`
+	Stack       string    `json:"stack"`      // the panicking goroutine
+	Goroutines  string    `json:"goroutines"` // every goroutine at the time of the panic
+	LogLines    []string  `json:"logLines"`
+	CrashedAt   time.Time `json:"crashedAt"`
+	Count       int       `json:"count"` // occurrences of this fingerprint on the device
+	FirstSeen   time.Time `json:"firstSeen"`
+}
+
+// ChangeQueue is the durable upload queue reports are handed to, normally
+// offlineSync.SyncManager
+type ChangeQueue interface {
+	AddPendingChange(key string, data []byte) error
+}
+
+// fingerprintStats tracks how often a fingerprint occurred
+type fingerprintStats struct {
+	Count     int       `json:"count"`
+	FirstSeen time.Time `json:"firstSeen"`
+	LastSeen  time.Time `json:"lastSeen"`
+}
+
+// CrashReporter captures agent panics with a goroutine dump and the last log
+// lines, persists them before the process exits and queues them for upload
+// on the next start. Reports with the same stack fingerprint are uploaded to
+// the same key, so repeated crashes update one report instead of piling up.
+type CrashReporter struct {
+	deviceID   string
+	version    string
+	crashDir   string
+	maxLines   int
+	lines      []string
+	partial    []byte
+	linesMutex sync.Mutex
+}
+
+// CrashReporterConfig contains configuration for the CrashReporter
+type CrashReporterConfig struct {
+	DeviceID string
+	Version  string // agent version recorded in reports
+	CrashDir string
+	LogLines int // log lines kept for reports; defaults to 200
+}
+
+// NewCrashReporter creates a new CrashReporter
+func NewCrashReporter(config CrashReporterConfig) (*CrashReporter, error) {
+	// Create crash directory if it doesn't exist
+	if err := os.MkdirAll(config.CrashDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create crash directory: %w", err)
+	}
+
+	if config.LogLines == 0 {
+		config.LogLines = 200
+	}
+
+	return &CrashReporter{
+		deviceID: config.DeviceID,
+		version:  config.Version,
+		crashDir: config.CrashDir,
+		maxLines: config.LogLines,
+		lines:    make([]string, 0, config.LogLines),
+	}, nil
+}
+
+// Write keeps the last log lines for reports; install it with
+// log.SetOutput(io.MultiWriter(os.Stderr, reporter))
+func (cr *CrashReporter) Write(p []byte) (int, error) {
+	cr.linesMutex.Lock()
+	defer cr.linesMutex.Unlock()
+
+	cr.partial = append(cr.partial, p...)
+	for {
+		i := bytes.IndexByte(cr.partial, '\n')
+		if i < 0 {
+			break
+		}
+		cr.lines = append(cr.lines, string(cr.partial[:i]))
+		cr.partial = cr.partial[i+1:]
+	}
+
+	if len(cr.lines) > cr.maxLines {
+		cr.lines = append(cr.lines[:0], cr.lines[len(cr.lines)-cr.maxLines:]...)
+	}
+
+	return len(p), nil
+}
+
+// Recover records a panic in the calling goroutine and re-panics, so the
+// agent still exits and restarts; use it as `defer reporter.Recover()`
+func (cr *CrashReporter) Recover() {
+	value := recover()
+	if value == nil {
+		return
+	}
+
+	if _, err := cr.Record(value, debug.Stack()); err != nil {
+		log.Printf("Failed to record crash: %v", err)
+	}
+	panic(value)
+}
+
+// Go runs fn in a new goroutine whose panics are recorded
+func (cr *CrashReporter) Go(fn func()) {
+	go func() {
+		defer cr.Recover()
+		fn()
+	}()
+}
+
+// Record captures a panic value and stack and persists the report locally
+func (cr *CrashReporter) Record(value interface{}, stack []byte) (*CrashReport, error) {
+	cr.linesMutex.Lock()
+	lines := append([]string(nil), cr.lines...)
+	cr.linesMutex.Unlock()
+
+	report := &CrashReport{
+		Fingerprint: Fingerprint(string(stack)),
+		DeviceID:    cr.deviceID,
+		Version:     cr.version,
+		Panic:       fmt.Sprint(value),
+		Stack:       string(stack),
+		Goroutines:  goroutineDump(),
+		LogLines:    lines,
+		CrashedAt:   time.Now().UTC(),
+	}
+
+	data, err := json.Marshal(report)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal crash report: %w", err)
+	}
+
+	// The process is about to exit, so sync the report to disk
+	path := filepath.Join(cr.crashDir, fmt.Sprintf("%s-%d%s", report.Fingerprint, report.CrashedAt.UnixNano(), pendingSuffix))
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to persist crash report: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to persist crash report: %w", err)
+	}
+	if err := file.Sync(); err != nil {
+		return nil, fmt.Errorf("failed to persist crash report: %w", err)
+	}
+
+	return report, nil
+}
+
+// QueuePending hands persisted reports to the sync queue, one per
+// fingerprint, as crashes/<fingerprint>.json. Call it on startup, after the
+// SyncManager is created; the reports upload with the next sync.
+func (cr *CrashReporter) QueuePending(queue ChangeQueue) error {
+	paths, err := filepath.Glob(filepath.Join(cr.crashDir, "*"+pendingSuffix))
+	if err != nil {
+		return fmt.Errorf("failed to list crash reports: %w", err)
+	}
+	if len(paths) == 0 {
+		return nil
+	}
+	// File names sort by fingerprint, then crash time
+	sort.Strings(paths)
+
+	index, err := cr.loadIndex()
+	if err != nil {
+		return err
+	}
+
+	// Keep the latest report of each fingerprint, counting every occurrence
+	latest := make(map[string]*CrashReport)
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read crash report: %w", err)
+		}
+
+		var report CrashReport
+		if err := json.Unmarshal(data, &report); err != nil {
+			log.Printf("Discarding unreadable crash report %s: %v", filepath.Base(path), err)
+			os.Remove(path)
+			continue
+		}
+
+		stats, ok := index[report.Fingerprint]
+		if !ok {
+			stats = fingerprintStats{FirstSeen: report.CrashedAt}
+		}
+		stats.Count++
+		stats.LastSeen = report.CrashedAt
+		index[report.Fingerprint] = stats
+
+		latest[report.Fingerprint] = &report
+	}
+
+	for fingerprint, report := range latest {
+		report.Count = index[fingerprint].Count
+		report.FirstSeen = index[fingerprint].FirstSeen
+
+		data, err := json.Marshal(report)
+		if err != nil {
+			return fmt.Errorf("failed to marshal crash report: %w", err)
+		}
+
+		if err := queue.AddPendingChange("crashes/"+fingerprint+".json", data); err != nil {
+			return fmt.Errorf("failed to queue crash report: %w", err)
+		}
+		log.Printf("Queued crash report %s (%d occurrence(s)): %s", fingerprint, report.Count, report.Panic)
+	}
+
+	// Re-queueing overwrites the same keys, so only commit the counts once
+	// every fingerprint is queued
+	if err := cr.saveIndex(index); err != nil {
+		return err
+	}
+
+	for _, path := range paths {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.Printf("Failed to remove queued crash report %s: %v", filepath.Base(path), err)
+		}
+	}
+
+	return nil
+}
+
+// loadIndex reads the fingerprint index
+func (cr *CrashReporter) loadIndex() (map[string]fingerprintStats, error) {
+	index := make(map[string]fingerprintStats)
+
+	data, err := ioutil.ReadFile(filepath.Join(cr.crashDir, indexFile))
+	if os.IsNotExist(err) {
+		return index, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read crash index: %w", err)
+	}
+
+	if err := json.Unmarshal(data, &index); err != nil {
+		return nil, fmt.Errorf("failed to parse crash index: %w", err)
+	}
+
+	return index, nil
+}
+
+// saveIndex writes the fingerprint index
+func (cr *CrashReporter) saveIndex(index map[string]fingerprintStats) error {
+	data, err := json.Marshal(index)
+	if err != nil {
+		return fmt.Errorf("failed to marshal crash index: %w", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(cr.crashDir, indexFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to write crash index: %w", err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// Fingerprint identifies a crash site by the functions and source lines on
+// its stack, leaving out goroutine IDs, argument values and PC offsets, so
+// the same bug fingerprints the same on every device
+func Fingerprint(stack string) string {
+	var frames []string
+	for _, line := range strings.Split(stack, "\n") {
+		if strings.HasPrefix(line, "goroutine ") {
+			continue
+		}
+
+		if strings.HasPrefix(line, "\t") {
+			// Source line: "\t/path/file.go:42 +0x1d"
+			line, _, _ = strings.Cut(strings.TrimSpace(line), " +0x")
+		} else if i := strings.LastIndex(line, "("); i > 0 {
+			// Function line: "pkg.fn(0xc000012345, 0x1)"
+			line = line[:i]
+		}
+
+		// Frames of the recording code differ by how the panic was caught, not by the bug
+		if line == "" || strings.Contains(line, "runtime/debug") || strings.Contains(line, "crash-reporter") || strings.Contains(line, "crashreporter.") {
+			continue
+		}
+		frames = append(frames, line)
+	}
+
+	sum := sha256.Sum256([]byte(strings.Join(frames, "\n")))
+	return hex.EncodeToString(sum[:8])
+}
+
+// goroutineDump returns the stacks of every goroutine
+func goroutineDump() string {
+	buf := make([]byte, 1<<20)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= 16<<20 {
+			return string(buf[:n])
+		}
+		buf = make([]byte, 2*len(buf))
+	}
+}
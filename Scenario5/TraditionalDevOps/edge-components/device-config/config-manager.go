@@ -0,0 +1,421 @@
+package deviceconfig
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	// desiredConfigKey is the sync key under which desired configuration is delivered
+	desiredConfigKey = "desired.json"
+
+	// driftReportKey is the sync key under which drift reports are uploaded
+	driftReportKey = "drift-report.json"
+)
+
+// ConfigItem is a single piece of desired configuration routed to an applier
+type ConfigItem struct {
+	Key     string          `json:"key"`
+	Applier string          `json:"applier"`
+	Content json.RawMessage `json:"content"`
+}
+
+// DesiredConfig is the complete desired configuration for a device
+type DesiredConfig struct {
+	Version     string       `json:"version"`
+	GeneratedAt time.Time    `json:"generatedAt"`
+	Items       []ConfigItem `json:"items"`
+}
+
+// DriftEntry describes a single configuration item whose actual state differs from desired
+type DriftEntry struct {
+	Key         string `json:"key"`
+	Applier     string `json:"applier"`
+	DesiredHash string `json:"desiredHash"`
+	ActualHash  string `json:"actualHash"`
+	Reason      string `json:"reason"`
+}
+
+// DriftReport summarizes the drift detected on a device
+type DriftReport struct {
+	DeviceID       string       `json:"deviceId"`
+	DesiredVersion string       `json:"desiredVersion"`
+	AppliedVersion string       `json:"appliedVersion"`
+	CheckedAt      time.Time    `json:"checkedAt"`
+	InSync         bool         `json:"inSync"`
+	Drift          []DriftEntry `json:"drift"`
+}
+
+// Applier is an interface for applying a type of configuration to the device
+type Applier interface {
+	// Apply writes the desired content for a key to the device
+	Apply(key string, content []byte) error
+
+	// Current reads the actual content for a key from the device
+	Current(key string) ([]byte, error)
+}
+
+// ConfigManager syncs desired device configuration, applies it and detects drift
+type ConfigManager struct {
+	deviceID       string
+	statePath      string
+	appliers       map[string]Applier
+	appliersMutex  sync.RWMutex
+	desired        *DesiredConfig
+	previous       *DesiredConfig // desired configuration before the last ApplyConfig
+	appliedVersion string
+	lastReport     *DriftReport
+	reportPending  bool
+	stateMutex     sync.Mutex
+	remediate      bool
+	driftInterval  time.Duration
+	driftTimer     *time.Timer
+	closed         bool // set by Close, so a running check doesn't reschedule; guarded by stateMutex
+}
+
+// ManagerConfig contains configuration for the ConfigManager
+type ManagerConfig struct {
+	DeviceID      string
+	StatePath     string
+	DriftInterval time.Duration
+	Remediate     bool
+}
+
+// NewConfigManager creates a new ConfigManager
+func NewConfigManager(config ManagerConfig) (*ConfigManager, error) {
+	// Create state directory if it doesn't exist
+	if err := os.MkdirAll(config.StatePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create config state directory: %w", err)
+	}
+
+	cm := &ConfigManager{
+		deviceID:      config.DeviceID,
+		statePath:     config.StatePath,
+		appliers:      make(map[string]Applier),
+		remediate:     config.Remediate,
+		driftInterval: config.DriftInterval,
+	}
+
+	// Restore the last desired configuration so drift can be detected while offline
+	if data, err := ioutil.ReadFile(filepath.Join(cm.statePath, desiredConfigKey)); err == nil {
+		var desired DesiredConfig
+		if err := json.Unmarshal(data, &desired); err != nil {
+			log.Printf("Ignoring unreadable desired config: %v", err)
+		} else {
+			cm.desired = &desired
+		}
+	}
+
+	// Start the drift detection timer
+	if cm.driftInterval > 0 {
+		cm.driftTimer = time.AfterFunc(cm.driftInterval, cm.checkDrift)
+	}
+
+	return cm, nil
+}
+
+// RegisterApplier registers an applier for a configuration type
+func (cm *ConfigManager) RegisterApplier(name string, applier Applier) {
+	cm.appliersMutex.Lock()
+	defer cm.appliersMutex.Unlock()
+	cm.appliers[name] = applier
+}
+
+// ProcessUpdate handles desired configuration delivered by the SyncManager
+func (cm *ConfigManager) ProcessUpdate(key string, data []byte) error {
+	if filepath.Base(key) != desiredConfigKey {
+		return nil
+	}
+
+	var desired DesiredConfig
+	if err := json.Unmarshal(data, &desired); err != nil {
+		return fmt.Errorf("failed to parse desired config: %w", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(cm.statePath, desiredConfigKey), data, 0644); err != nil {
+		return fmt.Errorf("failed to persist desired config: %w", err)
+	}
+
+	cm.stateMutex.Lock()
+	cm.desired = &desired
+	cm.stateMutex.Unlock()
+
+	if err := cm.Apply(); err != nil {
+		return err
+	}
+
+	cm.DetectDrift()
+	return nil
+}
+
+// ApplyConfig applies a DesiredConfig delivered by a config-only rollout,
+// making the ConfigManager a rollout.ConfigApplier
+func (cm *ConfigManager) ApplyConfig(version string, payload []byte) error {
+	var desired DesiredConfig
+	if err := json.Unmarshal(payload, &desired); err != nil {
+		return fmt.Errorf("failed to parse config payload: %w", err)
+	}
+	desired.Version = version
+	if desired.GeneratedAt.IsZero() {
+		desired.GeneratedAt = time.Now().UTC()
+	}
+
+	cm.stateMutex.Lock()
+	cm.previous = cm.desired
+	cm.stateMutex.Unlock()
+
+	if err := cm.setDesired(&desired); err != nil {
+		return err
+	}
+
+	if err := cm.Apply(); err != nil {
+		return err
+	}
+
+	cm.DetectDrift()
+	return nil
+}
+
+// RollbackConfig restores and reapplies the desired configuration from before the last ApplyConfig
+func (cm *ConfigManager) RollbackConfig() error {
+	cm.stateMutex.Lock()
+	previous := cm.previous
+	cm.previous = nil
+	cm.stateMutex.Unlock()
+
+	if previous == nil {
+		return fmt.Errorf("no previous configuration to roll back to")
+	}
+
+	if err := cm.setDesired(previous); err != nil {
+		return err
+	}
+
+	if err := cm.Apply(); err != nil {
+		return err
+	}
+
+	cm.DetectDrift()
+	return nil
+}
+
+// setDesired persists and installs a desired configuration
+func (cm *ConfigManager) setDesired(desired *DesiredConfig) error {
+	data, err := json.Marshal(desired)
+	if err != nil {
+		return fmt.Errorf("failed to marshal desired config: %w", err)
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(cm.statePath, desiredConfigKey), data, 0644); err != nil {
+		return fmt.Errorf("failed to persist desired config: %w", err)
+	}
+
+	cm.stateMutex.Lock()
+	cm.desired = desired
+	cm.stateMutex.Unlock()
+
+	return nil
+}
+
+// GetLocalChanges returns the latest drift report if it hasn't been uploaded yet
+func (cm *ConfigManager) GetLocalChanges() (map[string][]byte, error) {
+	cm.stateMutex.Lock()
+	defer cm.stateMutex.Unlock()
+
+	if !cm.reportPending || cm.lastReport == nil {
+		return nil, nil
+	}
+
+	data, err := json.Marshal(cm.lastReport)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal drift report: %w", err)
+	}
+
+	cm.reportPending = false
+	return map[string][]byte{driftReportKey: data}, nil
+}
+
+// MergeConflicts resolves conflicts in favour of the remote desired state
+func (cm *ConfigManager) MergeConflicts(localData, remoteData []byte) ([]byte, error) {
+	return remoteData, nil
+}
+
+// Apply applies every item of the desired configuration through its applier
+func (cm *ConfigManager) Apply() error {
+	cm.stateMutex.Lock()
+	desired := cm.desired
+	cm.stateMutex.Unlock()
+
+	if desired == nil {
+		return nil
+	}
+
+	for _, item := range desired.Items {
+		if err := cm.applyItem(item); err != nil {
+			return err
+		}
+	}
+
+	cm.stateMutex.Lock()
+	cm.appliedVersion = desired.Version
+	cm.stateMutex.Unlock()
+
+	return nil
+}
+
+// applyItem applies a single configuration item
+func (cm *ConfigManager) applyItem(item ConfigItem) error {
+	cm.appliersMutex.RLock()
+	applier, ok := cm.appliers[item.Applier]
+	cm.appliersMutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("no applier registered for %s (key %s)", item.Applier, item.Key)
+	}
+
+	if err := applier.Apply(item.Key, item.Content); err != nil {
+		return fmt.Errorf("failed to apply config %s: %w", item.Key, err)
+	}
+
+	return nil
+}
+
+// DetectDrift compares desired and actual configuration and records a drift report
+func (cm *ConfigManager) DetectDrift() *DriftReport {
+	cm.stateMutex.Lock()
+	desired := cm.desired
+	appliedVersion := cm.appliedVersion
+	cm.stateMutex.Unlock()
+
+	report := &DriftReport{
+		DeviceID:       cm.deviceID,
+		AppliedVersion: appliedVersion,
+		CheckedAt:      time.Now().UTC(),
+		Drift:          make([]DriftEntry, 0),
+	}
+
+	if desired == nil {
+		report.InSync = true
+		return report
+	}
+
+	report.DesiredVersion = desired.Version
+
+	for _, item := range desired.Items {
+		entry := DriftEntry{
+			Key:         item.Key,
+			Applier:     item.Applier,
+			DesiredHash: hashContent(item.Content),
+		}
+
+		cm.appliersMutex.RLock()
+		applier, ok := cm.appliers[item.Applier]
+		cm.appliersMutex.RUnlock()
+
+		if !ok {
+			entry.Reason = "applier not registered"
+			report.Drift = append(report.Drift, entry)
+			continue
+		}
+
+		actual, err := applier.Current(item.Key)
+		if err != nil {
+			entry.Reason = fmt.Sprintf("failed to read actual state: %v", err)
+			report.Drift = append(report.Drift, entry)
+			continue
+		}
+
+		entry.ActualHash = hashContent(actual)
+		if entry.ActualHash != entry.DesiredHash {
+			entry.Reason = "content differs from desired state"
+			report.Drift = append(report.Drift, entry)
+		}
+	}
+
+	report.InSync = len(report.Drift) == 0
+
+	cm.stateMutex.Lock()
+	// Only queue a new upload when the drift picture has changed
+	if cm.lastReport == nil || !sameDrift(cm.lastReport, report) {
+		cm.reportPending = true
+	}
+	cm.lastReport = report
+	cm.stateMutex.Unlock()
+
+	return report
+}
+
+// GetDriftReport returns the most recent drift report
+func (cm *ConfigManager) GetDriftReport() *DriftReport {
+	cm.stateMutex.Lock()
+	defer cm.stateMutex.Unlock()
+	return cm.lastReport
+}
+
+// checkDrift periodically detects drift and optionally remediates it
+func (cm *ConfigManager) checkDrift() {
+	defer func() {
+		// Reschedule the check unless the manager closed while it ran
+		cm.stateMutex.Lock()
+		defer cm.stateMutex.Unlock()
+		if !cm.closed {
+			cm.driftTimer.Reset(cm.driftInterval)
+		}
+	}()
+
+	report := cm.DetectDrift()
+	if report.InSync {
+		return
+	}
+
+	log.Printf("Configuration drift detected on %d item(s)", len(report.Drift))
+
+	if !cm.remediate {
+		return
+	}
+
+	if err := cm.Apply(); err != nil {
+		log.Printf("Failed to remediate configuration drift: %v", err)
+		return
+	}
+
+	cm.DetectDrift()
+}
+
+// Close stops the config manager
+func (cm *ConfigManager) Close() {
+	cm.stateMutex.Lock()
+	defer cm.stateMutex.Unlock()
+
+	cm.closed = true
+	if cm.driftTimer != nil {
+		cm.driftTimer.Stop()
+	}
+}
+
+// Helper functions
+
+func hashContent(data []byte) string {
+	return fmt.Sprintf("%x", sha256.Sum256(data))
+}
+
+func sameDrift(a, b *DriftReport) bool {
+	if a.DesiredVersion != b.DesiredVersion || len(a.Drift) != len(b.Drift) {
+		return false
+	}
+
+	for i := range a.Drift {
+		if a.Drift[i] != b.Drift[i] {
+			return false
+		}
+	}
+
+	return true
+}
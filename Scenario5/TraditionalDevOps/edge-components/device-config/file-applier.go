@@ -0,0 +1,74 @@
+package deviceconfig
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileApplier applies configuration by writing each key as a file under a base directory
+type FileApplier struct {
+	basePath string
+}
+
+// NewFileApplier creates a new FileApplier rooted at basePath
+func NewFileApplier(basePath string) (*FileApplier, error) {
+	if err := os.MkdirAll(basePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	return &FileApplier{basePath: basePath}, nil
+}
+
+// Apply atomically replaces the file for key with content
+func (fa *FileApplier) Apply(key string, content []byte) error {
+	filePath, err := fa.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+
+	// Write to a temporary file first so readers never observe a partial config
+	tmpPath := filePath + ".tmp"
+	if err := ioutil.WriteFile(tmpPath, content, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", key, err)
+	}
+
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to replace %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// Current returns the content of the file for key
+func (fa *FileApplier) Current(key string) ([]byte, error) {
+	filePath, err := fa.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(filePath)
+}
+
+// path returns the file for key; keys come from the remote config source, so
+// absolute keys and keys escaping the base directory are rejected
+func (fa *FileApplier) path(key string) (string, error) {
+	cleaned := filepath.Clean(key)
+	if filepath.IsAbs(cleaned) || cleaned == "." {
+		return "", fmt.Errorf("invalid config key %q", key)
+	}
+
+	filePath := filepath.Join(fa.basePath, cleaned)
+	rel, err := filepath.Rel(fa.basePath, filePath)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("config key %q is outside %s", key, fa.basePath)
+	}
+
+	return filePath, nil
+}
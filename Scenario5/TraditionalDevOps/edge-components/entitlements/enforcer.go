@@ -0,0 +1,235 @@
+package entitlements
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Entitlement states, from best to worst
+const (
+	StateValid   = "valid"   // licensed and not expired
+	StateGrace   = "grace"   // expired, within the grace period
+	StateExpired = "expired" // expired past the grace period
+	StateMissing = "missing" // no license received yet
+)
+
+// ErrNotEntitled means the device's license doesn't grant a feature, has
+// expired past its grace period, or hasn't been received
+var ErrNotEntitled = errors.New("not entitled")
+
+// Verifier checks license signatures, e.g. a keymanager.KeyRing
+type Verifier interface {
+	// Verify returns an error unless signature is a valid signature over data by keyID
+	Verify(keyID string, data, signature []byte) error
+}
+
+// Status is the device's entitlement as the Enforcer sees it
+type Status struct {
+	State       string     `json:"state"`
+	LicenseID   string     `json:"licenseId,omitempty"`
+	Features    []string   `json:"features,omitempty"`
+	ExpiresAt   *time.Time `json:"expiresAt,omitempty"`
+	GraceEndsAt *time.Time `json:"graceEndsAt,omitempty"`
+}
+
+// Enforcer holds the device's license and gates features on it. It receives
+// licenses as a SyncHandler for DataType, accepting one only when it is
+// signed by a trusted key, bound to this device and newer than the current
+// one. The license persists, so entitlements hold while the device is offline.
+type Enforcer struct {
+	deviceID     string
+	tenantID     string
+	path         string
+	verifier     Verifier
+	gracePeriod  time.Duration
+	license      *License
+	licenseMutex sync.RWMutex
+	now          func() time.Time
+}
+
+// EnforcerConfig contains configuration for the Enforcer
+type EnforcerConfig struct {
+	DeviceID    string
+	TenantID    string
+	StatePath   string        // directory the license persists in
+	Verifier    Verifier      // normally the keymanager.KeyRing that verifies rollouts
+	GracePeriod time.Duration // defaults to 7 days; a license's own GracePeriod replaces it
+}
+
+// NewEnforcer creates an Enforcer, restoring the last accepted license
+func NewEnforcer(config EnforcerConfig) (*Enforcer, error) {
+	if config.Verifier == nil {
+		return nil, errors.New("verifier is required")
+	}
+	if config.GracePeriod == 0 {
+		config.GracePeriod = 7 * 24 * time.Hour
+	}
+
+	// Create state directory if it doesn't exist
+	if err := os.MkdirAll(config.StatePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create license state directory: %w", err)
+	}
+
+	e := &Enforcer{
+		deviceID:    config.DeviceID,
+		tenantID:    config.TenantID,
+		path:        filepath.Join(config.StatePath, licenseFile),
+		verifier:    config.Verifier,
+		gracePeriod: config.GracePeriod,
+		now:         time.Now,
+	}
+
+	// The stored license is checked again, in case the file was tampered with
+	if data, err := ioutil.ReadFile(e.path); err == nil {
+		license, err := e.parse(data)
+		if err != nil {
+			log.Printf("Ignoring stored license: %v", err)
+		} else {
+			e.license = license
+		}
+	}
+
+	return e, nil
+}
+
+// Check returns nil when the license grants feature, and otherwise
+// ErrNotEntitled wrapped with the reason; during the grace period features
+// stay granted
+func (e *Enforcer) Check(feature string) error {
+	e.licenseMutex.RLock()
+	license := e.license
+	e.licenseMutex.RUnlock()
+
+	if license == nil {
+		return fmt.Errorf("%w: no license for %s", ErrNotEntitled, feature)
+	}
+	if !license.Grants(feature) {
+		return fmt.Errorf("%w: license %s does not grant %s", ErrNotEntitled, license.LicenseID, feature)
+	}
+	if e.state(license) == StateExpired {
+		return fmt.Errorf("%w: license %s expired at %s", ErrNotEntitled, license.LicenseID, license.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// Entitled reports whether the license grants feature
+func (e *Enforcer) Entitled(feature string) bool {
+	return e.Check(feature) == nil
+}
+
+// Status returns the license state, features and deadlines
+func (e *Enforcer) Status() Status {
+	e.licenseMutex.RLock()
+	license := e.license
+	e.licenseMutex.RUnlock()
+
+	if license == nil {
+		return Status{State: StateMissing}
+	}
+
+	expiresAt := license.ExpiresAt
+	graceEndsAt := license.ExpiresAt.Add(e.grace(license))
+	return Status{
+		State:       e.state(license),
+		LicenseID:   license.LicenseID,
+		Features:    license.Features,
+		ExpiresAt:   &expiresAt,
+		GraceEndsAt: &graceEndsAt,
+	}
+}
+
+// ProcessUpdate replaces the license with one delivered by the SyncManager
+func (e *Enforcer) ProcessUpdate(key string, data []byte) error {
+	if filepath.Base(key) != licenseFile {
+		return nil
+	}
+
+	license, err := e.parse(data)
+	if err != nil {
+		return err
+	}
+
+	e.licenseMutex.Lock()
+	defer e.licenseMutex.Unlock()
+
+	// A replayed older license can't undo a revocation or downgrade
+	if e.license != nil && !license.IssuedAt.After(e.license.IssuedAt) {
+		log.Printf("Ignoring license %s: not newer than license %s", license.LicenseID, e.license.LicenseID)
+		return nil
+	}
+
+	if err := ioutil.WriteFile(e.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to persist license: %w", err)
+	}
+
+	e.license = license
+	log.Printf("License %s accepted: %d features until %s", license.LicenseID, len(license.Features), license.ExpiresAt.Format(time.RFC3339))
+
+	return nil
+}
+
+// GetLocalChanges returns nothing; licenses only flow from the cloud to the device
+func (e *Enforcer) GetLocalChanges() (map[string][]byte, error) {
+	return nil, nil
+}
+
+// MergeConflicts always prefers the remote license
+func (e *Enforcer) MergeConflicts(localData, remoteData []byte) ([]byte, error) {
+	return remoteData, nil
+}
+
+// parse decodes a license and checks its signature and device binding
+func (e *Enforcer) parse(data []byte) (*License, error) {
+	var license License
+	if err := json.Unmarshal(data, &license); err != nil {
+		return nil, fmt.Errorf("failed to parse license: %w", err)
+	}
+
+	if len(license.Signature) == 0 {
+		return nil, fmt.Errorf("license %s is not signed", license.LicenseID)
+	}
+	if err := e.verifier.Verify(license.KeyID, license.SigningPayload(), license.Signature); err != nil {
+		return nil, fmt.Errorf("license %s signature verification failed: %w", license.LicenseID, err)
+	}
+	if !license.BoundTo(e.tenantID, e.deviceID) {
+		return nil, fmt.Errorf("license %s is not bound to this device", license.LicenseID)
+	}
+	if license.GracePeriod != "" {
+		if _, err := time.ParseDuration(license.GracePeriod); err != nil {
+			return nil, fmt.Errorf("license %s has an invalid grace period: %w", license.LicenseID, err)
+		}
+	}
+
+	return &license, nil
+}
+
+// state classifies a license against the clock
+func (e *Enforcer) state(license *License) string {
+	now := e.now()
+	switch {
+	case now.Before(license.ExpiresAt):
+		return StateValid
+	case now.Before(license.ExpiresAt.Add(e.grace(license))):
+		return StateGrace
+	default:
+		return StateExpired
+	}
+}
+
+// grace returns the license's grace period, or the Enforcer's default
+func (e *Enforcer) grace(license *License) time.Duration {
+	if license.GracePeriod != "" {
+		if grace, err := time.ParseDuration(license.GracePeriod); err == nil {
+			return grace
+		}
+	}
+	return e.gracePeriod
+}
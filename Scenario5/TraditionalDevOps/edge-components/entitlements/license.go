@@ -0,0 +1,173 @@
+package entitlements
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/google/uuid"
+
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+const (
+	// DataType is the sync data type licenses are delivered as; register an
+	// Enforcer for it with SyncManager.RegisterSyncHandler
+	DataType = "license"
+
+	// licenseFile is the object name of a license in device updates and on disk
+	licenseFile = "license.json"
+)
+
+// License is a signed grant of features to a tenant's devices. Devices check
+// it offline: the signature, the device binding and the expiry.
+type License struct {
+	LicenseID string    `json:"licenseId"`
+	TenantID  string    `json:"tenantId,omitempty"`
+	DeviceIDs []string  `json:"deviceIds,omitempty"` // devices the license is bound to; empty binds every device of the tenant
+	Features  []string  `json:"features"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ExpiresAt time.Time `json:"expiresAt"`
+
+	// GracePeriod, a duration, keeps features working after ExpiresAt for a
+	// device that hasn't received the renewal, e.g. while offline; it
+	// replaces the Enforcer's default
+	GracePeriod string `json:"gracePeriod,omitempty"`
+
+	KeyID     string `json:"keyId"`
+	Signature []byte `json:"signature"`
+}
+
+// Signer signs licenses, e.g. a keymanager.KMSSigner
+type Signer interface {
+	// Sign returns a signature over data
+	Sign(data []byte) ([]byte, error)
+
+	// KeyID identifies the signing key
+	KeyID() string
+}
+
+// Grants reports whether the license includes a feature
+func (l *License) Grants(feature string) bool {
+	for _, f := range l.Features {
+		if f == feature || f == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// BoundTo reports whether the license covers a device of a tenant
+func (l *License) BoundTo(tenantID, deviceID string) bool {
+	if l.TenantID != tenantID {
+		return false
+	}
+	if len(l.DeviceIDs) == 0 {
+		return true
+	}
+	for _, id := range l.DeviceIDs {
+		if id == deviceID {
+			return true
+		}
+	}
+	return false
+}
+
+// SigningPayload returns the bytes a license signature covers: every field
+// except the signature
+func (l License) SigningPayload() []byte {
+	l.KeyID = ""
+	l.Signature = nil
+	payload, _ := json.Marshal(l)
+	return payload
+}
+
+// Issuer signs licenses and delivers them to devices through their sync manifests
+type Issuer struct {
+	signer    Signer
+	transport offlineSync.SyncTransport
+}
+
+// IssuerConfig contains configuration for the Issuer
+type IssuerConfig struct {
+	Signer    Signer
+	Transport offlineSync.SyncTransport // the sync bucket devices read, normally offlineSync.NewS3Transport
+}
+
+// NewIssuer creates a new Issuer
+func NewIssuer(config IssuerConfig) (*Issuer, error) {
+	if config.Signer == nil {
+		return nil, errors.New("signer is required")
+	}
+	if config.Transport == nil {
+		return nil, errors.New("transport is required")
+	}
+
+	return &Issuer{signer: config.Signer, transport: config.Transport}, nil
+}
+
+// Issue fills in the license ID and issue time when unset and signs the license
+func (is *Issuer) Issue(license License) (*License, error) {
+	switch {
+	case len(license.Features) == 0:
+		return nil, errors.New("license grants no features")
+	case license.ExpiresAt.IsZero():
+		return nil, errors.New("license expiry is required")
+	}
+	if license.GracePeriod != "" {
+		if _, err := time.ParseDuration(license.GracePeriod); err != nil {
+			return nil, fmt.Errorf("invalid grace period: %w", err)
+		}
+	}
+
+	if license.LicenseID == "" {
+		license.LicenseID = uuid.New().String()
+	}
+	if license.IssuedAt.IsZero() {
+		license.IssuedAt = time.Now().UTC()
+	}
+
+	license.KeyID = is.signer.KeyID()
+	signature, err := is.signer.Sign(license.SigningPayload())
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign license: %w", err)
+	}
+	license.Signature = signature
+
+	return &license, nil
+}
+
+// Deliver writes a signed license into each device's updates, replacing the
+// license it holds once the device syncs
+func (is *Issuer) Deliver(ctx context.Context, license *License, deviceIDs []string) error {
+	data, err := json.Marshal(license)
+	if err != nil {
+		return fmt.Errorf("failed to marshal license: %w", err)
+	}
+
+	// Devices reject a license that isn't bound to them
+	for _, deviceID := range deviceIDs {
+		if !license.BoundTo(license.TenantID, deviceID) {
+			return fmt.Errorf("license %s is not bound to device %s", license.LicenseID, deviceID)
+		}
+	}
+
+	failed := 0
+	for _, deviceID := range deviceIDs {
+		devicePrefix := fmt.Sprintf("%sdevices/%s/", tenant.S3Prefix(license.TenantID), deviceID)
+		if err := offlineSync.DeliverUpdate(ctx, is.transport, devicePrefix, licenseFile, DataType, data); err != nil {
+			log.Printf("Failed to deliver license %s to %s: %v", license.LicenseID, deviceID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("license %s not delivered to %d of %d devices", license.LicenseID, failed, len(deviceIDs))
+	}
+
+	return nil
+}
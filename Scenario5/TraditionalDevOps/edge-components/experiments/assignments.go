@@ -0,0 +1,157 @@
+package experiments
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+)
+
+// TelemetryReporter is an interface for reporting telemetry data; it matches
+// the rollout package's TelemetryReporter
+type TelemetryReporter interface {
+	// ReportMetrics reports metrics for rollout monitoring
+	ReportMetrics(metrics []string) error
+}
+
+// Assignments holds the experiment variants assigned to this device. It
+// receives assignment sets as a SyncHandler for DataType.
+type Assignments struct {
+	statePath        string
+	assignments      map[string]Assignment
+	assignmentsMutex sync.RWMutex
+}
+
+// NewAssignments creates a new Assignments, restoring the last synced set
+// from statePath so variants stay stable while offline
+func NewAssignments(statePath string) (*Assignments, error) {
+	// Create state directory if it doesn't exist
+	if err := os.MkdirAll(statePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create experiment state directory: %w", err)
+	}
+
+	a := &Assignments{
+		statePath:   statePath,
+		assignments: make(map[string]Assignment),
+	}
+
+	if data, err := ioutil.ReadFile(filepath.Join(statePath, AssignmentsFile)); err == nil {
+		if err := a.load(data); err != nil {
+			log.Printf("Ignoring unreadable experiment assignments: %v", err)
+		}
+	}
+
+	return a, nil
+}
+
+// ProcessUpdate handles an assignment set delivered by the SyncManager
+func (a *Assignments) ProcessUpdate(key string, data []byte) error {
+	if filepath.Base(key) != AssignmentsFile {
+		return nil
+	}
+
+	if err := a.load(data); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(a.statePath, AssignmentsFile), data, 0644); err != nil {
+		return fmt.Errorf("failed to persist experiment assignments: %w", err)
+	}
+
+	return nil
+}
+
+// GetLocalChanges returns nothing; assignments only flow from the cloud to the device
+func (a *Assignments) GetLocalChanges() (map[string][]byte, error) {
+	return nil, nil
+}
+
+// MergeConflicts always prefers the remote assignment set
+func (a *Assignments) MergeConflicts(localData, remoteData []byte) ([]byte, error) {
+	return remoteData, nil
+}
+
+// load parses an assignment set and replaces the current assignments
+func (a *Assignments) load(data []byte) error {
+	var set AssignmentSet
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("failed to parse experiment assignments: %w", err)
+	}
+
+	assignments := make(map[string]Assignment, len(set.Assignments))
+	for _, assignment := range set.Assignments {
+		assignments[assignment.ExperimentID] = assignment
+	}
+
+	a.assignmentsMutex.Lock()
+	a.assignments = assignments
+	a.assignmentsMutex.Unlock()
+
+	return nil
+}
+
+// Variant returns the device's variant in an experiment, or false if it isn't enrolled
+func (a *Assignments) Variant(experimentID string) (string, bool) {
+	a.assignmentsMutex.RLock()
+	defer a.assignmentsMutex.RUnlock()
+
+	assignment, ok := a.assignments[experimentID]
+	return assignment.Variant, ok
+}
+
+// Value decodes the value of the device's variant into v, returning false if
+// the device isn't enrolled or the variant carries no value
+func (a *Assignments) Value(experimentID string, v interface{}) bool {
+	a.assignmentsMutex.RLock()
+	assignment, ok := a.assignments[experimentID]
+	a.assignmentsMutex.RUnlock()
+
+	if !ok || len(assignment.Value) == 0 {
+		return false
+	}
+	return json.Unmarshal(assignment.Value, v) == nil
+}
+
+// Tags returns one "experiment.<id>=<variant index>" metric per enrolled experiment
+func (a *Assignments) Tags() []string {
+	a.assignmentsMutex.RLock()
+	defer a.assignmentsMutex.RUnlock()
+
+	tags := make([]string, 0, len(a.assignments))
+	for id, assignment := range a.assignments {
+		tags = append(tags, Metric(id)+"="+strconv.Itoa(assignment.VariantIndex))
+	}
+	return tags
+}
+
+// TaggingReporter tags every telemetry report with the device's experiment
+// variants so the fleet server can compare variants on the same metrics
+type TaggingReporter struct {
+	reporter    TelemetryReporter
+	assignments *Assignments
+}
+
+// NewTaggingReporter wraps a reporter, e.g. the GRPCAgent or a DynamoReporter
+func NewTaggingReporter(reporter TelemetryReporter, assignments *Assignments) *TaggingReporter {
+	return &TaggingReporter{
+		reporter:    reporter,
+		assignments: assignments,
+	}
+}
+
+// ReportMetrics reports metrics with the experiment tags appended
+func (tr *TaggingReporter) ReportMetrics(metrics []string) error {
+	tags := tr.assignments.Tags()
+	if len(tags) == 0 {
+		return tr.reporter.ReportMetrics(metrics)
+	}
+
+	tagged := make([]string, 0, len(metrics)+len(tags))
+	tagged = append(tagged, metrics...)
+	tagged = append(tagged, tags...)
+	return tr.reporter.ReportMetrics(tagged)
+}
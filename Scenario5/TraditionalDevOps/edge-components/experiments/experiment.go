@@ -0,0 +1,140 @@
+package experiments
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	featureflags "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/feature-flags"
+)
+
+const (
+	// DataType is the sync data type assignment sets are delivered as; register
+	// an Assignments handler for it with SyncManager.RegisterSyncHandler
+	DataType = "experiments"
+
+	// AssignmentsFile is the object name of a device's assignment set in its updates
+	AssignmentsFile = "experiments.json"
+
+	// MetricPrefix prefixes the telemetry metric tagging reports with a variant:
+	// "experiment.<id>=<variant index>"
+	MetricPrefix = "experiment."
+)
+
+// Experiment statuses
+const (
+	StatusDraft   = "draft"
+	StatusRunning = "running"
+	StatusStopped = "stopped"
+)
+
+// Variant is one arm of an experiment; the first variant is the control
+type Variant struct {
+	Name   string          `dynamodbav:"Name" json:"name"`
+	Weight float64         `dynamodbav:"Weight" json:"weight"`
+	Value  json.RawMessage `dynamodbav:"Value,omitempty" json:"value,omitempty"`
+}
+
+// Experiment splits the exposed share of its target groups between variants
+// and compares their telemetry
+type Experiment struct {
+	ID           string     `dynamodbav:"ID" json:"id"`
+	Name         string     `dynamodbav:"Name" json:"name"`
+	Description  string     `dynamodbav:"Description" json:"description"`
+	Status       string     `dynamodbav:"Status" json:"status"`
+	TargetGroups []string   `dynamodbav:"TargetGroups" json:"targetGroups"`
+	Percentage   float64    `dynamodbav:"Percentage" json:"percentage"` // share of targeted devices exposed to the experiment
+	Variants     []Variant  `dynamodbav:"Variants" json:"variants"`
+	Metrics      []string   `dynamodbav:"Metrics" json:"metrics"`
+	CreatedBy    string     `dynamodbav:"CreatedBy" json:"createdBy"`
+	CreatedAt    time.Time  `dynamodbav:"CreatedAt" json:"createdAt"`
+	StartedAt    *time.Time `dynamodbav:"StartedAt,omitempty" json:"startedAt,omitempty"`
+	StoppedAt    *time.Time `dynamodbav:"StoppedAt,omitempty" json:"stoppedAt,omitempty"`
+	TenantID     string     `dynamodbav:"TenantID,omitempty" json:"tenantId,omitempty"`
+}
+
+// Assignment is the variant a device runs in one experiment
+type Assignment struct {
+	ExperimentID string          `json:"experimentId"`
+	Variant      string          `json:"variant"`
+	VariantIndex int             `json:"variantIndex"`
+	Value        json.RawMessage `json:"value,omitempty"`
+}
+
+// AssignmentSet is the complete set of assignments synced to a device
+type AssignmentSet struct {
+	UpdatedAt   time.Time    `json:"updatedAt"`
+	Assignments []Assignment `json:"assignments"`
+}
+
+// Validate checks that an experiment can be assigned
+func (e Experiment) Validate() error {
+	if e.Name == "" {
+		return errors.New("experiment name is required")
+	}
+	if len(e.TargetGroups) == 0 {
+		return errors.New("at least one target group is required")
+	}
+	if e.Percentage <= 0 || e.Percentage > 100 {
+		return fmt.Errorf("invalid exposure percentage %.1f", e.Percentage)
+	}
+	if len(e.Variants) < 2 {
+		return errors.New("at least two variants are required")
+	}
+
+	names := make(map[string]bool, len(e.Variants))
+	for _, v := range e.Variants {
+		if v.Name == "" || names[v.Name] {
+			return fmt.Errorf("variant names must be unique and non-empty")
+		}
+		if v.Weight <= 0 {
+			return fmt.Errorf("variant %s has non-positive weight", v.Name)
+		}
+		names[v.Name] = true
+	}
+
+	return nil
+}
+
+// Assign returns the device's variant, or false when the device falls outside
+// the exposed percentage. Exposure uses the rollout manager's FNV-1a bucketing
+// salted with the experiment ID, so experiments are independent of rollout
+// cohorts and of each other; the variant is picked with a second salt so the
+// split between variants doesn't depend on the exposure percentage.
+func Assign(e Experiment, deviceID string) (Assignment, bool) {
+	if e.Percentage <= 0 || featureflags.Bucket(deviceID, e.ID) > e.Percentage {
+		return Assignment{}, false
+	}
+
+	var total float64
+	for _, v := range e.Variants {
+		total += v.Weight
+	}
+	if total <= 0 {
+		return Assignment{}, false
+	}
+
+	point := featureflags.Bucket(deviceID, e.ID+":variant") / 100 * total
+	index := len(e.Variants) - 1
+	var cumulative float64
+	for i, v := range e.Variants {
+		cumulative += v.Weight
+		if point < cumulative {
+			index = i
+			break
+		}
+	}
+
+	return Assignment{
+		ExperimentID: e.ID,
+		Variant:      e.Variants[index].Name,
+		VariantIndex: index,
+		Value:        e.Variants[index].Value,
+	}, true
+}
+
+// Metric returns the telemetry metric name tagging reports for an experiment
+func Metric(experimentID string) string {
+	return MetricPrefix + experimentID
+}
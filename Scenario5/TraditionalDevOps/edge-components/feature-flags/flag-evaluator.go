@@ -0,0 +1,275 @@
+package featureflags
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// flagsKey is the sync key under which the flag set is delivered
+const flagsKey = "flags.json"
+
+// TargetRule restricts a flag to devices whose tag matches one of the values
+type TargetRule struct {
+	Tag    string   `json:"tag"`
+	Values []string `json:"values"`
+}
+
+// Flag represents a single feature flag
+type Flag struct {
+	Key          string          `json:"key"`
+	Description  string          `json:"description"`
+	Enabled      bool            `json:"enabled"`
+	Percentage   float64         `json:"percentage"`
+	Salt         string          `json:"salt,omitempty"`
+	Groups       []string        `json:"groups,omitempty"`
+	Rules        []TargetRule    `json:"rules,omitempty"`
+	Value        json.RawMessage `json:"value,omitempty"`
+	DefaultValue json.RawMessage `json:"defaultValue,omitempty"`
+	Entitlement  string          `json:"entitlement,omitempty"` // license feature the device must hold
+}
+
+// FlagSet is the complete set of flags synced to a device
+type FlagSet struct {
+	Version   string    `json:"version"`
+	UpdatedAt time.Time `json:"updatedAt"`
+	Flags     []Flag    `json:"flags"`
+}
+
+// Evaluation is the result of evaluating a flag for this device
+type Evaluation struct {
+	Key     string          `json:"key"`
+	Enabled bool            `json:"enabled"`
+	Value   json.RawMessage `json:"value,omitempty"`
+	Reason  string          `json:"reason"`
+}
+
+// Entitlements reports which licensed features the device holds;
+// *entitlements.Enforcer satisfies it
+type Entitlements interface {
+	Entitled(feature string) bool
+}
+
+// FlagEvaluator evaluates synced feature flags for this device
+type FlagEvaluator struct {
+	deviceID     string
+	deviceGroup  string
+	deviceTags   map[string]string
+	statePath    string
+	entitlements Entitlements
+	flags        map[string]Flag
+	version      string
+	flagsMutex   sync.RWMutex
+}
+
+// EvaluatorConfig contains configuration for the FlagEvaluator
+type EvaluatorConfig struct {
+	DeviceID     string
+	DeviceGroup  string
+	DeviceTags   map[string]string
+	StatePath    string
+	Entitlements Entitlements // without it, flags that set Entitlement evaluate off
+}
+
+// NewFlagEvaluator creates a new FlagEvaluator
+func NewFlagEvaluator(config EvaluatorConfig) (*FlagEvaluator, error) {
+	// Create state directory if it doesn't exist
+	if err := os.MkdirAll(config.StatePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create flag state directory: %w", err)
+	}
+
+	fe := &FlagEvaluator{
+		deviceID:     config.DeviceID,
+		deviceGroup:  config.DeviceGroup,
+		deviceTags:   config.DeviceTags,
+		statePath:    config.StatePath,
+		entitlements: config.Entitlements,
+		flags:        make(map[string]Flag),
+	}
+
+	// Restore the last synced flag set so flags evaluate while offline
+	if data, err := ioutil.ReadFile(filepath.Join(fe.statePath, flagsKey)); err == nil {
+		if err := fe.load(data); err != nil {
+			log.Printf("Ignoring unreadable flag set: %v", err)
+		}
+	}
+
+	return fe, nil
+}
+
+// ProcessUpdate handles a flag set delivered by the SyncManager
+func (fe *FlagEvaluator) ProcessUpdate(key string, data []byte) error {
+	if filepath.Base(key) != flagsKey {
+		return nil
+	}
+
+	if err := fe.load(data); err != nil {
+		return err
+	}
+
+	if err := ioutil.WriteFile(filepath.Join(fe.statePath, flagsKey), data, 0644); err != nil {
+		return fmt.Errorf("failed to persist flag set: %w", err)
+	}
+
+	return nil
+}
+
+// GetLocalChanges returns nothing; flags only flow from the cloud to the device
+func (fe *FlagEvaluator) GetLocalChanges() (map[string][]byte, error) {
+	return nil, nil
+}
+
+// MergeConflicts always prefers the remote flag set
+func (fe *FlagEvaluator) MergeConflicts(localData, remoteData []byte) ([]byte, error) {
+	return remoteData, nil
+}
+
+// load parses a flag set and replaces the current flags
+func (fe *FlagEvaluator) load(data []byte) error {
+	var flagSet FlagSet
+	if err := json.Unmarshal(data, &flagSet); err != nil {
+		return fmt.Errorf("failed to parse flag set: %w", err)
+	}
+
+	flags := make(map[string]Flag, len(flagSet.Flags))
+	for _, flag := range flagSet.Flags {
+		flags[flag.Key] = flag
+	}
+
+	fe.flagsMutex.Lock()
+	fe.flags = flags
+	fe.version = flagSet.Version
+	fe.flagsMutex.Unlock()
+
+	return nil
+}
+
+// Evaluate evaluates a flag for this device
+func (fe *FlagEvaluator) Evaluate(key string) Evaluation {
+	fe.flagsMutex.RLock()
+	flag, ok := fe.flags[key]
+	fe.flagsMutex.RUnlock()
+
+	if !ok {
+		return Evaluation{Key: key, Reason: "flag not found"}
+	}
+
+	off := Evaluation{Key: key, Value: flag.DefaultValue}
+
+	if !flag.Enabled {
+		off.Reason = "flag disabled"
+		return off
+	}
+
+	if flag.Entitlement != "" && (fe.entitlements == nil || !fe.entitlements.Entitled(flag.Entitlement)) {
+		off.Reason = fmt.Sprintf("not entitled to %s", flag.Entitlement)
+		return off
+	}
+
+	if len(flag.Groups) > 0 && !containsGroup(flag.Groups, fe.deviceGroup) {
+		off.Reason = "device group not targeted"
+		return off
+	}
+
+	for _, rule := range flag.Rules {
+		if !fe.matchesRule(rule) {
+			off.Reason = fmt.Sprintf("tag %s not matched", rule.Tag)
+			return off
+		}
+	}
+
+	if !inPercentage(fe.deviceID, flag.Salt, flag.Percentage) {
+		off.Reason = "device outside rollout percentage"
+		return off
+	}
+
+	return Evaluation{
+		Key:     key,
+		Enabled: true,
+		Value:   flag.Value,
+		Reason:  "targeted",
+	}
+}
+
+// IsEnabled reports whether a flag is enabled for this device
+func (fe *FlagEvaluator) IsEnabled(key string) bool {
+	return fe.Evaluate(key).Enabled
+}
+
+// StringValue returns the string value of a flag, or defaultValue if it can't be resolved
+func (fe *FlagEvaluator) StringValue(key, defaultValue string) string {
+	var value string
+	if err := json.Unmarshal(fe.Evaluate(key).Value, &value); err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// FloatValue returns the numeric value of a flag, or defaultValue if it can't be resolved
+func (fe *FlagEvaluator) FloatValue(key string, defaultValue float64) float64 {
+	var value float64
+	if err := json.Unmarshal(fe.Evaluate(key).Value, &value); err != nil {
+		return defaultValue
+	}
+	return value
+}
+
+// Version returns the version of the loaded flag set
+func (fe *FlagEvaluator) Version() string {
+	fe.flagsMutex.RLock()
+	defer fe.flagsMutex.RUnlock()
+	return fe.version
+}
+
+// matchesRule checks whether the device's tags satisfy a targeting rule
+func (fe *FlagEvaluator) matchesRule(rule TargetRule) bool {
+	value, ok := fe.deviceTags[rule.Tag]
+	if !ok {
+		return false
+	}
+
+	for _, v := range rule.Values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Helper functions
+
+// Bucket returns the deterministic 0-99 bucket of a device, using the same
+// FNV-1a hashing as the progressive rollout manager so a flag at N% covers the
+// same devices as a rollout phase at N%. A non-empty salt gives an independent
+// bucketing for flags that shouldn't follow rollout cohorts.
+func Bucket(deviceID, salt string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	if salt != "" {
+		h.Write([]byte(":" + salt))
+	}
+	return float64(h.Sum32() % 100)
+}
+
+func inPercentage(deviceID, salt string, percentage float64) bool {
+	if percentage <= 0 {
+		return false
+	}
+	return Bucket(deviceID, salt) <= percentage
+}
+
+func containsGroup(groups []string, group string) bool {
+	for _, g := range groups {
+		if g == group || g == "all" {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,1001 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// AgentGateway serves the FleetAgent gRPC service, acting on the device,
+// rollout and telemetry tables on behalf of agents that hold no AWS credentials
+type AgentGateway struct {
+	dynamoClient        *dynamodb.Client
+	presignClient       *s3.PresignClient
+	deviceTableName     string
+	rolloutTableName    string
+	artifactTableName   string
+	telemetryTableName  string
+	urlExpiry           time.Duration
+	requireCertIdentity bool
+	trustedProxies      map[string]bool
+	hub                 *StatusHub
+	exporter            *EventExporter
+	rollouts            []rollout.RolloutPlan
+	healthScores        map[string]float64            // device key -> score, loaded while a rollout requires one
+	cohorts             map[string]map[string]float64 // device key -> cohort percentiles, loaded while a rollout is cohorted
+	sessions            map[string]*agentSession
+	mutex               sync.RWMutex
+	pollInterval        time.Duration
+	pollTimer           *time.Timer
+}
+
+// AgentGatewayConfig contains configuration for the AgentGateway
+type AgentGatewayConfig struct {
+	DynamoClient       *dynamodb.Client
+	S3Client           *s3.Client
+	DeviceTableName    string
+	RolloutTableName   string
+	ArtifactTableName  string
+	TelemetryTableName string // metrics are dropped when empty
+	PollInterval       time.Duration
+	URLExpiry          time.Duration // lifetime of presigned package URLs
+
+	// AllowUnverifiedIdentity trusts the device and tenant IDs agents claim
+	// in their Hello. By default agents are rejected unless their mTLS client
+	// certificate's common name is the device ID and its organization the
+	// tenant; opt out only where agents have no client certificates, e.g. in
+	// development.
+	AllowUnverifiedIdentity bool
+
+	// TrustedProxies lists gateway devices that may connect on behalf of
+	// downstream devices; their certificate identifies the gateway instead
+	TrustedProxies []string
+
+	// Hub receives device events as agents report them when set
+	Hub *StatusHub
+
+	// Exporter receives agent metrics for the telemetry dataset when set
+	Exporter *EventExporter
+}
+
+// agentSession is one connected agent
+type agentSession struct {
+	stream         agentproto.FleetAgentConnectServer
+	hello          agentproto.Hello
+	key            string // device table partition key, scoped to the tenant
+	currentVersion string
+	configVersion  string
+	dynamicGroups  []string
+	timezone       string // from the device record, which may be provisioned rather than reported
+	window         string // the device's maintenance window
+	healthy        *bool
+	fenced         bool // last fence state dispatch read, so changes are logged once
+	desired        *rollout.DesiredState
+	pending        map[string]string  // command ID -> rollout ID
+	offered        map[string]bool    // rollout IDs already sent to the agent
+	aborting       map[string]bool    // pending command IDs an abort was sent for
+	writeUnits     map[string]float64 // rollout ID -> capacity consumed recording the agent's statuses
+	sendMutex      sync.Mutex
+}
+
+// NewAgentGateway creates a new AgentGateway and starts polling for rollouts
+func NewAgentGateway(config AgentGatewayConfig) *AgentGateway {
+	if config.PollInterval == 0 {
+		config.PollInterval = 30 * time.Second
+	}
+	if config.URLExpiry == 0 {
+		config.URLExpiry = 15 * time.Minute
+	}
+
+	g := &AgentGateway{
+		dynamoClient:        config.DynamoClient,
+		presignClient:       s3.NewPresignClient(config.S3Client),
+		deviceTableName:     config.DeviceTableName,
+		rolloutTableName:    config.RolloutTableName,
+		artifactTableName:   config.ArtifactTableName,
+		telemetryTableName:  config.TelemetryTableName,
+		urlExpiry:           config.URLExpiry,
+		requireCertIdentity: !config.AllowUnverifiedIdentity,
+		trustedProxies:      make(map[string]bool),
+		hub:                 config.Hub,
+		exporter:            config.Exporter,
+		sessions:            make(map[string]*agentSession),
+		pollInterval:        config.PollInterval,
+	}
+
+	for _, proxyID := range config.TrustedProxies {
+		g.trustedProxies[proxyID] = true
+	}
+
+	g.pollTimer = time.AfterFunc(0, g.pollLoop)
+
+	return g
+}
+
+// pollLoop refreshes active rollouts and dispatches commands to connected agents
+func (g *AgentGateway) pollLoop() {
+	defer func() {
+		// Reschedule the poll
+		g.pollTimer.Reset(g.pollInterval)
+	}()
+
+	ctx := context.Background()
+
+	plans, err := ScanRollouts(ctx, g.dynamoClient, g.rolloutTableName)
+	if err != nil {
+		log.Printf("Failed to refresh rollouts for agents: %v", err)
+		return
+	}
+
+	active := make([]rollout.RolloutPlan, 0)
+	aborted := make(map[string]bool)
+	for _, plan := range plans {
+		switch plan.Status {
+		case "in-progress":
+			active = append(active, plan)
+		case "aborted":
+			aborted[plan.ID] = true
+		}
+	}
+
+	// Only pay for the device scan when a rollout gates on health
+	var scores map[string]float64
+	for _, plan := range active {
+		if plan.MinHealthScore > 0 {
+			if scores, err = g.loadHealthScores(ctx); err != nil {
+				log.Printf("Failed to load device health scores: %v", err)
+			}
+			break
+		}
+	}
+
+	// Likewise the cohort ranks, only while a rollout is cohorted
+	var cohorts map[string]map[string]float64
+	for _, plan := range active {
+		if plan.CohortBy != "" {
+			if cohorts, err = g.loadCohortPercentiles(ctx); err != nil {
+				log.Printf("Failed to load device cohort percentiles: %v", err)
+			}
+			break
+		}
+	}
+
+	// Operators set desired states on devices directly, so refresh them for
+	// the connected agents; on failure the sessions keep the last known ones
+	desired, err := g.loadDesiredStates(ctx)
+	if err != nil {
+		log.Printf("Failed to load device desired states: %v", err)
+	}
+
+	g.mutex.Lock()
+	g.rollouts = active
+	g.healthScores = scores
+	g.cohorts = cohorts
+	sessions := make([]*agentSession, 0, len(g.sessions))
+	for _, session := range g.sessions {
+		sessions = append(sessions, session)
+	}
+	g.mutex.Unlock()
+
+	for _, session := range sessions {
+		if desired != nil {
+			session.sendMutex.Lock()
+			session.desired = desired[session.key]
+			session.sendMutex.Unlock()
+		}
+		g.abortPending(session, aborted)
+		g.dispatch(ctx, session)
+	}
+}
+
+// abortPending tells the agent to abort its in-flight update when the
+// rollout it belongs to was aborted, or the desired state it applies was
+// replaced or cleared
+func (g *AgentGateway) abortPending(session *agentSession, aborted map[string]bool) {
+	session.sendMutex.Lock()
+	defer session.sendMutex.Unlock()
+
+	for commandID, rolloutID := range session.pending {
+		stale := aborted[rolloutID]
+		if rollout.IsDesiredState(rolloutID) {
+			stale = session.desired == nil || session.desired.ID != rolloutID
+		}
+		if !stale || session.aborting[commandID] {
+			continue
+		}
+
+		command := &agentproto.Command{
+			ID:        uuid.New().String(),
+			Type:      agentproto.CommandAbort,
+			RolloutID: rolloutID,
+		}
+		if err := session.stream.Send(&agentproto.ServerMessage{Command: command}); err != nil {
+			log.Printf("Failed to send abort to %s: %v", session.hello.DeviceID, err)
+			return
+		}
+		session.aborting[commandID] = true
+	}
+}
+
+// Close stops the gateway's polling
+func (g *AgentGateway) Close() {
+	if g.pollTimer != nil {
+		g.pollTimer.Stop()
+	}
+}
+
+// Connect implements agentproto.FleetAgentServer
+func (g *AgentGateway) Connect(stream agentproto.FleetAgentConnectServer) error {
+	ctx := stream.Context()
+
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Hello == nil || first.Hello.DeviceID == "" {
+		return status.Error(codes.InvalidArgument, "first message must be a hello with a device ID")
+	}
+
+	if first.Hello.TenantID != "" {
+		if err := tenant.Validate(first.Hello.TenantID); err != nil {
+			return status.Error(codes.InvalidArgument, err.Error())
+		}
+	}
+
+	if g.requireCertIdentity {
+		identity := first.Hello.DeviceID
+		if first.Hello.ProxyID != "" {
+			if !g.trustedProxies[first.Hello.ProxyID] {
+				return status.Errorf(codes.PermissionDenied, "%q is not a trusted proxy", first.Hello.ProxyID)
+			}
+			identity = first.Hello.ProxyID
+		}
+
+		if err := verifyPeerIdentity(ctx, identity, first.Hello.TenantID); err != nil {
+			return err
+		}
+	}
+
+	session := &agentSession{
+		stream:         stream,
+		hello:          *first.Hello,
+		key:            tenant.Key(first.Hello.TenantID, first.Hello.DeviceID),
+		currentVersion: first.Hello.CurrentVersion,
+		configVersion:  first.Hello.ConfigVersion,
+		pending:        make(map[string]string),
+		offered:        make(map[string]bool),
+		aborting:       make(map[string]bool),
+		writeUnits:     make(map[string]float64),
+	}
+
+	record, err := g.registerDevice(ctx, session.hello)
+	if err != nil {
+		log.Printf("Failed to register device %s: %v", session.hello.DeviceID, err)
+		return status.Error(codes.Unavailable, "failed to register device")
+	}
+
+	session.dynamicGroups = record.DynamicGroups
+	session.timezone = record.Timezone
+	session.window = record.MaintenanceWindow
+	session.desired = record.Desired
+
+	// Don't retry a rollout this device already failed across reconnects
+	if record.UpdateStatus == agentproto.StatusFailed || record.UpdateStatus == agentproto.StatusRolledBack {
+		session.offered[record.LastUpdateID] = true
+	}
+
+	deviceID := session.key
+	g.mutex.Lock()
+	if _, ok := g.sessions[deviceID]; ok {
+		log.Printf("Device %s reconnected, replacing previous stream", deviceID)
+	}
+	g.sessions[deviceID] = session
+	g.mutex.Unlock()
+
+	g.hub.SetLive(deviceID, true)
+	g.publish(session, DeviceEvent{Type: EventConnected, Version: session.currentVersion})
+
+	defer func() {
+		g.mutex.Lock()
+		current := g.sessions[deviceID] == session
+		if current {
+			delete(g.sessions, deviceID)
+		}
+		g.mutex.Unlock()
+
+		if current {
+			g.hub.SetLive(deviceID, false)
+			g.publish(session, DeviceEvent{Type: EventDisconnected})
+		}
+	}()
+
+	if session.hello.ProxyID != "" {
+		log.Printf("Agent %s connected through %s (version %s)", deviceID, session.hello.ProxyID, session.currentVersion)
+	} else {
+		log.Printf("Agent %s connected (version %s)", deviceID, session.currentVersion)
+	}
+
+	// Offer any active rollout immediately rather than waiting for the next poll
+	g.dispatch(ctx, session)
+
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			log.Printf("Agent %s disconnected: %v", deviceID, err)
+			return nil
+		}
+
+		switch {
+		case message.Heartbeat != nil:
+			if err := g.touchDevice(ctx, deviceID, message.Heartbeat.Healthy); err != nil {
+				log.Printf("Failed to record heartbeat for %s: %v", deviceID, err)
+			}
+			g.recordHealth(session, message.Heartbeat.Healthy)
+		case message.Status != nil:
+			if err := g.handleStatus(ctx, session, message.Status); err != nil {
+				log.Printf("Failed to record status for %s: %v", deviceID, err)
+			}
+		case message.Metrics != nil:
+			if err := g.writeMetrics(ctx, deviceID, message.Metrics.Values); err != nil {
+				log.Printf("Failed to record metrics for %s: %v", deviceID, err)
+			}
+		case message.Sync != nil:
+			if err := g.recordSync(ctx, session, message.Sync); err != nil {
+				log.Printf("Failed to record sync for %s: %v", deviceID, err)
+			}
+		}
+	}
+}
+
+// dispatch sends an apply-update command when an active rollout selects the device
+func (g *AgentGateway) dispatch(ctx context.Context, session *agentSession) {
+	g.mutex.RLock()
+	plans := g.rollouts
+	scores := g.healthScores
+	cohorts := g.cohorts
+	g.mutex.RUnlock()
+
+	session.sendMutex.Lock()
+	defer session.sendMutex.Unlock()
+
+	// One update at a time per device
+	if len(session.pending) > 0 {
+		return
+	}
+
+	// The device's own desired state takes precedence over fleet rollouts; it
+	// is offered once, like a rollout, so a failed one waits to be set again
+	if session.desired != nil {
+		plans = []rollout.RolloutPlan{*session.desired.Plan(session.hello.TenantID)}
+	}
+
+	device := DeviceRecord{
+		DeviceID:      session.key,
+		DeviceGroup:   session.hello.DeviceGroup,
+		Region:        session.hello.Region,
+		Timezone:      session.timezone,
+		Tags:          session.hello.Tags,
+		DynamicGroups: session.dynamicGroups,
+		Capabilities:  helloCapabilities(session.hello),
+		Architecture:  session.hello.Architecture,
+
+		CohortPercentiles: cohorts[session.key],
+	}
+
+	for _, plan := range plans {
+		// The agent protocol carries one package per command; bundles are
+		// applied by polling devices only
+		if plan.IsBundle() {
+			continue
+		}
+
+		current := session.currentVersion
+		if plan.IsConfigOnly() {
+			current = session.configVersion
+		}
+		if plan.Version == current || session.offered[plan.ID] {
+			continue
+		}
+		if !rollout.IsDesiredState(plan.ID) && (!targetsDevice(plan, device) || !inCurrentPhase(plan, session.hello.DeviceID, device)) {
+			continue
+		}
+		// Not marked offered either, so the device gets it after business
+		// hours or once its maintenance window opens
+		now := time.Now()
+		if plan.InBusinessHours(now, device.Timezone, time.UTC) {
+			continue
+		}
+		if phase := plan.Phases[plan.CurrentPhase]; !rollout.InWindow(phase.WindowFor(session.window), now, device.Timezone, time.UTC) {
+			continue
+		}
+		// Not marked offered, so the device is reconsidered once its score recovers
+		if score, ok := scores[session.key]; plan.MinHealthScore > 0 && (!ok || score < plan.MinHealthScore) {
+			continue
+		}
+
+		// Devices whose attestation failed get no command until they are
+		// unfenced; the fence is read now, as it can change at any time
+		fenced, reason, err := g.loadFence(ctx, session.key)
+		if err != nil {
+			log.Printf("Failed to read fence of %s: %v", device.DeviceID, err)
+			return
+		}
+		if fenced != session.fenced {
+			session.fenced = fenced
+			if fenced {
+				log.Printf("Withholding rollouts from fenced device %s: %s", device.DeviceID, reason)
+			}
+		}
+		if fenced {
+			return
+		}
+
+		command, err := g.buildCommand(ctx, plan, session.hello.Architecture)
+		if err != nil {
+			log.Printf("Failed to build command for rollout %s: %v", plan.ID, err)
+			continue
+		}
+
+		if err := session.stream.Send(&agentproto.ServerMessage{Command: command}); err != nil {
+			log.Printf("Failed to send command to %s: %v", device.DeviceID, err)
+			return
+		}
+
+		session.pending[command.ID] = plan.ID
+		session.offered[plan.ID] = true
+		return
+	}
+}
+
+// buildCommand resolves the rollout package for the device's architecture
+// and presigns a download URL for it
+func (g *AgentGateway) buildCommand(ctx context.Context, plan rollout.RolloutPlan, architecture string) (*agentproto.Command, error) {
+	if plan.IsConfigOnly() {
+		return &agentproto.Command{
+			ID:            uuid.New().String(),
+			Type:          agentproto.CommandApplyConfig,
+			RolloutID:     plan.ID,
+			Version:       plan.Version,
+			ConfigPayload: plan.ConfigPayload,
+		}, nil
+	}
+
+	packageURL, packageHash, artifactName, err := plan.PackageFor(architecture)
+	if err != nil {
+		return nil, err
+	}
+
+	if artifactName != "" {
+		artifact, err := publisher.Resolve(ctx, g.dynamoClient, g.artifactTableName, tenant.Key(plan.TenantID, artifactName), plan.Version)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve artifact: %w", err)
+		}
+		packageURL, packageHash = artifact.URL, artifact.SHA256
+	}
+
+	if strings.HasPrefix(packageURL, "s3://") {
+		bucket, key, ok := strings.Cut(strings.TrimPrefix(packageURL, "s3://"), "/")
+		if !ok {
+			return nil, fmt.Errorf("invalid S3 URL format: %s", packageURL)
+		}
+
+		presigned, err := g.presignClient.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		}, s3.WithPresignExpires(g.urlExpiry))
+		if err != nil {
+			return nil, fmt.Errorf("failed to presign package URL: %w", err)
+		}
+		packageURL = presigned.URL
+	}
+
+	return &agentproto.Command{
+		ID:          uuid.New().String(),
+		Type:        agentproto.CommandApplyUpdate,
+		RolloutID:   plan.ID,
+		Version:     plan.Version,
+		PackageURL:  packageURL,
+		PackageHash: packageHash,
+	}, nil
+}
+
+// handleStatus records an agent's update progress in the device table
+func (g *AgentGateway) handleStatus(ctx context.Context, session *agentSession, update *agentproto.UpdateStatus) error {
+	final := false
+	switch update.Status {
+	case agentproto.StatusSuccess, agentproto.StatusFailed, agentproto.StatusRolledBack, agentproto.StatusAborted, agentproto.StatusIntegrityFailed:
+		final = true
+	}
+
+	session.sendMutex.Lock()
+	if final {
+		delete(session.pending, update.CommandID)
+		delete(session.aborting, update.CommandID)
+	}
+	if update.Status == agentproto.StatusSuccess {
+		if update.Config {
+			session.configVersion = update.Version
+		} else {
+			session.currentVersion = update.Version
+		}
+	}
+	writeUnits := session.writeUnits[update.RolloutID]
+	session.sendMutex.Unlock()
+
+	expression := "SET UpdateStatus = :status, LastUpdateID = :rolloutID, LastUpdateTime = :time, LastUpdateMessage = :message"
+	values := map[string]types.AttributeValue{
+		":status":    &types.AttributeValueMemberS{Value: update.Status},
+		":rolloutID": &types.AttributeValueMemberS{Value: update.RolloutID},
+		":time":      &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		":message":   &types.AttributeValueMemberS{Value: update.Message},
+	}
+	// A TPM quote bound to the report is checked by the AttestationVerifier
+	if update.Attestation != "" || update.AttestationError != "" {
+		expression += ", Attestation = :attestation, AttestationError = :attestationError"
+		values[":attestation"] = &types.AttributeValueMemberS{Value: update.Attestation}
+		values[":attestationError"] = &types.AttributeValueMemberS{Value: update.AttestationError}
+	}
+	if update.Status == agentproto.StatusSuccess {
+		if update.Config {
+			expression += ", ConfigVersion = :version"
+		} else {
+			expression += ", CurrentVersion = :version"
+		}
+		values[":version"] = &types.AttributeValueMemberS{Value: update.Version}
+	}
+	if final {
+		// Usage attributed to the rollout, rolled up by the CostAccountant
+		expression += ", UsageRolloutID = :rolloutID, UsageS3Bytes = :usageBytes, UsageReadUnits = :zero, UsageWriteUnits = :usageWrite"
+		values[":usageBytes"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(update.BytesDownloaded, 10)}
+		values[":usageWrite"] = &types.AttributeValueMemberN{Value: strconv.FormatFloat(writeUnits, 'f', -1, 64)}
+		values[":zero"] = &types.AttributeValueMemberN{Value: "0"}
+	}
+	switch update.Status {
+	case agentproto.StatusSuccess:
+		expression += " ADD UpdatesSucceeded :one"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+	case agentproto.StatusFailed, agentproto.StatusRolledBack:
+		expression += " ADD UpdatesFailed :one"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+	case agentproto.StatusAborted:
+		expression += " ADD UpdatesAborted :one"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+	}
+
+	// Agents number their reports, so one delayed across a reconnect can't
+	// overwrite a newer status; agents that don't are written unconditionally
+	var condition *string
+	if update.Sequence != 0 {
+		expression = strings.Replace(expression, "SET ", "SET StatusSequence = :sequence, ", 1)
+		values[":sequence"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(update.Sequence, 10)}
+		condition = aws.String("attribute_not_exists(StatusSequence) OR StatusSequence < :sequence")
+	}
+
+	result, err := g.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(g.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: session.key},
+		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       condition,
+		ExpressionAttributeValues: values,
+		ReturnConsumedCapacity:    types.ReturnConsumedCapacityTotal,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		log.Printf("Ignoring stale %s status from %s for rollout %s", update.Status, session.hello.DeviceID, update.RolloutID)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update device status: %w", err)
+	}
+
+	if result.ConsumedCapacity != nil {
+		session.sendMutex.Lock()
+		session.writeUnits[update.RolloutID] += aws.ToFloat64(result.ConsumedCapacity.CapacityUnits)
+		session.sendMutex.Unlock()
+	}
+
+	g.publish(session, DeviceEvent{
+		Type:      EventUpdate,
+		RolloutID: update.RolloutID,
+		Version:   update.Version,
+		Status:    update.Status,
+		Message:   update.Message,
+	})
+	if update.Status == agentproto.StatusSuccess && !update.Config {
+		g.publish(session, DeviceEvent{Type: EventVersion, Version: update.Version})
+	}
+
+	return nil
+}
+
+// recordHealth publishes a health event when a heartbeat changes the device's health
+func (g *AgentGateway) recordHealth(session *agentSession, healthy bool) {
+	session.sendMutex.Lock()
+	changed := session.healthy == nil || *session.healthy != healthy
+	session.healthy = &healthy
+	session.sendMutex.Unlock()
+
+	if changed {
+		g.publish(session, DeviceEvent{Type: EventHealth, Healthy: &healthy})
+	}
+}
+
+// recordSync stores the outcome of an offline sync on the device record
+func (g *AgentGateway) recordSync(ctx context.Context, session *agentSession, event *agentproto.SyncEvent) error {
+	_, err := g.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(g.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: session.key},
+		},
+		UpdateExpression: aws.String("SET LastSyncTime = :time, LastSyncStatus = :status"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":time":   &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":status": &types.AttributeValueMemberS{Value: event.Status},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update sync status: %w", err)
+	}
+
+	g.publish(session, DeviceEvent{
+		Type:    EventSync,
+		Status:  event.Status,
+		Message: event.Message,
+	})
+
+	return nil
+}
+
+// publish fills in the device identity and sends an event to the hub
+func (g *AgentGateway) publish(session *agentSession, event DeviceEvent) {
+	event.DeviceID = session.key
+	event.TenantID = session.hello.TenantID
+	event.DeviceGroup = session.hello.DeviceGroup
+	g.hub.Publish(event)
+}
+
+// registerDevice upserts the device record from an agent's hello and returns the stored record
+func (g *AgentGateway) registerDevice(ctx context.Context, hello agentproto.Hello) (*DeviceRecord, error) {
+	tags := make(map[string]types.AttributeValue, len(hello.Tags))
+	for k, v := range hello.Tags {
+		tags[k] = &types.AttributeValueMemberS{Value: v}
+	}
+
+	expression := "SET Region = :region, Tags = :tags, AgentVersion = :agentVersion, LastSeen = :time"
+	values := map[string]types.AttributeValue{
+		":region":       &types.AttributeValueMemberS{Value: hello.Region},
+		":tags":         &types.AttributeValueMemberM{Value: tags},
+		":agentVersion": &types.AttributeValueMemberS{Value: hello.AgentVersion},
+		":time":         &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+
+	// Empty strings would break the sparse version and group indexes
+	if hello.DeviceGroup != "" {
+		expression += ", DeviceGroup = :group"
+		values[":group"] = &types.AttributeValueMemberS{Value: hello.DeviceGroup}
+	}
+	if hello.CurrentVersion != "" {
+		expression += ", CurrentVersion = :version"
+		values[":version"] = &types.AttributeValueMemberS{Value: hello.CurrentVersion}
+	}
+	if hello.Timezone != "" {
+		expression += ", Timezone = :timezone"
+		values[":timezone"] = &types.AttributeValueMemberS{Value: hello.Timezone}
+	}
+	if hello.MaintenanceWindow != "" {
+		expression += ", MaintenanceWindow = :window"
+		values[":window"] = &types.AttributeValueMemberS{Value: hello.MaintenanceWindow}
+	}
+	if hello.ConfigVersion != "" {
+		expression += ", ConfigVersion = :configVersion"
+		values[":configVersion"] = &types.AttributeValueMemberS{Value: hello.ConfigVersion}
+	}
+	if hello.Architecture != "" {
+		expression += ", Architecture = :architecture"
+		values[":architecture"] = &types.AttributeValueMemberS{Value: hello.Architecture}
+	}
+	if capabilities := helloCapabilities(hello); capabilities != nil {
+		item, err := attributevalue.Marshal(capabilities)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal capabilities: %w", err)
+		}
+		expression += ", Capabilities = :capabilities"
+		values[":capabilities"] = item
+	}
+
+	// Record the gateway serving the device, clearing it once the device
+	// connects directly. A device that connects is no longer decommissioned,
+	// so its expiry is cleared too; the LifecycleManager sets a new one.
+	remove := " REMOVE ExpiresAt, ProxyID"
+	if hello.ProxyID != "" {
+		expression += ", ProxyID = :proxy"
+		values[":proxy"] = &types.AttributeValueMemberS{Value: hello.ProxyID}
+		remove = " REMOVE ExpiresAt"
+	}
+	expression += remove
+
+	result, err := g.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(g.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(hello.TenantID, hello.DeviceID)},
+		},
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeValues: values,
+		ReturnValues:              types.ReturnValueAllNew,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upsert device: %w", err)
+	}
+
+	var record DeviceRecord
+	if err := attributevalue.UnmarshalMap(result.Attributes, &record); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device: %w", err)
+	}
+
+	return &record, nil
+}
+
+// loadHealthScores reads every scored device's health score
+func (g *AgentGateway) loadHealthScores(ctx context.Context) (map[string]float64, error) {
+	scores := make(map[string]float64)
+
+	paginator := dynamodb.NewScanPaginator(g.dynamoClient, &dynamodb.ScanInput{
+		TableName:            aws.String(g.deviceTableName),
+		ProjectionExpression: aws.String("DeviceID, HealthScore"),
+		FilterExpression:     aws.String("attribute_exists(HealthScore)"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan health scores: %w", err)
+		}
+
+		for _, item := range page.Items {
+			var record struct {
+				DeviceID    string  `dynamodbav:"DeviceID"`
+				HealthScore float64 `dynamodbav:"HealthScore"`
+			}
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				continue
+			}
+			scores[record.DeviceID] = record.HealthScore
+		}
+	}
+
+	return scores, nil
+}
+
+// loadCohortPercentiles reads every ranked device's cohort percentiles
+func (g *AgentGateway) loadCohortPercentiles(ctx context.Context) (map[string]map[string]float64, error) {
+	cohorts := make(map[string]map[string]float64)
+
+	paginator := dynamodb.NewScanPaginator(g.dynamoClient, &dynamodb.ScanInput{
+		TableName:            aws.String(g.deviceTableName),
+		ProjectionExpression: aws.String("DeviceID, CohortPercentiles"),
+		FilterExpression:     aws.String("attribute_exists(CohortPercentiles)"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan cohort percentiles: %w", err)
+		}
+
+		for _, item := range page.Items {
+			var record struct {
+				DeviceID          string             `dynamodbav:"DeviceID"`
+				CohortPercentiles map[string]float64 `dynamodbav:"CohortPercentiles"`
+			}
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				continue
+			}
+			cohorts[record.DeviceID] = record.CohortPercentiles
+		}
+	}
+
+	return cohorts, nil
+}
+
+// loadDesiredStates reads the desired state of every device that has one
+func (g *AgentGateway) loadDesiredStates(ctx context.Context) (map[string]*rollout.DesiredState, error) {
+	desired := make(map[string]*rollout.DesiredState)
+
+	paginator := dynamodb.NewScanPaginator(g.dynamoClient, &dynamodb.ScanInput{
+		TableName:            aws.String(g.deviceTableName),
+		ProjectionExpression: aws.String("DeviceID, Desired"),
+		FilterExpression:     aws.String("attribute_exists(Desired)"),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan desired states: %w", err)
+		}
+
+		for _, item := range page.Items {
+			var record struct {
+				DeviceID string                `dynamodbav:"DeviceID"`
+				Desired  *rollout.DesiredState `dynamodbav:"Desired"`
+			}
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				continue
+			}
+			desired[record.DeviceID] = record.Desired
+		}
+	}
+
+	return desired, nil
+}
+
+// loadFence reads whether the AttestationVerifier fenced a device, and why
+func (g *AgentGateway) loadFence(ctx context.Context, deviceID string) (bool, string, error) {
+	result, err := g.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(g.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		ProjectionExpression: aws.String("Fenced, FencedReason"),
+	})
+	if err != nil {
+		return false, "", fmt.Errorf("failed to get device: %w", err)
+	}
+
+	var record struct {
+		Fenced       bool   `dynamodbav:"Fenced"`
+		FencedReason string `dynamodbav:"FencedReason"`
+	}
+	if err := attributevalue.UnmarshalMap(result.Item, &record); err != nil {
+		return false, "", fmt.Errorf("failed to unmarshal device: %w", err)
+	}
+
+	return record.Fenced, record.FencedReason, nil
+}
+
+// touchDevice records a heartbeat
+func (g *AgentGateway) touchDevice(ctx context.Context, deviceID string, healthy bool) error {
+	_, err := g.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(g.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression: aws.String("SET LastSeen = :time, Healthy = :healthy"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":time":    &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+			":healthy": &types.AttributeValueMemberBOOL{Value: healthy},
+		},
+	})
+	return err
+}
+
+// writeMetrics stores "name=value" metrics in the telemetry table in the
+// same shape as the device-side DynamoReporter
+func (g *AgentGateway) writeMetrics(ctx context.Context, deviceID string, metrics []string) error {
+	if g.telemetryTableName == "" && g.exporter == nil {
+		return nil
+	}
+
+	values := make(map[string]types.AttributeValue, len(metrics))
+	parsed := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		name, value, ok := strings.Cut(metric, "=")
+		if !ok {
+			continue
+		}
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		values[name] = &types.AttributeValueMemberN{Value: value}
+		parsed[name] = number
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	if g.exporter != nil {
+		g.exporter.RecordTelemetry(deviceID, time.Now().UTC(), parsed)
+	}
+	if g.telemetryTableName == "" {
+		return nil
+	}
+
+	_, err := g.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(g.telemetryTableName),
+		Item: map[string]types.AttributeValue{
+			"DeviceID":  &types.AttributeValueMemberS{Value: deviceID},
+			"Timestamp": &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339Nano)},
+			"Metrics":   &types.AttributeValueMemberM{Value: values},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write telemetry: %w", err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// inCurrentPhase applies the regional waves, group overrides, approval gating
+// and deterministic percentage selection of RolloutManager.CheckEligibility.
+// dispatch checks windows, health scores and fences itself; entitlements and
+// bandwidth caps are only known to polling devices, so gRPC agents skip them.
+func inCurrentPhase(plan rollout.RolloutPlan, deviceID string, device DeviceRecord) bool {
+	if plan.CurrentPhase >= len(plan.Phases) || !plan.RegionOpen(device.Region) {
+		return false
+	}
+
+	phase := plan.Phases[plan.CurrentPhase].ForGroup(device.DeviceGroup)
+	if phase.RequireApproval && !phase.Approved {
+		return false
+	}
+
+	return plan.PercentileFor(deviceID, device.CohortPercentiles) <= phase.Percentage
+}
+
+func verifyPeerIdentity(ctx context.Context, deviceID, tenantID string) error {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return status.Error(codes.Unauthenticated, "no peer information")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return status.Error(codes.Unauthenticated, "client certificate required")
+	}
+
+	subject := tlsInfo.State.VerifiedChains[0][0].Subject
+	if subject.CommonName != deviceID {
+		return status.Errorf(codes.PermissionDenied, "certificate identity %q does not match device %q", subject.CommonName, deviceID)
+	}
+
+	// Tenant devices carry their tenant ID as the certificate organization,
+	// and others none, so a tenant's device can't connect as untenanted
+	certTenant := ""
+	if len(subject.Organization) > 0 {
+		certTenant = subject.Organization[0]
+	}
+	if certTenant != tenantID {
+		return status.Errorf(codes.PermissionDenied, "certificate is issued to tenant %q, not %q", certTenant, tenantID)
+	}
+
+	return nil
+}
+
+// helloCapabilities returns the capabilities an agent advertised, or nil for
+// agents that predate capability negotiation
+func helloCapabilities(hello agentproto.Hello) *rollout.Capabilities {
+	if len(hello.Handlers) == 0 {
+		return nil
+	}
+	return &rollout.Capabilities{
+		Handlers:       hello.Handlers,
+		PackageFormats: hello.PackageFormats,
+		SchemaVersions: hello.SchemaVersions,
+	}
+}
@@ -0,0 +1,465 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// DeviceRecord is the server-side view of an item in the device table
+type DeviceRecord struct {
+	DeviceID          string            `dynamodbav:"DeviceID" json:"deviceId"`
+	DeviceGroup       string            `dynamodbav:"DeviceGroup" json:"deviceGroup"`
+	Region            string            `dynamodbav:"Region" json:"region"`
+	Timezone          string            `dynamodbav:"Timezone,omitempty" json:"timezone,omitempty"`
+	CurrentVersion    string            `dynamodbav:"CurrentVersion" json:"currentVersion"`
+	ConfigVersion     string            `dynamodbav:"ConfigVersion,omitempty" json:"configVersion,omitempty"`
+	UpdateStatus      string            `dynamodbav:"UpdateStatus" json:"updateStatus"`
+	LastUpdateID      string            `dynamodbav:"LastUpdateID" json:"lastUpdateId"`
+	LastUpdateTime    string            `dynamodbav:"LastUpdateTime" json:"lastUpdateTime"`
+	LastUpdateMessage string            `dynamodbav:"LastUpdateMessage" json:"lastUpdateMessage"`
+	Tags              map[string]string `dynamodbav:"Tags" json:"tags"`
+	DynamicGroups     []string          `dynamodbav:"DynamicGroups" json:"dynamicGroups"`
+	Healthy           *bool             `dynamodbav:"Healthy,omitempty" json:"healthy,omitempty"`
+	LastSyncTime      string            `dynamodbav:"LastSyncTime,omitempty" json:"lastSyncTime,omitempty"`
+	LastSyncStatus    string            `dynamodbav:"LastSyncStatus,omitempty" json:"lastSyncStatus,omitempty"`
+	LastSeen          string            `dynamodbav:"LastSeen,omitempty" json:"lastSeen,omitempty"`
+	UpdatesSucceeded  int               `dynamodbav:"UpdatesSucceeded,omitempty" json:"updatesSucceeded,omitempty"`
+	UpdatesFailed     int               `dynamodbav:"UpdatesFailed,omitempty" json:"updatesFailed,omitempty"`
+	UpdatesAborted    int               `dynamodbav:"UpdatesAborted,omitempty" json:"updatesAborted,omitempty"`
+	HealthScore       *float64          `dynamodbav:"HealthScore,omitempty" json:"healthScore,omitempty"`
+	UsageRolloutID    string            `dynamodbav:"UsageRolloutID,omitempty" json:"usageRolloutId,omitempty"`
+	UsageS3Bytes      int64             `dynamodbav:"UsageS3Bytes,omitempty" json:"usageS3Bytes,omitempty"`
+	UsageReadUnits    float64           `dynamodbav:"UsageReadUnits,omitempty" json:"usageReadUnits,omitempty"`
+	UsageWriteUnits   float64           `dynamodbav:"UsageWriteUnits,omitempty" json:"usageWriteUnits,omitempty"`
+	ProxyID           string            `dynamodbav:"ProxyID,omitempty" json:"proxyId,omitempty"`
+	ConfirmedUpdateID string            `dynamodbav:"ConfirmedUpdateID,omitempty" json:"confirmedUpdateId,omitempty"`
+	MaintenanceWindow string            `dynamodbav:"MaintenanceWindow,omitempty" json:"maintenanceWindow,omitempty"` // device-local; replaces phase windows
+	ExpiresAt         int64             `dynamodbav:"ExpiresAt,omitempty" json:"expiresAt,omitempty"`                 // Unix time; DynamoDB's TTL deletes the record after it
+
+	// ClockDriftMs is the device clock minus server time, reported by the
+	// agent's ClockMonitor at ClockCheckedAt
+	ClockDriftMs   *int64 `dynamodbav:"ClockDriftMs,omitempty" json:"clockDriftMs,omitempty"`
+	ClockCheckedAt string `dynamodbav:"ClockCheckedAt,omitempty" json:"clockCheckedAt,omitempty"`
+
+	// Bandwidth is the device's traffic in the billing period starting at
+	// BandwidthPeriodStart, reported by agents with a bandwidth.Meter
+	BandwidthPeriodStart  string `dynamodbav:"BandwidthPeriodStart,omitempty" json:"bandwidthPeriodStart,omitempty"`
+	BandwidthUploaded     int64  `dynamodbav:"BandwidthUploaded,omitempty" json:"bandwidthUploaded,omitempty"`
+	BandwidthDownloaded   int64  `dynamodbav:"BandwidthDownloaded,omitempty" json:"bandwidthDownloaded,omitempty"`
+	BandwidthRolloutBytes int64  `dynamodbav:"BandwidthRolloutBytes,omitempty" json:"bandwidthRolloutBytes,omitempty"`
+	BandwidthSyncBytes    int64  `dynamodbav:"BandwidthSyncBytes,omitempty" json:"bandwidthSyncBytes,omitempty"`
+	BandwidthCap          int64  `dynamodbav:"BandwidthCap,omitempty" json:"bandwidthCap,omitempty"` // 0 is unlimited
+	BandwidthCapped       bool   `dynamodbav:"BandwidthCapped,omitempty" json:"bandwidthCapped,omitempty"`
+
+	// Attestation is the TPM quote the agent attached to its last status
+	// report; the AttestationVerifier checks it against the pinned
+	// AttestationKey and fences the device when it fails
+	Attestation      string `dynamodbav:"Attestation,omitempty" json:"-"`
+	AttestationError string `dynamodbav:"AttestationError,omitempty" json:"attestationError,omitempty"`
+	AttestationKey   []byte `dynamodbav:"AttestationKey,omitempty" json:"-"`
+	AttestedSequence int64  `dynamodbav:"AttestedSequence,omitempty" json:"attestedSequence,omitempty"`
+	AttestedAt       string `dynamodbav:"AttestedAt,omitempty" json:"attestedAt,omitempty"`
+	Fenced           bool   `dynamodbav:"Fenced,omitempty" json:"fenced,omitempty"`
+	FencedReason     string `dynamodbav:"FencedReason,omitempty" json:"fencedReason,omitempty"`
+
+	// Capabilities is what the device's agent advertised it can install;
+	// rollouts it can't install don't target it
+	Capabilities *rollout.Capabilities `dynamodbav:"Capabilities,omitempty" json:"capabilities,omitempty"`
+
+	// Architecture is the CPU architecture the device reported, e.g. arm64;
+	// multi-architecture rollouts without a package for it don't target it
+	Architecture string `dynamodbav:"Architecture,omitempty" json:"architecture,omitempty"`
+
+	// CohortPercentiles is the device's rank within its cohort of each
+	// cohorted rollout targeting it, by rollout ID; set by the CohortRanker
+	CohortPercentiles map[string]float64 `dynamodbav:"CohortPercentiles,omitempty" json:"cohortPercentiles,omitempty"`
+
+	// PollInterval is the check interval the PollAdvisor last suggested
+	PollInterval string `dynamodbav:"PollInterval,omitempty" json:"pollInterval,omitempty"`
+
+	// Desired is the state an operator set for this device alone; see Shadow
+	Desired *rollout.DesiredState `dynamodbav:"Desired,omitempty" json:"desired,omitempty"`
+}
+
+// Expired reports whether a decommissioned device's record has outlived its
+// ExpiresAt and only awaits TTL deletion
+func (d DeviceRecord) Expired(now time.Time) bool {
+	return d.ExpiresAt != 0 && now.Unix() >= d.ExpiresAt
+}
+
+// Tenant returns the tenant that owns the device, taken from its partition key
+func (d DeviceRecord) Tenant() string {
+	tenantID, _ := tenant.Split(d.DeviceID)
+	return tenantID
+}
+
+// VersionFor returns the device version a rollout compares against: the
+// config version for config-only rollouts, otherwise the package version
+func (d DeviceRecord) VersionFor(plan rollout.RolloutPlan) string {
+	if plan.IsConfigOnly() {
+		return d.ConfigVersion
+	}
+	return d.CurrentVersion
+}
+
+// RolloutProgress summarizes how far a rollout has progressed through its phases
+type RolloutProgress struct {
+	ID                string  `json:"id"`
+	TenantID          string  `json:"tenantId,omitempty"`
+	Name              string  `json:"name"`
+	Version           string  `json:"version"`
+	Status            string  `json:"status"`
+	CurrentPhase      int     `json:"currentPhase"`
+	TotalPhases       int     `json:"totalPhases"`
+	TargetPercentage  float64 `json:"targetPercentage"`
+	AwaitingApproval  bool    `json:"awaitingApproval"`
+	DevicesSucceeded  int     `json:"devicesSucceeded"`
+	DevicesFailed     int     `json:"devicesFailed"`
+	DevicesRefused    int     `json:"devicesRefused"` // reported integrity-failed; not counted as failures of the rollout
+	DevicesOnVersion  int     `json:"devicesOnVersion"`
+	DevicesTargeted   int     `json:"devicesTargeted"`
+	DevicesSkipped    int     `json:"devicesSkipped"` // in the target groups but can't install the rollout; not targeted
+	CompletionPercent float64 `json:"completionPercent"`
+}
+
+// FailureCell counts update outcomes for one group and region
+type FailureCell struct {
+	Total       int     `json:"total"`
+	Failed      int     `json:"failed"`
+	FailureRate float64 `json:"failureRate"`
+}
+
+// FleetView is a pre-computed snapshot of the fleet for the dashboard
+type FleetView struct {
+	GeneratedAt         time.Time                         `json:"generatedAt"`
+	TotalDevices        int                               `json:"totalDevices"`
+	VersionDistribution map[string]int                    `json:"versionDistribution"`
+	GroupVersions       map[string]map[string]int         `json:"groupVersions"`
+	Rollouts            []RolloutProgress                 `json:"rollouts"`
+	FailureHeatmap      map[string]map[string]FailureCell `json:"failureHeatmap"`
+}
+
+// Aggregator scans the device and rollout tables and pre-computes fleet views
+type Aggregator struct {
+	dynamoClient     *dynamodb.Client
+	deviceTableName  string
+	rolloutTableName string
+	view             *FleetView
+	tenantViews      map[string]*FleetView
+	viewMutex        sync.RWMutex
+	hub              *StatusHub
+	devices          map[string]DeviceRecord
+	refreshInterval  time.Duration
+	refreshTimer     *time.Timer
+}
+
+// AggregatorConfig contains configuration for the Aggregator
+type AggregatorConfig struct {
+	DynamoClient     *dynamodb.Client
+	DeviceTableName  string
+	RolloutTableName string
+	RefreshInterval  time.Duration
+	Hub              *StatusHub // receives device changes found between refreshes when set
+}
+
+// NewAggregator creates a new Aggregator and computes the initial view
+func NewAggregator(config AggregatorConfig) (*Aggregator, error) {
+	a := &Aggregator{
+		dynamoClient:     config.DynamoClient,
+		deviceTableName:  config.DeviceTableName,
+		rolloutTableName: config.RolloutTableName,
+		refreshInterval:  config.RefreshInterval,
+		hub:              config.Hub,
+	}
+
+	if err := a.Refresh(context.Background()); err != nil {
+		return nil, fmt.Errorf("failed to compute initial fleet view: %w", err)
+	}
+
+	// Start the refresh timer
+	a.refreshTimer = time.AfterFunc(a.refreshInterval, a.refreshLoop)
+
+	return a, nil
+}
+
+// refreshLoop refreshes the view and reschedules itself
+func (a *Aggregator) refreshLoop() {
+	defer func() {
+		// Reschedule the refresh
+		a.refreshTimer.Reset(a.refreshInterval)
+	}()
+
+	if err := a.Refresh(context.Background()); err != nil {
+		log.Printf("Failed to refresh fleet view: %v", err)
+	}
+}
+
+// Refresh rescans the tables and replaces the cached view
+func (a *Aggregator) Refresh(ctx context.Context) error {
+	devices, err := ScanDevices(ctx, a.dynamoClient, a.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	rollouts, err := ScanRollouts(ctx, a.dynamoClient, a.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	view := buildFleetView(devices, rollouts)
+	tenantViews := buildTenantViews(devices, rollouts)
+
+	a.viewMutex.Lock()
+	a.view = view
+	a.tenantViews = tenantViews
+	previous := a.devices
+	a.devices = make(map[string]DeviceRecord, len(devices))
+	for _, device := range devices {
+		a.devices[device.DeviceID] = device
+	}
+	a.viewMutex.Unlock()
+
+	// Publish changes since the last scan for devices that write the table directly
+	if a.hub != nil && previous != nil {
+		for _, event := range diffDevices(previous, devices) {
+			if a.hub.IsLive(event.DeviceID) {
+				continue
+			}
+			a.hub.Publish(event)
+		}
+	}
+
+	return nil
+}
+
+// View returns the most recently computed fleet view
+func (a *Aggregator) View() *FleetView {
+	a.viewMutex.RLock()
+	defer a.viewMutex.RUnlock()
+	return a.view
+}
+
+// ViewFor returns the most recent view of one tenant's devices and rollouts;
+// the empty tenant gets the fleet-wide view
+func (a *Aggregator) ViewFor(tenantID string) *FleetView {
+	if tenantID == "" {
+		return a.View()
+	}
+
+	a.viewMutex.RLock()
+	view, ok := a.tenantViews[tenantID]
+	a.viewMutex.RUnlock()
+
+	if !ok {
+		return buildFleetView(nil, nil)
+	}
+	return view
+}
+
+// DevicesFor returns the devices read by the most recent refresh, sorted by
+// ID; the empty tenant gets every device
+func (a *Aggregator) DevicesFor(tenantID string) []DeviceRecord {
+	a.viewMutex.RLock()
+	devices := make([]DeviceRecord, 0, len(a.devices))
+	for _, device := range a.devices {
+		if tenantID == "" || device.Tenant() == tenantID {
+			devices = append(devices, device)
+		}
+	}
+	a.viewMutex.RUnlock()
+
+	sort.Slice(devices, func(i, j int) bool { return devices[i].DeviceID < devices[j].DeviceID })
+	return devices
+}
+
+// Close stops the aggregator
+func (a *Aggregator) Close() {
+	if a.refreshTimer != nil {
+		a.refreshTimer.Stop()
+	}
+}
+
+// buildFleetView computes all dashboard views from raw table contents
+func buildFleetView(devices []DeviceRecord, rollouts []rollout.RolloutPlan) *FleetView {
+	view := &FleetView{
+		GeneratedAt:         time.Now().UTC(),
+		TotalDevices:        len(devices),
+		VersionDistribution: make(map[string]int),
+		GroupVersions:       make(map[string]map[string]int),
+		Rollouts:            make([]RolloutProgress, 0),
+		FailureHeatmap:      make(map[string]map[string]FailureCell),
+	}
+
+	for _, device := range devices {
+		version := device.CurrentVersion
+		if version == "" {
+			version = "unknown"
+		}
+		view.VersionDistribution[version]++
+
+		group := valueOr(device.DeviceGroup, "ungrouped")
+		if view.GroupVersions[group] == nil {
+			view.GroupVersions[group] = make(map[string]int)
+		}
+		view.GroupVersions[group][version]++
+
+		if device.UpdateStatus == "" {
+			continue
+		}
+
+		region := valueOr(device.Region, "unknown")
+		if view.FailureHeatmap[group] == nil {
+			view.FailureHeatmap[group] = make(map[string]FailureCell)
+		}
+		cell := view.FailureHeatmap[group][region]
+		cell.Total++
+		if device.UpdateStatus == "failed" {
+			cell.Failed++
+		}
+		cell.FailureRate = float64(cell.Failed) / float64(cell.Total)
+		view.FailureHeatmap[group][region] = cell
+	}
+
+	for _, plan := range rollouts {
+		if plan.Status != "in-progress" && plan.Status != "pending" && plan.Status != "paused" {
+			continue
+		}
+		view.Rollouts = append(view.Rollouts, rolloutProgress(plan, devices))
+	}
+
+	sort.Slice(view.Rollouts, func(i, j int) bool { return view.Rollouts[i].ID < view.Rollouts[j].ID })
+
+	return view
+}
+
+// rolloutProgress computes progress for a single rollout
+func rolloutProgress(plan rollout.RolloutPlan, devices []DeviceRecord) RolloutProgress {
+	progress := RolloutProgress{
+		ID:           plan.ID,
+		TenantID:     plan.TenantID,
+		Name:         plan.Name,
+		Version:      plan.Version,
+		Status:       plan.Status,
+		CurrentPhase: plan.CurrentPhase,
+		TotalPhases:  len(plan.Phases),
+	}
+
+	if plan.CurrentPhase < len(plan.Phases) {
+		phase := plan.Phases[plan.CurrentPhase]
+		progress.TargetPercentage = phase.Percentage
+		progress.AwaitingApproval = phase.RequireApproval && !phase.Approved
+	}
+
+	for _, device := range devices {
+		if !inTarget(plan, device) {
+			continue
+		}
+		if !canInstall(plan, device) {
+			progress.DevicesSkipped++
+			continue
+		}
+		progress.DevicesTargeted++
+
+		if device.VersionFor(plan) == plan.Version {
+			progress.DevicesOnVersion++
+		}
+
+		if device.LastUpdateID != plan.ID {
+			continue
+		}
+
+		switch device.UpdateStatus {
+		case "success":
+			progress.DevicesSucceeded++
+		case "failed":
+			progress.DevicesFailed++
+		case "integrity-failed":
+			progress.DevicesRefused++
+		}
+	}
+
+	if progress.DevicesTargeted > 0 {
+		progress.CompletionPercent = 100 * float64(progress.DevicesOnVersion) / float64(progress.DevicesTargeted)
+	}
+
+	return progress
+}
+
+// Helper functions
+
+// buildTenantViews computes a separate view for each tenant present in the tables
+func buildTenantViews(devices []DeviceRecord, rollouts []rollout.RolloutPlan) map[string]*FleetView {
+	tenantDevices := make(map[string][]DeviceRecord)
+	for _, device := range devices {
+		if tenantID := device.Tenant(); tenantID != "" {
+			tenantDevices[tenantID] = append(tenantDevices[tenantID], device)
+		}
+	}
+
+	tenantRollouts := make(map[string][]rollout.RolloutPlan)
+	for _, plan := range rollouts {
+		if plan.TenantID != "" {
+			tenantRollouts[plan.TenantID] = append(tenantRollouts[plan.TenantID], plan)
+		}
+	}
+
+	views := make(map[string]*FleetView)
+	for tenantID, members := range tenantDevices {
+		views[tenantID] = buildFleetView(members, tenantRollouts[tenantID])
+	}
+	for tenantID, plans := range tenantRollouts {
+		if _, ok := views[tenantID]; !ok {
+			views[tenantID] = buildFleetView(nil, plans)
+		}
+	}
+
+	return views
+}
+
+// targetsDevice reports whether the rollout is for the device: it is in the
+// rollout's tenant, groups and regions, and can install the rollout
+func targetsDevice(plan rollout.RolloutPlan, device DeviceRecord) bool {
+	return inTarget(plan, device) && canInstall(plan, device)
+}
+
+// canInstall reports whether the device has the capabilities the rollout
+// needs and, for a multi-architecture rollout, a package for its architecture
+func canInstall(plan rollout.RolloutPlan, device DeviceRecord) bool {
+	return device.Capabilities.Check(plan) == nil && plan.SupportsArchitecture(device.Architecture)
+}
+
+// inTarget reports whether the device is in the rollout's tenant, groups and regions
+func inTarget(plan rollout.RolloutPlan, device DeviceRecord) bool {
+	return device.Tenant() == plan.TenantID && inGroups(plan.TargetGroups, device) && plan.TargetsRegion(device.Region)
+}
+
+// inGroups reports whether a device belongs to one of the groups, directly or dynamically
+func inGroups(groups []string, device DeviceRecord) bool {
+	for _, g := range groups {
+		if g == device.DeviceGroup || g == "all" {
+			return true
+		}
+		for _, dg := range device.DynamicGroups {
+			if g == dg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
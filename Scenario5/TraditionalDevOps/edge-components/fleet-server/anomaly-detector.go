@@ -0,0 +1,390 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+const (
+	// anomalyZThreshold is the phase threshold key for the z-score at which a metric is anomalous
+	anomalyZThreshold = "anomaly_z"
+
+	// anomalyActionThreshold is the phase threshold key selecting the response to an
+	// anomaly: 0 pauses the rollout, 1 rolls it back
+	anomalyActionThreshold = "anomaly_rollback"
+)
+
+// Anomaly actions
+const (
+	AnomalyActionPause    = "pause"
+	AnomalyActionRollback = "rollback"
+)
+
+// MetricAnomaly compares one metric after the update against its baseline
+type MetricAnomaly struct {
+	Metric          string  `json:"metric"`
+	BaselineMean    float64 `json:"baselineMean"`
+	BaselineStdDev  float64 `json:"baselineStdDev"`
+	BaselineBuckets int     `json:"baselineBuckets"`
+	CurrentEWMA     float64 `json:"currentEwma"`
+	CurrentBuckets  int     `json:"currentBuckets"`
+	ZScore          float64 `json:"zScore"`
+	Anomalous       bool    `json:"anomalous"`
+}
+
+// AnomalyReport is the outcome of checking a rollout phase for anomalies
+type AnomalyReport struct {
+	RolloutID   string          `json:"rolloutId"`
+	PhaseID     string          `json:"phaseId"`
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Devices     int             `json:"devices"`
+	Metrics     []MetricAnomaly `json:"metrics"`
+	Anomalous   bool            `json:"anomalous"`
+	Reason      string          `json:"reason"`
+}
+
+// AnomalyDetector flags statistically significant regressions in phase
+// metrics after devices update. Each updated device's telemetry is aligned
+// on its update time and bucketed; the cohort's bucket means before the
+// update form a rolling baseline, and an exponentially weighted moving
+// average of the buckets after it is scored against that baseline. Unlike
+// the CanaryAnalyzer it needs no control cohort, so it also covers phases
+// that update every targeted device.
+type AnomalyDetector struct {
+	dynamoClient       *dynamodb.Client
+	deviceTableName    string
+	rolloutTableName   string
+	telemetryTableName string
+	baselineWindow     time.Duration
+	bucketSize         time.Duration
+	alpha              float64
+	zThreshold         float64
+	minBaselineBuckets int
+	minCurrentBuckets  int
+	maxDevices         int
+	action             string
+}
+
+// AnomalyDetectorConfig contains configuration for the AnomalyDetector; the
+// z-score threshold and the action can be overridden per phase
+type AnomalyDetectorConfig struct {
+	DynamoClient       *dynamodb.Client
+	DeviceTableName    string
+	RolloutTableName   string
+	TelemetryTableName string
+	BaselineWindow     time.Duration // telemetry before each device's update used as the baseline
+	BucketSize         time.Duration
+	Alpha              float64 // EWMA smoothing factor, 0-1; higher weighs recent buckets more
+	ZThreshold         float64
+	MinBaselineBuckets int
+	MinCurrentBuckets  int
+	MaxDevices         int    // most recently updated devices sampled per evaluation
+	Action             string // pause (default) or rollback
+}
+
+// NewAnomalyDetector creates a new AnomalyDetector
+func NewAnomalyDetector(config AnomalyDetectorConfig) *AnomalyDetector {
+	ad := &AnomalyDetector{
+		dynamoClient:       config.DynamoClient,
+		deviceTableName:    config.DeviceTableName,
+		rolloutTableName:   config.RolloutTableName,
+		telemetryTableName: config.TelemetryTableName,
+		baselineWindow:     config.BaselineWindow,
+		bucketSize:         config.BucketSize,
+		alpha:              config.Alpha,
+		zThreshold:         config.ZThreshold,
+		minBaselineBuckets: config.MinBaselineBuckets,
+		minCurrentBuckets:  config.MinCurrentBuckets,
+		maxDevices:         config.MaxDevices,
+		action:             config.Action,
+	}
+
+	if ad.baselineWindow == 0 {
+		ad.baselineWindow = 6 * time.Hour
+	}
+	if ad.bucketSize == 0 {
+		ad.bucketSize = 5 * time.Minute
+	}
+	if ad.alpha == 0 {
+		ad.alpha = 0.3
+	}
+	if ad.zThreshold == 0 {
+		ad.zThreshold = 3
+	}
+	if ad.minBaselineBuckets == 0 {
+		ad.minBaselineBuckets = 12
+	}
+	if ad.minCurrentBuckets == 0 {
+		ad.minCurrentBuckets = 3
+	}
+	if ad.maxDevices == 0 {
+		ad.maxDevices = 50
+	}
+	if ad.action != AnomalyActionRollback {
+		ad.action = AnomalyActionPause
+	}
+
+	return ad
+}
+
+// Detect checks the current phase of a rollout for metric anomalies
+func (ad *AnomalyDetector) Detect(ctx context.Context, plan rollout.RolloutPlan, devices []DeviceRecord) (*AnomalyReport, error) {
+	report := &AnomalyReport{
+		RolloutID:   plan.ID,
+		GeneratedAt: time.Now().UTC(),
+		Metrics:     make([]MetricAnomaly, 0),
+	}
+
+	if plan.CurrentPhase >= len(plan.Phases) {
+		report.Reason = "rollout has no active phase"
+		return report, nil
+	}
+
+	phase := plan.Phases[plan.CurrentPhase]
+	report.PhaseID = phase.ID
+
+	if len(phase.Metrics) == 0 {
+		report.Reason = "phase defines no metrics"
+		return report, nil
+	}
+
+	updated := ad.updatedDevices(plan, phase, devices)
+	report.Devices = len(updated)
+	if len(updated) == 0 {
+		report.Reason = "no devices have updated in this phase"
+		return report, nil
+	}
+
+	buckets, err := ad.cohortBuckets(ctx, updated)
+	if err != nil {
+		return nil, err
+	}
+
+	zThreshold := thresholdOr(phase.Thresholds, anomalyZThreshold, ad.zThreshold)
+	anomalies := make([]string, 0)
+	insufficient := make([]string, 0)
+
+	for _, entry := range phase.Metrics {
+		metric := strings.TrimSuffix(entry, higherIsBetterSuffix)
+		higherIsBetter := metric != entry
+
+		baseline, current := splitBuckets(buckets[metric])
+		if len(baseline) < ad.minBaselineBuckets || len(current) < ad.minCurrentBuckets {
+			insufficient = append(insufficient, metric)
+			continue
+		}
+
+		result := ad.score(metric, baseline, current)
+		worse := result.ZScore
+		if higherIsBetter {
+			worse = -worse
+		}
+		result.Anomalous = worse > zThreshold
+
+		if result.Anomalous {
+			anomalies = append(anomalies, metric)
+		}
+		report.Metrics = append(report.Metrics, result)
+	}
+
+	switch {
+	case len(anomalies) > 0:
+		report.Anomalous = true
+		report.Reason = "anomalous " + strings.Join(anomalies, ", ")
+	case len(insufficient) > 0:
+		report.Reason = "insufficient telemetry for " + strings.Join(insufficient, ", ")
+	default:
+		report.Reason = "no anomalies"
+	}
+
+	return report, nil
+}
+
+// Action returns the response to an anomaly in a phase
+func (ad *AnomalyDetector) Action(phase rollout.RolloutPhase) string {
+	if value, ok := phase.Thresholds[anomalyActionThreshold]; ok {
+		if value > 0 {
+			return AnomalyActionRollback
+		}
+		return AnomalyActionPause
+	}
+	return ad.action
+}
+
+// updatedDevices returns the most recently updated devices that took the rollout in the current phase
+func (ad *AnomalyDetector) updatedDevices(plan rollout.RolloutPlan, phase rollout.RolloutPhase, devices []DeviceRecord) []DeviceRecord {
+	canary, _ := splitCohorts(plan, devices)
+
+	updated := make([]DeviceRecord, 0, len(canary))
+	for _, device := range canary {
+		updateTime, err := time.Parse(time.RFC3339, device.LastUpdateTime)
+		if err != nil {
+			continue
+		}
+		if !phase.StartTime.IsZero() && updateTime.Before(phase.StartTime) {
+			continue
+		}
+		// Devices updated before the last resume were already judged
+		if !phase.ResumedAt.IsZero() && updateTime.Before(phase.ResumedAt) {
+			continue
+		}
+		updated = append(updated, device)
+	}
+
+	sort.Slice(updated, func(i, j int) bool {
+		return updated[i].LastUpdateTime > updated[j].LastUpdateTime
+	})
+	if len(updated) > ad.maxDevices {
+		updated = updated[:ad.maxDevices]
+	}
+
+	return updated
+}
+
+// cohortBuckets returns, per metric, the cohort mean of each bucket keyed by
+// its offset from the device update time; negative offsets precede the update
+func (ad *AnomalyDetector) cohortBuckets(ctx context.Context, cohort []DeviceRecord) (map[string]map[int]float64, error) {
+	sums := make(map[string]map[int]float64)
+	counts := make(map[string]map[int]int)
+
+	for _, device := range cohort {
+		updateTime, err := time.Parse(time.RFC3339, device.LastUpdateTime)
+		if err != nil {
+			continue
+		}
+
+		err = forEachTelemetry(ctx, ad.dynamoClient, ad.telemetryTableName, device.DeviceID, updateTime.Add(-ad.baselineWindow), func(timestamp time.Time, metrics map[string]float64) {
+			offset := timestamp.Sub(updateTime)
+			bucket := int(math.Floor(float64(offset) / float64(ad.bucketSize)))
+
+			for name, value := range metrics {
+				if sums[name] == nil {
+					sums[name] = make(map[int]float64)
+					counts[name] = make(map[int]int)
+				}
+				sums[name][bucket] += value
+				counts[name][bucket]++
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	buckets := make(map[string]map[int]float64, len(sums))
+	for name, bucketSums := range sums {
+		buckets[name] = make(map[int]float64, len(bucketSums))
+		for bucket, sum := range bucketSums {
+			buckets[name][bucket] = sum / float64(counts[name][bucket])
+		}
+	}
+
+	return buckets, nil
+}
+
+// score computes the z-score of the EWMA of the current buckets against the baseline
+func (ad *AnomalyDetector) score(metric string, baseline, current []float64) MetricAnomaly {
+	mean, variance := meanVariance(baseline)
+	stdDev := math.Sqrt(variance)
+
+	ewma := current[0]
+	for _, value := range current[1:] {
+		ewma = ad.alpha*value + (1-ad.alpha)*ewma
+	}
+
+	// The EWMA varies less than single buckets; scale the baseline deviation to match
+	ewmaStdDev := stdDev * math.Sqrt(ad.alpha/(2-ad.alpha))
+
+	// Keep a perfectly flat baseline from turning noise into an infinite z-score
+	if floor := 0.01 * math.Abs(mean); ewmaStdDev < floor {
+		ewmaStdDev = floor
+	}
+
+	result := MetricAnomaly{
+		Metric:          metric,
+		BaselineMean:    mean,
+		BaselineStdDev:  stdDev,
+		BaselineBuckets: len(baseline),
+		CurrentEWMA:     ewma,
+		CurrentBuckets:  len(current),
+	}
+	if ewmaStdDev > 0 {
+		result.ZScore = (ewma - mean) / ewmaStdDev
+	}
+
+	return result
+}
+
+// RegisterRoutes registers the anomaly detection API on the server
+func (ad *AnomalyDetector) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/rollouts/{id}/anomalies", http.HandlerFunc(ad.handleReport))
+}
+
+// handleReport runs anomaly detection for a rollout on demand
+func (ad *AnomalyDetector) handleReport(w http.ResponseWriter, r *http.Request) {
+	plan, err := GetRollout(r.Context(), ad.dynamoClient, ad.rolloutTableName, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	devices, err := ScanDevices(r.Context(), ad.dynamoClient, ad.deviceTableName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	report, err := ad.Detect(r.Context(), *plan, devices)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// Helper functions
+
+// splitBuckets orders bucket means by offset and splits them at the update time
+func splitBuckets(buckets map[int]float64) ([]float64, []float64) {
+	offsets := make([]int, 0, len(buckets))
+	for offset := range buckets {
+		offsets = append(offsets, offset)
+	}
+	sort.Ints(offsets)
+
+	baseline := make([]float64, 0)
+	current := make([]float64, 0)
+	for _, offset := range offsets {
+		if offset < 0 {
+			baseline = append(baseline, buckets[offset])
+		} else {
+			current = append(current, buckets[offset])
+		}
+	}
+
+	return baseline, current
+}
+
+// anomalyDetails summarizes a report's anomalous metrics for notifications
+func anomalyDetails(report *AnomalyReport) map[string]string {
+	details := map[string]string{
+		"reason":  report.Reason,
+		"devices": fmt.Sprintf("%d", report.Devices),
+	}
+	for _, metric := range report.Metrics {
+		if metric.Anomalous {
+			details[metric.Metric] = fmt.Sprintf("%.2f against baseline %.2f (z=%.1f)", metric.CurrentEWMA, metric.BaselineMean, metric.ZScore)
+		}
+	}
+	return details
+}
@@ -0,0 +1,489 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Approval request statuses
+const (
+	ApprovalPending  = "pending"
+	ApprovalApproved = "approved"
+	ApprovalRejected = "rejected"
+	ApprovalExpired  = "expired"
+)
+
+// ApprovalDecision is a single approver's vote on a request
+type ApprovalDecision struct {
+	Approver  string    `dynamodbav:"Approver" json:"approver"`
+	Approved  bool      `dynamodbav:"Approved" json:"approved"`
+	Comment   string    `dynamodbav:"Comment" json:"comment"`
+	DecidedAt time.Time `dynamodbav:"DecidedAt" json:"decidedAt"`
+}
+
+// ApprovalRequest asks members of a role to approve a rollout phase; it is
+// approved once Quorum members approve and rejected by any single rejection
+type ApprovalRequest struct {
+	ID          string             `dynamodbav:"ID" json:"id"`
+	RolloutID   string             `dynamodbav:"RolloutID" json:"rolloutId"`
+	PhaseIndex  int                `dynamodbav:"PhaseIndex" json:"phaseIndex"`
+	PhaseID     string             `dynamodbav:"PhaseID" json:"phaseId"`
+	Role        string             `dynamodbav:"Role" json:"role"`
+	Quorum      int                `dynamodbav:"Quorum" json:"quorum"`
+	Eligible    int                `dynamodbav:"Eligible" json:"eligible"`
+	Status      string             `dynamodbav:"Status" json:"status"`
+	RequestedBy string             `dynamodbav:"RequestedBy" json:"requestedBy"`
+	CreatedAt   time.Time          `dynamodbav:"CreatedAt" json:"createdAt"`
+	ExpiresAt   time.Time          `dynamodbav:"ExpiresAt" json:"expiresAt"`
+	Decisions   []ApprovalDecision `dynamodbav:"Decisions" json:"decisions"`
+	TenantID    string             `dynamodbav:"TenantID,omitempty" json:"tenantId,omitempty"`
+}
+
+// ApprovalAnnouncer announces new approval requests to their approvers
+type ApprovalAnnouncer interface {
+	Announce(ctx context.Context, request *ApprovalRequest) error
+}
+
+// ApprovalService manages quorum-based phase approvals
+type ApprovalService struct {
+	dynamoClient      *dynamodb.Client
+	approvalTableName string
+	rolloutTableName  string
+	roles             map[string][]string
+	defaultTTL        time.Duration
+	auditLog          *AuditLog
+	announcers        []ApprovalAnnouncer
+	decisionMutex     sync.Mutex
+}
+
+// ApprovalServiceConfig contains configuration for the ApprovalService
+type ApprovalServiceConfig struct {
+	DynamoClient      *dynamodb.Client
+	ApprovalTableName string
+	RolloutTableName  string
+	Roles             map[string][]string // role name -> member identities
+	DefaultTTL        time.Duration
+	AuditLog          *AuditLog
+}
+
+// NewApprovalService creates a new ApprovalService
+func NewApprovalService(config ApprovalServiceConfig) *ApprovalService {
+	return &ApprovalService{
+		dynamoClient:      config.DynamoClient,
+		approvalTableName: config.ApprovalTableName,
+		rolloutTableName:  config.RolloutTableName,
+		roles:             config.Roles,
+		defaultTTL:        config.DefaultTTL,
+		auditLog:          config.AuditLog,
+	}
+}
+
+// RequestApproval opens an approval request for a phase of a rollout
+func (as *ApprovalService) RequestApproval(ctx context.Context, rolloutID string, phaseIndex int, role string, quorum int, ttl time.Duration, requestedBy string) (*ApprovalRequest, error) {
+	members, ok := as.roles[role]
+	if !ok {
+		return nil, fmt.Errorf("unknown approver role %q", role)
+	}
+
+	if quorum < 1 || quorum > len(members) {
+		return nil, fmt.Errorf("quorum %d is not satisfiable by %d member(s) of %s", quorum, len(members), role)
+	}
+
+	plan, err := GetRollout(ctx, as.dynamoClient, as.rolloutTableName, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if phaseIndex < 0 || phaseIndex >= len(plan.Phases) {
+		return nil, fmt.Errorf("rollout %s has no phase %d", rolloutID, phaseIndex)
+	}
+
+	if ttl <= 0 {
+		ttl = as.defaultTTL
+	}
+
+	now := time.Now().UTC()
+	request := &ApprovalRequest{
+		ID:          uuid.New().String(),
+		RolloutID:   rolloutID,
+		PhaseIndex:  phaseIndex,
+		PhaseID:     plan.Phases[phaseIndex].ID,
+		Role:        role,
+		Quorum:      quorum,
+		Eligible:    len(members),
+		Status:      ApprovalPending,
+		RequestedBy: requestedBy,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(ttl),
+		Decisions:   make([]ApprovalDecision, 0),
+		TenantID:    plan.TenantID,
+	}
+
+	if err := as.save(ctx, request); err != nil {
+		return nil, err
+	}
+
+	as.audit(ctx, requestedBy, "approval.requested", request, map[string]string{
+		"role":      role,
+		"quorum":    fmt.Sprintf("%d", quorum),
+		"expiresAt": request.ExpiresAt.Format(time.RFC3339),
+	})
+
+	for _, announcer := range as.announcers {
+		if err := announcer.Announce(ctx, request); err != nil {
+			log.Printf("Failed to announce approval request %s: %v", request.ID, err)
+		}
+	}
+
+	return request, nil
+}
+
+// AddAnnouncer announces every approval request opened from now on through
+// the announcer; announcement failures are logged, not returned
+func (as *ApprovalService) AddAnnouncer(announcer ApprovalAnnouncer) {
+	as.announcers = append(as.announcers, announcer)
+}
+
+// Decide records an approve or reject decision and applies the outcome once the request resolves
+func (as *ApprovalService) Decide(ctx context.Context, requestID, approver string, approve bool, comment string) (*ApprovalRequest, error) {
+	as.decisionMutex.Lock()
+	defer as.decisionMutex.Unlock()
+
+	request, err := as.Get(ctx, requestID)
+	if err != nil {
+		return nil, err
+	}
+
+	if request.Status != ApprovalPending {
+		return nil, fmt.Errorf("approval request %s is %s", requestID, request.Status)
+	}
+
+	if !as.isMember(request.Role, approver) {
+		return nil, fmt.Errorf("%s is not a member of approver role %s", approver, request.Role)
+	}
+
+	for _, d := range request.Decisions {
+		if d.Approver == approver {
+			return nil, fmt.Errorf("%s has already decided on request %s", approver, requestID)
+		}
+	}
+
+	request.Decisions = append(request.Decisions, ApprovalDecision{
+		Approver:  approver,
+		Approved:  approve,
+		Comment:   comment,
+		DecidedAt: time.Now().UTC(),
+	})
+
+	action := "approval.rejected"
+	if approve {
+		action = "approval.approved"
+	}
+	as.audit(ctx, approver, action, request, map[string]string{"comment": comment})
+
+	approvals := make([]string, 0)
+	for _, d := range request.Decisions {
+		if d.Approved {
+			approvals = append(approvals, d.Approver)
+		}
+	}
+
+	switch {
+	case !approve:
+		request.Status = ApprovalRejected
+	case len(approvals) >= request.Quorum:
+		if err := as.approvePhase(ctx, request, approvals); err != nil {
+			return nil, err
+		}
+		request.Status = ApprovalApproved
+		as.audit(ctx, strings.Join(approvals, ","), "phase.approved", request, map[string]string{
+			"quorum": fmt.Sprintf("%d/%d", len(approvals), request.Eligible),
+		})
+	}
+
+	if err := as.save(ctx, request); err != nil {
+		return nil, err
+	}
+
+	return request, nil
+}
+
+// Get loads an approval request, expiring it if its deadline has passed
+func (as *ApprovalService) Get(ctx context.Context, requestID string) (*ApprovalRequest, error) {
+	result, err := as.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(as.approvalTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: requestID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get approval request: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, fmt.Errorf("approval request not found: %s", requestID)
+	}
+
+	var request ApprovalRequest
+	if err := attributevalue.UnmarshalMap(result.Item, &request); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal approval request: %w", err)
+	}
+
+	if !tenant.Allowed(tenant.FromContext(ctx), request.TenantID) {
+		return nil, fmt.Errorf("approval request not found: %s", requestID)
+	}
+
+	if request.Status == ApprovalPending && time.Now().After(request.ExpiresAt) {
+		request.Status = ApprovalExpired
+		if err := as.save(ctx, &request); err != nil {
+			return nil, err
+		}
+		as.audit(ctx, "system", "approval.expired", &request, nil)
+	}
+
+	return &request, nil
+}
+
+// List returns the approval requests for a rollout
+func (as *ApprovalService) List(ctx context.Context, rolloutID string) ([]ApprovalRequest, error) {
+	requests := make([]ApprovalRequest, 0)
+
+	paginator := dynamodb.NewScanPaginator(as.dynamoClient, &dynamodb.ScanInput{
+		TableName:        aws.String(as.approvalTableName),
+		FilterExpression: aws.String("RolloutID = :rolloutID"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rolloutID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list approval requests: %w", err)
+		}
+
+		var batch []ApprovalRequest
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal approval requests: %w", err)
+		}
+		for _, request := range batch {
+			if tenant.Allowed(tenant.FromContext(ctx), request.TenantID) {
+				requests = append(requests, request)
+			}
+		}
+	}
+
+	return requests, nil
+}
+
+// approvePhase marks the phase approved on the rollout record
+func (as *ApprovalService) approvePhase(ctx context.Context, request *ApprovalRequest, approvers []string) error {
+	phase := fmt.Sprintf("Phases[%d]", request.PhaseIndex)
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(as.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: request.RolloutID},
+		},
+		UpdateExpression: aws.String(fmt.Sprintf("SET %s.Approved = :true, %s.ApprovedBy = :by, %s.ApprovedAt = :at, UpdatedAt = :at", phase, phase, phase)),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":true": &types.AttributeValueMemberBOOL{Value: true},
+			":by":   &types.AttributeValueMemberS{Value: strings.Join(approvers, ",")},
+			":at":   &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	bumpRevision(input)
+
+	_, err := as.dynamoClient.UpdateItem(ctx, input)
+	if err != nil {
+		return fmt.Errorf("failed to approve phase %s of rollout %s: %w", request.PhaseID, request.RolloutID, err)
+	}
+
+	return nil
+}
+
+// save writes an approval request
+func (as *ApprovalService) save(ctx context.Context, request *ApprovalRequest) error {
+	item, err := attributevalue.MarshalMap(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal approval request: %w", err)
+	}
+
+	_, err = as.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(as.approvalTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to save approval request: %w", err)
+	}
+
+	return nil
+}
+
+// isMember reports whether identity belongs to role
+func (as *ApprovalService) isMember(role, identity string) bool {
+	for _, member := range as.roles[role] {
+		if member == identity {
+			return true
+		}
+	}
+	return false
+}
+
+// audit records an approval event, logging rather than failing on audit errors
+func (as *ApprovalService) audit(ctx context.Context, actor, action string, request *ApprovalRequest, details map[string]string) {
+	if as.auditLog == nil {
+		return
+	}
+
+	if details == nil {
+		details = make(map[string]string)
+	}
+	details["approvalId"] = request.ID
+	details["phaseId"] = request.PhaseID
+
+	if err := as.auditLog.Record(ctx, actor, action, request.RolloutID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// RegisterRoutes registers the approval API on the server
+func (as *ApprovalService) RegisterRoutes(s *Server) {
+	s.Handle("POST /api/approvals", http.HandlerFunc(as.handleCreate))
+	s.Handle("GET /api/approvals", http.HandlerFunc(as.handleList))
+	s.Handle("GET /api/approvals/{id}", http.HandlerFunc(as.handleGet))
+	s.Handle("POST /api/approvals/{id}/approve", http.HandlerFunc(as.handleDecision(true)))
+	s.Handle("POST /api/approvals/{id}/reject", http.HandlerFunc(as.handleDecision(false)))
+}
+
+// handleCreate opens an approval request
+func (as *ApprovalService) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		RolloutID   string `json:"rolloutId"`
+		PhaseIndex  int    `json:"phaseIndex"`
+		Role        string `json:"role"`
+		Quorum      int    `json:"quorum"`
+		TTL         string `json:"ttl"`
+		RequestedBy string `json:"requestedBy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	var ttl time.Duration
+	if body.TTL != "" {
+		parsed, err := time.ParseDuration(body.TTL)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid ttl")
+			return
+		}
+		ttl = parsed
+	}
+
+	request, err := as.RequestApproval(r.Context(), body.RolloutID, body.PhaseIndex, body.Role, body.Quorum, ttl, actorFor(r.Context(), body.RequestedBy))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, request)
+}
+
+// handleList lists approval requests for a rollout
+func (as *ApprovalService) handleList(w http.ResponseWriter, r *http.Request) {
+	rolloutID := r.URL.Query().Get("rolloutId")
+	if rolloutID == "" {
+		writeError(w, http.StatusBadRequest, "rolloutId is required")
+		return
+	}
+
+	requests, err := as.List(r.Context(), rolloutID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, requests)
+}
+
+// handleGet returns a single approval request
+func (as *ApprovalService) handleGet(w http.ResponseWriter, r *http.Request) {
+	request, err := as.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, request)
+}
+
+// handleDecision returns a handler recording an approve or reject decision
+func (as *ApprovalService) handleDecision(approve bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Approver string `json:"approver"`
+			Comment  string `json:"comment"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		// Authenticated callers decide as themselves, whatever the body claims
+		request, err := as.Decide(r.Context(), r.PathValue("id"), actorFor(r.Context(), body.Approver), approve, body.Comment)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, request)
+	}
+}
+
+// GetRollout loads a single rollout record; rollouts owned by another tenant
+// than the context's are reported as not found
+func GetRollout(ctx context.Context, client *dynamodb.Client, tableName, rolloutID string) (*rollout.RolloutPlan, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		// Writes require the revision read here, so it must be current
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get rollout: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, errors.New("rollout not found: " + rolloutID)
+	}
+
+	var plan rollout.RolloutPlan
+	if err := attributevalue.UnmarshalMap(result.Item, &plan); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rollout: %w", err)
+	}
+
+	if !tenant.Allowed(tenant.FromContext(ctx), plan.TenantID) || plan.Expired(time.Now()) {
+		return nil, errors.New("rollout not found: " + rolloutID)
+	}
+
+	return &plan, nil
+}
@@ -0,0 +1,315 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/attestation"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// AttestationStatus is a device's attestation as the fleet sees it
+type AttestationStatus struct {
+	DeviceID         string            `json:"deviceId"`
+	Fenced           bool              `json:"fenced"`
+	FencedReason     string            `json:"fencedReason,omitempty"`
+	AttestedAt       string            `json:"attestedAt,omitempty"`
+	AttestedSequence int64             `json:"attestedSequence,omitempty"`
+	KeyPinned        bool              `json:"keyPinned"`
+	PCRs             map[string]string `json:"pcrs,omitempty"` // PCR index -> hex value from the last quote
+	Error            string            `json:"error,omitempty"`
+}
+
+// AttestationVerifier periodically verifies the TPM quotes devices attach to
+// their status reports. A device's first valid quote pins its attestation
+// key; a quote signed by another key, bound to another report, or measuring
+// a boot chain other than the golden PCRs fences the device out of rollouts
+// until an operator unfences it.
+type AttestationVerifier struct {
+	dynamoClient    *dynamodb.Client
+	deviceTableName string
+	goldenPCRs      map[int]string
+	auditLog        *AuditLog
+	interval        time.Duration
+	timer           *time.Timer
+}
+
+// AttestationVerifierConfig contains configuration for the AttestationVerifier
+type AttestationVerifierConfig struct {
+	DynamoClient    *dynamodb.Client
+	DeviceTableName string
+	GoldenPCRs      map[int]string // PCR index -> hex SHA-256 value of the approved boot chain
+	AuditLog        *AuditLog      // records fencing; optional
+	Interval        time.Duration  // defaults to 5 minutes
+}
+
+// NewAttestationVerifier creates a new AttestationVerifier and starts verifying
+func NewAttestationVerifier(config AttestationVerifierConfig) (*AttestationVerifier, error) {
+	for index, value := range config.GoldenPCRs {
+		if _, err := hex.DecodeString(value); err != nil {
+			return nil, fmt.Errorf("golden PCR %d is not hex: %w", index, err)
+		}
+	}
+
+	av := &AttestationVerifier{
+		dynamoClient:    config.DynamoClient,
+		deviceTableName: config.DeviceTableName,
+		goldenPCRs:      config.GoldenPCRs,
+		auditLog:        config.AuditLog,
+		interval:        config.Interval,
+	}
+	if av.interval == 0 {
+		av.interval = 5 * time.Minute
+	}
+
+	// Start the verification timer
+	av.timer = time.AfterFunc(av.interval, av.verifyLoop)
+
+	return av, nil
+}
+
+// verifyLoop verifies the fleet and reschedules itself
+func (av *AttestationVerifier) verifyLoop() {
+	defer func() {
+		// Reschedule the verification
+		av.timer.Reset(av.interval)
+	}()
+
+	if err := av.VerifyAll(context.Background()); err != nil {
+		log.Printf("Failed to verify device attestations: %v", err)
+	}
+}
+
+// VerifyAll verifies every device's latest quote that hasn't been verified yet
+func (av *AttestationVerifier) VerifyAll(ctx context.Context) error {
+	devices, err := ScanDevices(ctx, av.dynamoClient, av.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if device.Attestation == "" || device.Fenced {
+			continue
+		}
+		if err := av.Verify(ctx, device); err != nil {
+			log.Printf("Failed to verify attestation of %s: %v", device.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// Verify checks a device's latest quote, pinning its key on the first valid
+// quote and fencing the device when the quote fails
+func (av *AttestationVerifier) Verify(ctx context.Context, device DeviceRecord) error {
+	var quote attestation.Quote
+	if err := json.Unmarshal([]byte(device.Attestation), &quote); err != nil {
+		return av.fence(ctx, device, fmt.Sprintf("unreadable quote: %v", err))
+	}
+
+	// A quote is verified once; an older one is a replay
+	switch {
+	case quote.Claim.Sequence == device.AttestedSequence:
+		return nil
+	case quote.Claim.Sequence < device.AttestedSequence:
+		return av.fence(ctx, device, fmt.Sprintf("replayed quote for status report %d", quote.Claim.Sequence))
+	case quote.Claim.DeviceID != device.DeviceID:
+		return av.fence(ctx, device, "quote claims device "+quote.Claim.DeviceID)
+	}
+
+	// Until a key is pinned the quote's own key verifies it
+	if err := attestation.Verify(&quote, device.AttestationKey, av.goldenPCRs); err != nil {
+		return av.fence(ctx, device, err.Error())
+	}
+
+	expression := "SET AttestedSequence = :sequence, AttestedAt = :time"
+	values := map[string]types.AttributeValue{
+		":sequence": &types.AttributeValueMemberN{Value: strconv.FormatInt(quote.Claim.Sequence, 10)},
+		":time":     &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+	if len(device.AttestationKey) == 0 {
+		expression += ", AttestationKey = :key"
+		values[":key"] = &types.AttributeValueMemberB{Value: quote.AKPublic}
+		log.Printf("Pinned attestation key of %s", device.DeviceID)
+	}
+
+	return av.update(ctx, device.DeviceID, expression, values)
+}
+
+// Unfence lets a fenced device take rollouts again, from its next quote on;
+// resetKey also unpins its attestation key, e.g. after its TPM was replaced
+func (av *AttestationVerifier) Unfence(ctx context.Context, deviceID string, resetKey bool, actor string) error {
+	device, err := GetDevice(ctx, av.dynamoClient, av.deviceTableName, deviceID)
+	if err != nil {
+		return err
+	}
+	if device == nil {
+		return fmt.Errorf("%w: %s", rollout.ErrDeviceNotFound, deviceID)
+	}
+
+	// The quote that fenced the device counts as verified, so it doesn't
+	// fence the device again
+	var quote attestation.Quote
+	if device.Attestation != "" {
+		json.Unmarshal([]byte(device.Attestation), &quote)
+	}
+
+	expression := "SET AttestedSequence = :sequence REMOVE Fenced, FencedReason"
+	if resetKey {
+		expression += ", AttestationKey"
+	}
+	values := map[string]types.AttributeValue{
+		":sequence": &types.AttributeValueMemberN{Value: strconv.FormatInt(quote.Claim.Sequence, 10)},
+	}
+	if err := av.update(ctx, deviceID, expression, values); err != nil {
+		return err
+	}
+
+	av.audit(ctx, actor, "device-unfenced", map[string]string{
+		"deviceId": deviceID,
+		"resetKey": strconv.FormatBool(resetKey),
+	})
+	log.Printf("Device %s unfenced by %s", deviceID, actor)
+
+	return nil
+}
+
+// Status returns a device's attestation status, or nil when it isn't found
+func (av *AttestationVerifier) Status(ctx context.Context, deviceID string) (*AttestationStatus, error) {
+	device, err := GetDevice(ctx, av.dynamoClient, av.deviceTableName, deviceID)
+	if err != nil || device == nil {
+		return nil, err
+	}
+
+	status := &AttestationStatus{
+		DeviceID:         device.DeviceID,
+		Fenced:           device.Fenced,
+		FencedReason:     device.FencedReason,
+		AttestedAt:       device.AttestedAt,
+		AttestedSequence: device.AttestedSequence,
+		KeyPinned:        len(device.AttestationKey) > 0,
+		Error:            device.AttestationError,
+	}
+
+	var quote attestation.Quote
+	if device.Attestation != "" && json.Unmarshal([]byte(device.Attestation), &quote) == nil {
+		status.PCRs = make(map[string]string, len(quote.PCRs))
+		for index, value := range quote.PCRs {
+			status.PCRs[strconv.Itoa(index)] = hex.EncodeToString(value)
+		}
+	}
+
+	return status, nil
+}
+
+// fence marks a device as fenced out of rollouts
+func (av *AttestationVerifier) fence(ctx context.Context, device DeviceRecord, reason string) error {
+	err := av.update(ctx, device.DeviceID, "SET Fenced = :fenced, FencedReason = :reason", map[string]types.AttributeValue{
+		":fenced": &types.AttributeValueMemberBOOL{Value: true},
+		":reason": &types.AttributeValueMemberS{Value: reason},
+	})
+	if err != nil {
+		return err
+	}
+
+	av.audit(ctx, "attestation-verifier", "device-fenced", map[string]string{
+		"deviceId": device.DeviceID,
+		"reason":   reason,
+	})
+	log.Printf("Device %s fenced: %s", device.DeviceID, reason)
+
+	return nil
+}
+
+// update applies an update expression to an existing device record
+func (av *AttestationVerifier) update(ctx context.Context, deviceID, expression string, values map[string]types.AttributeValue) error {
+	_, err := av.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(av.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String("attribute_exists(DeviceID)"),
+		ExpressionAttributeValues: values,
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionErr) {
+		return fmt.Errorf("%w: %s", rollout.ErrDeviceNotFound, deviceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+
+	return nil
+}
+
+// audit records an attestation event, logging rather than failing on audit errors
+func (av *AttestationVerifier) audit(ctx context.Context, actor, action string, details map[string]string) {
+	if av.auditLog == nil {
+		return
+	}
+
+	if err := av.auditLog.Record(ctx, actor, action, "", details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// Close stops the verifier
+func (av *AttestationVerifier) Close() {
+	if av.timer != nil {
+		av.timer.Stop()
+	}
+}
+
+// RegisterRoutes registers the attestation API on the server
+func (av *AttestationVerifier) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/devices/{id}/attestation", http.HandlerFunc(av.handleStatus))
+	s.Handle("POST /api/devices/{id}/unfence", http.HandlerFunc(av.handleUnfence))
+}
+
+// handleStatus returns a device's attestation status
+func (av *AttestationVerifier) handleStatus(w http.ResponseWriter, r *http.Request) {
+	deviceID := tenant.Key(tenant.FromContext(r.Context()), r.PathValue("id"))
+
+	status, err := av.Status(r.Context(), deviceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if status == nil {
+		writeError(w, http.StatusNotFound, "device not found: "+r.PathValue("id"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+// handleUnfence unfences a device; ?resetKey=true also unpins its attestation key
+func (av *AttestationVerifier) handleUnfence(w http.ResponseWriter, r *http.Request) {
+	deviceID := tenant.Key(tenant.FromContext(r.Context()), r.PathValue("id"))
+	resetKey := r.URL.Query().Get("resetKey") == "true"
+
+	err := av.Unfence(r.Context(), deviceID, resetKey, actorFor(r.Context(), ""))
+	if errors.Is(err, rollout.ErrDeviceNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "unfenced"})
+}
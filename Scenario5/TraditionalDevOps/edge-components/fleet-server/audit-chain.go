@@ -0,0 +1,413 @@
+package fleetserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+const (
+	// chainHeadID keys the item that tracks the last entry of the chain
+	chainHeadID = "chain-head"
+
+	// maxAppendAttempts bounds retries when other servers keep appending first
+	maxAppendAttempts = 5
+
+	// maxChainProblems caps the problems a ChainReport lists
+	maxChainProblems = 100
+)
+
+// ChainHead is the last entry of the audit chain
+type ChainHead struct {
+	Sequence int64  `dynamodbav:"Sequence" json:"sequence"`
+	Hash     string `dynamodbav:"Hash" json:"hash"`
+}
+
+// AuditAnchor is a chain head written to S3 under Object Lock, so entries up
+// to it can't be rewritten or truncated without the anchor showing it
+type AuditAnchor struct {
+	Sequence   int64     `json:"sequence"`
+	Hash       string    `json:"hash"`
+	AnchoredAt time.Time `json:"anchoredAt"`
+}
+
+// ChainReport is the result of verifying the audit chain
+type ChainReport struct {
+	Valid        bool         `json:"valid"`
+	Records      int          `json:"records"`   // chained entries checked
+	Unchained    int          `json:"unchained"` // entries written before chaining, which it can't cover
+	HeadSequence int64        `json:"headSequence"`
+	HeadHash     string       `json:"headHash"`
+	Anchors      int          `json:"anchors"`
+	LastAnchor   *AuditAnchor `json:"lastAnchor,omitempty"`
+	Problems     []string     `json:"problems,omitempty"`
+	VerifiedAt   time.Time    `json:"verifiedAt"`
+}
+
+// AuditAnchorConfig contains configuration for anchoring the audit chain
+type AuditAnchorConfig struct {
+	S3Client  *s3.Client
+	Bucket    string        // must have Object Lock enabled
+	Prefix    string        // defaults to audit-anchors/
+	Interval  time.Duration // defaults to an hour
+	Retention time.Duration // how long each anchor is locked in compliance mode; defaults to 7 years
+}
+
+// anchorStore writes and lists anchors
+type anchorStore struct {
+	s3Client  *s3.Client
+	bucket    string
+	prefix    string
+	interval  time.Duration
+	retention time.Duration
+	last      int64 // sequence of the last anchor written
+}
+
+// ComputeHash returns the hex SHA-256 of the entry without its Hash, which
+// covers PrevHash and so every entry before it
+func (r AuditRecord) ComputeHash() string {
+	r.Hash = ""
+	data, _ := json.Marshal(r)
+	digest := sha256.Sum256(data)
+	return hex.EncodeToString(digest[:])
+}
+
+// EnableAnchors periodically writes the chain head to an Object Lock bucket,
+// and has Verify check the chain against the anchors
+func (al *AuditLog) EnableAnchors(config AuditAnchorConfig) error {
+	if config.S3Client == nil || config.Bucket == "" {
+		return errors.New("anchors need an S3 client and bucket")
+	}
+	if config.Prefix == "" {
+		config.Prefix = "audit-anchors/"
+	}
+	if config.Interval == 0 {
+		config.Interval = time.Hour
+	}
+	if config.Retention == 0 {
+		config.Retention = 7 * 365 * 24 * time.Hour
+	}
+
+	al.anchors = &anchorStore{
+		s3Client:  config.S3Client,
+		bucket:    config.Bucket,
+		prefix:    strings.TrimSuffix(config.Prefix, "/") + "/",
+		interval:  config.Interval,
+		retention: config.Retention,
+	}
+
+	// Start the anchor timer
+	al.anchorTimer = time.AfterFunc(al.anchors.interval, al.anchorLoop)
+
+	return nil
+}
+
+// anchorLoop anchors the chain and reschedules itself
+func (al *AuditLog) anchorLoop() {
+	defer func() {
+		// Reschedule the anchoring
+		al.anchorTimer.Reset(al.anchors.interval)
+	}()
+
+	if _, err := al.Anchor(context.Background()); err != nil {
+		log.Printf("Failed to anchor audit chain: %v", err)
+	}
+}
+
+// Anchor writes the current chain head to S3 unless it is already anchored;
+// it returns nil when there was nothing new to anchor
+func (al *AuditLog) Anchor(ctx context.Context) (*AuditAnchor, error) {
+	if al.anchors == nil {
+		return nil, errors.New("audit anchors are not enabled")
+	}
+
+	// Other servers append too, so read the head from the table
+	al.chainMutex.Lock()
+	err := al.loadHead(ctx)
+	head := al.head
+	al.chainMutex.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if head.Sequence == 0 || head.Sequence == al.anchors.last {
+		return nil, nil
+	}
+
+	anchor := &AuditAnchor{Sequence: head.Sequence, Hash: head.Hash, AnchoredAt: time.Now().UTC()}
+	data, err := json.Marshal(anchor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal audit anchor: %w", err)
+	}
+
+	// Object Lock requires a checksum on the upload
+	_, err = al.anchors.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:                    aws.String(al.anchors.bucket),
+		Key:                       aws.String(al.anchors.key(head)),
+		Body:                      bytes.NewReader(data),
+		ContentType:               aws.String("application/json"),
+		ChecksumAlgorithm:         s3types.ChecksumAlgorithmSha256,
+		ObjectLockMode:            s3types.ObjectLockModeCompliance,
+		ObjectLockRetainUntilDate: aws.Time(anchor.AnchoredAt.Add(al.anchors.retention)),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to write audit anchor: %w", err)
+	}
+	al.anchors.last = head.Sequence
+
+	return anchor, nil
+}
+
+// Verify walks the whole chain, checking every entry's hash and link, that
+// no entry is missing, and that each anchor matches its entry
+func (al *AuditLog) Verify(ctx context.Context) (*ChainReport, error) {
+	report := &ChainReport{VerifiedAt: time.Now().UTC()}
+
+	records, stored, err := al.scanChain(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	hashes := make(map[int64]string, len(records))
+	expected, prevHash := int64(1), ""
+	for _, record := range records {
+		if record.Sequence == 0 {
+			report.Unchained++
+			continue
+		}
+		report.Records++
+
+		switch {
+		case record.Sequence < expected:
+			report.problem("entry %d appears more than once", record.Sequence)
+			continue
+		case record.Sequence > expected:
+			report.problem("entries %d to %d are missing", expected, record.Sequence-1)
+		case record.PrevHash != prevHash:
+			report.problem("entry %d does not link to entry %d", record.Sequence, record.Sequence-1)
+		}
+		if record.ID != chainRecordID(record.Sequence) {
+			report.problem("entry %d has ID %s", record.Sequence, record.ID)
+		}
+		if record.ComputeHash() != record.Hash {
+			report.problem("entry %d was modified", record.Sequence)
+		}
+
+		hashes[record.Sequence] = record.Hash
+		expected, prevHash = record.Sequence+1, record.Hash
+	}
+	report.HeadSequence, report.HeadHash = expected-1, prevHash
+
+	// The head item only moves forward, so a head past the last entry means
+	// entries were deleted from the end
+	if stored.Sequence > report.HeadSequence {
+		report.problem("entries %d to %d were deleted", report.HeadSequence+1, stored.Sequence)
+	}
+
+	if al.anchors != nil {
+		anchors, err := al.anchors.list(ctx)
+		if err != nil {
+			return nil, err
+		}
+		report.Anchors = len(anchors)
+
+		for _, anchor := range anchors {
+			hash, ok := hashes[anchor.Sequence]
+			switch {
+			case anchor.Sequence > report.HeadSequence:
+				report.problem("entry %d was anchored but the chain ends at %d", anchor.Sequence, report.HeadSequence)
+			case !ok:
+				report.problem("anchored entry %d is missing", anchor.Sequence)
+			case hash != anchor.Hash:
+				report.problem("entry %d does not match its anchor", anchor.Sequence)
+			}
+		}
+		if len(anchors) > 0 {
+			report.LastAnchor = &anchors[len(anchors)-1]
+		}
+	}
+
+	report.Valid = len(report.Problems) == 0
+	return report, nil
+}
+
+// RegisterRoutes registers the audit verification API on the server
+func (al *AuditLog) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/audit/verify", http.HandlerFunc(al.handleVerify))
+}
+
+// handleVerify verifies the chain; a broken chain is reported, not an error
+func (al *AuditLog) handleVerify(w http.ResponseWriter, r *http.Request) {
+	report, err := al.Verify(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, report)
+}
+
+// loadHead reads the stored head, then follows entries past it that were
+// appended before the head item caught up
+func (al *AuditLog) loadHead(ctx context.Context) error {
+	result, err := al.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName:      aws.String(al.auditTableName),
+		Key:            map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: chainHeadID}},
+		ConsistentRead: aws.Bool(true),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read audit chain head: %w", err)
+	}
+
+	var head ChainHead
+	if result.Item != nil {
+		if err := attributevalue.UnmarshalMap(result.Item, &head); err != nil {
+			return fmt.Errorf("failed to unmarshal audit chain head: %w", err)
+		}
+	}
+
+	for {
+		result, err := al.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName:      aws.String(al.auditTableName),
+			Key:            map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: chainRecordID(head.Sequence + 1)}},
+			ConsistentRead: aws.Bool(true),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read audit record: %w", err)
+		}
+		if result.Item == nil {
+			break
+		}
+
+		var next AuditRecord
+		if err := attributevalue.UnmarshalMap(result.Item, &next); err != nil {
+			return fmt.Errorf("failed to unmarshal audit record: %w", err)
+		}
+		head = ChainHead{Sequence: next.Sequence, Hash: next.Hash}
+	}
+
+	al.head, al.headLoaded = head, true
+	return nil
+}
+
+// saveHead moves the stored head forward; failures only cost the next
+// loadHead a few extra reads
+func (al *AuditLog) saveHead(ctx context.Context) {
+	_, err := al.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName:           aws.String(al.auditTableName),
+		Key:                 map[string]types.AttributeValue{"ID": &types.AttributeValueMemberS{Value: chainHeadID}},
+		UpdateExpression:    aws.String("SET #sequence = :sequence, #hash = :hash"),
+		ConditionExpression: aws.String("attribute_not_exists(#sequence) OR #sequence < :sequence"),
+		ExpressionAttributeNames: map[string]string{
+			"#sequence": "Sequence",
+			"#hash":     "Hash",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sequence": &types.AttributeValueMemberN{Value: strconv.FormatInt(al.head.Sequence, 10)},
+			":hash":     &types.AttributeValueMemberS{Value: al.head.Hash},
+		},
+	})
+	var conditionErr *types.ConditionalCheckFailedException
+	if err != nil && !errors.As(err, &conditionErr) {
+		log.Printf("Failed to save audit chain head: %v", err)
+	}
+}
+
+// scanChain reads every entry, sorted by sequence, and the stored head
+func (al *AuditLog) scanChain(ctx context.Context) ([]AuditRecord, ChainHead, error) {
+	var head ChainHead
+	records := make([]AuditRecord, 0)
+
+	paginator := dynamodb.NewScanPaginator(al.dynamoClient, &dynamodb.ScanInput{
+		TableName:      aws.String(al.auditTableName),
+		ConsistentRead: aws.Bool(true),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, head, fmt.Errorf("failed to scan audit table: %w", err)
+		}
+
+		for _, item := range page.Items {
+			if id, ok := item["ID"].(*types.AttributeValueMemberS); ok && id.Value == chainHeadID {
+				if err := attributevalue.UnmarshalMap(item, &head); err != nil {
+					return nil, head, fmt.Errorf("failed to unmarshal audit chain head: %w", err)
+				}
+				continue
+			}
+
+			var record AuditRecord
+			if err := attributevalue.UnmarshalMap(item, &record); err != nil {
+				return nil, head, fmt.Errorf("failed to unmarshal audit record: %w", err)
+			}
+			records = append(records, record)
+		}
+	}
+
+	sort.Slice(records, func(i, j int) bool { return records[i].Sequence < records[j].Sequence })
+	return records, head, nil
+}
+
+// key names an anchor object; the hash is in the key, so verifying needs only a listing
+func (as *anchorStore) key(head ChainHead) string {
+	return fmt.Sprintf("%s%020d-%s.json", as.prefix, head.Sequence, head.Hash)
+}
+
+// list returns the anchors, oldest first
+func (as *anchorStore) list(ctx context.Context) ([]AuditAnchor, error) {
+	anchors := make([]AuditAnchor, 0)
+
+	paginator := s3.NewListObjectsV2Paginator(as.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(as.bucket),
+		Prefix: aws.String(as.prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list audit anchors: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			name := strings.TrimSuffix(strings.TrimPrefix(aws.ToString(object.Key), as.prefix), ".json")
+			sequence, hash, found := strings.Cut(name, "-")
+			parsed, err := strconv.ParseInt(sequence, 10, 64)
+			if !found || err != nil {
+				continue
+			}
+			anchors = append(anchors, AuditAnchor{Sequence: parsed, Hash: hash, AnchoredAt: aws.ToTime(object.LastModified)})
+		}
+	}
+
+	sort.Slice(anchors, func(i, j int) bool { return anchors[i].Sequence < anchors[j].Sequence })
+	return anchors, nil
+}
+
+// Helper functions
+
+// chainRecordID keys an entry by its sequence, so only one entry can take it
+func chainRecordID(sequence int64) string {
+	return fmt.Sprintf("%020d", sequence)
+}
+
+// problem adds a verification failure, up to maxChainProblems
+func (cr *ChainReport) problem(format string, args ...interface{}) {
+	if len(cr.Problems) < maxChainProblems {
+		cr.Problems = append(cr.Problems, fmt.Sprintf(format, args...))
+	}
+}
@@ -0,0 +1,127 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// AuditRecord is a single entry in the audit trail. Entries form a hash
+// chain: each carries the hash of the one before it, so an entry edited or
+// deleted in the table breaks the chain.
+type AuditRecord struct {
+	ID        string            `dynamodbav:"ID" json:"id"`
+	Timestamp time.Time         `dynamodbav:"Timestamp" json:"timestamp"`
+	Actor     string            `dynamodbav:"Actor" json:"actor"`
+	Action    string            `dynamodbav:"Action" json:"action"`
+	RolloutID string            `dynamodbav:"RolloutID" json:"rolloutId"`
+	Details   map[string]string `dynamodbav:"Details" json:"details"`
+	Sequence  int64             `dynamodbav:"Sequence,omitempty" json:"sequence,omitempty"` // position in the chain, from 1; 0 for entries written before chaining
+	PrevHash  string            `dynamodbav:"PrevHash,omitempty" json:"prevHash,omitempty"`
+	Hash      string            `dynamodbav:"Hash,omitempty" json:"hash,omitempty"` // hex SHA-256 of the entry without Hash
+}
+
+// AuditLog records operator actions in the audit table
+type AuditLog struct {
+	dynamoClient   *dynamodb.Client
+	auditTableName string
+	exporter       *EventExporter
+	head           ChainHead // last entry appended, once loaded
+	headLoaded     bool
+	chainMutex     sync.Mutex
+	anchors        *anchorStore
+	anchorTimer    *time.Timer
+}
+
+// NewAuditLog creates a new AuditLog
+func NewAuditLog(dynamoClient *dynamodb.Client, auditTableName string) *AuditLog {
+	return &AuditLog{
+		dynamoClient:   dynamoClient,
+		auditTableName: auditTableName,
+	}
+}
+
+// SetExporter also sends every recorded entry to an EventExporter
+func (al *AuditLog) SetExporter(exporter *EventExporter) {
+	al.exporter = exporter
+}
+
+// Record appends an entry to the audit trail
+func (al *AuditLog) Record(ctx context.Context, actor, action, rolloutID string, details map[string]string) error {
+	record := AuditRecord{
+		Timestamp: time.Now().UTC(),
+		Actor:     actor,
+		Action:    action,
+		RolloutID: rolloutID,
+		Details:   details,
+	}
+
+	al.chainMutex.Lock()
+	defer al.chainMutex.Unlock()
+
+	// Entries are keyed by sequence and written only if the key is free, so
+	// when another server appended first the write fails and is retried on
+	// the new head
+	var err error
+	for attempt := 0; attempt < maxAppendAttempts; attempt++ {
+		if !al.headLoaded {
+			if err = al.loadHead(ctx); err != nil {
+				return err
+			}
+		}
+
+		record.Sequence = al.head.Sequence + 1
+		record.ID = chainRecordID(record.Sequence)
+		record.PrevHash = al.head.Hash
+		record.Hash = record.ComputeHash()
+
+		err = al.append(ctx, record)
+		var conditionErr *types.ConditionalCheckFailedException
+		if !errors.As(err, &conditionErr) {
+			break
+		}
+		al.headLoaded = false
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write audit record: %w", err)
+	}
+
+	al.head = ChainHead{Sequence: record.Sequence, Hash: record.Hash}
+	al.saveHead(ctx)
+
+	if al.exporter != nil {
+		al.exporter.RecordRollout(record)
+	}
+
+	return nil
+}
+
+// Close stops anchoring the chain
+func (al *AuditLog) Close() {
+	if al.anchorTimer != nil {
+		al.anchorTimer.Stop()
+	}
+}
+
+// append writes an entry, failing with a ConditionalCheckFailedException
+// when its sequence is taken
+func (al *AuditLog) append(ctx context.Context, record AuditRecord) error {
+	item, err := attributevalue.MarshalMap(record)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit record: %w", err)
+	}
+
+	_, err = al.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(al.auditTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	return err
+}
@@ -0,0 +1,310 @@
+package fleetserver
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Roles that can be granted to API keys and OIDC identities
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleApprover = "approver"
+	RoleAdmin    = "admin"
+)
+
+// Permission is an action guarded by the fleet API
+type Permission string
+
+// Permissions checked by the fleet API
+const (
+	PermView            Permission = "view"
+	PermCreateRollout   Permission = "rollout:create"
+	PermAbortRollout    Permission = "rollout:abort"
+	PermApprove         Permission = "approval:decide"
+	PermRequestApproval Permission = "approval:request"
+	PermRunExperiment   Permission = "experiment:run"
+	PermAdmin           Permission = "admin"
+)
+
+// rolePermissions grants permissions to each role; admin holds every permission
+var rolePermissions = map[string][]Permission{
+	RoleViewer:   {PermView},
+	RoleOperator: {PermView, PermCreateRollout, PermAbortRollout, PermRequestApproval, PermRunExperiment},
+	RoleApprover: {PermView, PermApprove},
+}
+
+// APIKeyHeader carries API keys on fleet API requests
+const APIKeyHeader = "X-API-Key"
+
+// Principal is an authenticated caller
+type Principal struct {
+	Subject string   `json:"subject"`
+	Roles   []string `json:"roles"`
+	Tenant  string   `json:"tenant,omitempty"` // restricts the caller to one tenant when set
+	Method  string   `json:"method"`           // api-key, oidc or slack
+}
+
+// Can reports whether any of the principal's roles grants a permission
+func (p *Principal) Can(permission Permission) bool {
+	for _, role := range p.Roles {
+		if role == RoleAdmin {
+			return true
+		}
+		for _, granted := range rolePermissions[role] {
+			if granted == permission {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// APIKey grants roles to holders of a key; only the key's SHA-256 is configured
+type APIKey struct {
+	Name      string // identifies the key in audit records
+	SHA256Hex string // hex SHA-256 of the secret key
+	Roles     []string
+	Tenant    string
+}
+
+// Authenticator authenticates fleet API requests with API keys or OIDC
+// bearer tokens and enforces per-route role permissions
+type Authenticator struct {
+	apiKeys     map[string]APIKey
+	verifier    *oidc.IDTokenVerifier
+	rolesClaim  string
+	tenantClaim string
+	groupRoles  map[string]string
+}
+
+// AuthenticatorConfig contains configuration for the Authenticator
+type AuthenticatorConfig struct {
+	APIKeys []APIKey
+
+	// OIDC is enabled when OIDCIssuer is set; ID tokens must be issued for OIDCClientID
+	OIDCIssuer   string
+	OIDCClientID string
+
+	// RolesClaim names the token claim listing roles or groups (default "roles");
+	// GroupRoles maps claim values to roles, otherwise values must be role names
+	RolesClaim string
+	GroupRoles map[string]string
+
+	// TenantClaim names the token claim holding the caller's tenant; when
+	// set, tokens that lack it are rejected
+	TenantClaim string
+}
+
+type principalKey struct{}
+
+// NewAuthenticator creates a new Authenticator, discovering the OIDC provider when configured
+func NewAuthenticator(ctx context.Context, config AuthenticatorConfig) (*Authenticator, error) {
+	a := &Authenticator{
+		apiKeys:     make(map[string]APIKey, len(config.APIKeys)),
+		rolesClaim:  config.RolesClaim,
+		tenantClaim: config.TenantClaim,
+		groupRoles:  config.GroupRoles,
+	}
+
+	if a.rolesClaim == "" {
+		a.rolesClaim = "roles"
+	}
+
+	for _, key := range config.APIKeys {
+		if err := validateRoles(key.Roles); err != nil {
+			return nil, fmt.Errorf("api key %s: %w", key.Name, err)
+		}
+		a.apiKeys[strings.ToLower(key.SHA256Hex)] = key
+	}
+
+	if config.OIDCIssuer != "" {
+		provider, err := oidc.NewProvider(ctx, config.OIDCIssuer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to discover OIDC provider: %w", err)
+		}
+		a.verifier = provider.Verifier(&oidc.Config{ClientID: config.OIDCClientID})
+	}
+
+	return a, nil
+}
+
+// Middleware authenticates every request, rejecting anonymous callers. A
+// principal bound to a tenant may not select a different tenant.
+func (a *Authenticator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := a.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="fleet"`)
+			writeError(w, http.StatusUnauthorized, err.Error())
+			return
+		}
+
+		if principal.Tenant != "" {
+			if requested := r.Header.Get(tenant.Header); requested != "" && requested != principal.Tenant {
+				writeError(w, http.StatusForbidden, "not permitted for tenant "+requested)
+				return
+			}
+			r.Header.Set(tenant.Header, principal.Tenant)
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// Require wraps a handler so only principals holding a permission may call it
+func (a *Authenticator) Require(permission Permission, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := PrincipalFromContext(r.Context())
+		if !ok {
+			writeError(w, http.StatusUnauthorized, "authentication required")
+			return
+		}
+
+		if !principal.Can(permission) {
+			log.Printf("Denied %s %s to %s: missing %s", r.Method, r.URL.Path, principal.Subject, permission)
+			writeError(w, http.StatusForbidden, fmt.Sprintf("%s is not permitted", permission))
+			return
+		}
+
+		handler.ServeHTTP(w, r)
+	})
+}
+
+// authenticate resolves the principal from an API key or bearer token
+func (a *Authenticator) authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		sum := sha256.Sum256([]byte(key))
+		apiKey, ok := a.apiKeys[hex.EncodeToString(sum[:])]
+		if !ok {
+			return nil, fmt.Errorf("invalid api key")
+		}
+		return &Principal{Subject: "key:" + apiKey.Name, Roles: apiKey.Roles, Tenant: apiKey.Tenant, Method: "api-key"}, nil
+	}
+
+	raw, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || raw == "" {
+		return nil, fmt.Errorf("missing credentials")
+	}
+
+	if a.verifier == nil {
+		return nil, fmt.Errorf("bearer tokens are not accepted")
+	}
+
+	token, err := a.verifier.Verify(r.Context(), raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	var claims map[string]interface{}
+	if err := token.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("invalid token claims")
+	}
+
+	// The email names the caller only once the provider verified it;
+	// otherwise anyone able to set an address could act under it
+	principal := &Principal{Subject: token.Subject, Method: "oidc"}
+	if email, ok := claims["email"].(string); ok && email != "" {
+		if verified, _ := claims["email_verified"].(bool); verified {
+			principal.Subject = email
+		}
+	}
+
+	for _, value := range claimStrings(claims[a.rolesClaim]) {
+		if len(a.groupRoles) > 0 {
+			value = a.groupRoles[value]
+		}
+		if _, known := rolePermissions[value]; known || value == RoleAdmin {
+			principal.Roles = append(principal.Roles, value)
+		}
+	}
+
+	// An empty tenant is unrestricted, so a token without the claim is
+	// rejected rather than given access to every tenant
+	if a.tenantClaim != "" {
+		tenantID, _ := claims[a.tenantClaim].(string)
+		if tenantID == "" {
+			return nil, fmt.Errorf("token has no %s claim", a.tenantClaim)
+		}
+		principal.Tenant = tenantID
+	}
+
+	return principal, nil
+}
+
+// PrincipalFromContext returns the authenticated caller, if any
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// Helper functions
+
+// routePermission maps a route pattern to the permission it requires; reads
+// need only view, and unknown writes are reserved for admins
+func routePermission(pattern string) Permission {
+	method, path, found := strings.Cut(pattern, " ")
+
+	switch {
+	case !found || method == http.MethodGet || strings.HasPrefix(path, "/api/grafana/"):
+		return PermView
+	case pattern == "POST /api/rollouts" || pattern == "POST /api/templates/{name}/rollouts":
+		return PermCreateRollout
+	case method == http.MethodPut && strings.HasPrefix(path, "/api/rollouts/"):
+		return PermCreateRollout
+	case strings.HasSuffix(path, "/shadow/desired") || strings.HasSuffix(path, "/orchestrate"):
+		return PermCreateRollout
+	case strings.HasSuffix(path, "/abort") || strings.HasSuffix(path, "/pause") || strings.HasSuffix(path, "/resume") || strings.HasSuffix(path, "/confirm"):
+		return PermAbortRollout
+	case pattern == "POST /api/approvals":
+		return PermRequestApproval
+	case strings.HasPrefix(path, "/api/approvals/"):
+		return PermApprove
+	case strings.HasPrefix(path, "/api/experiments"):
+		return PermRunExperiment
+	default:
+		return PermAdmin
+	}
+}
+
+// actorFor returns the authenticated subject, falling back to a caller-supplied name
+func actorFor(ctx context.Context, fallback string) string {
+	if principal, ok := PrincipalFromContext(ctx); ok {
+		return principal.Subject
+	}
+	return fallback
+}
+
+func validateRoles(roles []string) error {
+	for _, role := range roles {
+		if _, known := rolePermissions[role]; !known && role != RoleAdmin {
+			return fmt.Errorf("unknown role %q", role)
+		}
+	}
+	return nil
+}
+
+func claimStrings(value interface{}) []string {
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		values := make([]string, 0, len(v))
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
@@ -0,0 +1,568 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/gate"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+const (
+	// canaryMaxRegressionThreshold is the phase threshold key for the largest tolerated
+	// relative regression of a canary metric against the control cohort
+	canaryMaxRegressionThreshold = "canary_max_regression"
+
+	// canaryAlphaThreshold is the phase threshold key for the significance level
+	canaryAlphaThreshold = "canary_alpha"
+
+	// canaryMinDevicesThreshold is the phase threshold key for the minimum cohort size
+	canaryMinDevicesThreshold = "canary_min_devices"
+
+	// higherIsBetterSuffix marks a phase metric whose increase is an improvement, e.g. "throughput:higher-is-better"
+	higherIsBetterSuffix = ":higher-is-better"
+)
+
+// Canary verdicts
+const (
+	CanaryPass         = "pass"
+	CanaryFail         = "fail"
+	CanaryInconclusive = "inconclusive"
+)
+
+// MetricComparison compares one metric between the canary and control cohorts
+type MetricComparison struct {
+	Metric         string  `json:"metric"`
+	CanaryDevices  int     `json:"canaryDevices"`
+	ControlDevices int     `json:"controlDevices"`
+	CanaryMean     float64 `json:"canaryMean"`
+	ControlMean    float64 `json:"controlMean"`
+	Delta          float64 `json:"delta"`
+	RelativeDelta  float64 `json:"relativeDelta"`
+	PValue         float64 `json:"pValue"`
+	Regression     bool    `json:"regression"`
+}
+
+// CanaryAnalysis is the outcome of comparing a rollout phase's cohorts
+type CanaryAnalysis struct {
+	RolloutID   string             `json:"rolloutId"`
+	PhaseID     string             `json:"phaseId"`
+	GeneratedAt time.Time          `json:"generatedAt"`
+	Since       time.Time          `json:"since"`
+	Comparisons []MetricComparison `json:"comparisons"`
+	Verdict     string             `json:"verdict"`
+	Reason      string             `json:"reason"`
+
+	// Gate is the phase's gate expression and GateValues the canary
+	// cohort's value of each metric term it evaluated
+	Gate       string             `json:"gate,omitempty"`
+	GateValues map[string]float64 `json:"gateValues,omitempty"`
+	GateMet    *bool              `json:"gateMet,omitempty"` // nil until the gate could be decided
+}
+
+// CanaryAnalyzer compares telemetry of devices that took a rollout (canary)
+// against targeted devices that have not yet updated (control)
+type CanaryAnalyzer struct {
+	dynamoClient       *dynamodb.Client
+	deviceTableName    string
+	rolloutTableName   string
+	telemetryTableName string
+	window             time.Duration
+	maxRegression      float64
+	alpha              float64
+	minDevices         int
+}
+
+// CanaryAnalyzerConfig contains configuration for the CanaryAnalyzer; the
+// regression, alpha and cohort size defaults can be overridden per phase
+type CanaryAnalyzerConfig struct {
+	DynamoClient       *dynamodb.Client
+	DeviceTableName    string
+	RolloutTableName   string
+	TelemetryTableName string
+	Window             time.Duration
+	MaxRegression      float64
+	Alpha              float64
+	MinDevices         int
+}
+
+// NewCanaryAnalyzer creates a new CanaryAnalyzer
+func NewCanaryAnalyzer(config CanaryAnalyzerConfig) *CanaryAnalyzer {
+	ca := &CanaryAnalyzer{
+		dynamoClient:       config.DynamoClient,
+		deviceTableName:    config.DeviceTableName,
+		rolloutTableName:   config.RolloutTableName,
+		telemetryTableName: config.TelemetryTableName,
+		window:             config.Window,
+		maxRegression:      config.MaxRegression,
+		alpha:              config.Alpha,
+		minDevices:         config.MinDevices,
+	}
+
+	if ca.window == 0 {
+		ca.window = time.Hour
+	}
+	if ca.maxRegression == 0 {
+		ca.maxRegression = 0.1
+	}
+	if ca.alpha == 0 {
+		ca.alpha = 0.05
+	}
+	if ca.minDevices == 0 {
+		ca.minDevices = 5
+	}
+
+	return ca
+}
+
+// Analyze compares the canary and control cohorts for the current phase of a rollout
+func (ca *CanaryAnalyzer) Analyze(ctx context.Context, plan rollout.RolloutPlan, devices []DeviceRecord) (*CanaryAnalysis, error) {
+	now := time.Now().UTC()
+	analysis := &CanaryAnalysis{
+		RolloutID:   plan.ID,
+		GeneratedAt: now,
+		Since:       now.Add(-ca.window),
+		Comparisons: make([]MetricComparison, 0),
+		Verdict:     CanaryInconclusive,
+	}
+
+	if plan.CurrentPhase >= len(plan.Phases) {
+		analysis.Reason = "rollout has no active phase"
+		return analysis, nil
+	}
+
+	phase := plan.Phases[plan.CurrentPhase]
+	analysis.PhaseID = phase.ID
+
+	if len(phase.Metrics) == 0 && phase.Gate == "" {
+		analysis.Reason = "phase defines no metrics or gate"
+		return analysis, nil
+	}
+
+	maxRegression := thresholdOr(phase.Thresholds, canaryMaxRegressionThreshold, ca.maxRegression)
+	alpha := thresholdOr(phase.Thresholds, canaryAlphaThreshold, ca.alpha)
+	minDevices := int(thresholdOr(phase.Thresholds, canaryMinDevicesThreshold, float64(ca.minDevices)))
+
+	// A gate reads the canary cohort only, so it is decided in the last phase too
+	canary, control := splitCohorts(plan, devices)
+	if len(canary) < minDevices || (len(phase.Metrics) > 0 && len(control) < minDevices) {
+		analysis.Reason = fmt.Sprintf("need %d devices per cohort, have %d canary and %d control", minDevices, len(canary), len(control))
+		return analysis, nil
+	}
+
+	canarySamples := make(map[string][]float64)
+	controlSamples := make(map[string][]float64)
+	if len(phase.Metrics) > 0 {
+		var err error
+		if canarySamples, err = ca.cohortMeans(ctx, canary, analysis.Since); err != nil {
+			return nil, err
+		}
+		if controlSamples, err = ca.cohortMeans(ctx, control, analysis.Since); err != nil {
+			return nil, err
+		}
+	}
+
+	regressions := make([]string, 0)
+	inconclusive := make([]string, 0)
+	failures := make([]string, 0)
+
+	for _, entry := range phase.Metrics {
+		metric := strings.TrimSuffix(entry, higherIsBetterSuffix)
+		higherIsBetter := metric != entry
+
+		a := canarySamples[metric]
+		b := controlSamples[metric]
+		if len(a) < minDevices || len(b) < minDevices {
+			inconclusive = append(inconclusive, metric)
+			continue
+		}
+
+		comparison := compareCohorts(metric, a, b)
+		worse := comparison.RelativeDelta
+		if higherIsBetter {
+			worse = -worse
+		}
+		comparison.Regression = comparison.PValue < alpha && worse > maxRegression
+
+		if comparison.Regression {
+			regressions = append(regressions, metric)
+		}
+		analysis.Comparisons = append(analysis.Comparisons, comparison)
+	}
+
+	if len(regressions) > 0 {
+		failures = append(failures, "significant regression in "+strings.Join(regressions, ", "))
+	}
+
+	// A gate without data, or one that can't be evaluated, leaves the verdict open
+	undecided := ""
+	if phase.Gate != "" {
+		analysis.Gate = phase.Gate
+		expr, err := gate.Parse(phase.Gate)
+		if err != nil {
+			undecided = "invalid gate: " + err.Error()
+		} else {
+			telemetry, err := ca.gateTelemetry(ctx, expr, canary, now)
+			if err != nil {
+				return nil, err
+			}
+
+			met, values, err := expr.Eval(telemetry)
+			analysis.GateValues = values
+			if err != nil {
+				undecided = err.Error()
+			} else {
+				analysis.GateMet = &met
+				if !met {
+					failures = append(failures, "gate not met: "+phase.Gate)
+				}
+			}
+		}
+	}
+
+	switch {
+	case len(failures) > 0:
+		analysis.Verdict = CanaryFail
+		analysis.Reason = strings.Join(failures, "; ")
+	case len(inconclusive) > 0 || undecided != "":
+		reasons := make([]string, 0, 2)
+		if len(inconclusive) > 0 {
+			reasons = append(reasons, "insufficient telemetry for "+strings.Join(inconclusive, ", "))
+		}
+		if undecided != "" {
+			reasons = append(reasons, "gate undecided: "+undecided)
+		}
+		analysis.Reason = strings.Join(reasons, "; ")
+	default:
+		analysis.Verdict = CanaryPass
+		analysis.Reason = "no significant regression"
+	}
+
+	return analysis, nil
+}
+
+// gateTelemetry collects the canary cohort's samples of the metrics a gate
+// reads, over the longest window it reads them in
+func (ca *CanaryAnalyzer) gateTelemetry(ctx context.Context, expr *gate.Expr, canary []DeviceRecord, now time.Time) (gate.Telemetry, error) {
+	telemetry := gate.Telemetry{
+		Now:     now,
+		Window:  ca.window,
+		Devices: len(canary),
+		Samples: make(map[string][]gate.Sample),
+	}
+
+	metrics := make(map[string]bool)
+	for _, metric := range expr.Metrics() {
+		metrics[metric] = true
+	}
+
+	since := now.Add(-expr.Window(ca.window))
+	for _, device := range canary {
+		// Only count telemetry reported after the device updated
+		from := since
+		if updated, err := time.Parse(time.RFC3339, device.LastUpdateTime); err == nil && updated.After(from) {
+			from = updated
+		}
+
+		err := forEachTelemetry(ctx, ca.dynamoClient, ca.telemetryTableName, device.DeviceID, from, func(timestamp time.Time, values map[string]float64) {
+			for name, value := range values {
+				if metrics[name] {
+					telemetry.Samples[name] = append(telemetry.Samples[name], gate.Sample{Time: timestamp, Value: value})
+				}
+			}
+		})
+		if err != nil {
+			return telemetry, err
+		}
+	}
+
+	return telemetry, nil
+}
+
+// cohortMeans returns, per metric, the mean value of each device in the cohort over the window
+func (ca *CanaryAnalyzer) cohortMeans(ctx context.Context, cohort []DeviceRecord, since time.Time) (map[string][]float64, error) {
+	means := make(map[string][]float64)
+
+	for _, device := range cohort {
+		// Only count canary telemetry reported after the device updated
+		from := since
+		if updated, err := time.Parse(time.RFC3339, device.LastUpdateTime); err == nil && updated.After(from) {
+			from = updated
+		}
+
+		sums, counts, err := queryTelemetry(ctx, ca.dynamoClient, ca.telemetryTableName, device.DeviceID, from)
+		if err != nil {
+			return nil, err
+		}
+
+		for metric, sum := range sums {
+			means[metric] = append(means[metric], sum/float64(counts[metric]))
+		}
+	}
+
+	return means, nil
+}
+
+// queryTelemetry sums a device's metric values reported since a time
+func queryTelemetry(ctx context.Context, client *dynamodb.Client, tableName, deviceID string, since time.Time) (map[string]float64, map[string]int, error) {
+	sums := make(map[string]float64)
+	counts := make(map[string]int)
+
+	err := forEachTelemetry(ctx, client, tableName, deviceID, since, func(_ time.Time, metrics map[string]float64) {
+		for name, value := range metrics {
+			sums[name] += value
+			counts[name]++
+		}
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return sums, counts, nil
+}
+
+// forEachTelemetry calls fn with each telemetry report a device made since a time, oldest first
+func forEachTelemetry(ctx context.Context, client *dynamodb.Client, tableName, deviceID string, since time.Time, fn func(timestamp time.Time, metrics map[string]float64)) error {
+	paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("DeviceID = :deviceID AND #ts >= :since"),
+		ExpressionAttributeNames: map[string]string{
+			"#ts": "Timestamp",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":deviceID": &types.AttributeValueMemberS{Value: deviceID},
+			":since":    &types.AttributeValueMemberS{Value: since.UTC().Format(time.RFC3339Nano)},
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query telemetry for %s: %w", deviceID, err)
+		}
+
+		for _, item := range page.Items {
+			metrics, ok := item["Metrics"].(*types.AttributeValueMemberM)
+			if !ok {
+				continue
+			}
+
+			var timestamp time.Time
+			if ts, ok := item["Timestamp"].(*types.AttributeValueMemberS); ok {
+				timestamp, _ = time.Parse(time.RFC3339Nano, ts.Value)
+			}
+
+			values := make(map[string]float64, len(metrics.Value))
+			for name, attr := range metrics.Value {
+				n, ok := attr.(*types.AttributeValueMemberN)
+				if !ok {
+					continue
+				}
+				value, err := strconv.ParseFloat(n.Value, 64)
+				if err != nil {
+					continue
+				}
+				values[name] = value
+			}
+
+			fn(timestamp, values)
+		}
+	}
+
+	return nil
+}
+
+// RegisterRoutes registers the canary analysis API on the server
+func (ca *CanaryAnalyzer) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/rollouts/{id}/canary", http.HandlerFunc(ca.handleAnalysis))
+}
+
+// handleAnalysis runs a canary analysis for a rollout on demand
+func (ca *CanaryAnalyzer) handleAnalysis(w http.ResponseWriter, r *http.Request) {
+	plan, err := GetRollout(r.Context(), ca.dynamoClient, ca.rolloutTableName, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	devices, err := ScanDevices(r.Context(), ca.dynamoClient, ca.deviceTableName)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	analysis, err := ca.Analyze(r.Context(), *plan, devices)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, analysis)
+}
+
+// Helper functions
+
+// splitCohorts divides targeted devices into those that took the rollout and those not yet updated
+func splitCohorts(plan rollout.RolloutPlan, devices []DeviceRecord) ([]DeviceRecord, []DeviceRecord) {
+	canary := make([]DeviceRecord, 0)
+	control := make([]DeviceRecord, 0)
+
+	for _, device := range devices {
+		if !targetsDevice(plan, device) {
+			continue
+		}
+
+		switch {
+		case device.LastUpdateID == plan.ID && device.UpdateStatus == "success":
+			canary = append(canary, device)
+		case device.LastUpdateID != plan.ID && device.VersionFor(plan) != plan.Version:
+			control = append(control, device)
+		}
+	}
+
+	return canary, control
+}
+
+// compareCohorts computes the delta between cohort means and a two-sided Welch's t-test p-value
+func compareCohorts(metric string, canary, control []float64) MetricComparison {
+	canaryMean, canaryVar := meanVariance(canary)
+	controlMean, controlVar := meanVariance(control)
+
+	comparison := MetricComparison{
+		Metric:         metric,
+		CanaryDevices:  len(canary),
+		ControlDevices: len(control),
+		CanaryMean:     canaryMean,
+		ControlMean:    controlMean,
+		Delta:          canaryMean - controlMean,
+		PValue:         1,
+	}
+
+	if controlMean != 0 {
+		comparison.RelativeDelta = comparison.Delta / math.Abs(controlMean)
+	}
+
+	a := canaryVar / float64(len(canary))
+	b := controlVar / float64(len(control))
+	if a+b == 0 {
+		if comparison.Delta != 0 {
+			comparison.PValue = 0
+		}
+		return comparison
+	}
+
+	t := comparison.Delta / math.Sqrt(a+b)
+	df := (a + b) * (a + b) / (a*a/float64(len(canary)-1) + b*b/float64(len(control)-1))
+	comparison.PValue = regularizedIncompleteBeta(df/(df+t*t), df/2, 0.5)
+
+	return comparison
+}
+
+func meanVariance(values []float64) (float64, float64) {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+
+	if len(values) < 2 {
+		return mean, 0
+	}
+
+	var squares float64
+	for _, v := range values {
+		squares += (v - mean) * (v - mean)
+	}
+
+	return mean, squares / float64(len(values)-1)
+}
+
+// regularizedIncompleteBeta evaluates I_x(a, b) using its continued fraction expansion
+func regularizedIncompleteBeta(x, a, b float64) float64 {
+	if x <= 0 {
+		return 0
+	}
+	if x >= 1 {
+		return 1
+	}
+
+	lgA, _ := math.Lgamma(a)
+	lgB, _ := math.Lgamma(b)
+	lgAB, _ := math.Lgamma(a + b)
+	front := math.Exp(lgAB - lgA - lgB + a*math.Log(x) + b*math.Log(1-x))
+
+	// The continued fraction converges quickly only below the mean; use the symmetry relation above it
+	if x > (a+1)/(a+b+2) {
+		return 1 - front*betaContinuedFraction(1-x, b, a)/b
+	}
+	return front * betaContinuedFraction(x, a, b) / a
+}
+
+func betaContinuedFraction(x, a, b float64) float64 {
+	const (
+		maxIterations = 200
+		epsilon       = 1e-12
+		tiny          = 1e-300
+	)
+
+	c := 1.0
+	d := 1 - (a+b)*x/(a+1)
+	if math.Abs(d) < tiny {
+		d = tiny
+	}
+	d = 1 / d
+	result := d
+
+	for m := 1; m <= maxIterations; m++ {
+		fm := float64(m)
+
+		// Even step
+		numerator := fm * (b - fm) * x / ((a + 2*fm - 1) * (a + 2*fm))
+		d = 1 + numerator*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		result *= d * c
+
+		// Odd step
+		numerator = -(a + fm) * (a + b + fm) * x / ((a + 2*fm) * (a + 2*fm + 1))
+		d = 1 + numerator*d
+		if math.Abs(d) < tiny {
+			d = tiny
+		}
+		c = 1 + numerator/c
+		if math.Abs(c) < tiny {
+			c = tiny
+		}
+		d = 1 / d
+		delta := d * c
+		result *= delta
+
+		if math.Abs(delta-1) < epsilon {
+			break
+		}
+	}
+
+	return result
+}
+
+func thresholdOr(thresholds map[string]float64, key string, fallback float64) float64 {
+	if value, ok := thresholds[key]; ok {
+		return value
+	}
+	return fallback
+}
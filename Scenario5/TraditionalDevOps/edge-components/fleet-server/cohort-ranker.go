@@ -0,0 +1,188 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// CohortRanker periodically ranks the devices targeted by each cohorted
+// rollout within their cohort and stamps the ranks on the device records.
+// Devices and the agent gateway compare a cohorted rollout's phase
+// percentage to that rank instead of the device's fleet-wide percentile, so
+// a 10% phase reaches 10% of every hardware revision, however rare.
+type CohortRanker struct {
+	dynamoClient     *dynamodb.Client
+	deviceTableName  string
+	rolloutTableName string
+	interval         time.Duration
+	timer            *time.Timer
+}
+
+// CohortRankerConfig contains configuration for the CohortRanker
+type CohortRankerConfig struct {
+	DynamoClient     *dynamodb.Client
+	DeviceTableName  string
+	RolloutTableName string
+	Interval         time.Duration // how often ranks are recomputed; defaults to 5 minutes
+}
+
+// NewCohortRanker creates a new CohortRanker and starts ranking
+func NewCohortRanker(config CohortRankerConfig) *CohortRanker {
+	cr := &CohortRanker{
+		dynamoClient:     config.DynamoClient,
+		deviceTableName:  config.DeviceTableName,
+		rolloutTableName: config.RolloutTableName,
+		interval:         config.Interval,
+	}
+
+	if cr.interval == 0 {
+		cr.interval = 5 * time.Minute
+	}
+
+	// Start the ranking timer
+	cr.timer = time.AfterFunc(0, cr.rankLoop)
+
+	return cr
+}
+
+// Stop stops ranking; ranks already stamped stay in place
+func (cr *CohortRanker) Stop() {
+	cr.timer.Stop()
+}
+
+// rankLoop updates the ranks and reschedules itself
+func (cr *CohortRanker) rankLoop() {
+	defer func() {
+		// Reschedule the ranking
+		cr.timer.Reset(cr.interval)
+	}()
+
+	if err := cr.RankAll(context.Background()); err != nil {
+		log.Printf("Failed to rank rollout cohorts: %v", err)
+	}
+}
+
+// RankAll computes every device's cohort percentiles for the cohorted
+// rollouts that haven't finished and writes the ones that changed
+func (cr *CohortRanker) RankAll(ctx context.Context) error {
+	devices, err := ScanDevices(ctx, cr.dynamoClient, cr.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	plans, err := ScanRollouts(ctx, cr.dynamoClient, cr.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	live := make([]DeviceRecord, 0, len(devices))
+	for _, device := range devices {
+		if !device.Expired(now) {
+			live = append(live, device)
+		}
+	}
+
+	percentiles := make(map[string]map[string]float64)
+	for _, plan := range plans {
+		switch plan.Status {
+		case "pending", "in-progress", "paused":
+		default:
+			continue
+		}
+		if plan.CohortBy == "" {
+			continue
+		}
+
+		for deviceKey, percentile := range RankCohorts(plan, live) {
+			if percentiles[deviceKey] == nil {
+				percentiles[deviceKey] = make(map[string]float64)
+			}
+			percentiles[deviceKey][plan.ID] = percentile
+		}
+	}
+
+	changed := 0
+	for _, device := range live {
+		ranks := percentiles[device.DeviceID]
+		if (len(ranks) == 0 && len(device.CohortPercentiles) == 0) || reflect.DeepEqual(ranks, device.CohortPercentiles) {
+			continue
+		}
+
+		if err := cr.store(ctx, device.DeviceID, ranks); err != nil {
+			log.Printf("Failed to store cohort percentiles for %s: %v", device.DeviceID, err)
+			continue
+		}
+		changed++
+	}
+
+	if changed > 0 {
+		log.Printf("Updated the cohort percentiles of %d devices", changed)
+	}
+	return nil
+}
+
+// RankCohorts returns the percentile of each device the rollout targets
+// within its cohort, by device key
+func RankCohorts(plan rollout.RolloutPlan, devices []DeviceRecord) map[string]float64 {
+	// Devices are ranked by their own ID, as devices compute their percentile
+	cohorts := make(map[string][]string)
+	keys := make(map[string]string)
+	for _, device := range devices {
+		if !targetsDevice(plan, device) {
+			continue
+		}
+		_, deviceID := tenant.Split(device.DeviceID)
+		cohort := plan.CohortOf(device.Tags)
+		cohorts[cohort] = append(cohorts[cohort], deviceID)
+		keys[deviceID] = device.DeviceID
+	}
+
+	ranks := make(map[string]float64, len(keys))
+	for _, deviceIDs := range cohorts {
+		for deviceID, percentile := range rollout.RankCohort(deviceIDs) {
+			ranks[keys[deviceID]] = percentile
+		}
+	}
+	return ranks
+}
+
+// store writes a device's cohort percentiles, removing them when it has none
+func (cr *CohortRanker) store(ctx context.Context, deviceID string, percentiles map[string]float64) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(cr.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression:    aws.String("REMOVE CohortPercentiles"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID)"),
+	}
+
+	if len(percentiles) > 0 {
+		item, err := attributevalue.Marshal(percentiles)
+		if err != nil {
+			return fmt.Errorf("failed to marshal cohort percentiles: %w", err)
+		}
+		input.UpdateExpression = aws.String("SET CohortPercentiles = :percentiles")
+		input.ExpressionAttributeValues = map[string]types.AttributeValue{
+			":percentiles": item,
+		}
+	}
+
+	if _, err := cr.dynamoClient.UpdateItem(ctx, input); err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,265 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// UsagePrices are the unit prices used to estimate rollout costs, in USD
+type UsagePrices struct {
+	S3TransferPerGB      float64 `json:"s3TransferPerGb"`
+	S3RequestsPerK       float64 `json:"s3RequestsPerK"` // GET requests, per thousand
+	ReadUnitsPerMillion  float64 `json:"readUnitsPerMillion"`
+	WriteUnitsPerMillion float64 `json:"writeUnitsPerMillion"`
+}
+
+// DeviceUsage is the AWS usage one device incurred for a rollout, as stored in the usage table
+type DeviceUsage struct {
+	RolloutID  string  `dynamodbav:"RolloutID"`
+	DeviceID   string  `dynamodbav:"DeviceID"`
+	S3Bytes    int64   `dynamodbav:"S3Bytes"`
+	ReadUnits  float64 `dynamodbav:"ReadUnits"`
+	WriteUnits float64 `dynamodbav:"WriteUnits"`
+	ReportedAt string  `dynamodbav:"ReportedAt"`
+	ExpiresAt  int64   `dynamodbav:"ExpiresAt,omitempty"` // TTL attribute, set when Retention is
+}
+
+// RolloutCost is the usage attributed to a rollout and its estimated cost
+type RolloutCost struct {
+	RolloutID      string      `json:"rolloutId"`
+	Devices        int         `json:"devices"`
+	S3Bytes        int64       `json:"s3Bytes"`
+	ReadUnits      float64     `json:"readUnits"`
+	WriteUnits     float64     `json:"writeUnits"`
+	S3TransferCost float64     `json:"s3TransferCost"`
+	S3RequestCost  float64     `json:"s3RequestCost"`
+	DynamoCost     float64     `json:"dynamoCost"`
+	TotalCost      float64     `json:"totalCost"`
+	Currency       string      `json:"currency"`
+	Prices         UsagePrices `json:"prices"`
+}
+
+// CostAccountant rolls up the usage devices report with their final update
+// status into a per-rollout usage table and estimates what each rollout
+// cost. Devices keep usage for their latest rollout only, so the roll-up
+// copies it out before they move on to the next one.
+type CostAccountant struct {
+	dynamoClient     *dynamodb.Client
+	deviceTableName  string
+	rolloutTableName string
+	usageTableName   string
+	prices           UsagePrices
+	interval         time.Duration
+	retention        time.Duration
+	recorded         map[string]DeviceUsage // device ID -> last usage written
+	accountantMutex  sync.Mutex
+	timer            *time.Timer
+}
+
+// CostAccountantConfig contains configuration for the CostAccountant; zero
+// prices default to AWS list prices for us-east-1
+type CostAccountantConfig struct {
+	DynamoClient     *dynamodb.Client
+	DeviceTableName  string
+	RolloutTableName string
+	UsageTableName   string // partition key RolloutID, sort key DeviceID
+	Prices           UsagePrices
+	Interval         time.Duration
+	Retention        time.Duration // sets the ExpiresAt TTL attribute of usage records when non-zero
+}
+
+// NewCostAccountant creates a new CostAccountant and starts rolling up usage
+func NewCostAccountant(config CostAccountantConfig) *CostAccountant {
+	ca := &CostAccountant{
+		dynamoClient:     config.DynamoClient,
+		deviceTableName:  config.DeviceTableName,
+		rolloutTableName: config.RolloutTableName,
+		usageTableName:   config.UsageTableName,
+		prices:           config.Prices,
+		interval:         config.Interval,
+		retention:        config.Retention,
+		recorded:         make(map[string]DeviceUsage),
+	}
+
+	if ca.prices.S3TransferPerGB == 0 {
+		ca.prices.S3TransferPerGB = 0.09
+	}
+	if ca.prices.S3RequestsPerK == 0 {
+		ca.prices.S3RequestsPerK = 0.0004
+	}
+	if ca.prices.ReadUnitsPerMillion == 0 {
+		ca.prices.ReadUnitsPerMillion = 0.25
+	}
+	if ca.prices.WriteUnitsPerMillion == 0 {
+		ca.prices.WriteUnitsPerMillion = 1.25
+	}
+	if ca.interval == 0 {
+		ca.interval = 5 * time.Minute
+	}
+
+	// Start the roll-up timer
+	ca.timer = time.AfterFunc(ca.interval, ca.rollUpLoop)
+
+	return ca
+}
+
+// rollUpLoop rolls up usage and reschedules itself
+func (ca *CostAccountant) rollUpLoop() {
+	defer func() {
+		// Reschedule the roll-up
+		ca.timer.Reset(ca.interval)
+	}()
+
+	if err := ca.RollUp(context.Background()); err != nil {
+		log.Printf("Failed to roll up rollout usage: %v", err)
+	}
+}
+
+// RollUp copies the usage each device reported into the usage table
+func (ca *CostAccountant) RollUp(ctx context.Context) error {
+	ca.accountantMutex.Lock()
+	defer ca.accountantMutex.Unlock()
+
+	devices, err := ScanDevices(ctx, ca.dynamoClient, ca.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		if device.UsageRolloutID == "" {
+			continue
+		}
+
+		usage := DeviceUsage{
+			RolloutID:  device.UsageRolloutID,
+			DeviceID:   device.DeviceID,
+			S3Bytes:    device.UsageS3Bytes,
+			ReadUnits:  device.UsageReadUnits,
+			WriteUnits: device.UsageWriteUnits,
+		}
+
+		// Skip writes for usage that has not changed since the last roll-up
+		previous, ok := ca.recorded[device.DeviceID]
+		previous.ReportedAt, previous.ExpiresAt = "", 0
+		if ok && previous == usage {
+			continue
+		}
+
+		now := time.Now().UTC()
+		usage.ReportedAt = now.Format(time.RFC3339)
+		if ca.retention > 0 {
+			usage.ExpiresAt = now.Add(ca.retention).Unix()
+		}
+		item, err := attributevalue.MarshalMap(usage)
+		if err != nil {
+			return fmt.Errorf("failed to marshal usage: %w", err)
+		}
+
+		_, err = ca.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+			TableName: aws.String(ca.usageTableName),
+			Item:      item,
+		})
+		if err != nil {
+			log.Printf("Failed to record usage for %s: %v", device.DeviceID, err)
+			continue
+		}
+
+		ca.recorded[device.DeviceID] = usage
+	}
+
+	return nil
+}
+
+// Cost sums the usage recorded for a rollout and estimates its cost
+func (ca *CostAccountant) Cost(ctx context.Context, rolloutID string) (*RolloutCost, error) {
+	cost := &RolloutCost{
+		RolloutID: rolloutID,
+		Currency:  "USD",
+		Prices:    ca.prices,
+	}
+
+	paginator := dynamodb.NewQueryPaginator(ca.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(ca.usageTableName),
+		KeyConditionExpression: aws.String("RolloutID = :rolloutID"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rolloutID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query usage: %w", err)
+		}
+
+		for _, item := range page.Items {
+			var usage DeviceUsage
+			if err := attributevalue.UnmarshalMap(item, &usage); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal usage: %w", err)
+			}
+			if usage.ExpiresAt != 0 && time.Now().Unix() >= usage.ExpiresAt {
+				continue
+			}
+
+			cost.Devices++
+			cost.S3Bytes += usage.S3Bytes
+			cost.ReadUnits += usage.ReadUnits
+			cost.WriteUnits += usage.WriteUnits
+		}
+	}
+
+	// Each device fetches its package once
+	cost.S3TransferCost = roundCost(float64(cost.S3Bytes) / (1 << 30) * ca.prices.S3TransferPerGB)
+	cost.S3RequestCost = roundCost(float64(cost.Devices) / 1000 * ca.prices.S3RequestsPerK)
+	cost.DynamoCost = roundCost(cost.ReadUnits/1e6*ca.prices.ReadUnitsPerMillion + cost.WriteUnits/1e6*ca.prices.WriteUnitsPerMillion)
+	cost.TotalCost = roundCost(cost.S3TransferCost + cost.S3RequestCost + cost.DynamoCost)
+
+	return cost, nil
+}
+
+// Close stops the accountant
+func (ca *CostAccountant) Close() {
+	if ca.timer != nil {
+		ca.timer.Stop()
+	}
+}
+
+// RegisterRoutes registers the cost API on the server
+func (ca *CostAccountant) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/rollouts/{id}/cost", http.HandlerFunc(ca.handleCost))
+}
+
+// handleCost returns a rollout's usage and estimated cost
+func (ca *CostAccountant) handleCost(w http.ResponseWriter, r *http.Request) {
+	// Resolve the rollout first so tenants only see their own rollouts' costs
+	plan, err := GetRollout(r.Context(), ca.dynamoClient, ca.rolloutTableName, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	cost, err := ca.Cost(r.Context(), plan.ID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, cost)
+}
+
+// Helper functions
+
+// roundCost rounds a cost to a hundredth of a cent so small rollouts still show a cost
+func roundCost(value float64) float64 {
+	return math.Round(value*10000) / 10000
+}
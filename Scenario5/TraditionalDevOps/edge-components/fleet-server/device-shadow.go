@@ -0,0 +1,304 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Shadow states
+const (
+	ShadowInSync      = "in-sync"     // no desired state, or the device reports it
+	ShadowReconciling = "reconciling" // the device hasn't reached its desired state yet
+	ShadowFailed      = "failed"      // the device failed to apply its desired state
+)
+
+// ReportedState is what a device last reported about itself
+type ReportedState struct {
+	CurrentVersion    string   `json:"currentVersion"`
+	ConfigVersion     string   `json:"configVersion,omitempty"`
+	UpdateStatus      string   `json:"updateStatus,omitempty"`
+	LastUpdateID      string   `json:"lastUpdateId,omitempty"`
+	LastUpdateTime    string   `json:"lastUpdateTime,omitempty"`
+	LastUpdateMessage string   `json:"lastUpdateMessage,omitempty"`
+	Healthy           *bool    `json:"healthy,omitempty"`
+	HealthScore       *float64 `json:"healthScore,omitempty"`
+	LastSeen          string   `json:"lastSeen,omitempty"`
+}
+
+// DeviceShadow is a device's desired state beside its reported state
+type DeviceShadow struct {
+	DeviceID string                `json:"deviceId"`
+	Desired  *rollout.DesiredState `json:"desired,omitempty"`
+	Reported ReportedState         `json:"reported"`
+	State    string                `json:"state"`
+}
+
+// Shadow splits the device record into what was asked of the device and what it reports
+func (d DeviceRecord) Shadow() DeviceShadow {
+	_, deviceID := tenant.Split(d.DeviceID)
+	shadow := DeviceShadow{
+		DeviceID: deviceID,
+		Desired:  d.Desired,
+		Reported: ReportedState{
+			CurrentVersion:    d.CurrentVersion,
+			ConfigVersion:     d.ConfigVersion,
+			UpdateStatus:      d.UpdateStatus,
+			LastUpdateID:      d.LastUpdateID,
+			LastUpdateTime:    d.LastUpdateTime,
+			LastUpdateMessage: d.LastUpdateMessage,
+			Healthy:           d.Healthy,
+			HealthScore:       d.HealthScore,
+			LastSeen:          d.LastSeen,
+		},
+		State: ShadowInSync,
+	}
+
+	if d.Desired == nil {
+		return shadow
+	}
+
+	reached := d.CurrentVersion
+	if d.Desired.ConfigPayload != "" {
+		reached = d.ConfigVersion
+	}
+	switch {
+	case reached == d.Desired.Version:
+		shadow.State = ShadowInSync
+	case d.LastUpdateID == d.Desired.ID && (d.UpdateStatus == "failed" || d.UpdateStatus == "rolled-back"):
+		shadow.State = ShadowFailed
+	default:
+		shadow.State = ShadowReconciling
+	}
+
+	return shadow
+}
+
+// ShadowService reads device shadows and sets or clears a device's desired
+// state, for operations on single devices alongside fleet-wide rollouts
+type ShadowService struct {
+	dynamoClient    *dynamodb.Client
+	deviceTableName string
+	auditLog        *AuditLog
+	signer          publisher.Signer
+}
+
+// ShadowServiceConfig contains configuration for the ShadowService
+type ShadowServiceConfig struct {
+	DynamoClient    *dynamodb.Client
+	DeviceTableName string
+	AuditLog        *AuditLog
+	Signer          publisher.Signer // signs desired states for devices that verify plans
+}
+
+// NewShadowService creates a new ShadowService
+func NewShadowService(config ShadowServiceConfig) *ShadowService {
+	return &ShadowService{
+		dynamoClient:    config.DynamoClient,
+		deviceTableName: config.DeviceTableName,
+		auditLog:        config.AuditLog,
+		signer:          config.Signer,
+	}
+}
+
+// Shadow returns the shadow of a device in the context's tenant, or nil when
+// the device doesn't exist
+func (ss *ShadowService) Shadow(ctx context.Context, deviceID string) (*DeviceShadow, error) {
+	device, err := GetDevice(ctx, ss.dynamoClient, ss.deviceTableName, tenant.Key(tenant.FromContext(ctx), deviceID))
+	if err != nil || device == nil {
+		return nil, err
+	}
+
+	shadow := device.Shadow()
+	return &shadow, nil
+}
+
+// SetDesired replaces a device's desired state. Each write gets a new ID, so
+// the device abandons an update toward the state it replaces.
+func (ss *ShadowService) SetDesired(ctx context.Context, deviceID string, desired rollout.DesiredState, actor string) (*rollout.DesiredState, error) {
+	if err := validateDesired(desired); err != nil {
+		return nil, err
+	}
+
+	desired.ID = rollout.DesiredStatePrefix + uuid.New().String()
+	desired.SetBy = actor
+	desired.SetAt = time.Now().UTC()
+
+	if err := ss.sign(tenant.FromContext(ctx), &desired); err != nil {
+		return nil, err
+	}
+
+	value, err := attributevalue.Marshal(desired)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal desired state: %w", err)
+	}
+
+	_, err = ss.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(ss.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(tenant.FromContext(ctx), deviceID)},
+		},
+		UpdateExpression:    aws.String("SET Desired = :desired"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":desired": value,
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil, fmt.Errorf("%w: %s", rollout.ErrDeviceNotFound, deviceID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to set desired state: %w", err)
+	}
+
+	ss.audit(ctx, actor, "device.desired-set", desired.ID, map[string]string{"device": deviceID, "version": desired.Version})
+
+	return &desired, nil
+}
+
+// ClearDesired removes a device's desired state, returning it to fleet rollouts
+func (ss *ShadowService) ClearDesired(ctx context.Context, deviceID, actor string) error {
+	result, err := ss.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(ss.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(tenant.FromContext(ctx), deviceID)},
+		},
+		UpdateExpression:    aws.String("REMOVE Desired"),
+		ConditionExpression: aws.String("attribute_exists(Desired)"),
+		ReturnValues:        types.ReturnValueUpdatedOld,
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("device %s has no desired state", deviceID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clear desired state: %w", err)
+	}
+
+	var cleared struct {
+		Desired rollout.DesiredState `dynamodbav:"Desired"`
+	}
+	if err := attributevalue.UnmarshalMap(result.Attributes, &cleared); err != nil {
+		log.Printf("Failed to unmarshal cleared desired state of %s: %v", deviceID, err)
+	}
+
+	ss.audit(ctx, actor, "device.desired-cleared", cleared.Desired.ID, map[string]string{"device": deviceID})
+
+	return nil
+}
+
+// sign sets the desired state's signature when a signer is configured
+func (ss *ShadowService) sign(tenantID string, desired *rollout.DesiredState) error {
+	desired.Signature, desired.SigningKeyID = "", ""
+	if ss.signer == nil {
+		return nil
+	}
+
+	signature, err := ss.signer.Sign(desired.Plan(tenantID).SigningPayload())
+	if err != nil {
+		return fmt.Errorf("failed to sign desired state: %w", err)
+	}
+	desired.Signature = base64.StdEncoding.EncodeToString(signature)
+	desired.SigningKeyID = ss.signer.KeyID()
+
+	return nil
+}
+
+// audit records a device event, logging rather than failing on audit errors
+func (ss *ShadowService) audit(ctx context.Context, actor, action, desiredID string, details map[string]string) {
+	if ss.auditLog == nil {
+		return
+	}
+
+	if err := ss.auditLog.Record(ctx, actor, action, desiredID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// RegisterRoutes registers the device shadow API on the server
+func (ss *ShadowService) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/devices/{id}/shadow", http.HandlerFunc(ss.handleGet))
+	s.Handle("PUT /api/devices/{id}/shadow/desired", http.HandlerFunc(ss.handleSetDesired))
+	s.Handle("DELETE /api/devices/{id}/shadow/desired", http.HandlerFunc(ss.handleClearDesired))
+}
+
+// handleGet returns a device's shadow
+func (ss *ShadowService) handleGet(w http.ResponseWriter, r *http.Request) {
+	shadow, err := ss.Shadow(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if shadow == nil {
+		writeError(w, http.StatusNotFound, "device not found: "+r.PathValue("id"))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, shadow)
+}
+
+// handleSetDesired sets a device's desired state from the request body
+func (ss *ShadowService) handleSetDesired(w http.ResponseWriter, r *http.Request) {
+	var desired rollout.DesiredState
+	if err := json.NewDecoder(r.Body).Decode(&desired); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	stored, err := ss.SetDesired(r.Context(), r.PathValue("id"), desired, actorFor(r.Context(), desired.SetBy))
+	if errors.Is(err, rollout.ErrDeviceNotFound) {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, stored)
+}
+
+// handleClearDesired clears a device's desired state
+func (ss *ShadowService) handleClearDesired(w http.ResponseWriter, r *http.Request) {
+	if err := ss.ClearDesired(r.Context(), r.PathValue("id"), actorFor(r.Context(), "")); err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "cleared"})
+}
+
+// Helper functions
+
+// validateDesired checks a desired state names a version and one way to deliver it
+func validateDesired(desired rollout.DesiredState) error {
+	if desired.Version == "" {
+		return errors.New("version is required")
+	}
+	if desired.ConfigPayload != "" {
+		if desired.PackageURL != "" || desired.PackageHash != "" || desired.ArtifactName != "" {
+			return errors.New("a desired state sets either a package or a configPayload, not both")
+		}
+		return nil
+	}
+	if desired.ArtifactName == "" && (desired.PackageURL == "" || desired.PackageHash == "") {
+		return errors.New("artifactName, or packageUrl and packageHash, are required")
+	}
+	return nil
+}
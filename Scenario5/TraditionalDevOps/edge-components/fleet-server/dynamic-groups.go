@@ -0,0 +1,376 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// GroupDefinition defines a dynamic device group as a tag query, e.g.
+// `tag.hardware = rpi4 and region in (eu-west-1, eu-central-1) and tag.firmware < 2.3`
+type GroupDefinition struct {
+	Name        string `dynamodbav:"Name" json:"name"`
+	Query       string `dynamodbav:"Query" json:"query"`
+	Description string `dynamodbav:"Description" json:"description"`
+}
+
+// condition is a single comparison within a group query
+type condition struct {
+	field    string
+	operator string
+	values   []string
+}
+
+// groupQuery is a parsed group definition; all conditions must match
+type groupQuery struct {
+	name       string
+	conditions []condition
+}
+
+// GroupMaterializer periodically evaluates dynamic group queries and writes
+// the resulting memberships into the device table's DynamicGroups attribute
+type GroupMaterializer struct {
+	dynamoClient        *dynamodb.Client
+	deviceTableName     string
+	groupTableName      string
+	materializeMutex    sync.Mutex
+	materializeInterval time.Duration
+	materializeTimer    *time.Timer
+}
+
+// GroupMaterializerConfig contains configuration for the GroupMaterializer
+type GroupMaterializerConfig struct {
+	DynamoClient        *dynamodb.Client
+	DeviceTableName     string
+	GroupTableName      string
+	MaterializeInterval time.Duration
+}
+
+// NewGroupMaterializer creates a new GroupMaterializer
+func NewGroupMaterializer(config GroupMaterializerConfig) *GroupMaterializer {
+	gm := &GroupMaterializer{
+		dynamoClient:        config.DynamoClient,
+		deviceTableName:     config.DeviceTableName,
+		groupTableName:      config.GroupTableName,
+		materializeInterval: config.MaterializeInterval,
+	}
+
+	// Start the materialization timer
+	gm.materializeTimer = time.AfterFunc(gm.materializeInterval, gm.materializeLoop)
+
+	return gm
+}
+
+// materializeLoop materializes groups and reschedules itself
+func (gm *GroupMaterializer) materializeLoop() {
+	defer func() {
+		// Reschedule the materialization
+		gm.materializeTimer.Reset(gm.materializeInterval)
+	}()
+
+	if err := gm.Materialize(context.Background()); err != nil {
+		log.Printf("Failed to materialize dynamic groups: %v", err)
+	}
+}
+
+// Materialize evaluates every group definition against every device and updates changed memberships
+func (gm *GroupMaterializer) Materialize(ctx context.Context) error {
+	gm.materializeMutex.Lock()
+	defer gm.materializeMutex.Unlock()
+
+	definitions, err := gm.listDefinitions(ctx)
+	if err != nil {
+		return err
+	}
+
+	queries := make([]groupQuery, 0, len(definitions))
+	for _, def := range definitions {
+		query, err := parseGroupQuery(def.Name, def.Query)
+		if err != nil {
+			log.Printf("Skipping invalid group %s: %v", def.Name, err)
+			continue
+		}
+		queries = append(queries, query)
+	}
+
+	devices, err := ScanDevices(ctx, gm.dynamoClient, gm.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		groups := make([]string, 0)
+		for _, query := range queries {
+			if query.matches(device) {
+				groups = append(groups, query.name)
+			}
+		}
+		sort.Strings(groups)
+
+		if sameStrings(groups, device.DynamicGroups) {
+			continue
+		}
+
+		if err := gm.setDynamicGroups(ctx, device.DeviceID, groups); err != nil {
+			log.Printf("Failed to update dynamic groups for %s: %v", device.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// Members returns the devices currently matching a query without materializing it
+func (gm *GroupMaterializer) Members(ctx context.Context, query string) ([]string, error) {
+	parsed, err := parseGroupQuery("preview", query)
+	if err != nil {
+		return nil, err
+	}
+
+	devices, err := ScanDevices(ctx, gm.dynamoClient, gm.deviceTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	members := make([]string, 0)
+	for _, device := range devices {
+		if parsed.matches(device) {
+			members = append(members, device.DeviceID)
+		}
+	}
+
+	return members, nil
+}
+
+// listDefinitions reads all group definitions
+func (gm *GroupMaterializer) listDefinitions(ctx context.Context) ([]GroupDefinition, error) {
+	definitions := make([]GroupDefinition, 0)
+
+	paginator := dynamodb.NewScanPaginator(gm.dynamoClient, &dynamodb.ScanInput{
+		TableName: aws.String(gm.groupTableName),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan group table: %w", err)
+		}
+
+		var batch []GroupDefinition
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal groups: %w", err)
+		}
+		definitions = append(definitions, batch...)
+	}
+
+	return definitions, nil
+}
+
+// setDynamicGroups writes a device's dynamic group memberships
+func (gm *GroupMaterializer) setDynamicGroups(ctx context.Context, deviceID string, groups []string) error {
+	members := make([]types.AttributeValue, 0, len(groups))
+	for _, g := range groups {
+		members = append(members, &types.AttributeValueMemberS{Value: g})
+	}
+
+	_, err := gm.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(gm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression: aws.String("SET DynamicGroups = :groups, DynamicGroupsUpdatedAt = :time"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":groups": &types.AttributeValueMemberL{Value: members},
+			":time":   &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	})
+
+	return err
+}
+
+// Close stops the group materializer
+func (gm *GroupMaterializer) Close() {
+	if gm.materializeTimer != nil {
+		gm.materializeTimer.Stop()
+	}
+}
+
+// parseGroupQuery parses whitespace-separated conditions of the form `field op value`
+// joined by `and`; supported operators are =, !=, <, <=, >, >= and `in (a, b, ...)`
+func parseGroupQuery(name, query string) (groupQuery, error) {
+	parsed := groupQuery{name: name}
+
+	for _, clause := range splitAnd(query) {
+		clause = strings.TrimSpace(clause)
+		if clause == "" {
+			continue
+		}
+
+		fields := strings.Fields(clause)
+		if len(fields) < 3 {
+			return parsed, fmt.Errorf("invalid condition %q", clause)
+		}
+
+		cond := condition{field: fields[0], operator: strings.ToLower(fields[1])}
+		afterField := strings.Index(clause, fields[0]) + len(fields[0])
+		afterOperator := afterField + strings.Index(clause[afterField:], fields[1]) + len(fields[1])
+		rest := strings.TrimSpace(clause[afterOperator:])
+
+		switch cond.operator {
+		case "=", "!=", "<", "<=", ">", ">=":
+			cond.values = []string{strings.Trim(rest, `"'`)}
+		case "in":
+			if !strings.HasPrefix(rest, "(") || !strings.HasSuffix(rest, ")") {
+				return parsed, fmt.Errorf("expected a parenthesised list in %q", clause)
+			}
+			for _, v := range strings.Split(rest[1:len(rest)-1], ",") {
+				cond.values = append(cond.values, strings.Trim(strings.TrimSpace(v), `"'`))
+			}
+		default:
+			return parsed, fmt.Errorf("unsupported operator %q", fields[1])
+		}
+
+		parsed.conditions = append(parsed.conditions, cond)
+	}
+
+	if len(parsed.conditions) == 0 {
+		return parsed, fmt.Errorf("query has no conditions")
+	}
+
+	return parsed, nil
+}
+
+// matches reports whether a device satisfies every condition of the query
+func (q groupQuery) matches(device DeviceRecord) bool {
+	for _, cond := range q.conditions {
+		value, ok := deviceField(device, cond.field)
+		if !ok || !cond.matches(value) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches evaluates a condition against a device field value
+func (c condition) matches(value string) bool {
+	switch c.operator {
+	case "=":
+		return value == c.values[0]
+	case "!=":
+		return value != c.values[0]
+	case "in":
+		for _, v := range c.values {
+			if value == v {
+				return true
+			}
+		}
+		return false
+	}
+
+	cmp := compareVersions(value, c.values[0])
+	switch c.operator {
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	}
+
+	return false
+}
+
+// Helper functions
+
+// deviceField resolves a query field against a device record
+func deviceField(device DeviceRecord, field string) (string, bool) {
+	if strings.HasPrefix(field, "tag.") {
+		value, ok := device.Tags[strings.TrimPrefix(field, "tag.")]
+		return value, ok
+	}
+
+	switch field {
+	case "group":
+		return device.DeviceGroup, true
+	case "region":
+		return device.Region, true
+	case "version":
+		return device.CurrentVersion, true
+	}
+
+	return "", false
+}
+
+// compareVersions compares dotted versions numerically segment by segment,
+// falling back to string comparison for non-numeric segments
+func compareVersions(a, b string) int {
+	as := strings.Split(strings.TrimPrefix(a, "v"), ".")
+	bs := strings.Split(strings.TrimPrefix(b, "v"), ".")
+
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y string
+		if i < len(as) {
+			x = as[i]
+		}
+		if i < len(bs) {
+			y = bs[i]
+		}
+
+		xi, xerr := strconv.Atoi(x)
+		yi, yerr := strconv.Atoi(y)
+		if x == "" {
+			xi, xerr = 0, nil
+		}
+		if y == "" {
+			yi, yerr = 0, nil
+		}
+
+		if xerr == nil && yerr == nil {
+			if xi != yi {
+				if xi < yi {
+					return -1
+				}
+				return 1
+			}
+			continue
+		}
+
+		if x != y {
+			return strings.Compare(x, y)
+		}
+	}
+
+	return 0
+}
+
+func splitAnd(query string) []string {
+	return strings.Split(strings.ReplaceAll(query, " AND ", " and "), " and ")
+}
+
+func sameStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+
+	sorted := append([]string{}, b...)
+	sort.Strings(sorted)
+
+	for i := range a {
+		if a[i] != sorted[i] {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,383 @@
+package fleetserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/google/uuid"
+	"github.com/parquet-go/parquet-go"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Datasets written by the EventExporter, one S3 prefix and table each
+const (
+	DatasetRolloutEvents = "rollout_events"
+	DatasetSyncEvents    = "sync_events"
+	DatasetDeviceEvents  = "device_events"
+	DatasetTelemetry     = "telemetry"
+)
+
+// RolloutEventRow is an operator action on a rollout, from the audit log, or
+// an update status a device reported for one
+type RolloutEventRow struct {
+	EventTime time.Time `parquet:"event_time,timestamp(millisecond)"`
+	TenantID  string    `parquet:"tenant_id"`
+	RolloutID string    `parquet:"rollout_id"`
+	DeviceID  string    `parquet:"device_id"` // empty for operator actions
+	Source    string    `parquet:"source"`    // audit or device
+	Action    string    `parquet:"action"`    // the audit action, or the device's update status
+	Actor     string    `parquet:"actor"`
+	Version   string    `parquet:"version"`
+	Message   string    `parquet:"message"`
+	Details   string    `parquet:"details"` // JSON object of the audit details
+}
+
+// SyncEventRow is an offline sync a device completed or failed
+type SyncEventRow struct {
+	EventTime   time.Time `parquet:"event_time,timestamp(millisecond)"`
+	TenantID    string    `parquet:"tenant_id"`
+	DeviceID    string    `parquet:"device_id"`
+	DeviceGroup string    `parquet:"device_group"`
+	Status      string    `parquet:"status"`
+	Message     string    `parquet:"message"`
+}
+
+// DeviceEventRow is any other device status change: version, health,
+// connected or disconnected
+type DeviceEventRow struct {
+	EventTime   time.Time `parquet:"event_time,timestamp(millisecond)"`
+	TenantID    string    `parquet:"tenant_id"`
+	DeviceID    string    `parquet:"device_id"`
+	DeviceGroup string    `parquet:"device_group"`
+	Type        string    `parquet:"type"`
+	Version     string    `parquet:"version"`
+	Status      string    `parquet:"status"`
+	Healthy     *bool     `parquet:"healthy,optional"`
+	Message     string    `parquet:"message"`
+}
+
+// TelemetryRow is one metric value an agent reported
+type TelemetryRow struct {
+	EventTime time.Time `parquet:"event_time,timestamp(millisecond)"`
+	TenantID  string    `parquet:"tenant_id"`
+	DeviceID  string    `parquet:"device_id"`
+	Metric    string    `parquet:"metric"`
+	Value     float64   `parquet:"value"`
+}
+
+// datasetModels gives the row type, and so the Parquet schema, of each dataset
+var datasetModels = map[string]interface{}{
+	DatasetRolloutEvents: RolloutEventRow{},
+	DatasetSyncEvents:    SyncEventRow{},
+	DatasetDeviceEvents:  DeviceEventRow{},
+	DatasetTelemetry:     TelemetryRow{},
+}
+
+// exportRow is a buffered row and the time that places it in a partition
+type exportRow struct {
+	at  time.Time
+	row interface{}
+}
+
+// EventExporter converts rollout, sync, device and telemetry events into
+// Parquet datasets in S3 for analysts, so Athena or Glue queries never touch
+// the operational tables. Rows are buffered in memory and written every
+// FlushInterval, one file per dataset and hour, under Hive-style partitions:
+//
+//	<prefix><dataset>/date=2024-05-01/hour=13/20240501T131500Z-<uuid>.parquet
+//
+// Feed it with AuditLog.SetExporter, StatusHubConfig.Exporter and
+// AgentGatewayConfig.Exporter. Buffered rows are lost if the process exits
+// without Close; an analytics copy accepts that, the operational tables don't.
+type EventExporter struct {
+	s3Client      *s3.Client
+	bucket        string
+	prefix        string
+	flushInterval time.Duration
+	maxRows       int
+	maxBuffered   int
+	pending       map[string][]exportRow
+	buffered      int
+	dropped       int
+	failing       bool
+	mutex         sync.Mutex
+	flushMutex    sync.Mutex
+	timer         *time.Timer
+}
+
+// EventExporterConfig contains configuration for the EventExporter
+type EventExporterConfig struct {
+	S3Client      *s3.Client
+	Bucket        string
+	Prefix        string        // defaults to events/
+	FlushInterval time.Duration // defaults to 15 minutes
+	MaxRows       int           // rows of one dataset that trigger an early flush; defaults to 100000
+	MaxBuffered   int           // rows kept while S3 is unreachable, oldest dropped first; defaults to 4 * MaxRows
+}
+
+// NewEventExporter creates a new EventExporter and starts flushing
+func NewEventExporter(config EventExporterConfig) *EventExporter {
+	if config.Prefix == "" {
+		config.Prefix = "events/"
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 15 * time.Minute
+	}
+	if config.MaxRows == 0 {
+		config.MaxRows = 100000
+	}
+	if config.MaxBuffered == 0 {
+		config.MaxBuffered = 4 * config.MaxRows
+	}
+
+	ee := &EventExporter{
+		s3Client:      config.S3Client,
+		bucket:        config.Bucket,
+		prefix:        strings.TrimSuffix(config.Prefix, "/") + "/",
+		flushInterval: config.FlushInterval,
+		maxRows:       config.MaxRows,
+		maxBuffered:   config.MaxBuffered,
+		pending:       make(map[string][]exportRow),
+	}
+
+	// Start the flush timer
+	ee.timer = time.AfterFunc(ee.flushInterval, ee.flushLoop)
+
+	return ee
+}
+
+// flushLoop writes the buffered rows and reschedules itself
+func (ee *EventExporter) flushLoop() {
+	defer func() {
+		// Reschedule the flush
+		ee.timer.Reset(ee.flushInterval)
+	}()
+
+	err := ee.Flush(context.Background())
+
+	// Log only when exports start failing or recover
+	ee.mutex.Lock()
+	defer ee.mutex.Unlock()
+	switch {
+	case err != nil && !ee.failing:
+		log.Printf("Failed to export events: %v", err)
+	case err == nil && ee.failing:
+		log.Printf("Event export recovered")
+	}
+	ee.failing = err != nil
+}
+
+// RecordRollout exports an audit record
+func (ee *EventExporter) RecordRollout(record AuditRecord) {
+	details := ""
+	if len(record.Details) > 0 {
+		if data, err := json.Marshal(record.Details); err == nil {
+			details = string(data)
+		}
+	}
+
+	ee.add(DatasetRolloutEvents, record.Timestamp, RolloutEventRow{
+		EventTime: record.Timestamp.UTC(),
+		TenantID:  record.Details["tenant"],
+		RolloutID: record.RolloutID,
+		Source:    "audit",
+		Action:    record.Action,
+		Actor:     record.Actor,
+		Version:   record.Details["version"],
+		Details:   details,
+	})
+}
+
+// RecordDevice exports a device event: update events go to rollout_events,
+// sync events to sync_events and the rest to device_events
+func (ee *EventExporter) RecordDevice(event DeviceEvent) {
+	tenantID, deviceID := tenant.Split(event.DeviceID)
+	if event.TenantID != "" {
+		tenantID = event.TenantID
+	}
+	at := event.Timestamp.UTC()
+
+	switch event.Type {
+	case EventUpdate:
+		ee.add(DatasetRolloutEvents, at, RolloutEventRow{
+			EventTime: at,
+			TenantID:  tenantID,
+			RolloutID: event.RolloutID,
+			DeviceID:  deviceID,
+			Source:    "device",
+			Action:    event.Status,
+			Version:   event.Version,
+			Message:   event.Message,
+		})
+	case EventSync:
+		ee.add(DatasetSyncEvents, at, SyncEventRow{
+			EventTime:   at,
+			TenantID:    tenantID,
+			DeviceID:    deviceID,
+			DeviceGroup: event.DeviceGroup,
+			Status:      event.Status,
+			Message:     event.Message,
+		})
+	default:
+		ee.add(DatasetDeviceEvents, at, DeviceEventRow{
+			EventTime:   at,
+			TenantID:    tenantID,
+			DeviceID:    deviceID,
+			DeviceGroup: event.DeviceGroup,
+			Type:        event.Type,
+			Version:     event.Version,
+			Status:      event.Status,
+			Healthy:     event.Healthy,
+			Message:     event.Message,
+		})
+	}
+}
+
+// RecordTelemetry exports the metrics a device reported at one time, one row per metric
+func (ee *EventExporter) RecordTelemetry(deviceKey string, at time.Time, metrics map[string]float64) {
+	tenantID, deviceID := tenant.Split(deviceKey)
+	at = at.UTC()
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		ee.add(DatasetTelemetry, at, TelemetryRow{
+			EventTime: at,
+			TenantID:  tenantID,
+			DeviceID:  deviceID,
+			Metric:    name,
+			Value:     metrics[name],
+		})
+	}
+}
+
+// Flush writes every buffered row now. Partitions that fail to upload are
+// buffered again for the next flush.
+func (ee *EventExporter) Flush(ctx context.Context) error {
+	ee.flushMutex.Lock()
+	defer ee.flushMutex.Unlock()
+
+	ee.mutex.Lock()
+	pending := ee.pending
+	ee.pending = make(map[string][]exportRow)
+	ee.buffered = 0
+	dropped := ee.dropped
+	ee.dropped = 0
+	ee.mutex.Unlock()
+
+	if dropped > 0 {
+		log.Printf("Dropped %d events while the export buffer was full", dropped)
+	}
+
+	now := time.Now().UTC()
+	failed := 0
+	var lastErr error
+	for dataset, rows := range pending {
+		partitions := make(map[time.Time][]exportRow)
+		for _, row := range rows {
+			hour := row.at.UTC().Truncate(time.Hour)
+			partitions[hour] = append(partitions[hour], row)
+		}
+
+		for hour, partition := range partitions {
+			if err := ee.upload(ctx, dataset, hour, partition, now); err != nil {
+				failed++
+				lastErr = err
+
+				ee.mutex.Lock()
+				for _, row := range partition {
+					ee.buffer(dataset, row)
+				}
+				ee.mutex.Unlock()
+			}
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d partitions not exported: %w", failed, lastErr)
+	}
+	return nil
+}
+
+// Close stops the exporter and writes what is still buffered
+func (ee *EventExporter) Close() error {
+	if ee.timer != nil {
+		ee.timer.Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	return ee.Flush(ctx)
+}
+
+// add buffers a row and flushes early once a dataset reaches MaxRows
+func (ee *EventExporter) add(dataset string, at time.Time, row interface{}) {
+	if ee == nil {
+		return
+	}
+
+	ee.mutex.Lock()
+	defer ee.mutex.Unlock()
+
+	ee.buffer(dataset, exportRow{at: at, row: row})
+
+	// An early flush while S3 is unreachable would only fail again
+	if len(ee.pending[dataset]) == ee.maxRows && !ee.failing && ee.timer != nil {
+		ee.timer.Reset(0)
+	}
+}
+
+// buffer appends a row with the mutex held, dropping the dataset's oldest
+// row when the buffer is full
+func (ee *EventExporter) buffer(dataset string, row exportRow) {
+	ee.pending[dataset] = append(ee.pending[dataset], row)
+	ee.buffered++
+	if ee.buffered > ee.maxBuffered {
+		ee.pending[dataset] = ee.pending[dataset][1:]
+		ee.buffered--
+		ee.dropped++
+	}
+}
+
+// upload writes one dataset's rows for an hour as a new Parquet file
+func (ee *EventExporter) upload(ctx context.Context, dataset string, hour time.Time, rows []exportRow, now time.Time) error {
+	sort.SliceStable(rows, func(i, j int) bool { return rows[i].at.Before(rows[j].at) })
+
+	var buf bytes.Buffer
+	w := parquet.NewWriter(&buf, parquet.SchemaOf(datasetModels[dataset]))
+	for _, row := range rows {
+		if err := w.Write(row.row); err != nil {
+			return fmt.Errorf("failed to encode %s row: %w", dataset, err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to encode %s: %w", dataset, err)
+	}
+
+	key := fmt.Sprintf("%s%s/date=%s/hour=%02d/%s-%s.parquet",
+		ee.prefix, dataset, hour.Format("2006-01-02"), hour.Hour(), now.Format("20060102T150405Z"), uuid.New().String())
+	_, err := ee.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(ee.bucket),
+		Key:    aws.String(key),
+		Body:   bytes.NewReader(buf.Bytes()),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	return nil
+}
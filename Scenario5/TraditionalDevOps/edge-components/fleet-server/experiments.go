@@ -0,0 +1,483 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/experiments"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// VariantResult is one variant's share of an experiment's telemetry
+type VariantResult struct {
+	Name        string             `json:"name"`
+	Devices     int                `json:"devices"`
+	Comparisons []MetricComparison `json:"comparisons"` // against the control; canary fields describe this variant
+}
+
+// ExperimentResults compares each variant of an experiment with its control
+type ExperimentResults struct {
+	ExperimentID string          `json:"experimentId"`
+	GeneratedAt  time.Time       `json:"generatedAt"`
+	Since        time.Time       `json:"since"`
+	Control      string          `json:"control"`
+	Variants     []VariantResult `json:"variants"`
+}
+
+// ExperimentService manages A/B experiments: it assigns devices to variants
+// with the rollout bucketing, delivers assignments through the sync channel
+// and compares variant-tagged telemetry
+type ExperimentService struct {
+	dynamoClient        *dynamodb.Client
+	experimentTableName string
+	deviceTableName     string
+	telemetryTableName  string
+	transport           offlineSync.SyncTransport
+	auditLog            *AuditLog
+	alpha               float64
+}
+
+// ExperimentServiceConfig contains configuration for the ExperimentService
+type ExperimentServiceConfig struct {
+	DynamoClient        *dynamodb.Client
+	ExperimentTableName string
+	DeviceTableName     string
+	TelemetryTableName  string
+	Transport           offlineSync.SyncTransport // the sync bucket devices read, normally offlineSync.NewS3Transport
+	AuditLog            *AuditLog
+	Alpha               float64 // significance level of variant comparisons; defaults to 0.05
+}
+
+// NewExperimentService creates a new ExperimentService
+func NewExperimentService(config ExperimentServiceConfig) *ExperimentService {
+	es := &ExperimentService{
+		dynamoClient:        config.DynamoClient,
+		experimentTableName: config.ExperimentTableName,
+		deviceTableName:     config.DeviceTableName,
+		telemetryTableName:  config.TelemetryTableName,
+		transport:           config.Transport,
+		auditLog:            config.AuditLog,
+		alpha:               config.Alpha,
+	}
+
+	if es.alpha == 0 {
+		es.alpha = 0.05
+	}
+
+	return es
+}
+
+// Create validates and stores a new draft experiment in the context's tenant
+func (es *ExperimentService) Create(ctx context.Context, experiment experiments.Experiment, createdBy string) (*experiments.Experiment, error) {
+	if err := experiment.Validate(); err != nil {
+		return nil, err
+	}
+
+	if experiment.ID == "" {
+		experiment.ID = uuid.New().String()
+	}
+	experiment.Status = experiments.StatusDraft
+	experiment.CreatedBy = createdBy
+	experiment.CreatedAt = time.Now().UTC()
+	experiment.StartedAt = nil
+	experiment.StoppedAt = nil
+	experiment.TenantID = tenant.FromContext(ctx)
+
+	item, err := attributevalue.MarshalMap(experiment)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal experiment: %w", err)
+	}
+
+	_, err = es.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(es.experimentTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, errors.New("experiment already exists: " + experiment.ID)
+		}
+		return nil, fmt.Errorf("failed to store experiment: %w", err)
+	}
+
+	es.audit(ctx, createdBy, "experiment.created", &experiment)
+
+	return &experiment, nil
+}
+
+// Get loads an experiment; experiments owned by another tenant are reported as not found
+func (es *ExperimentService) Get(ctx context.Context, experimentID string) (*experiments.Experiment, error) {
+	result, err := es.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(es.experimentTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: experimentID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get experiment: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, errors.New("experiment not found: " + experimentID)
+	}
+
+	var experiment experiments.Experiment
+	if err := attributevalue.UnmarshalMap(result.Item, &experiment); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal experiment: %w", err)
+	}
+
+	if !tenant.Allowed(tenant.FromContext(ctx), experiment.TenantID) {
+		return nil, errors.New("experiment not found: " + experimentID)
+	}
+
+	return &experiment, nil
+}
+
+// List returns the experiments visible to the context's tenant
+func (es *ExperimentService) List(ctx context.Context) ([]experiments.Experiment, error) {
+	list := make([]experiments.Experiment, 0)
+
+	paginator := dynamodb.NewScanPaginator(es.dynamoClient, &dynamodb.ScanInput{
+		TableName: aws.String(es.experimentTableName),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list experiments: %w", err)
+		}
+
+		var batch []experiments.Experiment
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal experiments: %w", err)
+		}
+		for _, experiment := range batch {
+			if tenant.Allowed(tenant.FromContext(ctx), experiment.TenantID) {
+				list = append(list, experiment)
+			}
+		}
+	}
+
+	return list, nil
+}
+
+// Start moves a draft experiment to running and delivers assignments to its tenant's devices
+func (es *ExperimentService) Start(ctx context.Context, experimentID, actor string) (*experiments.Experiment, error) {
+	return es.transition(ctx, experimentID, actor, experiments.StatusDraft, experiments.StatusRunning, "StartedAt")
+}
+
+// Stop ends a running experiment and withdraws its assignments; results stay available
+func (es *ExperimentService) Stop(ctx context.Context, experimentID, actor string) (*experiments.Experiment, error) {
+	return es.transition(ctx, experimentID, actor, experiments.StatusRunning, experiments.StatusStopped, "StoppedAt")
+}
+
+// Distribute delivers each device of a tenant its assignments in the running experiments
+func (es *ExperimentService) Distribute(ctx context.Context, tenantID string) error {
+	all, err := es.List(ctx)
+	if err != nil {
+		return err
+	}
+
+	running := make([]experiments.Experiment, 0)
+	for _, experiment := range all {
+		if experiment.Status == experiments.StatusRunning && experiment.TenantID == tenantID {
+			running = append(running, experiment)
+		}
+	}
+
+	devices, err := ScanDevices(ctx, es.dynamoClient, es.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	delivered, failed := 0, 0
+	for _, device := range devices {
+		if device.Tenant() != tenantID {
+			continue
+		}
+
+		// Every device gets a set, so stopped experiments are withdrawn too
+		set := experiments.AssignmentSet{
+			UpdatedAt:   time.Now().UTC(),
+			Assignments: make([]experiments.Assignment, 0),
+		}
+		_, deviceID := tenant.Split(device.DeviceID)
+		for _, experiment := range running {
+			if !inGroups(experiment.TargetGroups, device) {
+				continue
+			}
+			if assignment, ok := experiments.Assign(experiment, deviceID); ok {
+				set.Assignments = append(set.Assignments, assignment)
+			}
+		}
+
+		data, err := json.Marshal(set)
+		if err != nil {
+			return fmt.Errorf("failed to marshal experiment assignments: %w", err)
+		}
+
+		devicePrefix := fmt.Sprintf("%sdevices/%s/", tenant.S3Prefix(tenantID), deviceID)
+		if err := offlineSync.DeliverUpdate(ctx, es.transport, devicePrefix, experiments.AssignmentsFile, experiments.DataType, data); err != nil {
+			log.Printf("Failed to deliver experiment assignments to %s: %v", device.DeviceID, err)
+			failed++
+			continue
+		}
+		delivered++
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("experiment assignments not delivered to %d of %d devices", failed, delivered+failed)
+	}
+
+	return nil
+}
+
+// Results compares the telemetry each variant reported since the experiment
+// started. Only reports tagged with the experiment's variant count, so
+// telemetry from before a device received its assignment is excluded.
+func (es *ExperimentService) Results(ctx context.Context, experimentID string) (*ExperimentResults, error) {
+	experiment, err := es.Get(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	results := &ExperimentResults{
+		ExperimentID: experiment.ID,
+		GeneratedAt:  time.Now().UTC(),
+		Control:      experiment.Variants[0].Name,
+		Variants:     make([]VariantResult, 0, len(experiment.Variants)),
+	}
+	if experiment.StartedAt == nil {
+		return results, nil
+	}
+	results.Since = *experiment.StartedAt
+
+	devices, err := ScanDevices(ctx, es.dynamoClient, es.deviceTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	// samples[variant][metric] holds one mean per device
+	samples := make([]map[string][]float64, len(experiment.Variants))
+	counts := make([]int, len(experiment.Variants))
+	for i := range samples {
+		samples[i] = make(map[string][]float64)
+	}
+
+	tag := experiments.Metric(experiment.ID)
+	for _, device := range devices {
+		if device.Tenant() != experiment.TenantID || !inGroups(experiment.TargetGroups, device) {
+			continue
+		}
+
+		sums := make(map[int]map[string]float64)
+		reports := make(map[int]map[string]int)
+		err := forEachTelemetry(ctx, es.dynamoClient, es.telemetryTableName, device.DeviceID, results.Since, func(timestamp time.Time, metrics map[string]float64) {
+			value, ok := metrics[tag]
+			index := int(value)
+			if !ok || index < 0 || index >= len(experiment.Variants) {
+				return
+			}
+			if experiment.StoppedAt != nil && timestamp.After(*experiment.StoppedAt) {
+				return
+			}
+			if sums[index] == nil {
+				sums[index] = make(map[string]float64)
+				reports[index] = make(map[string]int)
+			}
+			for _, metric := range experiment.Metrics {
+				if v, ok := metrics[metric]; ok {
+					sums[index][metric] += v
+					reports[index][metric]++
+				}
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		for index, metricSums := range sums {
+			counts[index]++
+			for metric, sum := range metricSums {
+				samples[index][metric] = append(samples[index][metric], sum/float64(reports[index][metric]))
+			}
+		}
+	}
+
+	for i, variant := range experiment.Variants {
+		result := VariantResult{
+			Name:        variant.Name,
+			Devices:     counts[i],
+			Comparisons: make([]MetricComparison, 0),
+		}
+		if i > 0 {
+			for _, metric := range experiment.Metrics {
+				a, b := samples[i][metric], samples[0][metric]
+				if len(a) < 2 || len(b) < 2 {
+					continue
+				}
+				comparison := compareCohorts(metric, a, b)
+				comparison.Regression = comparison.PValue < es.alpha && comparison.Delta != 0
+				result.Comparisons = append(result.Comparisons, comparison)
+			}
+		}
+		results.Variants = append(results.Variants, result)
+	}
+
+	return results, nil
+}
+
+// transition moves an experiment between statuses, stamping the given time
+// attribute, and redistributes assignments for its tenant
+func (es *ExperimentService) transition(ctx context.Context, experimentID, actor, from, to, stamp string) (*experiments.Experiment, error) {
+	experiment, err := es.Get(ctx, experimentID)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	_, err = es.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(es.experimentTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: experimentID},
+		},
+		UpdateExpression:    aws.String(fmt.Sprintf("SET #status = :to, %s = :time", stamp)),
+		ConditionExpression: aws.String("#status = :from"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":to":   &types.AttributeValueMemberS{Value: to},
+			":from": &types.AttributeValueMemberS{Value: from},
+			":time": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		},
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, fmt.Errorf("experiment %s is %s, not %s", experimentID, experiment.Status, from)
+		}
+		return nil, fmt.Errorf("failed to update experiment: %w", err)
+	}
+
+	experiment.Status = to
+	if to == experiments.StatusRunning {
+		experiment.StartedAt = &now
+	} else {
+		experiment.StoppedAt = &now
+	}
+	es.audit(ctx, actor, "experiment."+to, experiment)
+
+	if err := es.Distribute(ctx, experiment.TenantID); err != nil {
+		return nil, err
+	}
+
+	return experiment, nil
+}
+
+// audit records an experiment event, logging rather than failing on audit errors
+func (es *ExperimentService) audit(ctx context.Context, actor, action string, experiment *experiments.Experiment) {
+	if es.auditLog == nil {
+		return
+	}
+
+	details := map[string]string{
+		"experimentId": experiment.ID,
+		"name":         experiment.Name,
+	}
+	if err := es.auditLog.Record(ctx, actor, action, "", details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// RegisterRoutes registers the experiment API on the server
+func (es *ExperimentService) RegisterRoutes(s *Server) {
+	s.Handle("POST /api/experiments", http.HandlerFunc(es.handleCreate))
+	s.Handle("GET /api/experiments", http.HandlerFunc(es.handleList))
+	s.Handle("GET /api/experiments/{id}", http.HandlerFunc(es.handleGet))
+	s.Handle("POST /api/experiments/{id}/start", http.HandlerFunc(es.handleTransition(es.Start)))
+	s.Handle("POST /api/experiments/{id}/stop", http.HandlerFunc(es.handleTransition(es.Stop)))
+	s.Handle("GET /api/experiments/{id}/results", http.HandlerFunc(es.handleResults))
+}
+
+// handleCreate creates a draft experiment
+func (es *ExperimentService) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var experiment experiments.Experiment
+	if err := json.NewDecoder(r.Body).Decode(&experiment); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	created, err := es.Create(r.Context(), experiment, actorFor(r.Context(), experiment.CreatedBy))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleList lists experiments
+func (es *ExperimentService) handleList(w http.ResponseWriter, r *http.Request) {
+	list, err := es.List(r.Context())
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, list)
+}
+
+// handleGet returns a single experiment
+func (es *ExperimentService) handleGet(w http.ResponseWriter, r *http.Request) {
+	experiment, err := es.Get(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, experiment)
+}
+
+// handleTransition returns a handler starting or stopping an experiment
+func (es *ExperimentService) handleTransition(transition func(ctx context.Context, experimentID, actor string) (*experiments.Experiment, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Actor string `json:"actor"`
+		}
+		// The body is optional
+		json.NewDecoder(r.Body).Decode(&body)
+
+		experiment, err := transition(r.Context(), r.PathValue("id"), actorFor(r.Context(), body.Actor))
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, experiment)
+	}
+}
+
+// handleResults compares an experiment's variants
+func (es *ExperimentService) handleResults(w http.ResponseWriter, r *http.Request) {
+	results, err := es.Results(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
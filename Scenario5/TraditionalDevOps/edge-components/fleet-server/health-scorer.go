@@ -0,0 +1,243 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Weights of the health score components; they sum to 100
+const (
+	healthCheckWeight   = 35.0
+	livenessWeight      = 15.0
+	telemetryWeight     = 30.0
+	updateHistoryWeight = 20.0
+)
+
+// HealthScore is a device's score and the components it was built from
+type HealthScore struct {
+	DeviceID      string    `json:"deviceId"`
+	Score         float64   `json:"score"`
+	HealthCheck   float64   `json:"healthCheck"`
+	Liveness      float64   `json:"liveness"`
+	Telemetry     float64   `json:"telemetry"`
+	UpdateHistory float64   `json:"updateHistory"`
+	ComputedAt    time.Time `json:"computedAt"`
+}
+
+// HealthScorer periodically scores every device from 0 to 100 and stores the
+// score in the device table, where rollouts with a MinHealthScore read it.
+// The score combines the last reported health check, how recently the device
+// was seen, telemetry against configured limits, and its update success rate.
+type HealthScorer struct {
+	dynamoClient       *dynamodb.Client
+	deviceTableName    string
+	telemetryTableName string
+	metricLimits       map[string]float64
+	telemetryWindow    time.Duration
+	staleAfter         time.Duration
+	interval           time.Duration
+	timer              *time.Timer
+}
+
+// HealthScorerConfig contains configuration for the HealthScorer
+type HealthScorerConfig struct {
+	DynamoClient       *dynamodb.Client
+	DeviceTableName    string
+	TelemetryTableName string
+	MetricLimits       map[string]float64 // metric -> highest healthy mean, e.g. cpu_usage_percent: 90
+	TelemetryWindow    time.Duration
+	StaleAfter         time.Duration // devices not seen for this long lose their liveness points
+	Interval           time.Duration
+}
+
+// NewHealthScorer creates a new HealthScorer and starts scoring
+func NewHealthScorer(config HealthScorerConfig) *HealthScorer {
+	hs := &HealthScorer{
+		dynamoClient:       config.DynamoClient,
+		deviceTableName:    config.DeviceTableName,
+		telemetryTableName: config.TelemetryTableName,
+		metricLimits:       config.MetricLimits,
+		telemetryWindow:    config.TelemetryWindow,
+		staleAfter:         config.StaleAfter,
+		interval:           config.Interval,
+	}
+
+	if hs.metricLimits == nil {
+		hs.metricLimits = map[string]float64{
+			"cpu_usage_percent":   90,
+			"memory_used_percent": 90,
+		}
+	}
+	if hs.telemetryWindow == 0 {
+		hs.telemetryWindow = time.Hour
+	}
+	if hs.staleAfter == 0 {
+		hs.staleAfter = 15 * time.Minute
+	}
+	if hs.interval == 0 {
+		hs.interval = 10 * time.Minute
+	}
+
+	// Start the scoring timer
+	hs.timer = time.AfterFunc(hs.interval, hs.scoreLoop)
+
+	return hs
+}
+
+// scoreLoop scores the fleet and reschedules itself
+func (hs *HealthScorer) scoreLoop() {
+	defer func() {
+		// Reschedule the scoring
+		hs.timer.Reset(hs.interval)
+	}()
+
+	if err := hs.ScoreAll(context.Background()); err != nil {
+		log.Printf("Failed to score device health: %v", err)
+	}
+}
+
+// ScoreAll scores every device and stores the results
+func (hs *HealthScorer) ScoreAll(ctx context.Context) error {
+	devices, err := ScanDevices(ctx, hs.dynamoClient, hs.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, device := range devices {
+		score, err := hs.Score(ctx, device)
+		if err != nil {
+			log.Printf("Failed to score %s: %v", device.DeviceID, err)
+			continue
+		}
+
+		if err := hs.store(ctx, score); err != nil {
+			log.Printf("Failed to store health score for %s: %v", device.DeviceID, err)
+		}
+	}
+
+	return nil
+}
+
+// Score computes a device's health score without storing it
+func (hs *HealthScorer) Score(ctx context.Context, device DeviceRecord) (*HealthScore, error) {
+	now := time.Now().UTC()
+	score := &HealthScore{DeviceID: device.DeviceID, ComputedAt: now}
+
+	// Health check: full marks when the last report was healthy, half when unknown
+	switch {
+	case device.Healthy == nil:
+		score.HealthCheck = healthCheckWeight / 2
+	case *device.Healthy:
+		score.HealthCheck = healthCheckWeight
+	}
+
+	// Liveness: decays linearly to zero over twice the stale threshold
+	if lastSeen, err := time.Parse(time.RFC3339, device.LastSeen); err == nil {
+		age := now.Sub(lastSeen)
+		switch {
+		case age <= hs.staleAfter:
+			score.Liveness = livenessWeight
+		case age < 2*hs.staleAfter:
+			score.Liveness = livenessWeight * float64(2*hs.staleAfter-age) / float64(hs.staleAfter)
+		}
+	}
+
+	// Telemetry: the share of limited metrics whose mean is within its limit
+	score.Telemetry = telemetryWeight / 2
+	if hs.telemetryTableName != "" && len(hs.metricLimits) > 0 {
+		sums, counts, err := queryTelemetry(ctx, hs.dynamoClient, hs.telemetryTableName, device.DeviceID, now.Add(-hs.telemetryWindow))
+		if err != nil {
+			return nil, err
+		}
+
+		reported, within := 0, 0
+		for metric, limit := range hs.metricLimits {
+			if counts[metric] == 0 {
+				continue
+			}
+			reported++
+			if sums[metric]/float64(counts[metric]) <= limit {
+				within++
+			}
+		}
+		if reported > 0 {
+			score.Telemetry = telemetryWeight * float64(within) / float64(reported)
+		}
+	}
+
+	// Update history: success rate, smoothed so one result does not dominate
+	succeeded, failed := float64(device.UpdatesSucceeded), float64(device.UpdatesFailed)
+	score.UpdateHistory = updateHistoryWeight * (succeeded + 1) / (succeeded + failed + 2)
+
+	total := score.HealthCheck + score.Liveness + score.Telemetry + score.UpdateHistory
+	score.Score = math.Round(total*10) / 10
+
+	return score, nil
+}
+
+// store writes a score to the device record
+func (hs *HealthScorer) store(ctx context.Context, score *HealthScore) error {
+	_, err := hs.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(hs.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: score.DeviceID},
+		},
+		UpdateExpression:    aws.String("SET HealthScore = :score, HealthScoreTime = :time"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":score": &types.AttributeValueMemberN{Value: strconv.FormatFloat(score.Score, 'f', 1, 64)},
+			":time":  &types.AttributeValueMemberS{Value: score.ComputedAt.Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+
+	return nil
+}
+
+// Close stops the scorer
+func (hs *HealthScorer) Close() {
+	if hs.timer != nil {
+		hs.timer.Stop()
+	}
+}
+
+// RegisterRoutes registers the health score API on the server
+func (hs *HealthScorer) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/devices/{id}/health", http.HandlerFunc(hs.handleScore))
+}
+
+// handleScore computes a device's score on demand, with its components
+func (hs *HealthScorer) handleScore(w http.ResponseWriter, r *http.Request) {
+	deviceID := tenant.Key(tenant.FromContext(r.Context()), r.PathValue("id"))
+
+	device, err := GetDevice(r.Context(), hs.dynamoClient, hs.deviceTableName, deviceID)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	if device == nil {
+		writeError(w, http.StatusNotFound, "device not found: "+r.PathValue("id"))
+		return
+	}
+
+	score, err := hs.Score(r.Context(), *device)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, score)
+}
@@ -0,0 +1,100 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/aws/aws-lambda-go/events"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// LambdaHandler runs a PhaseController as a short-lived AWS Lambda function
+// instead of a long-running process. Scheduled invocations, e.g. from an
+// EventBridge rule, evaluate every rollout; DynamoDB stream batches from the
+// rollout or device table evaluate only the rollouts whose records, or whose
+// devices' records, changed. Invocations are idempotent: rollout writes are
+// conditional on the revision they read, and a controller with a
+// notification table sends each notification once however often, and on
+// however many instances, the same change is evaluated.
+type LambdaHandler struct {
+	controller       *PhaseController
+	deviceTableName  string
+	rolloutTableName string
+}
+
+// NewLambdaHandler creates a LambdaHandler for a controller created without an
+// EvaluateInterval. Create both outside the handler function, so warm
+// invocations reuse the clients and the controller's record of sent
+// notifications; cold starts reload the latter from the notification table.
+func NewLambdaHandler(controller *PhaseController) *LambdaHandler {
+	return &LambdaHandler{
+		controller:       controller,
+		deviceTableName:  controller.deviceTableName,
+		rolloutTableName: controller.rolloutTableName,
+	}
+}
+
+// Handle processes one invocation; pass it to lambda.Start. An error fails
+// the invocation, so Lambda retries the schedule or the stream batch.
+func (lh *LambdaHandler) Handle(ctx context.Context, payload json.RawMessage) error {
+	var stream events.DynamoDBEvent
+	if err := json.Unmarshal(payload, &stream); err == nil && len(stream.Records) > 0 {
+		return lh.controller.EvaluateRollouts(ctx, lh.rolloutIDs(stream))
+	}
+
+	// Anything else, e.g. a scheduled event, evaluates every rollout
+	return lh.controller.Evaluate(ctx)
+}
+
+// rolloutIDs returns the rollouts affected by a stream batch: changed rollout
+// records, and the rollouts changed device records last updated to
+func (lh *LambdaHandler) rolloutIDs(stream events.DynamoDBEvent) []string {
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+
+	for _, record := range stream.Records {
+		var id string
+		switch streamTable(record.EventSourceArn) {
+		case lh.rolloutTableName:
+			if record.EventName == string(events.DynamoDBOperationTypeRemove) {
+				continue
+			}
+			id = streamString(record.Change.Keys, "ID")
+		case lh.deviceTableName:
+			id = streamString(record.Change.NewImage, "LastUpdateID")
+		}
+
+		if id == "" || rollout.IsDesiredState(id) || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// Helper functions
+
+// streamTable returns the table name in a DynamoDB stream ARN, e.g.
+// "arn:aws:dynamodb:eu-west-1:123456789012:table/edge-rollouts/stream/2024-01-01T00:00:00.000"
+func streamTable(streamARN string) string {
+	_, rest, found := strings.Cut(streamARN, ":table/")
+	if !found {
+		return ""
+	}
+	name, _, _ := strings.Cut(rest, "/")
+	return name
+}
+
+// streamString returns a string attribute of a stream image, or "" when it
+// is missing or not a string
+func streamString(image map[string]events.DynamoDBAttributeValue, name string) string {
+	value, ok := image[name]
+	if !ok || value.DataType() != events.DataTypeString {
+		return ""
+	}
+	return value.String()
+}
@@ -0,0 +1,225 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ExpiresAtAttribute is the TTL attribute of the rollout, device, usage,
+// telemetry and notification tables: a Unix time after which DynamoDB deletes
+// the item. Enable TTL on it for each table.
+const ExpiresAtAttribute = "ExpiresAt"
+
+// finishedStatuses are the rollout statuses nothing moves a rollout out of
+var finishedStatuses = map[string]bool{
+	"completed":   true,
+	"failed":      true,
+	"rolled-back": true,
+	"aborted":     true,
+}
+
+// LifecycleManager sets the ExpiresAt TTL attribute on stale records so the
+// rollout and device tables don't grow forever: finished rollouts expire
+// RolloutRetention after they finished, and devices DeviceRetention after
+// they were last seen or last reported a status, which is how decommissioned
+// devices leave the fleet. Devices that keep reporting keep being extended.
+type LifecycleManager struct {
+	dynamoClient     *dynamodb.Client
+	rolloutTableName string
+	deviceTableName  string
+	rolloutRetention time.Duration
+	deviceRetention  time.Duration
+	interval         time.Duration
+	sweepMutex       sync.Mutex
+	timer            *time.Timer
+}
+
+// LifecycleManagerConfig contains configuration for the LifecycleManager
+type LifecycleManagerConfig struct {
+	DynamoClient     *dynamodb.Client
+	RolloutTableName string
+	DeviceTableName  string
+	RolloutRetention time.Duration // defaults to 90 days
+	DeviceRetention  time.Duration // defaults to 180 days; match the agents' WithDeviceRetention
+	Interval         time.Duration // defaults to 1 hour
+}
+
+// NewLifecycleManager creates a new LifecycleManager and starts sweeping
+func NewLifecycleManager(config LifecycleManagerConfig) (*LifecycleManager, error) {
+	if config.RolloutRetention == 0 {
+		config.RolloutRetention = 90 * 24 * time.Hour
+	}
+	if config.DeviceRetention == 0 {
+		config.DeviceRetention = 180 * 24 * time.Hour
+	}
+	if config.Interval == 0 {
+		config.Interval = time.Hour
+	}
+
+	// A device must be swept at least twice within its retention, or a
+	// device that came back could be deleted before it is extended
+	if config.DeviceRetention < 2*config.Interval {
+		return nil, fmt.Errorf("device retention %s must be at least twice the sweep interval %s", config.DeviceRetention, config.Interval)
+	}
+
+	lm := &LifecycleManager{
+		dynamoClient:     config.DynamoClient,
+		rolloutTableName: config.RolloutTableName,
+		deviceTableName:  config.DeviceTableName,
+		rolloutRetention: config.RolloutRetention,
+		deviceRetention:  config.DeviceRetention,
+		interval:         config.Interval,
+	}
+
+	// Start the sweep timer
+	lm.timer = time.AfterFunc(lm.interval, lm.sweepLoop)
+
+	return lm, nil
+}
+
+// sweepLoop sweeps the tables and reschedules itself
+func (lm *LifecycleManager) sweepLoop() {
+	defer func() {
+		// Reschedule the sweep
+		lm.timer.Reset(lm.interval)
+	}()
+
+	if err := lm.Sweep(context.Background(), time.Now()); err != nil {
+		log.Printf("Failed to sweep stale records: %v", err)
+	}
+}
+
+// Sweep sets or extends the expiry of every rollout and device record once
+func (lm *LifecycleManager) Sweep(ctx context.Context, now time.Time) error {
+	lm.sweepMutex.Lock()
+	defer lm.sweepMutex.Unlock()
+
+	rollouts, err := ScanRollouts(ctx, lm.dynamoClient, lm.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	expiring := 0
+	for _, plan := range rollouts {
+		if !finishedStatuses[plan.Status] || plan.ExpiresAt != 0 {
+			continue
+		}
+
+		finishedAt := plan.UpdatedAt
+		if finishedAt.IsZero() {
+			finishedAt = now
+		}
+		if err := lm.expireRollout(ctx, plan.ID, plan.Revision, finishedAt.Add(lm.rolloutRetention)); err != nil {
+			log.Printf("Failed to set expiry of rollout %s: %v", plan.ID, err)
+			continue
+		}
+		expiring++
+	}
+
+	devices, err := ScanDevices(ctx, lm.dynamoClient, lm.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	extended := 0
+	for _, device := range devices {
+		lastActive, ok := lastActivity(device)
+		if !ok {
+			continue
+		}
+
+		// Only write when the expiry is unset or half used up, so devices
+		// that check in every minute aren't written every sweep
+		expiresAt := lastActive.Add(lm.deviceRetention).Unix()
+		if device.ExpiresAt != 0 && (device.ExpiresAt >= expiresAt || device.ExpiresAt-now.Unix() > int64(lm.deviceRetention.Seconds()/2)) {
+			continue
+		}
+
+		if err := lm.expireDevice(ctx, device.DeviceID, expiresAt); err != nil {
+			log.Printf("Failed to set expiry of device %s: %v", device.DeviceID, err)
+			continue
+		}
+		extended++
+	}
+
+	if expiring+extended > 0 {
+		log.Printf("Set the expiry of %d finished rollouts and %d devices", expiring, extended)
+	}
+
+	return nil
+}
+
+// Close stops the lifecycle manager
+func (lm *LifecycleManager) Close() {
+	if lm.timer != nil {
+		lm.timer.Stop()
+	}
+}
+
+// expireRollout sets a finished rollout's expiry, unless it changed since revision
+func (lm *LifecycleManager) expireRollout(ctx context.Context, rolloutID string, revision int64, expiresAt time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(lm.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression: aws.String("SET ExpiresAt = :expiresAt"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt.Unix(), 10)},
+		},
+	}
+	requireRevision(input, revision)
+
+	_, err := lm.dynamoClient.UpdateItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		// The next sweep sees the change
+		return nil
+	}
+
+	return err
+}
+
+// expireDevice sets a device's expiry, never shortening one set meanwhile,
+// e.g. by the device's own status report
+func (lm *LifecycleManager) expireDevice(ctx context.Context, deviceID string, expiresAt int64) error {
+	_, err := lm.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(lm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression:    aws.String("SET ExpiresAt = :expiresAt"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID) AND (attribute_not_exists(ExpiresAt) OR ExpiresAt < :expiresAt)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":expiresAt": &types.AttributeValueMemberN{Value: strconv.FormatInt(expiresAt, 10)},
+		},
+	})
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+
+	return err
+}
+
+// Helper functions
+
+// lastActivity returns when a device last checked in or reported a status
+func lastActivity(device DeviceRecord) (time.Time, bool) {
+	var latest time.Time
+	for _, value := range []string{device.LastSeen, device.LastUpdateTime} {
+		if t, err := time.Parse(time.RFC3339, value); err == nil && t.After(latest) {
+			latest = t
+		}
+	}
+	return latest, !latest.IsZero()
+}
@@ -0,0 +1,479 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/notify"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+const (
+	// failureRateThreshold is the phase threshold key for the maximum tolerated device failure rate
+	failureRateThreshold = "failure_rate"
+
+	// minSampleThreshold is the phase threshold key for the number of attempted updates
+	// required before the failure rate is evaluated
+	minSampleThreshold = "min_sample_size"
+
+	// notificationRetention is how long the notification table remembers a
+	// sent notification
+	notificationRetention = 30 * 24 * time.Hour
+)
+
+// PhaseController watches in-progress rollouts, notifies on failures and
+// automatically rolls back rollouts whose phase breaches its failure threshold
+// or whose canary cohort regresses against the control cohort; rollouts whose
+// phase metrics turn anomalous are paused or rolled back, and phases that
+// outlive their duration are handled as the plan's PhaseExpiry says, unless
+// a StepFunctionsController orchestrates the rollout. It keeps no state it
+// can't reload, so it also runs as a short-lived Lambda function; see
+// LambdaHandler.
+type PhaseController struct {
+	dynamoClient          *dynamodb.Client
+	deviceTableName       string
+	rolloutTableName      string
+	notificationTableName string
+	notifier              notify.Notifier
+	analyzer              *CanaryAnalyzer
+	detector              *AnomalyDetector
+	notified              map[string]bool
+	controllerMutex       sync.Mutex
+	evaluateInterval      time.Duration
+	evaluateTimer         *time.Timer
+}
+
+// PhaseControllerConfig contains configuration for the PhaseController
+type PhaseControllerConfig struct {
+	DynamoClient          *dynamodb.Client
+	DeviceTableName       string
+	RolloutTableName      string
+	NotificationTableName string // remembers sent notifications across restarts and instances; optional
+	Notifier              notify.Notifier
+	Analyzer              *CanaryAnalyzer
+	Detector              *AnomalyDetector
+	EvaluateInterval      time.Duration // zero leaves calling Evaluate to the caller, e.g. a LambdaHandler
+}
+
+// NewPhaseController creates a new PhaseController
+func NewPhaseController(config PhaseControllerConfig) *PhaseController {
+	pc := &PhaseController{
+		dynamoClient:          config.DynamoClient,
+		deviceTableName:       config.DeviceTableName,
+		rolloutTableName:      config.RolloutTableName,
+		notificationTableName: config.NotificationTableName,
+		notifier:              config.Notifier,
+		analyzer:              config.Analyzer,
+		detector:              config.Detector,
+		notified:              make(map[string]bool),
+		evaluateInterval:      config.EvaluateInterval,
+	}
+
+	// Start the evaluation timer
+	if pc.evaluateInterval > 0 {
+		pc.evaluateTimer = time.AfterFunc(pc.evaluateInterval, pc.evaluateLoop)
+	}
+
+	return pc
+}
+
+// evaluateLoop evaluates rollouts and reschedules itself
+func (pc *PhaseController) evaluateLoop() {
+	defer func() {
+		// Reschedule the evaluation
+		pc.evaluateTimer.Reset(pc.evaluateInterval)
+	}()
+
+	if err := pc.Evaluate(context.Background()); err != nil {
+		log.Printf("Failed to evaluate rollouts: %v", err)
+	}
+}
+
+// Evaluate checks every in-progress rollout once
+func (pc *PhaseController) Evaluate(ctx context.Context) error {
+	rollouts, err := ScanRollouts(ctx, pc.dynamoClient, pc.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	return pc.evaluate(ctx, rollouts)
+}
+
+// EvaluateRollouts checks the given rollouts once, e.g. those a DynamoDB
+// stream reported changes for. Rollouts that can't be read are skipped; the
+// next full Evaluate picks them up.
+func (pc *PhaseController) EvaluateRollouts(ctx context.Context, rolloutIDs []string) error {
+	rollouts := make([]rollout.RolloutPlan, 0, len(rolloutIDs))
+	for _, id := range rolloutIDs {
+		plan, err := GetRollout(ctx, pc.dynamoClient, pc.rolloutTableName, id)
+		if err != nil {
+			log.Printf("Skipping evaluation of rollout %s: %v", id, err)
+			continue
+		}
+		rollouts = append(rollouts, *plan)
+	}
+
+	if len(rollouts) == 0 {
+		return nil
+	}
+
+	return pc.evaluate(ctx, rollouts)
+}
+
+// evaluate checks the in-progress rollouts among rollouts
+func (pc *PhaseController) evaluate(ctx context.Context, rollouts []rollout.RolloutPlan) error {
+	pc.controllerMutex.Lock()
+	defer pc.controllerMutex.Unlock()
+
+	devices, err := ScanDevices(ctx, pc.dynamoClient, pc.deviceTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range rollouts {
+		if plan.Status != "in-progress" {
+			continue
+		}
+
+		if err := pc.evaluateRollout(ctx, plan, devices); err != nil {
+			log.Printf("Failed to evaluate rollout %s: %v", plan.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// evaluateRollout notifies new device failures and enforces the phase failure threshold
+func (pc *PhaseController) evaluateRollout(ctx context.Context, plan rollout.RolloutPlan, devices []DeviceRecord) error {
+	for _, device := range devices {
+		if device.LastUpdateID != plan.ID || device.UpdateStatus != "failed" {
+			continue
+		}
+
+		pc.notifyOnce(ctx, plan.ID+"/"+device.DeviceID, notify.Event{
+			Type:      notify.EventDeviceUpdateFailed,
+			Severity:  notify.SeverityWarning,
+			RolloutID: plan.ID,
+			DeviceID:  device.DeviceID,
+			Message:   valueOr(device.LastUpdateMessage, "update failed"),
+			Details: map[string]string{
+				"group":  device.DeviceGroup,
+				"region": device.Region,
+			},
+		})
+	}
+
+	if plan.CurrentPhase >= len(plan.Phases) {
+		return nil
+	}
+
+	phase := plan.Phases[plan.CurrentPhase]
+
+	breached, err := pc.checkFailureRate(ctx, plan, phase, devices)
+	if err != nil || breached {
+		return err
+	}
+
+	rolledBack, err := pc.checkCanary(ctx, plan, devices)
+	if err != nil || rolledBack {
+		return err
+	}
+
+	acted, err := pc.checkAnomalies(ctx, plan, devices)
+	if err != nil || acted {
+		return err
+	}
+
+	// A Step Functions execution decides when an orchestrated rollout's phases end
+	if plan.ExecutionARN != "" {
+		return nil
+	}
+
+	return pc.checkExpiry(ctx, plan, devices)
+}
+
+// checkFailureRate rolls back the rollout if the phase failure rate exceeds its
+// threshold, fleet-wide or within a group whose override sets thresholds
+func (pc *PhaseController) checkFailureRate(ctx context.Context, plan rollout.RolloutPlan, phase rollout.RolloutPhase, devices []DeviceRecord) (bool, error) {
+	breached, err := pc.checkGroupFailureRate(ctx, plan, phase, "", devices)
+	if err != nil || breached {
+		return breached, err
+	}
+
+	groups := make([]string, 0, len(phase.GroupOverrides))
+	for group, override := range phase.GroupOverrides {
+		if len(override.Thresholds) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	sort.Strings(groups)
+
+	for _, group := range groups {
+		var members []DeviceRecord
+		for _, device := range devices {
+			if device.DeviceGroup == group {
+				members = append(members, device)
+			}
+		}
+
+		breached, err := pc.checkGroupFailureRate(ctx, plan, phase.ForGroup(group), group, members)
+		if err != nil || breached {
+			return breached, err
+		}
+	}
+
+	return false, nil
+}
+
+// checkGroupFailureRate applies the phase failure threshold to devices, all
+// of them or one group's
+func (pc *PhaseController) checkGroupFailureRate(ctx context.Context, plan rollout.RolloutPlan, phase rollout.RolloutPhase, group string, devices []DeviceRecord) (bool, error) {
+	maxRate, ok := phase.Thresholds[failureRateThreshold]
+	if !ok {
+		return false, nil
+	}
+
+	progress := rolloutProgress(plan, devices)
+	attempted := progress.DevicesSucceeded + progress.DevicesFailed
+	if attempted == 0 || float64(attempted) < phase.Thresholds[minSampleThreshold] {
+		return false, nil
+	}
+
+	failureRate := float64(progress.DevicesFailed) / float64(attempted)
+	if failureRate <= maxRate {
+		return false, nil
+	}
+
+	details := map[string]string{
+		"failureRate": fmt.Sprintf("%.4f", failureRate),
+		"threshold":   fmt.Sprintf("%.4f", maxRate),
+		"failed":      fmt.Sprintf("%d", progress.DevicesFailed),
+		"attempted":   fmt.Sprintf("%d", attempted),
+	}
+	message := fmt.Sprintf("failure rate %.1f%% exceeds threshold %.1f%%", 100*failureRate, 100*maxRate)
+	if group != "" {
+		details["group"] = group
+		message = fmt.Sprintf("failure rate %.1f%% in group %s exceeds threshold %.1f%%", 100*failureRate, group, 100*maxRate)
+	}
+
+	pc.notifyOnce(ctx, plan.ID+"/breach/"+phase.ID+"/"+group, notify.Event{
+		Type:      notify.EventPhaseThresholdBreached,
+		Severity:  notify.SeverityCritical,
+		RolloutID: plan.ID,
+		PhaseID:   phase.ID,
+		Message:   message,
+		Details:   details,
+	})
+
+	return true, pc.rollBack(ctx, plan, phase, details)
+}
+
+// checkCanary records the canary verdict for the current phase and rolls back on a regression
+func (pc *PhaseController) checkCanary(ctx context.Context, plan rollout.RolloutPlan, devices []DeviceRecord) (bool, error) {
+	if pc.analyzer == nil {
+		return false, nil
+	}
+
+	analysis, err := pc.analyzer.Analyze(ctx, plan, devices)
+	if err != nil {
+		return false, err
+	}
+
+	if analysis.PhaseID == "" {
+		return false, nil
+	}
+
+	phase := plan.Phases[plan.CurrentPhase]
+	if phase.CanaryVerdict != analysis.Verdict {
+		if err := setCanaryVerdict(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.CurrentPhase, analysis.Verdict); err != nil {
+			log.Printf("Failed to record canary verdict for rollout %s: %v", plan.ID, err)
+		}
+	}
+
+	if analysis.Verdict != CanaryFail {
+		return false, nil
+	}
+
+	details := map[string]string{
+		"reason": analysis.Reason,
+	}
+	for _, comparison := range analysis.Comparisons {
+		if comparison.Regression {
+			details[comparison.Metric] = fmt.Sprintf("%+.1f%% (p=%.4f)", 100*comparison.RelativeDelta, comparison.PValue)
+		}
+	}
+	if analysis.GateMet != nil && !*analysis.GateMet {
+		details["gate"] = analysis.Gate
+		for term, value := range analysis.GateValues {
+			details[term] = fmt.Sprintf("%g", value)
+		}
+	}
+
+	pc.notifyOnce(ctx, plan.ID+"/canary/"+phase.ID, notify.Event{
+		Type:      notify.EventPhaseThresholdBreached,
+		Severity:  notify.SeverityCritical,
+		RolloutID: plan.ID,
+		PhaseID:   phase.ID,
+		Message:   "canary cohort regressed against control: " + analysis.Reason,
+		Details:   details,
+	})
+
+	return true, pc.rollBack(ctx, plan, phase, details)
+}
+
+// checkAnomalies pauses or rolls back the rollout when phase metrics deviate from their baseline
+func (pc *PhaseController) checkAnomalies(ctx context.Context, plan rollout.RolloutPlan, devices []DeviceRecord) (bool, error) {
+	if pc.detector == nil {
+		return false, nil
+	}
+
+	report, err := pc.detector.Detect(ctx, plan, devices)
+	if err != nil {
+		return false, err
+	}
+
+	if !report.Anomalous {
+		return false, nil
+	}
+
+	phase := plan.Phases[plan.CurrentPhase]
+	details := anomalyDetails(report)
+
+	pc.notifyOnce(ctx, plan.ID+"/anomaly/"+phase.ID, notify.Event{
+		Type:      notify.EventPhaseThresholdBreached,
+		Severity:  notify.SeverityCritical,
+		RolloutID: plan.ID,
+		PhaseID:   phase.ID,
+		Message:   "phase metrics deviate from their baseline: " + report.Reason,
+		Details:   details,
+	})
+
+	if pc.detector.Action(phase) == AnomalyActionRollback {
+		return true, pc.rollBack(ctx, plan, phase, details)
+	}
+
+	return true, pc.pause(ctx, plan, phase, details)
+}
+
+// rollBack marks the rollout rolled back and notifies
+func (pc *PhaseController) rollBack(ctx context.Context, plan rollout.RolloutPlan, phase rollout.RolloutPhase, details map[string]string) error {
+	if err := updateRolloutStatus(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.Revision, "rolled-back"); err != nil {
+		return err
+	}
+
+	pc.notifyOnce(ctx, plan.ID+"/rolled-back", notify.Event{
+		Type:      notify.EventRolloutRolledBack,
+		Severity:  notify.SeverityCritical,
+		RolloutID: plan.ID,
+		PhaseID:   phase.ID,
+		Message:   fmt.Sprintf("rollout of version %s automatically rolled back", plan.Version),
+		Details:   details,
+	})
+
+	return nil
+}
+
+// pause stops offering the rollout to devices until it is resumed, and notifies
+func (pc *PhaseController) pause(ctx context.Context, plan rollout.RolloutPlan, phase rollout.RolloutPhase, details map[string]string) error {
+	if err := updateRolloutStatus(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.Revision, "paused"); err != nil {
+		return err
+	}
+
+	pc.notifyOnce(ctx, plan.ID+"/paused/"+phase.ID, notify.Event{
+		Type:      notify.EventRolloutPaused,
+		Severity:  notify.SeverityCritical,
+		RolloutID: plan.ID,
+		PhaseID:   phase.ID,
+		Message:   fmt.Sprintf("rollout of version %s automatically paused", plan.Version),
+		Details:   details,
+	})
+
+	return nil
+}
+
+// notifyOnce delivers an event the first time its key is seen; with a
+// notification table, the first time any controller instance sees it
+func (pc *PhaseController) notifyOnce(ctx context.Context, key string, event notify.Event) {
+	if pc.notifier == nil || pc.notified[key] {
+		return
+	}
+
+	if pc.notificationTableName != "" {
+		claimed, err := pc.claimNotification(ctx, key)
+		if err != nil {
+			log.Printf("Failed to claim %s notification: %v", event.Type, err)
+			return
+		}
+		if !claimed {
+			pc.notified[key] = true
+			return
+		}
+	}
+
+	event.Timestamp = time.Now().UTC()
+	if err := pc.notifier.Notify(ctx, event); err != nil {
+		log.Printf("Failed to send %s notification: %v", event.Type, err)
+		pc.releaseNotification(ctx, key)
+		return
+	}
+
+	pc.notified[key] = true
+}
+
+// claimNotification records key in the notification table and reports
+// whether this call recorded it, i.e. nobody has sent the notification yet
+func (pc *PhaseController) claimNotification(ctx context.Context, key string) (bool, error) {
+	now := time.Now().UTC()
+	_, err := pc.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(pc.notificationTableName),
+		Item: map[string]types.AttributeValue{
+			"ID":               &types.AttributeValueMemberS{Value: key},
+			"NotifiedAt":       &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			ExpiresAtAttribute: &types.AttributeValueMemberN{Value: fmt.Sprint(now.Add(notificationRetention).Unix())},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to record notification: %w", err)
+	}
+
+	return true, nil
+}
+
+// releaseNotification forgets a claimed notification that couldn't be sent,
+// so the next evaluation tries again
+func (pc *PhaseController) releaseNotification(ctx context.Context, key string) {
+	if pc.notificationTableName == "" {
+		return
+	}
+
+	_, err := pc.dynamoClient.DeleteItem(ctx, &dynamodb.DeleteItemInput{
+		TableName: aws.String(pc.notificationTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: key},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to release notification %s: %v", key, err)
+	}
+}
+
+// Close stops the phase controller
+func (pc *PhaseController) Close() {
+	if pc.evaluateTimer != nil {
+		pc.evaluateTimer.Stop()
+	}
+}
@@ -0,0 +1,226 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/notify"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// checkExpiry records when the current phase started and, once the phase
+// outlives its duration, holds, advances or fails the rollout as its
+// PhaseExpiry says
+func (pc *PhaseController) checkExpiry(ctx context.Context, plan rollout.RolloutPlan, devices []DeviceRecord) error {
+	index := plan.CurrentPhase
+	phase := plan.Phases[index]
+	now := time.Now().UTC()
+
+	if phase.StartTime.IsZero() {
+		return setPhaseStart(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.Revision, index, now)
+	}
+
+	expiresAt, ok := phase.ExpiresAt()
+	if !ok || now.Before(expiresAt) {
+		return nil
+	}
+
+	action := plan.ExpiryAction()
+	details := map[string]string{
+		"duration":  phase.Duration,
+		"expiredAt": expiresAt.Format(time.RFC3339),
+		"action":    action,
+	}
+
+	switch action {
+	case rollout.PhaseExpiryAdvance:
+		return pc.advancePhase(ctx, plan, now, details)
+
+	case rollout.PhaseExpiryFail:
+		selected, updated := phaseCoverage(plan, devices)
+		if updated == selected {
+			return pc.advancePhase(ctx, plan, now, details)
+		}
+
+		details["selected"] = fmt.Sprintf("%d", selected)
+		details["updated"] = fmt.Sprintf("%d", updated)
+		if err := updateRolloutStatus(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.Revision, "failed"); err != nil {
+			return err
+		}
+
+		pc.notifyOnce(ctx, plan.ID+"/expired/"+phase.ID, notify.Event{
+			Type:      notify.EventPhaseExpired,
+			Severity:  notify.SeverityCritical,
+			RolloutID: plan.ID,
+			PhaseID:   phase.ID,
+			Message:   fmt.Sprintf("phase expired with %d of %d selected devices updated; rollout failed", updated, selected),
+			Details:   details,
+		})
+		return nil
+
+	default:
+		if index+1 == len(plan.Phases) || plan.Phases[index+1].Approved {
+			return pc.advancePhase(ctx, plan, now, details)
+		}
+
+		next := plan.Phases[index+1]
+		pc.notifyOnce(ctx, plan.ID+"/expired/"+phase.ID, notify.Event{
+			Type:      notify.EventPhaseExpired,
+			Severity:  notify.SeverityWarning,
+			RolloutID: plan.ID,
+			PhaseID:   phase.ID,
+			Message:   fmt.Sprintf("phase expired; approve phase %s to continue", next.ID),
+			Details:   details,
+		})
+		return nil
+	}
+}
+
+// advancePhase moves the rollout to its next phase, or completes it after the
+// final phase, and notifies
+func (pc *PhaseController) advancePhase(ctx context.Context, plan rollout.RolloutPlan, now time.Time, details map[string]string) error {
+	phase := plan.Phases[plan.CurrentPhase]
+
+	if plan.CurrentPhase+1 == len(plan.Phases) {
+		if err := updateRolloutStatus(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.Revision, "completed"); err != nil {
+			return err
+		}
+
+		pc.notifyOnce(ctx, plan.ID+"/expired/"+phase.ID, notify.Event{
+			Type:      notify.EventPhaseExpired,
+			Severity:  notify.SeverityInfo,
+			RolloutID: plan.ID,
+			PhaseID:   phase.ID,
+			Message:   fmt.Sprintf("final phase expired; rollout of version %s completed", plan.Version),
+			Details:   details,
+		})
+		pc.notifyOnce(ctx, plan.ID+"/completed", notify.Event{
+			Type:      notify.EventRolloutCompleted,
+			Severity:  notify.SeverityInfo,
+			RolloutID: plan.ID,
+			PhaseID:   phase.ID,
+			Message:   fmt.Sprintf("rollout of version %s completed", plan.Version),
+			Details:   map[string]string{"version": plan.Version, "tenant": plan.TenantID},
+		})
+		return nil
+	}
+
+	if err := setCurrentPhase(ctx, pc.dynamoClient, pc.rolloutTableName, plan.ID, plan.Revision, plan.CurrentPhase, now); err != nil {
+		return err
+	}
+
+	next := plan.Phases[plan.CurrentPhase+1]
+	pc.notifyOnce(ctx, plan.ID+"/expired/"+phase.ID, notify.Event{
+		Type:      notify.EventPhaseExpired,
+		Severity:  notify.SeverityInfo,
+		RolloutID: plan.ID,
+		PhaseID:   phase.ID,
+		Message:   fmt.Sprintf("phase expired; advanced to phase %s (%.0f%%)", next.ID, next.Percentage),
+		Details:   details,
+	})
+	pc.notifyOnce(ctx, plan.ID+"/advanced/"+next.ID, notify.Event{
+		Type:      notify.EventPhaseAdvanced,
+		Severity:  notify.SeverityInfo,
+		RolloutID: plan.ID,
+		PhaseID:   next.ID,
+		Message:   fmt.Sprintf("advanced from phase %s to phase %s (%.0f%%)", phase.ID, next.ID, next.Percentage),
+		Details: map[string]string{
+			"version":       plan.Version,
+			"previousPhase": phase.ID,
+			"percentage":    fmt.Sprintf("%.0f", next.Percentage),
+			"tenant":        plan.TenantID,
+		},
+	})
+
+	return nil
+}
+
+// phaseCoverage counts the targeted devices the current phase selects and how
+// many of them run the rollout's version
+func phaseCoverage(plan rollout.RolloutPlan, devices []DeviceRecord) (int, int) {
+	selected, updated := 0, 0
+	for _, device := range devices {
+		_, deviceID := tenant.Split(device.DeviceID)
+		if !targetsDevice(plan, device) || !inCurrentPhase(plan, deviceID, device) {
+			continue
+		}
+
+		selected++
+		if device.VersionFor(plan) == plan.Version {
+			updated++
+		}
+	}
+
+	return selected, updated
+}
+
+// setPhaseStart records when a phase started, unless the rollout has moved on
+// or changed since revision
+func setPhaseStart(ctx context.Context, client *dynamodb.Client, tableName, rolloutID string, revision int64, phaseIndex int, now time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:    aws.String(fmt.Sprintf("SET Phases[%d].StartTime = :start", phaseIndex)),
+		ConditionExpression: aws.String("CurrentPhase = :phase"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":start": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":phase": &types.AttributeValueMemberN{Value: fmt.Sprint(phaseIndex)},
+		},
+	}
+	requireRevision(input, revision)
+
+	_, err := client.UpdateItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to record start of phase %d of rollout %s: %w", phaseIndex, rolloutID, err)
+	}
+
+	return nil
+}
+
+// setCurrentPhase moves an in-progress rollout from phase to the next one and
+// starts its clock; a rollout that changed since revision is left alone
+func setCurrentPhase(ctx context.Context, client *dynamodb.Client, tableName, rolloutID string, revision int64, phase int, now time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:    aws.String(fmt.Sprintf("SET CurrentPhase = :next, Phases[%d].StartTime = :start, UpdatedAt = :time", phase+1)),
+		ConditionExpression: aws.String("#status = :inProgress AND CurrentPhase = :phase"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":next":       &types.AttributeValueMemberN{Value: fmt.Sprint(phase + 1)},
+			":phase":      &types.AttributeValueMemberN{Value: fmt.Sprint(phase)},
+			":inProgress": &types.AttributeValueMemberS{Value: "in-progress"},
+			":start":      &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+			":time":       &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+		},
+	}
+	requireRevision(input, revision)
+
+	_, err := client.UpdateItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to advance rollout %s past phase %d: %w", rolloutID, phase, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// PollAdvisor periodically stamps a suggested check interval on every device
+// record: short while an in-progress rollout or a desired state is waiting
+// for the device, long while nothing is. Devices honor the suggestion over
+// their configured intervals, so the fleet polls quickly only where a
+// rollout is moving and the rollout table isn't read for idle devices.
+//
+// Each pass reads only the in-progress rollouts and the device groups they
+// target, through the rollout StatusIndex and the device GroupIndex, plus one
+// page of a sweep through the device table. The sweep covers what the indexes
+// can't find, such as rollouts targeting "all" or dynamic groups and desired
+// state, and resumes where it left off on the next pass.
+type PollAdvisor struct {
+	dynamoClient     *dynamodb.Client
+	deviceTableName  string
+	rolloutTableName string
+	activeInterval   time.Duration
+	idleInterval     time.Duration
+	interval         time.Duration
+	sweepSize        int32
+	timer            *time.Timer
+
+	// cohorts holds the target groups of the rollouts in progress at the
+	// last pass, so a finished rollout's devices are returned to idle
+	cohorts     map[string][]string
+	sweepKey    map[string]types.AttributeValue // nil starts a new sweep
+	adviseMutex sync.Mutex
+}
+
+// PollAdvisorConfig contains configuration for the PollAdvisor
+type PollAdvisorConfig struct {
+	DynamoClient     *dynamodb.Client
+	DeviceTableName  string
+	RolloutTableName string
+	ActiveInterval   time.Duration // defaults to 30s; a rollout's PollInterval replaces it for its devices
+	IdleInterval     time.Duration // defaults to 4 hours
+	Interval         time.Duration // how often suggestions are recomputed; defaults to a minute
+	SweepSize        int           // devices the sweep reads per pass; defaults to 1000
+}
+
+// NewPollAdvisor creates a new PollAdvisor and starts advising
+func NewPollAdvisor(config PollAdvisorConfig) *PollAdvisor {
+	pa := &PollAdvisor{
+		dynamoClient:     config.DynamoClient,
+		deviceTableName:  config.DeviceTableName,
+		rolloutTableName: config.RolloutTableName,
+		activeInterval:   config.ActiveInterval,
+		idleInterval:     config.IdleInterval,
+		interval:         config.Interval,
+		sweepSize:        int32(config.SweepSize),
+		cohorts:          make(map[string][]string),
+	}
+
+	if pa.activeInterval == 0 {
+		pa.activeInterval = 30 * time.Second
+	}
+	if pa.idleInterval == 0 {
+		pa.idleInterval = 4 * time.Hour
+	}
+	if pa.interval == 0 {
+		pa.interval = time.Minute
+	}
+	if pa.sweepSize <= 0 {
+		pa.sweepSize = 1000
+	}
+
+	// Start the advising timer
+	pa.timer = time.AfterFunc(pa.interval, pa.adviseLoop)
+
+	return pa
+}
+
+// Stop stops advising; suggestions already stamped stay in place
+func (pa *PollAdvisor) Stop() {
+	pa.timer.Stop()
+}
+
+// adviseLoop updates the suggestions and reschedules itself
+func (pa *PollAdvisor) adviseLoop() {
+	defer func() {
+		// Reschedule the advising
+		pa.timer.Reset(pa.interval)
+	}()
+
+	if err := pa.AdviseAll(context.Background()); err != nil {
+		log.Printf("Failed to advise poll intervals: %v", err)
+	}
+}
+
+// AdviseAll recomputes the suggested interval of the devices in the cohorts
+// of in-progress and just finished rollouts, and of the next page of the
+// sweep, writing the ones that changed
+func (pa *PollAdvisor) AdviseAll(ctx context.Context) error {
+	pa.adviseMutex.Lock()
+	defer pa.adviseMutex.Unlock()
+
+	active, err := pa.activeRollouts(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Cohorts of rollouts in progress now or at the last pass
+	cohorts := make(map[string][]string, len(active))
+	for _, plan := range active {
+		cohorts[plan.ID] = plan.TargetGroups
+	}
+	groups := make(map[string]bool)
+	for _, targets := range []map[string][]string{pa.cohorts, cohorts} {
+		for _, targetGroups := range targets {
+			for _, group := range targetGroups {
+				// The sweep reaches every device of "all"
+				if group != "all" {
+					groups[group] = true
+				}
+			}
+		}
+	}
+
+	devices := make(map[string]DeviceRecord)
+	for group := range groups {
+		members, err := pa.groupDevices(ctx, group)
+		if err != nil {
+			return err
+		}
+		for _, device := range members {
+			devices[device.DeviceID] = device
+		}
+	}
+	pa.cohorts = cohorts
+
+	swept, err := pa.sweep(ctx)
+	if err != nil {
+		return err
+	}
+	for _, device := range swept {
+		devices[device.DeviceID] = device
+	}
+
+	now := time.Now()
+	changed := 0
+	for _, device := range devices {
+		if device.Expired(now) {
+			continue
+		}
+
+		suggested := pa.Suggest(device, active).String()
+		if suggested == device.PollInterval {
+			continue
+		}
+
+		if err := pa.store(ctx, device.DeviceID, suggested); err != nil {
+			log.Printf("Failed to store poll interval for %s: %v", device.DeviceID, err)
+			continue
+		}
+		changed++
+	}
+
+	if changed > 0 {
+		log.Printf("Updated the suggested poll interval of %d devices", changed)
+	}
+	return nil
+}
+
+// Suggest returns the interval a device should poll at given the in-progress
+// rollouts: the shortest among the rollouts still waiting for it, or the
+// idle interval when none is
+func (pa *PollAdvisor) Suggest(device DeviceRecord, active []rollout.RolloutPlan) time.Duration {
+	if device.Desired != nil && device.Shadow().State == ShadowReconciling {
+		return pa.activeInterval
+	}
+
+	suggested := time.Duration(0)
+	for _, plan := range active {
+		if !targetsDevice(plan, device) || !awaitsDevice(plan, device) {
+			continue
+		}
+
+		interval := pa.activeInterval
+		if plan.PollInterval != "" {
+			if planInterval, err := time.ParseDuration(plan.PollInterval); err == nil && planInterval > 0 {
+				interval = planInterval
+			}
+		}
+		if suggested == 0 || interval < suggested {
+			suggested = interval
+		}
+	}
+
+	if suggested == 0 {
+		return pa.idleInterval
+	}
+	return suggested
+}
+
+// activeRollouts queries the rollout table's StatusIndex for in-progress rollouts
+func (pa *PollAdvisor) activeRollouts(ctx context.Context) ([]rollout.RolloutPlan, error) {
+	paginator := dynamodb.NewQueryPaginator(pa.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(pa.rolloutTableName),
+		IndexName:              aws.String("StatusIndex"),
+		KeyConditionExpression: aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: "in-progress"},
+		},
+	})
+
+	now := time.Now()
+	plans := make([]rollout.RolloutPlan, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query active rollouts: %w", err)
+		}
+
+		var batch []rollout.RolloutPlan
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rollouts: %w", err)
+		}
+		for _, plan := range batch {
+			if !plan.Expired(now) {
+				plans = append(plans, plan)
+			}
+		}
+	}
+
+	return plans, nil
+}
+
+// groupDevices queries the device table's GroupIndex for the devices in a
+// group; dynamic group members aren't indexed and are left to the sweep
+func (pa *PollAdvisor) groupDevices(ctx context.Context, group string) ([]DeviceRecord, error) {
+	paginator := dynamodb.NewQueryPaginator(pa.dynamoClient, &dynamodb.QueryInput{
+		TableName:              aws.String(pa.deviceTableName),
+		IndexName:              aws.String(GroupIndex),
+		KeyConditionExpression: aws.String("DeviceGroup = :group"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":group": &types.AttributeValueMemberS{Value: group},
+		},
+	})
+
+	devices := make([]DeviceRecord, 0)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query devices in group %s: %w", group, err)
+		}
+
+		var batch []DeviceRecord
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal devices: %w", err)
+		}
+		devices = append(devices, batch...)
+	}
+
+	return devices, nil
+}
+
+// sweep reads the next page of the device table, starting over after the last
+func (pa *PollAdvisor) sweep(ctx context.Context) ([]DeviceRecord, error) {
+	result, err := pa.dynamoClient.Scan(ctx, &dynamodb.ScanInput{
+		TableName:         aws.String(pa.deviceTableName),
+		Limit:             aws.Int32(pa.sweepSize),
+		ExclusiveStartKey: pa.sweepKey,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan device table: %w", err)
+	}
+
+	var devices []DeviceRecord
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &devices); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal devices: %w", err)
+	}
+	pa.sweepKey = result.LastEvaluatedKey
+
+	return devices, nil
+}
+
+// store writes a suggested interval to the device record
+func (pa *PollAdvisor) store(ctx context.Context, deviceID, pollInterval string) error {
+	_, err := pa.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(pa.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression:    aws.String("SET PollInterval = :interval"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":interval": &types.AttributeValueMemberS{Value: pollInterval},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to update device: %w", err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// awaitsDevice reports whether a targeted device has yet to reach the
+// rollout's version or report a final failure for it
+func awaitsDevice(plan rollout.RolloutPlan, device DeviceRecord) bool {
+	if device.VersionFor(plan) == plan.Version {
+		return false
+	}
+	if device.LastUpdateID != plan.ID {
+		return true
+	}
+
+	switch device.UpdateStatus {
+	case "failed", "rolled-back", "aborted":
+		return false
+	}
+	return true
+}
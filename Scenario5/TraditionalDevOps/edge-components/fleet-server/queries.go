@@ -0,0 +1,193 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Device table global secondary indexes used by the query layer
+const (
+	// VersionIndex is keyed on CurrentVersion
+	VersionIndex = "VersionIndex"
+
+	// UpdateIndex is keyed on LastUpdateID with UpdateStatus as the sort key
+	UpdateIndex = "UpdateIndex"
+
+	// GroupIndex is keyed on DeviceGroup
+	GroupIndex = "GroupIndex"
+)
+
+// GroupSkew describes how many versions a device group is spread across
+type GroupSkew struct {
+	Group         string         `json:"group"`
+	TotalDevices  int            `json:"totalDevices"`
+	Versions      map[string]int `json:"versions"`
+	LatestVersion string         `json:"latestVersion"`
+	BehindLatest  int            `json:"behindLatest"`
+	VersionCount  int            `json:"versionCount"`
+}
+
+// FleetQuery answers targeted fleet questions from the device table's
+// secondary indexes instead of full table scans
+type FleetQuery struct {
+	dynamoClient    *dynamodb.Client
+	deviceTableName string
+}
+
+// NewFleetQuery creates a new FleetQuery
+func NewFleetQuery(dynamoClient *dynamodb.Client, deviceTableName string) *FleetQuery {
+	return &FleetQuery{
+		dynamoClient:    dynamoClient,
+		deviceTableName: deviceTableName,
+	}
+}
+
+// DevicesOnVersion returns the devices currently running a version
+func (fq *FleetQuery) DevicesOnVersion(ctx context.Context, version string) ([]DeviceRecord, error) {
+	return fq.query(ctx, &dynamodb.QueryInput{
+		IndexName:              aws.String(VersionIndex),
+		KeyConditionExpression: aws.String("CurrentVersion = :version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberS{Value: version},
+		},
+	})
+}
+
+// FailedDevices returns the devices whose last update for a rollout failed
+func (fq *FleetQuery) FailedDevices(ctx context.Context, rolloutID string) ([]DeviceRecord, error) {
+	return fq.query(ctx, &dynamodb.QueryInput{
+		IndexName:              aws.String(UpdateIndex),
+		KeyConditionExpression: aws.String("LastUpdateID = :rolloutID AND UpdateStatus = :failed"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rolloutID": &types.AttributeValueMemberS{Value: rolloutID},
+			":failed":    &types.AttributeValueMemberS{Value: "failed"},
+		},
+	})
+}
+
+// GroupSkew returns the version distribution of a device group
+func (fq *FleetQuery) GroupSkew(ctx context.Context, group string) (*GroupSkew, error) {
+	devices, err := fq.query(ctx, &dynamodb.QueryInput{
+		IndexName:              aws.String(GroupIndex),
+		KeyConditionExpression: aws.String("DeviceGroup = :group"),
+		ProjectionExpression:   aws.String("DeviceID, CurrentVersion"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":group": &types.AttributeValueMemberS{Value: group},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	skew := &GroupSkew{
+		Group:        group,
+		TotalDevices: len(devices),
+		Versions:     make(map[string]int),
+	}
+
+	for _, device := range devices {
+		version := valueOr(device.CurrentVersion, "unknown")
+		skew.Versions[version]++
+	}
+
+	versions := make([]string, 0, len(skew.Versions))
+	for version := range skew.Versions {
+		if version != "unknown" {
+			versions = append(versions, version)
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		return compareVersions(versions[i], versions[j]) < 0
+	})
+
+	skew.VersionCount = len(skew.Versions)
+	if len(versions) > 0 {
+		skew.LatestVersion = versions[len(versions)-1]
+		skew.BehindLatest = skew.TotalDevices - skew.Versions[skew.LatestVersion]
+	}
+
+	return skew, nil
+}
+
+// query runs a paginated index query against the device table, limited to
+// the request's tenant when there is one
+func (fq *FleetQuery) query(ctx context.Context, input *dynamodb.QueryInput) ([]DeviceRecord, error) {
+	input.TableName = aws.String(fq.deviceTableName)
+
+	if tenantID := tenant.FromContext(ctx); tenantID != "" {
+		input.FilterExpression = aws.String("begins_with(DeviceID, :tenantPrefix)")
+		input.ExpressionAttributeValues[":tenantPrefix"] = &types.AttributeValueMemberS{Value: tenant.Key(tenantID, "")}
+	}
+	devices := make([]DeviceRecord, 0)
+
+	paginator := dynamodb.NewQueryPaginator(fq.dynamoClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query %s: %w", aws.ToString(input.IndexName), err)
+		}
+
+		var batch []DeviceRecord
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal devices: %w", err)
+		}
+		devices = append(devices, batch...)
+	}
+
+	return devices, nil
+}
+
+// RegisterRoutes registers the query API on the server
+func (fq *FleetQuery) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/devices", http.HandlerFunc(fq.handleDevices))
+	s.Handle("GET /api/rollouts/{id}/failed-devices", http.HandlerFunc(fq.handleFailedDevices))
+	s.Handle("GET /api/groups/{group}/skew", http.HandlerFunc(fq.handleGroupSkew))
+}
+
+// handleDevices lists devices on the version given by the version query parameter
+func (fq *FleetQuery) handleDevices(w http.ResponseWriter, r *http.Request) {
+	version := r.URL.Query().Get("version")
+	if version == "" {
+		writeError(w, http.StatusBadRequest, "version is required")
+		return
+	}
+
+	devices, err := fq.DevicesOnVersion(r.Context(), version)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// handleFailedDevices lists devices that failed a rollout
+func (fq *FleetQuery) handleFailedDevices(w http.ResponseWriter, r *http.Request) {
+	devices, err := fq.FailedDevices(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, devices)
+}
+
+// handleGroupSkew returns the version skew of a device group
+func (fq *FleetQuery) handleGroupSkew(w http.ResponseWriter, r *http.Request) {
+	skew, err := fq.GroupSkew(r.Context(), r.PathValue("group"))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, skew)
+}
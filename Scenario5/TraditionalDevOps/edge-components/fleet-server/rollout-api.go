@@ -0,0 +1,843 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/google/uuid"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/gate"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/notify"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// RolloutService creates, updates, pauses and aborts rollouts through the fleet API,
+// directly or from templates
+type RolloutService struct {
+	dynamoClient     *dynamodb.Client
+	rolloutTableName string
+	deviceTableName  string
+	auditLog         *AuditLog
+	templates        map[string]rollout.RolloutTemplate
+	signer           publisher.Signer
+	notifier         notify.Notifier
+}
+
+// RolloutServiceConfig contains configuration for the RolloutService
+type RolloutServiceConfig struct {
+	DynamoClient     *dynamodb.Client
+	RolloutTableName string
+	DeviceTableName  string // needed to confirm device updates
+	AuditLog         *AuditLog
+	Templates        []rollout.RolloutTemplate // added to, or replacing, the built-in templates
+	Signer           publisher.Signer          // signs stored plans for devices that verify them, e.g. keymanager.KMSSigner
+	Notifier         notify.Notifier           // receives rollout.created and rollout.aborted events, e.g. an EventBridgeNotifier
+}
+
+// NewRolloutService creates a new RolloutService
+func NewRolloutService(config RolloutServiceConfig) *RolloutService {
+	rs := &RolloutService{
+		dynamoClient:     config.DynamoClient,
+		rolloutTableName: config.RolloutTableName,
+		deviceTableName:  config.DeviceTableName,
+		auditLog:         config.AuditLog,
+		templates:        rollout.BuiltinTemplates(),
+		signer:           config.Signer,
+		notifier:         config.Notifier,
+	}
+
+	for _, template := range config.Templates {
+		rs.templates[template.Name] = template
+	}
+
+	return rs
+}
+
+// Create validates and stores a new pending rollout in the context's tenant
+func (rs *RolloutService) Create(ctx context.Context, plan rollout.RolloutPlan, createdBy string) (*rollout.RolloutPlan, error) {
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if plan.ID == "" {
+		plan.ID = uuid.New().String()
+	}
+	plan.Status = "pending"
+	plan.CurrentPhase = 0
+	plan.CreatedAt = now
+	plan.UpdatedAt = now
+	plan.CreatedBy = createdBy
+	plan.TenantID = tenant.FromContext(ctx)
+	plan.Revision = 1
+	plan.ExpiresAt = 0
+	plan.ExecutionARN = ""
+
+	if err := rs.sign(&plan); err != nil {
+		return nil, err
+	}
+
+	item, err := attributevalue.MarshalMap(plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rollout: %w", err)
+	}
+
+	_, err = rs.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(rs.rolloutTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(ID)"),
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, errors.New("rollout already exists: " + plan.ID)
+		}
+		return nil, fmt.Errorf("failed to store rollout: %w", err)
+	}
+
+	rs.audit(ctx, createdBy, "rollout.created", plan.ID, map[string]string{"version": plan.Version})
+	rs.notify(ctx, notify.Event{
+		Type:      notify.EventRolloutCreated,
+		Severity:  notify.SeverityInfo,
+		RolloutID: plan.ID,
+		Message:   fmt.Sprintf("rollout of version %s created", plan.Version),
+		Details: map[string]string{
+			"version":   plan.Version,
+			"createdBy": createdBy,
+			"tenant":    plan.TenantID,
+		},
+	})
+
+	return &plan, nil
+}
+
+// CreateFromTemplate instantiates a named template with params and creates the rollout
+func (rs *RolloutService) CreateFromTemplate(ctx context.Context, templateName, rolloutID string, params map[string]string, createdBy string) (*rollout.RolloutPlan, error) {
+	template, ok := rs.templates[templateName]
+	if !ok {
+		return nil, errors.New("unknown template: " + templateName)
+	}
+
+	plan, err := template.Instantiate(params)
+	if err != nil {
+		return nil, err
+	}
+	plan.ID = rolloutID
+
+	created, err := rs.Create(ctx, plan, createdBy)
+	if err != nil {
+		return nil, err
+	}
+
+	rs.audit(ctx, createdBy, "rollout.templated", created.ID, map[string]string{"template": templateName})
+
+	return created, nil
+}
+
+// Templates returns the available rollout templates sorted by name
+func (rs *RolloutService) Templates() []rollout.RolloutTemplate {
+	templates := make([]rollout.RolloutTemplate, 0, len(rs.templates))
+	for _, template := range rs.templates {
+		templates = append(templates, template)
+	}
+	sort.Slice(templates, func(i, j int) bool { return templates[i].Name < templates[j].Name })
+	return templates
+}
+
+// Update replaces the definition of a pending, in-progress or paused rollout. Phases
+// that have already started cannot change, and expectedUpdatedAt and
+// expectedRevision, when set, reject the update if the rollout changed after
+// the caller last read it.
+func (rs *RolloutService) Update(ctx context.Context, plan rollout.RolloutPlan, expectedUpdatedAt time.Time, expectedRevision int64, actor string) (*rollout.RolloutPlan, error) {
+	if err := validatePlan(plan); err != nil {
+		return nil, err
+	}
+
+	current, err := GetRollout(ctx, rs.dynamoClient, rs.rolloutTableName, plan.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.Status != "pending" && current.Status != "in-progress" && current.Status != "paused" {
+		return nil, fmt.Errorf("rollout %s is %s and cannot be changed", plan.ID, current.Status)
+	}
+	if !expectedUpdatedAt.IsZero() && !current.UpdatedAt.Equal(expectedUpdatedAt) {
+		return nil, fmt.Errorf("rollout %s changed since it was planned; plan again", plan.ID)
+	}
+	if err := checkRevision(current, expectedRevision); err != nil {
+		return nil, err
+	}
+
+	if current.Status != "pending" {
+		if plan.Version != current.Version {
+			return nil, errors.New("version cannot change once a rollout is in progress")
+		}
+		if plan.ConfigPayload != current.ConfigPayload {
+			return nil, errors.New("configPayload cannot change once a rollout is in progress")
+		}
+		if len(plan.Phases) <= current.CurrentPhase {
+			return nil, errors.New("phases that have started cannot be removed")
+		}
+		for i := 0; i <= current.CurrentPhase && i < len(current.Phases); i++ {
+			if !samePhase(plan.Phases[i], current.Phases[i]) {
+				return nil, fmt.Errorf("phase %s has already started and cannot change", current.Phases[i].ID)
+			}
+		}
+	}
+
+	// Keep the server-owned state; only the definition is replaced
+	plan.Status = current.Status
+	plan.CurrentPhase = current.CurrentPhase
+	plan.CreatedAt = current.CreatedAt
+	plan.CreatedBy = current.CreatedBy
+	plan.TenantID = current.TenantID
+	plan.UpdatedAt = time.Now().UTC()
+	plan.Revision = current.Revision + 1
+	plan.ExpiresAt = current.ExpiresAt
+	plan.ExecutionARN = current.ExecutionARN
+	for i := range plan.Phases {
+		if i < len(current.Phases) && plan.Phases[i].ID == current.Phases[i].ID {
+			plan.Phases[i].StartTime = current.Phases[i].StartTime
+			plan.Phases[i].Approved = current.Phases[i].Approved
+			plan.Phases[i].ApprovedBy = current.Phases[i].ApprovedBy
+			plan.Phases[i].ApprovedAt = current.Phases[i].ApprovedAt
+			plan.Phases[i].CanaryVerdict = current.Phases[i].CanaryVerdict
+			plan.Phases[i].ResumedAt = current.Phases[i].ResumedAt
+		}
+	}
+
+	if err := rs.sign(&plan); err != nil {
+		return nil, err
+	}
+
+	item, err := attributevalue.MarshalMap(plan)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal rollout: %w", err)
+	}
+
+	// Guard against anyone, e.g. the phase controller advancing the rollout,
+	// writing it mid-update
+	revisionCondition := "Revision = :revision"
+	values := map[string]types.AttributeValue{
+		":status": &types.AttributeValueMemberS{Value: current.Status},
+		":phase":  &types.AttributeValueMemberN{Value: fmt.Sprint(current.CurrentPhase)},
+	}
+	if current.Revision == 0 {
+		revisionCondition = "attribute_not_exists(Revision)"
+	} else {
+		values[":revision"] = &types.AttributeValueMemberN{Value: fmt.Sprint(current.Revision)}
+	}
+
+	_, err = rs.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(rs.rolloutTableName),
+		Item:                item,
+		ConditionExpression: aws.String("#status = :status AND CurrentPhase = :phase AND " + revisionCondition),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: values,
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, fmt.Errorf("rollout %s changed since it was planned; plan again: %w", plan.ID, ErrRolloutChanged)
+		}
+		return nil, fmt.Errorf("failed to update rollout: %w", err)
+	}
+
+	rs.audit(ctx, actor, "rollout.updated", plan.ID, map[string]string{"version": plan.Version})
+
+	return &plan, nil
+}
+
+// Abort stops a pending, in-progress or paused rollout. Devices that finished
+// the update keep it; devices still applying it undo it and report aborted.
+func (rs *RolloutService) Abort(ctx context.Context, rolloutID string, expectedRevision int64, actor, reason string) (*rollout.RolloutPlan, error) {
+	plan, err := GetRollout(ctx, rs.dynamoClient, rs.rolloutTableName, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRevision(plan, expectedRevision); err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(rs.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:    aws.String("SET #status = :aborted, UpdatedAt = :time"),
+		ConditionExpression: aws.String("#status IN (:pending, :inProgress, :paused)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":aborted":    &types.AttributeValueMemberS{Value: "aborted"},
+			":pending":    &types.AttributeValueMemberS{Value: "pending"},
+			":inProgress": &types.AttributeValueMemberS{Value: "in-progress"},
+			":paused":     &types.AttributeValueMemberS{Value: "paused"},
+			":time":       &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	requireRevision(input, plan.Revision)
+
+	_, err = rs.dynamoClient.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, transitionConflict(plan, "aborted", "pending", "in-progress", "paused")
+		}
+		return nil, fmt.Errorf("failed to abort rollout: %w", err)
+	}
+
+	plan.Status = "aborted"
+	plan.Revision++
+	rs.audit(ctx, actor, "rollout.aborted", rolloutID, map[string]string{"reason": reason})
+	rs.notify(ctx, notify.Event{
+		Type:      notify.EventRolloutAborted,
+		Severity:  notify.SeverityWarning,
+		RolloutID: rolloutID,
+		Message:   fmt.Sprintf("rollout of version %s aborted by %s", plan.Version, actor),
+		Details: map[string]string{
+			"version": plan.Version,
+			"reason":  reason,
+			"tenant":  plan.TenantID,
+		},
+	})
+
+	return plan, nil
+}
+
+// Pause stops offering an in-progress rollout to devices; devices that already
+// updated keep the new version
+func (rs *RolloutService) Pause(ctx context.Context, rolloutID string, expectedRevision int64, actor, reason string) (*rollout.RolloutPlan, error) {
+	plan, err := GetRollout(ctx, rs.dynamoClient, rs.rolloutTableName, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRevision(plan, expectedRevision); err != nil {
+		return nil, err
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(rs.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:    aws.String("SET #status = :paused, UpdatedAt = :time"),
+		ConditionExpression: aws.String("#status = :inProgress"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":paused":     &types.AttributeValueMemberS{Value: "paused"},
+			":inProgress": &types.AttributeValueMemberS{Value: "in-progress"},
+			":time":       &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	requireRevision(input, plan.Revision)
+
+	_, err = rs.dynamoClient.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, transitionConflict(plan, "paused", "in-progress")
+		}
+		return nil, fmt.Errorf("failed to pause rollout: %w", err)
+	}
+
+	plan.Status = "paused"
+	plan.Revision++
+	rs.audit(ctx, actor, "rollout.paused", rolloutID, map[string]string{"reason": reason})
+
+	return plan, nil
+}
+
+// Resume continues a paused rollout. Resuming acknowledges the anomalies that
+// paused it: anomaly detection only considers devices updated afterwards.
+func (rs *RolloutService) Resume(ctx context.Context, rolloutID string, expectedRevision int64, actor, reason string) (*rollout.RolloutPlan, error) {
+	plan, err := GetRollout(ctx, rs.dynamoClient, rs.rolloutTableName, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkRevision(plan, expectedRevision); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	update := "SET #status = :inProgress, UpdatedAt = :time"
+	if plan.CurrentPhase < len(plan.Phases) {
+		update += fmt.Sprintf(", Phases[%d].ResumedAt = :resumedAt", plan.CurrentPhase)
+	}
+
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(rs.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:    aws.String(update),
+		ConditionExpression: aws.String("#status = :paused AND CurrentPhase = :phase"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":paused":     &types.AttributeValueMemberS{Value: "paused"},
+			":inProgress": &types.AttributeValueMemberS{Value: "in-progress"},
+			":phase":      &types.AttributeValueMemberN{Value: fmt.Sprint(plan.CurrentPhase)},
+			":time":       &types.AttributeValueMemberS{Value: now.Format(time.RFC3339)},
+			":resumedAt":  &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		},
+	}
+	requireRevision(input, plan.Revision)
+
+	_, err = rs.dynamoClient.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return nil, transitionConflict(plan, "resumed", "paused")
+		}
+		return nil, fmt.Errorf("failed to resume rollout: %w", err)
+	}
+
+	plan.Status = "in-progress"
+	plan.Revision++
+	if plan.CurrentPhase < len(plan.Phases) {
+		plan.Phases[plan.CurrentPhase].ResumedAt = now
+	}
+	rs.audit(ctx, actor, "rollout.resumed", rolloutID, map[string]string{"reason": reason})
+
+	return plan, nil
+}
+
+// Confirm keeps a device's update for a rollout that sets confirmWithin; the
+// device picks up the confirmation on its next check. Only an update awaiting
+// confirmation can be confirmed.
+func (rs *RolloutService) Confirm(ctx context.Context, rolloutID, deviceID, actor string) error {
+	_, err := rs.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(rs.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(tenant.FromContext(ctx), deviceID)},
+		},
+		UpdateExpression:    aws.String("SET ConfirmedUpdateID = :rolloutID"),
+		ConditionExpression: aws.String("LastUpdateID = :rolloutID AND UpdateStatus = :awaiting"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":rolloutID": &types.AttributeValueMemberS{Value: rolloutID},
+			":awaiting":  &types.AttributeValueMemberS{Value: "awaiting-confirmation"},
+		},
+	})
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return fmt.Errorf("device %s has no update for rollout %s awaiting confirmation", deviceID, rolloutID)
+		}
+		return fmt.Errorf("failed to confirm update: %w", err)
+	}
+
+	rs.audit(ctx, actor, "rollout.confirmed", rolloutID, map[string]string{"device": deviceID})
+
+	return nil
+}
+
+// sign sets the plan signature when a signer is configured
+func (rs *RolloutService) sign(plan *rollout.RolloutPlan) error {
+	plan.Signature, plan.SigningKeyID = "", ""
+	if rs.signer == nil {
+		return nil
+	}
+
+	signature, err := rs.signer.Sign(plan.SigningPayload())
+	if err != nil {
+		return fmt.Errorf("failed to sign rollout: %w", err)
+	}
+	plan.Signature = base64.StdEncoding.EncodeToString(signature)
+	plan.SigningKeyID = rs.signer.KeyID()
+
+	return nil
+}
+
+// audit records a rollout event, logging rather than failing on audit errors
+func (rs *RolloutService) audit(ctx context.Context, actor, action, rolloutID string, details map[string]string) {
+	if rs.auditLog == nil {
+		return
+	}
+
+	if err := rs.auditLog.Record(ctx, actor, action, rolloutID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// notify delivers a rollout event, logging rather than failing on delivery errors
+func (rs *RolloutService) notify(ctx context.Context, event notify.Event) {
+	if rs.notifier == nil {
+		return
+	}
+
+	event.Timestamp = time.Now().UTC()
+	if err := rs.notifier.Notify(ctx, event); err != nil {
+		log.Printf("Failed to send %s notification: %v", event.Type, err)
+	}
+}
+
+// RegisterRoutes registers the rollout API on the server
+func (rs *RolloutService) RegisterRoutes(s *Server) {
+	s.Handle("POST /api/rollouts", http.HandlerFunc(rs.handleCreate))
+	s.Handle("GET /api/rollouts/{id}", http.HandlerFunc(rs.handleGet))
+	s.Handle("PUT /api/rollouts/{id}", http.HandlerFunc(rs.handleUpdate))
+	s.Handle("POST /api/rollouts/{id}/abort", http.HandlerFunc(rs.handleAbort))
+	s.Handle("POST /api/rollouts/{id}/pause", http.HandlerFunc(rs.handleTransition(rs.Pause)))
+	s.Handle("POST /api/rollouts/{id}/resume", http.HandlerFunc(rs.handleTransition(rs.Resume)))
+	s.Handle("POST /api/rollouts/{id}/devices/{device}/confirm", http.HandlerFunc(rs.handleConfirm))
+	s.Handle("GET /api/templates", http.HandlerFunc(rs.handleTemplates))
+	s.Handle("POST /api/templates/{name}/rollouts", http.HandlerFunc(rs.handleCreateFromTemplate))
+}
+
+// handleCreate creates a rollout from a plan in the request body
+func (rs *RolloutService) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var plan rollout.RolloutPlan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	created, err := rs.Create(r.Context(), plan, actorFor(r.Context(), plan.CreatedBy))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleCreateFromTemplate creates a rollout from a template and parameters
+func (rs *RolloutService) handleCreateFromTemplate(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		ID         string            `json:"id"`
+		Parameters map[string]string `json:"parameters"`
+		CreatedBy  string            `json:"createdBy"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	created, err := rs.CreateFromTemplate(r.Context(), r.PathValue("name"), body.ID, body.Parameters, actorFor(r.Context(), body.CreatedBy))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleTemplates lists the rollout templates
+func (rs *RolloutService) handleTemplates(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, rs.Templates())
+}
+
+// handleGet returns a single rollout
+func (rs *RolloutService) handleGet(w http.ResponseWriter, r *http.Request) {
+	plan, err := GetRollout(r.Context(), rs.dynamoClient, rs.rolloutTableName, r.PathValue("id"))
+	if err != nil {
+		writeError(w, http.StatusNotFound, err.Error())
+		return
+	}
+
+	w.Header().Set("ETag", strconv.Quote(strconv.FormatInt(plan.Revision, 10)))
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// handleUpdate replaces a rollout definition; an If-Unmodified-Since header
+// (RFC 3339) carries the updatedAt the caller planned against, and an If-Match
+// header the revision
+func (rs *RolloutService) handleUpdate(w http.ResponseWriter, r *http.Request) {
+	var plan rollout.RolloutPlan
+	if err := json.NewDecoder(r.Body).Decode(&plan); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	plan.ID = r.PathValue("id")
+
+	var expected time.Time
+	if header := r.Header.Get("If-Unmodified-Since"); header != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, header)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid If-Unmodified-Since")
+			return
+		}
+		expected = parsed
+	}
+
+	revision, err := ifMatchRevision(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	updated, err := rs.Update(r.Context(), plan, expected, revision, actorFor(r.Context(), ""))
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleConfirm confirms a device's update awaiting confirmation
+func (rs *RolloutService) handleConfirm(w http.ResponseWriter, r *http.Request) {
+	if err := rs.Confirm(r.Context(), r.PathValue("id"), r.PathValue("device"), actorFor(r.Context(), "")); err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]string{"status": "confirmed"})
+}
+
+// handleAbort aborts a rollout
+func (rs *RolloutService) handleAbort(w http.ResponseWriter, r *http.Request) {
+	rs.handleTransition(rs.Abort)(w, r)
+}
+
+// handleTransition returns a handler that moves a rollout to another status;
+// an If-Match header carries the revision the caller decided on
+func (rs *RolloutService) handleTransition(transition func(ctx context.Context, rolloutID string, expectedRevision int64, actor, reason string) (*rollout.RolloutPlan, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Actor  string `json:"actor"`
+			Reason string `json:"reason"`
+		}
+		// The body is optional
+		json.NewDecoder(r.Body).Decode(&body)
+
+		revision, err := ifMatchRevision(r)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+
+		plan, err := transition(r.Context(), r.PathValue("id"), revision, actorFor(r.Context(), body.Actor), body.Reason)
+		if err != nil {
+			writeError(w, http.StatusConflict, err.Error())
+			return
+		}
+
+		writeJSON(w, http.StatusOK, plan)
+	}
+}
+
+// Helper functions
+
+// checkRevision rejects a change the caller decided on at another revision;
+// expected 0 skips the check
+func checkRevision(plan *rollout.RolloutPlan, expected int64) error {
+	if expected != 0 && plan.Revision != expected {
+		return fmt.Errorf("rollout %s is at revision %d, not %d: %w", plan.ID, plan.Revision, expected, ErrRolloutChanged)
+	}
+	return nil
+}
+
+// transitionConflict explains a failed conditional status change: the
+// rollout was in another status when read, or changed since
+func transitionConflict(plan *rollout.RolloutPlan, to string, from ...string) error {
+	for _, status := range from {
+		if plan.Status == status {
+			return fmt.Errorf("rollout %s cannot be %s: %w", plan.ID, to, ErrRolloutChanged)
+		}
+	}
+	return fmt.Errorf("rollout %s is %s and cannot be %s", plan.ID, plan.Status, to)
+}
+
+// ifMatchRevision parses an If-Match header holding a rollout revision, as
+// returned in the ETag of GET /api/rollouts/{id}; 0 when absent
+func ifMatchRevision(r *http.Request) (int64, error) {
+	header := r.Header.Get("If-Match")
+	if header == "" {
+		return 0, nil
+	}
+
+	revision, err := strconv.ParseInt(strings.Trim(header, `"`), 10, 64)
+	if err != nil || revision < 1 {
+		return 0, errors.New("invalid If-Match: expected a rollout revision")
+	}
+	return revision, nil
+}
+
+// phaseParameters are the keys a phase's thresholds may set. They tune the
+// phase controller, canary analyzer and anomaly detector rather than limit
+// a metric; conditions on telemetry belong in the phase's gate, which can
+// say what a bare limit can't, such as the window and aggregate.
+var phaseParameters = map[string]bool{
+	failureRateThreshold:         true,
+	minSampleThreshold:           true,
+	canaryMaxRegressionThreshold: true,
+	canaryAlphaThreshold:         true,
+	canaryMinDevicesThreshold:    true,
+	anomalyZThreshold:            true,
+	anomalyActionThreshold:       true,
+}
+
+// checkThresholds rejects threshold keys that aren't phase parameters,
+// pointing metric limits at the gate
+func checkThresholds(thresholds map[string]float64) error {
+	for key, value := range thresholds {
+		if !phaseParameters[key] {
+			return fmt.Errorf("unknown threshold %s; put conditions on metrics in the gate, e.g. \"%s < %g\"", key, key, value)
+		}
+	}
+	return nil
+}
+
+// validatePlan checks a submitted plan before it is stored
+func validatePlan(plan rollout.RolloutPlan) error {
+	if plan.Version == "" {
+		return errors.New("version is required")
+	}
+	if len(plan.TargetGroups) == 0 {
+		return errors.New("at least one target group is required")
+	}
+	if len(plan.Phases) == 0 {
+		return errors.New("at least one phase is required")
+	}
+	if plan.IsConfigOnly() && (plan.PackageURL != "" || plan.PackageHash != "" || plan.ArtifactName != "") {
+		return errors.New("a rollout delivers either a package or a configPayload, not both")
+	}
+	if plan.IsBundle() {
+		if plan.IsConfigOnly() || plan.PackageURL != "" || plan.PackageHash != "" || plan.ArtifactName != "" {
+			return errors.New("a bundle delivers its artifacts instead of a package or a configPayload")
+		}
+		kinds := make(map[string]bool)
+		for i, artifact := range plan.Artifacts {
+			if artifact.Kind == "" || kinds[artifact.Kind] {
+				return fmt.Errorf("artifact %d: kind is required and must be unique", i)
+			}
+			kinds[artifact.Kind] = true
+			if artifact.ArtifactName == "" && (artifact.PackageURL == "" || artifact.PackageHash == "") {
+				return fmt.Errorf("artifact %s: artifactName, or packageUrl and packageHash, are required", artifact.Kind)
+			}
+		}
+		if _, err := rollout.OrderArtifacts(plan.Artifacts); err != nil {
+			return err
+		}
+	}
+	if plan.IsMultiArch() {
+		if plan.IsConfigOnly() || plan.IsBundle() || plan.PackageURL != "" || plan.PackageHash != "" || plan.ArtifactName != "" {
+			return errors.New("a multi-architecture rollout delivers its packages instead of a package, a configPayload or artifacts")
+		}
+		architectures := make(map[string]bool)
+		for i, pkg := range plan.Packages {
+			if pkg.Architecture == "" || architectures[pkg.Architecture] {
+				return fmt.Errorf("package %d: architecture is required and must be unique", i)
+			}
+			architectures[pkg.Architecture] = true
+			if pkg.ArtifactName == "" && (pkg.PackageURL == "" || pkg.PackageHash == "") {
+				return fmt.Errorf("package %s: artifactName, or packageUrl and packageHash, are required", pkg.Architecture)
+			}
+		}
+	}
+	if plan.MinHealthScore < 0 || plan.MinHealthScore > 100 {
+		return errors.New("minHealthScore must be between 0 and 100")
+	}
+	if !rollout.ValidPhaseExpiry(plan.PhaseExpiry) {
+		return fmt.Errorf("phaseExpiry must be %s, %s or %s", rollout.PhaseExpiryHold, rollout.PhaseExpiryAdvance, rollout.PhaseExpiryFail)
+	}
+	if _, err := plan.ConfirmationWindow(); err != nil {
+		return err
+	}
+	if plan.HealthPolicy != nil {
+		if err := plan.HealthPolicy.Validate(); err != nil {
+			return err
+		}
+	}
+	if plan.BusinessHours != "" {
+		if _, _, err := rollout.ParseBusinessHours(plan.BusinessHours); err != nil {
+			return err
+		}
+	}
+	if plan.SchemaVersion < 0 {
+		return errors.New("schemaVersion must not be negative")
+	}
+	for _, artifact := range plan.Artifacts {
+		if artifact.SchemaVersion < 0 {
+			return fmt.Errorf("artifact %s: schemaVersion must not be negative", artifact.Kind)
+		}
+	}
+	if plan.PollInterval != "" {
+		if interval, err := time.ParseDuration(plan.PollInterval); err != nil || interval < rollout.MinPollInterval || interval > rollout.MaxPollInterval {
+			return fmt.Errorf("pollInterval must be a duration between %s and %s", rollout.MinPollInterval, rollout.MaxPollInterval)
+		}
+	}
+
+	previous := 0.0
+	for i, phase := range plan.Phases {
+		// A phase opening new regions may keep the percentage of the one before
+		if phase.Percentage < previous || (phase.Percentage == previous && len(phase.Regions) == 0) || phase.Percentage > 100 {
+			return fmt.Errorf("phase %d: percentage must increase and be at most 100", i)
+		}
+		for _, region := range phase.Regions {
+			if !plan.TargetsRegion(region) {
+				return fmt.Errorf("phase %d: region %s is not in targetRegions", i, region)
+			}
+		}
+		if _, err := phase.ParseDuration(); err != nil {
+			return fmt.Errorf("phase %d: %w", i, err)
+		}
+		if phase.Window != "" {
+			if _, _, err := rollout.ParseWindow(phase.Window); err != nil {
+				return fmt.Errorf("phase %d: %w", i, err)
+			}
+		}
+		if phase.Gate != "" {
+			if _, err := gate.Parse(phase.Gate); err != nil {
+				return fmt.Errorf("phase %d: invalid gate: %w", i, err)
+			}
+		}
+		if err := checkThresholds(phase.Thresholds); err != nil {
+			return fmt.Errorf("phase %d: %w", i, err)
+		}
+		previous = phase.Percentage
+		for group, override := range phase.GroupOverrides {
+			if override.Percentage != nil && (*override.Percentage < 0 || *override.Percentage > 100) {
+				return fmt.Errorf("phase %d: percentage for group %s must be between 0 and 100", i, group)
+			}
+			if err := checkThresholds(override.Thresholds); err != nil {
+				return fmt.Errorf("phase %d: group %s: %w", i, group, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// samePhase reports whether two phases have the same definition
+func samePhase(a, b rollout.RolloutPhase) bool {
+	if a.ID != b.ID || a.Percentage != b.Percentage || a.Duration != b.Duration || a.RequireApproval != b.RequireApproval {
+		return false
+	}
+	if strings.Join(a.Metrics, ",") != strings.Join(b.Metrics, ",") || len(a.Thresholds) != len(b.Thresholds) {
+		return false
+	}
+	if !reflect.DeepEqual(a.GroupOverrides, b.GroupOverrides) || strings.Join(a.Regions, ",") != strings.Join(b.Regions, ",") || a.Window != b.Window || a.Gate != b.Gate {
+		return false
+	}
+	for metric, threshold := range a.Thresholds {
+		if other, ok := b.Thresholds[metric]; !ok || other != threshold {
+			return false
+		}
+	}
+	return true
+}
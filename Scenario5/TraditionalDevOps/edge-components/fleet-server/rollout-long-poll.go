@@ -0,0 +1,273 @@
+package fleetserver
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// RolloutNotifier serves rollout notices to devices over HTTP long polls, so
+// each device learns of a rollout change within seconds while it polls the
+// rollout table only as a safety net. It watches the rollout table like the
+// RolloutQueuePublisher, but needs no queue per group and no gateway device.
+type RolloutNotifier struct {
+	dynamoClient     *dynamodb.Client
+	rolloutTableName string
+	interval         time.Duration
+	maxWait          time.Duration
+	retain           int
+	epoch            string           // tells cursors from before a restart apart
+	revisions        map[string]int64 // rollout ID -> revision last noticed
+	seeded           bool
+	notices          []sequencedNotice // most recent last
+	sequence         int64             // of the last notice
+	changed          chan struct{}     // closed when notices are added
+	mutex            sync.Mutex
+	timer            *time.Timer
+}
+
+// RolloutNotifierConfig contains configuration for the RolloutNotifier
+type RolloutNotifierConfig struct {
+	DynamoClient     *dynamodb.Client
+	RolloutTableName string
+	Interval         time.Duration // how often the rollout table is scanned; defaults to 5 seconds
+	MaxWait          time.Duration // longest a poll is held open; defaults to 30 seconds
+	Retain           int           // notices kept for devices catching up; defaults to 1000
+}
+
+// sequencedNotice is a notice with its position and the groups it concerns
+type sequencedNotice struct {
+	sequence int64
+	notice   rollout.RolloutNotice
+	groups   []string
+}
+
+// NewRolloutNotifier creates a new RolloutNotifier and starts watching
+func NewRolloutNotifier(config RolloutNotifierConfig) *RolloutNotifier {
+	if config.Interval == 0 {
+		config.Interval = 5 * time.Second
+	}
+	if config.MaxWait == 0 {
+		config.MaxWait = 30 * time.Second
+	}
+	if config.Retain == 0 {
+		config.Retain = 1000
+	}
+
+	rn := &RolloutNotifier{
+		dynamoClient:     config.DynamoClient,
+		rolloutTableName: config.RolloutTableName,
+		interval:         config.Interval,
+		maxWait:          config.MaxWait,
+		retain:           config.Retain,
+		epoch:            strconv.FormatInt(time.Now().UnixNano(), 36),
+		revisions:        make(map[string]int64),
+		changed:          make(chan struct{}),
+	}
+
+	// Start the watch timer
+	rn.timer = time.AfterFunc(0, rn.watchLoop)
+
+	return rn
+}
+
+// watchLoop scans for rollout changes and reschedules itself
+func (rn *RolloutNotifier) watchLoop() {
+	defer func() {
+		// Reschedule the watch
+		rn.timer.Reset(rn.interval)
+	}()
+
+	if err := rn.Scan(context.Background(), time.Now()); err != nil {
+		log.Printf("Failed to scan for rollout changes: %v", err)
+	}
+}
+
+// Scan records a notice for every rollout whose revision changed since the
+// last call and wakes the polls waiting for it. The first call only records
+// the current revisions; devices reconnecting after a restart get a reset.
+func (rn *RolloutNotifier) Scan(ctx context.Context, now time.Time) error {
+	plans, err := ScanRollouts(ctx, rn.dynamoClient, rn.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+
+	current := make(map[string]bool, len(plans))
+	added := 0
+	for _, plan := range plans {
+		current[plan.ID] = true
+		if revision, ok := rn.revisions[plan.ID]; ok && revision == plan.Revision {
+			continue
+		}
+		rn.revisions[plan.ID] = plan.Revision
+		if !rn.seeded {
+			continue
+		}
+
+		rn.sequence++
+		rn.notices = append(rn.notices, sequencedNotice{
+			sequence: rn.sequence,
+			notice: rollout.RolloutNotice{
+				RolloutID:    plan.ID,
+				TenantID:     plan.TenantID,
+				Status:       plan.Status,
+				CurrentPhase: plan.CurrentPhase,
+				Revision:     plan.Revision,
+				Timestamp:    now.UTC(),
+			},
+			groups: plan.TargetGroups,
+		})
+		added++
+	}
+	rn.seeded = true
+
+	// Forget rollouts that expired out of the table
+	for id := range rn.revisions {
+		if !current[id] {
+			delete(rn.revisions, id)
+		}
+	}
+
+	if added == 0 {
+		return nil
+	}
+
+	if len(rn.notices) > rn.retain {
+		rn.notices = append([]sequencedNotice(nil), rn.notices[len(rn.notices)-rn.retain:]...)
+	}
+
+	close(rn.changed)
+	rn.changed = make(chan struct{})
+
+	return nil
+}
+
+// Close stops the notifier
+func (rn *RolloutNotifier) Close() {
+	if rn.timer != nil {
+		rn.timer.Stop()
+	}
+}
+
+// RegisterRoutes adds the long-poll route to the server
+func (rn *RolloutNotifier) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/rollout-notices", http.HandlerFunc(rn.handlePoll))
+}
+
+// handlePoll answers with the notices after the cursor for the device's
+// groups, waiting up to the requested time for one. Without a cursor, or
+// with one the notifier can no longer serve, it answers at once with the
+// current cursor; Reset then tells the device it may have missed changes.
+func (rn *RolloutNotifier) handlePoll(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	groups := query["group"]
+	if len(groups) == 0 {
+		writeError(w, http.StatusBadRequest, "at least one group is required")
+		return
+	}
+
+	wait := rn.maxWait
+	if value := query.Get("wait"); value != "" {
+		requested, err := time.ParseDuration(value)
+		if err != nil || requested < 0 {
+			writeError(w, http.StatusBadRequest, "invalid wait")
+			return
+		}
+		if requested < wait {
+			wait = requested
+		}
+	}
+
+	tenantID := tenant.FromContext(r.Context())
+	cursor := query.Get("cursor")
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	for {
+		response, changed := rn.noticesAfter(cursor, tenantID, groups)
+		if len(response.Notices) > 0 || response.Reset || cursor == "" {
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+
+		select {
+		case <-changed:
+			// The new notices may concern other groups; the cursor moves on either way
+			cursor = response.Cursor
+		case <-timer.C:
+			writeJSON(w, http.StatusOK, response)
+			return
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
+
+// noticesAfter returns the tenant's notices for the groups after cursor, and
+// the channel closed when more are added
+func (rn *RolloutNotifier) noticesAfter(cursor, tenantID string, groups []string) (rollout.LongPollResponse, <-chan struct{}) {
+	rn.mutex.Lock()
+	defer rn.mutex.Unlock()
+
+	response := rollout.LongPollResponse{
+		Cursor:  fmt.Sprintf("%s.%d", rn.epoch, rn.sequence),
+		Notices: make([]rollout.RolloutNotice, 0),
+	}
+	if cursor == "" {
+		return response, rn.changed
+	}
+
+	epoch, value, _ := strings.Cut(cursor, ".")
+	after, err := strconv.ParseInt(value, 10, 64)
+	oldest := rn.sequence + 1
+	if len(rn.notices) > 0 {
+		oldest = rn.notices[0].sequence
+	}
+	if epoch != rn.epoch || err != nil || after > rn.sequence || after < oldest-1 {
+		response.Reset = true
+		return response, rn.changed
+	}
+
+	for _, entry := range rn.notices {
+		if entry.sequence <= after || entry.notice.TenantID != tenantID {
+			continue
+		}
+		if targetsGroups(entry.groups, groups) {
+			response.Notices = append(response.Notices, entry.notice)
+		}
+	}
+
+	return response, rn.changed
+}
+
+// Helper functions
+
+// targetsGroups reports whether a rollout's target groups include any of the
+// device's groups; "all" includes every group
+func targetsGroups(targets, groups []string) bool {
+	for _, target := range targets {
+		if target == "all" {
+			return true
+		}
+		for _, group := range groups {
+			if target == group {
+				return true
+			}
+		}
+	}
+	return false
+}
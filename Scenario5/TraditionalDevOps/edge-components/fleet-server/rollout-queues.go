@@ -0,0 +1,216 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// RolloutQueuePublisher puts a RolloutNotice on the SQS queue of every group
+// a rollout targets whenever the rollout's revision changes, so gateway
+// devices can long-poll their group's queue instead of querying the rollout
+// table. Groups only get notices when their queue exists; create one per
+// group that should use this path, named by rollout.QueueName.
+type RolloutQueuePublisher struct {
+	dynamoClient     *dynamodb.Client
+	sqsClient        *sqs.Client
+	rolloutTableName string
+	queuePrefix      string
+	interval         time.Duration
+	revisions        map[string]int64 // rollout ID -> revision last notified
+	seeded           bool
+	publishMutex     sync.Mutex
+	timer            *time.Timer
+}
+
+// RolloutQueuePublisherConfig contains configuration for the RolloutQueuePublisher
+type RolloutQueuePublisherConfig struct {
+	DynamoClient     *dynamodb.Client
+	SQSClient        *sqs.Client
+	RolloutTableName string
+	QueuePrefix      string        // defaults to rollout.DefaultQueuePrefix
+	Interval         time.Duration // defaults to 15 seconds
+}
+
+// NewRolloutQueuePublisher creates a new RolloutQueuePublisher and starts publishing
+func NewRolloutQueuePublisher(config RolloutQueuePublisherConfig) *RolloutQueuePublisher {
+	if config.QueuePrefix == "" {
+		config.QueuePrefix = rollout.DefaultQueuePrefix
+	}
+	if config.Interval == 0 {
+		config.Interval = 15 * time.Second
+	}
+
+	qp := &RolloutQueuePublisher{
+		dynamoClient:     config.DynamoClient,
+		sqsClient:        config.SQSClient,
+		rolloutTableName: config.RolloutTableName,
+		queuePrefix:      config.QueuePrefix,
+		interval:         config.Interval,
+		revisions:        make(map[string]int64),
+	}
+
+	// Start the publish timer
+	qp.timer = time.AfterFunc(0, qp.publishLoop)
+
+	return qp
+}
+
+// publishLoop publishes rollout changes and reschedules itself
+func (qp *RolloutQueuePublisher) publishLoop() {
+	defer func() {
+		// Reschedule the publish
+		qp.timer.Reset(qp.interval)
+	}()
+
+	if err := qp.Publish(context.Background(), time.Now()); err != nil {
+		log.Printf("Failed to publish rollout notices: %v", err)
+	}
+}
+
+// Publish sends a notice for every rollout whose revision changed since the
+// last call. The first call only records the current revisions, so a restart
+// doesn't notify every group again.
+func (qp *RolloutQueuePublisher) Publish(ctx context.Context, now time.Time) error {
+	qp.publishMutex.Lock()
+	defer qp.publishMutex.Unlock()
+
+	plans, err := ScanRollouts(ctx, qp.dynamoClient, qp.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	if !qp.seeded {
+		for _, plan := range plans {
+			qp.revisions[plan.ID] = plan.Revision
+		}
+		qp.seeded = true
+		return nil
+	}
+
+	current := make(map[string]bool, len(plans))
+	changed := make([]rollout.RolloutPlan, 0)
+	for _, plan := range plans {
+		current[plan.ID] = true
+		if revision, ok := qp.revisions[plan.ID]; !ok || revision != plan.Revision {
+			changed = append(changed, plan)
+		}
+	}
+
+	// Forget rollouts that expired out of the table
+	for id := range qp.revisions {
+		if !current[id] {
+			delete(qp.revisions, id)
+		}
+	}
+
+	if len(changed) == 0 {
+		return nil
+	}
+
+	queues, err := qp.listQueues(ctx)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, plan := range changed {
+		if err := qp.notify(ctx, plan, queues, now); err != nil {
+			log.Printf("Failed to publish notice for rollout %s: %v", plan.ID, err)
+			failed++
+			continue
+		}
+		qp.revisions[plan.ID] = plan.Revision
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d rollout notices not published", failed, len(changed))
+	}
+
+	return nil
+}
+
+// Close stops the publisher
+func (qp *RolloutQueuePublisher) Close() {
+	if qp.timer != nil {
+		qp.timer.Stop()
+	}
+}
+
+// notify sends a notice for plan to the queue of each group it targets; "all"
+// targets every queue of the rollout's tenant
+func (qp *RolloutQueuePublisher) notify(ctx context.Context, plan rollout.RolloutPlan, queues map[string]string, now time.Time) error {
+	body, err := json.Marshal(rollout.RolloutNotice{
+		RolloutID:    plan.ID,
+		TenantID:     plan.TenantID,
+		Status:       plan.Status,
+		CurrentPhase: plan.CurrentPhase,
+		Revision:     plan.Revision,
+		Timestamp:    now.UTC(),
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollout notice: %w", err)
+	}
+
+	targets := make(map[string]string)
+	for _, group := range plan.TargetGroups {
+		if group == "all" {
+			for name, url := range queues {
+				if tenantID, _ := rollout.QueueTenant(qp.queuePrefix, name); tenantID == plan.TenantID {
+					targets[name] = url
+				}
+			}
+			continue
+		}
+
+		name := rollout.QueueName(qp.queuePrefix, plan.TenantID, group)
+		if url, ok := queues[name]; ok {
+			targets[name] = url
+		}
+	}
+
+	for name, url := range targets {
+		_, err := qp.sqsClient.SendMessage(ctx, &sqs.SendMessageInput{
+			QueueUrl:    aws.String(url),
+			MessageBody: aws.String(string(body)),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to send notice to %s: %w", name, err)
+		}
+	}
+
+	return nil
+}
+
+// listQueues returns the URL of every rollout queue, by queue name
+func (qp *RolloutQueuePublisher) listQueues(ctx context.Context) (map[string]string, error) {
+	queues := make(map[string]string)
+
+	paginator := sqs.NewListQueuesPaginator(qp.sqsClient, &sqs.ListQueuesInput{
+		QueueNamePrefix: aws.String(qp.queuePrefix),
+		MaxResults:      aws.Int32(1000),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list rollout queues: %w", err)
+		}
+
+		for _, url := range page.QueueUrls {
+			name := url[strings.LastIndex(url, "/")+1:]
+			queues[name] = url
+		}
+	}
+
+	return queues, nil
+}
@@ -0,0 +1,173 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+const (
+	// scheduleLayout is the wall-clock layout of RolloutPlan.ScheduledStart
+	scheduleLayout = "2006-01-02T15:04"
+
+	// blackoutLayout is the layout of RolloutPlan.BlackoutDates
+	blackoutLayout = "2006-01-02"
+
+	// maxBlackoutDays bounds how far a start can be pushed by consecutive blackout dates
+	maxBlackoutDays = 366
+)
+
+// RolloutScheduler starts pending rollouts once their scheduled time arrives
+type RolloutScheduler struct {
+	dynamoClient     *dynamodb.Client
+	rolloutTableName string
+	checkInterval    time.Duration
+	checkTimer       *time.Timer
+}
+
+// RolloutSchedulerConfig contains configuration for the RolloutScheduler
+type RolloutSchedulerConfig struct {
+	DynamoClient     *dynamodb.Client
+	RolloutTableName string
+	CheckInterval    time.Duration
+}
+
+// NewRolloutScheduler creates a new RolloutScheduler
+func NewRolloutScheduler(config RolloutSchedulerConfig) *RolloutScheduler {
+	rs := &RolloutScheduler{
+		dynamoClient:     config.DynamoClient,
+		rolloutTableName: config.RolloutTableName,
+		checkInterval:    config.CheckInterval,
+	}
+
+	// Start the check timer
+	rs.checkTimer = time.AfterFunc(rs.checkInterval, rs.checkLoop)
+
+	return rs
+}
+
+// checkLoop starts due rollouts and reschedules itself
+func (rs *RolloutScheduler) checkLoop() {
+	defer func() {
+		// Reschedule the check
+		rs.checkTimer.Reset(rs.checkInterval)
+	}()
+
+	if err := rs.StartDue(context.Background(), time.Now()); err != nil {
+		log.Printf("Failed to start scheduled rollouts: %v", err)
+	}
+}
+
+// StartDue transitions every pending rollout whose effective start time has passed to in-progress
+func (rs *RolloutScheduler) StartDue(ctx context.Context, now time.Time) error {
+	rollouts, err := ScanRollouts(ctx, rs.dynamoClient, rs.rolloutTableName)
+	if err != nil {
+		return err
+	}
+
+	for _, plan := range rollouts {
+		if plan.Status != "pending" || plan.ScheduledStart == "" {
+			continue
+		}
+
+		start, err := EffectiveStart(plan)
+		if err != nil {
+			log.Printf("Rollout %s has an invalid schedule: %v", plan.ID, err)
+			continue
+		}
+
+		if now.Before(start) {
+			continue
+		}
+
+		if err := startRollout(ctx, rs.dynamoClient, rs.rolloutTableName, plan.ID, plan.Revision, now); err != nil {
+			log.Printf("Failed to start rollout %s: %v", plan.ID, err)
+			continue
+		}
+
+		log.Printf("Started scheduled rollout %s (scheduled for %s)", plan.ID, start.Format(time.RFC3339))
+	}
+
+	return nil
+}
+
+// startRollout moves a rollout from pending to in-progress, guarding against concurrent transitions
+func startRollout(ctx context.Context, client *dynamodb.Client, tableName, rolloutID string, revision int64, now time.Time) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:    aws.String("SET #status = :inProgress, UpdatedAt = :time, StartedAt = :time"),
+		ConditionExpression: aws.String("#status = :pending"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":inProgress": &types.AttributeValueMemberS{Value: "in-progress"},
+			":pending":    &types.AttributeValueMemberS{Value: "pending"},
+			":time":       &types.AttributeValueMemberS{Value: now.UTC().Format(time.RFC3339)},
+		},
+	}
+	requireRevision(input, revision)
+
+	_, err := client.UpdateItem(ctx, input)
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		// Someone else already started, changed or cancelled it; the next check sees it
+		return nil
+	}
+
+	return err
+}
+
+// Close stops the rollout scheduler
+func (rs *RolloutScheduler) Close() {
+	if rs.checkTimer != nil {
+		rs.checkTimer.Stop()
+	}
+}
+
+// EffectiveStart resolves a plan's scheduled start in its timezone, moving it
+// to the same wall-clock time on the next day while it falls on a blackout date
+func EffectiveStart(plan rollout.RolloutPlan) (time.Time, error) {
+	location := time.UTC
+	if plan.ScheduleTimezone != "" {
+		loc, err := time.LoadLocation(plan.ScheduleTimezone)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("unknown timezone %q: %w", plan.ScheduleTimezone, err)
+		}
+		location = loc
+	}
+
+	start, err := time.ParseInLocation(scheduleLayout, plan.ScheduledStart, location)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid scheduled start %q: %w", plan.ScheduledStart, err)
+	}
+
+	blackouts := make(map[string]bool, len(plan.BlackoutDates))
+	for _, date := range plan.BlackoutDates {
+		if _, err := time.Parse(blackoutLayout, date); err != nil {
+			return time.Time{}, fmt.Errorf("invalid blackout date %q: %w", date, err)
+		}
+		blackouts[date] = true
+	}
+
+	for i := 0; blackouts[start.Format(blackoutLayout)]; i++ {
+		if i >= maxBlackoutDays {
+			return time.Time{}, fmt.Errorf("no allowed start date within %d days", maxBlackoutDays)
+		}
+		// AddDate keeps the wall-clock time across DST changes
+		start = start.AddDate(0, 0, 1)
+	}
+
+	return start, nil
+}
@@ -0,0 +1,137 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Server serves the fleet API consumed by dashboards and tooling
+type Server struct {
+	aggregator *Aggregator
+	auth       *Authenticator
+	mux        *http.ServeMux
+	httpServer *http.Server
+}
+
+// ServerConfig contains configuration for the Server
+type ServerConfig struct {
+	ListenAddr string
+	Aggregator *Aggregator
+
+	// RequireTenant rejects requests without a tenant header; requests that
+	// carry one only see that tenant's devices and rollouts
+	RequireTenant bool
+
+	// Auth authenticates every request and enforces role permissions per
+	// route when set; the API is open when nil
+	Auth *Authenticator
+}
+
+// NewServer creates a new Server and registers the dashboard routes
+func NewServer(config ServerConfig) *Server {
+	s := &Server{
+		aggregator: config.Aggregator,
+		auth:       config.Auth,
+		mux:        http.NewServeMux(),
+	}
+
+	handler := tenant.Middleware(s.mux, config.RequireTenant)
+	if s.auth != nil {
+		handler = s.auth.Middleware(handler)
+	}
+
+	s.httpServer = &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           handler,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	s.Handle("/api/fleet/summary", getOnly(s.handleSummary))
+	s.Handle("/api/fleet/versions", getOnly(s.handleVersions))
+	s.Handle("/api/fleet/rollouts", getOnly(s.handleRollouts))
+	s.Handle("/api/fleet/failures", getOnly(s.handleFailures))
+
+	return s
+}
+
+// Handle registers an additional route on the server, guarded by the
+// permission its method and path require when auth is enabled
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	if s.auth != nil {
+		handler = s.auth.Require(routePermission(pattern), handler)
+	}
+	s.mux.Handle(pattern, handler)
+}
+
+// ListenAndServe starts serving the fleet API
+func (s *Server) ListenAndServe() error {
+	log.Printf("Fleet server listening on %s", s.httpServer.Addr)
+	return s.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops the server
+func (s *Server) Shutdown(ctx context.Context) error {
+	return s.httpServer.Shutdown(ctx)
+}
+
+// handleSummary serves the full pre-computed fleet view
+func (s *Server) handleSummary(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.aggregator.ViewFor(tenant.FromContext(r.Context())))
+}
+
+// handleVersions serves the fleet-wide and per-group version distribution
+func (s *Server) handleVersions(w http.ResponseWriter, r *http.Request) {
+	view := s.aggregator.ViewFor(tenant.FromContext(r.Context()))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"generatedAt":         view.GeneratedAt,
+		"versionDistribution": view.VersionDistribution,
+		"groupVersions":       view.GroupVersions,
+	})
+}
+
+// handleRollouts serves phase progress for active rollouts
+func (s *Server) handleRollouts(w http.ResponseWriter, r *http.Request) {
+	view := s.aggregator.ViewFor(tenant.FromContext(r.Context()))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"generatedAt": view.GeneratedAt,
+		"rollouts":    view.Rollouts,
+	})
+}
+
+// handleFailures serves the failure heatmap by group and region
+func (s *Server) handleFailures(w http.ResponseWriter, r *http.Request) {
+	view := s.aggregator.ViewFor(tenant.FromContext(r.Context()))
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"generatedAt":    view.GeneratedAt,
+		"failureHeatmap": view.FailureHeatmap,
+	})
+}
+
+// Helper functions
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+func getOnly(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeError(w, http.StatusMethodNotAllowed, "method not allowed")
+			return
+		}
+		handler(w, r)
+	}
+}
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
@@ -0,0 +1,407 @@
+package fleetserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// slackPostMessageURL is the Slack Web API method posting to a channel
+const slackPostMessageURL = "https://slack.com/api/chat.postMessage"
+
+// slackMaxSkew is how far a Slack request's timestamp may be from now before
+// the request is rejected as a replay
+const slackMaxSkew = 5 * time.Minute
+
+// SlackApprover maps a Slack user to the identity and roles they approve as,
+// as an API key maps its holder
+type SlackApprover struct {
+	Identity string // approver role member identity, e.g. alice@example.com
+	Roles    []string
+	Tenant   string
+}
+
+// SlackApprovalBot posts approval requests to a Slack channel with approve
+// and reject buttons and records the decisions of the Slack users it maps to
+// approvers. It serves Slack's interaction and slash command callbacks on
+// its own listener, as Slack signs its requests instead of presenting fleet
+// API credentials.
+type SlackApprovalBot struct {
+	approvals     *ApprovalService
+	signingSecret string
+	botToken      string
+	channel       string
+	approvers     map[string]SlackApprover
+	httpClient    *http.Client
+	httpServer    *http.Server
+}
+
+// SlackApprovalBotConfig contains configuration for the SlackApprovalBot
+type SlackApprovalBotConfig struct {
+	ListenAddr    string
+	Approvals     *ApprovalService
+	SigningSecret string                   // the Slack app's signing secret
+	BotToken      string                   // bot token with the chat:write scope
+	Channel       string                   // channel approval requests are posted to
+	Approvers     map[string]SlackApprover // Slack user ID -> approver
+}
+
+// NewSlackApprovalBot creates a new SlackApprovalBot and registers it to
+// announce the approval service's requests
+func NewSlackApprovalBot(config SlackApprovalBotConfig) (*SlackApprovalBot, error) {
+	if config.SigningSecret == "" {
+		return nil, fmt.Errorf("slack signing secret is required")
+	}
+
+	for userID, approver := range config.Approvers {
+		if err := validateRoles(approver.Roles); err != nil {
+			return nil, fmt.Errorf("slack user %s: %w", userID, err)
+		}
+	}
+
+	bot := &SlackApprovalBot{
+		approvals:     config.Approvals,
+		signingSecret: config.SigningSecret,
+		botToken:      config.BotToken,
+		channel:       config.Channel,
+		approvers:     config.Approvers,
+		httpClient:    &http.Client{Timeout: 10 * time.Second},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /slack/interactions", bot.verified(bot.handleInteraction))
+	mux.HandleFunc("POST /slack/commands", bot.verified(bot.handleCommand))
+
+	bot.httpServer = &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	config.Approvals.AddAnnouncer(bot)
+
+	return bot, nil
+}
+
+// ListenAndServe starts serving Slack callbacks
+func (b *SlackApprovalBot) ListenAndServe() error {
+	log.Printf("Slack approval bot listening on %s", b.httpServer.Addr)
+	return b.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops the bot
+func (b *SlackApprovalBot) Shutdown(ctx context.Context) error {
+	return b.httpServer.Shutdown(ctx)
+}
+
+// Announce posts an approval request to the channel with approve and reject buttons
+func (b *SlackApprovalBot) Announce(ctx context.Context, request *ApprovalRequest) error {
+	payload := map[string]interface{}{
+		"channel": b.channel,
+		"text":    describeApproval(request),
+		"blocks": []interface{}{
+			map[string]interface{}{
+				"type": "section",
+				"text": map[string]string{"type": "mrkdwn", "text": describeApproval(request)},
+			},
+			map[string]interface{}{
+				"type":     "actions",
+				"block_id": "approval",
+				"elements": []interface{}{
+					slackButton("approve", "Approve", "primary", request.ID),
+					slackButton("reject", "Reject", "danger", request.ID),
+				},
+			},
+		},
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal slack message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, slackPostMessageURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Bearer "+b.botToken)
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post slack message: %w", err)
+	}
+	defer resp.Body.Close()
+
+	// The Web API reports failures in the body, usually with a 200
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode slack response (%s): %w", resp.Status, err)
+	}
+	if !result.OK {
+		return fmt.Errorf("slack rejected the message: %s", result.Error)
+	}
+
+	return nil
+}
+
+// Decide records a Slack user's decision as the approver they map to, after
+// checking the approver's roles grant approval permission. The approval
+// service then checks the approver is a member of the request's role.
+func (b *SlackApprovalBot) Decide(ctx context.Context, userID, requestID string, approve bool, comment string) (*ApprovalRequest, error) {
+	approver, ok := b.approvers[userID]
+	if !ok {
+		return nil, fmt.Errorf("slack user %s is not mapped to an approver", userID)
+	}
+
+	principal := &Principal{Subject: approver.Identity, Roles: approver.Roles, Tenant: approver.Tenant, Method: "slack"}
+	if !principal.Can(PermApprove) {
+		return nil, fmt.Errorf("%s is not permitted to decide approvals", principal.Subject)
+	}
+
+	ctx = context.WithValue(ctx, principalKey{}, principal)
+	if principal.Tenant != "" {
+		ctx = tenant.WithTenant(ctx, principal.Tenant)
+	}
+
+	return b.approvals.Decide(ctx, requestID, principal.Subject, approve, comment)
+}
+
+// verified wraps a Slack callback so only requests carrying a valid, recent
+// Slack signature reach it; the form body is parsed for the handler
+func (b *SlackApprovalBot) verified(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 1<<20))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+
+		if err := b.verifySignature(r.Header, body, time.Now()); err != nil {
+			log.Printf("Rejected slack request to %s: %v", r.URL.Path, err)
+			writeError(w, http.StatusUnauthorized, "invalid slack signature")
+			return
+		}
+
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid request body")
+			return
+		}
+		r.PostForm = form
+		r.Form = form
+
+		handler(w, r)
+	}
+}
+
+// verifySignature checks a request's v0 signature, an HMAC-SHA256 of its
+// timestamp and body keyed with the signing secret
+func (b *SlackApprovalBot) verifySignature(header http.Header, body []byte, now time.Time) error {
+	timestamp := header.Get("X-Slack-Request-Timestamp")
+	seconds, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return fmt.Errorf("invalid timestamp %q", timestamp)
+	}
+
+	if skew := now.Sub(time.Unix(seconds, 0)); skew > slackMaxSkew || skew < -slackMaxSkew {
+		return fmt.Errorf("timestamp is %s from now", skew.Round(time.Second))
+	}
+
+	mac := hmac.New(sha256.New, []byte(b.signingSecret))
+	fmt.Fprintf(mac, "v0:%s:%s", timestamp, body)
+	expected := "v0=" + hex.EncodeToString(mac.Sum(nil))
+
+	if !hmac.Equal([]byte(expected), []byte(header.Get("X-Slack-Signature"))) {
+		return fmt.Errorf("signature mismatch")
+	}
+
+	return nil
+}
+
+// handleInteraction records a click on an approve or reject button and
+// replaces the message with the request's outcome
+func (b *SlackApprovalBot) handleInteraction(w http.ResponseWriter, r *http.Request) {
+	var payload struct {
+		Type string `json:"type"`
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+		Actions []struct {
+			ActionID string `json:"action_id"`
+			Value    string `json:"value"`
+		} `json:"actions"`
+		ResponseURL string `json:"response_url"`
+	}
+	if err := json.Unmarshal([]byte(r.PostForm.Get("payload")), &payload); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid interaction payload")
+		return
+	}
+
+	// Slack only expects an acknowledgement; outcomes go to the response URL
+	w.WriteHeader(http.StatusOK)
+
+	if payload.Type != "block_actions" || len(payload.Actions) == 0 {
+		return
+	}
+
+	action := payload.Actions[0]
+	if action.ActionID != "approve" && action.ActionID != "reject" {
+		return
+	}
+
+	request, err := b.Decide(r.Context(), payload.User.ID, action.Value, action.ActionID == "approve", "via Slack")
+	if err != nil {
+		b.respond(r.Context(), payload.ResponseURL, false, fmt.Sprintf("Could not %s: %v", action.ActionID, err))
+		return
+	}
+
+	// A resolved request no longer needs buttons, so the message is replaced
+	b.respond(r.Context(), payload.ResponseURL, request.Status != ApprovalPending, describeApproval(request))
+}
+
+// handleCommand serves the slash command: approve <id> [comment],
+// reject <id> [comment] or show <id>
+func (b *SlackApprovalBot) handleCommand(w http.ResponseWriter, r *http.Request) {
+	fields := strings.Fields(r.PostForm.Get("text"))
+	if len(fields) < 2 {
+		writeSlackReply(w, fmt.Sprintf("Usage: %s approve|reject|show <request-id> [comment]", r.PostForm.Get("command")))
+		return
+	}
+
+	verb, requestID, comment := fields[0], fields[1], strings.Join(fields[2:], " ")
+	if comment == "" {
+		comment = "via Slack"
+	}
+
+	switch verb {
+	case "approve", "reject":
+		request, err := b.Decide(r.Context(), r.PostForm.Get("user_id"), requestID, verb == "approve", comment)
+		if err != nil {
+			writeSlackReply(w, fmt.Sprintf("Could not %s: %v", verb, err))
+			return
+		}
+		writeSlackReply(w, describeApproval(request))
+	case "show":
+		approver, ok := b.approvers[r.PostForm.Get("user_id")]
+		if !ok {
+			writeSlackReply(w, "You are not mapped to an approver")
+			return
+		}
+		request, err := b.approvals.Get(tenant.WithTenant(r.Context(), approver.Tenant), requestID)
+		if err != nil {
+			writeSlackReply(w, err.Error())
+			return
+		}
+		writeSlackReply(w, describeApproval(request))
+	default:
+		writeSlackReply(w, fmt.Sprintf("Unknown action %q; use approve, reject or show", verb))
+	}
+}
+
+// respond posts a reply to an interaction's response URL, replacing the
+// original message or adding an ephemeral reply for the clicking user
+func (b *SlackApprovalBot) respond(ctx context.Context, responseURL string, replace bool, text string) {
+	if responseURL == "" {
+		return
+	}
+
+	payload := map[string]interface{}{"text": text}
+	if replace {
+		payload["replace_original"] = true
+	} else {
+		payload["response_type"] = "ephemeral"
+		payload["replace_original"] = false
+	}
+
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to marshal slack response: %v", err)
+		return
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, responseURL, bytes.NewReader(body))
+	if err != nil {
+		log.Printf("Failed to create slack response: %v", err)
+		return
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := b.httpClient.Do(req)
+	if err != nil {
+		log.Printf("Failed to send slack response: %v", err)
+		return
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		log.Printf("Slack response URL returned %s", resp.Status)
+	}
+}
+
+// Helper functions
+
+// describeApproval summarizes an approval request and its progress for Slack
+func describeApproval(request *ApprovalRequest) string {
+	approvals := make([]string, 0)
+	rejections := make([]string, 0)
+	for _, d := range request.Decisions {
+		if d.Approved {
+			approvals = append(approvals, d.Approver)
+		} else {
+			rejections = append(rejections, d.Approver)
+		}
+	}
+
+	target := fmt.Sprintf("phase *%s* (#%d) of rollout *%s*", request.PhaseID, request.PhaseIndex, request.RolloutID)
+
+	switch request.Status {
+	case ApprovalApproved:
+		return fmt.Sprintf(":white_check_mark: Approved %s by %s", target, strings.Join(approvals, ", "))
+	case ApprovalRejected:
+		return fmt.Sprintf(":x: Rejected %s by %s", target, strings.Join(rejections, ", "))
+	case ApprovalExpired:
+		return fmt.Sprintf(":hourglass: Approval of %s expired with %d of %d approvals", target, len(approvals), request.Quorum)
+	}
+
+	text := fmt.Sprintf("Approval requested by %s for %s: %d of %d *%s* members must approve by %s (request `%s`)",
+		request.RequestedBy, target, request.Quorum, request.Eligible, request.Role, request.ExpiresAt.Format(time.RFC3339), request.ID)
+	if len(approvals) > 0 {
+		text += fmt.Sprintf("\nApproved so far by %s (%d/%d)", strings.Join(approvals, ", "), len(approvals), request.Quorum)
+	}
+	return text
+}
+
+func slackButton(actionID, label, style, value string) map[string]interface{} {
+	return map[string]interface{}{
+		"type":      "button",
+		"action_id": actionID,
+		"text":      map[string]string{"type": "plain_text", "text": label},
+		"style":     style,
+		"value":     value,
+	}
+}
+
+// writeSlackReply answers a slash command with a reply only the caller sees
+func writeSlackReply(w http.ResponseWriter, text string) {
+	writeJSON(w, http.StatusOK, map[string]string{
+		"response_type": "ephemeral",
+		"text":          text,
+	})
+}
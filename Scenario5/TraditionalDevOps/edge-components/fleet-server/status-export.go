@@ -0,0 +1,505 @@
+package fleetserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// StatusSchemaVersion is the version of the StatusExport schema. Fields are
+// only added within a version; renaming or removing one bumps it.
+const StatusSchemaVersion = 1
+
+// StatusExport is fleet and per-device status in a stable schema for
+// dashboards. Every field is always present; unknown values are null.
+type StatusExport struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	GeneratedAt   time.Time         `json:"generatedAt"`
+	Fleet         FleetStatus       `json:"fleet"`
+	Rollouts      []RolloutProgress `json:"rollouts"` // active rollouts only
+	Devices       []DeviceStatus    `json:"devices"`
+}
+
+// FleetStatus counts devices fleet-wide
+type FleetStatus struct {
+	TotalDevices     int            `json:"totalDevices"`
+	HealthyDevices   int            `json:"healthyDevices"`
+	UnhealthyDevices int            `json:"unhealthyDevices"` // devices without a health report count as neither
+	StaleDevices     int            `json:"staleDevices"`
+	Versions         map[string]int `json:"versions"`
+	Groups           map[string]int `json:"groups"`
+	UpdateStatuses   map[string]int `json:"updateStatuses"`
+}
+
+// DeviceStatus is one device's exported status
+type DeviceStatus struct {
+	DeviceID      string     `json:"deviceId"`
+	TenantID      string     `json:"tenantId"`
+	Group         string     `json:"group"`
+	Region        string     `json:"region"`
+	Version       string     `json:"version"`
+	ConfigVersion string     `json:"configVersion"`
+	UpdateStatus  string     `json:"updateStatus"`
+	LastUpdateID  string     `json:"lastUpdateId"`
+	Healthy       *bool      `json:"healthy"`
+	HealthScore   *float64   `json:"healthScore"`
+	LastSeen      *time.Time `json:"lastSeen"`
+	Stale         bool       `json:"stale"`        // not seen within StaleAfter
+	ClockDriftMs  *int64     `json:"clockDriftMs"` // device clock minus server time
+}
+
+// Grafana JSON datasource targets served by the StatusExporter
+var grafanaTargets = []string{
+	"devices",
+	"devices_healthy",
+	"devices_unhealthy",
+	"devices_stale",
+	"devices_by_version",
+	"devices_by_group",
+	"devices_by_update_status",
+	"rollout_completion",
+	"device_table",
+	"rollout_table",
+}
+
+// StatusExporter serves the aggregator's fleet view, and the devices it read,
+// for dashboards: as a StatusExport, through the Grafana JSON datasource API,
+// and in the Prometheus text format. Dashboards read the aggregator's
+// snapshot, so they never scan DynamoDB themselves.
+//
+// Prometheus metrics and their labels:
+//
+//	edge_fleet_devices{tenant, group, region, version, update_status}
+//	edge_device_up{tenant, device, group, region, version}
+//	edge_device_healthy{tenant, device, group, region}
+//	edge_device_health_score{tenant, device, group, region}
+//	edge_device_last_seen_timestamp_seconds{tenant, device}
+//	edge_device_clock_drift_seconds{tenant, device}
+//	edge_rollout_phase{tenant, rollout, version, status}
+//	edge_rollout_completion_percent{tenant, rollout, version, status}
+//	edge_rollout_devices{tenant, rollout, state}
+//	edge_status_generated_timestamp_seconds
+//
+// tenant is empty for single-tenant fleets and device is the ID without its
+// tenant. Unknown groups, regions and versions are "ungrouped", "unknown" and
+// "unknown", as in the fleet view. edge_device_healthy and
+// edge_device_health_score are omitted for devices that never reported them,
+// as is edge_device_clock_drift_seconds for devices without a ClockMonitor.
+type StatusExporter struct {
+	aggregator *Aggregator
+	staleAfter time.Duration
+}
+
+// StatusExporterConfig contains configuration for the StatusExporter
+type StatusExporterConfig struct {
+	Aggregator *Aggregator
+	StaleAfter time.Duration // defaults to 15 minutes, as for health scores
+}
+
+// NewStatusExporter creates a new StatusExporter
+func NewStatusExporter(config StatusExporterConfig) *StatusExporter {
+	if config.StaleAfter == 0 {
+		config.StaleAfter = 15 * time.Minute
+	}
+
+	return &StatusExporter{
+		aggregator: config.Aggregator,
+		staleAfter: config.StaleAfter,
+	}
+}
+
+// Export returns the status of one tenant's devices and rollouts; the empty
+// tenant gets the whole fleet
+func (se *StatusExporter) Export(tenantID string, now time.Time) *StatusExport {
+	view := se.aggregator.ViewFor(tenantID)
+	devices := se.aggregator.DevicesFor(tenantID)
+
+	export := &StatusExport{
+		SchemaVersion: StatusSchemaVersion,
+		GeneratedAt:   view.GeneratedAt,
+		Fleet: FleetStatus{
+			TotalDevices:   len(devices),
+			Versions:       make(map[string]int),
+			Groups:         make(map[string]int),
+			UpdateStatuses: make(map[string]int),
+		},
+		Rollouts: view.Rollouts,
+		Devices:  make([]DeviceStatus, 0, len(devices)),
+	}
+
+	for _, device := range devices {
+		status := se.deviceStatus(device, now)
+		export.Devices = append(export.Devices, status)
+
+		switch {
+		case status.Healthy == nil:
+		case *status.Healthy:
+			export.Fleet.HealthyDevices++
+		default:
+			export.Fleet.UnhealthyDevices++
+		}
+		if status.Stale {
+			export.Fleet.StaleDevices++
+		}
+
+		export.Fleet.Versions[status.Version]++
+		export.Fleet.Groups[status.Group]++
+		if status.UpdateStatus != "" {
+			export.Fleet.UpdateStatuses[status.UpdateStatus]++
+		}
+	}
+
+	return export
+}
+
+// WriteMetrics writes an export in the Prometheus text exposition format
+func (se *StatusExporter) WriteMetrics(w io.Writer, export *StatusExport) error {
+	mw := &metricWriter{w: w}
+
+	// Devices are sorted by ID, so count label sets in order of first appearance
+	type fleetKey struct{ tenant, group, region, version, updateStatus string }
+	fleet := make(map[fleetKey]int)
+	keys := make([]fleetKey, 0)
+	for _, device := range export.Devices {
+		key := fleetKey{device.TenantID, device.Group, device.Region, device.Version, device.UpdateStatus}
+		if _, ok := fleet[key]; !ok {
+			keys = append(keys, key)
+		}
+		fleet[key]++
+	}
+
+	mw.header("edge_fleet_devices", "Devices by tenant, group, region, version and update status")
+	for _, key := range keys {
+		mw.sample("edge_fleet_devices", float64(fleet[key]),
+			"tenant", key.tenant, "group", key.group, "region", key.region, "version", key.version, "update_status", key.updateStatus)
+	}
+
+	mw.header("edge_device_up", "1 when the device was seen recently, 0 when it is stale")
+	for _, device := range export.Devices {
+		mw.sample("edge_device_up", boolValue(!device.Stale),
+			"tenant", device.TenantID, "device", device.DeviceID, "group", device.Group, "region", device.Region, "version", device.Version)
+	}
+
+	mw.header("edge_device_healthy", "1 when the device's last health check passed")
+	for _, device := range export.Devices {
+		if device.Healthy != nil {
+			mw.sample("edge_device_healthy", boolValue(*device.Healthy),
+				"tenant", device.TenantID, "device", device.DeviceID, "group", device.Group, "region", device.Region)
+		}
+	}
+
+	mw.header("edge_device_health_score", "Device health score from 0 to 100")
+	for _, device := range export.Devices {
+		if device.HealthScore != nil {
+			mw.sample("edge_device_health_score", *device.HealthScore,
+				"tenant", device.TenantID, "device", device.DeviceID, "group", device.Group, "region", device.Region)
+		}
+	}
+
+	mw.header("edge_device_last_seen_timestamp_seconds", "Unix time the device was last seen")
+	for _, device := range export.Devices {
+		if device.LastSeen != nil {
+			mw.sample("edge_device_last_seen_timestamp_seconds", float64(device.LastSeen.Unix()),
+				"tenant", device.TenantID, "device", device.DeviceID)
+		}
+	}
+
+	mw.header("edge_device_clock_drift_seconds", "Device clock minus server time; positive when the device is ahead")
+	for _, device := range export.Devices {
+		if device.ClockDriftMs != nil {
+			mw.sample("edge_device_clock_drift_seconds", float64(*device.ClockDriftMs)/1000,
+				"tenant", device.TenantID, "device", device.DeviceID)
+		}
+	}
+
+	mw.header("edge_rollout_phase", "Current phase of an active rollout, from 0")
+	for _, progress := range export.Rollouts {
+		mw.sample("edge_rollout_phase", float64(progress.CurrentPhase),
+			"tenant", progress.TenantID, "rollout", progress.ID, "version", progress.Version, "status", progress.Status)
+	}
+
+	mw.header("edge_rollout_completion_percent", "Percentage of targeted devices on the rollout's version")
+	for _, progress := range export.Rollouts {
+		mw.sample("edge_rollout_completion_percent", progress.CompletionPercent,
+			"tenant", progress.TenantID, "rollout", progress.ID, "version", progress.Version, "status", progress.Status)
+	}
+
+	mw.header("edge_rollout_devices", "Devices of an active rollout by state: targeted, on_version, succeeded or failed")
+	for _, progress := range export.Rollouts {
+		for _, state := range []struct {
+			name  string
+			count int
+		}{
+			{"targeted", progress.DevicesTargeted},
+			{"on_version", progress.DevicesOnVersion},
+			{"succeeded", progress.DevicesSucceeded},
+			{"failed", progress.DevicesFailed},
+			{"integrity_failed", progress.DevicesRefused},
+		} {
+			mw.sample("edge_rollout_devices", float64(state.count),
+				"tenant", progress.TenantID, "rollout", progress.ID, "state", state.name)
+		}
+	}
+
+	mw.header("edge_status_generated_timestamp_seconds", "Unix time the fleet view was computed")
+	mw.sample("edge_status_generated_timestamp_seconds", float64(export.GeneratedAt.Unix()))
+
+	return mw.err
+}
+
+// RegisterRoutes registers the status export, the Grafana JSON datasource API
+// under /api/grafana/ and the Prometheus metrics on the server
+func (se *StatusExporter) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/export/status", http.HandlerFunc(se.handleStatus))
+	s.Handle("GET /api/export/metrics", http.HandlerFunc(se.handleMetrics))
+	s.Handle("GET /api/grafana/{$}", http.HandlerFunc(se.handleGrafanaHealth))
+	s.Handle("POST /api/grafana/search", http.HandlerFunc(se.handleGrafanaSearch))
+	s.Handle("POST /api/grafana/metrics", http.HandlerFunc(se.handleGrafanaMetrics))
+	s.Handle("POST /api/grafana/query", http.HandlerFunc(se.handleGrafanaQuery))
+}
+
+// handleStatus serves the caller's StatusExport
+func (se *StatusExporter) handleStatus(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, se.Export(tenant.FromContext(r.Context()), time.Now().UTC()))
+}
+
+// handleMetrics serves the caller's status for a Prometheus scrape
+func (se *StatusExporter) handleMetrics(w http.ResponseWriter, r *http.Request) {
+	export := se.Export(tenant.FromContext(r.Context()), time.Now().UTC())
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	if err := se.WriteMetrics(w, export); err != nil {
+		log.Printf("Failed to write metrics: %v", err)
+	}
+}
+
+// handleGrafanaHealth answers the datasource's connection test
+func (se *StatusExporter) handleGrafanaHealth(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]string{"status": "ok"})
+}
+
+// handleGrafanaSearch lists the targets for datasource versions that search
+func (se *StatusExporter) handleGrafanaSearch(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, grafanaTargets)
+}
+
+// handleGrafanaMetrics lists the targets for datasource versions that ask for metrics
+func (se *StatusExporter) handleGrafanaMetrics(w http.ResponseWriter, r *http.Request) {
+	metrics := make([]map[string]string, 0, len(grafanaTargets))
+	for _, target := range grafanaTargets {
+		metrics = append(metrics, map[string]string{"label": target, "value": target})
+	}
+	writeJSON(w, http.StatusOK, metrics)
+}
+
+// handleGrafanaQuery answers a panel's queries. The view is a snapshot, so
+// each time series has a single point at the time it was computed; Grafana
+// keeps history when the panel's data is recorded, e.g. by Prometheus.
+func (se *StatusExporter) handleGrafanaQuery(w http.ResponseWriter, r *http.Request) {
+	var query struct {
+		Targets []struct {
+			Target string `json:"target"`
+			Hide   bool   `json:"hide"`
+		} `json:"targets"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&query); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid query: "+err.Error())
+		return
+	}
+
+	export := se.Export(tenant.FromContext(r.Context()), time.Now().UTC())
+
+	results := make([]interface{}, 0, len(query.Targets))
+	for _, target := range query.Targets {
+		if target.Hide {
+			continue
+		}
+
+		result, err := grafanaResult(export, target.Target)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		results = append(results, result...)
+	}
+
+	writeJSON(w, http.StatusOK, results)
+}
+
+// deviceStatus converts a device record to its exported status
+func (se *StatusExporter) deviceStatus(device DeviceRecord, now time.Time) DeviceStatus {
+	tenantID, deviceID := tenant.Split(device.DeviceID)
+
+	status := DeviceStatus{
+		DeviceID:      deviceID,
+		TenantID:      tenantID,
+		Group:         valueOr(device.DeviceGroup, "ungrouped"),
+		Region:        valueOr(device.Region, "unknown"),
+		Version:       valueOr(device.CurrentVersion, "unknown"),
+		ConfigVersion: device.ConfigVersion,
+		UpdateStatus:  device.UpdateStatus,
+		LastUpdateID:  device.LastUpdateID,
+		Healthy:       device.Healthy,
+		HealthScore:   device.HealthScore,
+		Stale:         true,
+		ClockDriftMs:  device.ClockDriftMs,
+	}
+
+	if lastSeen, err := time.Parse(time.RFC3339, device.LastSeen); err == nil {
+		status.LastSeen = &lastSeen
+		status.Stale = now.Sub(lastSeen) > se.staleAfter
+	}
+
+	return status
+}
+
+// Helper functions
+
+// grafanaSeries is a time series in the Grafana JSON datasource format; each
+// point is [value, Unix milliseconds]
+type grafanaSeries struct {
+	Target     string       `json:"target"`
+	Datapoints [][2]float64 `json:"datapoints"`
+}
+
+// grafanaTable is a table in the Grafana JSON datasource format
+type grafanaTable struct {
+	Type    string              `json:"type"`
+	Columns []map[string]string `json:"columns"`
+	Rows    [][]interface{}     `json:"rows"`
+}
+
+// grafanaResult answers one datasource target from an export
+func grafanaResult(export *StatusExport, target string) ([]interface{}, error) {
+	at := float64(export.GeneratedAt.UnixMilli())
+	point := func(name string, value float64) interface{} {
+		return grafanaSeries{Target: name, Datapoints: [][2]float64{{value, at}}}
+	}
+	counts := func(values map[string]int) []interface{} {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		series := make([]interface{}, 0, len(names))
+		for _, name := range names {
+			series = append(series, point(name, float64(values[name])))
+		}
+		return series
+	}
+
+	switch target {
+	case "devices":
+		return []interface{}{point(target, float64(export.Fleet.TotalDevices))}, nil
+	case "devices_healthy":
+		return []interface{}{point(target, float64(export.Fleet.HealthyDevices))}, nil
+	case "devices_unhealthy":
+		return []interface{}{point(target, float64(export.Fleet.UnhealthyDevices))}, nil
+	case "devices_stale":
+		return []interface{}{point(target, float64(export.Fleet.StaleDevices))}, nil
+	case "devices_by_version":
+		return counts(export.Fleet.Versions), nil
+	case "devices_by_group":
+		return counts(export.Fleet.Groups), nil
+	case "devices_by_update_status":
+		return counts(export.Fleet.UpdateStatuses), nil
+	case "rollout_completion":
+		series := make([]interface{}, 0, len(export.Rollouts))
+		for _, progress := range export.Rollouts {
+			series = append(series, point(progress.ID, progress.CompletionPercent))
+		}
+		return series, nil
+	case "device_table":
+		table := grafanaTable{
+			Type: "table",
+			Columns: grafanaColumns("Device", "string", "Tenant", "string", "Group", "string", "Region", "string",
+				"Version", "string", "Update status", "string", "Healthy", "string", "Health score", "number", "Last seen", "time"),
+			Rows: make([][]interface{}, 0, len(export.Devices)),
+		}
+		for _, device := range export.Devices {
+			var healthy, lastSeen interface{}
+			if device.Healthy != nil {
+				healthy = fmt.Sprint(*device.Healthy)
+			}
+			if device.LastSeen != nil {
+				lastSeen = device.LastSeen.UnixMilli()
+			}
+			table.Rows = append(table.Rows, []interface{}{device.DeviceID, device.TenantID, device.Group, device.Region,
+				device.Version, device.UpdateStatus, healthy, device.HealthScore, lastSeen})
+		}
+		return []interface{}{table}, nil
+	case "rollout_table":
+		table := grafanaTable{
+			Type: "table",
+			Columns: grafanaColumns("Rollout", "string", "Tenant", "string", "Version", "string", "Status", "string",
+				"Phase", "number", "Phases", "number", "Targeted", "number", "Failed", "number", "Complete %", "number"),
+			Rows: make([][]interface{}, 0, len(export.Rollouts)),
+		}
+		for _, progress := range export.Rollouts {
+			table.Rows = append(table.Rows, []interface{}{progress.ID, progress.TenantID, progress.Version, progress.Status,
+				progress.CurrentPhase, progress.TotalPhases, progress.DevicesTargeted, progress.DevicesFailed, progress.CompletionPercent})
+		}
+		return []interface{}{table}, nil
+	default:
+		return nil, fmt.Errorf("unknown target %q", target)
+	}
+}
+
+// grafanaColumns builds table columns from alternating names and types
+func grafanaColumns(namesAndTypes ...string) []map[string]string {
+	columns := make([]map[string]string, 0, len(namesAndTypes)/2)
+	for i := 0; i+1 < len(namesAndTypes); i += 2 {
+		columns = append(columns, map[string]string{"text": namesAndTypes[i], "type": namesAndTypes[i+1]})
+	}
+	return columns
+}
+
+// labelEscaper escapes the characters the text format requires in label values
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// metricWriter writes Prometheus text samples, keeping the first error
+type metricWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (mw *metricWriter) header(name, help string) {
+	mw.printf("# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+}
+
+// sample writes one sample; labels alternate names and values
+func (mw *metricWriter) sample(name string, value float64, labels ...string) {
+	pairs := make([]string, 0, len(labels)/2)
+	for i := 0; i+1 < len(labels); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, labels[i], labelEscaper.Replace(labels[i+1])))
+	}
+
+	if len(pairs) == 0 {
+		mw.printf("%s %s\n", name, strconv.FormatFloat(value, 'f', -1, 64))
+		return
+	}
+	mw.printf("%s{%s} %s\n", name, strings.Join(pairs, ","), strconv.FormatFloat(value, 'f', -1, 64))
+}
+
+func (mw *metricWriter) printf(format string, args ...interface{}) {
+	if mw.err != nil {
+		return
+	}
+	_, mw.err = fmt.Fprintf(mw.w, format, args...)
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,319 @@
+package fleetserver
+
+import (
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Device event types published to status stream subscribers
+const (
+	EventUpdate       = "update"       // update progress reported by a device
+	EventVersion      = "version"      // the device's current version changed
+	EventHealth       = "health"       // the device became healthy or unhealthy
+	EventSync         = "sync"         // an offline sync completed or failed
+	EventConnected    = "connected"    // an agent opened a gRPC stream
+	EventDisconnected = "disconnected" // an agent's gRPC stream ended
+)
+
+// DeviceEvent is one device status change
+type DeviceEvent struct {
+	Type        string    `json:"type"`
+	DeviceID    string    `json:"deviceId"`
+	DeviceGroup string    `json:"deviceGroup,omitempty"`
+	TenantID    string    `json:"tenantId,omitempty"`
+	RolloutID   string    `json:"rolloutId,omitempty"`
+	Version     string    `json:"version,omitempty"`
+	Status      string    `json:"status,omitempty"`
+	Healthy     *bool     `json:"healthy,omitempty"`
+	Message     string    `json:"message,omitempty"`
+	Timestamp   time.Time `json:"timestamp"`
+}
+
+// StatusHub fans device events out to WebSocket subscribers. Events come
+// from the AgentGateway as agents report them and from the Aggregator's
+// table diffs for devices that write to DynamoDB directly.
+type StatusHub struct {
+	upgrader     websocket.Upgrader
+	subscribers  map[*subscriber]bool
+	live         map[string]bool // devices publishing directly through the AgentGateway
+	mutex        sync.Mutex
+	bufferSize   int
+	pingInterval time.Duration
+	exporter     *EventExporter
+}
+
+// StatusHubConfig contains configuration for the StatusHub
+type StatusHubConfig struct {
+	AllowedOrigins []string // same-origin only when empty
+	BufferSize     int      // events queued per subscriber before it is dropped
+	PingInterval   time.Duration
+	Exporter       *EventExporter // receives every published event when set
+}
+
+// subscriber is one WebSocket connection and its filters
+type subscriber struct {
+	events  chan DeviceEvent
+	tenant  string
+	devices map[string]bool
+	groups  map[string]bool
+	types   map[string]bool
+}
+
+// NewStatusHub creates a new StatusHub
+func NewStatusHub(config StatusHubConfig) *StatusHub {
+	if config.BufferSize == 0 {
+		config.BufferSize = 256
+	}
+	if config.PingInterval == 0 {
+		config.PingInterval = 30 * time.Second
+	}
+
+	h := &StatusHub{
+		subscribers:  make(map[*subscriber]bool),
+		live:         make(map[string]bool),
+		bufferSize:   config.BufferSize,
+		pingInterval: config.PingInterval,
+		exporter:     config.Exporter,
+	}
+
+	h.upgrader = websocket.Upgrader{
+		ReadBufferSize:  1024,
+		WriteBufferSize: 4096,
+	}
+	if len(config.AllowedOrigins) > 0 {
+		allowed := make(map[string]bool, len(config.AllowedOrigins))
+		for _, origin := range config.AllowedOrigins {
+			allowed[origin] = true
+		}
+		h.upgrader.CheckOrigin = func(r *http.Request) bool {
+			return allowed[r.Header.Get("Origin")]
+		}
+	}
+
+	return h
+}
+
+// Publish delivers an event to every matching subscriber without blocking;
+// subscribers that fall too far behind are disconnected
+func (h *StatusHub) Publish(event DeviceEvent) {
+	if h == nil {
+		return
+	}
+	if event.Timestamp.IsZero() {
+		event.Timestamp = time.Now().UTC()
+	}
+	if h.exporter != nil {
+		h.exporter.RecordDevice(event)
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for sub := range h.subscribers {
+		if !sub.matches(event) {
+			continue
+		}
+
+		select {
+		case sub.events <- event:
+		default:
+			log.Printf("Dropping slow status stream subscriber")
+			delete(h.subscribers, sub)
+			close(sub.events)
+		}
+	}
+}
+
+// SetLive marks a device as publishing its own events, so table diffs for it are suppressed
+func (h *StatusHub) SetLive(deviceID string, live bool) {
+	if h == nil {
+		return
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if live {
+		h.live[deviceID] = true
+	} else {
+		delete(h.live, deviceID)
+	}
+}
+
+// IsLive reports whether a device is publishing its own events
+func (h *StatusHub) IsLive(deviceID string) bool {
+	if h == nil {
+		return false
+	}
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.live[deviceID]
+}
+
+// RegisterRoutes registers the status stream endpoint on the server
+func (h *StatusHub) RegisterRoutes(s *Server) {
+	s.Handle("GET /api/stream/devices", http.HandlerFunc(h.handleStream))
+}
+
+// handleStream upgrades to a WebSocket and streams matching events as JSON
+// messages; filter with ?device=, ?group= and ?type= (comma separated)
+func (h *StatusHub) handleStream(w http.ResponseWriter, r *http.Request) {
+	conn, err := h.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		// Upgrade has already written an HTTP error
+		log.Printf("Failed to upgrade status stream: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	query := r.URL.Query()
+	sub := &subscriber{
+		events:  make(chan DeviceEvent, h.bufferSize),
+		tenant:  tenant.FromContext(r.Context()),
+		devices: splitFilter(query.Get("device")),
+		groups:  splitFilter(query.Get("group")),
+		types:   splitFilter(query.Get("type")),
+	}
+
+	h.mutex.Lock()
+	h.subscribers[sub] = true
+	h.mutex.Unlock()
+
+	defer h.unsubscribe(sub)
+
+	// Read until the client goes away; clients send nothing but control frames
+	closed := make(chan struct{})
+	go func() {
+		defer close(closed)
+		conn.SetReadLimit(512)
+		conn.SetReadDeadline(time.Now().Add(2 * h.pingInterval))
+		conn.SetPongHandler(func(string) error {
+			return conn.SetReadDeadline(time.Now().Add(2 * h.pingInterval))
+		})
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(h.pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closed:
+			return
+		case event, ok := <-sub.events:
+			if !ok {
+				conn.WriteControl(websocket.CloseMessage,
+					websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "subscriber too slow"),
+					time.Now().Add(time.Second))
+				return
+			}
+			conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := conn.WriteJSON(event); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(10*time.Second)); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// unsubscribe removes a subscriber unless Publish already dropped it
+func (h *StatusHub) unsubscribe(sub *subscriber) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	if h.subscribers[sub] {
+		delete(h.subscribers, sub)
+		close(sub.events)
+	}
+}
+
+// matches reports whether an event passes the subscriber's filters
+func (sub *subscriber) matches(event DeviceEvent) bool {
+	if !tenant.Allowed(sub.tenant, event.TenantID) {
+		return false
+	}
+	if len(sub.devices) > 0 && !sub.devices[event.DeviceID] {
+		return false
+	}
+	if len(sub.groups) > 0 && !sub.groups[event.DeviceGroup] {
+		return false
+	}
+	if len(sub.types) > 0 && !sub.types[event.Type] {
+		return false
+	}
+	return true
+}
+
+// Helper functions
+
+// diffDevices derives events from two scans of the device table
+func diffDevices(previous map[string]DeviceRecord, devices []DeviceRecord) []DeviceEvent {
+	events := make([]DeviceEvent, 0)
+
+	for _, device := range devices {
+		before, known := previous[device.DeviceID]
+		if !known {
+			// First sighting; report nothing until there is a baseline
+			continue
+		}
+
+		event := DeviceEvent{DeviceID: device.DeviceID, DeviceGroup: device.DeviceGroup, TenantID: device.Tenant()}
+
+		if device.UpdateStatus != before.UpdateStatus || device.LastUpdateTime != before.LastUpdateTime {
+			update := event
+			update.Type = EventUpdate
+			update.RolloutID = device.LastUpdateID
+			update.Status = device.UpdateStatus
+			update.Message = device.LastUpdateMessage
+			events = append(events, update)
+		}
+
+		if device.CurrentVersion != before.CurrentVersion {
+			version := event
+			version.Type = EventVersion
+			version.Version = device.CurrentVersion
+			events = append(events, version)
+		}
+
+		if device.Healthy != nil && (before.Healthy == nil || *before.Healthy != *device.Healthy) {
+			health := event
+			health.Type = EventHealth
+			health.Healthy = device.Healthy
+			events = append(events, health)
+		}
+
+		if device.LastSyncTime != before.LastSyncTime {
+			syncEvent := event
+			syncEvent.Type = EventSync
+			syncEvent.Status = device.LastSyncStatus
+			events = append(events, syncEvent)
+		}
+	}
+
+	return events
+}
+
+func splitFilter(value string) map[string]bool {
+	filter := make(map[string]bool)
+	for _, part := range strings.Split(value, ",") {
+		if part = strings.TrimSpace(part); part != "" {
+			filter[part] = true
+		}
+	}
+	return filter
+}
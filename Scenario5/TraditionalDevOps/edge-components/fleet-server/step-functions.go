@@ -0,0 +1,809 @@
+package fleetserver
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/sfn"
+	sfntypes "github.com/aws/aws-sdk-go-v2/service/sfn/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/notify"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// Actions the state machine asks the activity worker to perform, in the
+// "action" field of each task's input
+const (
+	stepStartPhase    = "start-phase"
+	stepAwaitApproval = "await-approval"
+	stepAnalyze       = "analyze"
+	stepComplete      = "complete"
+	stepRollBack      = "roll-back"
+)
+
+// Errors activity tasks fail with; the state machine retries or catches them by name
+const (
+	stepErrorWaiting   = "Rollout.Waiting"   // paused or changed concurrently; retried
+	stepErrorFailed    = "Rollout.Error"     // the fleet server couldn't do the step; retried
+	stepErrorStopped   = "Rollout.Stopped"   // aborted, failed or rolled back outside the state machine
+	stepErrorRegressed = "Rollout.Regressed" // the canary cohort regressed; the rollout is rolled back
+	stepErrorInvalid   = "Rollout.Invalid"   // the task input doesn't match the rollout
+)
+
+// StateMachineTemplate is the Amazon States Language definition of the
+// rollout state machine. ${ActivityArn} is the activity the fleet server
+// polls, e.g. substituted through DefinitionSubstitutions in CloudFormation;
+// StateMachineDefinition fills it in. Each execution walks the rollout's
+// phases in order: it waits for approval where a phase requires one, starts
+// the phase, soaks it for its duration and analyses the canary cohort before
+// moving on, then completes the rollout or rolls it back on a regression.
+const StateMachineTemplate = `{
+  "Comment": "Progressive rollout phases, driven by the fleet server's StepFunctionsController",
+  "StartAt": "Phases",
+  "States": {
+    "Phases": {
+      "Type": "Map",
+      "ItemsPath": "$.phases",
+      "MaxConcurrency": 1,
+      "ItemSelector": {
+        "rolloutId.$": "$.rolloutId",
+        "phase.$": "$$.Map.Item.Value"
+      },
+      "ItemProcessor": {
+        "ProcessorConfig": {"Mode": "INLINE"},
+        "StartAt": "NeedsApproval",
+        "States": {
+          "NeedsApproval": {
+            "Type": "Choice",
+            "Choices": [{"Variable": "$.phase.requireApproval", "BooleanEquals": true, "Next": "AwaitApproval"}],
+            "Default": "StartPhase"
+          },
+          "AwaitApproval": {
+            "Type": "Task",
+            "Resource": "${ActivityArn}",
+            "Parameters": {"action": "await-approval", "rolloutId.$": "$.rolloutId", "phase.$": "$.phase.index"},
+            "HeartbeatSeconds": 300,
+            "ResultPath": null,
+            "Retry": [{"ErrorEquals": ["States.Timeout", "Rollout.Error"], "IntervalSeconds": 30, "MaxAttempts": 10000, "BackoffRate": 1}],
+            "Next": "StartPhase"
+          },
+          "StartPhase": {
+            "Type": "Task",
+            "Resource": "${ActivityArn}",
+            "Parameters": {"action": "start-phase", "rolloutId.$": "$.rolloutId", "phase.$": "$.phase.index"},
+            "TimeoutSeconds": 300,
+            "ResultPath": "$.started",
+            "Retry": [{"ErrorEquals": ["States.Timeout", "Rollout.Waiting", "Rollout.Error"], "IntervalSeconds": 60, "MaxAttempts": 1000, "BackoffRate": 1.5, "MaxDelaySeconds": 900}],
+            "Next": "Soak"
+          },
+          "Soak": {
+            "Type": "Wait",
+            "SecondsPath": "$.phase.waitSeconds",
+            "Next": "Analyze"
+          },
+          "Analyze": {
+            "Type": "Task",
+            "Resource": "${ActivityArn}",
+            "Parameters": {"action": "analyze", "rolloutId.$": "$.rolloutId", "phase.$": "$.phase.index"},
+            "TimeoutSeconds": 300,
+            "ResultPath": "$.analysis",
+            "Retry": [{"ErrorEquals": ["States.Timeout", "Rollout.Waiting", "Rollout.Error"], "IntervalSeconds": 60, "MaxAttempts": 1000, "BackoffRate": 1.5, "MaxDelaySeconds": 900}],
+            "End": true
+          }
+        }
+      },
+      "ResultPath": null,
+      "Catch": [
+        {"ErrorEquals": ["Rollout.Regressed"], "ResultPath": "$.error", "Next": "RollBack"},
+        {"ErrorEquals": ["States.ALL"], "ResultPath": "$.error", "Next": "Stopped"}
+      ],
+      "Next": "Complete"
+    },
+    "Complete": {
+      "Type": "Task",
+      "Resource": "${ActivityArn}",
+      "Parameters": {"action": "complete", "rolloutId.$": "$.rolloutId"},
+      "TimeoutSeconds": 300,
+      "ResultPath": null,
+      "Retry": [{"ErrorEquals": ["States.Timeout", "Rollout.Waiting", "Rollout.Error"], "IntervalSeconds": 60, "MaxAttempts": 1000, "BackoffRate": 1.5, "MaxDelaySeconds": 900}],
+      "End": true
+    },
+    "RollBack": {
+      "Type": "Task",
+      "Resource": "${ActivityArn}",
+      "Parameters": {"action": "roll-back", "rolloutId.$": "$.rolloutId", "cause.$": "$.error.Cause"},
+      "TimeoutSeconds": 300,
+      "ResultPath": null,
+      "Retry": [{"ErrorEquals": ["States.Timeout", "Rollout.Error"], "IntervalSeconds": 60, "MaxAttempts": 100, "BackoffRate": 1.5, "MaxDelaySeconds": 900}],
+      "Next": "RolledBack"
+    },
+    "RolledBack": {
+      "Type": "Fail",
+      "Error": "Rollout.RolledBack",
+      "CausePath": "$.error.Cause"
+    },
+    "Stopped": {
+      "Type": "Fail",
+      "Error": "Rollout.Stopped",
+      "CausePath": "$.error.Cause"
+    }
+  }
+}
+`
+
+// StateMachineDefinition returns StateMachineTemplate for the activity with ARN activityARN
+func StateMachineDefinition(activityARN string) string {
+	return strings.ReplaceAll(StateMachineTemplate, "${ActivityArn}", activityARN)
+}
+
+// StepFunctionsController hands the phase progression of selected rollouts to
+// an AWS Step Functions state machine defined by StateMachineTemplate, for
+// teams that want to see and audit each rollout's waits, approvals and
+// analyses in the Step Functions console. The controller starts one execution
+// per rollout and works the execution's activity tasks, updating the rollout
+// record as each step completes. The PhaseController keeps enforcing failure
+// thresholds, canary regressions and anomalies on orchestrated rollouts, but
+// leaves ending their phases to the execution.
+type StepFunctionsController struct {
+	dynamoClient     *dynamodb.Client
+	sfnClient        *sfn.Client
+	deviceTableName  string
+	rolloutTableName string
+	stateMachineARN  string
+	activityARN      string
+	workerName       string
+	analyzer         *CanaryAnalyzer
+	notifier         notify.Notifier
+	auditLog         *AuditLog
+	approvals        map[string]stepTask // task token -> approval it waits for
+	approvalMutex    sync.Mutex
+	approvalInterval time.Duration
+	approvalTimer    *time.Timer
+	retryInterval    time.Duration
+	cancel           context.CancelFunc
+	done             chan struct{}
+}
+
+// StepFunctionsControllerConfig contains configuration for the StepFunctionsController
+type StepFunctionsControllerConfig struct {
+	DynamoClient     *dynamodb.Client
+	SFNClient        *sfn.Client // its HTTP client must allow the 60-second activity long poll
+	DeviceTableName  string
+	RolloutTableName string
+	StateMachineARN  string
+	ActivityARN      string
+	WorkerName       string          // defaults to the hostname
+	Analyzer         *CanaryAnalyzer // optional; without it analysis steps pass
+	Notifier         notify.Notifier
+	AuditLog         *AuditLog
+	ApprovalInterval time.Duration // how often waiting approvals are checked; defaults to 30 seconds
+	RetryInterval    time.Duration // pause after a failed poll; defaults to 30 seconds
+}
+
+// stepTask is the input of an activity task, set by the state machine's Parameters
+type stepTask struct {
+	Action    string `json:"action"`
+	RolloutID string `json:"rolloutId"`
+	Phase     int    `json:"phase"`
+	Cause     string `json:"cause,omitempty"`
+}
+
+// stepResult is the output of a successful activity task
+type stepResult struct {
+	Status  string    `json:"status"`
+	PhaseID string    `json:"phaseId,omitempty"`
+	Started time.Time `json:"started,omitempty"`
+	Verdict string    `json:"verdict,omitempty"`
+	Reason  string    `json:"reason,omitempty"`
+}
+
+// executionInput is the input of a rollout's execution
+type executionInput struct {
+	RolloutID string           `json:"rolloutId"`
+	Phases    []executionPhase `json:"phases"`
+}
+
+// executionPhase describes one phase to the state machine
+type executionPhase struct {
+	Index           int    `json:"index"`
+	ID              string `json:"id"`
+	RequireApproval bool   `json:"requireApproval"`
+	WaitSeconds     int64  `json:"waitSeconds"`
+}
+
+// stepError fails an activity task with a named error
+type stepError struct {
+	code  string
+	cause string
+}
+
+func (e *stepError) Error() string {
+	return e.code + ": " + e.cause
+}
+
+// NewStepFunctionsController creates a new StepFunctionsController and starts
+// working activity tasks
+func NewStepFunctionsController(config StepFunctionsControllerConfig) (*StepFunctionsController, error) {
+	if config.StateMachineARN == "" || config.ActivityARN == "" {
+		return nil, errors.New("a state machine ARN and an activity ARN are required")
+	}
+	if config.WorkerName == "" {
+		config.WorkerName, _ = os.Hostname()
+	}
+	if config.ApprovalInterval == 0 {
+		config.ApprovalInterval = 30 * time.Second
+	}
+	if config.RetryInterval == 0 {
+		config.RetryInterval = 30 * time.Second
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	sc := &StepFunctionsController{
+		dynamoClient:     config.DynamoClient,
+		sfnClient:        config.SFNClient,
+		deviceTableName:  config.DeviceTableName,
+		rolloutTableName: config.RolloutTableName,
+		stateMachineARN:  config.StateMachineARN,
+		activityARN:      config.ActivityARN,
+		workerName:       config.WorkerName,
+		analyzer:         config.Analyzer,
+		notifier:         config.Notifier,
+		auditLog:         config.AuditLog,
+		approvals:        make(map[string]stepTask),
+		approvalInterval: config.ApprovalInterval,
+		retryInterval:    config.RetryInterval,
+		cancel:           cancel,
+		done:             make(chan struct{}),
+	}
+
+	go sc.poll(ctx)
+
+	// Start the approval timer
+	sc.approvalTimer = time.AfterFunc(sc.approvalInterval, sc.approvalLoop)
+
+	return sc, nil
+}
+
+// Start hands a pending rollout to the state machine: it records the
+// execution on the rollout and starts it, named after the rollout. Starting
+// an orchestrated rollout again only retries starting the same execution.
+func (sc *StepFunctionsController) Start(ctx context.Context, rolloutID, actor string) (*rollout.RolloutPlan, error) {
+	plan, err := GetRollout(ctx, sc.dynamoClient, sc.rolloutTableName, rolloutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.Status != "pending" {
+		return nil, fmt.Errorf("rollout %s is %s; only pending rollouts can be orchestrated", plan.ID, plan.Status)
+	}
+	if plan.ScheduledStart != "" {
+		return nil, fmt.Errorf("rollout %s is scheduled; scheduled rollouts are started by the scheduler", plan.ID)
+	}
+
+	input, err := buildExecutionInput(*plan)
+	if err != nil {
+		return nil, err
+	}
+
+	executionARN := strings.Replace(sc.stateMachineARN, ":stateMachine:", ":execution:", 1) + ":" + plan.ID
+	if err := sc.recordExecution(ctx, plan, executionARN); err != nil {
+		return nil, err
+	}
+
+	_, err = sc.sfnClient.StartExecution(ctx, &sfn.StartExecutionInput{
+		StateMachineArn: aws.String(sc.stateMachineARN),
+		Name:            aws.String(plan.ID),
+		Input:           aws.String(input),
+	})
+	if err != nil {
+		var alreadyExists *sfntypes.ExecutionAlreadyExists
+		if !errors.As(err, &alreadyExists) {
+			return nil, fmt.Errorf("failed to start execution for rollout %s: %w", plan.ID, err)
+		}
+		// An execution with the same input is returned rather than rejected,
+		// so one that differs was started before the rollout changed
+		log.Printf("Execution for rollout %s already exists with different input: %v", plan.ID, err)
+	}
+
+	sc.audit(ctx, actor, "rollout.orchestrated", plan.ID, map[string]string{"execution": executionARN})
+
+	plan.ExecutionARN = executionARN
+	plan.Revision++
+	return plan, nil
+}
+
+// recordExecution sets the execution ARN on a pending rollout still at the
+// revision it was read at, unless another execution drives it already
+func (sc *StepFunctionsController) recordExecution(ctx context.Context, plan *rollout.RolloutPlan, executionARN string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(sc.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: plan.ID},
+		},
+		UpdateExpression:    aws.String("SET ExecutionARN = :execution, UpdatedAt = :time"),
+		ConditionExpression: aws.String("#status = :pending AND (attribute_not_exists(ExecutionARN) OR ExecutionARN = :execution)"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":execution": &types.AttributeValueMemberS{Value: executionARN},
+			":pending":   &types.AttributeValueMemberS{Value: "pending"},
+			":time":      &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	requireRevision(input, plan.Revision)
+
+	_, err := sc.dynamoClient.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return fmt.Errorf("failed to record execution for rollout %s: %w", plan.ID, ErrRolloutChanged)
+		}
+		return fmt.Errorf("failed to record execution for rollout %s: %w", plan.ID, err)
+	}
+
+	return nil
+}
+
+// poll works activity tasks until the controller is closed
+func (sc *StepFunctionsController) poll(ctx context.Context) {
+	defer close(sc.done)
+
+	for ctx.Err() == nil {
+		result, err := sc.sfnClient.GetActivityTask(ctx, &sfn.GetActivityTaskInput{
+			ActivityArn: aws.String(sc.activityARN),
+			WorkerName:  aws.String(sc.workerName),
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to get rollout activity task: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(sc.retryInterval):
+			}
+			continue
+		}
+
+		// No token means the long poll ended without a task
+		token := aws.ToString(result.TaskToken)
+		if token == "" {
+			continue
+		}
+
+		sc.handle(ctx, token, aws.ToString(result.Input))
+	}
+}
+
+// handle performs one activity task and reports its outcome, except for
+// approvals that are still outstanding, which approvalLoop reports later
+func (sc *StepFunctionsController) handle(ctx context.Context, token, input string) {
+	var task stepTask
+	if err := json.Unmarshal([]byte(input), &task); err != nil {
+		sc.respond(ctx, token, nil, &stepError{code: stepErrorInvalid, cause: "unreadable task input: " + err.Error()})
+		return
+	}
+
+	var result *stepResult
+	var err error
+	switch task.Action {
+	case stepStartPhase:
+		result, err = sc.startPhase(ctx, task)
+
+	case stepAwaitApproval:
+		result, err = sc.checkApproval(ctx, task)
+		if err == nil && result == nil {
+			sc.approvalMutex.Lock()
+			sc.approvals[token] = task
+			sc.approvalMutex.Unlock()
+			return
+		}
+
+	case stepAnalyze:
+		result, err = sc.analyze(ctx, task)
+
+	case stepComplete:
+		result, err = sc.complete(ctx, task)
+
+	case stepRollBack:
+		result, err = sc.rollBack(ctx, task)
+
+	default:
+		err = &stepError{code: stepErrorInvalid, cause: "unknown action " + task.Action}
+	}
+
+	sc.respond(ctx, token, result, err)
+}
+
+// startPhase moves the rollout into the task's phase and starts its clock;
+// the first phase also starts the rollout
+func (sc *StepFunctionsController) startPhase(ctx context.Context, task stepTask) (*stepResult, error) {
+	plan, err := sc.loadRollout(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	if plan.Status == "pending" && task.Phase == 0 {
+		if err := startRollout(ctx, sc.dynamoClient, sc.rolloutTableName, plan.ID, plan.Revision, now); err != nil {
+			return nil, err
+		}
+		if plan, err = sc.loadRollout(ctx, task); err != nil {
+			return nil, err
+		}
+	}
+	if plan.Status != "in-progress" {
+		return nil, &stepError{code: stepErrorWaiting, cause: fmt.Sprintf("rollout %s is %s", plan.ID, plan.Status)}
+	}
+
+	phase := plan.Phases[task.Phase]
+	switch {
+	case plan.CurrentPhase == task.Phase-1:
+		if err := setCurrentPhase(ctx, sc.dynamoClient, sc.rolloutTableName, plan.ID, plan.Revision, plan.CurrentPhase, now); err != nil {
+			return nil, err
+		}
+	case plan.CurrentPhase == task.Phase && phase.StartTime.IsZero():
+		if err := setPhaseStart(ctx, sc.dynamoClient, sc.rolloutTableName, plan.ID, plan.Revision, task.Phase, now); err != nil {
+			return nil, err
+		}
+	case plan.CurrentPhase == task.Phase:
+		// Started by an earlier attempt of this task
+		return &stepResult{Status: "started", PhaseID: phase.ID, Started: phase.StartTime}, nil
+	default:
+		return nil, &stepError{code: stepErrorInvalid, cause: fmt.Sprintf("rollout %s is in phase %d, not before phase %d", plan.ID, plan.CurrentPhase, task.Phase)}
+	}
+
+	// Both updates leave a rollout that changed meanwhile alone, so check
+	if plan, err = sc.loadRollout(ctx, task); err != nil {
+		return nil, err
+	}
+	phase = plan.Phases[task.Phase]
+	if plan.CurrentPhase != task.Phase || phase.StartTime.IsZero() {
+		return nil, &stepError{code: stepErrorWaiting, cause: fmt.Sprintf("rollout %s changed while starting phase %s", plan.ID, phase.ID)}
+	}
+
+	sc.audit(ctx, "step-functions", "rollout.phase-started", plan.ID, map[string]string{"phase": phase.ID})
+	if task.Phase > 0 {
+		previous := plan.Phases[task.Phase-1]
+		sc.notify(ctx, notify.Event{
+			Type:      notify.EventPhaseAdvanced,
+			Severity:  notify.SeverityInfo,
+			RolloutID: plan.ID,
+			PhaseID:   phase.ID,
+			Message:   fmt.Sprintf("advanced from phase %s to phase %s (%.0f%%)", previous.ID, phase.ID, phase.Percentage),
+			Details: map[string]string{
+				"version":       plan.Version,
+				"previousPhase": previous.ID,
+				"percentage":    fmt.Sprintf("%.0f", phase.Percentage),
+				"tenant":        plan.TenantID,
+			},
+		})
+	}
+
+	return &stepResult{Status: "started", PhaseID: phase.ID, Started: phase.StartTime}, nil
+}
+
+// checkApproval reports whether the task's phase is approved; a nil result
+// and error mean it is still waiting
+func (sc *StepFunctionsController) checkApproval(ctx context.Context, task stepTask) (*stepResult, error) {
+	plan, err := sc.loadRollout(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+
+	phase := plan.Phases[task.Phase]
+	if !phase.Approved {
+		return nil, nil
+	}
+
+	return &stepResult{Status: "approved", PhaseID: phase.ID, Reason: "approved by " + phase.ApprovedBy}, nil
+}
+
+// analyze compares the canary and control cohorts at the end of the task's
+// phase, records the verdict and fails the task on a regression
+func (sc *StepFunctionsController) analyze(ctx context.Context, task stepTask) (*stepResult, error) {
+	plan, err := sc.loadRollout(ctx, task)
+	if err != nil {
+		return nil, err
+	}
+	if plan.Status != "in-progress" {
+		return nil, &stepError{code: stepErrorWaiting, cause: fmt.Sprintf("rollout %s is %s", plan.ID, plan.Status)}
+	}
+	if plan.CurrentPhase != task.Phase {
+		return nil, &stepError{code: stepErrorInvalid, cause: fmt.Sprintf("rollout %s is in phase %d, not %d", plan.ID, plan.CurrentPhase, task.Phase)}
+	}
+
+	phase := plan.Phases[task.Phase]
+	if sc.analyzer == nil {
+		return &stepResult{Status: "analyzed", PhaseID: phase.ID, Reason: "no canary analyzer configured"}, nil
+	}
+
+	devices, err := ScanDevices(ctx, sc.dynamoClient, sc.deviceTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	analysis, err := sc.analyzer.Analyze(ctx, *plan, devices)
+	if err != nil {
+		return nil, err
+	}
+
+	if analysis.PhaseID != "" && phase.CanaryVerdict != analysis.Verdict {
+		if err := setCanaryVerdict(ctx, sc.dynamoClient, sc.rolloutTableName, plan.ID, task.Phase, analysis.Verdict); err != nil {
+			log.Printf("Failed to record canary verdict for rollout %s: %v", plan.ID, err)
+		}
+	}
+
+	if analysis.Verdict == CanaryFail {
+		return nil, &stepError{code: stepErrorRegressed, cause: "canary cohort regressed against control: " + analysis.Reason}
+	}
+
+	return &stepResult{Status: "analyzed", PhaseID: phase.ID, Verdict: analysis.Verdict, Reason: analysis.Reason}, nil
+}
+
+// complete marks the rollout completed after its final phase
+func (sc *StepFunctionsController) complete(ctx context.Context, task stepTask) (*stepResult, error) {
+	plan, err := GetRollout(ctx, sc.dynamoClient, sc.rolloutTableName, task.RolloutID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch plan.Status {
+	case "completed":
+		return &stepResult{Status: plan.Status}, nil
+	case "in-progress":
+	case "paused":
+		return nil, &stepError{code: stepErrorWaiting, cause: fmt.Sprintf("rollout %s is paused", plan.ID)}
+	default:
+		return nil, &stepError{code: stepErrorStopped, cause: fmt.Sprintf("rollout %s is %s", plan.ID, plan.Status)}
+	}
+
+	if err := updateRolloutStatus(ctx, sc.dynamoClient, sc.rolloutTableName, plan.ID, plan.Revision, "completed"); err != nil {
+		if errors.Is(err, ErrRolloutChanged) {
+			return nil, &stepError{code: stepErrorWaiting, cause: err.Error()}
+		}
+		return nil, err
+	}
+
+	sc.audit(ctx, "step-functions", "rollout.completed", plan.ID, map[string]string{"version": plan.Version})
+	sc.notify(ctx, notify.Event{
+		Type:      notify.EventRolloutCompleted,
+		Severity:  notify.SeverityInfo,
+		RolloutID: plan.ID,
+		Message:   fmt.Sprintf("rollout of version %s completed", plan.Version),
+		Details:   map[string]string{"version": plan.Version, "tenant": plan.TenantID},
+	})
+
+	return &stepResult{Status: "completed"}, nil
+}
+
+// rollBack marks the rollout rolled back after a failed analysis, unless it
+// already stopped
+func (sc *StepFunctionsController) rollBack(ctx context.Context, task stepTask) (*stepResult, error) {
+	plan, err := GetRollout(ctx, sc.dynamoClient, sc.rolloutTableName, task.RolloutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if plan.Status != "in-progress" && plan.Status != "paused" {
+		return &stepResult{Status: plan.Status}, nil
+	}
+
+	if err := updateRolloutStatus(ctx, sc.dynamoClient, sc.rolloutTableName, plan.ID, plan.Revision, "rolled-back"); err != nil {
+		// Retried as a failure; the next attempt reads the rollout again
+		return nil, err
+	}
+
+	details := map[string]string{"reason": task.Cause}
+	sc.audit(ctx, "step-functions", "rollout.rolled-back", plan.ID, details)
+	sc.notify(ctx, notify.Event{
+		Type:      notify.EventRolloutRolledBack,
+		Severity:  notify.SeverityCritical,
+		RolloutID: plan.ID,
+		PhaseID:   plan.Phases[plan.CurrentPhase].ID,
+		Message:   fmt.Sprintf("rollout of version %s rolled back by its state machine", plan.Version),
+		Details:   details,
+	})
+
+	return &stepResult{Status: "rolled-back", Reason: task.Cause}, nil
+}
+
+// approvalLoop reports approvals granted since the last check, heartbeats the
+// rest and reschedules itself
+func (sc *StepFunctionsController) approvalLoop() {
+	defer func() {
+		// Reschedule the check
+		sc.approvalTimer.Reset(sc.approvalInterval)
+	}()
+
+	ctx := context.Background()
+
+	sc.approvalMutex.Lock()
+	waiting := make(map[string]stepTask, len(sc.approvals))
+	for token, task := range sc.approvals {
+		waiting[token] = task
+	}
+	sc.approvalMutex.Unlock()
+
+	for token, task := range waiting {
+		result, err := sc.checkApproval(ctx, task)
+		if err != nil || result != nil {
+			sc.forget(token)
+			sc.respond(ctx, token, result, err)
+			continue
+		}
+
+		if _, err := sc.sfnClient.SendTaskHeartbeat(ctx, &sfn.SendTaskHeartbeatInput{TaskToken: aws.String(token)}); err != nil {
+			var timedOut *sfntypes.TaskTimedOut
+			var missing *sfntypes.TaskDoesNotExist
+			if errors.As(err, &timedOut) || errors.As(err, &missing) {
+				// The execution moved on or stopped; a retry issues a new task
+				sc.forget(token)
+				continue
+			}
+			log.Printf("Failed to heartbeat approval of rollout %s: %v", task.RolloutID, err)
+		}
+	}
+}
+
+// Close stops working activity tasks and waits for an outstanding poll to
+// return. Unanswered approval tasks time out and are issued again to the
+// next worker.
+func (sc *StepFunctionsController) Close() {
+	if sc.approvalTimer != nil {
+		sc.approvalTimer.Stop()
+	}
+	sc.cancel()
+	<-sc.done
+}
+
+// loadRollout loads the task's rollout and checks it can still take the step
+func (sc *StepFunctionsController) loadRollout(ctx context.Context, task stepTask) (*rollout.RolloutPlan, error) {
+	plan, err := GetRollout(ctx, sc.dynamoClient, sc.rolloutTableName, task.RolloutID)
+	if err != nil {
+		return nil, err
+	}
+
+	if task.Phase < 0 || task.Phase >= len(plan.Phases) {
+		return nil, &stepError{code: stepErrorInvalid, cause: fmt.Sprintf("rollout %s has no phase %d", plan.ID, task.Phase)}
+	}
+	switch plan.Status {
+	case "pending", "in-progress", "paused":
+		return plan, nil
+	}
+
+	return nil, &stepError{code: stepErrorStopped, cause: fmt.Sprintf("rollout %s is %s", plan.ID, plan.Status)}
+}
+
+// respond reports the outcome of an activity task
+func (sc *StepFunctionsController) respond(ctx context.Context, token string, result *stepResult, err error) {
+	if err != nil {
+		code, cause := stepErrorFailed, err.Error()
+		var failure *stepError
+		if errors.As(err, &failure) {
+			code, cause = failure.code, failure.cause
+		}
+
+		if _, err := sc.sfnClient.SendTaskFailure(ctx, &sfn.SendTaskFailureInput{
+			TaskToken: aws.String(token),
+			Error:     aws.String(code),
+			Cause:     aws.String(cause),
+		}); err != nil {
+			log.Printf("Failed to report failed rollout step: %v", err)
+		}
+		return
+	}
+
+	output, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to marshal rollout step result: %v", err)
+		return
+	}
+
+	if _, err := sc.sfnClient.SendTaskSuccess(ctx, &sfn.SendTaskSuccessInput{
+		TaskToken: aws.String(token),
+		Output:    aws.String(string(output)),
+	}); err != nil {
+		log.Printf("Failed to report rollout step: %v", err)
+	}
+}
+
+// forget stops tracking an approval task
+func (sc *StepFunctionsController) forget(token string) {
+	sc.approvalMutex.Lock()
+	delete(sc.approvals, token)
+	sc.approvalMutex.Unlock()
+}
+
+// audit records an orchestration event, logging rather than failing the step
+func (sc *StepFunctionsController) audit(ctx context.Context, actor, action, rolloutID string, details map[string]string) {
+	if sc.auditLog == nil {
+		return
+	}
+
+	if err := sc.auditLog.Record(ctx, actor, action, rolloutID, details); err != nil {
+		log.Printf("Failed to record audit event %s: %v", action, err)
+	}
+}
+
+// notify delivers an event, logging rather than failing the step
+func (sc *StepFunctionsController) notify(ctx context.Context, event notify.Event) {
+	if sc.notifier == nil {
+		return
+	}
+
+	event.Timestamp = time.Now().UTC()
+	if err := sc.notifier.Notify(ctx, event); err != nil {
+		log.Printf("Failed to send %s notification: %v", event.Type, err)
+	}
+}
+
+// RegisterRoutes registers the orchestration API on the server
+func (sc *StepFunctionsController) RegisterRoutes(s *Server) {
+	s.Handle("POST /api/rollouts/{id}/orchestrate", http.HandlerFunc(sc.handleStart))
+	s.Handle("GET /api/orchestration/definition", http.HandlerFunc(sc.handleDefinition))
+}
+
+// handleStart hands a pending rollout to the state machine
+func (sc *StepFunctionsController) handleStart(w http.ResponseWriter, r *http.Request) {
+	plan, err := sc.Start(r.Context(), r.PathValue("id"), actorFor(r.Context(), ""))
+	if err != nil {
+		writeError(w, http.StatusConflict, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plan)
+}
+
+// handleDefinition returns the state machine definition for the controller's activity
+func (sc *StepFunctionsController) handleDefinition(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if _, err := w.Write([]byte(StateMachineDefinition(sc.activityARN))); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// Helper functions
+
+// buildExecutionInput describes a rollout's phases to the state machine
+func buildExecutionInput(plan rollout.RolloutPlan) (string, error) {
+	input := executionInput{
+		RolloutID: plan.ID,
+		Phases:    make([]executionPhase, len(plan.Phases)),
+	}
+	for i, phase := range plan.Phases {
+		duration, err := phase.ParseDuration()
+		if err != nil {
+			return "", err
+		}
+
+		input.Phases[i] = executionPhase{
+			Index:           i,
+			ID:              phase.ID,
+			RequireApproval: phase.RequireApproval && !phase.Approved,
+			WaitSeconds:     int64(duration / time.Second),
+		}
+	}
+
+	data, err := json.Marshal(input)
+	if err != nil {
+		return "", fmt.Errorf("failed to marshal execution input: %w", err)
+	}
+
+	return string(data), nil
+}
@@ -0,0 +1,183 @@
+package fleetserver
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// ScanDevices reads every item in the device table, skipping expired ones
+func ScanDevices(ctx context.Context, client *dynamodb.Client, tableName string) ([]DeviceRecord, error) {
+	devices := make([]DeviceRecord, 0)
+	now := time.Now()
+
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan device table: %w", err)
+		}
+
+		var batch []DeviceRecord
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal devices: %w", err)
+		}
+		for _, device := range batch {
+			if !device.Expired(now) {
+				devices = append(devices, device)
+			}
+		}
+	}
+
+	return devices, nil
+}
+
+// GetDevice reads a single device record by its partition key; it returns nil when the device does not exist or has expired
+func GetDevice(ctx context.Context, client *dynamodb.Client, tableName, deviceID string) (*DeviceRecord, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, nil
+	}
+
+	var device DeviceRecord
+	if err := attributevalue.UnmarshalMap(result.Item, &device); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device: %w", err)
+	}
+	if device.Expired(time.Now()) {
+		return nil, nil
+	}
+
+	return &device, nil
+}
+
+// ScanRollouts reads every item in the rollout table, skipping expired ones
+func ScanRollouts(ctx context.Context, client *dynamodb.Client, tableName string) ([]rollout.RolloutPlan, error) {
+	rollouts := make([]rollout.RolloutPlan, 0)
+	now := time.Now()
+
+	paginator := dynamodb.NewScanPaginator(client, &dynamodb.ScanInput{
+		TableName: aws.String(tableName),
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan rollout table: %w", err)
+		}
+
+		var batch []rollout.RolloutPlan
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal rollouts: %w", err)
+		}
+		for _, plan := range batch {
+			if !plan.Expired(now) {
+				rollouts = append(rollouts, plan)
+			}
+		}
+	}
+
+	return rollouts, nil
+}
+
+// ErrRolloutChanged is returned when a rollout record was written after it
+// was read, e.g. by another operator or the phase controller; read it again
+// and retry
+var ErrRolloutChanged = errors.New("rollout changed since it was read")
+
+// updateRolloutStatus sets the status of a rollout record that is still at revision
+func updateRolloutStatus(ctx context.Context, client *dynamodb.Client, tableName, rolloutID string, revision int64, status string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression: aws.String("SET #status = :status, UpdatedAt = :time"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: status},
+			":time":   &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	requireRevision(input, revision)
+
+	_, err := client.UpdateItem(ctx, input)
+	if err != nil {
+		var conditionFailed *types.ConditionalCheckFailedException
+		if errors.As(err, &conditionFailed) {
+			return fmt.Errorf("failed to set rollout %s status to %s: %w", rolloutID, status, ErrRolloutChanged)
+		}
+		return fmt.Errorf("failed to set rollout %s status to %s: %w", rolloutID, status, err)
+	}
+
+	return nil
+}
+
+// setCanaryVerdict records the canary verdict on a rollout phase
+func setCanaryVerdict(ctx context.Context, client *dynamodb.Client, tableName, rolloutID string, phaseIndex int, verdict string) error {
+	input := &dynamodb.UpdateItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression: aws.String(fmt.Sprintf("SET Phases[%d].CanaryVerdict = :verdict, UpdatedAt = :time", phaseIndex)),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":verdict": &types.AttributeValueMemberS{Value: verdict},
+			":time":    &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		},
+	}
+	bumpRevision(input)
+
+	if _, err := client.UpdateItem(ctx, input); err != nil {
+		return fmt.Errorf("failed to set canary verdict for rollout %s: %w", rolloutID, err)
+	}
+
+	return nil
+}
+
+// requireRevision makes a rollout update conditional on the record still
+// being at the revision it was read at, and bumps the revision. Records
+// written before revisions existed are at revision 0.
+func requireRevision(input *dynamodb.UpdateItemInput, revision int64) {
+	condition := "Revision = :revision"
+	if revision == 0 {
+		condition = "attribute_not_exists(Revision)"
+	} else {
+		input.ExpressionAttributeValues[":revision"] = &types.AttributeValueMemberN{Value: fmt.Sprint(revision)}
+	}
+	if input.ConditionExpression != nil {
+		condition = "(" + *input.ConditionExpression + ") AND " + condition
+	}
+	input.ConditionExpression = aws.String(condition)
+
+	bumpRevision(input)
+}
+
+// bumpRevision adds one to a rollout's revision without requiring one, for
+// writes that only add to the record, such as approvals and verdicts, so
+// writers that read the record before them conflict
+func bumpRevision(input *dynamodb.UpdateItemInput) {
+	input.UpdateExpression = aws.String(*input.UpdateExpression + " ADD Revision :one")
+	input.ExpressionAttributeValues[":one"] = &types.AttributeValueMemberN{Value: "1"}
+}
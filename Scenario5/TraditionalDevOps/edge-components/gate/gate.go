@@ -0,0 +1,642 @@
+// Package gate parses and evaluates phase gate expressions, conditions on
+// a canary cohort's telemetry such as
+//
+//	error_rate < 0.01 && p99_latency_ms < 200 && rate(crash_count, 10m) == 0
+//
+// A bare metric name is the metric's average over the analysis window;
+// avg, min, max, sum and rate take an optional window of their own. sum and
+// rate are per device, and rate is per second over the window. Expressions
+// combine numbers with + - * /, compare them with < <= > >= == !=, and
+// join the comparisons with &&, || and !.
+package gate
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNoData is returned when a metric an expression needs has no samples in
+// its window; the gate can't be decided yet
+var ErrNoData = errors.New("no telemetry")
+
+// Sample is one reported value of a metric
+type Sample struct {
+	Time  time.Time
+	Value float64
+}
+
+// Telemetry is what an expression is evaluated against: the samples a
+// cohort of devices reported, pooled by metric
+type Telemetry struct {
+	Now     time.Time
+	Window  time.Duration       // of bare metric names and functions without one
+	Devices int                 // in the cohort; sum and rate are per device
+	Samples map[string][]Sample // by metric
+}
+
+// Expr is a parsed gate expression
+type Expr struct {
+	source string
+	root   node
+	terms  []*term
+}
+
+// Parse parses a gate expression, which must be a condition rather than a
+// number
+func Parse(source string) (*Expr, error) {
+	tokens, err := lex(source)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{tokens: tokens}
+	root, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if tok := p.peek(); tok.kind != tokenEOF {
+		return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.offset)
+	}
+	if root.kind() != kindBool {
+		return nil, errors.New("gate must be a condition, e.g. error_rate < 0.01")
+	}
+
+	return &Expr{source: source, root: root, terms: p.terms}, nil
+}
+
+// String returns the expression as written
+func (e *Expr) String() string {
+	return e.source
+}
+
+// Metrics returns the names of the metrics the expression reads, sorted
+func (e *Expr) Metrics() []string {
+	seen := make(map[string]bool)
+	names := make([]string, 0, len(e.terms))
+	for _, t := range e.terms {
+		if !seen[t.metric] {
+			seen[t.metric] = true
+			names = append(names, t.metric)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Window returns the longest window the expression reads telemetry over,
+// given the window of bare metric names
+func (e *Expr) Window(window time.Duration) time.Duration {
+	longest := window
+	for _, t := range e.terms {
+		if t.window > longest {
+			longest = t.window
+		}
+	}
+	return longest
+}
+
+// Eval decides the expression on the telemetry and returns the value of
+// each metric term it evaluated, by the term as written. && and || stop at
+// the first operand that decides them.
+func (e *Expr) Eval(telemetry Telemetry) (bool, map[string]float64, error) {
+	ev := &evaluation{telemetry: telemetry, values: make(map[string]float64)}
+	result, err := e.root.eval(ev)
+	if err != nil {
+		return false, ev.values, err
+	}
+	return result != 0, ev.values, nil
+}
+
+// Lexer
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenNumber
+	tokenDuration
+	tokenIdent
+	tokenOperator
+)
+
+type token struct {
+	kind     tokenKind
+	text     string
+	offset   int
+	number   float64
+	duration time.Duration
+}
+
+// operators lists the operators longest first, so "<=" isn't read as "<"
+var operators = []string{"&&", "||", "<=", ">=", "==", "!=", "<", ">", "!", "+", "-", "*", "/", "(", ")", ","}
+
+func lex(source string) ([]token, error) {
+	tokens := make([]token, 0)
+	for i := 0; i < len(source); {
+		c := source[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+
+		case isDigit(c) || c == '.':
+			start := i
+			for i < len(source) && (isDigit(source[i]) || source[i] == '.') {
+				i++
+			}
+			// An exponent, as in 1e-3
+			if i+1 < len(source) && (source[i] == 'e' || source[i] == 'E') && (isDigit(source[i+1]) || source[i+1] == '-' || source[i+1] == '+') {
+				i += 2
+				for i < len(source) && isDigit(source[i]) {
+					i++
+				}
+			}
+			// A unit makes it a duration, as in 10m or 1h30m
+			if i < len(source) && isLetter(source[i]) {
+				for i < len(source) && (isLetter(source[i]) || isDigit(source[i]) || source[i] == '.') {
+					i++
+				}
+				duration, err := time.ParseDuration(source[start:i])
+				if err != nil || duration <= 0 {
+					return nil, fmt.Errorf("invalid duration %q at offset %d", source[start:i], start)
+				}
+				tokens = append(tokens, token{kind: tokenDuration, text: source[start:i], offset: start, duration: duration})
+				continue
+			}
+			number, err := strconv.ParseFloat(source[start:i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid number %q at offset %d", source[start:i], start)
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: source[start:i], offset: start, number: number})
+
+		case isLetter(c) || c == '_':
+			start := i
+			for i < len(source) && (isLetter(source[i]) || isDigit(source[i]) || source[i] == '_' || source[i] == '.') {
+				i++
+			}
+			tokens = append(tokens, token{kind: tokenIdent, text: source[start:i], offset: start})
+
+		default:
+			matched := ""
+			for _, op := range operators {
+				if strings.HasPrefix(source[i:], op) {
+					matched = op
+					break
+				}
+			}
+			if matched == "" {
+				return nil, fmt.Errorf("unexpected %q at offset %d", string(c), i)
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: matched, offset: i})
+			i += len(matched)
+		}
+	}
+
+	return append(tokens, token{kind: tokenEOF, text: "end of expression", offset: len(source)}), nil
+}
+
+// Parser
+
+type parser struct {
+	tokens []token
+	pos    int
+	terms  []*term
+}
+
+func (p *parser) peek() token {
+	return p.tokens[p.pos]
+}
+
+func (p *parser) next() token {
+	tok := p.tokens[p.pos]
+	if tok.kind != tokenEOF {
+		p.pos++
+	}
+	return tok
+}
+
+// accept consumes the next token when it is the operator
+func (p *parser) accept(op string) bool {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *parser) expect(op string) error {
+	if !p.accept(op) {
+		tok := p.peek()
+		return fmt.Errorf("expected %q, found %q at offset %d", op, tok.text, tok.offset)
+	}
+	return nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	x, err := p.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "||" {
+		tok := p.next()
+		y, err := p.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		if x, err = newBinary(tok, x, y); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) parseAnd() (node, error) {
+	x, err := p.parseNot()
+	if err != nil {
+		return nil, err
+	}
+	for p.peek().text == "&&" {
+		tok := p.next()
+		y, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if x, err = newBinary(tok, x, y); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) parseNot() (node, error) {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == "!" {
+		p.next()
+		x, err := p.parseNot()
+		if err != nil {
+			return nil, err
+		}
+		if x.kind() != kindBool {
+			return nil, fmt.Errorf("! needs a condition at offset %d", tok.offset)
+		}
+		return &unary{op: "!", x: x}, nil
+	}
+	return p.parseComparison()
+}
+
+func (p *parser) parseComparison() (node, error) {
+	x, err := p.parseSum()
+	if err != nil {
+		return nil, err
+	}
+	switch tok := p.peek(); tok.text {
+	case "<", "<=", ">", ">=", "==", "!=":
+		p.next()
+		y, err := p.parseSum()
+		if err != nil {
+			return nil, err
+		}
+		return newBinary(tok, x, y)
+	}
+	return x, nil
+}
+
+func (p *parser) parseSum() (node, error) {
+	x, err := p.parseProduct()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokenOperator && (tok.text == "+" || tok.text == "-"); tok = p.peek() {
+		p.next()
+		y, err := p.parseProduct()
+		if err != nil {
+			return nil, err
+		}
+		if x, err = newBinary(tok, x, y); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) parseProduct() (node, error) {
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	for tok := p.peek(); tok.kind == tokenOperator && (tok.text == "*" || tok.text == "/"); tok = p.peek() {
+		p.next()
+		y, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if x, err = newBinary(tok, x, y); err != nil {
+			return nil, err
+		}
+	}
+	return x, nil
+}
+
+func (p *parser) parseUnary() (node, error) {
+	if tok := p.peek(); tok.kind == tokenOperator && tok.text == "-" {
+		p.next()
+		x, err := p.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		if x.kind() != kindNumber {
+			return nil, fmt.Errorf("- needs a number at offset %d", tok.offset)
+		}
+		return &unary{op: "-", x: x}, nil
+	}
+	return p.parsePrimary()
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	tok := p.next()
+	switch tok.kind {
+	case tokenNumber:
+		return &literal{value: tok.number, k: kindNumber}, nil
+
+	case tokenDuration:
+		return nil, fmt.Errorf("duration %s at offset %d is only allowed as a window", tok.text, tok.offset)
+
+	case tokenIdent:
+		switch tok.text {
+		case "true":
+			return &literal{value: 1, k: kindBool}, nil
+		case "false":
+			return &literal{value: 0, k: kindBool}, nil
+		}
+		if p.peek().text == "(" && p.peek().kind == tokenOperator {
+			return p.parseCall(tok)
+		}
+		t := &term{source: tok.text, function: "avg", metric: tok.text}
+		p.terms = append(p.terms, t)
+		return t, nil
+
+	case tokenOperator:
+		if tok.text == "(" {
+			x, err := p.parseOr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return x, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unexpected %q at offset %d", tok.text, tok.offset)
+}
+
+// parseCall parses a function of a metric and an optional window
+func (p *parser) parseCall(name token) (node, error) {
+	if _, ok := functions[name.text]; !ok {
+		return nil, fmt.Errorf("unknown function %s at offset %d; use avg, min, max, sum or rate", name.text, name.offset)
+	}
+	p.next()
+
+	metric := p.next()
+	if metric.kind != tokenIdent {
+		return nil, fmt.Errorf("%s needs a metric name, found %q at offset %d", name.text, metric.text, metric.offset)
+	}
+
+	t := &term{function: name.text, metric: metric.text}
+	if p.accept(",") {
+		window := p.next()
+		if window.kind != tokenDuration {
+			return nil, fmt.Errorf("%s needs a window such as 10m, found %q at offset %d", name.text, window.text, window.offset)
+		}
+		t.window = window.duration
+		t.source = fmt.Sprintf("%s(%s, %s)", t.function, t.metric, window.text)
+	} else {
+		t.source = fmt.Sprintf("%s(%s)", t.function, t.metric)
+	}
+	if err := p.expect(")"); err != nil {
+		return nil, err
+	}
+
+	p.terms = append(p.terms, t)
+	return t, nil
+}
+
+// Nodes
+
+type kind int
+
+const (
+	kindNumber kind = iota
+	kindBool
+)
+
+// node is a parsed expression; conditions evaluate to 1 or 0
+type node interface {
+	kind() kind
+	eval(ev *evaluation) (float64, error)
+}
+
+type evaluation struct {
+	telemetry Telemetry
+	values    map[string]float64
+}
+
+type literal struct {
+	value float64
+	k     kind
+}
+
+func (l *literal) kind() kind { return l.k }
+
+func (l *literal) eval(*evaluation) (float64, error) { return l.value, nil }
+
+type unary struct {
+	op string
+	x  node
+}
+
+func (u *unary) kind() kind { return u.x.kind() }
+
+func (u *unary) eval(ev *evaluation) (float64, error) {
+	x, err := u.x.eval(ev)
+	if err != nil {
+		return 0, err
+	}
+	if u.op == "-" {
+		return -x, nil
+	}
+	return truth(x == 0), nil
+}
+
+type binary struct {
+	op   string
+	x, y node
+}
+
+// newBinary checks the operand kinds of an operator
+func newBinary(op token, x, y node) (node, error) {
+	switch op.text {
+	case "&&", "||":
+		if x.kind() != kindBool || y.kind() != kindBool {
+			return nil, fmt.Errorf("%s needs conditions on both sides at offset %d", op.text, op.offset)
+		}
+	case "==", "!=":
+		if x.kind() != y.kind() {
+			return nil, fmt.Errorf("%s compares a number with a condition at offset %d", op.text, op.offset)
+		}
+	default:
+		if x.kind() != kindNumber || y.kind() != kindNumber {
+			return nil, fmt.Errorf("%s needs numbers on both sides at offset %d", op.text, op.offset)
+		}
+	}
+	return &binary{op: op.text, x: x, y: y}, nil
+}
+
+func (b *binary) kind() kind {
+	switch b.op {
+	case "+", "-", "*", "/":
+		return kindNumber
+	}
+	return kindBool
+}
+
+func (b *binary) eval(ev *evaluation) (float64, error) {
+	x, err := b.x.eval(ev)
+	if err != nil {
+		return 0, err
+	}
+
+	// Short-circuit, so a decided condition needs no data for the rest
+	if (b.op == "&&" && x == 0) || (b.op == "||" && x != 0) {
+		return x, nil
+	}
+
+	y, err := b.y.eval(ev)
+	if err != nil {
+		return 0, err
+	}
+
+	switch b.op {
+	case "&&", "||":
+		return y, nil
+	case "+":
+		return x + y, nil
+	case "-":
+		return x - y, nil
+	case "*":
+		return x * y, nil
+	case "/":
+		if y == 0 {
+			return 0, errors.New("division by zero")
+		}
+		return x / y, nil
+	case "<":
+		return truth(x < y), nil
+	case "<=":
+		return truth(x <= y), nil
+	case ">":
+		return truth(x > y), nil
+	case ">=":
+		return truth(x >= y), nil
+	case "==":
+		return truth(x == y), nil
+	default:
+		return truth(x != y), nil
+	}
+}
+
+// term is a metric, bare or in a function, read from the telemetry
+type term struct {
+	source   string
+	function string
+	metric   string
+	window   time.Duration // zero for the telemetry's window
+}
+
+func (t *term) kind() kind { return kindNumber }
+
+func (t *term) eval(ev *evaluation) (float64, error) {
+	window := t.window
+	if window == 0 {
+		window = ev.telemetry.Window
+	}
+
+	since := ev.telemetry.Now.Add(-window)
+	values := make([]float64, 0)
+	for _, sample := range ev.telemetry.Samples[t.metric] {
+		if sample.Time.After(since) {
+			values = append(values, sample.Value)
+		}
+	}
+	if len(values) == 0 {
+		return 0, fmt.Errorf("%w for %s in the last %s", ErrNoData, t.metric, window)
+	}
+
+	devices := ev.telemetry.Devices
+	if devices < 1 {
+		devices = 1
+	}
+
+	value := functions[t.function](values, devices, window)
+	ev.values[t.source] = value
+	return value, nil
+}
+
+// functions aggregate a metric's samples in the window
+var functions = map[string]func(values []float64, devices int, window time.Duration) float64{
+	"avg": func(values []float64, _ int, _ time.Duration) float64 {
+		return total(values) / float64(len(values))
+	},
+	"min": func(values []float64, _ int, _ time.Duration) float64 {
+		lowest := values[0]
+		for _, v := range values[1:] {
+			if v < lowest {
+				lowest = v
+			}
+		}
+		return lowest
+	},
+	"max": func(values []float64, _ int, _ time.Duration) float64 {
+		highest := values[0]
+		for _, v := range values[1:] {
+			if v > highest {
+				highest = v
+			}
+		}
+		return highest
+	},
+	"sum": func(values []float64, devices int, _ time.Duration) float64 {
+		return total(values) / float64(devices)
+	},
+	"rate": func(values []float64, devices int, window time.Duration) float64 {
+		return total(values) / float64(devices) / window.Seconds()
+	},
+}
+
+// Helper functions
+
+func total(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum
+}
+
+func truth(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
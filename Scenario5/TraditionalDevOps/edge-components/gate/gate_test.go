@@ -0,0 +1,202 @@
+package gate_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/gate"
+)
+
+// telemetry is a two-device cohort with a 5m default window
+func telemetry() gate.Telemetry {
+	now := time.Date(2026, 1, 1, 12, 0, 0, 0, time.UTC)
+	ago := func(d time.Duration) time.Time { return now.Add(-d) }
+
+	return gate.Telemetry{
+		Now:     now,
+		Window:  5 * time.Minute,
+		Devices: 2,
+		Samples: map[string][]gate.Sample{
+			"error_rate": {{Time: ago(time.Minute), Value: 0.25}, {Time: ago(2 * time.Minute), Value: 0.75}},
+			"latency_ms": {{Time: ago(time.Minute), Value: 100}, {Time: ago(2 * time.Minute), Value: 300}, {Time: ago(time.Hour), Value: 900}},
+			"crash_count": {
+				{Time: ago(time.Minute), Value: 3},
+				{Time: ago(20 * time.Minute), Value: 6},
+			},
+		},
+	}
+}
+
+func TestEval(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		want    bool
+		wantErr error
+	}{
+		// Precedence and associativity
+		{name: "product before sum", expr: "1 + 2 * 3 == 7", want: true},
+		{name: "parentheses", expr: "(1 + 2) * 3 == 9", want: true},
+		{name: "subtraction is left associative", expr: "10 - 4 - 3 == 3", want: true},
+		{name: "division is left associative", expr: "8 / 4 / 2 == 1", want: true},
+		{name: "unary minus", expr: "-2 * 3 == -6", want: true},
+		{name: "and before or", expr: "true || false && false", want: true},
+		{name: "comparison before and", expr: "1 < 2 && 3 > 2", want: true},
+		{name: "not before and", expr: "!false && false", want: false},
+		{name: "not of group", expr: "!(false && false)", want: true},
+		{name: "double not", expr: "!!true", want: true},
+		{name: "conditions compare", expr: "(1 < 2) == true", want: true},
+
+		// && and || need no data once decided
+		{name: "and short-circuits", expr: "false && missing > 0", want: false},
+		{name: "or short-circuits", expr: "true || missing > 0", want: true},
+		{name: "and reads right operand", expr: "true && missing > 0", wantErr: gate.ErrNoData},
+		{name: "or reads left operand", expr: "missing > 0 || true", wantErr: gate.ErrNoData},
+
+		// Literals
+		{name: "exponent", expr: "1e5 == 100000", want: true},
+		{name: "negative exponent", expr: "1.5e-3 < 0.002", want: true},
+		{name: "leading dot", expr: ".5 == 0.5", want: true},
+		{name: "millisecond window", expr: "max(latency_ms, 5ms) > 0", wantErr: gate.ErrNoData},
+		{name: "compound window", expr: "max(latency_ms, 1h30m) == 900", want: true},
+
+		// Terms over windows
+		{name: "bare metric is the average", expr: "error_rate == 0.5", want: true},
+		{name: "min", expr: "min(latency_ms) == 100", want: true},
+		{name: "max in default window", expr: "max(latency_ms) == 300", want: true},
+		{name: "sum is per device", expr: "sum(crash_count) == 1.5", want: true},
+		{name: "sum over longer window", expr: "sum(crash_count, 30m) == 4.5", want: true},
+		{name: "rate is per device per second", expr: "rate(crash_count, 10m) == 0.0025", want: true},
+		{name: "rate gate fails", expr: "rate(crash_count, 10m) == 0", want: false},
+
+		// Undecided gates
+		{name: "metric without samples", expr: "missing < 1", wantErr: gate.ErrNoData},
+		{name: "window without samples", expr: "rate(crash_count, 30s) == 0", wantErr: gate.ErrNoData},
+		{name: "division by zero", expr: "1 / 0 > 0", wantErr: errors.New("division by zero")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := gate.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+
+			got, _, err := expr.Eval(telemetry())
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("Eval(%q) error = %v", tt.expr, err)
+			case tt.wantErr != nil && err == nil:
+				t.Fatalf("Eval(%q) = %v, want error %v", tt.expr, got, tt.wantErr)
+			case tt.wantErr != nil && !errors.Is(err, tt.wantErr) && err.Error() != tt.wantErr.Error():
+				t.Fatalf("Eval(%q) error = %v, want %v", tt.expr, err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("Eval(%q) = %v, want %v", tt.expr, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEvalValues(t *testing.T) {
+	tests := []struct {
+		name string
+		expr string
+		want map[string]float64
+	}{
+		{
+			name: "each term as written",
+			expr: "error_rate < 1 && rate(crash_count, 10m) < 1 && max(latency_ms) < 1000",
+			want: map[string]float64{"error_rate": 0.5, "rate(crash_count, 10m)": 0.0025, "max(latency_ms)": 300},
+		},
+		{
+			name: "short-circuited terms are not read",
+			expr: "error_rate > 1 && max(latency_ms) < 1000",
+			want: map[string]float64{"error_rate": 0.5},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			expr, err := gate.Parse(tt.expr)
+			if err != nil {
+				t.Fatalf("Parse(%q): %v", tt.expr, err)
+			}
+
+			_, values, err := expr.Eval(telemetry())
+			if err != nil {
+				t.Fatalf("Eval(%q): %v", tt.expr, err)
+			}
+			if !reflect.DeepEqual(values, tt.want) {
+				t.Errorf("Eval(%q) values = %v, want %v", tt.expr, values, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		expr    string
+		wantErr string
+	}{
+		{name: "empty", expr: "", wantErr: `unexpected "end of expression" at offset 0`},
+		{name: "trailing number", expr: "error_rate < 0.01 0.02", wantErr: `unexpected "0.02" at offset 18`},
+		{name: "trailing parenthesis", expr: "error_rate < 0.01)", wantErr: `unexpected ")" at offset 17`},
+		{name: "chained comparison", expr: "0 < error_rate < 1", wantErr: `unexpected "<" at offset 15`},
+		{name: "missing parenthesis", expr: "(error_rate < 1", wantErr: `expected ")", found "end of expression" at offset 15`},
+		{name: "dangling operator", expr: "error_rate <", wantErr: `unexpected "end of expression" at offset 12`},
+		{name: "number is not a condition", expr: "error_rate * 2", wantErr: "gate must be a condition"},
+		{name: "and needs conditions", expr: "true && 1", wantErr: "&& needs conditions on both sides at offset 5"},
+		{name: "not needs a condition", expr: "!error_rate", wantErr: "! needs a condition at offset 0"},
+		{name: "minus needs a number", expr: "-true == false", wantErr: "- needs a number at offset 0"},
+		{name: "comparison needs numbers", expr: "true < 1", wantErr: "< needs numbers on both sides at offset 5"},
+		{name: "number equals condition", expr: "1 == true", wantErr: "== compares a number with a condition at offset 2"},
+		{name: "duration outside window", expr: "error_rate < 5m", wantErr: "duration 5m at offset 13 is only allowed as a window"},
+		{name: "invalid duration", expr: "rate(crash_count, 10q) == 0", wantErr: `invalid duration "10q" at offset 18`},
+		{name: "zero window", expr: "rate(crash_count, 0s) == 0", wantErr: `invalid duration "0s" at offset 18`},
+		{name: "window must be a duration", expr: "rate(crash_count, 10) == 0", wantErr: `rate needs a window such as 10m, found "10" at offset 18`},
+		{name: "function needs a metric", expr: "max(1) > 0", wantErr: `max needs a metric name, found "1" at offset 4`},
+		{name: "unknown function", expr: "p99(latency_ms) < 200", wantErr: "unknown function p99 at offset 0"},
+		{name: "unknown character", expr: "error_rate < 1 & true", wantErr: `unexpected "&" at offset 15`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := gate.Parse(tt.expr)
+			if err == nil {
+				t.Fatalf("Parse(%q) succeeded, want error %q", tt.expr, tt.wantErr)
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Parse(%q) error = %q, want %q", tt.expr, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestMetricsAndWindow(t *testing.T) {
+	expr, err := gate.Parse("error_rate < 0.01 && rate(crash_count, 2h) == 0 && max(error_rate, 10m) < 0.05")
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+
+	if got, want := expr.Metrics(), []string{"crash_count", "error_rate"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Metrics() = %v, want %v", got, want)
+	}
+
+	tests := []struct {
+		window time.Duration
+		want   time.Duration
+	}{
+		{window: time.Hour, want: 2 * time.Hour},
+		{window: 3 * time.Hour, want: 3 * time.Hour},
+	}
+	for _, tt := range tests {
+		if got := expr.Window(tt.window); got != tt.want {
+			t.Errorf("Window(%s) = %s, want %s", tt.window, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package healthchecks
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// HealthCheck matches the rollout package's HealthCheck, so ExecChecks can be
+// registered with a RolloutManager or a GRPCAgent
+type HealthCheck interface {
+	// CheckHealth performs a health check
+	CheckHealth() (bool, error)
+}
+
+// Exit codes of the exec check protocol; any other code, a timeout or a
+// failure to run the executable is an error
+const (
+	ExitHealthy   = 0
+	ExitUnhealthy = 1
+)
+
+// maxOutput caps how much of a check's stdout is kept
+const maxOutput = 64 * 1024
+
+// ExecResult is the outcome of one run of an exec check. A check may print
+// it as a JSON object on stdout; a "healthy" field there overrides the exit
+// code, and other output is kept as the message.
+type ExecResult struct {
+	Healthy   bool                   `json:"healthy"`
+	Message   string                 `json:"message,omitempty"`
+	Details   map[string]interface{} `json:"details,omitempty"`
+	ExitCode  int                    `json:"exitCode"`
+	Duration  time.Duration          `json:"duration"`
+	CheckedAt time.Time              `json:"checkedAt"`
+}
+
+// ExecCheck is a HealthCheck that runs an external executable or script,
+// so site-specific checks can be dropped onto devices without rebuilding the
+// agent. The executable exits 0 when healthy and 1 when not.
+type ExecCheck struct {
+	name        string
+	path        string
+	args        []string
+	env         []string
+	timeout     time.Duration
+	lastResult  *ExecResult
+	resultMutex sync.RWMutex
+}
+
+// ExecCheckConfig contains configuration for an ExecCheck
+type ExecCheckConfig struct {
+	Name    string // defaults to the executable's name without extension
+	Path    string
+	Args    []string
+	Env     []string      // added to the agent's environment, as KEY=value
+	Timeout time.Duration // defaults to 30s; a check that runs longer is killed and errors
+}
+
+// NewExecCheck creates an ExecCheck for an executable file
+func NewExecCheck(config ExecCheckConfig) (*ExecCheck, error) {
+	info, err := os.Stat(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat health check %s: %w", config.Path, err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return nil, fmt.Errorf("health check %s is not an executable file", config.Path)
+	}
+
+	if config.Name == "" {
+		config.Name = checkName(config.Path)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	return &ExecCheck{
+		name:    config.Name,
+		path:    config.Path,
+		args:    config.Args,
+		env:     config.Env,
+		timeout: config.Timeout,
+	}, nil
+}
+
+// LoadDir creates an ExecCheck for every executable file in dir, sorted by
+// name; other files, such as READMEs, are skipped
+func LoadDir(dir string, timeout time.Duration) ([]*ExecCheck, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read health check directory: %w", err)
+	}
+
+	checks := make([]*ExecCheck, 0)
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+			continue
+		}
+
+		check, err := NewExecCheck(ExecCheckConfig{Path: filepath.Join(dir, entry.Name()), Timeout: timeout})
+		if err != nil {
+			return nil, err
+		}
+		checks = append(checks, check)
+	}
+
+	sort.Slice(checks, func(i, j int) bool { return checks[i].name < checks[j].name })
+	return checks, nil
+}
+
+// Name returns the check's name, for RolloutManager.RegisterNamedHealthCheck
+func (c *ExecCheck) Name() string {
+	return c.name
+}
+
+// CheckHealth runs the executable and interprets its exit code and output
+func (c *ExecCheck) CheckHealth() (bool, error) {
+	result, err := c.Run(context.Background())
+	if err != nil {
+		return false, err
+	}
+	return result.Healthy, nil
+}
+
+// Run runs the executable once and returns its result
+func (c *ExecCheck) Run(ctx context.Context) (*ExecResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, c.path, c.args...)
+	cmd.Env = append(os.Environ(), c.env...)
+	cmd.Stdout = &limitedBuffer{buffer: &stdout, limit: maxOutput}
+	cmd.Stderr = &limitedBuffer{buffer: &stderr, limit: maxOutput}
+
+	start := time.Now()
+	err := cmd.Run()
+	result := &ExecResult{Duration: time.Since(start), CheckedAt: start.UTC()}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return nil, fmt.Errorf("health check %s timed out after %s", c.name, c.timeout)
+	}
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.ExitCode = ExitHealthy
+	case errors.As(err, &exitErr):
+		result.ExitCode = exitErr.ExitCode()
+	default:
+		return nil, fmt.Errorf("failed to run health check %s: %w", c.name, err)
+	}
+
+	if result.ExitCode != ExitHealthy && result.ExitCode != ExitUnhealthy {
+		return nil, fmt.Errorf("health check %s exited with code %d: %s", c.name, result.ExitCode, strings.TrimSpace(stderr.String()))
+	}
+	result.Healthy = result.ExitCode == ExitHealthy
+
+	parseOutput(result, stdout.Bytes())
+	if result.Message == "" {
+		result.Message = strings.TrimSpace(stderr.String())
+	}
+
+	c.resultMutex.Lock()
+	c.lastResult = result
+	c.resultMutex.Unlock()
+
+	return result, nil
+}
+
+// LastResult returns the result of the last completed run, or nil
+func (c *ExecCheck) LastResult() *ExecResult {
+	c.resultMutex.RLock()
+	defer c.resultMutex.RUnlock()
+	return c.lastResult
+}
+
+// Helper functions
+
+// parseOutput applies a check's JSON output to the result; output that isn't
+// a JSON object becomes the message
+func parseOutput(result *ExecResult, output []byte) {
+	output = bytes.TrimSpace(output)
+	if len(output) == 0 {
+		return
+	}
+
+	var reported struct {
+		Healthy *bool                  `json:"healthy"`
+		Message string                 `json:"message"`
+		Details map[string]interface{} `json:"details"`
+	}
+	if output[0] != '{' || json.Unmarshal(output, &reported) != nil {
+		result.Message = string(output)
+		return
+	}
+
+	if reported.Healthy != nil {
+		result.Healthy = *reported.Healthy
+	}
+	result.Message = reported.Message
+	result.Details = reported.Details
+}
+
+// checkName names a check after its file, without the extension
+func checkName(path string) string {
+	base := filepath.Base(path)
+	return strings.TrimSuffix(base, filepath.Ext(base))
+}
+
+// limitedBuffer keeps the first limit bytes written and discards the rest,
+// so a chatty check can't exhaust memory
+type limitedBuffer struct {
+	buffer *bytes.Buffer
+	limit  int
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if remaining := b.limit - b.buffer.Len(); remaining > 0 {
+		if len(p) > remaining {
+			b.buffer.Write(p[:remaining])
+		} else {
+			b.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
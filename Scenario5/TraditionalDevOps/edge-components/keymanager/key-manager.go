@@ -0,0 +1,313 @@
+package keymanager
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+const (
+	// DataType is the sync data type key sets are delivered as; register a
+	// KeyRing for it with SyncManager.RegisterSyncHandler
+	DataType = "signing-keys"
+
+	// keySetFile is the object name of the key set, both in the key store and in device updates
+	keySetFile = "signing-keys.json"
+
+	// Algorithm is the signature scheme used by every key
+	Algorithm = "ECDSA_SHA_256"
+)
+
+// PublicKey is a signing key devices trust
+type PublicKey struct {
+	KeyID     string     `json:"keyId"`
+	Algorithm string     `json:"algorithm"`
+	PublicKey []byte     `json:"publicKey"` // DER-encoded SubjectPublicKeyInfo
+	CreatedAt time.Time  `json:"createdAt"`
+	RetiredAt *time.Time `json:"retiredAt,omitempty"` // no longer signs, but still verifies what it signed
+}
+
+// KeySet is the set of public keys distributed to devices. Each new set is
+// signed by a key from the previous set, so devices only accept rotations
+// from a key they already trust.
+type KeySet struct {
+	Keys      []PublicKey `json:"keys"`
+	UpdatedAt time.Time   `json:"updatedAt"`
+	SignedBy  string      `json:"signedBy,omitempty"`
+	Signature []byte      `json:"signature,omitempty"`
+}
+
+// KMSSigner signs with a KMS asymmetric key; the private key never leaves KMS.
+// It satisfies publisher.Signer and reporting.Signer.
+type KMSSigner struct {
+	kmsClient *kms.Client
+	keyID     string
+}
+
+// NewKMSSigner creates a signer for a KMS key ID or ARN
+func NewKMSSigner(kmsClient *kms.Client, keyID string) *KMSSigner {
+	return &KMSSigner{kmsClient: kmsClient, keyID: keyID}
+}
+
+// Sign returns an ECDSA signature over the SHA-256 digest of data
+func (s *KMSSigner) Sign(data []byte) ([]byte, error) {
+	digest := sha256.Sum256(data)
+
+	result, err := s.kmsClient.Sign(context.Background(), &kms.SignInput{
+		KeyId:            aws.String(s.keyID),
+		Message:          digest[:],
+		MessageType:      kmstypes.MessageTypeDigest,
+		SigningAlgorithm: kmstypes.SigningAlgorithmSpecEcdsaSha256,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign with %s: %w", s.keyID, err)
+	}
+
+	return result.Signature, nil
+}
+
+// KeyID returns the KMS key ID
+func (s *KMSSigner) KeyID() string {
+	return s.keyID
+}
+
+// KeyManager owns the fleet's signing keys: the current key is the one a KMS
+// alias points at, rotation creates a new key and moves the alias, and the
+// public halves are published to devices through the sync channel
+type KeyManager struct {
+	kmsClient   *kms.Client
+	transport   offlineSync.SyncTransport
+	keyAlias    string
+	tenantID    string
+	retireAfter time.Duration
+}
+
+// KeyManagerConfig contains configuration for the KeyManager
+type KeyManagerConfig struct {
+	KMSClient   *kms.Client
+	Transport   offlineSync.SyncTransport // the sync bucket devices read, normally offlineSync.NewS3Transport
+	KeyAlias    string                    // e.g. alias/edge-signing
+	TenantID    string
+	RetireAfter time.Duration // how long a rotated-out key stays trusted; defaults to 30 days
+}
+
+// NewKeyManager creates a new KeyManager
+func NewKeyManager(config KeyManagerConfig) (*KeyManager, error) {
+	if config.KeyAlias == "" {
+		return nil, errors.New("key alias is required")
+	}
+	if config.RetireAfter == 0 {
+		config.RetireAfter = 30 * 24 * time.Hour
+	}
+
+	return &KeyManager{
+		kmsClient:   config.KMSClient,
+		transport:   config.Transport,
+		keyAlias:    config.KeyAlias,
+		tenantID:    config.TenantID,
+		retireAfter: config.RetireAfter,
+	}, nil
+}
+
+// Signer returns a signer for the current key
+func (km *KeyManager) Signer(ctx context.Context) (*KMSSigner, error) {
+	keyID, err := km.currentKeyID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if keyID == "" {
+		return nil, fmt.Errorf("no signing key behind %s; rotate to create one", km.keyAlias)
+	}
+
+	return NewKMSSigner(km.kmsClient, keyID), nil
+}
+
+// KeySet returns the published key set, which is empty before the first rotation
+func (km *KeyManager) KeySet(ctx context.Context) (*KeySet, error) {
+	data, err := km.transport.GetObject(ctx, km.keySetKey())
+	if err != nil {
+		var noSuchKey *s3types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return &KeySet{}, nil
+		}
+		return nil, err
+	}
+
+	var keySet KeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, fmt.Errorf("failed to parse key set: %w", err)
+	}
+
+	return &keySet, nil
+}
+
+// Rotate creates a new signing key, points the alias at it and publishes the
+// new key set signed by the outgoing key. Keys retired for longer than
+// RetireAfter are dropped from the set but not deleted from KMS.
+func (km *KeyManager) Rotate(ctx context.Context) (string, error) {
+	previousID, err := km.currentKeyID(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	keySet, err := km.KeySet(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	created, err := km.kmsClient.CreateKey(ctx, &kms.CreateKeyInput{
+		KeySpec:     kmstypes.KeySpecEccNistP256,
+		KeyUsage:    kmstypes.KeyUsageTypeSignVerify,
+		Description: aws.String("Edge fleet signing key for " + km.keyAlias),
+		Tags:        []kmstypes.Tag{{TagKey: aws.String("tenant"), TagValue: aws.String(km.tenantID)}},
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to create signing key: %w", err)
+	}
+	keyID := aws.ToString(created.KeyMetadata.KeyId)
+
+	publicKey, err := km.publicKey(ctx, keyID)
+	if err != nil {
+		return "", err
+	}
+
+	// Rebuild the set: retire the outgoing key, drop expired ones, add the new key
+	now := time.Now().UTC()
+	keys := make([]PublicKey, 0, len(keySet.Keys)+1)
+	for _, key := range keySet.Keys {
+		if key.KeyID == previousID && key.RetiredAt == nil {
+			key.RetiredAt = &now
+		}
+		if key.RetiredAt != nil && now.Sub(*key.RetiredAt) > km.retireAfter {
+			log.Printf("Dropping signing key %s, retired %s", key.KeyID, key.RetiredAt.Format(time.RFC3339))
+			continue
+		}
+		keys = append(keys, key)
+	}
+	keys = append(keys, *publicKey)
+
+	// Sign with the key devices already trust; the first set is trusted on first use
+	signingKeyID := previousID
+	if signingKeyID == "" {
+		signingKeyID = keyID
+	}
+	if err := km.publish(ctx, &KeySet{Keys: keys, UpdatedAt: now}, signingKeyID); err != nil {
+		return "", err
+	}
+
+	// Only move the alias once devices can learn the new key
+	if previousID == "" {
+		_, err = km.kmsClient.CreateAlias(ctx, &kms.CreateAliasInput{AliasName: aws.String(km.keyAlias), TargetKeyId: aws.String(keyID)})
+	} else {
+		_, err = km.kmsClient.UpdateAlias(ctx, &kms.UpdateAliasInput{AliasName: aws.String(km.keyAlias), TargetKeyId: aws.String(keyID)})
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to point %s at the new key: %w", km.keyAlias, err)
+	}
+
+	log.Printf("Rotated signing key %s: %s -> %s", km.keyAlias, previousID, keyID)
+
+	return keyID, nil
+}
+
+// Distribute delivers the key set to devices through their sync manifests
+func (km *KeyManager) Distribute(ctx context.Context, deviceIDs []string) error {
+	data, err := km.transport.GetObject(ctx, km.keySetKey())
+	if err != nil {
+		return fmt.Errorf("failed to read key set: %w", err)
+	}
+
+	failed := 0
+	for _, deviceID := range deviceIDs {
+		if err := km.deliver(ctx, deviceID, data); err != nil {
+			log.Printf("Failed to deliver signing keys to %s: %v", deviceID, err)
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("signing keys not delivered to %d of %d devices", failed, len(deviceIDs))
+	}
+
+	return nil
+}
+
+// deliver writes the key set into a device's updates and lists it in its manifest
+func (km *KeyManager) deliver(ctx context.Context, deviceID string, data []byte) error {
+	devicePrefix := fmt.Sprintf("%sdevices/%s/", tenant.S3Prefix(km.tenantID), deviceID)
+	return offlineSync.DeliverUpdate(ctx, km.transport, devicePrefix, keySetFile, DataType, data)
+}
+
+// publish signs and stores a key set
+func (km *KeyManager) publish(ctx context.Context, keySet *KeySet, signingKeyID string) error {
+	signature, err := NewKMSSigner(km.kmsClient, signingKeyID).Sign(keySet.signingPayload())
+	if err != nil {
+		return fmt.Errorf("failed to sign key set: %w", err)
+	}
+	keySet.SignedBy = signingKeyID
+	keySet.Signature = signature
+
+	data, err := json.Marshal(keySet)
+	if err != nil {
+		return fmt.Errorf("failed to marshal key set: %w", err)
+	}
+
+	return km.transport.PutObject(ctx, km.keySetKey(), data, map[string]string{"datatype": DataType})
+}
+
+// currentKeyID resolves the alias, returning "" when it does not exist yet
+func (km *KeyManager) currentKeyID(ctx context.Context) (string, error) {
+	result, err := km.kmsClient.DescribeKey(ctx, &kms.DescribeKeyInput{KeyId: aws.String(km.keyAlias)})
+	if err != nil {
+		var notFound *kmstypes.NotFoundException
+		if errors.As(err, &notFound) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to describe %s: %w", km.keyAlias, err)
+	}
+
+	return aws.ToString(result.KeyMetadata.KeyId), nil
+}
+
+// publicKey fetches the public half of a KMS key
+func (km *KeyManager) publicKey(ctx context.Context, keyID string) (*PublicKey, error) {
+	result, err := km.kmsClient.GetPublicKey(ctx, &kms.GetPublicKeyInput{KeyId: aws.String(keyID)})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get public key for %s: %w", keyID, err)
+	}
+
+	return &PublicKey{
+		KeyID:     keyID,
+		Algorithm: Algorithm,
+		PublicKey: result.PublicKey,
+		CreatedAt: time.Now().UTC(),
+	}, nil
+}
+
+// keySetKey is where the authoritative key set is stored
+func (km *KeyManager) keySetKey() string {
+	return tenant.S3Prefix(km.tenantID) + "keys/" + keySetFile
+}
+
+// Helper functions
+
+// signingPayload returns the bytes a key set signature covers
+func (ks KeySet) signingPayload() []byte {
+	payload, _ := json.Marshal(struct {
+		Keys      []PublicKey `json:"keys"`
+		UpdatedAt time.Time   `json:"updatedAt"`
+	}{ks.Keys, ks.UpdatedAt})
+	return payload
+}
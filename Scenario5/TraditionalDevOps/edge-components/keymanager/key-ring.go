@@ -0,0 +1,166 @@
+package keymanager
+
+import (
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// KeyRing holds the signing keys a device trusts. It receives key sets as a
+// SyncHandler for DataType, accepting a new set only when it is signed by a
+// key already on the ring, and verifies signatures as a rollout.SignatureVerifier.
+type KeyRing struct {
+	path      string
+	keySet    KeySet
+	keys      map[string]*ecdsa.PublicKey
+	ringMutex sync.RWMutex
+}
+
+// NewKeyRing loads the key ring persisted at path; a missing file starts an
+// empty ring that trusts the first key set it receives
+func NewKeyRing(path string) (*KeyRing, error) {
+	kr := &KeyRing{
+		path: path,
+		keys: make(map[string]*ecdsa.PublicKey),
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return kr, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key ring: %w", err)
+	}
+
+	var keySet KeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return nil, fmt.Errorf("failed to parse key ring: %w", err)
+	}
+
+	keys, err := parseKeys(keySet)
+	if err != nil {
+		return nil, err
+	}
+	kr.keySet, kr.keys = keySet, keys
+
+	return kr, nil
+}
+
+// Verify checks an ECDSA signature over the SHA-256 digest of data
+func (kr *KeyRing) Verify(keyID string, data, signature []byte) error {
+	kr.ringMutex.RLock()
+	publicKey, ok := kr.keys[keyID]
+	kr.ringMutex.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("untrusted signing key %q", keyID)
+	}
+
+	digest := sha256.Sum256(data)
+	if !ecdsa.VerifyASN1(publicKey, digest[:], signature) {
+		return fmt.Errorf("invalid signature by %s", keyID)
+	}
+
+	return nil
+}
+
+// KeyIDs returns the trusted key IDs
+func (kr *KeyRing) KeyIDs() []string {
+	kr.ringMutex.RLock()
+	defer kr.ringMutex.RUnlock()
+
+	ids := make([]string, 0, len(kr.keySet.Keys))
+	for _, key := range kr.keySet.Keys {
+		ids = append(ids, key.KeyID)
+	}
+	return ids
+}
+
+// ProcessUpdate replaces the ring with a key set delivered by the SyncManager
+func (kr *KeyRing) ProcessUpdate(key string, data []byte) error {
+	var keySet KeySet
+	if err := json.Unmarshal(data, &keySet); err != nil {
+		return fmt.Errorf("failed to parse key set %s: %w", key, err)
+	}
+
+	keys, err := parseKeys(keySet)
+	if err != nil {
+		return err
+	}
+
+	kr.ringMutex.Lock()
+	defer kr.ringMutex.Unlock()
+
+	if !keySet.UpdatedAt.After(kr.keySet.UpdatedAt) {
+		log.Printf("Ignoring key set %s: not newer than the current ring", key)
+		return nil
+	}
+
+	// Trust on first use; afterwards a new set must be signed by a key we hold
+	signers := kr.keys
+	if len(signers) == 0 {
+		signers = keys
+	}
+	signer, ok := signers[keySet.SignedBy]
+	if !ok {
+		return fmt.Errorf("key set %s is signed by untrusted key %q", key, keySet.SignedBy)
+	}
+	digest := sha256.Sum256(keySet.signingPayload())
+	if !ecdsa.VerifyASN1(signer, digest[:], keySet.Signature) {
+		return fmt.Errorf("key set %s has an invalid signature", key)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(kr.path), 0755); err != nil {
+		return fmt.Errorf("failed to create key ring directory: %w", err)
+	}
+	if err := ioutil.WriteFile(kr.path, data, 0644); err != nil {
+		return fmt.Errorf("failed to persist key ring: %w", err)
+	}
+
+	kr.keySet, kr.keys = keySet, keys
+	log.Printf("Signing keys updated: %d trusted", len(keys))
+
+	return nil
+}
+
+// GetLocalChanges returns nothing; keys only flow from the cloud to the device
+func (kr *KeyRing) GetLocalChanges() (map[string][]byte, error) {
+	return nil, nil
+}
+
+// MergeConflicts always prefers the remote key set
+func (kr *KeyRing) MergeConflicts(localData, remoteData []byte) ([]byte, error) {
+	return remoteData, nil
+}
+
+// Helper functions
+
+// parseKeys decodes the public keys in a key set
+func parseKeys(keySet KeySet) (map[string]*ecdsa.PublicKey, error) {
+	keys := make(map[string]*ecdsa.PublicKey, len(keySet.Keys))
+	for _, key := range keySet.Keys {
+		if key.Algorithm != Algorithm {
+			return nil, fmt.Errorf("key %s uses unsupported algorithm %s", key.KeyID, key.Algorithm)
+		}
+
+		parsed, err := x509.ParsePKIXPublicKey(key.PublicKey)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse key %s: %w", key.KeyID, err)
+		}
+
+		publicKey, ok := parsed.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("key %s is not an ECDSA key", key.KeyID)
+		}
+		keys[key.KeyID] = publicKey
+	}
+
+	return keys, nil
+}
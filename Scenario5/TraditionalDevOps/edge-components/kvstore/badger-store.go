@@ -0,0 +1,111 @@
+package kvstore
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/dgraph-io/badger/v3"
+)
+
+// BadgerStore is a KVStore backed by BadgerDB
+type BadgerStore struct {
+	db *badger.DB
+}
+
+// OpenBadger opens a BadgerDB directory
+func OpenBadger(path string) (*BadgerStore, error) {
+	opts := badger.DefaultOptions(path)
+	opts.Logger = nil // Disable logging
+	db, err := badger.Open(opts)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open BadgerDB: %w", err)
+	}
+
+	return &BadgerStore{db: db}, nil
+}
+
+// Get returns a copy of the value stored at key
+func (bs *BadgerStore) Get(key []byte) ([]byte, error) {
+	var result []byte
+	err := bs.db.View(func(txn *badger.Txn) error {
+		item, err := txn.Get(key)
+		if err != nil {
+			return err
+		}
+
+		result, err = item.ValueCopy(nil)
+		return err
+	})
+
+	if err == badger.ErrKeyNotFound {
+		return nil, ErrKeyNotFound
+	}
+	return result, err
+}
+
+// Set stores a value
+func (bs *BadgerStore) Set(key, value []byte) error {
+	return bs.db.Update(func(txn *badger.Txn) error {
+		return txn.Set(key, value)
+	})
+}
+
+// SetBatch stores several values in one transaction
+func (bs *BadgerStore) SetBatch(entries map[string][]byte) error {
+	return bs.db.Update(func(txn *badger.Txn) error {
+		for key, value := range entries {
+			if err := txn.Set([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes a key
+func (bs *BadgerStore) Delete(key []byte) error {
+	return bs.db.Update(func(txn *badger.Txn) error {
+		return txn.Delete(key)
+	})
+}
+
+// Iterate calls fn for each key with the prefix
+func (bs *BadgerStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return bs.db.View(func(txn *badger.Txn) error {
+		it := txn.NewIterator(badger.DefaultIteratorOptions)
+		defer it.Close()
+
+		for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+			item := it.Item()
+			err := item.Value(func(value []byte) error {
+				return fn(item.Key(), value)
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// Backup writes a full Badger backup to w
+func (bs *BadgerStore) Backup(w io.Writer) error {
+	if _, err := bs.db.Backup(w, 0); err != nil {
+		return fmt.Errorf("failed to back up BadgerDB: %w", err)
+	}
+	return nil
+}
+
+// Restore loads a Badger backup into the database
+func (bs *BadgerStore) Restore(r io.Reader) error {
+	if err := bs.db.Load(r, 256); err != nil {
+		return fmt.Errorf("failed to restore BadgerDB: %w", err)
+	}
+	return nil
+}
+
+// Close closes the database
+func (bs *BadgerStore) Close() error {
+	return bs.db.Close()
+}
@@ -0,0 +1,154 @@
+package kvstore
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// boltBucket holds every key; components namespace keys with prefixes
+var boltBucket = []byte("kv")
+
+// BoltStore is a KVStore backed by a single bbolt file. It trades write
+// throughput for a small, predictable memory footprint.
+type BoltStore struct {
+	db *bolt.DB
+}
+
+// OpenBolt opens a bbolt database; a directory path gets a store.db file inside it
+func OpenBolt(path string) (*BoltStore, error) {
+	if info, err := os.Stat(path); err == nil && info.IsDir() {
+		path = filepath.Join(path, "store.db")
+	} else if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create store directory: %w", err)
+	}
+
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open bbolt database: %w", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to create bucket: %w", err)
+	}
+
+	return &BoltStore{db: db}, nil
+}
+
+// Get returns a copy of the value stored at key
+func (bs *BoltStore) Get(key []byte) ([]byte, error) {
+	var result []byte
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(boltBucket).Get(key)
+		if value == nil {
+			return ErrKeyNotFound
+		}
+		result = append([]byte{}, value...)
+		return nil
+	})
+
+	return result, err
+}
+
+// Set stores a value
+func (bs *BoltStore) Set(key, value []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Put(key, value)
+	})
+}
+
+// SetBatch stores several values in one transaction
+func (bs *BoltStore) SetBatch(entries map[string][]byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(boltBucket)
+		for key, value := range entries {
+			if err := bucket.Put([]byte(key), value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Delete removes a key
+func (bs *BoltStore) Delete(key []byte) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltBucket).Delete(key)
+	})
+}
+
+// Iterate calls fn for each key with the prefix
+func (bs *BoltStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	return bs.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltBucket).Cursor()
+		for key, value := cursor.Seek(prefix); key != nil && bytes.HasPrefix(key, prefix); key, value = cursor.Next() {
+			if err := fn(key, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Backup writes a consistent copy of the database file to w
+func (bs *BoltStore) Backup(w io.Writer) error {
+	err := bs.db.View(func(tx *bolt.Tx) error {
+		_, err := tx.WriteTo(w)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to back up bbolt database: %w", err)
+	}
+	return nil
+}
+
+// Restore copies every key of a database file written by Backup into the store
+func (bs *BoltStore) Restore(r io.Reader) error {
+	// bbolt only opens files, so stage the backup on disk first
+	staged, err := os.CreateTemp(filepath.Dir(bs.db.Path()), "restore-*.db")
+	if err != nil {
+		return fmt.Errorf("failed to stage backup: %w", err)
+	}
+	defer os.Remove(staged.Name())
+
+	_, err = io.Copy(staged, r)
+	staged.Close()
+	if err != nil {
+		return fmt.Errorf("failed to stage backup: %w", err)
+	}
+
+	backup, err := bolt.Open(staged.Name(), 0600, &bolt.Options{ReadOnly: true, Timeout: 5 * time.Second})
+	if err != nil {
+		return fmt.Errorf("failed to open backup: %w", err)
+	}
+	defer backup.Close()
+
+	return backup.View(func(src *bolt.Tx) error {
+		from := src.Bucket(boltBucket)
+		if from == nil {
+			return fmt.Errorf("backup has no %s bucket", boltBucket)
+		}
+
+		return bs.db.Update(func(dst *bolt.Tx) error {
+			to := dst.Bucket(boltBucket)
+			return from.ForEach(func(key, value []byte) error {
+				return to.Put(key, value)
+			})
+		})
+	})
+}
+
+// Close closes the database
+func (bs *BoltStore) Close() error {
+	return bs.db.Close()
+}
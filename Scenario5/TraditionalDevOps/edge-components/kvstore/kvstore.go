@@ -0,0 +1,68 @@
+package kvstore
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Storage backends selectable in component configuration
+const (
+	// BackendBadger is the default; fastest, but its memtables and caches need tens of MB of memory
+	BackendBadger = "badger"
+
+	// BackendBolt keeps a single memory-mapped file and suits the smallest devices
+	BackendBolt = "bolt"
+
+	// BackendMemory keeps everything in memory, for demos and tests; the path is ignored
+	BackendMemory = "memory"
+)
+
+// ErrKeyNotFound is returned by Get for keys that are not stored
+var ErrKeyNotFound = errors.New("key not found")
+
+// KVStore is the local persistence used by edge components
+type KVStore interface {
+	// Get returns a copy of the value stored at key, or ErrKeyNotFound
+	Get(key []byte) ([]byte, error)
+
+	// Set stores a value
+	Set(key, value []byte) error
+
+	// SetBatch stores several values atomically
+	SetBatch(entries map[string][]byte) error
+
+	// Delete removes a key; deleting a missing key is not an error
+	Delete(key []byte) error
+
+	// Iterate calls fn for each key with the prefix, in key order; the slices
+	// are only valid during the call
+	Iterate(prefix []byte, fn func(key, value []byte) error) error
+
+	// Close releases the store
+	Close() error
+}
+
+// Snapshotter is implemented by stores that can be backed up and restored
+// as a whole, e.g. to move a device's state onto replacement hardware
+type Snapshotter interface {
+	// Backup writes a full backup of the store to w
+	Backup(w io.Writer) error
+
+	// Restore loads a backup written by the same backend into the store
+	Restore(r io.Reader) error
+}
+
+// Open opens the store for a backend at path; an empty backend means badger
+func Open(backend, path string) (KVStore, error) {
+	switch backend {
+	case "", BackendBadger:
+		return OpenBadger(path)
+	case BackendBolt:
+		return OpenBolt(path)
+	case BackendMemory:
+		return NewMemoryStore(), nil
+	default:
+		return nil, fmt.Errorf("unknown storage backend: %s", backend)
+	}
+}
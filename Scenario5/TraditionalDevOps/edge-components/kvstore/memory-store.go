@@ -0,0 +1,134 @@
+package kvstore
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrClosed is returned by a MemoryStore after Close
+var ErrClosed = errors.New("store is closed")
+
+// MemoryStore is a KVStore held in memory, for demos, unit tests and laptop
+// development; nothing survives the process
+type MemoryStore struct {
+	data   map[string][]byte
+	closed bool
+	mutex  sync.RWMutex
+}
+
+// NewMemoryStore creates an empty MemoryStore
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{data: make(map[string][]byte)}
+}
+
+// Get returns a copy of the value stored at key
+func (ms *MemoryStore) Get(key []byte) ([]byte, error) {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if ms.closed {
+		return nil, ErrClosed
+	}
+	value, ok := ms.data[string(key)]
+	if !ok {
+		return nil, ErrKeyNotFound
+	}
+	return append([]byte{}, value...), nil
+}
+
+// Set stores a value
+func (ms *MemoryStore) Set(key, value []byte) error {
+	return ms.SetBatch(map[string][]byte{string(key): value})
+}
+
+// SetBatch stores several values atomically
+func (ms *MemoryStore) SetBatch(entries map[string][]byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.closed {
+		return ErrClosed
+	}
+	for key, value := range entries {
+		ms.data[key] = append([]byte{}, value...)
+	}
+	return nil
+}
+
+// Delete removes a key
+func (ms *MemoryStore) Delete(key []byte) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	if ms.closed {
+		return ErrClosed
+	}
+	delete(ms.data, string(key))
+	return nil
+}
+
+// Iterate calls fn for each key with the prefix, in key order. It iterates
+// over a copy, so fn may modify the store.
+func (ms *MemoryStore) Iterate(prefix []byte, fn func(key, value []byte) error) error {
+	ms.mutex.RLock()
+	if ms.closed {
+		ms.mutex.RUnlock()
+		return ErrClosed
+	}
+	keys := make([]string, 0, len(ms.data))
+	values := make(map[string][]byte)
+	for key, value := range ms.data {
+		if strings.HasPrefix(key, string(prefix)) {
+			keys = append(keys, key)
+			values[key] = value
+		}
+	}
+	ms.mutex.RUnlock()
+
+	sort.Strings(keys)
+	for _, key := range keys {
+		if err := fn([]byte(key), values[key]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close releases the store; its data is dropped
+func (ms *MemoryStore) Close() error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.closed = true
+	ms.data = nil
+	return nil
+}
+
+// Backup writes the store as JSON to w
+func (ms *MemoryStore) Backup(w io.Writer) error {
+	ms.mutex.RLock()
+	defer ms.mutex.RUnlock()
+
+	if ms.closed {
+		return ErrClosed
+	}
+	if err := json.NewEncoder(w).Encode(ms.data); err != nil {
+		return fmt.Errorf("failed to write memory store backup: %w", err)
+	}
+	return nil
+}
+
+// Restore loads a backup written by Backup into the store
+func (ms *MemoryStore) Restore(r io.Reader) error {
+	var data map[string][]byte
+	if err := json.NewDecoder(r).Decode(&data); err != nil {
+		return fmt.Errorf("failed to read memory store backup: %w", err)
+	}
+
+	return ms.SetBatch(data)
+}
@@ -0,0 +1,468 @@
+package lanbroker
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	natsserver "github.com/nats-io/nats-server/v2/server"
+	"github.com/nats-io/nats.go"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Subjects used on the LAN; <device> is the device ID
+const (
+	// syncPutSubject is requested by devices to upload a sync object: edge.sync.<device>.put
+	syncPutSubject = "edge.sync.%s.put"
+
+	// syncGetSubject is requested by devices to download a sync object: edge.sync.<device>.get
+	syncGetSubject = "edge.sync.%s.get"
+
+	// RolloutsSubject carries the tenant's in-progress rollouts whenever they change
+	RolloutsSubject = "edge.rollouts"
+
+	// rolloutsGetSubject is requested by devices for the current rollouts
+	rolloutsGetSubject = "edge.rollouts.get"
+)
+
+// Local store key prefixes
+const (
+	outboxPrefix = "lan/outbox/"
+	cachePrefix  = "lan/cache/"
+)
+
+// DeviceUser is a site-local device allowed to connect to the broker; it may
+// only use its own sync subjects
+type DeviceUser struct {
+	DeviceID string
+	Password string
+}
+
+// Broker runs an embedded NATS server on a gateway device so site-local
+// devices can exchange sync data and rollout notifications over the LAN.
+// The gateway bridges to AWS: uploads are queued locally while offline and
+// forwarded when connected, downloads are cached for offline reads, and the
+// tenant's in-progress rollouts are announced to the site.
+type Broker struct {
+	server           *natsserver.Server
+	conn             *nats.Conn
+	store            kvstore.KVStore
+	transport        offlineSync.SyncTransport
+	dynamoClient     *dynamodb.Client
+	rolloutTableName string
+	tenantID         string
+	pollInterval     time.Duration
+	pollTimer        *time.Timer
+	rollouts         []byte // last announced rollouts, as JSON
+	isOnline         bool
+	brokerMutex      sync.Mutex
+	flushMutex       sync.Mutex
+}
+
+// BrokerConfig contains configuration for the Broker
+type BrokerConfig struct {
+	Host             string // LAN address to listen on; defaults to all interfaces
+	Port             int    // defaults to 4222
+	Devices          []DeviceUser
+	StorageBackend   string // local store for the outbox and download cache: badger (default) or bolt
+	StoragePath      string
+	Transport        offlineSync.SyncTransport // how the gateway reaches the cloud, normally offlineSync.NewS3Transport
+	DynamoClient     *dynamodb.Client
+	RolloutTableName string
+	TenantID         string
+	PollInterval     time.Duration
+}
+
+// wireRequest is a sync request on the LAN
+type wireRequest struct {
+	Key      string            `json:"key"`
+	Data     []byte            `json:"data,omitempty"`
+	Metadata map[string]string `json:"metadata,omitempty"`
+}
+
+// wireReply answers a sync request
+type wireReply struct {
+	Data   []byte `json:"data,omitempty"`
+	Queued bool   `json:"queued,omitempty"` // accepted while offline, uploaded when connected
+	Error  string `json:"error,omitempty"`
+}
+
+// queuedObject is an upload waiting in the outbox
+type queuedObject struct {
+	Data     []byte            `json:"data"`
+	Metadata map[string]string `json:"metadata"`
+}
+
+// NewBroker starts the embedded broker and the bridge
+func NewBroker(config BrokerConfig) (*Broker, error) {
+	if config.Port == 0 {
+		config.Port = 4222
+	}
+	if config.PollInterval == 0 {
+		config.PollInterval = time.Minute
+	}
+
+	store, err := kvstore.Open(config.StorageBackend, config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	// The bridge connects in-process with its own generated credentials
+	bridgePassword, err := randomPassword()
+	if err != nil {
+		store.Close()
+		return nil, err
+	}
+
+	users := []*natsserver.User{{Username: "bridge", Password: bridgePassword}}
+	for _, device := range config.Devices {
+		users = append(users, &natsserver.User{
+			Username: device.DeviceID,
+			Password: device.Password,
+			Permissions: &natsserver.Permissions{
+				Publish: &natsserver.SubjectPermission{
+					Allow: []string{fmt.Sprintf("edge.sync.%s.>", device.DeviceID), rolloutsGetSubject},
+				},
+				Subscribe: &natsserver.SubjectPermission{
+					Allow: []string{RolloutsSubject, "_INBOX.>"},
+				},
+			},
+		})
+	}
+
+	server, err := natsserver.NewServer(&natsserver.Options{
+		Host:   config.Host,
+		Port:   config.Port,
+		Users:  users,
+		NoSigs: true,
+	})
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to create broker: %w", err)
+	}
+
+	go server.Start()
+	if !server.ReadyForConnections(10 * time.Second) {
+		server.Shutdown()
+		store.Close()
+		return nil, errors.New("broker did not start")
+	}
+
+	conn, err := nats.Connect(server.ClientURL(), nats.InProcessServer(server), nats.UserInfo("bridge", bridgePassword))
+	if err != nil {
+		server.Shutdown()
+		store.Close()
+		return nil, fmt.Errorf("failed to connect bridge: %w", err)
+	}
+
+	b := &Broker{
+		server:           server,
+		conn:             conn,
+		store:            store,
+		transport:        config.Transport,
+		dynamoClient:     config.DynamoClient,
+		rolloutTableName: config.RolloutTableName,
+		tenantID:         config.TenantID,
+		pollInterval:     config.PollInterval,
+	}
+
+	subscriptions := map[string]nats.MsgHandler{
+		fmt.Sprintf(syncPutSubject, "*"): b.handlePut,
+		fmt.Sprintf(syncGetSubject, "*"): b.handleGet,
+		rolloutsGetSubject:               b.handleRollouts,
+	}
+	for subject, handler := range subscriptions {
+		if _, err := conn.Subscribe(subject, handler); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("failed to subscribe to %s: %w", subject, err)
+		}
+	}
+
+	// Start announcing rollouts
+	if b.dynamoClient != nil {
+		b.pollTimer = time.AfterFunc(b.pollInterval, b.pollLoop)
+	}
+
+	return b, nil
+}
+
+// SetOnlineStatus records whether the gateway can reach AWS, flushing the
+// outbox when it reconnects
+func (b *Broker) SetOnlineStatus(online bool) {
+	b.brokerMutex.Lock()
+	wasOnline := b.isOnline
+	b.isOnline = online
+	b.brokerMutex.Unlock()
+
+	if !wasOnline && online {
+		go func() {
+			if err := b.Flush(context.Background()); err != nil {
+				log.Printf("Failed to flush LAN outbox: %v", err)
+			}
+		}()
+	}
+}
+
+// IsOnline returns whether the gateway can reach AWS
+func (b *Broker) IsOnline() bool {
+	b.brokerMutex.Lock()
+	defer b.brokerMutex.Unlock()
+	return b.isOnline
+}
+
+// Flush uploads every queued object, keeping those that fail for the next attempt
+func (b *Broker) Flush(ctx context.Context) error {
+	b.flushMutex.Lock()
+	defer b.flushMutex.Unlock()
+
+	queued := make(map[string]queuedObject)
+	err := b.store.Iterate([]byte(outboxPrefix), func(key, value []byte) error {
+		var object queuedObject
+		if err := json.Unmarshal(value, &object); err != nil {
+			log.Printf("Dropping unreadable outbox entry %s: %v", key, err)
+			return nil
+		}
+		queued[strings.TrimPrefix(string(key), outboxPrefix)] = object
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read outbox: %w", err)
+	}
+
+	failed := 0
+	for key, object := range queued {
+		if err := b.transport.PutObject(ctx, key, object.Data, object.Metadata); err != nil {
+			log.Printf("Failed to forward %s: %v", key, err)
+			failed++
+			continue
+		}
+
+		if err := b.store.Delete([]byte(outboxPrefix + key)); err != nil {
+			log.Printf("Failed to remove %s from the outbox: %v", key, err)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d queued objects not forwarded", failed, len(queued))
+	}
+
+	return nil
+}
+
+// handlePut forwards a device upload, or queues it while offline
+func (b *Broker) handlePut(msg *nats.Msg) {
+	deviceID, request, err := b.parseRequest(msg)
+	if err != nil {
+		respond(msg, wireReply{Error: err.Error()})
+		return
+	}
+
+	if b.IsOnline() {
+		err := b.transport.PutObject(context.Background(), request.Key, request.Data, request.Metadata)
+		if err == nil {
+			respond(msg, wireReply{})
+			return
+		}
+		log.Printf("Failed to forward %s for %s, queueing: %v", request.Key, deviceID, err)
+	}
+
+	value, err := json.Marshal(queuedObject{Data: request.Data, Metadata: request.Metadata})
+	if err == nil {
+		err = b.store.Set([]byte(outboxPrefix+request.Key), value)
+	}
+	if err != nil {
+		respond(msg, wireReply{Error: "failed to queue upload: " + err.Error()})
+		return
+	}
+
+	respond(msg, wireReply{Queued: true})
+}
+
+// handleGet serves a device download from AWS when online, or the cache when not
+func (b *Broker) handleGet(msg *nats.Msg) {
+	_, request, err := b.parseRequest(msg)
+	if err != nil {
+		respond(msg, wireReply{Error: err.Error()})
+		return
+	}
+
+	if b.IsOnline() {
+		data, err := b.transport.GetObject(context.Background(), request.Key)
+		if err == nil {
+			if err := b.store.Set([]byte(cachePrefix+request.Key), data); err != nil {
+				log.Printf("Failed to cache %s: %v", request.Key, err)
+			}
+			respond(msg, wireReply{Data: data})
+			return
+		}
+		log.Printf("Failed to download %s, trying the cache: %v", request.Key, err)
+	}
+
+	data, err := b.store.Get([]byte(cachePrefix + request.Key))
+	if err != nil {
+		respond(msg, wireReply{Error: "not available offline: " + request.Key})
+		return
+	}
+
+	respond(msg, wireReply{Data: data})
+}
+
+// handleRollouts replies with the last announced rollouts
+func (b *Broker) handleRollouts(msg *nats.Msg) {
+	b.brokerMutex.Lock()
+	rollouts := b.rollouts
+	b.brokerMutex.Unlock()
+
+	if rollouts == nil {
+		rollouts = []byte("[]")
+	}
+	if err := msg.Respond(rollouts); err != nil {
+		log.Printf("Failed to reply with rollouts: %v", err)
+	}
+}
+
+// parseRequest decodes a sync request and checks the key belongs to the requesting device
+func (b *Broker) parseRequest(msg *nats.Msg) (string, wireRequest, error) {
+	var request wireRequest
+
+	// Subjects are edge.sync.<device>.<op>; broker permissions tie <device> to the connection
+	parts := strings.Split(msg.Subject, ".")
+	if len(parts) != 4 {
+		return "", request, fmt.Errorf("invalid subject: %s", msg.Subject)
+	}
+	deviceID := parts[2]
+
+	if err := json.Unmarshal(msg.Data, &request); err != nil {
+		return deviceID, request, errors.New("invalid request")
+	}
+
+	prefix := fmt.Sprintf("%sdevices/%s/", tenant.S3Prefix(b.tenantID), deviceID)
+	if !strings.HasPrefix(request.Key, prefix) || strings.Contains(request.Key, "..") {
+		return deviceID, request, fmt.Errorf("key outside %s", prefix)
+	}
+
+	return deviceID, request, nil
+}
+
+// pollLoop announces rollout changes and reschedules itself
+func (b *Broker) pollLoop() {
+	defer func() {
+		// Reschedule the poll
+		b.pollTimer.Reset(b.pollInterval)
+	}()
+
+	if !b.IsOnline() {
+		return
+	}
+
+	if err := b.announceRollouts(context.Background()); err != nil {
+		log.Printf("Failed to announce rollouts: %v", err)
+	}
+}
+
+// AnnounceNow refreshes and announces the rollouts immediately instead of at
+// the next poll, e.g. from a rollout.QueueWatcher's OnNotice
+func (b *Broker) AnnounceNow() {
+	if b.pollTimer != nil {
+		b.pollTimer.Reset(0)
+	}
+}
+
+// announceRollouts publishes the tenant's in-progress rollouts when they change
+func (b *Broker) announceRollouts(ctx context.Context) error {
+	input := &dynamodb.QueryInput{
+		TableName:              aws.String(b.rolloutTableName),
+		IndexName:              aws.String("StatusIndex"),
+		KeyConditionExpression: aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: "in-progress"},
+		},
+	}
+	if b.tenantID != "" {
+		input.IndexName = aws.String("TenantStatusIndex")
+		input.KeyConditionExpression = aws.String("TenantID = :tenant AND #status = :status")
+		input.ExpressionAttributeValues[":tenant"] = &types.AttributeValueMemberS{Value: b.tenantID}
+	}
+
+	plans := make([]rollout.RolloutPlan, 0)
+	paginator := dynamodb.NewQueryPaginator(b.dynamoClient, input)
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to query rollouts: %w", err)
+		}
+
+		var pagePlans []rollout.RolloutPlan
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &pagePlans); err != nil {
+			return fmt.Errorf("failed to unmarshal rollouts: %w", err)
+		}
+		plans = append(plans, pagePlans...)
+	}
+
+	data, err := json.Marshal(plans)
+	if err != nil {
+		return fmt.Errorf("failed to marshal rollouts: %w", err)
+	}
+
+	b.brokerMutex.Lock()
+	changed := string(data) != string(b.rollouts)
+	b.rollouts = data
+	b.brokerMutex.Unlock()
+
+	if !changed {
+		return nil
+	}
+
+	return b.conn.Publish(RolloutsSubject, data)
+}
+
+// Close stops the bridge and the broker
+func (b *Broker) Close() error {
+	if b.pollTimer != nil {
+		b.pollTimer.Stop()
+	}
+	b.conn.Close()
+	b.server.Shutdown()
+	return b.store.Close()
+}
+
+// Helper functions
+
+// respond sends a JSON reply, logging failures
+func respond(msg *nats.Msg, reply wireReply) {
+	data, err := json.Marshal(reply)
+	if err != nil {
+		log.Printf("Failed to marshal reply: %v", err)
+		return
+	}
+	if err := msg.Respond(data); err != nil {
+		log.Printf("Failed to reply on %s: %v", msg.Subject, err)
+	}
+}
+
+// randomPassword generates a credential for the in-process bridge connection
+func randomPassword() (string, error) {
+	buf := make([]byte, 24)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate bridge password: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
@@ -0,0 +1,138 @@
+package lanbroker
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// Client connects a site-local device to a gateway Broker. It implements
+// offlineSync.SyncTransport, so a SyncManager configured with it syncs over
+// the LAN instead of reaching S3.
+type Client struct {
+	conn     *nats.Conn
+	deviceID string
+	timeout  time.Duration
+}
+
+// ClientConfig contains configuration for the Client
+type ClientConfig struct {
+	URL       string // broker address, e.g. nats://gateway.local:4222
+	DeviceID  string
+	Password  string
+	TLSConfig *tls.Config
+	Timeout   time.Duration // per-request timeout
+}
+
+// NewClient connects to the broker, reconnecting automatically if the LAN drops
+func NewClient(config ClientConfig) (*Client, error) {
+	if config.Timeout == 0 {
+		config.Timeout = 30 * time.Second
+	}
+
+	options := []nats.Option{
+		nats.UserInfo(config.DeviceID, config.Password),
+		nats.Name(config.DeviceID),
+		nats.MaxReconnects(-1),
+	}
+	if config.TLSConfig != nil {
+		options = append(options, nats.Secure(config.TLSConfig))
+	}
+
+	conn, err := nats.Connect(config.URL, options...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to LAN broker: %w", err)
+	}
+
+	return &Client{
+		conn:     conn,
+		deviceID: config.DeviceID,
+		timeout:  config.Timeout,
+	}, nil
+}
+
+// PutObject uploads a sync object through the gateway; the gateway queues it while offline
+func (c *Client) PutObject(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	_, err := c.request(ctx, fmt.Sprintf(syncPutSubject, c.deviceID), wireRequest{Key: key, Data: data, Metadata: metadata})
+	return err
+}
+
+// GetObject downloads a sync object through the gateway, from its cache while offline
+func (c *Client) GetObject(ctx context.Context, key string) ([]byte, error) {
+	reply, err := c.request(ctx, fmt.Sprintf(syncGetSubject, c.deviceID), wireRequest{Key: key})
+	if err != nil {
+		return nil, err
+	}
+	return reply.Data, nil
+}
+
+// Rollouts returns the in-progress rollouts the gateway last saw
+func (c *Client) Rollouts(ctx context.Context) ([]rollout.RolloutPlan, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	msg, err := c.conn.RequestWithContext(ctx, rolloutsGetSubject, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request rollouts: %w", err)
+	}
+
+	var plans []rollout.RolloutPlan
+	if err := json.Unmarshal(msg.Data, &plans); err != nil {
+		return nil, fmt.Errorf("failed to parse rollouts: %w", err)
+	}
+	return plans, nil
+}
+
+// WatchRollouts calls fn whenever the gateway announces a rollout change, e.g.
+// with RolloutManager.CheckNow to pick up a new phase without waiting to poll
+func (c *Client) WatchRollouts(fn func([]rollout.RolloutPlan)) error {
+	_, err := c.conn.Subscribe(RolloutsSubject, func(msg *nats.Msg) {
+		var plans []rollout.RolloutPlan
+		if err := json.Unmarshal(msg.Data, &plans); err != nil {
+			return
+		}
+		fn(plans)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to watch rollouts: %w", err)
+	}
+	return nil
+}
+
+// Close disconnects from the broker
+func (c *Client) Close() {
+	c.conn.Close()
+}
+
+// request sends a sync request and decodes the reply
+func (c *Client) request(ctx context.Context, subject string, request wireRequest) (*wireReply, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	msg, err := c.conn.RequestWithContext(ctx, subject, data)
+	if err != nil {
+		return nil, fmt.Errorf("LAN broker request for %s failed: %w", request.Key, err)
+	}
+
+	var reply wireReply
+	if err := json.Unmarshal(msg.Data, &reply); err != nil {
+		return nil, fmt.Errorf("invalid reply from LAN broker: %w", err)
+	}
+	if reply.Error != "" {
+		return nil, errors.New(reply.Error)
+	}
+
+	return &reply, nil
+}
@@ -0,0 +1,461 @@
+package logshipper
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs"
+	cwltypes "github.com/aws/aws-sdk-go-v2/service/cloudwatchlogs/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+)
+
+// queuePrefix is followed by a big-endian sequence number, so log events
+// iterate in the order they were written
+const queuePrefix = "logs/queue/"
+
+// PutLogEvents limits
+const (
+	maxBatchEvents = 10000
+	maxBatchBytes  = 1048576
+	eventOverhead  = 26 // bytes CloudWatch counts per event on top of the message
+	maxEventBytes  = 256*1024 - eventOverhead
+	maxBatchSpan   = 24 * time.Hour
+)
+
+// errStopIteration ends a store iteration early
+var errStopIteration = errors.New("stop iteration")
+
+// logEvent is a log line waiting in the buffer
+type logEvent struct {
+	Timestamp int64  `json:"t"` // Unix milliseconds
+	Message   string `json:"m"`
+}
+
+// CloudWatchSink ships agent log lines to a per-device log stream in
+// CloudWatch Logs. Lines are queued in the local store as they are written
+// and sent in batches, so logs written while the device is offline, or
+// before a restart, are delivered once CloudWatch is reachable again. It is
+// an io.Writer for log.New or log.SetOutput, and a Printf Logger for the
+// managers' WithLogger.
+type CloudWatchSink struct {
+	client        *cloudwatchlogs.Client
+	logGroupName  string
+	logStreamName string
+	store         kvstore.KVStore
+	batchSize     int
+	maxEvents     int
+	maxAge        time.Duration
+	flushInterval time.Duration
+	flushTimer    *time.Timer
+	streamReady   bool
+	failing       bool
+	nextSequence  uint64
+	queued        int
+	dropped       uint64
+	partial       []byte
+	bufferMutex   sync.Mutex
+	flushMutex    sync.Mutex
+}
+
+// CloudWatchSinkConfig contains configuration for the CloudWatchSink
+type CloudWatchSinkConfig struct {
+	Client         *cloudwatchlogs.Client
+	LogGroupName   string // must exist; the sink creates its log stream in it
+	DeviceID       string
+	TenantID       string        // streams are named "<tenant>/<device>" for tenants, "<device>" otherwise
+	StorageBackend string        // badger (default), bolt or memory
+	StoragePath    string        // not shared with other stores
+	BatchSize      int           // events per request; defaults to 1000, at most 10000
+	MaxEvents      int           // events kept while CloudWatch is unreachable; defaults to 100000, the oldest are dropped beyond it
+	MaxAge         time.Duration // defaults to 7 days; older events are dropped unsent, and CloudWatch rejects events older than 14 days
+	FlushInterval  time.Duration // defaults to 5 seconds
+}
+
+// NewCloudWatchSink creates a new CloudWatchSink, restoring log events
+// queued before a restart, and starts flushing
+func NewCloudWatchSink(config CloudWatchSinkConfig) (*CloudWatchSink, error) {
+	if config.BatchSize == 0 {
+		config.BatchSize = 1000
+	}
+	if config.MaxEvents == 0 {
+		config.MaxEvents = 100000
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = 7 * 24 * time.Hour
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 5 * time.Second
+	}
+
+	switch {
+	case config.LogGroupName == "":
+		return nil, errors.New("a log group name is required")
+	case config.DeviceID == "":
+		return nil, errors.New("a device ID is required")
+	case config.BatchSize < 0 || config.BatchSize > maxBatchEvents:
+		return nil, fmt.Errorf("batch size %d must be between 1 and %d", config.BatchSize, maxBatchEvents)
+	case config.MaxEvents < config.BatchSize:
+		return nil, fmt.Errorf("max events %d must be at least the batch size %d", config.MaxEvents, config.BatchSize)
+	}
+
+	store, err := kvstore.Open(config.StorageBackend, config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	cs := &CloudWatchSink{
+		client:        config.Client,
+		logGroupName:  config.LogGroupName,
+		logStreamName: StreamName(config.TenantID, config.DeviceID),
+		store:         store,
+		batchSize:     config.BatchSize,
+		maxEvents:     config.MaxEvents,
+		maxAge:        config.MaxAge,
+		flushInterval: config.FlushInterval,
+	}
+
+	err = store.Iterate([]byte(queuePrefix), func(key, value []byte) error {
+		cs.queued++
+		cs.nextSequence = sequence(key) + 1
+		return nil
+	})
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to read log buffer: %w", err)
+	}
+
+	// Start the flush timer
+	cs.flushTimer = time.AfterFunc(0, cs.flushLoop)
+
+	return cs, nil
+}
+
+// StreamName returns the log stream of a device; CloudWatch doesn't allow
+// ":" or "*" in stream names, so tenant-scoped device keys can't be used
+func StreamName(tenantID, deviceID string) string {
+	if tenantID == "" {
+		return deviceID
+	}
+	return tenantID + "/" + deviceID
+}
+
+// Write queues each complete line in p; a trailing partial line waits for
+// the rest. It only fails if a line can't be queued.
+func (cs *CloudWatchSink) Write(p []byte) (int, error) {
+	now := time.Now().UnixMilli()
+
+	cs.bufferMutex.Lock()
+	cs.partial = append(cs.partial, p...)
+	var lines []string
+	for {
+		i := bytes.IndexByte(cs.partial, '\n')
+		if i < 0 {
+			break
+		}
+		if i > 0 {
+			lines = append(lines, string(cs.partial[:i]))
+		}
+		cs.partial = cs.partial[i+1:]
+	}
+	cs.bufferMutex.Unlock()
+
+	for _, line := range lines {
+		if err := cs.enqueue(logEvent{Timestamp: now, Message: line}); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(p), nil
+}
+
+// Printf queues a formatted log line, so the sink can be passed to WithLogger
+// directly; log.New(sink, ...) adds timestamps and prefixes instead
+func (cs *CloudWatchSink) Printf(format string, v ...interface{}) {
+	message := fmt.Sprintf(format, v...)
+	if err := cs.enqueue(logEvent{Timestamp: time.Now().UnixMilli(), Message: message}); err != nil {
+		log.Printf("Failed to buffer log line: %v", err)
+	}
+}
+
+// flushLoop sends queued log events and reschedules itself
+func (cs *CloudWatchSink) flushLoop() {
+	defer func() {
+		// Reschedule the flush
+		cs.flushTimer.Reset(cs.flushInterval)
+	}()
+
+	err := cs.Flush(context.Background())
+
+	// Only report changes, so an offline device doesn't log a failure per flush
+	cs.bufferMutex.Lock()
+	wasFailing := cs.failing
+	cs.failing = err != nil
+	cs.bufferMutex.Unlock()
+
+	if err != nil && !wasFailing {
+		log.Printf("Failed to ship logs to CloudWatch, buffering: %v", err)
+	}
+	if err == nil && wasFailing {
+		log.Printf("Shipping logs to CloudWatch again")
+	}
+}
+
+// Flush sends queued log events oldest first, stopping at the first failure
+// so events stay in order; events older than MaxAge are dropped
+func (cs *CloudWatchSink) Flush(ctx context.Context) error {
+	cs.flushMutex.Lock()
+	defer cs.flushMutex.Unlock()
+
+	if !cs.streamReady {
+		if err := cs.createStream(ctx); err != nil {
+			return err
+		}
+		cs.streamReady = true
+	}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		keys, events, err := cs.nextBatch()
+		if err != nil {
+			return err
+		}
+		if len(keys) == 0 {
+			return nil
+		}
+
+		if len(events) > 0 {
+			if err := cs.put(ctx, events); err != nil {
+				return err
+			}
+		}
+
+		cs.remove(keys)
+	}
+}
+
+// Queued returns how many log events wait to be sent, and how many were
+// dropped unsent since the sink was created
+func (cs *CloudWatchSink) Queued() (int, uint64) {
+	cs.bufferMutex.Lock()
+	defer cs.bufferMutex.Unlock()
+	return cs.queued, cs.dropped
+}
+
+// Close stops the flush timer, makes a last attempt to send queued events
+// and closes the local store; unsent events are kept for the next start
+func (cs *CloudWatchSink) Close() error {
+	if cs.flushTimer != nil {
+		cs.flushTimer.Stop()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	flushErr := cs.Flush(ctx)
+
+	if err := cs.store.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// nextBatch reads the oldest queued events that fit one PutLogEvents request.
+// Every key read is returned; expired and unreadable events are among the
+// keys but not the events, so they are removed without being sent.
+func (cs *CloudWatchSink) nextBatch() ([][]byte, []logEvent, error) {
+	cutoff := time.Now().Add(-cs.maxAge).UnixMilli()
+	keys := make([][]byte, 0)
+	events := make([]logEvent, 0)
+	size := 0
+	expired := 0
+
+	err := cs.store.Iterate([]byte(queuePrefix), func(key, value []byte) error {
+		var event logEvent
+		if err := json.Unmarshal(value, &event); err != nil || event.Timestamp < cutoff {
+			keys = append(keys, append([]byte(nil), key...))
+			expired++
+			return nil
+		}
+
+		eventSize := len(event.Message) + eventOverhead
+		switch {
+		case len(events) == cs.batchSize:
+			return errStopIteration
+		case size+eventSize > maxBatchBytes:
+			return errStopIteration
+		case len(events) > 0 && event.Timestamp-events[0].Timestamp > maxBatchSpan.Milliseconds():
+			return errStopIteration
+		}
+
+		keys = append(keys, append([]byte(nil), key...))
+		events = append(events, event)
+		size += eventSize
+		return nil
+	})
+	if err != nil && err != errStopIteration {
+		return nil, nil, fmt.Errorf("failed to read log buffer: %w", err)
+	}
+
+	if expired > 0 {
+		cs.bufferMutex.Lock()
+		cs.dropped += uint64(expired)
+		cs.bufferMutex.Unlock()
+	}
+
+	return keys, events, nil
+}
+
+// put sends one batch of events to the device's log stream
+func (cs *CloudWatchSink) put(ctx context.Context, events []logEvent) error {
+	// CloudWatch requires chronological order; the clock can step back
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Timestamp < events[j].Timestamp
+	})
+
+	inputEvents := make([]cwltypes.InputLogEvent, len(events))
+	for i, event := range events {
+		inputEvents[i] = cwltypes.InputLogEvent{
+			Timestamp: aws.Int64(event.Timestamp),
+			Message:   aws.String(event.Message),
+		}
+	}
+
+	result, err := cs.client.PutLogEvents(ctx, &cloudwatchlogs.PutLogEventsInput{
+		LogGroupName:  aws.String(cs.logGroupName),
+		LogStreamName: aws.String(cs.logStreamName),
+		LogEvents:     inputEvents,
+	})
+	if err != nil {
+		var notFound *cwltypes.ResourceNotFoundException
+		if errors.As(err, &notFound) {
+			// The stream was deleted; create it again on the next flush
+			cs.streamReady = false
+		}
+		return fmt.Errorf("failed to put log events: %w", err)
+	}
+
+	// Rejected events would be rejected again, so they are dropped
+	if rejected := result.RejectedLogEventsInfo; rejected != nil {
+		log.Printf("CloudWatch rejected log events: too old up to %d, expired up to %d, too new from %d",
+			aws.ToInt32(rejected.TooOldLogEventEndIndex), aws.ToInt32(rejected.ExpiredLogEventEndIndex), aws.ToInt32(rejected.TooNewLogEventStartIndex))
+	}
+
+	return nil
+}
+
+// createStream creates the device's log stream unless it exists
+func (cs *CloudWatchSink) createStream(ctx context.Context) error {
+	_, err := cs.client.CreateLogStream(ctx, &cloudwatchlogs.CreateLogStreamInput{
+		LogGroupName:  aws.String(cs.logGroupName),
+		LogStreamName: aws.String(cs.logStreamName),
+	})
+	if err != nil {
+		var exists *cwltypes.ResourceAlreadyExistsException
+		if errors.As(err, &exists) {
+			return nil
+		}
+		return fmt.Errorf("failed to create log stream %s: %w", cs.logStreamName, err)
+	}
+
+	return nil
+}
+
+// enqueue stores a log event, dropping the oldest if the buffer is full
+func (cs *CloudWatchSink) enqueue(event logEvent) error {
+	if len(event.Message) > maxEventBytes {
+		event.Message = event.Message[:maxEventBytes]
+	}
+
+	value, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal log event: %w", err)
+	}
+
+	cs.bufferMutex.Lock()
+	defer cs.bufferMutex.Unlock()
+
+	if err := cs.store.Set(queueKey(cs.nextSequence), value); err != nil {
+		return fmt.Errorf("failed to buffer log event: %w", err)
+	}
+	cs.nextSequence++
+	cs.queued++
+
+	for cs.queued > cs.maxEvents {
+		oldest, err := cs.oldestKey()
+		if err != nil || oldest == nil {
+			break
+		}
+		if err := cs.store.Delete(oldest); err != nil {
+			break
+		}
+		cs.queued--
+		cs.dropped++
+	}
+
+	// A full batch goes out right away, unless CloudWatch is unreachable
+	if cs.queued >= cs.batchSize && !cs.failing && cs.flushTimer != nil {
+		cs.flushTimer.Reset(0)
+	}
+
+	return nil
+}
+
+// remove deletes sent or dropped events from the buffer
+func (cs *CloudWatchSink) remove(keys [][]byte) {
+	cs.bufferMutex.Lock()
+	defer cs.bufferMutex.Unlock()
+
+	for _, key := range keys {
+		if err := cs.store.Delete(key); err != nil {
+			continue
+		}
+		// The event may already have been dropped by enqueue
+		if cs.queued > 0 {
+			cs.queued--
+		}
+	}
+}
+
+// oldestKey returns the key of the oldest queued event, or nil; the caller
+// holds bufferMutex
+func (cs *CloudWatchSink) oldestKey() ([]byte, error) {
+	var oldest []byte
+	err := cs.store.Iterate([]byte(queuePrefix), func(key, value []byte) error {
+		oldest = append([]byte(nil), key...)
+		return errStopIteration
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	return oldest, nil
+}
+
+// Helper functions
+
+// queueKey returns the store key of the event with sequence number sequence
+func queueKey(sequence uint64) []byte {
+	key := make([]byte, len(queuePrefix)+8)
+	copy(key, queuePrefix)
+	binary.BigEndian.PutUint64(key[len(queuePrefix):], sequence)
+	return key
+}
+
+// sequence returns the sequence number of a queue key
+func sequence(key []byte) uint64 {
+	if len(key) != len(queuePrefix)+8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(key[len(queuePrefix):])
+}
@@ -0,0 +1,218 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	snstypes "github.com/aws/aws-sdk-go-v2/service/sns/types"
+)
+
+// pagerDutyEventsURL is the PagerDuty Events API v2 endpoint
+const pagerDutyEventsURL = "https://events.pagerduty.com/v2/enqueue"
+
+// SlackNotifier posts events to a Slack incoming webhook
+type SlackNotifier struct {
+	webhookURL string
+	httpClient *http.Client
+}
+
+// NewSlackNotifier creates a new SlackNotifier
+func NewSlackNotifier(webhookURL string) *SlackNotifier {
+	return &SlackNotifier{
+		webhookURL: webhookURL,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify posts the event to Slack
+func (s *SlackNotifier) Notify(ctx context.Context, event Event) error {
+	payload := map[string]interface{}{
+		"text": event.Summary(),
+	}
+
+	return postJSON(ctx, s.httpClient, s.webhookURL, payload)
+}
+
+// PagerDutyNotifier raises PagerDuty incidents through the Events API v2
+type PagerDutyNotifier struct {
+	routingKey string
+	httpClient *http.Client
+}
+
+// NewPagerDutyNotifier creates a new PagerDutyNotifier
+func NewPagerDutyNotifier(routingKey string) *PagerDutyNotifier {
+	return &PagerDutyNotifier{
+		routingKey: routingKey,
+		httpClient: &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Notify triggers a PagerDuty alert, deduplicated per rollout and event type
+func (p *PagerDutyNotifier) Notify(ctx context.Context, event Event) error {
+	payload := map[string]interface{}{
+		"routing_key":  p.routingKey,
+		"event_action": "trigger",
+		"dedup_key":    fmt.Sprintf("%s/%s/%s", event.RolloutID, event.Type, event.DeviceID),
+		"payload": map[string]interface{}{
+			"summary":        event.Summary(),
+			"source":         "edge-rollout",
+			"severity":       pagerDutySeverity(event.Severity),
+			"timestamp":      event.Timestamp.Format(time.RFC3339),
+			"component":      event.RolloutID,
+			"class":          string(event.Type),
+			"custom_details": event.Details,
+		},
+	}
+
+	return postJSON(ctx, p.httpClient, pagerDutyEventsURL, payload)
+}
+
+// SNSNotifier publishes events as JSON to an SNS topic
+type SNSNotifier struct {
+	snsClient *sns.Client
+	topicARN  string
+}
+
+// NewSNSNotifier creates a new SNSNotifier
+func NewSNSNotifier(snsClient *sns.Client, topicARN string) *SNSNotifier {
+	return &SNSNotifier{
+		snsClient: snsClient,
+		topicARN:  topicARN,
+	}
+}
+
+// Notify publishes the event to SNS with attributes for subscription filtering
+func (s *SNSNotifier) Notify(ctx context.Context, event Event) error {
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	_, err = s.snsClient.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(s.topicARN),
+		Subject:  aws.String(truncate(event.Summary(), 100)),
+		Message:  aws.String(string(body)),
+		MessageAttributes: map[string]snstypes.MessageAttributeValue{
+			"eventType": {DataType: aws.String("String"), StringValue: aws.String(string(event.Type))},
+			"severity":  {DataType: aws.String("String"), StringValue: aws.String(string(event.Severity))},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to publish to SNS: %w", err)
+	}
+
+	return nil
+}
+
+// EventBridgeNotifier puts events on an EventBridge bus, so AWS-native
+// automation can subscribe with rules on the detail type (the event type)
+// instead of reading the fleet tables
+type EventBridgeNotifier struct {
+	eventBridgeClient *eventbridge.Client
+	eventBusName      string
+	source            string
+}
+
+// NewEventBridgeNotifier creates a new EventBridgeNotifier; an empty bus name
+// means the account's default bus, and an empty source "edge-rollout"
+func NewEventBridgeNotifier(eventBridgeClient *eventbridge.Client, eventBusName, source string) *EventBridgeNotifier {
+	if source == "" {
+		source = "edge-rollout"
+	}
+
+	return &EventBridgeNotifier{
+		eventBridgeClient: eventBridgeClient,
+		eventBusName:      eventBusName,
+		source:            source,
+	}
+}
+
+// Notify puts the event on the bus with the event as its JSON detail
+func (e *EventBridgeNotifier) Notify(ctx context.Context, event Event) error {
+	detail, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	entry := ebtypes.PutEventsRequestEntry{
+		Source:     aws.String(e.source),
+		DetailType: aws.String(string(event.Type)),
+		Detail:     aws.String(string(detail)),
+	}
+	if e.eventBusName != "" {
+		entry.EventBusName = aws.String(e.eventBusName)
+	}
+	if !event.Timestamp.IsZero() {
+		entry.Time = aws.Time(event.Timestamp)
+	}
+
+	result, err := e.eventBridgeClient.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{entry},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to put event to EventBridge: %w", err)
+	}
+
+	// PutEvents succeeds as a call even when an entry is rejected
+	if result.FailedEntryCount > 0 && len(result.Entries) > 0 {
+		failed := result.Entries[0]
+		return fmt.Errorf("EventBridge rejected event: %s: %s", aws.ToString(failed.ErrorCode), aws.ToString(failed.ErrorMessage))
+	}
+
+	return nil
+}
+
+// Helper functions
+
+func postJSON(ctx context.Context, client *http.Client, url string, payload interface{}) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal payload: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send notification: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		respBody, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("notification endpoint returned %s: %s", resp.Status, string(respBody))
+	}
+
+	return nil
+}
+
+func pagerDutySeverity(s Severity) string {
+	switch s {
+	case SeverityCritical:
+		return "critical"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "info"
+	}
+}
+
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	return s[:max-3] + "..."
+}
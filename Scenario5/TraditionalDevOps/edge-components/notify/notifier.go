@@ -0,0 +1,136 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// EventType identifies the kind of rollout event being notified
+type EventType string
+
+const (
+	// EventDeviceUpdateFailed is raised when a device reports a failed update
+	EventDeviceUpdateFailed EventType = "device.update.failed"
+
+	// EventPhaseThresholdBreached is raised when a phase exceeds its failure threshold
+	EventPhaseThresholdBreached EventType = "phase.threshold.breached"
+
+	// EventRolloutRolledBack is raised when a rollout is automatically rolled back
+	EventRolloutRolledBack EventType = "rollout.rolled-back"
+
+	// EventRolloutPaused is raised when a rollout is automatically paused
+	EventRolloutPaused EventType = "rollout.paused"
+
+	// EventPhaseExpired is raised when a phase outlives its duration
+	EventPhaseExpired EventType = "phase.expired"
+
+	// EventRolloutCreated is raised when a rollout is created
+	EventRolloutCreated EventType = "rollout.created"
+
+	// EventPhaseAdvanced is raised when a rollout moves on to its next phase
+	EventPhaseAdvanced EventType = "phase.advanced"
+
+	// EventRolloutCompleted is raised when a rollout finishes its final phase
+	EventRolloutCompleted EventType = "rollout.completed"
+
+	// EventRolloutAborted is raised when an operator aborts a rollout
+	EventRolloutAborted EventType = "rollout.aborted"
+)
+
+// Severity indicates how urgently an event needs attention
+type Severity string
+
+const (
+	SeverityInfo     Severity = "info"
+	SeverityWarning  Severity = "warning"
+	SeverityCritical Severity = "critical"
+)
+
+// Event describes a rollout failure worth notifying about, or a lifecycle
+// change at SeverityInfo for automation such as the EventBridgeNotifier
+type Event struct {
+	Type      EventType         `json:"type"`
+	Severity  Severity          `json:"severity"`
+	RolloutID string            `json:"rolloutId"`
+	PhaseID   string            `json:"phaseId,omitempty"`
+	DeviceID  string            `json:"deviceId,omitempty"`
+	Message   string            `json:"message"`
+	Details   map[string]string `json:"details,omitempty"`
+	Timestamp time.Time         `json:"timestamp"`
+}
+
+// Summary returns a single-line human readable description of the event
+func (e Event) Summary() string {
+	parts := []string{fmt.Sprintf("[%s] %s", strings.ToUpper(string(e.Severity)), e.Type)}
+	if e.RolloutID != "" {
+		parts = append(parts, "rollout "+e.RolloutID)
+	}
+	if e.PhaseID != "" {
+		parts = append(parts, "phase "+e.PhaseID)
+	}
+	if e.DeviceID != "" {
+		parts = append(parts, "device "+e.DeviceID)
+	}
+	return strings.Join(parts, " ") + ": " + e.Message
+}
+
+// Notifier is an interface for delivering rollout events to people or systems
+type Notifier interface {
+	// Notify delivers an event
+	Notify(ctx context.Context, event Event) error
+}
+
+// MultiNotifier fans an event out to several notifiers
+type MultiNotifier struct {
+	notifiers []Notifier
+}
+
+// NewMultiNotifier creates a MultiNotifier
+func NewMultiNotifier(notifiers ...Notifier) *MultiNotifier {
+	return &MultiNotifier{notifiers: notifiers}
+}
+
+// Notify delivers the event to every notifier, returning the combined errors
+func (m *MultiNotifier) Notify(ctx context.Context, event Event) error {
+	var errs []string
+	for _, notifier := range m.notifiers {
+		if err := notifier.Notify(ctx, event); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("failed to deliver notification: %s", strings.Join(errs, "; "))
+	}
+
+	return nil
+}
+
+// SeverityFilter only forwards events at or above a minimum severity
+type SeverityFilter struct {
+	Notifier    Notifier
+	MinSeverity Severity
+}
+
+// Notify forwards the event if it is severe enough
+func (f *SeverityFilter) Notify(ctx context.Context, event Event) error {
+	if severityRank(event.Severity) < severityRank(f.MinSeverity) {
+		return nil
+	}
+	return f.Notifier.Notify(ctx, event)
+}
+
+// Helper functions
+
+func severityRank(s Severity) int {
+	switch s {
+	case SeverityCritical:
+		return 2
+	case SeverityWarning:
+		return 1
+	default:
+		return 0
+	}
+}
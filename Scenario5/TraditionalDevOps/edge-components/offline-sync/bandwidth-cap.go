@@ -0,0 +1,45 @@
+package offlineSync
+
+import (
+	"fmt"
+	"time"
+)
+
+// withinCap reports whether a data type may sync under the bandwidth meter:
+// always without a meter or for critical types, otherwise until the cap is used up
+func (sm *SyncManager) withinCap(dataType string) bool {
+	if sm.meter == nil || sm.criticalTypes[dataType] {
+		return true
+	}
+	return sm.meter.Allow(false) == nil
+}
+
+// alreadyFetched reports whether an update came down in an earlier sync that
+// deferred others, so it isn't downloaded again while the cap holds the last
+// sync time back
+func (sm *SyncManager) alreadyFetched(key string, timestamp time.Time) bool {
+	fetched, ok := sm.fetched[key]
+	return ok && !timestamp.After(fetched)
+}
+
+// markFetched records a downloaded update
+func (sm *SyncManager) markFetched(key string, timestamp time.Time) {
+	if sm.meter == nil {
+		return
+	}
+	if sm.fetched == nil {
+		sm.fetched = make(map[string]time.Time)
+	}
+	sm.fetched[key] = timestamp
+}
+
+// finishFetch ends a download pass: with deferred updates it returns
+// ErrBandwidthCap, so the sync doesn't advance the last sync time past them;
+// otherwise the fetched updates are covered by the last sync time again
+func (sm *SyncManager) finishFetch(deferred int) error {
+	if deferred > 0 {
+		return fmt.Errorf("%d updates deferred: %w", deferred, ErrBandwidthCap)
+	}
+	sm.fetched = nil
+	return nil
+}
@@ -0,0 +1,250 @@
+package offlineSync
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// ConflictStrategy decides what happens when an update comes down for a key
+// that also changed on the device since the last sync
+type ConflictStrategy string
+
+// Conflict strategies for SyncConfig.ConflictStrategies
+const (
+	// ConflictServerWins applies the update over the local change; it is
+	// the default, and what the manager always did before strategies
+	ConflictServerWins ConflictStrategy = "server-wins"
+
+	// ConflictClientWins keeps the local change and drops the update
+	ConflictClientWins ConflictStrategy = "client-wins"
+
+	// ConflictLastWriterWins keeps whichever changed last: the local change
+	// by the device's clock or the update by its manifest timestamp
+	ConflictLastWriterWins ConflictStrategy = "last-writer-wins"
+
+	// ConflictMerge applies the data type's SyncHandler.MergeConflicts
+	// result and queues it for upload
+	ConflictMerge ConflictStrategy = "merge"
+
+	// ConflictManual applies neither and queues the conflict until
+	// ResolveConflict is called, e.g. by an operator
+	ConflictManual ConflictStrategy = "manual"
+)
+
+// conflictPrefix prefixes the local store keys of conflicts waiting for
+// ResolveConflict, so they survive restarts
+const conflictPrefix = "sync-conflicts/"
+
+// Conflict is a key changed both on the device and remotely, waiting for ResolveConflict
+type Conflict struct {
+	Key             string    `json:"key"`
+	DataType        string    `json:"dataType"`
+	Local           []byte    `json:"local"`
+	Remote          []byte    `json:"remote"`
+	LocalChangedAt  time.Time `json:"localChangedAt"`
+	RemoteChangedAt time.Time `json:"remoteChangedAt"`
+	DetectedAt      time.Time `json:"detectedAt"`
+}
+
+// localEdit is a change made on the device since the last sync
+type localEdit struct {
+	data      []byte
+	changedAt time.Time
+}
+
+// validStrategy reports whether a strategy is one of the known ones
+func validStrategy(strategy ConflictStrategy) bool {
+	switch strategy {
+	case ConflictServerWins, ConflictClientWins, ConflictLastWriterWins, ConflictMerge, ConflictManual:
+		return true
+	}
+	return false
+}
+
+// recordLocalEdit remembers a local change until a sync has reconciled it
+// with the updates that came down; call with changesMutex held
+func (sm *SyncManager) recordLocalEdit(key string, data []byte) {
+	sm.localEdits[key] = localEdit{data: data, changedAt: sm.clock.Now()}
+}
+
+// clearLocalEdits forgets local changes made before a sync that finished downloading
+func (sm *SyncManager) clearLocalEdits(before time.Time) {
+	sm.changesMutex.Lock()
+	defer sm.changesMutex.Unlock()
+
+	for key, edit := range sm.localEdits {
+		if !edit.changedAt.After(before) {
+			delete(sm.localEdits, key)
+		}
+	}
+}
+
+// resolveIncoming applies the data type's strategy to an update and returns
+// the data to apply locally, or false when nothing should be applied
+func (sm *SyncManager) resolveIncoming(dataType, key string, remote []byte, remoteChangedAt time.Time) ([]byte, bool) {
+	sm.changesMutex.Lock()
+	edit, edited := sm.localEdits[key]
+	_, queued := sm.conflicts[key]
+	sm.changesMutex.Unlock()
+
+	if !edited && !queued {
+		return remote, true
+	}
+
+	strategy := sm.conflictStrategies[dataType]
+	if strategy == "" {
+		strategy = ConflictServerWins
+	}
+
+	// A key with a queued conflict stays queued, with the newer remote copy
+	if queued {
+		strategy = ConflictManual
+	}
+
+	switch strategy {
+	case ConflictClientWins:
+		return nil, false
+
+	case ConflictLastWriterWins:
+		if edit.changedAt.After(remoteChangedAt) {
+			return nil, false
+		}
+		return remote, true
+
+	case ConflictMerge:
+		handler, ok := sm.syncHandlers[dataType]
+		if !ok {
+			sm.logger.Printf("No handler to merge conflict on %s; queueing it", key)
+			break
+		}
+		merged, err := handler.MergeConflicts(edit.data, remote)
+		if err != nil {
+			sm.logger.Printf("Failed to merge conflict on %s; queueing it: %v", key, err)
+			break
+		}
+		if err := sm.queueChange(key, merged); err != nil {
+			sm.logger.Printf("Failed to queue merged %s: %v", key, err)
+		}
+		return merged, true
+
+	case ConflictManual:
+	default:
+		return remote, true
+	}
+
+	sm.queueConflict(Conflict{
+		Key:             key,
+		DataType:        dataType,
+		Local:           edit.data,
+		Remote:          remote,
+		LocalChangedAt:  edit.changedAt,
+		RemoteChangedAt: remoteChangedAt,
+		DetectedAt:      sm.clock.Now(),
+	})
+	return nil, false
+}
+
+// queueConflict stores a conflict for ResolveConflict; a queued conflict only takes the newer remote copy
+func (sm *SyncManager) queueConflict(conflict Conflict) {
+	sm.changesMutex.Lock()
+	defer sm.changesMutex.Unlock()
+
+	if existing, ok := sm.conflicts[conflict.Key]; ok {
+		existing.Remote = conflict.Remote
+		existing.RemoteChangedAt = conflict.RemoteChangedAt
+		conflict = *existing
+	} else {
+		sm.logger.Printf("Conflict on %s queued for manual resolution", conflict.Key)
+	}
+	sm.conflicts[conflict.Key] = &conflict
+
+	data, err := json.Marshal(conflict)
+	if err == nil {
+		err = sm.store.Set([]byte(conflictPrefix+conflict.Key), data)
+	}
+	if err != nil {
+		sm.logger.Printf("Failed to persist conflict on %s: %v", conflict.Key, err)
+	}
+}
+
+// Conflicts returns the conflicts waiting for ResolveConflict, by key
+func (sm *SyncManager) Conflicts() []Conflict {
+	sm.changesMutex.Lock()
+	defer sm.changesMutex.Unlock()
+
+	conflicts := make([]Conflict, 0, len(sm.conflicts))
+	for _, conflict := range sm.conflicts {
+		conflicts = append(conflicts, *conflict)
+	}
+	sort.Slice(conflicts, func(i, j int) bool {
+		return conflicts[i].Key < conflicts[j].Key
+	})
+
+	return conflicts
+}
+
+// ResolveConflict settles a queued conflict with data, which may be the
+// local copy, the remote copy or a merge of both. The data is applied like
+// a downloaded update and queued for upload.
+func (sm *SyncManager) ResolveConflict(key string, data []byte) error {
+	sm.changesMutex.Lock()
+	conflict, ok := sm.conflicts[key]
+	sm.changesMutex.Unlock()
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrNoConflict, key)
+	}
+
+	filePath := filepath.Join(sm.localCachePath, key)
+	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+		return fmt.Errorf("failed to create directory for %s: %w", key, err)
+	}
+	if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s to cache: %w", key, err)
+	}
+
+	if handler, ok := sm.syncHandlers[conflict.DataType]; ok {
+		if err := handler.ProcessUpdate(key, data); err != nil {
+			return fmt.Errorf("handler failed to process %s: %w", key, err)
+		}
+	}
+
+	sm.changesMutex.Lock()
+	delete(sm.conflicts, key)
+	sm.changesMutex.Unlock()
+	if err := sm.store.Delete([]byte(conflictPrefix + key)); err != nil {
+		sm.logger.Printf("Failed to delete resolved conflict on %s: %v", key, err)
+	}
+
+	return sm.AddPendingChange(key, data)
+}
+
+// loadConflicts restores the conflicts queued before a restart
+func (sm *SyncManager) loadConflicts() error {
+	return sm.store.Iterate([]byte(conflictPrefix), func(key, value []byte) error {
+		var conflict Conflict
+		if err := json.Unmarshal(value, &conflict); err != nil {
+			sm.logger.Printf("Skipping unreadable conflict %s: %v", key, err)
+			return nil
+		}
+		sm.conflicts[conflict.Key] = &conflict
+		return nil
+	})
+}
+
+// queueChange queues data for upload without starting a sync, for changes
+// made while one is running
+func (sm *SyncManager) queueChange(key string, data []byte) error {
+	sm.changesMutex.Lock()
+	sm.pendingChanges[key] = data
+	sm.changesMutex.Unlock()
+
+	if err := sm.store.Set([]byte(key), data); err != nil {
+		return fmt.Errorf("failed to store pending change: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,35 @@
+package offlineSync
+
+import (
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+)
+
+// ContentHashMetadata is the object metadata holding the SHA-256 of an
+// uploaded change, so the stored copy can be compared without downloading it
+const ContentHashMetadata = "content-sha256"
+
+// uploadedHashPrefix prefixes the local store keys recording the content hash
+// of each object last uploaded, by full object key. Keying by object key,
+// which includes the device ID, keeps records restored from another device's
+// snapshot from matching this device's objects.
+const uploadedHashPrefix = "sync-uploaded/"
+
+// alreadyUploaded reports whether the object at objectKey was last uploaded
+// with this content hash, so uploading it again would change nothing
+func (sm *SyncManager) alreadyUploaded(objectKey, hash string) bool {
+	stored, err := sm.store.Get([]byte(uploadedHashPrefix + objectKey))
+	if err != nil {
+		if err != kvstore.ErrKeyNotFound {
+			sm.logger.Printf("Failed to read upload record for %s: %v", objectKey, err)
+		}
+		return false
+	}
+	return string(stored) == hash
+}
+
+// recordUpload remembers the content hash of a successful upload
+func (sm *SyncManager) recordUpload(objectKey, hash string) {
+	if err := sm.store.Set([]byte(uploadedHashPrefix+objectKey), []byte(hash)); err != nil {
+		sm.logger.Printf("Failed to record upload of %s: %v", objectKey, err)
+	}
+}
@@ -0,0 +1,268 @@
+package offlineSync
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// latestSnapshot is the object, under a device's snapshots prefix, that
+// holds a copy of the most recent snapshot manifest
+const latestSnapshot = "latest.json"
+
+// CachedFile is a file in the local cache captured by a snapshot
+type CachedFile struct {
+	Path   string `json:"path"`
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256"`
+}
+
+// Snapshot describes a backup of a device's sync state: the local store, the
+// changes still queued for upload and the local cache
+type Snapshot struct {
+	ID           string       `json:"id"`
+	DeviceID     string       `json:"deviceId"`
+	CreatedAt    time.Time    `json:"createdAt"`
+	StoreSize    int64        `json:"storeSize"`
+	StoreSHA256  string       `json:"storeSha256"`
+	PendingKeys  []string     `json:"pendingKeys"`
+	CacheFiles   []CachedFile `json:"cacheFiles"`
+	LastSyncTime time.Time    `json:"lastSyncTime"`
+}
+
+// Backup uploads a snapshot of the local store and cache, so the device's
+// queued data survives a hardware swap. Syncs are held off while it runs.
+func (sm *SyncManager) Backup(ctx context.Context) (*Snapshot, error) {
+	snapshotter, ok := sm.store.(kvstore.Snapshotter)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+
+	if !sm.IsOnline() {
+		return nil, ErrOffline
+	}
+	if !sm.beginExclusive() {
+		return nil, ErrSyncInProgress
+	}
+	defer sm.endExclusive()
+
+	now := sm.clock.Now().UTC()
+	snapshot := &Snapshot{
+		ID:           now.Format("20060102T150405Z"),
+		DeviceID:     sm.deviceID,
+		CreatedAt:    now,
+		PendingKeys:  make([]string, 0),
+		CacheFiles:   make([]CachedFile, 0),
+		LastSyncTime: sm.lastSyncTime,
+	}
+	prefix := sm.snapshotPrefix(sm.deviceID) + snapshot.ID + "/"
+
+	// Pending changes are already persisted in the store; record which keys
+	// still need uploading so a restore can queue them again
+	sm.changesMutex.Lock()
+	for key := range sm.pendingChanges {
+		snapshot.PendingKeys = append(snapshot.PendingKeys, key)
+	}
+	sm.changesMutex.Unlock()
+
+	var backup bytes.Buffer
+	if err := snapshotter.Backup(&backup); err != nil {
+		return nil, err
+	}
+	snapshot.StoreSize = int64(backup.Len())
+	snapshot.StoreSHA256 = sha256Hex(backup.Bytes())
+
+	if err := sm.transport.PutObject(ctx, prefix+"store.backup", backup.Bytes(), map[string]string{"device-id": sm.deviceID}); err != nil {
+		return nil, fmt.Errorf("failed to upload store backup: %w", err)
+	}
+
+	err := filepath.Walk(sm.localCachePath, func(path string, info os.FileInfo, err error) error {
+		if err != nil || !info.Mode().IsRegular() {
+			return err
+		}
+
+		rel, err := filepath.Rel(sm.localCachePath, path)
+		if err != nil {
+			return err
+		}
+
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		rel = filepath.ToSlash(rel)
+		if err := sm.transport.PutObject(ctx, prefix+"cache/"+rel, data, nil); err != nil {
+			return err
+		}
+
+		snapshot.CacheFiles = append(snapshot.CacheFiles, CachedFile{
+			Path:   rel,
+			Size:   info.Size(),
+			SHA256: sha256Hex(data),
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload local cache: %w", err)
+	}
+
+	// The manifest goes last, so a snapshot is only visible once complete
+	manifest, err := json.Marshal(snapshot)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal snapshot manifest: %w", err)
+	}
+
+	if err := sm.transport.PutObject(ctx, prefix+"snapshot.json", manifest, nil); err != nil {
+		return nil, fmt.Errorf("failed to upload snapshot manifest: %w", err)
+	}
+	if err := sm.transport.PutObject(ctx, sm.snapshotPrefix(sm.deviceID)+latestSnapshot, manifest, nil); err != nil {
+		return nil, fmt.Errorf("failed to update latest snapshot: %w", err)
+	}
+
+	sm.logger.Printf("Uploaded snapshot %s: %d bytes of store, %d pending changes, %d cached files",
+		snapshot.ID, snapshot.StoreSize, len(snapshot.PendingKeys), len(snapshot.CacheFiles))
+
+	return snapshot, nil
+}
+
+// Restore loads a snapshot onto this device, e.g. replacement hardware taking
+// over from a failed device. sourceDeviceID defaults to this device and
+// snapshotID to the latest snapshot. Changes that were queued when the
+// snapshot was taken are queued again, and every update in the manifest is
+// processed again on the next sync so handlers rebuild their local state.
+func (sm *SyncManager) Restore(ctx context.Context, sourceDeviceID, snapshotID string) (*Snapshot, error) {
+	snapshotter, ok := sm.store.(kvstore.Snapshotter)
+	if !ok {
+		return nil, ErrSnapshotsUnsupported
+	}
+
+	if sourceDeviceID == "" {
+		sourceDeviceID = sm.deviceID
+	}
+
+	if !sm.IsOnline() {
+		return nil, ErrOffline
+	}
+	if !sm.beginExclusive() {
+		return nil, ErrSyncInProgress
+	}
+	defer sm.endExclusive()
+
+	manifestKey := sm.snapshotPrefix(sourceDeviceID) + latestSnapshot
+	if snapshotID != "" {
+		manifestKey = sm.snapshotPrefix(sourceDeviceID) + snapshotID + "/snapshot.json"
+	}
+
+	manifest, err := sm.transport.GetObject(ctx, manifestKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download snapshot manifest: %w", err)
+	}
+
+	var snapshot Snapshot
+	if err := json.Unmarshal(manifest, &snapshot); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot manifest: %w", err)
+	}
+	prefix := sm.snapshotPrefix(sourceDeviceID) + snapshot.ID + "/"
+
+	backup, err := sm.transport.GetObject(ctx, prefix+"store.backup")
+	if err != nil {
+		return nil, fmt.Errorf("failed to download store backup: %w", err)
+	}
+	if sha256Hex(backup) != snapshot.StoreSHA256 {
+		return nil, fmt.Errorf("store backup of snapshot %s is corrupt", snapshot.ID)
+	}
+
+	// Fetch and verify the whole cache before changing anything locally
+	cache := make(map[string][]byte, len(snapshot.CacheFiles))
+	for _, file := range snapshot.CacheFiles {
+		data, err := sm.transport.GetObject(ctx, prefix+"cache/"+file.Path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download cached file %s: %w", file.Path, err)
+		}
+		if sha256Hex(data) != file.SHA256 {
+			return nil, fmt.Errorf("cached file %s of snapshot %s is corrupt", file.Path, snapshot.ID)
+		}
+		cache[file.Path] = data
+	}
+
+	if err := snapshotter.Restore(bytes.NewReader(backup)); err != nil {
+		return nil, err
+	}
+
+	for path, data := range cache {
+		filePath := filepath.Join(sm.localCachePath, filepath.FromSlash(path))
+		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
+			return nil, fmt.Errorf("failed to create directory for %s: %w", path, err)
+		}
+		if err := ioutil.WriteFile(filePath, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to restore cached file %s: %w", path, err)
+		}
+	}
+
+	sm.changesMutex.Lock()
+	for _, key := range snapshot.PendingKeys {
+		data, err := sm.store.Get([]byte(key))
+		if err != nil {
+			sm.logger.Printf("Pending change %s missing from snapshot %s: %v", key, snapshot.ID, err)
+			continue
+		}
+		sm.pendingChanges[key] = data
+	}
+
+	// Conflicts queued on the snapshot's device are queued here too
+	sm.conflicts = make(map[string]*Conflict)
+	if err := sm.loadConflicts(); err != nil {
+		sm.logger.Printf("Failed to restore conflicts from snapshot %s: %v", snapshot.ID, err)
+	}
+	sm.changesMutex.Unlock()
+
+	sm.lastSyncTime = time.Time{}
+
+	sm.logger.Printf("Restored snapshot %s of %s: %d pending changes, %d cached files",
+		snapshot.ID, sourceDeviceID, len(snapshot.PendingKeys), len(snapshot.CacheFiles))
+
+	return &snapshot, nil
+}
+
+// beginExclusive marks a sync in progress so scheduled syncs skip while the
+// store is being backed up or restored; it returns false if a sync is running
+func (sm *SyncManager) beginExclusive() bool {
+	sm.syncMux.Lock()
+	defer sm.syncMux.Unlock()
+
+	if sm.syncInProgress {
+		return false
+	}
+	sm.syncInProgress = true
+	return true
+}
+
+// endExclusive releases beginExclusive
+func (sm *SyncManager) endExclusive() {
+	sm.syncMux.Lock()
+	sm.syncInProgress = false
+	sm.syncMux.Unlock()
+}
+
+// snapshotPrefix returns the object prefix of a device's snapshots
+func (sm *SyncManager) snapshotPrefix(deviceID string) string {
+	return fmt.Sprintf("%sdevices/%s/snapshots/", tenant.S3Prefix(sm.tenantID), deviceID)
+}
+
+// Helper functions
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,34 @@
+package offlineSync
+
+import (
+	"errors"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+)
+
+// Errors returned, wrapped, by the SyncManager; match them with errors.Is
+var (
+	// ErrOffline means the operation needs connectivity and the device is offline
+	ErrOffline = errors.New("device is offline")
+
+	// ErrKeyNotFound means no pending change, stored value or cached file
+	// exists for a key; it is kvstore.ErrKeyNotFound, so either matches
+	ErrKeyNotFound = kvstore.ErrKeyNotFound
+
+	// ErrSyncInProgress means another sync, backup or restore is running
+	ErrSyncInProgress = errors.New("sync in progress")
+
+	// ErrSnapshotsUnsupported means the storage backend can't back up and restore
+	ErrSnapshotsUnsupported = errors.New("storage backend does not support snapshots")
+
+	// ErrBandwidthCap means the sync deferred updates because the device used
+	// up its bandwidth cap; it is bandwidth.ErrCapExceeded, so either matches
+	ErrBandwidthCap = bandwidth.ErrCapExceeded
+
+	// ErrNoConflict means ResolveConflict was called for a key with no queued conflict
+	ErrNoConflict = errors.New("no conflict queued")
+
+	// ErrPermissionDenied means a ScopedSync's NamespaceACL doesn't allow the access
+	ErrPermissionDenied = errors.New("permission denied")
+)
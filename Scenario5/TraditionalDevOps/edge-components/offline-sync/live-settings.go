@@ -0,0 +1,64 @@
+package offlineSync
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// SetSyncInterval reschedules the periodic sync while running; a sync in
+// progress and the pending changes are unaffected
+func (sm *SyncManager) SetSyncInterval(interval time.Duration) error {
+	if interval <= 0 {
+		return fmt.Errorf("sync interval must be positive")
+	}
+
+	sm.settingsMutex.Lock()
+	defer sm.settingsMutex.Unlock()
+
+	if interval == sm.syncInterval {
+		return nil
+	}
+
+	entry, err := sm.syncCron.AddFunc(fmt.Sprintf("@every %s", interval.String()), sm.scheduledSync)
+	if err != nil {
+		return fmt.Errorf("failed to schedule sync: %w", err)
+	}
+	sm.syncCron.Remove(sm.syncEntry)
+	sm.syncEntry = entry
+	sm.syncInterval = interval
+
+	return nil
+}
+
+// SetUploadFilter limits uploads to the given data types, e.g. to hold back
+// bulky telemetry on a metered link; changes of other types stay queued until
+// the filter allows them. Nil or empty allows every type.
+func (sm *SyncManager) SetUploadFilter(dataTypes []string) {
+	var allowed map[string]bool
+	if len(dataTypes) > 0 {
+		allowed = make(map[string]bool, len(dataTypes))
+		for _, dataType := range dataTypes {
+			allowed[dataType] = true
+		}
+	}
+
+	sm.settingsMutex.Lock()
+	sm.uploadTypes = allowed
+	sm.settingsMutex.Unlock()
+}
+
+// uploadAllowed reports whether the upload filter passes a data type
+func (sm *SyncManager) uploadAllowed(dataType string) bool {
+	sm.settingsMutex.RLock()
+	defer sm.settingsMutex.RUnlock()
+	return sm.uploadTypes == nil || sm.uploadTypes[dataType]
+}
+
+// scheduledSync is the periodic sync job
+func (sm *SyncManager) scheduledSync() {
+	// Deferrals past the bandwidth cap were logged by the meter
+	if err := sm.Sync(); err != nil && !errors.Is(err, ErrOffline) && !errors.Is(err, ErrBandwidthCap) {
+		sm.logger.Printf("Scheduled sync failed: %v", err)
+	}
+}
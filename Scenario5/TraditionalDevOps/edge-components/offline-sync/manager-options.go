@@ -0,0 +1,348 @@
+package offlineSync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/robfig/cron/v3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/backoff"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/transfer"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/validation"
+)
+
+// Logger receives the manager's log output; *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Clock tells the manager the time, so tests can control it
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManagerOption configures a SyncManager built by NewManager
+type ManagerOption func(*managerOptions) error
+
+// managerOptions collects the options before the manager is built
+type managerOptions struct {
+	config     SyncConfig
+	logger     Logger
+	clock      Clock
+	httpClient *http.Client
+	backoff    backoff.Policy
+	bandwidth  *bandwidth.Limiter
+	meter      *bandwidth.Meter
+	critical   map[string]bool
+	transfer   *transfer.Options
+}
+
+// WithSyncConfig sets the device identity, storage and transport configuration
+func WithSyncConfig(config SyncConfig) ManagerOption {
+	return func(o *managerOptions) error {
+		o.config = config
+		return nil
+	}
+}
+
+// WithLogger sends the manager's log output to logger instead of the standard logger
+func WithLogger(logger Logger) ManagerOption {
+	return func(o *managerOptions) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithClock replaces the system clock
+func WithClock(clock Clock) ManagerOption {
+	return func(o *managerOptions) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+		o.clock = clock
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used with SyncConfig.ProxyURL, e.g. one
+// presenting the device's TLS certificate
+func WithHTTPClient(client *http.Client) ManagerOption {
+	return func(o *managerOptions) error {
+		if client == nil {
+			return errors.New("HTTP client must not be nil")
+		}
+		o.httpClient = client
+		return nil
+	}
+}
+
+// WithBackoff sets how transport calls are retried; backoff.None() disables retries
+func WithBackoff(policy backoff.Policy) ManagerOption {
+	return func(o *managerOptions) error {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		o.backoff = policy
+		return nil
+	}
+}
+
+// WithBandwidth limits sync transfers; share the limiter with the rollout
+// manager to cap the agent's combined traffic
+func WithBandwidth(limiter *bandwidth.Limiter) ManagerOption {
+	return func(o *managerOptions) error {
+		o.bandwidth = limiter
+		return nil
+	}
+}
+
+// WithMeter counts sync transfers against the device's billing period. Once
+// its cap is used up, only the critical data types sync; changes of other
+// types stay queued and their updates wait for the next period. Share the
+// meter with the rollout manager.
+func WithMeter(meter *bandwidth.Meter, criticalTypes ...string) ManagerOption {
+	return func(o *managerOptions) error {
+		o.meter = meter
+		o.critical = make(map[string]bool, len(criticalTypes))
+		for _, dataType := range criticalTypes {
+			o.critical[dataType] = true
+		}
+		return nil
+	}
+}
+
+// WithTransfer moves sync objects with the S3 transfer manager, as
+// concurrent ranged downloads and multipart uploads. It applies to the
+// default S3 transport, not to a Transport or ProxyURL.
+func WithTransfer(options transfer.Options) ManagerOption {
+	return func(o *managerOptions) error {
+		if err := options.Validate(); err != nil {
+			return err
+		}
+		o.transfer = &options
+		return nil
+	}
+}
+
+// NewManager creates a SyncManager from options. Unset options default to
+// the standard logger, the system clock, an HTTP client with a 1 minute
+// timeout and backoff.Default(); the sync interval defaults to 15 minutes.
+// An invalid configuration returns a *validation.Error listing every problem.
+func NewManager(opts ...ManagerOption) (*SyncManager, error) {
+	o := &managerOptions{
+		logger:     log.Default(),
+		clock:      systemClock{},
+		httpClient: &http.Client{Timeout: time.Minute},
+		backoff:    backoff.Default(),
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("invalid sync manager option: %w", err)
+		}
+	}
+
+	config := o.config
+	if config.SyncInterval == 0 {
+		config.SyncInterval = 15 * time.Minute
+	}
+	if err := validateSyncConfig(config); err != nil {
+		return nil, err
+	}
+
+	// Open the local store
+	storePath := config.BadgerDBPath
+	if config.StorageBackend == kvstore.BackendBolt {
+		storePath = config.BoltDBPath
+	}
+	store, err := kvstore.Open(config.StorageBackend, storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := config.Transport
+	if transport == nil && config.ProxyURL != "" {
+		transport = NewHTTPTransport(o.httpClient, config.ProxyURL)
+	}
+	if transport == nil && o.transfer != nil {
+		transport = NewS3TransferTransport(config.S3Client, config.SyncBucket, *o.transfer)
+	}
+	if transport == nil {
+		transport = NewS3Transport(config.S3Client, config.SyncBucket)
+	}
+
+	transfers := &transferStats{}
+	sm := &SyncManager{
+		store:              store,
+		transport:          &retryingTransport{transport: transport, policy: o.backoff, limiter: o.bandwidth, meter: o.meter, stats: transfers},
+		syncBucket:         config.SyncBucket,
+		deviceID:           config.DeviceID,
+		tenantID:           config.TenantID,
+		localCachePath:     config.LocalCachePath,
+		syncInterval:       config.SyncInterval,
+		pendingChanges:     make(map[string][]byte),
+		isOnline:           false,
+		syncHandlers:       make(map[string]SyncHandler),
+		syncCron:           cron.New(),
+		logger:             o.logger,
+		clock:              o.clock,
+		transfers:          transfers,
+		meter:              o.meter,
+		criticalTypes:      o.critical,
+		conflictStrategies: config.ConflictStrategies,
+		localEdits:         make(map[string]localEdit),
+		conflicts:          make(map[string]*Conflict),
+	}
+
+	if err := sm.loadConflicts(); err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to load sync conflicts: %w", err)
+	}
+
+	// Schedule periodic sync
+	sm.syncEntry, err = sm.syncCron.AddFunc(fmt.Sprintf("@every %s", config.SyncInterval.String()), sm.scheduledSync)
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to schedule sync: %w", err)
+	}
+
+	sm.syncCron.Start()
+	return sm, nil
+}
+
+// Limits on the sync interval
+const (
+	minSyncInterval = 10 * time.Second
+	maxSyncInterval = 7 * 24 * time.Hour
+)
+
+// validateSyncConfig checks every setting, returning a *validation.Error
+// listing all invalid fields
+func validateSyncConfig(config SyncConfig) error {
+	c := validation.NewChecker("SyncConfig")
+
+	switch {
+	case config.DeviceID == "":
+		c.Add("DeviceID", "is required", "set it to the device's unique ID")
+	case strings.ContainsAny(config.DeviceID, " \t\n/"+tenant.Separator):
+		c.Add("DeviceID", fmt.Sprintf("%q contains whitespace, '/' or %q", config.DeviceID, tenant.Separator), "use letters, digits, '-' or '_'")
+	}
+	if config.TenantID != "" {
+		c.Check(tenant.Validate(config.TenantID) == nil, "TenantID", fmt.Sprintf("%q is not a valid tenant ID", config.TenantID), "use up to 63 lowercase letters, digits or '-'")
+	}
+
+	c.WritableDir("LocalCachePath", config.LocalCachePath)
+	c.Check(config.SyncInterval >= minSyncInterval && config.SyncInterval <= maxSyncInterval,
+		"SyncInterval", fmt.Sprintf("%s is out of range", config.SyncInterval), fmt.Sprintf("use %s to %s, or 0 for the default", minSyncInterval, maxSyncInterval))
+
+	switch config.StorageBackend {
+	case "", kvstore.BackendBadger:
+		c.WritableDir("BadgerDBPath", config.BadgerDBPath)
+	case kvstore.BackendBolt:
+		if config.BoltDBPath == "" {
+			c.Add("BoltDBPath", "is required for the bolt backend", "set it to the bbolt file path")
+		} else {
+			c.WritableDir("BoltDBPath", filepath.Dir(config.BoltDBPath))
+		}
+	case kvstore.BackendMemory:
+	default:
+		c.Add("StorageBackend", fmt.Sprintf("%q is unknown", config.StorageBackend), "use badger, bolt or memory")
+	}
+
+	switch {
+	case config.Transport != nil:
+	case config.ProxyURL != "":
+		proxy, err := url.Parse(config.ProxyURL)
+		c.Check(err == nil && (proxy.Scheme == "http" || proxy.Scheme == "https") && proxy.Host != "",
+			"ProxyURL", fmt.Sprintf("%q is not an http(s) URL", config.ProxyURL), "use the gateway's address, e.g. https://gateway.local:8443")
+	default:
+		c.Check(config.S3Client != nil, "S3Client", "is required without a Transport or ProxyURL", "create one with s3.NewFromConfig or agentconfig.Config.Clients")
+		c.BucketName("SyncBucket", config.SyncBucket, true)
+	}
+
+	for dataType, strategy := range config.ConflictStrategies {
+		c.Check(validStrategy(strategy), "ConflictStrategies", fmt.Sprintf("%q for %s is unknown", strategy, dataType),
+			"use server-wins, client-wins, last-writer-wins, merge or manual")
+	}
+
+	return c.Err()
+}
+
+// retryingTransport retries a SyncTransport's calls with backoff, keeps
+// them within the bandwidth limit and meters them; missing S3 objects are not retried, since
+// a missing manifest is the usual case
+type retryingTransport struct {
+	transport SyncTransport
+	policy    backoff.Policy
+	limiter   *bandwidth.Limiter
+	meter     *bandwidth.Meter
+	stats     *transferStats
+}
+
+// PutObject uploads an object, retrying transient failures
+func (t *retryingTransport) PutObject(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	if t.limiter != nil {
+		if err := t.limiter.WaitN(ctx, len(data)); err != nil {
+			return err
+		}
+	}
+	err := backoff.Retry(ctx, t.policy, func() error {
+		return t.transport.PutObject(ctx, key, data, metadata)
+	})
+	if err == nil {
+		t.stats.uploaded.Add(int64(len(data)))
+		if t.meter != nil {
+			t.meter.Add(bandwidth.CategorySync, int64(len(data)), 0)
+		}
+	}
+	return err
+}
+
+// GetObject downloads an object, retrying transient failures
+func (t *retryingTransport) GetObject(ctx context.Context, key string) ([]byte, error) {
+	var data []byte
+	var missing *s3types.NoSuchKey
+	err := backoff.Retry(ctx, t.policy, func() error {
+		var err error
+		data, err = t.transport.GetObject(ctx, key)
+		if errors.As(err, &missing) {
+			return nil
+		}
+		return err
+	})
+	if missing != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, missing)
+	}
+	if err != nil {
+		return nil, err
+	}
+	t.stats.downloaded.Add(int64(len(data)))
+	if t.meter != nil {
+		t.meter.Add(bandwidth.CategorySync, 0, int64(len(data)))
+	}
+	if t.limiter != nil {
+		// The size is only known afterwards, so the wait follows the transfer
+		if err := t.limiter.WaitN(ctx, len(data)); err != nil {
+			return nil, err
+		}
+	}
+	return data, nil
+}
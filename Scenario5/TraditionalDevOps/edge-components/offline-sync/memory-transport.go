@@ -0,0 +1,74 @@
+package offlineSync
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+)
+
+// MemoryTransport is a SyncTransport held in memory, standing in for the sync
+// bucket in demos, unit tests and laptop development. Several managers can
+// share one to exchange objects, and the fleet side can publish updates to it
+// with DeliverUpdate.
+type MemoryTransport struct {
+	objects  map[string][]byte
+	metadata map[string]map[string]string
+	mutex    sync.RWMutex
+}
+
+// NewMemoryTransport creates an empty MemoryTransport
+func NewMemoryTransport() *MemoryTransport {
+	return &MemoryTransport{
+		objects:  make(map[string][]byte),
+		metadata: make(map[string]map[string]string),
+	}
+}
+
+// PutObject stores a copy of an object
+func (t *MemoryTransport) PutObject(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.objects[key] = append([]byte{}, data...)
+	t.metadata[key] = metadata
+	return nil
+}
+
+// GetObject returns a copy of an object; a missing object is an
+// s3types.NoSuchKey, as from S3
+func (t *MemoryTransport) GetObject(ctx context.Context, key string) ([]byte, error) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	data, ok := t.objects[key]
+	if !ok {
+		return nil, fmt.Errorf("failed to download %s: %w", key, &s3types.NoSuchKey{})
+	}
+	return append([]byte{}, data...), nil
+}
+
+// Keys lists the stored keys with a prefix, in order
+func (t *MemoryTransport) Keys(prefix string) []string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	var keys []string
+	for key := range t.objects {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Metadata returns the metadata an object was stored with
+func (t *MemoryTransport) Metadata(key string) map[string]string {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.metadata[key]
+}
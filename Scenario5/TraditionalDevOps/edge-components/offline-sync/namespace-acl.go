@@ -0,0 +1,117 @@
+package offlineSync
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Permission is what a ScopedSync may do with the keys of a namespace
+type Permission string
+
+// Namespace permissions
+const (
+	PermissionReadOnly  Permission = "read-only"
+	PermissionWriteOnly Permission = "write-only" // e.g. an outbox the application fills but shouldn't read back
+	PermissionReadWrite Permission = "read-write"
+)
+
+// NamespaceACL grants permissions by key namespace. A namespace is a key
+// prefix ending at a '/', such as "app" or "platform/flags"; the longest
+// namespace that matches a key decides, and keys in no namespace are denied.
+type NamespaceACL map[string]Permission
+
+// ScopedSync is a view of a SyncManager limited by a NamespaceACL. Hand it,
+// instead of the manager, to application code embedding the library, so it
+// can't write into namespaces owned by other components or the platform.
+type ScopedSync struct {
+	sm  *SyncManager
+	acl NamespaceACL
+}
+
+// Scope returns a view of the manager that enforces acl on every key
+func (sm *SyncManager) Scope(acl NamespaceACL) (*ScopedSync, error) {
+	scoped := make(NamespaceACL, len(acl))
+	for namespace, permission := range acl {
+		switch permission {
+		case PermissionReadOnly, PermissionWriteOnly, PermissionReadWrite:
+		default:
+			return nil, fmt.Errorf("unknown permission %q for namespace %q", permission, namespace)
+		}
+		namespace = strings.Trim(namespace, "/")
+		if namespace == "" || strings.Contains(namespace, "..") {
+			return nil, fmt.Errorf("invalid namespace %q", namespace)
+		}
+		scoped[namespace] = permission
+	}
+
+	return &ScopedSync{sm: sm, acl: scoped}, nil
+}
+
+// AddPendingChange queues a change in a namespace the scope may write
+func (s *ScopedSync) AddPendingChange(key string, data []byte) error {
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return s.sm.AddPendingChange(key, data)
+}
+
+// GetLocalData reads a key in a namespace the scope may read
+func (s *ScopedSync) GetLocalData(key string) ([]byte, error) {
+	if err := s.check(key, false); err != nil {
+		return nil, err
+	}
+	return s.sm.GetLocalData(key)
+}
+
+// Conflicts returns the waiting conflicts in namespaces the scope may read
+func (s *ScopedSync) Conflicts() []Conflict {
+	var conflicts []Conflict
+	for _, conflict := range s.sm.Conflicts() {
+		if s.check(conflict.Key, false) == nil {
+			conflicts = append(conflicts, conflict)
+		}
+	}
+	return conflicts
+}
+
+// ResolveConflict resolves a conflict in a namespace the scope may write
+func (s *ScopedSync) ResolveConflict(key string, data []byte) error {
+	if err := s.check(key, true); err != nil {
+		return err
+	}
+	return s.sm.ResolveConflict(key, data)
+}
+
+// GetSyncStatus returns the manager's sync status
+func (s *ScopedSync) GetSyncStatus() SyncStatus {
+	return s.sm.GetSyncStatus()
+}
+
+// check returns ErrPermissionDenied unless the ACL allows the access to key
+func (s *ScopedSync) check(key string, write bool) error {
+	if strings.Contains(key, "..") {
+		return fmt.Errorf("%w: %s escapes its namespace", ErrPermissionDenied, key)
+	}
+
+	namespace, permission := s.permission(key)
+	switch {
+	case namespace == "":
+		return fmt.Errorf("%w: %s is in no permitted namespace", ErrPermissionDenied, key)
+	case write && permission == PermissionReadOnly:
+		return fmt.Errorf("%w: namespace %s is read-only", ErrPermissionDenied, namespace)
+	case !write && permission == PermissionWriteOnly:
+		return fmt.Errorf("%w: namespace %s is write-only", ErrPermissionDenied, namespace)
+	}
+	return nil
+}
+
+// permission returns the longest namespace of the ACL that contains key, and its permission
+func (s *ScopedSync) permission(key string) (string, Permission) {
+	best := ""
+	for namespace := range s.acl {
+		if (key == namespace || strings.HasPrefix(key, namespace+"/")) && len(namespace) > len(best) {
+			best = namespace
+		}
+	}
+	return best, s.acl[best]
+}
@@ -3,26 +3,29 @@ package offlineSync
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
-	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/dgraph-io/badger/v3"
 	"github.com/robfig/cron/v3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
 )
 
 // SyncManager handles offline operations and synchronized updates for edge devices
 type SyncManager struct {
-	db              *badger.DB
-	s3Client        *s3.Client
+	store           kvstore.KVStore
+	transport       SyncTransport
 	syncBucket      string
 	deviceID        string
+	tenantID        string
 	localCachePath  string
 	syncInterval    time.Duration
 	syncCron        *cron.Cron
@@ -34,6 +37,22 @@ type SyncManager struct {
 	syncInProgress  bool
 	syncMux         sync.Mutex
 	syncHandlers    map[string]SyncHandler
+	syncReporters   []SyncReporter
+	logger          Logger
+	clock           Clock
+	syncEntry       cron.EntryID
+	uploadTypes     map[string]bool // data types allowed to upload; nil allows all
+	settingsMutex   sync.RWMutex
+	lastError       string
+	lastErrorTime   time.Time
+	statusMutex     sync.Mutex
+	transfers       *transferStats
+	meter           *bandwidth.Meter // set by WithMeter
+	criticalTypes   map[string]bool  // data types that sync past the bandwidth cap
+	fetched         map[string]time.Time // updates downloaded while others were deferred
+	conflictStrategies map[string]ConflictStrategy // by data type
+	localEdits      map[string]localEdit  // local changes since the last sync, by key
+	conflicts       map[string]*Conflict  // waiting for ResolveConflict, by key
 }
 
 // SyncHandler is an interface for handling different types of synchronized data
@@ -43,56 +62,34 @@ type SyncHandler interface {
 	MergeConflicts(localData, remoteData []byte) ([]byte, error)
 }
 
+// SyncReporter is an interface for reporting sync outcomes to the fleet
+type SyncReporter interface {
+	ReportSync(succeeded bool, pendingChanges int, message string) error
+}
+
 // SyncConfig contains configuration for the SyncManager
 type SyncConfig struct {
 	DeviceID        string
+	TenantID        string // prefixes object keys with tenants/<id>/ when set
 	LocalCachePath  string
 	SyncBucket      string
 	SyncInterval    time.Duration
 	BadgerDBPath    string
-	S3Client        *s3.Client
+	StorageBackend  string // badger (default) or bolt, for devices with little memory
+	BoltDBPath      string // bbolt file used by the bolt backend
+	S3Client        S3API         // *s3.Client, or a fake in tests
+	Transport       SyncTransport // replaces direct S3 access, e.g. a LAN broker client; defaults to S3Client
+	ProxyURL        string        // syncs through a gateway proxy over HTTP when set and Transport is not
+	
+	// ConflictStrategies decides, by data type, what happens to an update
+	// for a key that also changed locally; unlisted types use ConflictServerWins
+	ConflictStrategies map[string]ConflictStrategy
 }
 
-// NewSyncManager creates a new SyncManager
+// NewSyncManager creates a new SyncManager; it is NewManager with only
+// WithSyncConfig, kept for existing callers
 func NewSyncManager(config SyncConfig) (*SyncManager, error) {
-	// Create local cache directory if it doesn't exist
-	if err := os.MkdirAll(config.LocalCachePath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create local cache directory: %w", err)
-	}
-
-	// Open BadgerDB for local storage
-	opts := badger.DefaultOptions(config.BadgerDBPath)
-	opts.Logger = nil // Disable logging
-	db, err := badger.Open(opts)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open BadgerDB: %w", err)
-	}
-
-	sm := &SyncManager{
-		db:              db,
-		s3Client:        config.S3Client,
-		syncBucket:      config.SyncBucket,
-		deviceID:        config.DeviceID,
-		localCachePath:  config.LocalCachePath,
-		syncInterval:    config.SyncInterval,
-		pendingChanges:  make(map[string][]byte),
-		isOnline:        false,
-		syncHandlers:    make(map[string]SyncHandler),
-		syncCron:        cron.New(),
-	}
-
-	// Schedule periodic sync
-	_, err = sm.syncCron.AddFunc(fmt.Sprintf("@every %s", config.SyncInterval.String()), func() {
-		if err := sm.Sync(); err != nil {
-			log.Printf("Scheduled sync failed: %v", err)
-		}
-	})
-	if err != nil {
-		return nil, fmt.Errorf("failed to schedule sync: %w", err)
-	}
-
-	sm.syncCron.Start()
-	return sm, nil
+	return NewManager(WithSyncConfig(config))
 }
 
 // RegisterSyncHandler registers a handler for a specific data type
@@ -100,6 +97,11 @@ func (sm *SyncManager) RegisterSyncHandler(dataType string, handler SyncHandler)
 	sm.syncHandlers[dataType] = handler
 }
 
+// RegisterSyncReporter registers a reporter for sync outcomes
+func (sm *SyncManager) RegisterSyncReporter(reporter SyncReporter) {
+	sm.syncReporters = append(sm.syncReporters, reporter)
+}
+
 // SetOnlineStatus updates the online status of the device
 func (sm *SyncManager) SetOnlineStatus(online bool) {
 	sm.onlineStatusMux.Lock()
@@ -111,8 +113,8 @@ func (sm *SyncManager) SetOnlineStatus(online bool) {
 	// If we just came online, trigger a sync
 	if !wasOnline && online {
 		go func() {
-			if err := sm.Sync(); err != nil {
-				log.Printf("Auto-sync on reconnection failed: %v", err)
+			if err := sm.Sync(); err != nil && !errors.Is(err, ErrOffline) {
+				sm.logger.Printf("Auto-sync on reconnection failed: %v", err)
 			}
 		}()
 	}
@@ -132,20 +134,18 @@ func (sm *SyncManager) AddPendingChange(key string, data []byte) error {
 	
 	// Store in memory
 	sm.pendingChanges[key] = data
+	sm.recordLocalEdit(key, data)
 	
-	// Store in BadgerDB for persistence
-	err := sm.db.Update(func(txn *badger.Txn) error {
-		return txn.Set([]byte(key), data)
-	})
-	if err != nil {
+	// Store in the local store for persistence
+	if err := sm.store.Set([]byte(key), data); err != nil {
 		return fmt.Errorf("failed to store pending change: %w", err)
 	}
 	
 	// If we're online, try to sync immediately
 	if sm.IsOnline() {
 		go func() {
-			if err := sm.Sync(); err != nil {
-				log.Printf("Auto-sync after change failed: %v", err)
+			if err := sm.Sync(); err != nil && !errors.Is(err, ErrOffline) {
+				sm.logger.Printf("Auto-sync after change failed: %v", err)
 			}
 		}()
 	}
@@ -163,34 +163,24 @@ func (sm *SyncManager) GetLocalData(key string) ([]byte, error) {
 	}
 	sm.changesMutex.Unlock()
 	
-	// Then check BadgerDB
-	var result []byte
-	err := sm.db.View(func(txn *badger.Txn) error {
-		item, err := txn.Get([]byte(key))
-		if err != nil {
-			return err
-		}
-		
-		return item.Value(func(val []byte) error {
-			result = append([]byte{}, val...)
-			return nil
-		})
-	})
+	// Then check the local store
+	result, err := sm.store.Get([]byte(key))
 	
-	if err == badger.ErrKeyNotFound {
+	if err == kvstore.ErrKeyNotFound {
 		// Finally check file system cache
 		filePath := filepath.Join(sm.localCachePath, key)
 		if _, err := os.Stat(filePath); err == nil {
 			return ioutil.ReadFile(filePath)
 		}
-		return nil, fmt.Errorf("data not found for key: %s", key)
+		return nil, fmt.Errorf("%w: %s", ErrKeyNotFound, key)
 	}
 	
 	return result, err
 }
 
-// Sync synchronizes data with the cloud
-func (sm *SyncManager) Sync() error {
+// Sync synchronizes data with the cloud; it returns ErrOffline while the
+// device is offline
+func (sm *SyncManager) Sync() (err error) {
 	// Prevent multiple syncs from running concurrently
 	sm.syncMux.Lock()
 	if sm.syncInProgress {
@@ -206,11 +196,19 @@ func (sm *SyncManager) Sync() error {
 		sm.syncMux.Unlock()
 	}()
 	
-	// Skip if offline
+	// Nothing to do while offline; changes stay queued
 	if !sm.IsOnline() {
-		return nil
+		return ErrOffline
 	}
 	
+	started := sm.clock.Now()
+	
+	// Report the outcome once the sync finishes
+	defer func() {
+		sm.recordSyncResult(err)
+		sm.reportSync(err)
+	}()
+	
 	// 1. Upload pending changes
 	if err := sm.uploadPendingChanges(); err != nil {
 		return fmt.Errorf("failed to upload pending changes: %w", err)
@@ -221,8 +219,11 @@ func (sm *SyncManager) Sync() error {
 		return fmt.Errorf("failed to download updates: %w", err)
 	}
 	
+	// Local changes made before the sync have now met every update
+	sm.clearLocalEdits(started)
+	
 	// Update last sync time
-	sm.lastSyncTime = time.Now()
+	sm.lastSyncTime = sm.clock.Now()
 	
 	return nil
 }
@@ -235,39 +236,51 @@ func (sm *SyncManager) uploadPendingChanges() error {
 	// Add changes from memory
 	sm.changesMutex.Lock()
 	for k, v := range sm.pendingChanges {
-		allChanges[k] = v
+		if dataType := strings.SplitN(k, "/", 2)[0]; sm.uploadAllowed(dataType) && sm.withinCap(dataType) {
+			allChanges[k] = v
+		}
 	}
 	sm.changesMutex.Unlock()
 	
 	// Add changes from handlers
 	for dataType, handler := range sm.syncHandlers {
+		if !sm.uploadAllowed(dataType) || !sm.withinCap(dataType) {
+			continue
+		}
 		changes, err := handler.GetLocalChanges()
 		if err != nil {
-			log.Printf("Failed to get local changes from handler %s: %v", dataType, err)
+			sm.logger.Printf("Failed to get local changes from handler %s: %v", dataType, err)
 			continue
 		}
 		
+		sm.changesMutex.Lock()
 		for k, v := range changes {
-			allChanges[fmt.Sprintf("%s/%s", dataType, k)] = v
+			key := fmt.Sprintf("%s/%s", dataType, k)
+			allChanges[key] = v
+			sm.recordLocalEdit(key, v)
 		}
+		sm.changesMutex.Unlock()
 	}
 	
 	// Upload each change to S3
 	for key, data := range allChanges {
-		s3Key := fmt.Sprintf("devices/%s/data/%s", sm.deviceID, key)
-		
-		_, err := sm.s3Client.PutObject(context.Background(), &s3.PutObjectInput{
-			Bucket: aws.String(sm.syncBucket),
-			Key:    aws.String(s3Key),
-			Body:   aws.ReadSeekCloser(bytes.NewReader(data)),
-			Metadata: map[string]string{
-				"device-id":   sm.deviceID,
-				"upload-time": time.Now().UTC().Format(time.RFC3339),
-			},
-		})
+		s3Key := fmt.Sprintf("%sdevices/%s/data/%s", tenant.S3Prefix(sm.tenantID), sm.deviceID, key)
+		hash := sha256Hex(data)
 		
-		if err != nil {
-			return fmt.Errorf("failed to upload %s: %w", key, err)
+		// Skip changes whose content is already stored remotely
+		if sm.alreadyUploaded(s3Key, hash) {
+			sm.transfers.skipped.Add(1)
+		} else {
+			err := sm.transport.PutObject(context.Background(), s3Key, data, map[string]string{
+				"device-id":         sm.deviceID,
+				"upload-time":       sm.clock.Now().UTC().Format(time.RFC3339),
+				ContentHashMetadata: hash,
+			})
+			
+			if err != nil {
+				return err
+			}
+			sm.recordUpload(s3Key, hash)
 		}
 		
 		// Remove from pending changes after successful upload
@@ -282,24 +295,16 @@ func (sm *SyncManager) uploadPendingChanges() error {
 // downloadUpdates downloads updates from S3
 func (sm *SyncManager) downloadUpdates() error {
 	// Get the manifest file that lists all available updates
-	manifestKey := fmt.Sprintf("devices/%s/manifest.json", sm.deviceID)
-	
-	result, err := sm.s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: aws.String(sm.syncBucket),
-		Key:    aws.String(manifestKey),
-	})
+	manifestKey := fmt.Sprintf("%sdevices/%s/manifest.json", tenant.S3Prefix(sm.tenantID), sm.deviceID)
 	
+	manifestData, err := sm.transport.GetObject(context.Background(), manifestKey)
 	if err != nil {
 		// If manifest doesn't exist, that's okay
-		log.Printf("No manifest found: %v", err)
+		sm.logger.Printf("No manifest found: %v", err)
 		return nil
 	}
 	
-	// Read and parse the manifest
-	manifestData, err := ioutil.ReadAll(result.Body)
-	if err != nil {
-		return fmt.Errorf("failed to read manifest: %w", err)
-	}
+	// Parse the manifest
 	
 	var manifest struct {
 		Updates []struct {
@@ -314,58 +319,82 @@ func (sm *SyncManager) downloadUpdates() error {
 	}
 	
 	// Process each update
+	deferred := 0
 	for _, update := range manifest.Updates {
 		// Skip if we've already processed this update
-		if !update.Timestamp.After(sm.lastSyncTime) {
+		if !update.Timestamp.After(sm.lastSyncTime) || sm.alreadyFetched(update.Key, update.Timestamp) {
 			continue
 		}
 		
-		// Download the update
-		s3Key := fmt.Sprintf("devices/%s/updates/%s", sm.deviceID, update.Key)
-		updateResult, err := sm.s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-			Bucket: aws.String(sm.syncBucket),
-			Key:    aws.String(s3Key),
-		})
+		// Past the bandwidth cap only critical data types come down
+		if !sm.withinCap(update.DataType) {
+			deferred++
+			continue
+		}
 		
+		// Download the update
+		s3Key := fmt.Sprintf("%sdevices/%s/updates/%s", tenant.S3Prefix(sm.tenantID), sm.deviceID, update.Key)
+		updateData, err := sm.transport.GetObject(context.Background(), s3Key)
 		if err != nil {
-			log.Printf("Failed to download update %s: %v", update.Key, err)
+			sm.logger.Printf("Failed to download update %s: %v", update.Key, err)
 			continue
 		}
 		
-		// Read the update data
-		updateData, err := ioutil.ReadAll(updateResult.Body)
-		if err != nil {
-			log.Printf("Failed to read update %s: %v", update.Key, err)
+		// A key that also changed locally is resolved by its data type's strategy
+		updateData, apply := sm.resolveIncoming(update.DataType, update.Key, updateData, update.Timestamp)
+		if !apply {
+			sm.markFetched(update.Key, update.Timestamp)
 			continue
 		}
 		
 		// Save to local cache
 		filePath := filepath.Join(sm.localCachePath, update.Key)
 		if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-			log.Printf("Failed to create directory for %s: %v", update.Key, err)
+			sm.logger.Printf("Failed to create directory for %s: %v", update.Key, err)
 			continue
 		}
 		
 		if err := ioutil.WriteFile(filePath, updateData, 0644); err != nil {
-			log.Printf("Failed to write update %s to cache: %v", update.Key, err)
+			sm.logger.Printf("Failed to write update %s to cache: %v", update.Key, err)
 			continue
 		}
 		
 		// Process with appropriate handler
 		if handler, ok := sm.syncHandlers[update.DataType]; ok {
 			if err := handler.ProcessUpdate(update.Key, updateData); err != nil {
-				log.Printf("Handler failed to process update %s: %v", update.Key, err)
+				sm.logger.Printf("Handler failed to process update %s: %v", update.Key, err)
 			}
 		}
+		sm.markFetched(update.Key, update.Timestamp)
 	}
 	
-	return nil
+	// Deferred updates keep the last sync time back, so they come down once
+	// the cap resets
+	return sm.finishFetch(deferred)
+}
+
+// reportSync sends a sync outcome to all registered reporters
+func (sm *SyncManager) reportSync(syncErr error) {
+	sm.changesMutex.Lock()
+	pendingCount := len(sm.pendingChanges)
+	sm.changesMutex.Unlock()
+	
+	message := ""
+	if syncErr != nil {
+		message = syncErr.Error()
+	}
+	
+	for _, reporter := range sm.syncReporters {
+		if err := reporter.ReportSync(syncErr == nil, pendingCount, message); err != nil {
+			sm.logger.Printf("Failed to report sync status: %v", err)
+		}
+	}
 }
 
 // Close closes the SyncManager and releases resources
 func (sm *SyncManager) Close() error {
 	sm.syncCron.Stop()
-	return sm.db.Close()
+	return sm.store.Close()
 }
 
 // GetLastSyncTime returns the time of the last successful sync
@@ -378,21 +407,11 @@ func (sm *SyncManager) ForceSyncNow() error {
 	return sm.Sync()
 }
 
-// GetSyncStatus returns the current sync status
-func (sm *SyncManager) GetSyncStatus() map[string]interface{} {
-	sm.changesMutex.Lock()
-	pendingCount := len(sm.pendingChanges)
-	sm.changesMutex.Unlock()
-	
-	sm.syncMux.Lock()
-	inProgress := sm.syncInProgress
-	sm.syncMux.Unlock()
-	
-	return map[string]interface{}{
-		"last_sync_time":   sm.lastSyncTime,
-		"is_online":        sm.IsOnline(),
-		"sync_in_progress": inProgress,
-		"pending_changes":  pendingCount,
-		"device_id":        sm.deviceID,
+// CheckStore reports whether the local store is open and readable; it can
+// be registered as a readiness check with rollout.ProbeServer
+func (sm *SyncManager) CheckStore(ctx context.Context) error {
+	if _, err := sm.store.Get([]byte("probe")); err != nil && err != kvstore.ErrKeyNotFound {
+		return fmt.Errorf("local store unavailable: %w", err)
 	}
+	return nil
 }
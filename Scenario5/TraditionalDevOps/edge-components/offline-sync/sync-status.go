@@ -0,0 +1,95 @@
+package offlineSync
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+)
+
+// SyncStatusVersion is the SyncStatus schema version; it increases when
+// fields are removed or change meaning, not when fields are added
+const SyncStatusVersion = 1
+
+// SyncStatus is a snapshot of the SyncManager's state. The JSON names match
+// the keys of the map GetSyncStatus used to return.
+type SyncStatus struct {
+	Version         int       `json:"version"`
+	DeviceID        string    `json:"device_id"`
+	IsOnline        bool      `json:"is_online"`
+	SyncInProgress  bool      `json:"sync_in_progress"`
+	PendingChanges  int       `json:"pending_changes"` // queued changes not yet uploaded
+	LastSyncTime    time.Time `json:"last_sync_time"`  // last successful sync
+	NextSyncTime    time.Time `json:"next_sync_time"`  // next scheduled sync
+	LastError       string    `json:"last_error,omitempty"`
+	LastErrorTime   time.Time `json:"last_error_time,omitempty"`
+	BytesUploaded   int64     `json:"bytes_uploaded"`   // since the manager started
+	BytesDownloaded int64     `json:"bytes_downloaded"` // since the manager started
+	UploadsSkipped  int64     `json:"uploads_skipped"`  // changes already stored remotely, since the manager started
+	Conflicts       int       `json:"conflicts"`        // waiting for ResolveConflict
+
+	// Bandwidth is the billing period's traffic, set with WithMeter
+	Bandwidth *bandwidth.Usage `json:"bandwidth,omitempty"`
+}
+
+// transferStats counts bytes moved by the transport
+type transferStats struct {
+	uploaded   atomic.Int64
+	downloaded atomic.Int64
+	skipped    atomic.Int64 // uploads skipped as duplicates
+}
+
+// GetSyncStatus returns the current sync status
+func (sm *SyncManager) GetSyncStatus() SyncStatus {
+	sm.changesMutex.Lock()
+	pendingCount := len(sm.pendingChanges)
+	conflictCount := len(sm.conflicts)
+	sm.changesMutex.Unlock()
+
+	sm.syncMux.Lock()
+	inProgress := sm.syncInProgress
+	sm.syncMux.Unlock()
+
+	sm.settingsMutex.RLock()
+	next := sm.syncCron.Entry(sm.syncEntry).Next
+	sm.settingsMutex.RUnlock()
+
+	sm.statusMutex.Lock()
+	defer sm.statusMutex.Unlock()
+
+	status := SyncStatus{
+		Version:         SyncStatusVersion,
+		DeviceID:        sm.deviceID,
+		IsOnline:        sm.IsOnline(),
+		SyncInProgress:  inProgress,
+		PendingChanges:  pendingCount,
+		LastSyncTime:    sm.lastSyncTime,
+		NextSyncTime:    next,
+		LastError:       sm.lastError,
+		LastErrorTime:   sm.lastErrorTime,
+		BytesUploaded:   sm.transfers.uploaded.Load(),
+		BytesDownloaded: sm.transfers.downloaded.Load(),
+		UploadsSkipped:  sm.transfers.skipped.Load(),
+		Conflicts:       conflictCount,
+	}
+	if sm.meter != nil {
+		usage := sm.meter.Usage()
+		status.Bandwidth = &usage
+	}
+	return status
+}
+
+// recordSyncResult keeps the last sync error for the status; a successful
+// sync clears it
+func (sm *SyncManager) recordSyncResult(err error) {
+	sm.statusMutex.Lock()
+	defer sm.statusMutex.Unlock()
+
+	if err == nil {
+		sm.lastError = ""
+		sm.lastErrorTime = time.Time{}
+		return
+	}
+	sm.lastError = err.Error()
+	sm.lastErrorTime = sm.clock.Now()
+}
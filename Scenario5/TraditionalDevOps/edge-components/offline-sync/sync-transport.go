@@ -0,0 +1,226 @@
+package offlineSync
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/transfer"
+)
+
+// SyncTransport moves sync objects between the device and the cloud. Keys
+// are full object keys, including the tenant and device prefix.
+type SyncTransport interface {
+	PutObject(ctx context.Context, key string, data []byte, metadata map[string]string) error
+	GetObject(ctx context.Context, key string) ([]byte, error)
+}
+
+// SyncMetadataHeader prefixes object metadata sent to a proxy as HTTP headers
+const SyncMetadataHeader = "X-Sync-Meta-"
+
+// S3API is the part of *s3.Client the S3Transport uses, so tests can pass a
+// testkit.FakeS3 instead of reaching minio
+type S3API interface {
+	PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+}
+
+// S3Transport is the default SyncTransport, reading and writing the sync bucket directly
+type S3Transport struct {
+	s3Client   S3API
+	bucket     string
+	uploader   *manager.Uploader   // multipart uploads, when the client supports them
+	downloader *manager.Downloader // concurrent ranged downloads
+}
+
+// NewS3Transport creates a SyncTransport for a bucket
+func NewS3Transport(client S3API, bucket string) *S3Transport {
+	return &S3Transport{s3Client: client, bucket: bucket}
+}
+
+// NewS3TransferTransport creates a SyncTransport for a bucket that moves
+// objects with the S3 transfer manager. Uploads stay single requests unless
+// the client also implements the multipart calls, as *s3.Client does.
+func NewS3TransferTransport(client S3API, bucket string, options transfer.Options) *S3Transport {
+	t := &S3Transport{
+		s3Client:   client,
+		bucket:     bucket,
+		downloader: transfer.NewDownloader(client, options),
+	}
+	if multipart, ok := client.(manager.UploadAPIClient); ok {
+		t.uploader = transfer.NewUploader(multipart, options)
+	}
+	return t
+}
+
+// PutObject uploads an object
+func (t *S3Transport) PutObject(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	input := &s3.PutObjectInput{
+		Bucket:   aws.String(t.bucket),
+		Key:      aws.String(key),
+		Body:     bytes.NewReader(data),
+		Metadata: metadata,
+	}
+
+	var err error
+	if t.uploader != nil {
+		_, err = t.uploader.Upload(ctx, input)
+	} else {
+		_, err = t.s3Client.PutObject(ctx, input)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+
+	return nil
+}
+
+// GetObject downloads an object
+func (t *S3Transport) GetObject(ctx context.Context, key string) ([]byte, error) {
+	if t.downloader != nil {
+		buffer := manager.NewWriteAtBuffer(nil)
+		_, err := t.downloader.Download(ctx, buffer, &s3.GetObjectInput{
+			Bucket: aws.String(t.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s: %w", key, err)
+		}
+		return buffer.Bytes(), nil
+	}
+
+	result, err := t.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(t.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer result.Body.Close()
+
+	data, err := io.ReadAll(result.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	return data, nil
+}
+
+// HTTPTransport is a SyncTransport for devices without cloud access, sending
+// sync objects through a gateway's proxy endpoint (rollout.GatewayProxy)
+type HTTPTransport struct {
+	httpClient *http.Client
+	baseURL    string
+}
+
+// NewHTTPTransport creates a SyncTransport for a proxy; the client's TLS
+// certificate identifies the device to the proxy
+func NewHTTPTransport(client *http.Client, baseURL string) *HTTPTransport {
+	return &HTTPTransport{httpClient: client, baseURL: strings.TrimSuffix(baseURL, "/")}
+}
+
+// PutObject uploads an object through the proxy
+func (t *HTTPTransport) PutObject(ctx context.Context, key string, data []byte, metadata map[string]string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, t.baseURL+"/sync/"+key, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range metadata {
+		req.Header.Set(SyncMetadataHeader+name, value)
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		return fmt.Errorf("failed to upload %s: %s", key, resp.Status)
+	}
+
+	return nil
+}
+
+// GetObject downloads an object through the proxy
+func (t *HTTPTransport) GetObject(ctx context.Context, key string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, t.baseURL+"/sync/"+key, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to download %s: %w", key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to download %s: %s", key, resp.Status)
+	}
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", key, err)
+	}
+
+	return data, nil
+}
+
+// manifestEntry is one update in a device's sync manifest
+type manifestEntry struct {
+	Key       string    `json:"key"`
+	Timestamp time.Time `json:"timestamp"`
+	DataType  string    `json:"dataType"`
+}
+
+// DeliverUpdate writes data into a device's updates as name and lists it in
+// the device's manifest, replacing an earlier update of the same name.
+// devicePrefix is the device's sync prefix, e.g. "devices/<id>/".
+func DeliverUpdate(ctx context.Context, transport SyncTransport, devicePrefix, name, dataType string, data []byte) error {
+	if err := transport.PutObject(ctx, devicePrefix+"updates/"+name, data, map[string]string{"datatype": dataType}); err != nil {
+		return err
+	}
+
+	// Read-modify-write the manifest, keeping other updates
+	var manifest struct {
+		Updates []manifestEntry `json:"updates"`
+	}
+	manifestData, err := transport.GetObject(ctx, devicePrefix+"manifest.json")
+	if err == nil {
+		if err := json.Unmarshal(manifestData, &manifest); err != nil {
+			return fmt.Errorf("failed to parse manifest: %w", err)
+		}
+	} else {
+		var noSuchKey *s3types.NoSuchKey
+		if !errors.As(err, &noSuchKey) {
+			return err
+		}
+	}
+
+	updates := make([]manifestEntry, 0, len(manifest.Updates)+1)
+	for _, update := range manifest.Updates {
+		if update.Key != name {
+			updates = append(updates, update)
+		}
+	}
+	manifest.Updates = append(updates, manifestEntry{Key: name, Timestamp: time.Now().UTC(), DataType: dataType})
+
+	manifestData, err = json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	return transport.PutObject(ctx, devicePrefix+"manifest.json", manifestData, nil)
+}
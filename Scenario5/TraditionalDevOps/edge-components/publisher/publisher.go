@@ -0,0 +1,232 @@
+package publisher
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// ErrVersionExists is returned when publishing a name+version that is already registered
+var ErrVersionExists = errors.New("artifact version already published")
+
+// namePattern restricts artifact names and versions to characters that are safe in S3 keys
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._+-]*$`)
+
+// Artifact is the registry entry for a published package
+type Artifact struct {
+	Name        string            `dynamodbav:"Name" json:"name"`
+	Version     string            `dynamodbav:"Version" json:"version"`
+	URL         string            `dynamodbav:"URL" json:"url"`
+	SHA256      string            `dynamodbav:"SHA256" json:"sha256"`
+	Size        int64             `dynamodbav:"Size" json:"size"`
+	Signature   string            `dynamodbav:"Signature" json:"signature,omitempty"`
+	KeyID       string            `dynamodbav:"KeyID" json:"keyId,omitempty"`
+	PublishedBy string            `dynamodbav:"PublishedBy" json:"publishedBy"`
+	PublishedAt time.Time         `dynamodbav:"PublishedAt" json:"publishedAt"`
+	Labels      map[string]string `dynamodbav:"Labels" json:"labels,omitempty"`
+}
+
+// Signer is an interface for signing artifact digests
+type Signer interface {
+	// Sign returns a signature over data
+	Sign(data []byte) ([]byte, error)
+
+	// KeyID identifies the signing key
+	KeyID() string
+}
+
+// PublishInput describes an artifact to publish
+type PublishInput struct {
+	Name        string
+	Version     string
+	FilePath    string
+	PublishedBy string
+	Labels      map[string]string
+}
+
+// Publisher uploads artifacts to S3 under immutable versioned keys and
+// registers their metadata in the artifact table
+type Publisher struct {
+	s3Client          *s3.Client
+	dynamoClient      *dynamodb.Client
+	bucketName        string
+	keyPrefix         string
+	artifactTableName string
+	tenantID          string
+	signer            Signer
+}
+
+// PublisherConfig contains configuration for the Publisher
+type PublisherConfig struct {
+	S3Client          *s3.Client
+	DynamoClient      *dynamodb.Client
+	BucketName        string
+	KeyPrefix         string
+	ArtifactTableName string
+	TenantID          string // scopes artifact names and object keys to a tenant
+	Signer            Signer
+}
+
+// NewPublisher creates a new Publisher
+func NewPublisher(config PublisherConfig) (*Publisher, error) {
+	if config.BucketName == "" || config.ArtifactTableName == "" {
+		return nil, fmt.Errorf("bucket name and artifact table name are required")
+	}
+
+	keyPrefix := config.KeyPrefix
+	if keyPrefix == "" {
+		keyPrefix = "artifacts"
+	}
+
+	return &Publisher{
+		s3Client:          config.S3Client,
+		dynamoClient:      config.DynamoClient,
+		bucketName:        config.BucketName,
+		keyPrefix:         keyPrefix,
+		artifactTableName: config.ArtifactTableName,
+		tenantID:          config.TenantID,
+		signer:            config.Signer,
+	}, nil
+}
+
+// Publish hashes, signs, uploads and registers an artifact; a name+version can only be published once
+func (p *Publisher) Publish(ctx context.Context, input PublishInput) (*Artifact, error) {
+	if !namePattern.MatchString(input.Name) || !namePattern.MatchString(input.Version) {
+		return nil, fmt.Errorf("invalid artifact name or version: %s@%s", input.Name, input.Version)
+	}
+
+	name := tenant.Key(p.tenantID, input.Name)
+
+	existing, err := Resolve(ctx, p.dynamoClient, p.artifactTableName, name, input.Version)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return nil, err
+	}
+	if existing != nil {
+		return nil, fmt.Errorf("%w: %s@%s", ErrVersionExists, input.Name, input.Version)
+	}
+
+	digest, size, err := fileDigest(input.FilePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to hash artifact: %w", err)
+	}
+
+	artifact := &Artifact{
+		Name:        name,
+		Version:     input.Version,
+		SHA256:      fmt.Sprintf("%x", digest),
+		Size:        size,
+		PublishedBy: input.PublishedBy,
+		PublishedAt: time.Now().UTC(),
+		Labels:      input.Labels,
+	}
+
+	if p.signer != nil {
+		signature, err := p.signer.Sign(digest)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sign artifact: %w", err)
+		}
+		artifact.Signature = base64.StdEncoding.EncodeToString(signature)
+		artifact.KeyID = p.signer.KeyID()
+	}
+
+	key := path.Join(tenant.S3Prefix(p.tenantID)+p.keyPrefix, input.Name, input.Version, filepath.Base(input.FilePath))
+	artifact.URL = fmt.Sprintf("s3://%s/%s", p.bucketName, key)
+
+	if err := p.upload(ctx, key, input.FilePath, artifact); err != nil {
+		return nil, err
+	}
+
+	if err := p.register(ctx, artifact); err != nil {
+		return nil, err
+	}
+
+	return artifact, nil
+}
+
+// upload writes the artifact body to S3
+func (p *Publisher) upload(ctx context.Context, key, filePath string, artifact *Artifact) error {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to open artifact: %w", err)
+	}
+	defer file.Close()
+
+	_, err = p.s3Client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(p.bucketName),
+		Key:           aws.String(key),
+		Body:          file,
+		ContentLength: aws.Int64(artifact.Size),
+		// Refuse to overwrite an existing object so versioned keys stay immutable
+		IfNoneMatch: aws.String("*"),
+		Metadata: map[string]string{
+			"sha256":  artifact.SHA256,
+			"version": artifact.Version,
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to upload artifact: %w", err)
+	}
+
+	return nil
+}
+
+// register records the artifact metadata, refusing to overwrite an existing entry
+func (p *Publisher) register(ctx context.Context, artifact *Artifact) error {
+	item, err := attributevalue.MarshalMap(artifact)
+	if err != nil {
+		return fmt.Errorf("failed to marshal artifact: %w", err)
+	}
+
+	_, err = p.dynamoClient.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName:           aws.String(p.artifactTableName),
+		Item:                item,
+		ConditionExpression: aws.String("attribute_not_exists(#name)"),
+		ExpressionAttributeNames: map[string]string{
+			"#name": "Name",
+		},
+	})
+
+	var conditionFailed *types.ConditionalCheckFailedException
+	if errors.As(err, &conditionFailed) {
+		return fmt.Errorf("%w: %s@%s", ErrVersionExists, artifact.Name, artifact.Version)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to register artifact: %w", err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+func fileDigest(filePath string) ([]byte, int64, error) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	return hash.Sum(nil), size, nil
+}
@@ -0,0 +1,102 @@
+package publisher
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ErrNotFound is returned when an artifact name+version is not registered
+var ErrNotFound = errors.New("artifact not found")
+
+// ItemGetter is the part of *dynamodb.Client Resolve uses
+type ItemGetter interface {
+	GetItem(ctx context.Context, input *dynamodb.GetItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error)
+}
+
+// Resolve looks up a published artifact by name and version
+func Resolve(ctx context.Context, client ItemGetter, tableName, name, version string) (*Artifact, error) {
+	result, err := client.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(tableName),
+		Key: map[string]types.AttributeValue{
+			"Name":    &types.AttributeValueMemberS{Value: name},
+			"Version": &types.AttributeValueMemberS{Value: version},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get artifact: %w", err)
+	}
+
+	if result.Item == nil {
+		return nil, fmt.Errorf("%w: %s@%s", ErrNotFound, name, version)
+	}
+
+	var artifact Artifact
+	if err := attributevalue.UnmarshalMap(result.Item, &artifact); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal artifact: %w", err)
+	}
+
+	return &artifact, nil
+}
+
+// ListVersions returns every published version of an artifact
+func ListVersions(ctx context.Context, client *dynamodb.Client, tableName, name string) ([]Artifact, error) {
+	artifacts := make([]Artifact, 0)
+
+	paginator := dynamodb.NewQueryPaginator(client, &dynamodb.QueryInput{
+		TableName:              aws.String(tableName),
+		KeyConditionExpression: aws.String("#name = :name"),
+		ExpressionAttributeNames: map[string]string{
+			"#name": "Name",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":name": &types.AttributeValueMemberS{Value: name},
+		},
+	})
+
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query artifact versions: %w", err)
+		}
+
+		var batch []Artifact
+		if err := attributevalue.UnmarshalListOfMaps(page.Items, &batch); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal artifacts: %w", err)
+		}
+		artifacts = append(artifacts, batch...)
+	}
+
+	return artifacts, nil
+}
+
+// VerifySignature checks an artifact's signature over its SHA-256 digest
+func VerifySignature(artifact *Artifact, publicKey ed25519.PublicKey) error {
+	if artifact.Signature == "" {
+		return fmt.Errorf("artifact %s@%s is not signed", artifact.Name, artifact.Version)
+	}
+
+	digest, err := hex.DecodeString(artifact.SHA256)
+	if err != nil {
+		return fmt.Errorf("invalid artifact digest: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(artifact.Signature)
+	if err != nil {
+		return fmt.Errorf("invalid artifact signature encoding: %w", err)
+	}
+
+	if !ed25519.Verify(publicKey, digest, signature) {
+		return fmt.Errorf("artifact signature verification failed for %s@%s", artifact.Name, artifact.Version)
+	}
+
+	return nil
+}
@@ -0,0 +1,411 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	deviceconfig "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/device-config"
+	fleetserver "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/fleet-server"
+)
+
+// Signer is an interface for signing report manifests
+type Signer interface {
+	// Sign returns a signature over data
+	Sign(data []byte) ([]byte, error)
+
+	// KeyID identifies the key auditors should verify the signature with
+	KeyID() string
+}
+
+// Ed25519Signer signs reports with a local Ed25519 private key
+type Ed25519Signer struct {
+	privateKey ed25519.PrivateKey
+	keyID      string
+}
+
+// NewEd25519Signer creates a new Ed25519Signer
+func NewEd25519Signer(privateKey ed25519.PrivateKey, keyID string) *Ed25519Signer {
+	return &Ed25519Signer{privateKey: privateKey, keyID: keyID}
+}
+
+// Sign signs data with the private key
+func (s *Ed25519Signer) Sign(data []byte) ([]byte, error) {
+	return ed25519.Sign(s.privateKey, data), nil
+}
+
+// KeyID returns the key identifier
+func (s *Ed25519Signer) KeyID() string {
+	return s.keyID
+}
+
+// DeviceVersionEntry records which version a device runs
+type DeviceVersionEntry struct {
+	DeviceID       string `json:"deviceId"`
+	DeviceGroup    string `json:"deviceGroup"`
+	Region         string `json:"region"`
+	CurrentVersion string `json:"currentVersion"`
+	LastUpdateID   string `json:"lastUpdateId"`
+	LastUpdateTime string `json:"lastUpdateTime"`
+	UpdateStatus   string `json:"updateStatus"`
+}
+
+// ApprovalEntry records who approved a rollout phase and when
+type ApprovalEntry struct {
+	RolloutID   string    `json:"rolloutId"`
+	RolloutName string    `json:"rolloutName"`
+	Version     string    `json:"version"`
+	PhaseID     string    `json:"phaseId"`
+	CreatedBy   string    `json:"createdBy"`
+	ApprovedBy  string    `json:"approvedBy"`
+	ApprovedAt  time.Time `json:"approvedAt"`
+}
+
+// DriftEntry records outstanding configuration drift on a device
+type DriftEntry struct {
+	DeviceID       string    `json:"deviceId"`
+	DesiredVersion string    `json:"desiredVersion"`
+	AppliedVersion string    `json:"appliedVersion"`
+	DriftedItems   int       `json:"driftedItems"`
+	CheckedAt      time.Time `json:"checkedAt"`
+}
+
+// ComplianceReport is a point-in-time audit record of the fleet
+type ComplianceReport struct {
+	GeneratedAt    time.Time            `json:"generatedAt"`
+	PeriodStart    time.Time            `json:"periodStart"`
+	PeriodEnd      time.Time            `json:"periodEnd"`
+	TotalDevices   int                  `json:"totalDevices"`
+	DevicesInDrift int                  `json:"devicesInDrift"`
+	DeviceVersions []DeviceVersionEntry `json:"deviceVersions"`
+	Approvals      []ApprovalEntry      `json:"approvals"`
+	Drift          []DriftEntry         `json:"drift"`
+}
+
+// ReportManifest lists the files of a published report and is what gets signed
+type ReportManifest struct {
+	GeneratedAt time.Time         `json:"generatedAt"`
+	Files       map[string]string `json:"files"` // file name -> sha256
+	KeyID       string            `json:"keyId"`
+	Signature   string            `json:"signature,omitempty"`
+}
+
+// ComplianceReporter periodically generates signed compliance reports
+type ComplianceReporter struct {
+	dynamoClient     *dynamodb.Client
+	s3Client         *s3.Client
+	deviceTableName  string
+	rolloutTableName string
+	syncBucket       string
+	reportBucket     string
+	signer           Signer
+	lastReportTime   time.Time
+	reportInterval   time.Duration
+	reportTimer      *time.Timer
+}
+
+// ComplianceConfig contains configuration for the ComplianceReporter
+type ComplianceConfig struct {
+	DynamoClient     *dynamodb.Client
+	S3Client         *s3.Client
+	DeviceTableName  string
+	RolloutTableName string
+	SyncBucket       string // where devices upload drift reports; empty skips drift
+	ReportBucket     string
+	Signer           Signer
+	ReportInterval   time.Duration
+}
+
+// NewComplianceReporter creates a new ComplianceReporter
+func NewComplianceReporter(config ComplianceConfig) (*ComplianceReporter, error) {
+	if config.Signer == nil {
+		return nil, fmt.Errorf("a signer is required for compliance reports")
+	}
+
+	cr := &ComplianceReporter{
+		dynamoClient:     config.DynamoClient,
+		s3Client:         config.S3Client,
+		deviceTableName:  config.DeviceTableName,
+		rolloutTableName: config.RolloutTableName,
+		syncBucket:       config.SyncBucket,
+		reportBucket:     config.ReportBucket,
+		signer:           config.Signer,
+		lastReportTime:   time.Now().UTC().Add(-config.ReportInterval),
+		reportInterval:   config.ReportInterval,
+	}
+
+	// Start the report timer
+	cr.reportTimer = time.AfterFunc(cr.reportInterval, cr.reportLoop)
+
+	return cr, nil
+}
+
+// reportLoop publishes a report and reschedules itself
+func (cr *ComplianceReporter) reportLoop() {
+	defer func() {
+		// Reschedule the report
+		cr.reportTimer.Reset(cr.reportInterval)
+	}()
+
+	now := time.Now().UTC()
+	report, err := cr.Generate(context.Background(), cr.lastReportTime, now)
+	if err != nil {
+		log.Printf("Failed to generate compliance report: %v", err)
+		return
+	}
+
+	if _, err := cr.Publish(context.Background(), report); err != nil {
+		log.Printf("Failed to publish compliance report: %v", err)
+		return
+	}
+
+	cr.lastReportTime = now
+}
+
+// Generate builds a compliance report covering approvals made within the period
+func (cr *ComplianceReporter) Generate(ctx context.Context, periodStart, periodEnd time.Time) (*ComplianceReport, error) {
+	devices, err := fleetserver.ScanDevices(ctx, cr.dynamoClient, cr.deviceTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	rollouts, err := fleetserver.ScanRollouts(ctx, cr.dynamoClient, cr.rolloutTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &ComplianceReport{
+		GeneratedAt:    time.Now().UTC(),
+		PeriodStart:    periodStart,
+		PeriodEnd:      periodEnd,
+		TotalDevices:   len(devices),
+		DeviceVersions: make([]DeviceVersionEntry, 0, len(devices)),
+		Approvals:      make([]ApprovalEntry, 0),
+		Drift:          make([]DriftEntry, 0),
+	}
+
+	for _, device := range devices {
+		report.DeviceVersions = append(report.DeviceVersions, DeviceVersionEntry{
+			DeviceID:       device.DeviceID,
+			DeviceGroup:    device.DeviceGroup,
+			Region:         device.Region,
+			CurrentVersion: device.CurrentVersion,
+			LastUpdateID:   device.LastUpdateID,
+			LastUpdateTime: device.LastUpdateTime,
+			UpdateStatus:   device.UpdateStatus,
+		})
+
+		if cr.syncBucket == "" {
+			continue
+		}
+
+		drift, err := cr.getDriftReport(ctx, device.DeviceID)
+		if err != nil || drift == nil || drift.InSync {
+			continue
+		}
+
+		report.Drift = append(report.Drift, DriftEntry{
+			DeviceID:       device.DeviceID,
+			DesiredVersion: drift.DesiredVersion,
+			AppliedVersion: drift.AppliedVersion,
+			DriftedItems:   len(drift.Drift),
+			CheckedAt:      drift.CheckedAt,
+		})
+	}
+	report.DevicesInDrift = len(report.Drift)
+
+	for _, plan := range rollouts {
+		for _, phase := range plan.Phases {
+			if !phase.Approved || phase.ApprovedAt.Before(periodStart) || phase.ApprovedAt.After(periodEnd) {
+				continue
+			}
+
+			report.Approvals = append(report.Approvals, ApprovalEntry{
+				RolloutID:   plan.ID,
+				RolloutName: plan.Name,
+				Version:     plan.Version,
+				PhaseID:     phase.ID,
+				CreatedBy:   plan.CreatedBy,
+				ApprovedBy:  phase.ApprovedBy,
+				ApprovedAt:  phase.ApprovedAt,
+			})
+		}
+	}
+
+	sort.Slice(report.DeviceVersions, func(i, j int) bool {
+		return report.DeviceVersions[i].DeviceID < report.DeviceVersions[j].DeviceID
+	})
+	sort.Slice(report.Approvals, func(i, j int) bool {
+		return report.Approvals[i].ApprovedAt.Before(report.Approvals[j].ApprovedAt)
+	})
+
+	return report, nil
+}
+
+// Render produces the JSON and CSV files of a report together with its signed manifest
+func (cr *ComplianceReporter) Render(report *ComplianceReport) (map[string][]byte, error) {
+	files := make(map[string][]byte)
+
+	reportJSON, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal report: %w", err)
+	}
+	files["report.json"] = reportJSON
+
+	versionRows := [][]string{{"device_id", "device_group", "region", "current_version", "last_update_id", "last_update_time", "update_status"}}
+	for _, e := range report.DeviceVersions {
+		versionRows = append(versionRows, []string{e.DeviceID, e.DeviceGroup, e.Region, e.CurrentVersion, e.LastUpdateID, e.LastUpdateTime, e.UpdateStatus})
+	}
+
+	approvalRows := [][]string{{"rollout_id", "rollout_name", "version", "phase_id", "created_by", "approved_by", "approved_at"}}
+	for _, e := range report.Approvals {
+		approvalRows = append(approvalRows, []string{e.RolloutID, e.RolloutName, e.Version, e.PhaseID, e.CreatedBy, e.ApprovedBy, e.ApprovedAt.Format(time.RFC3339)})
+	}
+
+	driftRows := [][]string{{"device_id", "desired_version", "applied_version", "drifted_items", "checked_at"}}
+	for _, e := range report.Drift {
+		driftRows = append(driftRows, []string{e.DeviceID, e.DesiredVersion, e.AppliedVersion, fmt.Sprintf("%d", e.DriftedItems), e.CheckedAt.Format(time.RFC3339)})
+	}
+
+	for name, rows := range map[string][][]string{
+		"device-versions.csv": versionRows,
+		"approvals.csv":       approvalRows,
+		"drift.csv":           driftRows,
+	} {
+		data, err := renderCSV(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to render %s: %w", name, err)
+		}
+		files[name] = data
+	}
+
+	manifest := ReportManifest{
+		GeneratedAt: report.GeneratedAt,
+		Files:       make(map[string]string),
+		KeyID:       cr.signer.KeyID(),
+	}
+	for name, data := range files {
+		manifest.Files[name] = fmt.Sprintf("%x", sha256.Sum256(data))
+	}
+
+	unsigned, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	signature, err := cr.signer.Sign(unsigned)
+	if err != nil {
+		return nil, fmt.Errorf("failed to sign manifest: %w", err)
+	}
+	manifest.Signature = base64.StdEncoding.EncodeToString(signature)
+
+	manifestJSON, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+	files["manifest.json"] = manifestJSON
+
+	return files, nil
+}
+
+// Publish renders a report and uploads it under a dated prefix, returning the prefix
+func (cr *ComplianceReporter) Publish(ctx context.Context, report *ComplianceReport) (string, error) {
+	files, err := cr.Render(report)
+	if err != nil {
+		return "", err
+	}
+
+	prefix := fmt.Sprintf("compliance/%s/", report.GeneratedAt.Format("2006-01-02T150405Z"))
+	for name, data := range files {
+		_, err := cr.s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(cr.reportBucket),
+			Key:    aws.String(prefix + name),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to upload %s: %w", name, err)
+		}
+	}
+
+	return prefix, nil
+}
+
+// getDriftReport fetches the latest drift report uploaded by a device
+func (cr *ComplianceReporter) getDriftReport(ctx context.Context, deviceID string) (*deviceconfig.DriftReport, error) {
+	result, err := cr.s3Client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(cr.syncBucket),
+		Key:    aws.String(fmt.Sprintf("devices/%s/data/config/drift-report.json", deviceID)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	data, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var report deviceconfig.DriftReport
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, err
+	}
+
+	return &report, nil
+}
+
+// Close stops the compliance reporter
+func (cr *ComplianceReporter) Close() {
+	if cr.reportTimer != nil {
+		cr.reportTimer.Stop()
+	}
+}
+
+// VerifyManifest checks a manifest signature with an Ed25519 public key
+func VerifyManifest(manifestJSON []byte, publicKey ed25519.PublicKey) error {
+	var manifest ReportManifest
+	if err := json.Unmarshal(manifestJSON, &manifest); err != nil {
+		return fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	manifest.Signature = ""
+	unsigned, err := json.Marshal(manifest)
+	if err != nil {
+		return fmt.Errorf("failed to marshal manifest: %w", err)
+	}
+
+	if !ed25519.Verify(publicKey, unsigned, signature) {
+		return fmt.Errorf("manifest signature is invalid")
+	}
+
+	return nil
+}
+
+// Helper functions
+
+func renderCSV(rows [][]string) ([]byte, error) {
+	var buf bytes.Buffer
+	w := csv.NewWriter(&buf)
+	if err := w.WriteAll(rows); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,442 @@
+package reporting
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/parquet-go/parquet-go"
+
+	fleetserver "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/fleet-server"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Output formats of the fleet summary
+const (
+	FormatCSV     = "csv"
+	FormatParquet = "parquet"
+)
+
+// VersionCount is the number of devices in a group running a version
+type VersionCount struct {
+	Date        string `json:"date" parquet:"date"`
+	TenantID    string `json:"tenantId" parquet:"tenant_id"`
+	DeviceGroup string `json:"deviceGroup" parquet:"device_group"`
+	Version     string `json:"version" parquet:"version"`
+	Devices     int64  `json:"devices" parquet:"devices"`
+}
+
+// UpdateCount is the number of devices whose last update report for a
+// rollout, made during the day, had a status
+type UpdateCount struct {
+	Date      string `json:"date" parquet:"date"`
+	TenantID  string `json:"tenantId" parquet:"tenant_id"`
+	RolloutID string `json:"rolloutId" parquet:"rollout_id"`
+	Status    string `json:"status" parquet:"status"`
+	Devices   int64  `json:"devices" parquet:"devices"`
+}
+
+// OfflineDevice is a device not seen within OfflineAfter when the summary was generated
+type OfflineDevice struct {
+	Date         string  `json:"date" parquet:"date"`
+	TenantID     string  `json:"tenantId" parquet:"tenant_id"`
+	DeviceID     string  `json:"deviceId" parquet:"device_id"`
+	DeviceGroup  string  `json:"deviceGroup" parquet:"device_group"`
+	Region       string  `json:"region" parquet:"region"`
+	LastSeen     string  `json:"lastSeen" parquet:"last_seen"` // RFC3339; empty if never seen
+	OfflineHours float64 `json:"offlineHours" parquet:"offline_hours"`
+}
+
+// SyncVolume is what a device uploaded through offline sync during the day
+type SyncVolume struct {
+	Date     string `json:"date" parquet:"date"`
+	TenantID string `json:"tenantId" parquet:"tenant_id"`
+	DeviceID string `json:"deviceId" parquet:"device_id"`
+	Objects  int64  `json:"objects" parquet:"objects"`
+	Bytes    int64  `json:"bytes" parquet:"bytes"`
+}
+
+// FleetSummary is one UTC day of fleet activity. Versions and offline
+// devices are the state when it was generated, shortly after the day ended;
+// updates and sync volumes cover the day itself.
+type FleetSummary struct {
+	Date        string          `json:"date"` // 2006-01-02
+	GeneratedAt time.Time       `json:"generatedAt"`
+	Devices     int             `json:"devices"`
+	Versions    []VersionCount  `json:"versions"`
+	Updates     []UpdateCount   `json:"updates"`
+	Offline     []OfflineDevice `json:"offline"`
+	SyncVolumes []SyncVolume    `json:"syncVolumes"`
+}
+
+// FleetSummaryReporter writes a FleetSummary for the previous UTC day to S3
+// every day, one file per table under Hive-style date partitions, e.g.
+// fleet-summary/versions/date=2024-05-01/versions.parquet, so BI tools such
+// as Athena or QuickSight can query the history without touching DynamoDB
+type FleetSummaryReporter struct {
+	dynamoClient    *dynamodb.Client
+	s3Client        *s3.Client
+	deviceTableName string
+	syncBucket      string
+	reportBucket    string
+	prefix          string
+	formats         []string
+	offlineAfter    time.Duration
+	runAt           time.Duration
+	reportTimer     *time.Timer
+}
+
+// FleetSummaryConfig contains configuration for the FleetSummaryReporter
+type FleetSummaryConfig struct {
+	DynamoClient    *dynamodb.Client
+	S3Client        *s3.Client
+	DeviceTableName string
+	SyncBucket      string // where devices upload sync data; empty skips sync volumes
+	ReportBucket    string
+	Prefix          string        // defaults to fleet-summary/
+	Formats         []string      // csv and/or parquet; defaults to both
+	OfflineAfter    time.Duration // defaults to 24 hours
+	RunAt           time.Duration // time of day, UTC; defaults to 00:30
+}
+
+// NewFleetSummaryReporter creates a new FleetSummaryReporter and schedules the first report
+func NewFleetSummaryReporter(config FleetSummaryConfig) (*FleetSummaryReporter, error) {
+	if config.Prefix == "" {
+		config.Prefix = "fleet-summary/"
+	}
+	if len(config.Formats) == 0 {
+		config.Formats = []string{FormatCSV, FormatParquet}
+	}
+	if config.OfflineAfter == 0 {
+		config.OfflineAfter = 24 * time.Hour
+	}
+	if config.RunAt == 0 {
+		config.RunAt = 30 * time.Minute
+	}
+
+	for _, format := range config.Formats {
+		if format != FormatCSV && format != FormatParquet {
+			return nil, fmt.Errorf("unknown fleet summary format %q", format)
+		}
+	}
+	if config.RunAt < 0 || config.RunAt >= 24*time.Hour {
+		return nil, fmt.Errorf("run time must be within a day, got %s", config.RunAt)
+	}
+
+	fr := &FleetSummaryReporter{
+		dynamoClient:    config.DynamoClient,
+		s3Client:        config.S3Client,
+		deviceTableName: config.DeviceTableName,
+		syncBucket:      config.SyncBucket,
+		reportBucket:    config.ReportBucket,
+		prefix:          strings.TrimSuffix(config.Prefix, "/") + "/",
+		formats:         config.Formats,
+		offlineAfter:    config.OfflineAfter,
+		runAt:           config.RunAt,
+	}
+
+	// Start the report timer
+	fr.reportTimer = time.AfterFunc(fr.untilNextRun(time.Now().UTC()), fr.reportLoop)
+
+	return fr, nil
+}
+
+// reportLoop publishes the previous day's summary and reschedules itself
+func (fr *FleetSummaryReporter) reportLoop() {
+	defer func() {
+		// Reschedule the report
+		fr.reportTimer.Reset(fr.untilNextRun(time.Now().UTC()))
+	}()
+
+	now := time.Now().UTC()
+	day := now.Truncate(24*time.Hour).AddDate(0, 0, -1)
+
+	summary, err := fr.Generate(context.Background(), day, now)
+	if err != nil {
+		log.Printf("Failed to generate fleet summary: %v", err)
+		return
+	}
+
+	if _, err := fr.Publish(context.Background(), summary); err != nil {
+		log.Printf("Failed to publish fleet summary: %v", err)
+	}
+}
+
+// Generate builds the summary of the UTC day starting at day, with device
+// state as of now
+func (fr *FleetSummaryReporter) Generate(ctx context.Context, day, now time.Time) (*FleetSummary, error) {
+	devices, err := fleetserver.ScanDevices(ctx, fr.dynamoClient, fr.deviceTableName)
+	if err != nil {
+		return nil, err
+	}
+
+	dayStart := day.UTC().Truncate(24 * time.Hour)
+	dayEnd := dayStart.Add(24 * time.Hour)
+	date := dayStart.Format("2006-01-02")
+
+	summary := &FleetSummary{
+		Date:        date,
+		GeneratedAt: now.UTC(),
+		Devices:     len(devices),
+		Versions:    make([]VersionCount, 0),
+		Updates:     make([]UpdateCount, 0),
+		Offline:     make([]OfflineDevice, 0),
+		SyncVolumes: make([]SyncVolume, 0),
+	}
+
+	versions := make(map[[3]string]int64)
+	updates := make(map[[3]string]int64)
+	for _, device := range devices {
+		tenantID, deviceID := tenant.Split(device.DeviceID)
+
+		versions[[3]string{tenantID, device.DeviceGroup, valueOr(device.CurrentVersion, "unknown")}]++
+
+		if updated, err := time.Parse(time.RFC3339, device.LastUpdateTime); err == nil && !updated.Before(dayStart) && updated.Before(dayEnd) {
+			updates[[3]string{tenantID, device.LastUpdateID, device.UpdateStatus}]++
+		}
+
+		lastSeen, err := time.Parse(time.RFC3339, device.LastSeen)
+		if err == nil && now.Sub(lastSeen) <= fr.offlineAfter {
+			continue
+		}
+		offline := OfflineDevice{
+			Date:        date,
+			TenantID:    tenantID,
+			DeviceID:    deviceID,
+			DeviceGroup: device.DeviceGroup,
+			Region:      device.Region,
+		}
+		if err == nil {
+			offline.LastSeen = lastSeen.UTC().Format(time.RFC3339)
+			offline.OfflineHours = now.Sub(lastSeen).Hours()
+		}
+		summary.Offline = append(summary.Offline, offline)
+	}
+
+	for key, count := range versions {
+		summary.Versions = append(summary.Versions, VersionCount{Date: date, TenantID: key[0], DeviceGroup: key[1], Version: key[2], Devices: count})
+	}
+	for key, count := range updates {
+		summary.Updates = append(summary.Updates, UpdateCount{Date: date, TenantID: key[0], RolloutID: key[1], Status: key[2], Devices: count})
+	}
+
+	if fr.syncBucket != "" {
+		volumes, err := fr.syncVolumes(ctx, date, dayStart, dayEnd)
+		if err != nil {
+			return nil, err
+		}
+		summary.SyncVolumes = volumes
+	}
+
+	sort.Slice(summary.Versions, func(i, j int) bool {
+		a, b := summary.Versions[i], summary.Versions[j]
+		return a.TenantID+"/"+a.DeviceGroup+"/"+a.Version < b.TenantID+"/"+b.DeviceGroup+"/"+b.Version
+	})
+	sort.Slice(summary.Updates, func(i, j int) bool {
+		a, b := summary.Updates[i], summary.Updates[j]
+		return a.TenantID+"/"+a.RolloutID+"/"+a.Status < b.TenantID+"/"+b.RolloutID+"/"+b.Status
+	})
+	sort.Slice(summary.Offline, func(i, j int) bool {
+		return summary.Offline[i].TenantID+"/"+summary.Offline[i].DeviceID < summary.Offline[j].TenantID+"/"+summary.Offline[j].DeviceID
+	})
+
+	return summary, nil
+}
+
+// Render produces each table of the summary in every configured format,
+// keyed by object name relative to the prefix
+func (fr *FleetSummaryReporter) Render(summary *FleetSummary) (map[string][]byte, error) {
+	tables := []struct {
+		name    string
+		model   interface{}
+		header  []string
+		rows    [][]string
+		records []interface{}
+	}{
+		{name: "versions", model: VersionCount{}, header: []string{"date", "tenant_id", "device_group", "version", "devices"}},
+		{name: "updates", model: UpdateCount{}, header: []string{"date", "tenant_id", "rollout_id", "status", "devices"}},
+		{name: "offline", model: OfflineDevice{}, header: []string{"date", "tenant_id", "device_id", "device_group", "region", "last_seen", "offline_hours"}},
+		{name: "sync-volumes", model: SyncVolume{}, header: []string{"date", "tenant_id", "device_id", "objects", "bytes"}},
+	}
+
+	for _, e := range summary.Versions {
+		tables[0].rows = append(tables[0].rows, []string{e.Date, e.TenantID, e.DeviceGroup, e.Version, strconv.FormatInt(e.Devices, 10)})
+		tables[0].records = append(tables[0].records, e)
+	}
+	for _, e := range summary.Updates {
+		tables[1].rows = append(tables[1].rows, []string{e.Date, e.TenantID, e.RolloutID, e.Status, strconv.FormatInt(e.Devices, 10)})
+		tables[1].records = append(tables[1].records, e)
+	}
+	for _, e := range summary.Offline {
+		tables[2].rows = append(tables[2].rows, []string{e.Date, e.TenantID, e.DeviceID, e.DeviceGroup, e.Region, e.LastSeen, strconv.FormatFloat(e.OfflineHours, 'f', 1, 64)})
+		tables[2].records = append(tables[2].records, e)
+	}
+	for _, e := range summary.SyncVolumes {
+		tables[3].rows = append(tables[3].rows, []string{e.Date, e.TenantID, e.DeviceID, strconv.FormatInt(e.Objects, 10), strconv.FormatInt(e.Bytes, 10)})
+		tables[3].records = append(tables[3].records, e)
+	}
+
+	files := make(map[string][]byte)
+	for _, table := range tables {
+		partition := fmt.Sprintf("%s/date=%s/%s", table.name, summary.Date, table.name)
+
+		for _, format := range fr.formats {
+			var data []byte
+			var err error
+			switch format {
+			case FormatCSV:
+				data, err = renderCSV(append([][]string{table.header}, table.rows...))
+			case FormatParquet:
+				data, err = renderParquet(table.model, table.records)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to render %s as %s: %w", table.name, format, err)
+			}
+			files[partition+"."+format] = data
+		}
+	}
+
+	return files, nil
+}
+
+// Publish renders a summary and uploads it, replacing an earlier summary of
+// the same day; it returns the uploaded keys
+func (fr *FleetSummaryReporter) Publish(ctx context.Context, summary *FleetSummary) ([]string, error) {
+	files, err := fr.Render(summary)
+	if err != nil {
+		return nil, err
+	}
+
+	keys := make([]string, 0, len(files))
+	for name, data := range files {
+		key := fr.prefix + name
+		_, err := fr.s3Client.PutObject(ctx, &s3.PutObjectInput{
+			Bucket: aws.String(fr.reportBucket),
+			Key:    aws.String(key),
+			Body:   bytes.NewReader(data),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to upload %s: %w", key, err)
+		}
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Close stops the fleet summary reporter
+func (fr *FleetSummaryReporter) Close() {
+	if fr.reportTimer != nil {
+		fr.reportTimer.Stop()
+	}
+}
+
+// syncVolumes totals the sync data each device uploaded within the day,
+// from the last-modified times of the objects under the devices' data prefixes
+func (fr *FleetSummaryReporter) syncVolumes(ctx context.Context, date string, dayStart, dayEnd time.Time) ([]SyncVolume, error) {
+	volumes := make(map[[2]string]*SyncVolume)
+
+	paginator := s3.NewListObjectsV2Paginator(fr.s3Client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(fr.syncBucket),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list sync objects: %w", err)
+		}
+
+		for _, object := range page.Contents {
+			if object.LastModified == nil || object.LastModified.Before(dayStart) || !object.LastModified.Before(dayEnd) {
+				continue
+			}
+
+			tenantID, deviceID, ok := syncDataOwner(aws.ToString(object.Key))
+			if !ok {
+				continue
+			}
+
+			key := [2]string{tenantID, deviceID}
+			volume := volumes[key]
+			if volume == nil {
+				volume = &SyncVolume{Date: date, TenantID: tenantID, DeviceID: deviceID}
+				volumes[key] = volume
+			}
+			volume.Objects++
+			volume.Bytes += aws.ToInt64(object.Size)
+		}
+	}
+
+	result := make([]SyncVolume, 0, len(volumes))
+	for _, volume := range volumes {
+		result = append(result, *volume)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].TenantID+"/"+result[i].DeviceID < result[j].TenantID+"/"+result[j].DeviceID
+	})
+
+	return result, nil
+}
+
+// untilNextRun returns how long until the next run at the configured time of day
+func (fr *FleetSummaryReporter) untilNextRun(now time.Time) time.Duration {
+	next := now.Truncate(24 * time.Hour).Add(fr.runAt)
+	if !next.After(now) {
+		next = next.Add(24 * time.Hour)
+	}
+	return next.Sub(now)
+}
+
+// Helper functions
+
+// syncDataOwner returns the tenant and device of an object a device uploaded,
+// keyed "[tenants/<tenant>/]devices/<device>/data/..."
+func syncDataOwner(key string) (string, string, bool) {
+	var tenantID string
+	if rest, ok := strings.CutPrefix(key, "tenants/"); ok {
+		tenantID, key, ok = strings.Cut(rest, "/")
+		if !ok {
+			return "", "", false
+		}
+	}
+
+	rest, ok := strings.CutPrefix(key, "devices/")
+	if !ok {
+		return "", "", false
+	}
+	deviceID, path, ok := strings.Cut(rest, "/")
+	if !ok || !strings.HasPrefix(path, "data/") {
+		return "", "", false
+	}
+
+	return tenantID, deviceID, true
+}
+
+func renderParquet(model interface{}, records []interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	w := parquet.NewWriter(&buf, parquet.SchemaOf(model))
+	for _, record := range records {
+		if err := w.Write(record); err != nil {
+			return nil, err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func valueOr(value, fallback string) string {
+	if value == "" {
+		return fallback
+	}
+	return value
+}
@@ -0,0 +1,358 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// Bounds on intervals suggested by the fleet server, the same as on configured ones
+const (
+	MinPollInterval = minCheckInterval
+	MaxPollInterval = maxCheckInterval
+)
+
+// pollSchedule keeps a large fleet from throttling the rollout table. Devices
+// poll slowly while no rollout targets them and quickly while one does,
+// reuse the last active plan until it expires, and stop querying for the
+// rest of a budget window once its query budget is spent. Intervals, window
+// start and budget are randomized per device so polls spread evenly. The
+// fleet server may replace the intervals: an active plan's PollInterval, or
+// else the one the PollAdvisor stamps on the device record, wins.
+type pollSchedule struct {
+	activeInterval time.Duration
+	idleInterval   time.Duration
+	jitter         float64
+	cacheTTL       time.Duration
+	budget         int
+	budgetWindow   time.Duration
+	maxPages       int // pages read per rollout query
+	windowEnd      time.Time
+	windowBudget   int
+	queries        int
+	cached         *RolloutPlan
+	cachedAt       time.Time
+	finishedID     string        // rollout this device already reported a final status for
+	suggested      time.Duration // from the device record; zero when the server suggests none
+	pushInterval   time.Duration // idle interval while rollout notices arrive by long poll
+	pushed         bool
+	mutex          sync.Mutex
+}
+
+// newPollSchedule creates a poll schedule from the manager configuration
+func newPollSchedule(config RolloutConfig) *pollSchedule {
+	ps := &pollSchedule{}
+	ps.configure(config)
+
+	// Start part-way through a window so devices booted together reset at different times
+	ps.windowEnd = time.Now().Add(time.Duration(rand.Int63n(int64(ps.budgetWindow) + 1)))
+	ps.windowBudget = ps.randomBudget()
+
+	return ps
+}
+
+// configure sets the intervals and budget from the configuration, with defaults
+func (ps *pollSchedule) configure(config RolloutConfig) {
+	ps.activeInterval = config.CheckInterval
+	ps.idleInterval = config.IdleCheckInterval
+	ps.jitter = config.PollJitter
+	ps.cacheTTL = config.PlanCacheTTL
+	ps.budget = config.QueryBudget
+	ps.budgetWindow = config.QueryBudgetWindow
+	ps.maxPages = config.MaxQueryPages
+
+	if ps.idleInterval == 0 {
+		ps.idleInterval = 5 * ps.activeInterval
+	}
+	if ps.jitter == 0 {
+		ps.jitter = 0.2
+	}
+	if ps.cacheTTL == 0 {
+		ps.cacheTTL = 2 * ps.activeInterval
+	}
+	if ps.budgetWindow == 0 {
+		ps.budgetWindow = time.Hour
+	}
+	if ps.maxPages == 0 {
+		ps.maxPages = 10
+	}
+}
+
+// pageLimit returns how many pages a rollout query may read
+func (ps *pollSchedule) pageLimit() int {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	return ps.maxPages
+}
+
+// reconfigure applies new settings while running, keeping the cached plan
+// and the current budget window
+func (ps *pollSchedule) reconfigure(config RolloutConfig) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.configure(config)
+	ps.windowBudget = ps.randomBudget()
+}
+
+// next returns the delay until the next check, with jitter
+func (ps *pollSchedule) next() time.Duration {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	interval := ps.serverInterval()
+	if interval == 0 {
+		interval = ps.idleInterval
+		if ps.active() {
+			interval = ps.activeInterval
+		}
+	}
+
+	// While rollout notices arrive by long poll, idle checks are only a safety net
+	if ps.pushed && !ps.active() && interval < ps.pushInterval {
+		interval = ps.pushInterval
+	}
+
+	factor := 1 + ps.jitter*(2*rand.Float64()-1)
+	return time.Duration(float64(interval) * factor)
+}
+
+// cachedPlan returns the cached active plan while it is fresh
+func (ps *pollSchedule) cachedPlan(now time.Time) (*RolloutPlan, bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	// Checks at a server-suggested interval always query, so a device told
+	// to poll every 30s sees phase changes within 30s
+	ttl := ps.cacheTTL
+	if interval := ps.serverInterval(); interval > 0 && interval/2 < ttl {
+		ttl = interval / 2
+	}
+
+	if ps.cached == nil || now.Sub(ps.cachedAt) > ttl {
+		return nil, false
+	}
+	return ps.cached, true
+}
+
+// active reports whether a rollout the device isn't done with is cached;
+// call with mutex held
+func (ps *pollSchedule) active() bool {
+	return ps.cached != nil && ps.cached.ID != ps.finishedID
+}
+
+// serverInterval returns the interval the fleet server suggests, the active
+// plan's before the device record's, or zero; call with mutex held
+func (ps *pollSchedule) serverInterval() time.Duration {
+	if ps.active() {
+		if interval := parsePollInterval(ps.cached.PollInterval); interval > 0 {
+			return interval
+		}
+	}
+	return ps.suggested
+}
+
+// allowQuery spends one query from the budget, returning false once the
+// window's budget is exhausted
+func (ps *pollSchedule) allowQuery(now time.Time) bool {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	if ps.budget <= 0 {
+		return true
+	}
+
+	if !now.Before(ps.windowEnd) {
+		ps.windowEnd = now.Add(ps.budgetWindow)
+		ps.windowBudget = ps.randomBudget()
+		ps.queries = 0
+	}
+
+	if ps.queries >= ps.windowBudget {
+		return false
+	}
+	ps.queries++
+	return true
+}
+
+// record caches the result of a rollout query; nil means no rollout targets the device
+func (ps *pollSchedule) record(plan *RolloutPlan, now time.Time) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.cached = plan
+	ps.cachedAt = now
+}
+
+// suggest records the interval the server suggested on the device record
+func (ps *pollSchedule) suggest(pollInterval string) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.suggested = parsePollInterval(pollInterval)
+}
+
+// setPushed records whether rollout notices arrive by long poll
+func (ps *pollSchedule) setPushed(pushed bool) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.pushed = pushed
+}
+
+// stale returns the cached plan regardless of age, for when the budget is spent
+func (ps *pollSchedule) stale() *RolloutPlan {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	return ps.cached
+}
+
+// finished marks a rollout the device is done with, so polling slows down
+// until another rollout targets it
+func (ps *pollSchedule) finished(rolloutID string) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	ps.finishedID = rolloutID
+}
+
+// randomBudget draws a window budget within a quarter of the configured budget
+func (ps *pollSchedule) randomBudget() int {
+	if ps.budget <= 0 {
+		return 0
+	}
+	spread := ps.budget / 4
+	return ps.budget - spread + rand.Intn(2*spread+1)
+}
+
+// findActiveRollout returns the rollout targeting this device, from the cache
+// when it is fresh and querying DynamoDB only within the query budget
+func (rm *RolloutManager) findActiveRollout() (*RolloutPlan, error) {
+	now := time.Now()
+
+	if plan, ok := rm.polls.cachedPlan(now); ok {
+		return plan, nil
+	}
+
+	if !rm.polls.allowQuery(now) {
+		log.Printf("Rollout query budget exhausted; using the last known rollout")
+		return rm.polls.stale(), nil
+	}
+
+	// Get device information; while the tables fail, degraded mode stands in
+	deviceInfo, err := rm.getDeviceInfo()
+	if err != nil {
+		if last, ok := rm.fallback(err); ok {
+			return last.Plan, nil
+		}
+		return nil, err
+	}
+
+	rm.polls.suggest(deviceInfo.PollInterval)
+	rm.advertiseCapabilities(deviceInfo)
+	rm.reportArchitecture(deviceInfo)
+	if rm.longPoll != nil {
+		rm.longPoll.setGroups(deviceInfo.DynamicGroups)
+	}
+
+	// The device's own desired state takes precedence over fleet rollouts.
+	// One the device failed isn't retried; setting it again gives it a new ID.
+	if desired := deviceInfo.Desired; desired != nil {
+		var plan *RolloutPlan
+		if deviceInfo.LastUpdateID != desired.ID || (deviceInfo.UpdateStatus != "failed" && deviceInfo.UpdateStatus != "rolled-back") {
+			plan = desired.Plan(rm.tenantID)
+		}
+		rm.polls.record(plan, now)
+		rm.saveFetched(plan, deviceInfo)
+		return plan, nil
+	}
+
+	// Check if there's an active rollout for this device
+	rollout, err := rm.getActiveRollout(deviceInfo)
+	if err != nil {
+		if last, ok := rm.fallback(err); ok {
+			return last.Plan, nil
+		}
+		return nil, err
+	}
+
+	rm.polls.record(rollout, now)
+	rm.saveFetched(rollout, deviceInfo)
+	return rollout, nil
+}
+
+// parsePollInterval parses a server-suggested interval, clamped so a bad
+// record can neither hammer the tables nor silence the device; empty or
+// invalid intervals are zero
+func parsePollInterval(pollInterval string) time.Duration {
+	if pollInterval == "" {
+		return 0
+	}
+	interval, err := time.ParseDuration(pollInterval)
+	if err != nil || interval <= 0 {
+		return 0
+	}
+	if interval < MinPollInterval {
+		return MinPollInterval
+	}
+	if interval > MaxPollInterval {
+		return MaxPollInterval
+	}
+	return interval
+}
+
+// queryAllPages reads every page of a rollout query, following
+// LastEvaluatedKey up to the page limit. DynamoDB returns at most 1MB per
+// page, so in-progress rollouts with large plans span several pages; reading
+// only the first would hide the rest from the device.
+func (rm *RolloutManager) queryAllPages(input *dynamodb.QueryInput) ([]map[string]types.AttributeValue, error) {
+	maxPages := rm.polls.pageLimit()
+	items := make([]map[string]types.AttributeValue, 0)
+
+	for page := 0; page < maxPages; page++ {
+		result, err := rm.dynamoClient.Query(context.Background(), input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to query active rollouts (page %d): %w", page+1, err)
+		}
+		rm.usage.addCapacity(result.ConsumedCapacity)
+		items = append(items, result.Items...)
+
+		if len(result.LastEvaluatedKey) == 0 {
+			return items, nil
+		}
+		input.ExclusiveStartKey = result.LastEvaluatedKey
+	}
+
+	rm.logger.Printf("Rollout query stopped at the limit of %d pages; rollouts on later pages are ignored until MaxQueryPages is raised", maxPages)
+	return items, nil
+}
+
+// CheckNow discards the cached plan and checks for updates immediately, e.g.
+// when a LAN broker announces a rollout change
+func (rm *RolloutManager) CheckNow() {
+	rm.polls.record(nil, time.Time{})
+	rm.checkTimer.Reset(0)
+}
+
+// SetPolling applies new polling settings from config while running; only
+// the interval, jitter, cache and budget fields are used. A check in
+// progress finishes, and the next one is scheduled with the new settings.
+func (rm *RolloutManager) SetPolling(config RolloutConfig) {
+	if config.CheckInterval <= 0 {
+		config.CheckInterval = rm.checkInterval
+	}
+	rm.polls.reconfigure(config)
+	rm.checkInterval = config.CheckInterval
+
+	// Reschedule a waiting check; a running one reschedules itself when done
+	if rm.checkTimer.Stop() {
+		rm.checkTimer.Reset(rm.polls.next())
+	}
+}
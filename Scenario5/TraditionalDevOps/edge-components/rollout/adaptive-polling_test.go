@@ -0,0 +1,118 @@
+package rollout_test
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"reflect"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/testkit"
+)
+
+// recordingDynamoDB records the start key of each Query and the key it returned
+type recordingDynamoDB struct {
+	*testkit.FakeDynamoDB
+	startKeys []map[string]types.AttributeValue
+	lastKeys  []map[string]types.AttributeValue
+}
+
+func (r *recordingDynamoDB) Query(ctx context.Context, input *dynamodb.QueryInput, optFns ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	r.startKeys = append(r.startKeys, input.ExclusiveStartKey)
+	output, err := r.FakeDynamoDB.Query(ctx, input, optFns...)
+	if err == nil {
+		r.lastKeys = append(r.lastKeys, output.LastEvaluatedKey)
+	}
+	return output, err
+}
+
+func TestQueryAllPagesFollowsLastEvaluatedKey(t *testing.T) {
+	const rollouts = 5
+
+	tests := []struct {
+		name      string
+		pageSize  int
+		maxPages  int
+		wantItems int
+		wantPages int
+	}{
+		{name: "single page", pageSize: 0, wantItems: 5, wantPages: 1},
+		{name: "one item per page", pageSize: 1, wantItems: 5, wantPages: 5},
+		{name: "partial last page", pageSize: 2, wantItems: 5, wantPages: 3},
+		{name: "page limit", pageSize: 2, maxPages: 2, wantItems: 4, wantPages: 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			fake := testkit.NewFleetDynamoDB()
+			for i := 0; i < rollouts; i++ {
+				plan := testkit.NewRolloutPlan(fmt.Sprintf("r-%d", i), "2.0.0").Phase(100, false)
+				if _, err := plan.Put(ctx, fake, testkit.RolloutTable); err != nil {
+					t.Fatalf("Put: %v", err)
+				}
+			}
+			fake.SetPageSize(tt.pageSize)
+
+			client := &recordingDynamoDB{FakeDynamoDB: fake}
+			laptop := testkit.NewLaptop(t.TempDir())
+			config := laptop.RolloutConfig("device-1")
+			config.DynamoClient = client
+			config.MaxQueryPages = tt.maxPages
+
+			rm, err := rollout.NewManager(
+				rollout.WithConfig(config),
+				rollout.WithLogger(log.New(io.Discard, "", 0)),
+			)
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			defer rm.Close()
+
+			// Only count the query under test
+			client.startKeys, client.lastKeys = nil, nil
+
+			items, err := rm.QueryAllPages(&dynamodb.QueryInput{
+				TableName:              aws.String(testkit.RolloutTable),
+				IndexName:              aws.String("StatusIndex"),
+				KeyConditionExpression: aws.String("Status = :status"),
+				ExpressionAttributeValues: map[string]types.AttributeValue{
+					":status": &types.AttributeValueMemberS{Value: "in-progress"},
+				},
+			})
+			if err != nil {
+				t.Fatalf("QueryAllPages: %v", err)
+			}
+
+			if len(items) != tt.wantItems {
+				t.Errorf("got %d items, want %d", len(items), tt.wantItems)
+			}
+			seen := make(map[string]bool)
+			for _, item := range items {
+				id := item["ID"].(*types.AttributeValueMemberS).Value
+				if seen[id] {
+					t.Errorf("rollout %s returned twice", id)
+				}
+				seen[id] = true
+			}
+
+			if len(client.startKeys) != tt.wantPages {
+				t.Fatalf("got %d queries, want %d", len(client.startKeys), tt.wantPages)
+			}
+			if client.startKeys[0] != nil {
+				t.Errorf("first query started at %v, want no start key", client.startKeys[0])
+			}
+			for page := 1; page < len(client.startKeys); page++ {
+				if !reflect.DeepEqual(client.startKeys[page], client.lastKeys[page-1]) {
+					t.Errorf("query %d started at %v, want the previous LastEvaluatedKey %v", page+1, client.startKeys[page], client.lastKeys[page-1])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,233 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// BundleArtifact is one package of a multi-artifact rollout, e.g. the app,
+// its config or an ML model, routed to the handlers registered for its Kind
+type BundleArtifact struct {
+	Kind         string `json:"kind" dynamodbav:"Kind"`
+	PackageURL   string `json:"packageUrl,omitempty" dynamodbav:"PackageURL,omitempty"`
+	PackageHash  string `json:"packageHash,omitempty" dynamodbav:"PackageHash,omitempty"`
+	ArtifactName string `json:"artifactName,omitempty" dynamodbav:"ArtifactName,omitempty"` // registry artifact, resolved at Version
+	Version      string `json:"version,omitempty" dynamodbav:"Version,omitempty"`           // defaults to the plan's Version
+
+	// DependsOn lists the kinds that must be applied before this artifact;
+	// otherwise artifacts are applied in the order listed
+	DependsOn []string `json:"dependsOn,omitempty" dynamodbav:"DependsOn,omitempty"`
+
+	// PackageFormat and SchemaVersion are what a device's handler for Kind
+	// must support; devices advertising less skip the rollout
+	PackageFormat string `json:"packageFormat,omitempty" dynamodbav:"PackageFormat,omitempty"`
+	SchemaVersion int    `json:"schemaVersion,omitempty" dynamodbav:"SchemaVersion,omitempty"`
+}
+
+// IsBundle reports whether the plan delivers several artifacts as one update
+func (p RolloutPlan) IsBundle() bool {
+	return len(p.Artifacts) > 0
+}
+
+// ArtifactVersion returns the version an artifact of the plan is installed at
+func (p RolloutPlan) ArtifactVersion(artifact BundleArtifact) string {
+	if artifact.Version != "" {
+		return artifact.Version
+	}
+	return p.Version
+}
+
+// OrderArtifacts returns a bundle's artifacts in install order: each after
+// the kinds it depends on, and otherwise in the order listed. It fails on
+// unknown dependencies and dependency cycles.
+func OrderArtifacts(artifacts []BundleArtifact) ([]BundleArtifact, error) {
+	kinds := make(map[string]bool, len(artifacts))
+	for _, artifact := range artifacts {
+		kinds[artifact.Kind] = true
+	}
+	for _, artifact := range artifacts {
+		for _, dependency := range artifact.DependsOn {
+			if !kinds[dependency] {
+				return nil, fmt.Errorf("%s artifact depends on %s, which the bundle doesn't contain", artifact.Kind, dependency)
+			}
+		}
+	}
+
+	ordered := make([]BundleArtifact, 0, len(artifacts))
+	placed := make(map[string]bool, len(artifacts))
+	for len(ordered) < len(artifacts) {
+		progressed := false
+		for _, artifact := range artifacts {
+			if placed[artifact.Kind] || !allPlaced(artifact.DependsOn, placed) {
+				continue
+			}
+			ordered = append(ordered, artifact)
+			placed[artifact.Kind] = true
+			progressed = true
+			break
+		}
+		if !progressed {
+			return nil, fmt.Errorf("bundle artifacts have a dependency cycle")
+		}
+	}
+
+	return ordered, nil
+}
+
+// RegisterBundleHandler registers a handler for the artifacts of kind in
+// bundle rollouts; plain package rollouts keep using RegisterUpdateHandler
+func (rm *RolloutManager) RegisterBundleHandler(kind string, handler UpdateHandler) {
+	rm.bundleHandlers[kind] = append(rm.bundleHandlers[kind], handler)
+}
+
+// stagedArtifact is a downloaded and validated bundle artifact
+type stagedArtifact struct {
+	kind        string
+	packageURL  string
+	packagePath string
+	version     string
+	handlers    []UpdateHandler
+}
+
+// applyBundle applies every artifact of a bundle as one update, in
+// dependency order: all of them are downloaded and validated before any is
+// applied, and a failure or an abort part-way leaves the device to roll back
+// as for a single package. Artifacts depending on one that failed are not applied.
+func (rm *RolloutManager) applyBundle(ctx context.Context, rollout *RolloutPlan) error {
+	if rm.verifier != nil {
+		if err := rm.verifyPlan(rollout); err != nil {
+			return err
+		}
+	}
+
+	ordered, err := OrderArtifacts(rollout.Artifacts)
+	if err != nil {
+		return err
+	}
+
+	staged := make([]stagedArtifact, 0, len(ordered))
+	packagePaths := make([]string, 0, len(ordered))
+	for _, artifact := range ordered {
+		handlers := rm.bundleHandlers[artifact.Kind]
+		if len(handlers) == 0 {
+			return fmt.Errorf("no update handler registered for %s artifacts", artifact.Kind)
+		}
+
+		version := rollout.ArtifactVersion(artifact)
+		packageURL, packageHash := artifact.PackageURL, artifact.PackageHash
+		if artifact.ArtifactName != "" {
+			var err error
+			packageURL, packageHash, err = rm.resolveArtifact(ctx, artifact.ArtifactName, version)
+			if err != nil {
+				return err
+			}
+		}
+
+		// Packages are staged under their file name, which must not collide
+		for _, s := range staged {
+			if filepath.Base(s.packageURL) == filepath.Base(packageURL) {
+				return fmt.Errorf("%s and %s packages are both named %s", s.kind, artifact.Kind, filepath.Base(packageURL))
+			}
+		}
+
+		packagePath, err := rm.downloadUpdatePackage(ctx, packageURL, packageHash)
+		if err != nil {
+			return fmt.Errorf("failed to download %s package: %w", artifact.Kind, err)
+		}
+		packagePaths = append(packagePaths, packagePath)
+
+		for _, handler := range handlers {
+			if err := handler.ValidateUpdate(packagePath); err != nil {
+				return fmt.Errorf("%s update validation failed: %w", artifact.Kind, err)
+			}
+		}
+
+		staged = append(staged, stagedArtifact{artifact.Kind, packageURL, packagePath, version, handlers})
+	}
+
+	applied := make([]UpdateHandler, 0)
+	for _, s := range staged {
+		for _, handler := range s.handlers {
+			if err := aborted(ctx); err != nil {
+				return abortHandlers(applied, err, packagePaths...)
+			}
+
+			if err := handler.HandleUpdate(s.packagePath, s.version); err != nil {
+				if skipped := dependents(ordered, s.kind); len(skipped) > 0 {
+					return fmt.Errorf("%s update application failed, so %s were not applied: %w", s.kind, strings.Join(skipped, ", "), err)
+				}
+				return fmt.Errorf("%s update application failed: %w", s.kind, err)
+			}
+			applied = append(applied, handler)
+		}
+	}
+
+	if err := rm.checkRolloutHealth(rollout); err != nil {
+		return err
+	}
+
+	if err := aborted(ctx); err != nil {
+		return abortHandlers(applied, err, packagePaths...)
+	}
+
+	return nil
+}
+
+// rollbackBundle rolls back the handlers of every kind in a bundle, the last
+// applied first
+func (rm *RolloutManager) rollbackBundle(kinds []string) error {
+	for i := len(kinds) - 1; i >= 0; i-- {
+		handlers := rm.bundleHandlers[kinds[i]]
+		for j := len(handlers) - 1; j >= 0; j-- {
+			if err := handlers[j].RollbackUpdate(); err != nil {
+				return fmt.Errorf("%s rollback failed: %w", kinds[i], err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// bundleKinds lists the artifact kinds of a bundle in apply order
+func bundleKinds(artifacts []BundleArtifact) []string {
+	if ordered, err := OrderArtifacts(artifacts); err == nil {
+		artifacts = ordered
+	}
+
+	kinds := make([]string, 0, len(artifacts))
+	for _, artifact := range artifacts {
+		kinds = append(kinds, artifact.Kind)
+	}
+	return kinds
+}
+
+// dependents returns the kinds that depend on kind, directly or through
+// other artifacts, in install order
+func dependents(ordered []BundleArtifact, kind string) []string {
+	affected := map[string]bool{kind: true}
+	var kinds []string
+	for _, artifact := range ordered {
+		for _, dependency := range artifact.DependsOn {
+			if affected[dependency] {
+				affected[artifact.Kind] = true
+				kinds = append(kinds, artifact.Kind)
+				break
+			}
+		}
+	}
+	return kinds
+}
+
+// allPlaced reports whether every kind is placed
+func allPlaced(kinds []string, placed map[string]bool) bool {
+	for _, kind := range kinds {
+		if !placed[kind] {
+			return false
+		}
+	}
+	return true
+}
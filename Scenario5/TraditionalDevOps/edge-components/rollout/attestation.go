@@ -0,0 +1,99 @@
+package rollout
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/attestation"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// attestationExpression sets the device record attestation attributes from attestationValues
+const attestationExpression = "Attestation = :attestation, AttestationError = :attestationError"
+
+// Attestor quotes the device's boot measurements;
+// *attestation.TPMAttestor satisfies it
+type Attestor interface {
+	// Attest returns a quote whose nonce is the claim's
+	Attest(claim attestation.Claim) (*attestation.Quote, error)
+}
+
+// AttestationConfig contains configuration for hardware attestation
+type AttestationConfig struct {
+	Attestor Attestor
+
+	// Required only applies updates after the device attests successfully
+	// against ExpectedPCRs
+	Required bool
+
+	// ExpectedPCRs maps a PCR index to the hex SHA-256 value of the approved
+	// boot chain; the fleet server checks its own golden values
+	ExpectedPCRs map[int]string
+}
+
+// attestUpdate quotes the device's PCRs before an update is applied when
+// attestation is required, returning ErrAttestationFailed when the TPM can't
+// quote or the PCRs differ from the expected values
+func (rm *RolloutManager) attestUpdate(rollout *RolloutPlan) error {
+	return attestBeforeApply(rm.attestation, attestation.Claim{
+		DeviceID:  tenant.Key(rm.tenantID, rm.deviceID),
+		RolloutID: rollout.ID,
+		Status:    "applying",
+	})
+}
+
+// attestationValues quotes the device's PCRs for a status report as device
+// record values; a failed quote is reported instead of failing the report
+func (rm *RolloutManager) attestationValues(rolloutID, status string, sequence int64) map[string]types.AttributeValue {
+	encoded, message := quoteStatus(rm.attestation.Attestor, attestation.Claim{
+		DeviceID:  tenant.Key(rm.tenantID, rm.deviceID),
+		RolloutID: rolloutID,
+		Status:    status,
+		Sequence:  sequence,
+	})
+	if message != "" {
+		rm.logger.Printf("Failed to attest status %s for rollout %s: %s", status, rolloutID, message)
+	}
+
+	return map[string]types.AttributeValue{
+		":attestation":      &types.AttributeValueMemberS{Value: encoded},
+		":attestationError": &types.AttributeValueMemberS{Value: message},
+	}
+}
+
+// Helper functions
+
+// attestBeforeApply quotes the claim and checks the quote against the
+// expected PCRs when config requires attestation
+func attestBeforeApply(config *AttestationConfig, claim attestation.Claim) error {
+	if config == nil || !config.Required {
+		return nil
+	}
+
+	quote, err := config.Attestor.Attest(claim)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrAttestationFailed, err)
+	}
+	if err := attestation.Verify(quote, nil, config.ExpectedPCRs); err != nil {
+		return fmt.Errorf("%w: %v", ErrAttestationFailed, err)
+	}
+
+	return nil
+}
+
+// quoteStatus returns the JSON quote binding a status report, or why the
+// device couldn't quote it
+func quoteStatus(attestor Attestor, claim attestation.Claim) (string, string) {
+	quote, err := attestor.Attest(claim)
+	if err != nil {
+		return "", err.Error()
+	}
+
+	data, err := json.Marshal(quote)
+	if err != nil {
+		return "", err.Error()
+	}
+	return string(data), ""
+}
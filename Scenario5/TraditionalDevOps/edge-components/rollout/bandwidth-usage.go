@@ -0,0 +1,77 @@
+package rollout
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// bandwidthReportInterval is how often the check loop reports the meter
+// while no update status carries it
+const bandwidthReportInterval = time.Hour
+
+// bandwidthExpression sets the device record bandwidth attributes from bandwidthValues
+const bandwidthExpression = "BandwidthPeriodStart = :bwPeriod, BandwidthUploaded = :bwUp, BandwidthDownloaded = :bwDown, BandwidthRolloutBytes = :bwRollout, BandwidthSyncBytes = :bwSync, BandwidthCap = :bwCap, BandwidthCapped = :bwCapped"
+
+// meterDownload counts package bytes against the billing period
+func (rm *RolloutManager) meterDownload(written int64) {
+	if rm.meter != nil {
+		rm.meter.Add(bandwidth.CategoryRollout, 0, written)
+	}
+}
+
+// checkBandwidthCap defers a rollout once the device's cap is used up;
+// critical rollouts and config-only rollouts, which download nothing, proceed
+func (rm *RolloutManager) checkBandwidthCap(rollout *RolloutPlan) error {
+	if rm.meter == nil || rollout.IsConfigOnly() {
+		return nil
+	}
+	return rm.meter.Allow(rollout.Critical)
+}
+
+// bandwidthValues returns the meter's usage as device record values
+func (rm *RolloutManager) bandwidthValues() map[string]types.AttributeValue {
+	usage := rm.meter.Usage()
+	rolloutCounter := usage.Categories[bandwidth.CategoryRollout]
+	syncCounter := usage.Categories[bandwidth.CategorySync]
+
+	return map[string]types.AttributeValue{
+		":bwPeriod":  &types.AttributeValueMemberS{Value: usage.PeriodStart.UTC().Format(time.RFC3339)},
+		":bwUp":      &types.AttributeValueMemberN{Value: strconv.FormatInt(usage.Uploaded, 10)},
+		":bwDown":    &types.AttributeValueMemberN{Value: strconv.FormatInt(usage.Downloaded, 10)},
+		":bwRollout": &types.AttributeValueMemberN{Value: strconv.FormatInt(rolloutCounter.Uploaded+rolloutCounter.Downloaded, 10)},
+		":bwSync":    &types.AttributeValueMemberN{Value: strconv.FormatInt(syncCounter.Uploaded+syncCounter.Downloaded, 10)},
+		":bwCap":     &types.AttributeValueMemberN{Value: strconv.FormatInt(usage.Cap, 10)},
+		":bwCapped":  &types.AttributeValueMemberBOOL{Value: usage.Capped},
+	}
+}
+
+// reportBandwidth records the meter on the device record at most once per
+// bandwidthReportInterval, so a capped device that defers every update still
+// reports its usage
+func (rm *RolloutManager) reportBandwidth() {
+	if rm.meter == nil || rm.clock.Now().Sub(rm.bandwidthReported) < bandwidthReportInterval {
+		return
+	}
+
+	_, err := rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+		},
+		UpdateExpression:          aws.String("SET " + bandwidthExpression),
+		ExpressionAttributeValues: rm.bandwidthValues(),
+	})
+	if err != nil {
+		rm.logger.Printf("Failed to report bandwidth usage: %v", err)
+		return
+	}
+	rm.bandwidthReported = rm.clock.Now()
+}
@@ -0,0 +1,81 @@
+package rollout_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log"
+	"testing"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/testkit"
+)
+
+func TestBandwidthCapDefersAllButCriticalRollouts(t *testing.T) {
+	tests := []struct {
+		name     string
+		critical bool
+		want     error
+	}{
+		{name: "regular", critical: false, want: rollout.ErrBandwidthCap},
+		{name: "critical", critical: true, want: nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			laptop := testkit.NewLaptop(t.TempDir())
+
+			if err := laptop.AddDevice(ctx, testkit.NewDevice("device-1").Version("1.0.0")); err != nil {
+				t.Fatalf("AddDevice: %v", err)
+			}
+
+			plan := testkit.NewRolloutPlan("r-1", "2.0.0").Phase(100, false)
+			if tt.critical {
+				plan.Critical()
+			}
+			if _, err := plan.Put(ctx, laptop.Dynamo, testkit.RolloutTable); err != nil {
+				t.Fatalf("Put: %v", err)
+			}
+
+			// Use up the cap before the check
+			meter, err := bandwidth.NewMeter(bandwidth.MeterConfig{Cap: 1024})
+			if err != nil {
+				t.Fatalf("NewMeter: %v", err)
+			}
+			meter.Add(bandwidth.CategoryRollout, 0, 1024)
+
+			rm, err := rollout.NewManager(
+				rollout.WithConfig(laptop.RolloutConfig("device-1")),
+				rollout.WithMeter(meter),
+				rollout.WithLogger(log.New(io.Discard, "", 0)),
+			)
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			defer rm.Close()
+
+			// The plan goes through the device's own read of the rollout table,
+			// so a Critical flag lost there would fail the critical case
+			active, err := rm.ActiveRollout(ctx)
+			if err != nil {
+				t.Fatalf("ActiveRollout: %v", err)
+			}
+			if active == nil {
+				t.Fatal("ActiveRollout found no rollout")
+			}
+			if active.Critical != tt.critical {
+				t.Errorf("Critical = %v, want %v", active.Critical, tt.critical)
+			}
+
+			err = rm.CheckEligibility(active)
+			if tt.want == nil && err != nil {
+				t.Errorf("CheckEligibility = %v, want nil", err)
+			}
+			if tt.want != nil && !errors.Is(err, tt.want) {
+				t.Errorf("CheckEligibility = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
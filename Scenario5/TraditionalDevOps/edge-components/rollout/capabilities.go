@@ -0,0 +1,162 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Handler types of plain package and config-only rollouts; bundle artifacts
+// are handled by their Kind
+const (
+	HandlerPackage = "package"
+	HandlerConfig  = "config"
+)
+
+// Capabilities is what a device's agent can install, advertised in its
+// device record so rollouts skip devices that couldn't process them
+type Capabilities struct {
+	// Handlers are the handler types the agent has registered: "package",
+	// "config", and the bundle artifact kinds
+	Handlers []string `json:"handlers" dynamodbav:"Handlers"`
+
+	// PackageFormats are the package formats its handlers unpack, e.g.
+	// "tar.gz" or "deb"; empty accepts any format
+	PackageFormats []string `json:"packageFormats,omitempty" dynamodbav:"PackageFormats,omitempty"`
+
+	// SchemaVersions is the newest payload schema each handler type reads
+	SchemaVersions map[string]int `json:"schemaVersions,omitempty" dynamodbav:"SchemaVersions,omitempty"`
+}
+
+// Requirement is what installing one part of a rollout takes
+type Requirement struct {
+	Handler       string
+	PackageFormat string // empty when the rollout doesn't say
+	SchemaVersion int    // zero when the rollout doesn't say
+}
+
+// Requirements lists what the rollout needs from a device: one entry per
+// bundle artifact, or one for its package or config payload
+func (p RolloutPlan) Requirements() []Requirement {
+	if p.IsBundle() {
+		requirements := make([]Requirement, 0, len(p.Artifacts))
+		for _, artifact := range p.Artifacts {
+			requirements = append(requirements, Requirement{
+				Handler:       artifact.Kind,
+				PackageFormat: artifact.PackageFormat,
+				SchemaVersion: artifact.SchemaVersion,
+			})
+		}
+		return requirements
+	}
+
+	if p.IsConfigOnly() {
+		return []Requirement{{Handler: HandlerConfig, SchemaVersion: p.SchemaVersion}}
+	}
+	return []Requirement{{Handler: HandlerPackage, PackageFormat: p.PackageFormat, SchemaVersion: p.SchemaVersion}}
+}
+
+// Check returns ErrIncompatible, naming the first unmet requirement, when the
+// device can't install the rollout. Devices that never advertised
+// capabilities, such as older agents, are assumed to handle everything.
+func (c *Capabilities) Check(plan RolloutPlan) error {
+	if c == nil {
+		return nil
+	}
+
+	for _, requirement := range plan.Requirements() {
+		if !contains(c.Handlers, requirement.Handler) {
+			return fmt.Errorf("%w: no %s handler", ErrIncompatible, requirement.Handler)
+		}
+		if requirement.PackageFormat != "" && len(c.PackageFormats) > 0 && !contains(c.PackageFormats, requirement.PackageFormat) {
+			return fmt.Errorf("%w: %s packages not supported", ErrIncompatible, requirement.PackageFormat)
+		}
+		if requirement.SchemaVersion > 0 && c.SchemaVersions[requirement.Handler] < requirement.SchemaVersion {
+			return fmt.Errorf("%w: %s schema version %d is newer than %d", ErrIncompatible, requirement.Handler, requirement.SchemaVersion, c.SchemaVersions[requirement.Handler])
+		}
+	}
+
+	return nil
+}
+
+// capabilities returns what this device can install: the handler types
+// registered so far, with the formats and schema versions from WithCapabilities
+func (rm *RolloutManager) capabilities() *Capabilities {
+	capabilities := &Capabilities{Handlers: make([]string, 0)}
+	if rm.declared != nil {
+		capabilities.PackageFormats = rm.declared.PackageFormats
+		capabilities.SchemaVersions = rm.declared.SchemaVersions
+	}
+
+	if len(rm.updateHandlers) > 0 {
+		capabilities.Handlers = append(capabilities.Handlers, HandlerPackage)
+	}
+	if len(rm.configAppliers) > 0 {
+		capabilities.Handlers = append(capabilities.Handlers, HandlerConfig)
+	}
+	for kind := range rm.bundleHandlers {
+		capabilities.Handlers = append(capabilities.Handlers, kind)
+	}
+	sort.Strings(capabilities.Handlers)
+
+	return capabilities
+}
+
+// checkCapabilities returns ErrIncompatible when a registered handler or a
+// declared format or schema version is missing for the rollout
+func (rm *RolloutManager) checkCapabilities(rollout *RolloutPlan) error {
+	if rm.declared == nil {
+		return nil
+	}
+	if err := rm.capabilities().Check(*rollout); err != nil {
+		return fmt.Errorf("rollout %s: %w", rollout.ID, err)
+	}
+	return nil
+}
+
+// advertiseCapabilities writes the device's capabilities to its record when
+// they differ from what the record holds
+func (rm *RolloutManager) advertiseCapabilities(deviceInfo *DeviceInfo) {
+	if rm.declared == nil {
+		return
+	}
+
+	capabilities := rm.capabilities()
+	if reflect.DeepEqual(capabilities, deviceInfo.Capabilities) {
+		return
+	}
+
+	item, err := attributevalue.Marshal(capabilities)
+	if err != nil {
+		rm.logger.Printf("Failed to marshal capabilities: %v", err)
+		return
+	}
+
+	result, err := rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+		},
+		UpdateExpression:    aws.String("SET Capabilities = :capabilities"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":capabilities": item,
+		},
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		rm.logger.Printf("Failed to advertise capabilities: %v", err)
+		return
+	}
+	rm.usage.addCapacity(result.ConsumedCapacity)
+
+	rm.logger.Printf("Advertised handlers %v", capabilities.Handlers)
+}
@@ -0,0 +1,317 @@
+package rollout
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Seconds between the NTP epoch (1900) and the Unix epoch
+const ntpEpochOffset = 2208988800
+
+// Clock drift levels
+const (
+	DriftOK       = "ok"
+	DriftWarning  = "warning"
+	DriftCritical = "critical"
+)
+
+// ClockMonitorConfig contains configuration for the ClockMonitor
+type ClockMonitorConfig struct {
+	URL       string // HTTPS endpoint whose Date header is trusted; defaults to the S3 endpoint of Region
+	Region    string
+	NTPServer string // host[:port] probed with SNTP instead of URL when set, e.g. pool.ntp.org
+
+	WarnThreshold     time.Duration // defaults to 30 seconds
+	CriticalThreshold time.Duration // defaults to 4 minutes, inside the 5 minutes of skew AWS request signing allows
+	Interval          time.Duration // defaults to 1 hour
+}
+
+// ClockDrift is one comparison of the local clock against server time
+type ClockDrift struct {
+	Drift       time.Duration `json:"drift"`       // local minus server time; positive when the local clock is ahead
+	Uncertainty time.Duration `json:"uncertainty"` // half the round trip, plus the Date header's one second resolution
+	Source      string        `json:"source"`      // the URL or NTP server
+	Level       string        `json:"level"`
+	CheckedAt   time.Time     `json:"checkedAt"`
+}
+
+// ClockMonitor periodically compares the device clock against server time
+// and records the drift on the device record. Maintenance windows, phase
+// expiry, confirmation deadlines and sync conflict resolution all use the
+// local clock, and AWS rejects signed requests from clocks more than five
+// minutes off, so the monitor warns well before that.
+type ClockMonitor struct {
+	rm         *RolloutManager
+	url        string
+	ntpServer  string
+	warn       time.Duration
+	critical   time.Duration
+	httpClient *http.Client
+	last       *ClockDrift
+	mutex      sync.RWMutex
+	interval   time.Duration
+	timer      *time.Timer
+}
+
+// StartClockMonitor starts checking the device clock, first right away
+func (rm *RolloutManager) StartClockMonitor(config ClockMonitorConfig) *ClockMonitor {
+	if config.URL == "" {
+		config.URL = "https://s3.amazonaws.com"
+		if config.Region != "" {
+			config.URL = fmt.Sprintf("https://s3.%s.amazonaws.com", config.Region)
+		}
+	}
+	if config.WarnThreshold == 0 {
+		config.WarnThreshold = 30 * time.Second
+	}
+	if config.CriticalThreshold == 0 {
+		config.CriticalThreshold = 4 * time.Minute
+	}
+	if config.Interval == 0 {
+		config.Interval = time.Hour
+	}
+
+	cm := &ClockMonitor{
+		rm:        rm,
+		url:       config.URL,
+		ntpServer: config.NTPServer,
+		warn:      config.WarnThreshold,
+		critical:  config.CriticalThreshold,
+		interval:  config.Interval,
+		httpClient: &http.Client{
+			Timeout: 10 * time.Second,
+			CheckRedirect: func(*http.Request, []*http.Request) error {
+				// Any response carries the Date header
+				return http.ErrUseLastResponse
+			},
+		},
+	}
+
+	// Start the check timer
+	cm.timer = time.AfterFunc(0, cm.checkLoop)
+
+	return cm
+}
+
+// checkLoop checks the clock and reschedules itself
+func (cm *ClockMonitor) checkLoop() {
+	defer func() {
+		// Reschedule the check
+		cm.timer.Reset(cm.interval)
+	}()
+
+	if _, err := cm.Check(context.Background()); err != nil {
+		cm.rm.logger.Printf("Failed to check clock drift: %v", err)
+	}
+}
+
+// Check measures the drift now, warns when it crosses a threshold and
+// records it on the device record
+func (cm *ClockMonitor) Check(ctx context.Context) (*ClockDrift, error) {
+	var drift *ClockDrift
+	var err error
+	if cm.ntpServer != "" {
+		drift, err = cm.measureNTP(ctx)
+	} else {
+		drift, err = cm.measureHTTP(ctx)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	magnitude := drift.Drift
+	if magnitude < 0 {
+		magnitude = -magnitude
+	}
+	switch {
+	case magnitude >= cm.critical:
+		drift.Level = DriftCritical
+	case magnitude >= cm.warn:
+		drift.Level = DriftWarning
+	default:
+		drift.Level = DriftOK
+	}
+
+	cm.mutex.Lock()
+	previous := cm.last
+	cm.last = drift
+	cm.mutex.Unlock()
+
+	// Warn on every critical check, otherwise only when the level changes
+	switch {
+	case drift.Level == DriftCritical:
+		cm.rm.logger.Printf("Clock is off by %s (critical above %s); time-based sync, windows and AWS requests will fail until it is corrected", drift.Drift.Round(time.Millisecond), cm.critical)
+	case drift.Level == DriftWarning && (previous == nil || previous.Level != DriftWarning):
+		cm.rm.logger.Printf("Clock is off by %s (warning above %s)", drift.Drift.Round(time.Millisecond), cm.warn)
+	case drift.Level == DriftOK && previous != nil && previous.Level != DriftOK:
+		cm.rm.logger.Printf("Clock drift back within %s", cm.warn)
+	}
+
+	if err := cm.report(ctx, drift); err != nil {
+		return drift, err
+	}
+
+	return drift, nil
+}
+
+// Drift returns the last measurement, or false before the first one
+func (cm *ClockMonitor) Drift() (ClockDrift, bool) {
+	cm.mutex.RLock()
+	defer cm.mutex.RUnlock()
+
+	if cm.last == nil {
+		return ClockDrift{}, false
+	}
+	return *cm.last, true
+}
+
+// CheckClock is a readiness check failing while the drift is critical
+func (cm *ClockMonitor) CheckClock() ProbeCheck {
+	return func(ctx context.Context) error {
+		drift, ok := cm.Drift()
+		if ok && drift.Level == DriftCritical {
+			return fmt.Errorf("clock is off by %s", drift.Drift.Round(time.Millisecond))
+		}
+		return nil
+	}
+}
+
+// Close stops the monitor
+func (cm *ClockMonitor) Close() {
+	if cm.timer != nil {
+		cm.timer.Stop()
+	}
+}
+
+// measureHTTP compares the local clock against a server's Date header
+func (cm *ClockMonitor) measureHTTP(ctx context.Context) (*ClockDrift, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, cm.url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	sent := cm.rm.clock.Now()
+	resp, err := cm.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", cm.url, err)
+	}
+	received := cm.rm.clock.Now()
+	resp.Body.Close()
+
+	serverTime, err := http.ParseTime(resp.Header.Get("Date"))
+	if err != nil {
+		return nil, fmt.Errorf("no usable Date header from %s: %w", cm.url, err)
+	}
+
+	// The Date header truncates to the second, so the server time is half a
+	// second later on average; assume it was read halfway through the round trip
+	roundTrip := received.Sub(sent)
+	local := sent.Add(roundTrip / 2)
+	server := serverTime.Add(500 * time.Millisecond)
+
+	return &ClockDrift{
+		Drift:       local.Sub(server),
+		Uncertainty: roundTrip/2 + 500*time.Millisecond,
+		Source:      cm.url,
+		CheckedAt:   local.UTC(),
+	}, nil
+}
+
+// measureNTP compares the local clock against an NTP server using SNTP
+func (cm *ClockMonitor) measureNTP(ctx context.Context) (*ClockDrift, error) {
+	server := cm.ntpServer
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(server, "123")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach %s: %w", server, err)
+	}
+	defer conn.Close()
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	// Version 4, client mode
+	request := make([]byte, 48)
+	request[0] = 0x23
+
+	sent := cm.rm.clock.Now()
+	if _, err := conn.Write(request); err != nil {
+		return nil, fmt.Errorf("failed to query %s: %w", server, err)
+	}
+
+	response := make([]byte, 48)
+	n, err := conn.Read(response)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read from %s: %w", server, err)
+	}
+	received := cm.rm.clock.Now()
+
+	if n < 48 || response[0]&0x07 != 4 {
+		return nil, fmt.Errorf("invalid NTP response from %s", server)
+	}
+	if response[1] == 0 {
+		return nil, fmt.Errorf("NTP server %s refused the query", server)
+	}
+
+	serverReceived := ntpTime(response[32:40])
+	serverSent := ntpTime(response[40:48])
+
+	// Standard NTP offset and delay; drift is the negated offset
+	offset := (serverReceived.Sub(sent) + serverSent.Sub(received)) / 2
+	delay := received.Sub(sent) - serverSent.Sub(serverReceived)
+
+	return &ClockDrift{
+		Drift:       -offset,
+		Uncertainty: delay / 2,
+		Source:      "ntp://" + server,
+		CheckedAt:   received.UTC(),
+	}, nil
+}
+
+// report records the drift on the device record
+func (cm *ClockMonitor) report(ctx context.Context, drift *ClockDrift) error {
+	_, err := cm.rm.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(cm.rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(cm.rm.tenantID, cm.rm.deviceID)},
+		},
+		UpdateExpression: aws.String("SET ClockDriftMs = :drift, ClockCheckedAt = :time"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":drift": &types.AttributeValueMemberN{Value: strconv.FormatInt(drift.Drift.Milliseconds(), 10)},
+			":time":  &types.AttributeValueMemberS{Value: drift.CheckedAt.Format(time.RFC3339)},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to report clock drift: %w", err)
+	}
+	return nil
+}
+
+// Helper functions
+
+// ntpTime converts a 64-bit NTP timestamp to a time
+func ntpTime(b []byte) time.Time {
+	seconds := int64(binary.BigEndian.Uint32(b[0:4]))
+	fraction := int64(binary.BigEndian.Uint32(b[4:8]))
+	return time.Unix(seconds-ntpEpochOffset, (fraction*1e9)>>32)
+}
@@ -0,0 +1,53 @@
+package rollout
+
+import (
+	"hash/fnv"
+	"sort"
+)
+
+// DevicePercentile places a device in the fleet for phase percentages: a
+// stable value from 0 to 99 derived from its ID, so the same devices are
+// selected in each phase
+func DevicePercentile(deviceID string) float64 {
+	h := fnv.New32a()
+	h.Write([]byte(deviceID))
+	return float64(h.Sum32() % 100)
+}
+
+// CohortOf returns the cohort a device with the tags belongs to in the
+// rollout: the value of its CohortBy tag, empty for untagged devices
+func (p RolloutPlan) CohortOf(tags map[string]string) string {
+	return tags[p.CohortBy]
+}
+
+// PercentileFor returns the percentile the phase percentage is compared to
+// for a device: its percentile within its cohort when the rollout is
+// cohorted and the fleet server ranked the device, else DevicePercentile
+func (p RolloutPlan) PercentileFor(deviceID string, cohortPercentiles map[string]float64) float64 {
+	if p.CohortBy != "" {
+		if percentile, ok := cohortPercentiles[p.ID]; ok {
+			return percentile
+		}
+	}
+	return DevicePercentile(deviceID)
+}
+
+// RankCohort spreads the devices of one cohort evenly over 0 to 100 in the
+// order of their DevicePercentile, so any percentage above zero selects at
+// least one of them and the same devices stay selected as it grows
+func RankCohort(deviceIDs []string) map[string]float64 {
+	ranked := append([]string(nil), deviceIDs...)
+	sort.Slice(ranked, func(i, j int) bool {
+		pi, pj := DevicePercentile(ranked[i]), DevicePercentile(ranked[j])
+		if pi != pj {
+			return pi < pj
+		}
+		return ranked[i] < ranked[j]
+	})
+
+	percentiles := make(map[string]float64, len(ranked))
+	for i, deviceID := range ranked {
+		percentiles[deviceID] = 100 * float64(i) / float64(len(ranked))
+	}
+	return percentiles
+}
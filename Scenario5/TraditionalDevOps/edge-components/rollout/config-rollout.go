@@ -0,0 +1,106 @@
+package rollout
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// ConfigApplier is an interface for applying configuration delivered by
+// config-only rollouts, e.g. deviceconfig.ConfigManager
+type ConfigApplier interface {
+	// ApplyConfig applies a configuration payload as the given version
+	ApplyConfig(version string, payload []byte) error
+
+	// RollbackConfig restores the configuration in place before the last ApplyConfig
+	RollbackConfig() error
+}
+
+// IsConfigOnly reports whether the plan delivers configuration instead of a package
+func (p RolloutPlan) IsConfigOnly() bool {
+	return p.ConfigPayload != ""
+}
+
+// RegisterConfigApplier registers an applier for config-only rollouts
+func (rm *RolloutManager) RegisterConfigApplier(applier ConfigApplier) {
+	rm.configAppliers = append(rm.configAppliers, applier)
+}
+
+// applyConfig applies a config-only rollout and records the applied config version
+func (rm *RolloutManager) applyConfig(rollout *RolloutPlan) error {
+	if rm.verifier != nil {
+		if err := rm.verifyPlan(rollout); err != nil {
+			return err
+		}
+	}
+
+	if len(rm.configAppliers) == 0 {
+		return fmt.Errorf("no config applier registered for config rollout %s", rollout.ID)
+	}
+
+	for _, applier := range rm.configAppliers {
+		if err := applier.ApplyConfig(rollout.Version, []byte(rollout.ConfigPayload)); err != nil {
+			return fmt.Errorf("config application failed: %w", err)
+		}
+	}
+
+	// Perform health checks under the rollout's policy
+	if err := rm.checkRolloutHealth(rollout); err != nil {
+		return err
+	}
+
+	if err := rm.recordConfigVersion(rollout.Version); err != nil {
+		rm.logger.Printf("Failed to record config version: %v", err)
+	}
+
+	return nil
+}
+
+// rollbackConfig rolls back the last configuration change
+func (rm *RolloutManager) rollbackConfig() error {
+	for _, applier := range rm.configAppliers {
+		if err := applier.RollbackConfig(); err != nil {
+			return fmt.Errorf("config rollback failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// getConfigVersion returns the config version last applied by a rollout, or "" if none
+func (rm *RolloutManager) getConfigVersion() (string, error) {
+	info, err := rm.loadDeviceInfo(context.Background(), "ConfigVersion")
+	if errors.Is(err, ErrDeviceNotFound) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to get config version: %w", err)
+	}
+
+	return info.ConfigVersion, nil
+}
+
+// recordConfigVersion stores the applied config version on the device record
+func (rm *RolloutManager) recordConfigVersion(version string) error {
+	_, err := rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+		},
+		UpdateExpression: aws.String("SET ConfigVersion = :version"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":version": &types.AttributeValueMemberS{Value: version},
+		},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to record config version: %w", err)
+	}
+
+	return nil
+}
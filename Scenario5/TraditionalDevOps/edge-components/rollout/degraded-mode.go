@@ -0,0 +1,141 @@
+package rollout
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// degradedFile holds the last plan and device record fetched successfully
+const degradedFile = "last-plan.json"
+
+// fetchedPlan is a successful rollout check, kept for degraded mode
+type fetchedPlan struct {
+	Plan      *RolloutPlan `json:"plan"` // nil when no rollout targeted the device
+	Device    *DeviceInfo  `json:"device"`
+	FetchedAt time.Time    `json:"fetchedAt"`
+}
+
+// degradedMode keeps devices mid-rollout going while the rollout and device
+// tables are throttled or failing: the last successful check, saved on
+// disk, stands in for the tables until it is older than maxAge
+type degradedMode struct {
+	path   string
+	maxAge time.Duration
+	last   *fetchedPlan
+	since  time.Time // when the tables started failing; zero while they work
+	mutex  sync.Mutex
+}
+
+// newDegradedMode loads the plan saved before a restart
+func newDegradedMode(updateBasePath string, maxAge time.Duration) (*degradedMode, error) {
+	dm := &degradedMode{
+		path:   filepath.Join(updateBasePath, degradedFile),
+		maxAge: maxAge,
+	}
+
+	data, err := os.ReadFile(dm.path)
+	if os.IsNotExist(err) {
+		return dm, nil
+	}
+	if err != nil {
+		return dm, fmt.Errorf("failed to read last plan: %w", err)
+	}
+
+	var last fetchedPlan
+	if err := json.Unmarshal(data, &last); err != nil {
+		return dm, fmt.Errorf("failed to parse last plan: %w", err)
+	}
+	dm.last = &last
+
+	return dm, nil
+}
+
+// Degraded reports whether the manager is running on its last fetched plan
+// because the tables are failing, and since when
+func (rm *RolloutManager) Degraded() (bool, time.Time) {
+	if rm.degraded == nil {
+		return false, time.Time{}
+	}
+
+	rm.degraded.mutex.Lock()
+	defer rm.degraded.mutex.Unlock()
+	return !rm.degraded.since.IsZero(), rm.degraded.since
+}
+
+// saveFetched records a successful check, ending degraded mode
+func (rm *RolloutManager) saveFetched(plan *RolloutPlan, device *DeviceInfo) {
+	if rm.degraded == nil {
+		return
+	}
+
+	dm := rm.degraded
+	last := &fetchedPlan{Plan: plan, Device: device, FetchedAt: rm.clock.Now()}
+
+	dm.mutex.Lock()
+	dm.last = last
+	recovered := !dm.since.IsZero()
+	dm.since = time.Time{}
+	dm.mutex.Unlock()
+
+	if recovered {
+		rm.logger.Printf("Rollout tables reachable again; leaving degraded mode")
+	}
+
+	data, err := json.Marshal(last)
+	if err == nil {
+		tmp := dm.path + ".tmp"
+		if err = os.WriteFile(tmp, data, 0600); err == nil {
+			err = os.Rename(tmp, dm.path)
+		}
+	}
+	if err != nil {
+		rm.logger.Printf("Failed to save last plan: %v", err)
+	}
+}
+
+// fallback returns the last fetched plan and device record when a table
+// read failed and they are within the staleness bound
+func (rm *RolloutManager) fallback(cause error) (*fetchedPlan, bool) {
+	if rm.degraded == nil || errors.Is(cause, ErrDeviceNotFound) {
+		return nil, false
+	}
+
+	dm := rm.degraded
+	now := rm.clock.Now()
+
+	dm.mutex.Lock()
+	defer dm.mutex.Unlock()
+
+	if dm.last == nil || now.Sub(dm.last.FetchedAt) > dm.maxAge {
+		if !dm.since.IsZero() {
+			rm.logger.Printf("Last plan is older than %s; stopping degraded mode", dm.maxAge)
+			dm.since = time.Time{}
+		}
+		return nil, false
+	}
+
+	if dm.since.IsZero() {
+		dm.since = now
+		rm.logger.Printf("Rollout tables failing (%v); using the plan fetched at %s", cause, dm.last.FetchedAt.Format(time.RFC3339))
+	}
+	return dm.last, true
+}
+
+// deviceInfoOrCached reads the device record, falling back to the last one
+// fetched while the device table fails
+func (rm *RolloutManager) deviceInfoOrCached() (*DeviceInfo, error) {
+	deviceInfo, err := rm.getDeviceInfo()
+	if err == nil {
+		return deviceInfo, nil
+	}
+
+	if last, ok := rm.fallback(err); ok && last.Device != nil {
+		return last.Device, nil
+	}
+	return nil, err
+}
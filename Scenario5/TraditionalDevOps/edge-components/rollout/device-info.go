@@ -0,0 +1,128 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// DeviceInfo is this device's record in the device table, as the rollout
+// manager reads it. Attributes the fleet server adds are ignored.
+type DeviceInfo struct {
+	DeviceID       string            `dynamodbav:"DeviceID" json:"deviceId"` // tenant-qualified key
+	DeviceGroup    string            `dynamodbav:"DeviceGroup" json:"deviceGroup"`
+	Region         string            `dynamodbav:"Region,omitempty" json:"region,omitempty"`
+	Timezone       string            `dynamodbav:"Timezone,omitempty" json:"timezone,omitempty"` // IANA name, e.g. Europe/Berlin
+	Tags           map[string]string `dynamodbav:"Tags" json:"tags"`
+	DynamicGroups  []string          `dynamodbav:"DynamicGroups" json:"dynamicGroups"`
+	CurrentVersion string            `dynamodbav:"CurrentVersion" json:"currentVersion"`
+	ConfigVersion  string            `dynamodbav:"ConfigVersion,omitempty" json:"configVersion,omitempty"`
+	UpdateStatus   string            `dynamodbav:"UpdateStatus" json:"updateStatus"`
+	LastUpdateID   string            `dynamodbav:"LastUpdateID" json:"lastUpdateId"`
+	Healthy        *bool             `dynamodbav:"Healthy,omitempty" json:"healthy,omitempty"`
+	HealthScore    *float64          `dynamodbav:"HealthScore,omitempty" json:"healthScore,omitempty"` // nil until first scored
+	LastSeen       string            `dynamodbav:"LastSeen,omitempty" json:"lastSeen,omitempty"`       // RFC3339
+
+	// ClockDriftMs is the device clock minus server time, as last measured
+	// by a ClockMonitor
+	ClockDriftMs *int64 `dynamodbav:"ClockDriftMs,omitempty" json:"clockDriftMs,omitempty"`
+
+	// Fenced is set by the fleet server when the device's attestation fails;
+	// a fenced device applies no rollouts
+	Fenced       bool   `dynamodbav:"Fenced,omitempty" json:"fenced,omitempty"`
+	FencedReason string `dynamodbav:"FencedReason,omitempty" json:"fencedReason,omitempty"`
+
+	// ConfirmedUpdateID is the rollout an operator confirmed the device's
+	// update for, when the rollout sets ConfirmWithin
+	ConfirmedUpdateID string `dynamodbav:"ConfirmedUpdateID,omitempty" json:"confirmedUpdateId,omitempty"`
+
+	// MaintenanceWindow ("HH:MM-HH:MM", device-local) is when the device
+	// accepts updates; it replaces the phase's Window
+	MaintenanceWindow string `dynamodbav:"MaintenanceWindow,omitempty" json:"maintenanceWindow,omitempty"`
+
+	// PollInterval, a duration, is how often the fleet server's PollAdvisor
+	// suggests the device checks for updates while no rollout plan sets one
+	PollInterval string `dynamodbav:"PollInterval,omitempty" json:"pollInterval,omitempty"`
+
+	// Capabilities is what the device advertised it can install; nil for
+	// devices that never did
+	Capabilities *Capabilities `dynamodbav:"Capabilities,omitempty" json:"capabilities,omitempty"`
+
+	// Architecture is the CPU architecture the device reported, e.g. arm64
+	Architecture string `dynamodbav:"Architecture,omitempty" json:"architecture,omitempty"`
+
+	// CohortPercentiles is the device's rank within its cohort of each
+	// cohorted rollout targeting it, by rollout ID, as the fleet server's
+	// CohortRanker last computed it
+	CohortPercentiles map[string]float64 `dynamodbav:"CohortPercentiles,omitempty" json:"cohortPercentiles,omitempty"`
+
+	// Desired is what an operator set for this device alone; it takes
+	// precedence over fleet rollouts until cleared
+	Desired *DesiredState `dynamodbav:"Desired,omitempty" json:"desired,omitempty"`
+}
+
+// InDynamicGroup reports whether the fleet server placed the device in group
+func (d *DeviceInfo) InDynamicGroup(group string) bool {
+	for _, g := range d.DynamicGroups {
+		if g == group {
+			return true
+		}
+	}
+	return false
+}
+
+// MeetsHealthScore reports whether the device's health score reaches
+// minScore; an unscored device only passes when no minimum is set
+func (d *DeviceInfo) MeetsHealthScore(minScore float64) bool {
+	if minScore <= 0 {
+		return true
+	}
+	return d.HealthScore != nil && *d.HealthScore >= minScore
+}
+
+// GetDeviceInfo returns this device's record from the device table
+func (rm *RolloutManager) GetDeviceInfo(ctx context.Context) (*DeviceInfo, error) {
+	return rm.loadDeviceInfo(ctx, "")
+}
+
+// getDeviceInfo retrieves information about this device
+func (rm *RolloutManager) getDeviceInfo() (*DeviceInfo, error) {
+	return rm.loadDeviceInfo(context.Background(), "")
+}
+
+// loadDeviceInfo reads the device record, limited to the projection when set
+func (rm *RolloutManager) loadDeviceInfo(ctx context.Context, projection string) (*DeviceInfo, error) {
+	input := &dynamodb.GetItemInput{
+		TableName: aws.String(rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+		},
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	}
+	if projection != "" {
+		input.ProjectionExpression = aws.String(projection)
+	}
+
+	result, err := rm.dynamoClient.GetItem(ctx, input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get device info: %w", err)
+	}
+	rm.usage.addCapacity(result.ConsumedCapacity)
+
+	if result.Item == nil {
+		return nil, fmt.Errorf("%w: %s", ErrDeviceNotFound, rm.deviceID)
+	}
+
+	var info DeviceInfo
+	if err := attributevalue.UnmarshalMap(result.Item, &info); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal device info: %w", err)
+	}
+
+	return &info, nil
+}
@@ -0,0 +1,89 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// DesiredStatePrefix starts the IDs of per-device desired states, so status
+// reports and abort checks can tell them from fleet-wide rollouts
+const DesiredStatePrefix = "desired/"
+
+// DesiredState is what an operator wants one device to run, stored in the
+// device record beside the state the device reports. While it is set the
+// device reconciles toward it and fleet rollouts pass the device by.
+type DesiredState struct {
+	ID            string    `json:"id" dynamodbav:"ID"` // DesiredStatePrefix plus a unique suffix; changes on every write
+	Version       string    `json:"version,omitempty" dynamodbav:"Version,omitempty"`
+	PackageURL    string    `json:"packageUrl,omitempty" dynamodbav:"PackageURL,omitempty"`
+	PackageHash   string    `json:"packageHash,omitempty" dynamodbav:"PackageHash,omitempty"`
+	ArtifactName  string    `json:"artifactName,omitempty" dynamodbav:"ArtifactName,omitempty"`
+	ConfigPayload string    `json:"configPayload,omitempty" dynamodbav:"ConfigPayload,omitempty"` // target config instead of a package
+	Signature     string    `json:"signature,omitempty" dynamodbav:"Signature,omitempty"`         // over the SigningPayload of Plan
+	SigningKeyID  string    `json:"signingKeyId,omitempty" dynamodbav:"SigningKeyID,omitempty"`
+	SetBy         string    `json:"setBy,omitempty" dynamodbav:"SetBy,omitempty"`
+	SetAt         time.Time `json:"setAt" dynamodbav:"SetAt"`
+	Reason        string    `json:"reason,omitempty" dynamodbav:"Reason,omitempty"`
+}
+
+// IsDesiredState reports whether rolloutID names a per-device desired state
+// rather than a fleet rollout
+func IsDesiredState(rolloutID string) bool {
+	return strings.HasPrefix(rolloutID, DesiredStatePrefix)
+}
+
+// Plan returns the desired state as a one-phase rollout of the whole device,
+// so it is applied, verified, reported and rolled back like any rollout
+func (d *DesiredState) Plan(tenantID string) *RolloutPlan {
+	return &RolloutPlan{
+		ID:            d.ID,
+		Name:          "desired state",
+		Description:   d.Reason,
+		Version:       d.Version,
+		CreatedAt:     d.SetAt,
+		UpdatedAt:     d.SetAt,
+		Status:        "in-progress",
+		Phases:        []RolloutPhase{{ID: "device", Percentage: 100}},
+		PackageURL:    d.PackageURL,
+		PackageHash:   d.PackageHash,
+		ArtifactName:  d.ArtifactName,
+		ConfigPayload: d.ConfigPayload,
+		CreatedBy:     d.SetBy,
+		TenantID:      tenantID,
+		Signature:     d.Signature,
+		SigningKeyID:  d.SigningKeyID,
+	}
+}
+
+// desiredStateStatus returns "in-progress" while rolloutID is still this
+// device's desired state, and "aborted" once it was replaced or cleared
+func (rm *RolloutManager) desiredStateStatus(ctx context.Context, rolloutID string) (string, error) {
+	result, err := rm.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+		},
+		ProjectionExpression:   aws.String("Desired.ID"),
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get desired state: %w", err)
+	}
+	rm.usage.addCapacity(result.ConsumedCapacity)
+
+	if desired, ok := result.Item["Desired"].(*types.AttributeValueMemberM); ok {
+		if id, ok := desired.Value["ID"].(*types.AttributeValueMemberS); ok && id.Value == rolloutID {
+			return "in-progress", nil
+		}
+	}
+
+	return "aborted", nil
+}
@@ -0,0 +1,23 @@
+package rollout
+
+import "fmt"
+
+// EntitlementChecker gates features on the device's license;
+// *entitlements.Enforcer satisfies it
+type EntitlementChecker interface {
+	// Check returns nil when the license grants feature, and otherwise an
+	// error wrapping entitlements.ErrNotEntitled
+	Check(feature string) error
+}
+
+// checkEntitlement returns ErrNotEntitled when the rollout requires a feature
+// the device's license doesn't grant
+func (rm *RolloutManager) checkEntitlement(rollout *RolloutPlan) error {
+	if rollout.RequiredFeature == "" || rm.entitlements == nil {
+		return nil
+	}
+	if err := rm.entitlements.Check(rollout.RequiredFeature); err != nil {
+		return fmt.Errorf("rollout %s: %w", rollout.ID, err)
+	}
+	return nil
+}
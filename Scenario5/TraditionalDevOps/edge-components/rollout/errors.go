@@ -0,0 +1,94 @@
+package rollout
+
+import (
+	"errors"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	bootintegrity "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/boot-integrity"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/entitlements"
+)
+
+// Errors returned, wrapped, by the RolloutManager; match them with errors.Is
+var (
+	// ErrDeviceNotFound means the device has no record in the device table
+	ErrDeviceNotFound = errors.New("device not found")
+
+	// ErrHashMismatch means a downloaded package doesn't match the plan's hash
+	ErrHashMismatch = errors.New("package hash mismatch")
+
+	// ErrPhaseNotApproved means the rollout's current phase awaits approval
+	ErrPhaseNotApproved = errors.New("rollout phase not approved")
+
+	// ErrUpToDate means the device already runs the rollout's version
+	ErrUpToDate = errors.New("device already on rollout version")
+
+	// ErrNotSelected means the device is outside the current phase's
+	// percentage, or the rollout has no phase left
+	ErrNotSelected = errors.New("device not selected by rollout phase")
+
+	// ErrBusinessHours means the update waits for the end of the device's
+	// local business hours
+	ErrBusinessHours = errors.New("update deferred during business hours")
+
+	// ErrOutsideWindow means the update waits for the device's maintenance
+	// window, or the phase's window in device-local time
+	ErrOutsideWindow = errors.New("outside maintenance window")
+
+	// ErrBandwidthCap means the device used up its bandwidth cap for the
+	// billing period and the rollout isn't critical; it is
+	// bandwidth.ErrCapExceeded, so either matches
+	ErrBandwidthCap = bandwidth.ErrCapExceeded
+
+	// ErrNotEntitled means the rollout requires a feature the device's
+	// license doesn't grant, or the license expired past its grace period;
+	// it is entitlements.ErrNotEntitled, so either matches
+	ErrNotEntitled = entitlements.ErrNotEntitled
+
+	// ErrIncompatible means the device lacks a handler, package format or
+	// schema version the rollout needs, so it skips the rollout
+	ErrIncompatible = errors.New("device can't install rollout")
+
+	// ErrFenced means the fleet server fenced the device out of rollouts
+	// after its attestation failed, until an operator unfences it
+	ErrFenced = errors.New("device fenced")
+
+	// ErrAttestationFailed means attestation is required and the device's TPM
+	// couldn't quote its PCRs, or they differ from the expected values
+	ErrAttestationFailed = errors.New("attestation failed")
+
+	// ErrIntegrityFailed means a pre-apply hook found the device's boot chain
+	// tampered with, so the update was refused and reported as
+	// integrity-failed; it is bootintegrity.ErrIntegrityFailed, so either matches
+	ErrIntegrityFailed = bootintegrity.ErrIntegrityFailed
+
+	// ErrRolloutAborted means the rollout was aborted while the device was
+	// applying it; the device has undone what it had applied
+	ErrRolloutAborted = errors.New("rollout aborted")
+
+	// ErrNoPendingConfirmation means no update for the rollout awaits confirmation
+	ErrNoPendingConfirmation = errors.New("no update awaiting confirmation")
+)
+
+// skipErrors are the CheckEligibility results that mean the device should
+// not apply the rollout now, as opposed to failures to decide
+var skipErrors = []error{
+	ErrUpToDate,
+	ErrNotSelected,
+	ErrPhaseNotApproved,
+	ErrBusinessHours,
+	ErrOutsideWindow,
+	ErrBandwidthCap,
+	ErrNotEntitled,
+	ErrIncompatible,
+	ErrFenced,
+}
+
+// isExpectedSkip reports whether err is one of skipErrors
+func isExpectedSkip(err error) bool {
+	for _, target := range skipErrors {
+		if errors.Is(err, target) {
+			return true
+		}
+	}
+	return false
+}
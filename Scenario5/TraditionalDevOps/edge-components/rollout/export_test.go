@@ -0,0 +1,22 @@
+package rollout
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// ActiveRollout exposes the rollout table query the check loop runs
+func (rm *RolloutManager) ActiveRollout(ctx context.Context) (*RolloutPlan, error) {
+	deviceInfo, err := rm.GetDeviceInfo(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return rm.getActiveRollout(deviceInfo)
+}
+
+// QueryAllPages exposes the paginated rollout query
+func (rm *RolloutManager) QueryAllPages(input *dynamodb.QueryInput) ([]map[string]types.AttributeValue, error) {
+	return rm.queryAllPages(input)
+}
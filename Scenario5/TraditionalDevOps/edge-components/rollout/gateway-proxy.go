@@ -0,0 +1,431 @@
+package rollout
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// GatewayProxy lets a connected gateway device act for downstream devices
+// that have no cloud access. It serves the FleetAgent gRPC service to
+// downstream GRPCAgents and relays each stream to the fleet server, so rollout
+// commands and status reports stay per device. Package URLs in commands are
+// rewritten to the proxy, which downloads each package once and serves it
+// from a local cache, and sync uploads are forwarded with the gateway's own
+// credentials. Downstream devices are identified by their mTLS client
+// certificate; the fleet server must list the gateway in TrustedProxies.
+type GatewayProxy struct {
+	conn       *grpc.ClientConn
+	proxyID    string
+	tenantID   string
+	publicURL  string
+	cacheDir   string
+	transport  offlineSync.SyncTransport
+	httpClient *http.Client
+	packages   map[string]string // package hash -> upstream URL
+	downstream map[string]*DownstreamStatus
+	mutex      sync.Mutex
+	fetchMutex sync.Mutex
+}
+
+// GatewayProxyConfig contains configuration for the GatewayProxy
+type GatewayProxyConfig struct {
+	ServerAddr string
+	TLSConfig  *tls.Config // the gateway's client certificate for the fleet server
+	ProxyID    string      // the gateway's device ID
+	TenantID   string
+	PublicURL  string // base URL downstream devices use to reach Handler
+	CacheDir   string
+	Transport  offlineSync.SyncTransport // where sync uploads go, normally offlineSync.NewS3Transport
+}
+
+// DownstreamStatus is the last known state of a downstream device
+type DownstreamStatus struct {
+	DeviceID       string    `json:"deviceId"`
+	Connected      bool      `json:"connected"`
+	CurrentVersion string    `json:"currentVersion"`
+	Healthy        *bool     `json:"healthy,omitempty"`
+	LastSeen       time.Time `json:"lastSeen"`
+	RolloutID      string    `json:"rolloutId,omitempty"`
+	UpdateStatus   string    `json:"updateStatus,omitempty"`
+	UpdateMessage  string    `json:"updateMessage,omitempty"`
+	LastSyncStatus string    `json:"lastSyncStatus,omitempty"`
+}
+
+// NewGatewayProxy creates a new GatewayProxy; register it with a gRPC server
+// using agentproto.RegisterFleetAgentServer and serve Handler over HTTPS
+func NewGatewayProxy(config GatewayProxyConfig) (*GatewayProxy, error) {
+	if err := os.MkdirAll(config.CacheDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create package cache: %w", err)
+	}
+
+	conn, err := grpc.NewClient(config.ServerAddr, grpc.WithTransportCredentials(credentials.NewTLS(config.TLSConfig)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+
+	return &GatewayProxy{
+		conn:       conn,
+		proxyID:    config.ProxyID,
+		tenantID:   config.TenantID,
+		publicURL:  strings.TrimSuffix(config.PublicURL, "/"),
+		cacheDir:   config.CacheDir,
+		transport:  config.Transport,
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+		packages:   make(map[string]string),
+		downstream: make(map[string]*DownstreamStatus),
+	}, nil
+}
+
+// Close disconnects from the fleet server
+func (p *GatewayProxy) Close() error {
+	return p.conn.Close()
+}
+
+// Connect implements agentproto.FleetAgentServer, relaying one downstream stream
+func (p *GatewayProxy) Connect(stream agentproto.FleetAgentConnectServer) error {
+	first, err := stream.Recv()
+	if err != nil {
+		return err
+	}
+	if first.Hello == nil || first.Hello.DeviceID == "" {
+		return status.Error(codes.InvalidArgument, "first message must be a hello with a device ID")
+	}
+
+	hello := *first.Hello
+	identity, err := PeerDeviceID(stream.Context())
+	if err != nil {
+		return err
+	}
+	if identity != hello.DeviceID {
+		return status.Errorf(codes.PermissionDenied, "certificate identity %q does not match device %q", identity, hello.DeviceID)
+	}
+	if hello.TenantID != p.tenantID {
+		return status.Errorf(codes.PermissionDenied, "device is not in tenant %q", p.tenantID)
+	}
+	hello.ProxyID = p.proxyID
+
+	ctx, cancel := context.WithCancel(stream.Context())
+	defer cancel()
+
+	upstream, err := agentproto.NewConnectStream(ctx, p.conn)
+	if err != nil {
+		log.Printf("Failed to open upstream stream for %s: %v", hello.DeviceID, err)
+		return status.Error(codes.Unavailable, "fleet server unreachable")
+	}
+	if err := upstream.Send(&agentproto.AgentMessage{Hello: &hello}); err != nil {
+		return status.Error(codes.Unavailable, "fleet server unreachable")
+	}
+
+	p.updateDownstream(hello.DeviceID, func(ds *DownstreamStatus) {
+		ds.Connected = true
+		ds.CurrentVersion = hello.CurrentVersion
+	})
+	defer p.updateDownstream(hello.DeviceID, func(ds *DownstreamStatus) {
+		ds.Connected = false
+	})
+
+	log.Printf("Proxying device %s (version %s)", hello.DeviceID, hello.CurrentVersion)
+
+	// Relay commands down, pointing package downloads at the proxy
+	go func() {
+		defer cancel()
+		for {
+			message, err := upstream.Recv()
+			if err != nil {
+				if err != io.EOF && ctx.Err() == nil {
+					log.Printf("Upstream stream for %s ended: %v", hello.DeviceID, err)
+				}
+				return
+			}
+
+			if message.Command != nil && message.Command.PackageURL != "" {
+				message.Command.PackageURL = p.proxyPackage(message.Command.PackageURL, message.Command.PackageHash)
+			}
+
+			if err := stream.Send(message); err != nil {
+				log.Printf("Failed to relay message to %s: %v", hello.DeviceID, err)
+				return
+			}
+		}
+	}()
+
+	// Relay reports up, recording each device's state on the way
+	for {
+		message, err := stream.Recv()
+		if err != nil {
+			upstream.CloseSend()
+			if err == io.EOF || ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		p.record(hello.DeviceID, message)
+
+		if err := upstream.Send(message); err != nil {
+			log.Printf("Failed to relay message from %s: %v", hello.DeviceID, err)
+			return status.Error(codes.Unavailable, "fleet server unreachable")
+		}
+	}
+}
+
+// Downstream returns the state of every device that has connected through the proxy
+func (p *GatewayProxy) Downstream() []DownstreamStatus {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	devices := make([]DownstreamStatus, 0, len(p.downstream))
+	for _, ds := range p.downstream {
+		devices = append(devices, *ds)
+	}
+	sort.Slice(devices, func(i, j int) bool {
+		return devices[i].DeviceID < devices[j].DeviceID
+	})
+
+	return devices
+}
+
+// Handler serves package downloads, sync objects and downstream status to downstream devices
+func (p *GatewayProxy) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /packages/{hash}", p.handlePackage)
+	mux.HandleFunc("PUT /sync/{key...}", p.handleSyncPut)
+	mux.HandleFunc("GET /sync/{key...}", p.handleSyncGet)
+	mux.HandleFunc("GET /downstream", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(w).Encode(p.Downstream()); err != nil {
+			log.Printf("Failed to write response: %v", err)
+		}
+	})
+	return mux
+}
+
+// record updates a downstream device's state from a message it sent
+func (p *GatewayProxy) record(deviceID string, message *agentproto.AgentMessage) {
+	p.updateDownstream(deviceID, func(ds *DownstreamStatus) {
+		switch {
+		case message.Heartbeat != nil:
+			healthy := message.Heartbeat.Healthy
+			ds.Healthy = &healthy
+		case message.Status != nil:
+			ds.RolloutID = message.Status.RolloutID
+			ds.UpdateStatus = message.Status.Status
+			ds.UpdateMessage = message.Status.Message
+			if message.Status.Status == agentproto.StatusSuccess {
+				ds.CurrentVersion = message.Status.Version
+			}
+		case message.Sync != nil:
+			ds.LastSyncStatus = message.Sync.Status
+		}
+	})
+}
+
+// updateDownstream applies a change to a downstream device's state
+func (p *GatewayProxy) updateDownstream(deviceID string, update func(*DownstreamStatus)) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	ds, ok := p.downstream[deviceID]
+	if !ok {
+		ds = &DownstreamStatus{DeviceID: deviceID}
+		p.downstream[deviceID] = ds
+	}
+	update(ds)
+	ds.LastSeen = time.Now()
+}
+
+// proxyPackage remembers where a package comes from and returns the proxy's URL for it
+func (p *GatewayProxy) proxyPackage(packageURL, packageHash string) string {
+	p.mutex.Lock()
+	p.packages[packageHash] = packageURL
+	p.mutex.Unlock()
+
+	return p.publicURL + "/packages/" + packageHash
+}
+
+// handlePackage serves a package from the cache, downloading it on first request
+func (p *GatewayProxy) handlePackage(w http.ResponseWriter, r *http.Request) {
+	if _, err := RequestDeviceID(r); err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	hash := r.PathValue("hash")
+	packagePath, err := p.cachedPackage(r.Context(), hash)
+	if err != nil {
+		log.Printf("Failed to fetch package %s: %v", hash, err)
+		http.Error(w, "package unavailable", http.StatusBadGateway)
+		return
+	}
+
+	http.ServeFile(w, r, packagePath)
+}
+
+// cachedPackage returns the local path of a package, downloading and verifying it if needed
+func (p *GatewayProxy) cachedPackage(ctx context.Context, hash string) (string, error) {
+	p.mutex.Lock()
+	packageURL, ok := p.packages[hash]
+	p.mutex.Unlock()
+	if !ok {
+		return "", fmt.Errorf("unknown package")
+	}
+
+	// One download per package, however many devices ask at once
+	p.fetchMutex.Lock()
+	defer p.fetchMutex.Unlock()
+
+	packagePath := filepath.Join(p.cacheDir, hash)
+	if _, err := os.Stat(packagePath); err == nil {
+		return packagePath, nil
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, packageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := p.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download package: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download package: %s", resp.Status)
+	}
+
+	tempPath := packagePath + ".partial"
+	file, err := os.Create(tempPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+
+	_, actual, err := copyHashed(file, resp.Body)
+	file.Close()
+	if err != nil {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("failed to write package file: %w", err)
+	}
+
+	if actual != hash {
+		os.Remove(tempPath)
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, hash, actual)
+	}
+
+	if err := os.Rename(tempPath, packagePath); err != nil {
+		return "", fmt.Errorf("failed to cache package: %w", err)
+	}
+
+	return packagePath, nil
+}
+
+// handleSyncPut forwards a downstream device's sync upload
+func (p *GatewayProxy) handleSyncPut(w http.ResponseWriter, r *http.Request) {
+	key, ok := p.syncKey(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := io.ReadAll(r.Body)
+	if err != nil {
+		http.Error(w, "failed to read body", http.StatusBadRequest)
+		return
+	}
+
+	metadata := make(map[string]string)
+	for name := range r.Header {
+		if strings.HasPrefix(name, offlineSync.SyncMetadataHeader) {
+			metadata[strings.ToLower(strings.TrimPrefix(name, offlineSync.SyncMetadataHeader))] = r.Header.Get(name)
+		}
+	}
+
+	if err := p.transport.PutObject(r.Context(), key, data, metadata); err != nil {
+		log.Printf("Failed to forward sync upload %s: %v", key, err)
+		http.Error(w, "upload failed", http.StatusBadGateway)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
+
+// handleSyncGet forwards a downstream device's sync download
+func (p *GatewayProxy) handleSyncGet(w http.ResponseWriter, r *http.Request) {
+	key, ok := p.syncKey(w, r)
+	if !ok {
+		return
+	}
+
+	data, err := p.transport.GetObject(r.Context(), key)
+	if err != nil {
+		log.Printf("Failed to forward sync download %s: %v", key, err)
+		http.Error(w, "download failed", http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/octet-stream")
+	w.Write(data)
+}
+
+// syncKey returns the requested sync key, rejecting keys outside the device's own prefix
+func (p *GatewayProxy) syncKey(w http.ResponseWriter, r *http.Request) (string, bool) {
+	deviceID, err := RequestDeviceID(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return "", false
+	}
+
+	key := r.PathValue("key")
+	prefix := fmt.Sprintf("%sdevices/%s/", tenant.S3Prefix(p.tenantID), deviceID)
+	if !strings.HasPrefix(key, prefix) || strings.Contains(key, "..") {
+		http.Error(w, "key outside "+prefix, http.StatusForbidden)
+		return "", false
+	}
+
+	return key, true
+}
+
+// Helper functions
+
+// PeerDeviceID returns the device ID from a gRPC peer's verified client certificate
+func PeerDeviceID(ctx context.Context) (string, error) {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return "", status.Error(codes.Unauthenticated, "no peer information")
+	}
+
+	tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(tlsInfo.State.VerifiedChains) == 0 || len(tlsInfo.State.VerifiedChains[0]) == 0 {
+		return "", status.Error(codes.Unauthenticated, "client certificate required")
+	}
+
+	return tlsInfo.State.VerifiedChains[0][0].Subject.CommonName, nil
+}
+
+// RequestDeviceID returns the device ID from an HTTPS request's verified client certificate
+func RequestDeviceID(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", fmt.Errorf("client certificate required")
+	}
+	return r.TLS.VerifiedChains[0][0].Subject.CommonName, nil
+}
@@ -0,0 +1,261 @@
+package rollout
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// appliedCommitFile records the last commit whose handlers and health checks
+// succeeded, in the checkout directory
+const appliedCommitFile = "applied-commit"
+
+// GitOpsConfig contains configuration for GitOps reconciliation mode
+type GitOpsConfig struct {
+	RepoURL              string
+	BranchPrefix         string // the device group is appended to form the branch name
+	CheckoutPath         string
+	RequireSignedCommits bool
+	ReconcileInterval    time.Duration
+}
+
+// GitOpsReconciler reconciles device state from a Git branch, treating each commit as a rollout
+type GitOpsReconciler struct {
+	rm                *RolloutManager
+	repoURL           string
+	branch            string
+	repoPath          string
+	statePath         string
+	requireSigned     bool
+	appliedCommit     string
+	reconcileMutex    sync.Mutex
+	reconcileInterval time.Duration
+	reconcileTimer    *time.Timer
+}
+
+// StartGitOpsMode switches the manager from rollout-table polling to Git reconciliation
+func (rm *RolloutManager) StartGitOpsMode(config GitOpsConfig) (*GitOpsReconciler, error) {
+	if err := os.MkdirAll(config.CheckoutPath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create checkout directory: %w", err)
+	}
+
+	gr := &GitOpsReconciler{
+		rm:                rm,
+		repoURL:           config.RepoURL,
+		branch:            config.BranchPrefix + rm.deviceGroup,
+		repoPath:          filepath.Join(config.CheckoutPath, "repo"),
+		statePath:         filepath.Join(config.CheckoutPath, appliedCommitFile),
+		requireSigned:     config.RequireSignedCommits,
+		reconcileInterval: config.ReconcileInterval,
+	}
+
+	if err := gr.ensureClone(); err != nil {
+		return nil, err
+	}
+
+	// Resume from the last commit that was applied; the checkout itself
+	// can't tell, as a fresh clone or an apply interrupted by a crash
+	// leaves HEAD at a commit the handlers never finished
+	data, err := os.ReadFile(gr.statePath)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read applied commit: %w", err)
+	}
+	gr.appliedCommit = strings.TrimSpace(string(data))
+
+	// Git replaces the rollout table as the source of updates
+	if rm.checkTimer != nil {
+		rm.checkTimer.Stop()
+	}
+
+	// Start the reconcile timer
+	gr.reconcileTimer = time.AfterFunc(gr.reconcileInterval, gr.reconcileLoop)
+
+	return gr, nil
+}
+
+// reconcileLoop reconciles and reschedules itself
+func (gr *GitOpsReconciler) reconcileLoop() {
+	defer func() {
+		// Reschedule the reconciliation
+		gr.reconcileTimer.Reset(gr.reconcileInterval)
+	}()
+
+	if err := gr.Reconcile(); err != nil {
+		log.Printf("GitOps reconciliation failed: %v", err)
+	}
+}
+
+// Reconcile fetches the group branch and applies the head commit if it hasn't been applied yet
+func (gr *GitOpsReconciler) Reconcile() error {
+	gr.reconcileMutex.Lock()
+	defer gr.reconcileMutex.Unlock()
+
+	if _, err := gr.git("fetch", "--quiet", "origin", gr.branch); err != nil {
+		return fmt.Errorf("failed to fetch %s: %w", gr.branch, err)
+	}
+
+	target, err := gr.git("rev-parse", "FETCH_HEAD")
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", gr.branch, err)
+	}
+
+	if target == gr.appliedCommit {
+		return nil
+	}
+
+	rolloutID := "gitops:" + target
+
+	if gr.requireSigned {
+		if _, err := gr.git("verify-commit", target); err != nil {
+			gr.report(rolloutID, target, "failed", fmt.Sprintf("commit signature verification failed: %v", err))
+			return fmt.Errorf("commit %s failed signature verification: %w", target, err)
+		}
+	}
+
+	if _, err := gr.git("checkout", "--quiet", "--force", target); err != nil {
+		return fmt.Errorf("failed to check out %s: %w", target, err)
+	}
+
+	if err := gr.apply(target); err != nil {
+		gr.report(rolloutID, target, "failed", err.Error())
+
+		if err := gr.rm.rollbackUpdate(); err != nil {
+			log.Printf("Failed to rollback update: %v", err)
+		}
+
+		// Return the worktree to the last good commit
+		if gr.appliedCommit != "" {
+			if _, err := gr.git("checkout", "--quiet", "--force", gr.appliedCommit); err != nil {
+				log.Printf("Failed to restore checkout of %s: %v", gr.appliedCommit, err)
+			}
+		}
+
+		return err
+	}
+
+	if err := gr.saveAppliedCommit(target); err != nil {
+		log.Printf("Failed to record applied commit %s: %v", target, err)
+	}
+	gr.appliedCommit = target
+	gr.report(rolloutID, target, "success", "")
+
+	return nil
+}
+
+// apply validates and applies the checked-out tree with all registered handlers
+func (gr *GitOpsReconciler) apply(sha string) error {
+	for _, handler := range gr.rm.updateHandlers {
+		if err := handler.ValidateUpdate(gr.repoPath); err != nil {
+			return fmt.Errorf("update validation failed: %w", err)
+		}
+	}
+
+	for _, handler := range gr.rm.updateHandlers {
+		if err := handler.HandleUpdate(gr.repoPath, sha); err != nil {
+			return fmt.Errorf("update application failed: %w", err)
+		}
+	}
+
+	healthy, err := gr.rm.performHealthChecks()
+	if err != nil {
+		return fmt.Errorf("health check failed after update: %w", err)
+	}
+	if !healthy {
+		return errors.New("device unhealthy after update")
+	}
+
+	return nil
+}
+
+// report records the update status and the applied commit in the device table
+func (gr *GitOpsReconciler) report(rolloutID, sha, status, message string) {
+	if err := gr.rm.reportUpdateStatus(rolloutID, status, message); err != nil {
+		log.Printf("Failed to report GitOps status: %v", err)
+	}
+
+	if status != "success" {
+		return
+	}
+
+	_, err := gr.rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(gr.rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(gr.rm.tenantID, gr.rm.deviceID)},
+		},
+		UpdateExpression: aws.String("SET AppliedCommit = :sha, GitOpsBranch = :branch, CurrentVersion = :sha"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":sha":    &types.AttributeValueMemberS{Value: sha},
+			":branch": &types.AttributeValueMemberS{Value: gr.branch},
+		},
+	})
+	if err != nil {
+		log.Printf("Failed to report applied commit: %v", err)
+	}
+}
+
+// saveAppliedCommit records a successfully applied commit, so a restart
+// resumes from it
+func (gr *GitOpsReconciler) saveAppliedCommit(sha string) error {
+	tmp := gr.statePath + ".tmp"
+	if err := os.WriteFile(tmp, []byte(sha+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, gr.statePath)
+}
+
+// AppliedCommit returns the SHA of the last successfully applied commit
+func (gr *GitOpsReconciler) AppliedCommit() string {
+	gr.reconcileMutex.Lock()
+	defer gr.reconcileMutex.Unlock()
+	return gr.appliedCommit
+}
+
+// ensureClone clones the repository if it isn't present yet
+func (gr *GitOpsReconciler) ensureClone() error {
+	if _, err := os.Stat(filepath.Join(gr.repoPath, ".git")); err == nil {
+		return nil
+	}
+
+	cmd := exec.Command("git", "clone", "--quiet", "--no-checkout", "--branch", gr.branch, gr.repoURL, gr.repoPath)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to clone %s: %w: %s", gr.repoURL, err, strings.TrimSpace(string(out)))
+	}
+
+	return nil
+}
+
+// git runs a git command in the checkout and returns its trimmed output
+func (gr *GitOpsReconciler) git(args ...string) (string, error) {
+	var stderr bytes.Buffer
+	cmd := exec.Command("git", args...)
+	cmd.Dir = gr.repoPath
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+
+	return strings.TrimSpace(string(out)), nil
+}
+
+// Close stops GitOps reconciliation
+func (gr *GitOpsReconciler) Close() {
+	if gr.reconcileTimer != nil {
+		gr.reconcileTimer.Stop()
+	}
+}
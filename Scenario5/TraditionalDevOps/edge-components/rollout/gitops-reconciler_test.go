@@ -0,0 +1,171 @@
+package rollout_test
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/testkit"
+)
+
+// recordingHandler records the versions it applied
+type recordingHandler struct {
+	applied []string
+}
+
+func (h *recordingHandler) HandleUpdate(_ string, version string) error {
+	h.applied = append(h.applied, version)
+	return nil
+}
+
+func (h *recordingHandler) ValidateUpdate(string) error { return nil }
+
+func (h *recordingHandler) RollbackUpdate() error { return nil }
+
+// healthCheck reports a fixed health
+type healthCheck bool
+
+func (h healthCheck) CheckHealth() (bool, error) { return bool(h), nil }
+
+func TestGitOpsAppliesUntilRecorded(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	// An origin with one commit on the group branch
+	origin := t.TempDir()
+	git(t, origin, "init", "--quiet", "--initial-branch", "edge-all")
+	if err := os.WriteFile(filepath.Join(origin, "state.yaml"), []byte("replicas: 1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	git(t, origin, "add", ".")
+	git(t, origin, "-c", "user.name=test", "-c", "user.email=test@example.com", "commit", "--quiet", "-m", "desired state")
+	head := git(t, origin, "rev-parse", "HEAD")
+
+	laptop := testkit.NewLaptop(t.TempDir())
+	if err := laptop.AddDevice(context.Background(), testkit.NewDevice("device-1").Tenant("acme").Group("all")); err != nil {
+		t.Fatalf("AddDevice: %v", err)
+	}
+	checkout := t.TempDir()
+
+	tests := []struct {
+		name      string
+		prepare   func()
+		healthy   bool
+		wantApply bool
+		wantErr   string
+	}{
+		{name: "fresh clone", healthy: true, wantApply: true},
+		{name: "restart after apply", healthy: true, wantApply: false},
+		{
+			name: "restart after interrupted apply",
+			// A crash before the handlers finished leaves no record
+			prepare:   func() { os.Remove(filepath.Join(checkout, "applied-commit")) },
+			healthy:   true,
+			wantApply: true,
+		},
+		{
+			name:      "unhealthy after apply",
+			prepare:   func() { os.Remove(filepath.Join(checkout, "applied-commit")) },
+			healthy:   false,
+			wantApply: true,
+			wantErr:   "device unhealthy after update",
+		},
+		{name: "restart after unhealthy apply", healthy: true, wantApply: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.prepare != nil {
+				tt.prepare()
+			}
+
+			config := laptop.RolloutConfig("device-1")
+			config.TenantID = "acme"
+			rm, err := rollout.NewManager(
+				rollout.WithConfig(config),
+				rollout.WithLogger(log.New(io.Discard, "", 0)),
+			)
+			if err != nil {
+				t.Fatalf("NewManager: %v", err)
+			}
+			defer rm.Close()
+
+			handler := &recordingHandler{}
+			rm.RegisterUpdateHandler(handler)
+			rm.RegisterHealthCheck(healthCheck(tt.healthy))
+
+			gr, err := rm.StartGitOpsMode(rollout.GitOpsConfig{
+				RepoURL:           origin,
+				BranchPrefix:      "edge-",
+				CheckoutPath:      checkout,
+				ReconcileInterval: time.Hour,
+			})
+			if err != nil {
+				t.Fatalf("StartGitOpsMode: %v", err)
+			}
+			defer gr.Close()
+
+			err = gr.Reconcile()
+			if tt.wantErr == "" && err != nil {
+				t.Fatalf("Reconcile: %v", err)
+			}
+			if tt.wantErr != "" && (err == nil || err.Error() != tt.wantErr) {
+				t.Fatalf("Reconcile error = %v, want %q", err, tt.wantErr)
+			}
+
+			applied := len(handler.applied) > 0
+			if applied != tt.wantApply {
+				t.Errorf("applied = %v, want %v", handler.applied, tt.wantApply)
+			}
+			if applied && handler.applied[0] != head {
+				t.Errorf("applied %s, want %s", handler.applied[0], head)
+			}
+
+			want := head
+			if tt.wantErr != "" {
+				want = ""
+			}
+			if got := gr.AppliedCommit(); got != want {
+				t.Errorf("AppliedCommit() = %q, want %q", got, want)
+			}
+
+			// The commit is reported on the tenant's device record only
+			devices := laptop.Dynamo.Items(testkit.DeviceTable)
+			if len(devices) != 1 {
+				t.Fatalf("device table has %d items, want 1", len(devices))
+			}
+			if id := devices[0]["DeviceID"].(*types.AttributeValueMemberS).Value; id != tenant.Key("acme", "device-1") {
+				t.Errorf("DeviceID = %s, want %s", id, tenant.Key("acme", "device-1"))
+			}
+			if applied && tt.wantErr == "" {
+				reported, _ := devices[0]["AppliedCommit"].(*types.AttributeValueMemberS)
+				if reported == nil || reported.Value != head {
+					t.Errorf("AppliedCommit attribute = %v, want %s", reported, head)
+				}
+			}
+		})
+	}
+}
+
+// git runs a git command in dir and returns its trimmed output
+func git(t *testing.T, dir string, args ...string) string {
+	t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = dir
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("git %s: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
@@ -0,0 +1,624 @@
+package rollout
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/attestation"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/backoff"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/transfer"
+)
+
+// GRPCAgent receives rollout commands from the fleet server over a gRPC
+// stream instead of reading DynamoDB and S3 directly, so the device needs no
+// AWS credentials. It reuses the RolloutManager's handler interfaces.
+type GRPCAgent struct {
+	conn              *grpc.ClientConn
+	hello             agentproto.Hello
+	updateBasePath    string
+	versionFile       string
+	configFile        string // records the version applied by the last config rollout
+	httpClient        *http.Client
+	transfer          *transfer.Options // parallel ranged downloads; nil streams packages
+	capabilities      *Capabilities     // advertised in the hello when set
+	attestation       *AttestationConfig
+	updateHandlers    []UpdateHandler
+	preApplyHooks     []PreApplyHook
+	configAppliers    []ConfigApplier
+	healthChecks      []HealthCheck
+	heartbeatInterval time.Duration
+	reconnectInterval time.Duration
+	stream            agentproto.FleetAgentConnectClient
+	streamMutex       sync.Mutex
+	commandMutex      sync.Mutex
+	downloaded        int64  // package bytes fetched for the command being handled
+	applying          string // rollout whose update is being applied
+	cancelApply       context.CancelCauseFunc
+	applyMutex        sync.Mutex
+	statusSequence    *statusSequence // numbers status reports so the server can drop stale ones
+	ctx               context.Context
+	cancel            context.CancelFunc
+}
+
+// GRPCAgentConfig contains configuration for the GRPCAgent
+type GRPCAgentConfig struct {
+	ServerAddr        string
+	TLSConfig         *tls.Config // client certificate identifies the device when the server requires it
+	DeviceID          string
+	TenantID          string
+	DeviceGroup       string
+	Region            string
+	Timezone          string // IANA name, e.g. Europe/Berlin; defaults to UTC for business hours
+	MaintenanceWindow string // "HH:MM-HH:MM" local time; replaces rollout phases' windows
+	DeviceTags        map[string]string
+	AgentVersion      string
+	UpdateBasePath    string
+	HeartbeatInterval time.Duration
+	ReconnectInterval time.Duration
+	Transfer          *transfer.Options // downloads packages larger than one part as parallel byte ranges
+	Architecture      string            // selects multi-architecture packages; defaults to LocalArchitecture()
+
+	// Capabilities, when set, advertises the registered handler types and
+	// these package formats and schema versions, so the server only offers
+	// rollouts the agent can install
+	Capabilities *Capabilities
+
+	// Attestation, when set, attaches a TPM quote to every status report for
+	// the fleet server's AttestationVerifier, as WithAttestation does for
+	// polling devices; Required also attests before each update is applied
+	Attestation *AttestationConfig
+}
+
+// NewGRPCAgent creates a new GRPCAgent; call Run to connect
+func NewGRPCAgent(config GRPCAgentConfig) (*GRPCAgent, error) {
+	if err := os.MkdirAll(config.UpdateBasePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create update directory: %w", err)
+	}
+
+	if config.HeartbeatInterval == 0 {
+		config.HeartbeatInterval = 30 * time.Second
+	}
+	if config.ReconnectInterval == 0 {
+		config.ReconnectInterval = 10 * time.Second
+	}
+	if config.Architecture == "" {
+		config.Architecture = LocalArchitecture()
+	}
+
+	conn, err := grpc.NewClient(config.ServerAddr, grpc.WithTransportCredentials(credentials.NewTLS(config.TLSConfig)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	return &GRPCAgent{
+		conn: conn,
+		hello: agentproto.Hello{
+			DeviceID:          config.DeviceID,
+			TenantID:          config.TenantID,
+			DeviceGroup:       config.DeviceGroup,
+			Region:            config.Region,
+			Timezone:          config.Timezone,
+			MaintenanceWindow: config.MaintenanceWindow,
+			Tags:              config.DeviceTags,
+			AgentVersion:      config.AgentVersion,
+			Architecture:      config.Architecture,
+		},
+		updateBasePath:    config.UpdateBasePath,
+		versionFile:       filepath.Join(config.UpdateBasePath, "current-version"),
+		configFile:        filepath.Join(config.UpdateBasePath, "current-config-version"),
+		httpClient:        &http.Client{Timeout: 10 * time.Minute},
+		transfer:          config.Transfer,
+		capabilities:      config.Capabilities,
+		attestation:       config.Attestation,
+		statusSequence:    newStatusSequence(config.UpdateBasePath),
+		updateHandlers:    make([]UpdateHandler, 0),
+		healthChecks:      make([]HealthCheck, 0),
+		heartbeatInterval: config.HeartbeatInterval,
+		reconnectInterval: config.ReconnectInterval,
+		ctx:               ctx,
+		cancel:            cancel,
+	}, nil
+}
+
+// RegisterUpdateHandler registers a handler for updates
+func (a *GRPCAgent) RegisterUpdateHandler(handler UpdateHandler) {
+	a.updateHandlers = append(a.updateHandlers, handler)
+}
+
+// RegisterConfigApplier registers an applier for config-only rollouts
+func (a *GRPCAgent) RegisterConfigApplier(applier ConfigApplier) {
+	a.configAppliers = append(a.configAppliers, applier)
+}
+
+// RegisterHealthCheck registers a health check
+func (a *GRPCAgent) RegisterHealthCheck(check HealthCheck) {
+	a.healthChecks = append(a.healthChecks, check)
+}
+
+// handlerTypes returns the handler types registered with the agent
+func (a *GRPCAgent) handlerTypes() []string {
+	handlers := make([]string, 0, 2)
+	if len(a.updateHandlers) > 0 {
+		handlers = append(handlers, HandlerPackage)
+	}
+	if len(a.configAppliers) > 0 {
+		handlers = append(handlers, HandlerConfig)
+	}
+	return handlers
+}
+
+// Run keeps a stream to the fleet server open, reconnecting until Close is called
+func (a *GRPCAgent) Run() {
+	for a.ctx.Err() == nil {
+		if err := a.session(); err != nil && a.ctx.Err() == nil {
+			log.Printf("Fleet server stream ended: %v", err)
+		}
+
+		select {
+		case <-a.ctx.Done():
+		case <-time.After(a.reconnectInterval):
+		}
+	}
+}
+
+// Close disconnects from the fleet server
+func (a *GRPCAgent) Close() error {
+	a.cancel()
+	return a.conn.Close()
+}
+
+// ReportMetrics sends metrics over the stream, making the agent a TelemetryReporter
+func (a *GRPCAgent) ReportMetrics(metrics []string) error {
+	return a.send(&agentproto.AgentMessage{Metrics: &agentproto.Metrics{Values: metrics}})
+}
+
+// ReportSync sends the outcome of an offline sync, making the agent a SyncReporter
+func (a *GRPCAgent) ReportSync(succeeded bool, pendingChanges int, message string) error {
+	event := &agentproto.SyncEvent{
+		Status:         agentproto.SyncSucceeded,
+		PendingChanges: pendingChanges,
+		Message:        message,
+	}
+	if !succeeded {
+		event.Status = agentproto.SyncFailed
+	}
+
+	return a.send(&agentproto.AgentMessage{Sync: event})
+}
+
+// session runs one stream until it fails
+func (a *GRPCAgent) session() error {
+	ctx, cancel := context.WithCancel(a.ctx)
+	defer cancel()
+
+	stream, err := agentproto.NewConnectStream(ctx, a.conn)
+	if err != nil {
+		return fmt.Errorf("failed to open stream: %w", err)
+	}
+
+	hello := a.hello
+	hello.CurrentVersion = readVersion(a.versionFile)
+	hello.ConfigVersion = readVersion(a.configFile)
+	if a.capabilities != nil {
+		hello.Handlers = a.handlerTypes()
+		hello.PackageFormats = a.capabilities.PackageFormats
+		hello.SchemaVersions = a.capabilities.SchemaVersions
+	}
+
+	a.streamMutex.Lock()
+	a.stream = stream
+	err = stream.Send(&agentproto.AgentMessage{Hello: &hello})
+	a.streamMutex.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to send hello: %w", err)
+	}
+
+	log.Printf("Connected to fleet server as %s (version %s)", hello.DeviceID, hello.CurrentVersion)
+
+	go a.heartbeatLoop(ctx)
+
+	for {
+		message, err := stream.Recv()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		if message.Command != nil {
+			go a.handleCommand(message.Command)
+		}
+	}
+}
+
+// heartbeatLoop reports liveness and health until the stream ends
+func (a *GRPCAgent) heartbeatLoop(ctx context.Context) {
+	ticker := time.NewTicker(a.heartbeatInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			healthy, err := a.performHealthChecks()
+			if err != nil {
+				log.Printf("Health check failed: %v", err)
+			}
+
+			heartbeat := &agentproto.Heartbeat{TimestampUnix: time.Now().Unix(), Healthy: healthy}
+			if err := a.send(&agentproto.AgentMessage{Heartbeat: heartbeat}); err != nil {
+				log.Printf("Failed to send heartbeat: %v", err)
+			}
+		}
+	}
+}
+
+// handleCommand executes a server command, reporting progress as it goes
+func (a *GRPCAgent) handleCommand(command *agentproto.Command) {
+	// An abort interrupts the command in flight, so it can't wait its turn
+	if command.Type == agentproto.CommandAbort {
+		a.abort(command.RolloutID)
+		return
+	}
+
+	a.commandMutex.Lock()
+	defer a.commandMutex.Unlock()
+
+	a.downloaded = 0
+
+	// Attestation and hooks refuse updates before anything is downloaded or applied
+	if command.Type == agentproto.CommandApplyUpdate || command.Type == agentproto.CommandApplyConfig {
+		claim := attestation.Claim{DeviceID: a.deviceKey(), RolloutID: command.RolloutID, Status: agentproto.StatusApplying}
+		if err := attestBeforeApply(a.attestation, claim); err != nil {
+			log.Printf("Update refused: %v", err)
+			a.reportStatus(command, agentproto.StatusFailed, err.Error())
+			return
+		}
+		if err := runPreApplyHooks(a.preApplyHooks, command.RolloutID, command.Version); err != nil {
+			log.Printf("Update refused: %v", err)
+			a.reportStatus(command, refusedStatus(err), err.Error())
+			return
+		}
+	}
+
+	switch command.Type {
+	case agentproto.CommandApplyUpdate:
+		err := a.applyUpdate(command)
+		if errors.Is(err, ErrRolloutAborted) {
+			log.Printf("Update aborted: %v", err)
+			a.reportStatus(command, agentproto.StatusAborted, err.Error())
+			return
+		}
+		if err != nil {
+			log.Printf("Failed to apply update: %v", err)
+			a.reportStatus(command, agentproto.StatusFailed, err.Error())
+
+			if err := a.rollbackUpdate(); err != nil {
+				log.Printf("Failed to rollback update: %v", err)
+				return
+			}
+			a.reportStatus(command, agentproto.StatusRolledBack, "")
+			return
+		}
+		a.reportStatus(command, agentproto.StatusSuccess, "")
+
+	case agentproto.CommandApplyConfig:
+		if err := a.applyConfig(command); err != nil {
+			log.Printf("Failed to apply config: %v", err)
+			a.reportStatus(command, agentproto.StatusFailed, err.Error())
+
+			if err := a.rollbackConfig(); err != nil {
+				log.Printf("Failed to rollback config: %v", err)
+				return
+			}
+			a.reportStatus(command, agentproto.StatusRolledBack, "")
+			return
+		}
+		a.reportStatus(command, agentproto.StatusSuccess, "")
+
+	case agentproto.CommandRollback:
+		if err := a.rollbackUpdate(); err != nil {
+			a.reportStatus(command, agentproto.StatusFailed, err.Error())
+			return
+		}
+		a.reportStatus(command, agentproto.StatusRolledBack, "")
+
+	default:
+		log.Printf("Ignoring unknown command type %q", command.Type)
+	}
+}
+
+// applyUpdate downloads, validates and applies an update package; an abort
+// command while it runs undoes what was applied and returns ErrRolloutAborted
+func (a *GRPCAgent) applyUpdate(command *agentproto.Command) error {
+	ctx, cancel := context.WithCancelCause(a.ctx)
+	a.applyMutex.Lock()
+	a.applying, a.cancelApply = command.RolloutID, cancel
+	a.applyMutex.Unlock()
+	defer func() {
+		a.applyMutex.Lock()
+		a.applying, a.cancelApply = "", nil
+		a.applyMutex.Unlock()
+		cancel(nil)
+	}()
+
+	a.reportStatus(command, agentproto.StatusDownloading, "")
+
+	packagePath, err := a.downloadUpdatePackage(ctx, command.PackageURL, command.PackageHash)
+	if err != nil {
+		return fmt.Errorf("failed to download update package: %w", err)
+	}
+
+	a.reportStatus(command, agentproto.StatusApplying, "")
+
+	if err := runHandlers(ctx, a.updateHandlers, packagePath, command.Version); err != nil {
+		return err
+	}
+
+	healthy, err := a.performHealthChecks()
+	if err != nil || !healthy {
+		return fmt.Errorf("health check failed after update: %w", err)
+	}
+
+	if err := aborted(ctx); err != nil {
+		return abortHandlers(a.updateHandlers, err, packagePath)
+	}
+
+	if err := os.WriteFile(a.versionFile, []byte(command.Version), 0644); err != nil {
+		log.Printf("Failed to record current version: %v", err)
+	}
+
+	return nil
+}
+
+// applyConfig applies the configuration of a config-only rollout
+func (a *GRPCAgent) applyConfig(command *agentproto.Command) error {
+	if len(a.configAppliers) == 0 {
+		return fmt.Errorf("no config applier registered")
+	}
+
+	a.reportStatus(command, agentproto.StatusApplying, "")
+
+	for _, applier := range a.configAppliers {
+		if err := applier.ApplyConfig(command.Version, []byte(command.ConfigPayload)); err != nil {
+			return fmt.Errorf("config application failed: %w", err)
+		}
+	}
+
+	healthy, err := a.performHealthChecks()
+	if err != nil || !healthy {
+		return fmt.Errorf("health check failed after config change: %w", err)
+	}
+
+	if err := os.WriteFile(a.configFile, []byte(command.Version), 0644); err != nil {
+		log.Printf("Failed to record config version: %v", err)
+	}
+
+	return nil
+}
+
+// rollbackConfig rolls back the last configuration change
+func (a *GRPCAgent) rollbackConfig() error {
+	for _, applier := range a.configAppliers {
+		if err := applier.RollbackConfig(); err != nil {
+			return fmt.Errorf("config rollback failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// downloadUpdatePackage fetches a package from a presigned HTTPS URL and verifies its hash
+func (a *GRPCAgent) downloadUpdatePackage(ctx context.Context, packageURL, expectedHash string) (string, error) {
+	packageName := filepath.Base(strings.SplitN(packageURL, "?", 2)[0])
+	packagePath := filepath.Join(a.updateBasePath, packageName)
+
+	if a.transfer != nil {
+		size, err := transfer.ProbeSize(ctx, a.httpClient, packageURL)
+		if err == nil && size > a.transfer.PartSize {
+			return a.downloadRanges(ctx, packageURL, size, packagePath, expectedHash)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, packageURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := a.httpClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to download package: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to download package: %s", resp.Status)
+	}
+
+	file, err := os.Create(packagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer file.Close()
+
+	written, hash, err := copyHashed(file, resp.Body)
+	a.downloaded += written
+	if abortErr := aborted(ctx); abortErr != nil {
+		file.Close()
+		os.Remove(packagePath)
+		return "", abortErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write package file: %w", err)
+	}
+
+	if hash != expectedHash {
+		os.Remove(packagePath)
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, expectedHash, hash)
+	}
+
+	return packagePath, nil
+}
+
+// downloadRanges downloads a large package as parallel byte ranges, each
+// retried on its own, and verifies its hash from the file
+func (a *GRPCAgent) downloadRanges(ctx context.Context, packageURL string, size int64, packagePath, expectedHash string) (string, error) {
+	file, err := os.Create(packagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return "", fmt.Errorf("failed to allocate package file: %w", err)
+	}
+
+	written, err := transfer.DownloadRanges(ctx, a.httpClient, packageURL, size, file, *a.transfer, backoff.Default())
+	a.downloaded += written
+	if abortErr := aborted(ctx); abortErr != nil {
+		file.Close()
+		os.Remove(packagePath)
+		return "", abortErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download package: %w", err)
+	}
+
+	hash, err := calculateFileHash(packagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to calculate package hash: %w", err)
+	}
+	if hash != expectedHash {
+		os.Remove(packagePath)
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, expectedHash, hash)
+	}
+
+	return packagePath, nil
+}
+
+// performHealthChecks runs all registered health checks
+func (a *GRPCAgent) performHealthChecks() (bool, error) {
+	for _, check := range a.healthChecks {
+		healthy, err := check.CheckHealth()
+		if err != nil {
+			return false, fmt.Errorf("health check error: %w", err)
+		}
+
+		if !healthy {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// abort cancels the update being applied for rolloutID, if any
+func (a *GRPCAgent) abort(rolloutID string) {
+	a.applyMutex.Lock()
+	defer a.applyMutex.Unlock()
+
+	if a.applying != rolloutID || a.cancelApply == nil {
+		log.Printf("Ignoring abort of rollout %s: no update in progress", rolloutID)
+		return
+	}
+	a.cancelApply(fmt.Errorf("%w: %s", ErrRolloutAborted, rolloutID))
+}
+
+// rollbackUpdate rolls back to the previous version
+func (a *GRPCAgent) rollbackUpdate() error {
+	for _, handler := range a.updateHandlers {
+		if err := handler.RollbackUpdate(); err != nil {
+			return fmt.Errorf("rollback failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// reportStatus sends command progress to the fleet server
+func (a *GRPCAgent) reportStatus(command *agentproto.Command, status, message string) {
+	update := &agentproto.UpdateStatus{
+		CommandID: command.ID,
+		RolloutID: command.RolloutID,
+		Version:   command.Version,
+		Status:    status,
+		Message:   message,
+		Config:    command.Type == agentproto.CommandApplyConfig,
+	}
+
+	// Final statuses carry the transfer so the server can account for its cost
+	switch status {
+	case agentproto.StatusSuccess, agentproto.StatusFailed, agentproto.StatusRolledBack:
+		update.BytesDownloaded = a.downloaded
+	}
+
+	sequence, err := a.statusSequence.next(time.Now())
+	if err != nil {
+		log.Printf("Failed to record status sequence: %v", err)
+	}
+	update.Sequence = sequence
+
+	// A TPM quote bound to this report lets the fleet verify the device's boot chain
+	if a.attestation != nil {
+		update.Attestation, update.AttestationError = quoteStatus(a.attestation.Attestor, attestation.Claim{
+			DeviceID:  a.deviceKey(),
+			RolloutID: command.RolloutID,
+			Status:    status,
+			Sequence:  sequence,
+		})
+		if update.AttestationError != "" {
+			log.Printf("Failed to attest status %s for rollout %s: %s", status, command.RolloutID, update.AttestationError)
+		}
+	}
+
+	if err := a.send(&agentproto.AgentMessage{Status: update}); err != nil {
+		log.Printf("Failed to report update status %s: %v", status, err)
+	}
+}
+
+// deviceKey returns the device's key in the device table, which quotes claim
+func (a *GRPCAgent) deviceKey() string {
+	return tenant.Key(a.hello.TenantID, a.hello.DeviceID)
+}
+
+// send writes a message to the current stream
+func (a *GRPCAgent) send(message *agentproto.AgentMessage) error {
+	a.streamMutex.Lock()
+	defer a.streamMutex.Unlock()
+
+	if a.stream == nil {
+		return fmt.Errorf("not connected to fleet server")
+	}
+
+	return a.stream.Send(message)
+}
+
+// Helper functions
+
+// readVersion reads a version recorded after a successful update or config change
+func readVersion(path string) string {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
@@ -0,0 +1,186 @@
+package rollout
+
+import (
+	"fmt"
+	"strings"
+)
+
+// How a HealthPolicy combines the checks that are neither critical nor advisory
+const (
+	HealthModeAll      = "all"      // every check must pass; the default
+	HealthModeWeighted = "weighted" // the passing checks' share of the weight must reach MinScore
+	HealthModeQuorum   = "quorum"   // at least Quorum checks must pass
+)
+
+// HealthPolicy decides whether a device is healthy after an update from its
+// named health checks. Critical checks must pass in every mode; advisory
+// checks only warn.
+type HealthPolicy struct {
+	Mode     string             `json:"mode,omitempty" dynamodbav:"Mode,omitempty"`
+	Weights  map[string]float64 `json:"weights,omitempty" dynamodbav:"Weights,omitempty"`   // by check name; unlisted checks weigh 1
+	MinScore float64            `json:"minScore,omitempty" dynamodbav:"MinScore,omitempty"` // 0-1, for weighted
+	Quorum   int                `json:"quorum,omitempty" dynamodbav:"Quorum,omitempty"`     // for quorum
+	Critical []string           `json:"critical,omitempty" dynamodbav:"Critical,omitempty"`
+	Advisory []string           `json:"advisory,omitempty" dynamodbav:"Advisory,omitempty"`
+}
+
+// Validate checks the policy's mode and parameters
+func (p HealthPolicy) Validate() error {
+	switch p.Mode {
+	case "", HealthModeAll:
+	case HealthModeWeighted:
+		if p.MinScore <= 0 || p.MinScore > 1 {
+			return fmt.Errorf("weighted health policy needs a minScore between 0 and 1")
+		}
+	case HealthModeQuorum:
+		if p.Quorum <= 0 {
+			return fmt.Errorf("quorum health policy needs a positive quorum")
+		}
+	default:
+		return fmt.Errorf("health policy mode must be %s, %s or %s", HealthModeAll, HealthModeWeighted, HealthModeQuorum)
+	}
+
+	for name, weight := range p.Weights {
+		if weight < 0 {
+			return fmt.Errorf("negative weight for health check %s", name)
+		}
+	}
+	for _, name := range p.Critical {
+		if contains(p.Advisory, name) {
+			return fmt.Errorf("health check %s can't be both critical and advisory", name)
+		}
+	}
+
+	return nil
+}
+
+// HealthResult is the outcome of a health policy over the device's checks
+type HealthResult struct {
+	Healthy  bool
+	Mode     string
+	Passed   int      // counted checks that passed
+	Total    int      // counted checks: neither advisory nor critical
+	Score    float64  // weighted mode: passing share of the weight
+	Failed   []string // failed critical and counted checks
+	Warnings []string // failed advisory checks
+}
+
+// Summary describes the result for status reports, e.g.
+// "health weighted 0.75 (3/4 passed); failed: db; advisory failed: disk"
+func (r HealthResult) Summary() string {
+	summary := fmt.Sprintf("health %s (%d/%d passed)", r.Mode, r.Passed, r.Total)
+	if r.Mode == HealthModeWeighted {
+		summary = fmt.Sprintf("health %s %.2f (%d/%d passed)", r.Mode, r.Score, r.Passed, r.Total)
+	}
+	if len(r.Failed) > 0 {
+		summary += "; failed: " + strings.Join(r.Failed, ", ")
+	}
+	if len(r.Warnings) > 0 {
+		summary += "; advisory failed: " + strings.Join(r.Warnings, ", ")
+	}
+	return summary
+}
+
+// RegisterNamedHealthCheck registers a health check that health policies
+// refer to by name; RegisterHealthCheck names checks "check-1", "check-2"...
+func (rm *RolloutManager) RegisterNamedHealthCheck(name string, check HealthCheck) {
+	rm.healthChecks = append(rm.healthChecks, check)
+	rm.healthCheckNames = append(rm.healthCheckNames, name)
+}
+
+// checkRolloutHealth runs the health checks under the rollout's policy,
+// keeping the result for the status report; it returns an error unless the
+// device is healthy
+func (rm *RolloutManager) checkRolloutHealth(rollout *RolloutPlan) error {
+	var policy HealthPolicy
+	if rollout.HealthPolicy != nil {
+		policy = *rollout.HealthPolicy
+	}
+
+	result := rm.evaluateHealth(policy)
+	rm.rolloutMutex.Lock()
+	rm.lastHealth = result.Summary()
+	rm.rolloutMutex.Unlock()
+
+	for _, warning := range result.Warnings {
+		rm.logger.Printf("Advisory health check %s failed after update to %s", warning, rollout.Version)
+	}
+	if !result.Healthy {
+		return fmt.Errorf("health check failed after update: %s", result.Summary())
+	}
+	return nil
+}
+
+// healthSummary returns the summary of the last policy evaluation
+func (rm *RolloutManager) healthSummary() string {
+	rm.rolloutMutex.RLock()
+	defer rm.rolloutMutex.RUnlock()
+	return rm.lastHealth
+}
+
+// evaluateHealth runs every health check once and applies the policy; a
+// check that returns an error counts as failed
+func (rm *RolloutManager) evaluateHealth(policy HealthPolicy) HealthResult {
+	result := HealthResult{Healthy: true, Mode: policy.Mode}
+	if result.Mode == "" {
+		result.Mode = HealthModeAll
+	}
+
+	totalWeight, passedWeight := 0.0, 0.0
+	for i, check := range rm.healthChecks {
+		name := rm.healthCheckNames[i]
+		healthy, err := check.CheckHealth()
+		if err != nil {
+			rm.logger.Printf("Health check %s error: %v", name, err)
+			healthy = false
+		}
+
+		switch {
+		case contains(policy.Advisory, name):
+			if !healthy {
+				result.Warnings = append(result.Warnings, name)
+			}
+			continue
+		case contains(policy.Critical, name):
+			if !healthy {
+				result.Failed = append(result.Failed, name)
+				result.Healthy = false
+			}
+			continue
+		}
+
+		weight := 1.0
+		if w, ok := policy.Weights[name]; ok {
+			weight = w
+		}
+		result.Total++
+		totalWeight += weight
+		if healthy {
+			result.Passed++
+			passedWeight += weight
+		} else {
+			result.Failed = append(result.Failed, name)
+		}
+	}
+
+	result.Score = 1
+	if totalWeight > 0 {
+		result.Score = passedWeight / totalWeight
+	}
+
+	switch result.Mode {
+	case HealthModeWeighted:
+		result.Healthy = result.Healthy && result.Score >= policy.MinScore
+	case HealthModeQuorum:
+		// A quorum above the number of checks needs all of them
+		quorum := policy.Quorum
+		if quorum > result.Total {
+			quorum = result.Total
+		}
+		result.Healthy = result.Healthy && result.Passed >= quorum
+	default:
+		result.Healthy = result.Healthy && result.Passed == result.Total
+	}
+
+	return result
+}
@@ -0,0 +1,71 @@
+package rollout
+
+import (
+	"time"
+)
+
+// LocalStatus is what the rollout manager knows about its device's updates
+// without reading the device table, so it is available while the site is
+// cut off from the cloud
+type LocalStatus struct {
+	DeviceID             string     `json:"deviceId"`
+	RolloutID            string     `json:"rolloutId,omitempty"` // active rollout found by the last check
+	Version              string     `json:"version,omitempty"`   // the active rollout's version
+	Applying             bool       `json:"applying"`
+	AwaitingConfirmation bool       `json:"awaitingConfirmation"`
+	UpdateRolloutID      string     `json:"updateRolloutId,omitempty"`
+	UpdateStatus         string     `json:"updateStatus,omitempty"` // last status reported, e.g. success or failed
+	UpdateTime           *time.Time `json:"updateTime,omitempty"`
+	Health               string     `json:"health,omitempty"` // summary of the last health policy evaluation
+	LastCheck            time.Time  `json:"lastCheck"`
+	DegradedSince        *time.Time `json:"degradedSince,omitempty"` // running on the last fetched plan since; see WithDegradedMode
+	PushConnected        bool       `json:"pushConnected,omitempty"` // rollout notices arrive by long poll; see WithLongPoll
+}
+
+// localReport is the last update status the manager reported
+type localReport struct {
+	RolloutID string
+	Status    string
+	Time      time.Time
+}
+
+// LocalStatus returns the device's update state as the manager last saw it
+func (rm *RolloutManager) LocalStatus() LocalStatus {
+	status := LocalStatus{DeviceID: rm.deviceID}
+
+	rm.rolloutMutex.RLock()
+	if rm.currentRollout != nil {
+		status.RolloutID = rm.currentRollout.ID
+		status.Version = rm.currentRollout.Version
+	}
+	if rm.lastReport.Status != "" {
+		reported := rm.lastReport.Time
+		status.UpdateRolloutID = rm.lastReport.RolloutID
+		status.UpdateStatus = rm.lastReport.Status
+		status.UpdateTime = &reported
+	}
+	status.Health = rm.lastHealth
+	status.LastCheck = rm.lastCheckTime
+	rm.rolloutMutex.RUnlock()
+
+	rm.applyMutex.Lock()
+	status.Applying = rm.applying != ""
+	rm.applyMutex.Unlock()
+
+	_, _, status.AwaitingConfirmation = rm.PendingConfirmation()
+
+	if degraded, since := rm.Degraded(); degraded {
+		status.DegradedSince = &since
+	}
+	status.PushConnected = rm.PushConnected()
+
+	return status
+}
+
+// recordReport keeps a reported status for LocalStatus, whether or not the
+// report reaches the device table
+func (rm *RolloutManager) recordReport(rolloutID, status string) {
+	rm.rolloutMutex.Lock()
+	rm.lastReport = localReport{RolloutID: rolloutID, Status: status, Time: rm.clock.Now().UTC()}
+	rm.rolloutMutex.Unlock()
+}
@@ -0,0 +1,227 @@
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// LongPollResponse is the fleet server's answer to a long poll for rollout
+// notices. Cursor goes in the next poll; Reset means the server can't tell
+// what changed since the cursor, e.g. after it restarted, so the device
+// should check the rollout table.
+type LongPollResponse struct {
+	Cursor  string          `json:"cursor"`
+	Notices []RolloutNotice `json:"notices"`
+	Reset   bool            `json:"reset,omitempty"`
+}
+
+// LongPollConfig configures WithLongPoll
+type LongPollConfig struct {
+	URL           string        // fleet server base URL, e.g. https://fleet.example.com
+	APIKey        string        // sent in X-API-Key; a viewer key suffices
+	HTTPClient    *http.Client  // defaults to one with a timeout above WaitTime
+	WaitTime      time.Duration // how long the server holds a poll open; defaults to 25 seconds
+	RetryInterval time.Duration // pause after a failed poll; defaults to 30 seconds
+	PushInterval  time.Duration // table polling interval while no rollout is active and the channel is up; defaults to an hour
+}
+
+// longPoll keeps a long poll against the fleet server open and checks for
+// updates whenever a notice for the device's groups arrives
+type longPoll struct {
+	url           string
+	apiKey        string
+	httpClient    *http.Client
+	waitTime      time.Duration
+	retryInterval time.Duration
+	groups        []string // dynamic groups from the last device record
+	cursor        string
+	connected     bool
+	mutex         sync.Mutex
+	cancel        context.CancelFunc
+	done          chan struct{}
+}
+
+// newLongPoll creates a long poll from a validated configuration, with defaults
+func newLongPoll(config LongPollConfig) *longPoll {
+	if config.WaitTime == 0 {
+		config.WaitTime = 25 * time.Second
+	}
+	if config.RetryInterval == 0 {
+		config.RetryInterval = 30 * time.Second
+	}
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: config.WaitTime + 30*time.Second}
+	}
+
+	return &longPoll{
+		url:           strings.TrimSuffix(config.URL, "/") + "/api/rollout-notices",
+		apiKey:        config.APIKey,
+		httpClient:    config.HTTPClient,
+		waitTime:      config.WaitTime,
+		retryInterval: config.RetryInterval,
+		done:          make(chan struct{}),
+	}
+}
+
+// startLongPoll runs the long poll until the manager is closed
+func (rm *RolloutManager) startLongPoll() {
+	ctx, cancel := context.WithCancel(context.Background())
+	rm.longPoll.cancel = cancel
+
+	go rm.runLongPoll(ctx)
+}
+
+// runLongPoll polls for notices, falling back to table polling at the
+// configured intervals while the fleet server can't be reached
+func (rm *RolloutManager) runLongPoll(ctx context.Context) {
+	lp := rm.longPoll
+	defer close(lp.done)
+
+	for ctx.Err() == nil {
+		response, err := lp.poll(ctx, rm.deviceGroup, rm.tenantID)
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			rm.setPushConnected(false, err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(lp.retryInterval):
+			}
+			continue
+		}
+		rm.setPushConnected(true, nil)
+
+		switch {
+		case response.Reset:
+			// Spread the checks of devices reconnecting after a server restart
+			delay := time.Duration(rand.Int63n(int64(lp.retryInterval) + 1))
+			rm.logger.Printf("Rollout notices reset; checking for updates in %s", delay.Round(time.Second))
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			rm.CheckNow()
+
+		case len(response.Notices) > 0:
+			rm.logger.Printf("Rollout %s changed; checking for updates", response.Notices[len(response.Notices)-1].RolloutID)
+			rm.CheckNow()
+		}
+	}
+}
+
+// poll sends one long poll and returns the server's answer, moving the cursor on
+func (lp *longPoll) poll(ctx context.Context, deviceGroup, tenantID string) (*LongPollResponse, error) {
+	lp.mutex.Lock()
+	query := url.Values{}
+	query.Add("group", deviceGroup)
+	for _, group := range lp.groups {
+		query.Add("group", group)
+	}
+	query.Set("wait", lp.waitTime.String())
+	if lp.cursor != "" {
+		query.Set("cursor", lp.cursor)
+	}
+	lp.mutex.Unlock()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, lp.url+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create long poll request: %w", err)
+	}
+	if lp.apiKey != "" {
+		req.Header.Set("X-API-Key", lp.apiKey)
+	}
+	if tenantID != "" {
+		req.Header.Set(tenant.Header, tenantID)
+	}
+
+	resp, err := lp.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to poll for rollout notices: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("rollout notices returned status %d", resp.StatusCode)
+	}
+
+	var response LongPollResponse
+	if err := json.NewDecoder(resp.Body).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to decode rollout notices: %w", err)
+	}
+
+	lp.mutex.Lock()
+	lp.cursor = response.Cursor
+	lp.mutex.Unlock()
+
+	return &response, nil
+}
+
+// setGroups records the device's dynamic groups for the next poll
+func (lp *longPoll) setGroups(groups []string) {
+	lp.mutex.Lock()
+	defer lp.mutex.Unlock()
+
+	lp.groups = groups
+}
+
+// setPushConnected records whether the long poll works, logging changes.
+// Table polling slows down from the next check while it does, and speeds up
+// again at once when it stops.
+func (rm *RolloutManager) setPushConnected(connected bool, cause error) {
+	lp := rm.longPoll
+
+	lp.mutex.Lock()
+	changed := lp.connected != connected
+	lp.connected = connected
+	lp.mutex.Unlock()
+
+	if !changed {
+		return
+	}
+
+	if connected {
+		rm.logger.Printf("Rollout notices connected; polling the rollout table as a fallback only")
+	} else {
+		rm.logger.Printf("Rollout notices unavailable; polling the rollout table: %v", cause)
+	}
+	rm.polls.setPushed(connected)
+
+	// Bring a waiting check forward; a running one reschedules itself when done
+	if !connected && rm.checkTimer.Stop() {
+		rm.checkTimer.Reset(rm.polls.next())
+	}
+}
+
+// PushConnected reports whether the manager currently receives rollout
+// notices by long poll
+func (rm *RolloutManager) PushConnected() bool {
+	if rm.longPoll == nil {
+		return false
+	}
+
+	rm.longPoll.mutex.Lock()
+	defer rm.longPoll.mutex.Unlock()
+	return rm.longPoll.connected
+}
+
+// stopLongPoll ends the long poll and waits for it to return
+func (rm *RolloutManager) stopLongPoll() {
+	if rm.longPoll == nil || rm.longPoll.cancel == nil {
+		return
+	}
+	rm.longPoll.cancel()
+	<-rm.longPoll.done
+}
@@ -0,0 +1,35 @@
+package rollout
+
+import "time"
+
+// WindowFor returns the device-local hours the phase may update a device in:
+// the device's own maintenance window when it has one, otherwise the phase's
+// Window. Empty means any time.
+func (p RolloutPhase) WindowFor(maintenanceWindow string) string {
+	if maintenanceWindow != "" {
+		return maintenanceWindow
+	}
+	return p.Window
+}
+
+// ParseWindow parses a maintenance window, "HH:MM-HH:MM" in device-local
+// time, into minutes after midnight; "22:00-04:00" runs past midnight
+func ParseWindow(window string) (int, int, error) {
+	return parseClockRange("maintenance window", window)
+}
+
+// InWindow reports whether now falls within window on any day, in timezone
+// (an IANA name) or fallback when the timezone is empty or unknown. An empty
+// window is always open; an invalid one never is.
+func InWindow(window string, now time.Time, timezone string, fallback *time.Location) bool {
+	if window == "" {
+		return true
+	}
+
+	start, end, err := ParseWindow(window)
+	if err != nil {
+		return false
+	}
+
+	return inClockRange(now.In(location(timezone, fallback)), start, end)
+}
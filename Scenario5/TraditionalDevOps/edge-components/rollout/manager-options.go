@@ -0,0 +1,391 @@
+package rollout
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/backoff"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/transfer"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/validation"
+)
+
+// Logger receives the manager's log output; *log.Logger satisfies it
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// Clock tells the manager the time, so tests can control it
+type Clock interface {
+	Now() time.Time
+}
+
+// systemClock is the default Clock
+type systemClock struct{}
+
+func (systemClock) Now() time.Time { return time.Now() }
+
+// ManagerOption configures a RolloutManager built by NewManager
+type ManagerOption func(*managerOptions) error
+
+// managerOptions collects the options before the manager is built
+type managerOptions struct {
+	config     RolloutConfig
+	logger     Logger
+	clock      Clock
+	httpClient *http.Client
+	backoff    backoff.Policy
+	bandwidth  *bandwidth.Limiter
+	meter      *bandwidth.Meter
+	entitled   EntitlementChecker
+	attest     *AttestationConfig
+	transfer   *transfer.Options
+	retention  time.Duration
+	degraded   time.Duration
+	longPoll   *LongPollConfig
+	declared   *Capabilities
+	arch       string
+}
+
+// WithConfig sets the device identity, tables and polling configuration
+func WithConfig(config RolloutConfig) ManagerOption {
+	return func(o *managerOptions) error {
+		o.config = config
+		return nil
+	}
+}
+
+// WithLogger sends the manager's log output to logger instead of the standard logger
+func WithLogger(logger Logger) ManagerOption {
+	return func(o *managerOptions) error {
+		if logger == nil {
+			return errors.New("logger must not be nil")
+		}
+		o.logger = logger
+		return nil
+	}
+}
+
+// WithClock replaces the system clock
+func WithClock(clock Clock) ManagerOption {
+	return func(o *managerOptions) error {
+		if clock == nil {
+			return errors.New("clock must not be nil")
+		}
+		o.clock = clock
+		return nil
+	}
+}
+
+// WithHTTPClient sets the client used for http(s) package URLs, e.g. ones
+// served by a gateway proxy or an artifact CDN
+func WithHTTPClient(client *http.Client) ManagerOption {
+	return func(o *managerOptions) error {
+		if client == nil {
+			return errors.New("HTTP client must not be nil")
+		}
+		o.httpClient = client
+		return nil
+	}
+}
+
+// WithBackoff sets how package downloads are retried; backoff.None() disables retries
+func WithBackoff(policy backoff.Policy) ManagerOption {
+	return func(o *managerOptions) error {
+		if err := policy.Validate(); err != nil {
+			return err
+		}
+		o.backoff = policy
+		return nil
+	}
+}
+
+// WithBandwidth limits package downloads; share the limiter with the sync
+// manager to cap the agent's combined traffic
+func WithBandwidth(limiter *bandwidth.Limiter) ManagerOption {
+	return func(o *managerOptions) error {
+		o.bandwidth = limiter
+		return nil
+	}
+}
+
+// WithMeter counts package downloads against the device's billing period and
+// reports the meter on the device record. Once its cap is used up, only
+// critical rollouts are downloaded. Share the meter with the sync manager.
+func WithMeter(meter *bandwidth.Meter) ManagerOption {
+	return func(o *managerOptions) error {
+		o.meter = meter
+		return nil
+	}
+}
+
+// WithEntitlements gates rollouts that set RequiredFeature on the device's
+// license, normally an *entitlements.Enforcer. Without it those rollouts are
+// applied like any other.
+func WithEntitlements(checker EntitlementChecker) ManagerOption {
+	return func(o *managerOptions) error {
+		if checker == nil {
+			return errors.New("entitlement checker must not be nil")
+		}
+		o.entitled = checker
+		return nil
+	}
+}
+
+// WithAttestation attaches a TPM quote of the device's boot measurements to
+// every status report, so the fleet server can fence devices that fail
+// attestation. With Required set, updates are only applied after a
+// successful attestation.
+func WithAttestation(config AttestationConfig) ManagerOption {
+	return func(o *managerOptions) error {
+		if config.Attestor == nil {
+			return errors.New("attestor must not be nil")
+		}
+		for index, value := range config.ExpectedPCRs {
+			if decoded, err := hex.DecodeString(value); err != nil || len(decoded) != sha256.Size {
+				return fmt.Errorf("expected PCR %d is not a hex SHA-256 value", index)
+			}
+		}
+		o.attest = &config
+		return nil
+	}
+}
+
+// WithTransfer downloads large packages as concurrent ranged requests: s3://
+// packages with the S3 transfer manager, and http(s) packages larger than
+// one part from servers that support ranges. transfer.Default() suits most links.
+func WithTransfer(options transfer.Options) ManagerOption {
+	return func(o *managerOptions) error {
+		if err := options.Validate(); err != nil {
+			return err
+		}
+		o.transfer = &options
+		return nil
+	}
+}
+
+// WithDeviceRetention sets the device record's ExpiresAt TTL attribute to
+// retention after each status report, so the record of a decommissioned
+// device is deleted once it stops reporting. Use the fleet server's
+// LifecyclePolicy.InactiveDevices, which also covers gateway devices.
+func WithDeviceRetention(retention time.Duration) ManagerOption {
+	return func(o *managerOptions) error {
+		if retention < time.Hour {
+			return fmt.Errorf("device retention %s is shorter than an hour", retention)
+		}
+		o.retention = retention
+		return nil
+	}
+}
+
+// WithDegradedMode makes the manager fall back to the last plan it fetched
+// when the rollout or device table fails, e.g. under DynamoDB throttling, as
+// long as the plan was fetched within maxAge. The plan is kept in
+// UpdateBasePath, so the fallback also works after a restart.
+func WithDegradedMode(maxAge time.Duration) ManagerOption {
+	return func(o *managerOptions) error {
+		if maxAge <= 0 {
+			return errors.New("degraded mode max age must be positive")
+		}
+		o.degraded = maxAge
+		return nil
+	}
+}
+
+// WithLongPoll has the manager long-poll the fleet server for rollout
+// notices and check for updates as soon as one arrives. While the long poll
+// works, idle devices poll the rollout table only every PushInterval; when
+// it fails they fall back to their configured intervals until it recovers.
+func WithLongPoll(config LongPollConfig) ManagerOption {
+	return func(o *managerOptions) error {
+		if config.PushInterval == 0 {
+			config.PushInterval = time.Hour
+		}
+		if parsed, err := url.Parse(config.URL); err != nil || parsed.Host == "" {
+			return fmt.Errorf("invalid long poll URL %q", config.URL)
+		}
+		if config.WaitTime < 0 || config.WaitTime > 5*time.Minute {
+			return fmt.Errorf("long poll wait time %s must be at most 5 minutes", config.WaitTime)
+		}
+		if config.PushInterval < minCheckInterval || config.PushInterval > maxCheckInterval {
+			return fmt.Errorf("push interval %s must be between %s and %s", config.PushInterval, minCheckInterval, maxCheckInterval)
+		}
+		o.longPoll = &config
+		return nil
+	}
+}
+
+// WithCapabilities has the manager advertise what the device can install in
+// its device record and skip rollouts it can't: ones needing a handler type
+// that isn't registered, or a package format or schema version not declared
+// here. Handlers in capabilities are ignored; registrations decide them.
+func WithCapabilities(capabilities Capabilities) ManagerOption {
+	return func(o *managerOptions) error {
+		for handler, version := range capabilities.SchemaVersions {
+			if version < 0 {
+				return fmt.Errorf("negative schema version for %s handler", handler)
+			}
+		}
+		o.declared = &capabilities
+		return nil
+	}
+}
+
+// WithArchitecture sets the architecture the device reports and picks
+// multi-architecture packages for, instead of LocalArchitecture(); e.g.
+// "armv6" for an armv7 build running on older boards
+func WithArchitecture(architecture string) ManagerOption {
+	return func(o *managerOptions) error {
+		if architecture == "" {
+			return errors.New("architecture must not be empty")
+		}
+		o.arch = architecture
+		return nil
+	}
+}
+
+// NewManager creates a RolloutManager from options. Unset options default to
+// the standard logger, the system clock, an HTTP client with a 10 minute
+// timeout and backoff.Default(); the check interval defaults to 5 minutes.
+// An invalid configuration returns a *validation.Error listing every problem.
+func NewManager(opts ...ManagerOption) (*RolloutManager, error) {
+	o := &managerOptions{
+		logger:     log.Default(),
+		clock:      systemClock{},
+		httpClient: &http.Client{Timeout: 10 * time.Minute},
+		backoff:    backoff.Default(),
+		arch:       LocalArchitecture(),
+	}
+	for _, opt := range opts {
+		if err := opt(o); err != nil {
+			return nil, fmt.Errorf("invalid rollout manager option: %w", err)
+		}
+	}
+
+	config := o.config
+	if config.CheckInterval == 0 {
+		config.CheckInterval = 5 * time.Minute
+	}
+	if err := validateRolloutConfig(config); err != nil {
+		return nil, err
+	}
+
+	rm := &RolloutManager{
+		dynamoClient:       config.DynamoClient,
+		s3Client:           config.S3Client,
+		deviceID:           config.DeviceID,
+		tenantID:           config.TenantID,
+		deviceGroup:        config.DeviceGroup,
+		deviceTags:         config.DeviceTags,
+		rolloutTableName:   config.RolloutTableName,
+		deviceTableName:    config.DeviceTableName,
+		artifactTableName:  config.ArtifactTableName,
+		updateBasePath:     config.UpdateBasePath,
+		updateHandlers:     make([]UpdateHandler, 0),
+		bundleHandlers:     make(map[string][]UpdateHandler),
+		telemetryReporters: make([]TelemetryReporter, 0),
+		healthChecks:       make([]HealthCheck, 0),
+		checkInterval:      config.CheckInterval,
+		polls:              newPollSchedule(config),
+		verifier:           config.Verifier,
+		logger:             o.logger,
+		clock:              o.clock,
+		httpClient:         o.httpClient,
+		backoff:            o.backoff,
+		bandwidth:          o.bandwidth,
+		meter:              o.meter,
+		entitlements:       o.entitled,
+		attestation:        o.attest,
+		transfer:           o.transfer,
+		statusSequence:     newStatusSequence(config.UpdateBasePath),
+		deviceRetention:    o.retention,
+		declared:           o.declared,
+		architecture:       o.arch,
+	}
+	if o.transfer != nil && config.S3Client != nil {
+		rm.downloader = transfer.NewDownloader(config.S3Client, *o.transfer)
+	}
+	if o.degraded > 0 {
+		degraded, err := newDegradedMode(config.UpdateBasePath, o.degraded)
+		if err != nil {
+			rm.logger.Printf("Ignoring saved plan: %v", err)
+		}
+		rm.degraded = degraded
+	}
+	if o.longPoll != nil {
+		rm.longPoll = newLongPoll(*o.longPoll)
+		rm.polls.pushInterval = o.longPoll.PushInterval
+	}
+
+	// An update applied before a restart may still await confirmation; the
+	// first check resumes its deadline
+	confirmation, err := loadConfirmation(rm.confirmationPath())
+	if err != nil {
+		rm.logger.Printf("Ignoring pending confirmation: %v", err)
+	}
+	rm.confirmation = confirmation
+
+	// Start the check timer
+	rm.lastCheckTime = rm.clock.Now()
+	rm.checkTimer = time.AfterFunc(rm.polls.next(), rm.checkForUpdates)
+	if rm.longPoll != nil {
+		rm.startLongPoll()
+	}
+
+	return rm, nil
+}
+
+// Limits on the polling intervals, so a typo can't hammer DynamoDB or stall updates
+const (
+	minCheckInterval = 10 * time.Second
+	maxCheckInterval = 24 * time.Hour
+)
+
+// validateRolloutConfig checks every setting, returning a *validation.Error
+// listing all invalid fields
+func validateRolloutConfig(config RolloutConfig) error {
+	c := validation.NewChecker("RolloutConfig")
+
+	c.Check(config.DynamoClient != nil, "DynamoClient", "is required", "create one with dynamodb.NewFromConfig or agentconfig.Config.Clients")
+	c.Check(config.S3Client != nil, "S3Client", "is required to download s3:// packages", "create one with s3.NewFromConfig or agentconfig.Config.Clients")
+
+	switch {
+	case config.DeviceID == "":
+		c.Add("DeviceID", "is required", "set it to the device's unique ID")
+	case strings.ContainsAny(config.DeviceID, " \t\n/"+tenant.Separator):
+		c.Add("DeviceID", fmt.Sprintf("%q contains whitespace, '/' or %q", config.DeviceID, tenant.Separator), "use letters, digits, '-' or '_'")
+	}
+	if config.TenantID != "" {
+		c.Check(tenant.Validate(config.TenantID) == nil, "TenantID", fmt.Sprintf("%q is not a valid tenant ID", config.TenantID), "use up to 63 lowercase letters, digits or '-'")
+	}
+
+	c.TableName("RolloutTableName", config.RolloutTableName, true)
+	c.TableName("DeviceTableName", config.DeviceTableName, true)
+	c.TableName("ArtifactTableName", config.ArtifactTableName, false)
+	c.WritableDir("UpdateBasePath", config.UpdateBasePath)
+
+	bounds := fmt.Sprintf("use %s to %s, or 0 for the default", minCheckInterval, maxCheckInterval)
+	c.Check(config.CheckInterval >= minCheckInterval && config.CheckInterval <= maxCheckInterval,
+		"CheckInterval", fmt.Sprintf("%s is out of range", config.CheckInterval), bounds)
+	if config.IdleCheckInterval != 0 {
+		c.Check(config.IdleCheckInterval >= config.CheckInterval && config.IdleCheckInterval <= maxCheckInterval,
+			"IdleCheckInterval", fmt.Sprintf("%s is out of range", config.IdleCheckInterval), "use CheckInterval to "+maxCheckInterval.String()+", or 0 for 5x CheckInterval")
+	}
+	c.Check(config.PollJitter >= 0 && config.PollJitter < 1, "PollJitter", fmt.Sprintf("%g is out of range", config.PollJitter), "use 0 (the default, 0.2) to below 1")
+	c.Check(config.PlanCacheTTL >= 0, "PlanCacheTTL", "must not be negative", "use 0 for 2x CheckInterval")
+	c.Check(config.QueryBudget >= 0, "QueryBudget", "must not be negative", "use 0 for unlimited")
+	c.Check(config.MaxQueryPages >= 0, "MaxQueryPages", "must not be negative", "use 0 for the default, 10")
+	if config.QueryBudgetWindow != 0 {
+		c.Check(config.QueryBudgetWindow >= time.Minute, "QueryBudgetWindow", fmt.Sprintf("%s is too short", config.QueryBudgetWindow), "use at least 1m, or 0 for an hour")
+	}
+
+	return c.Err()
+}
@@ -0,0 +1,108 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"runtime/debug"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// ArchPackage is the package of a multi-architecture rollout for one CPU
+// architecture, named as LocalArchitecture names it: amd64, arm64, armv7
+type ArchPackage struct {
+	Architecture string `json:"architecture" dynamodbav:"Architecture"`
+	PackageURL   string `json:"packageUrl,omitempty" dynamodbav:"PackageURL,omitempty"`
+	PackageHash  string `json:"packageHash,omitempty" dynamodbav:"PackageHash,omitempty"`
+	ArtifactName string `json:"artifactName,omitempty" dynamodbav:"ArtifactName,omitempty"` // registry artifact, resolved at the plan's Version
+}
+
+// LocalArchitecture returns the architecture this binary runs on, with 32-bit
+// ARM qualified by its version, e.g. armv7
+func LocalArchitecture() string {
+	if runtime.GOARCH != "arm" {
+		return runtime.GOARCH
+	}
+
+	version := "7"
+	if info, ok := debug.ReadBuildInfo(); ok {
+		for _, setting := range info.Settings {
+			if setting.Key == "GOARM" && setting.Value != "" {
+				version = setting.Value[:1]
+			}
+		}
+	}
+	return "armv" + version
+}
+
+// IsMultiArch reports whether the plan delivers a package per architecture
+func (p RolloutPlan) IsMultiArch() bool {
+	return len(p.Packages) > 0
+}
+
+// PackageFor returns the package URL, hash and registry artifact name a
+// device of the architecture installs: the plan's own, or the matching
+// entry of Packages. It returns ErrIncompatible when no entry matches.
+func (p RolloutPlan) PackageFor(architecture string) (string, string, string, error) {
+	if !p.IsMultiArch() {
+		return p.PackageURL, p.PackageHash, p.ArtifactName, nil
+	}
+
+	for _, pkg := range p.Packages {
+		if pkg.Architecture == architecture {
+			return pkg.PackageURL, pkg.PackageHash, pkg.ArtifactName, nil
+		}
+	}
+	return "", "", "", fmt.Errorf("%w: no package for architecture %q", ErrIncompatible, architecture)
+}
+
+// SupportsArchitecture reports whether a device of the architecture has a
+// package in the plan; an unknown architecture is assumed supported
+func (p RolloutPlan) SupportsArchitecture(architecture string) bool {
+	if architecture == "" {
+		return true
+	}
+	_, _, _, err := p.PackageFor(architecture)
+	return err == nil
+}
+
+// checkArchitecture returns ErrIncompatible when a multi-architecture rollout
+// has no package for this device
+func (rm *RolloutManager) checkArchitecture(rollout *RolloutPlan) error {
+	if _, _, _, err := rollout.PackageFor(rm.architecture); err != nil {
+		return fmt.Errorf("rollout %s: %w", rollout.ID, err)
+	}
+	return nil
+}
+
+// reportArchitecture records the device's architecture in its record when
+// the record holds another, so the fleet server can tell which devices a
+// multi-architecture rollout covers
+func (rm *RolloutManager) reportArchitecture(deviceInfo *DeviceInfo) {
+	if deviceInfo.Architecture == rm.architecture {
+		return
+	}
+
+	result, err := rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+		TableName: aws.String(rm.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+		},
+		UpdateExpression:    aws.String("SET Architecture = :architecture"),
+		ConditionExpression: aws.String("attribute_exists(DeviceID)"),
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":architecture": &types.AttributeValueMemberS{Value: rm.architecture},
+		},
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		rm.logger.Printf("Failed to report architecture: %v", err)
+		return
+	}
+	rm.usage.addCapacity(result.ConsumedCapacity)
+}
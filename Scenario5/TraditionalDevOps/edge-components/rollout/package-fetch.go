@@ -0,0 +1,162 @@
+package rollout
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/transfer"
+)
+
+// fetchPackage downloads one attempt of a package to packagePath, from S3
+// for s3://bucket/key URLs and through the HTTP client for http(s) URLs. It
+// returns the package's SHA-256, hashed as it streams to disk, or "" if the
+// download was written out of order, by the transfer manager or as byte
+// ranges, and must be hashed from the file.
+func (rm *RolloutManager) fetchPackage(ctx context.Context, packageURL, packagePath string) (string, error) {
+	var body io.ReadCloser
+
+	switch {
+	case strings.HasPrefix(packageURL, "s3://"):
+		parts := strings.SplitN(strings.TrimPrefix(packageURL, "s3://"), "/", 2)
+		if len(parts) != 2 {
+			return "", fmt.Errorf("invalid S3 URL format: %s", packageURL)
+		}
+
+		if rm.downloader != nil {
+			return "", rm.fetchConcurrently(ctx, parts[0], parts[1], packagePath)
+		}
+
+		result, err := rm.s3Client.GetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(parts[0]),
+			Key:    aws.String(parts[1]),
+		})
+		if err != nil {
+			return "", fmt.Errorf("failed to download package: %w", err)
+		}
+		body = result.Body
+
+	case strings.HasPrefix(packageURL, "http://"), strings.HasPrefix(packageURL, "https://"):
+		// Large packages come down as parallel byte ranges when the server
+		// supports them
+		if rm.transfer != nil {
+			size, err := transfer.ProbeSize(ctx, rm.httpClient, packageURL)
+			if err == nil && size > rm.transfer.PartSize {
+				return "", rm.fetchRanges(ctx, packageURL, size, packagePath)
+			}
+		}
+
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, packageURL, nil)
+		if err != nil {
+			return "", fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := rm.httpClient.Do(req)
+		if err != nil {
+			return "", fmt.Errorf("failed to download package: %w", err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			resp.Body.Close()
+			return "", fmt.Errorf("failed to download package: %s", resp.Status)
+		}
+		body = resp.Body
+
+	default:
+		return "", fmt.Errorf("unsupported package URL: %s", packageURL)
+	}
+	defer body.Close()
+
+	// Create the file
+	file, err := os.Create(packagePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer file.Close()
+
+	// Copy the data, within the bandwidth limit, hashing it on the way
+	var reader io.Reader = body
+	if rm.bandwidth != nil {
+		reader = rm.bandwidth.Reader(ctx, body)
+	}
+	written, hash, err := copyHashed(file, reader)
+	rm.meterDownload(written)
+	if strings.HasPrefix(packageURL, "s3://") {
+		rm.usage.s3Bytes += written
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to write package file: %w", err)
+	}
+
+	return hash, nil
+}
+
+// fetchConcurrently downloads an S3 package with the transfer manager, as
+// concurrent ranged requests written at their offsets
+func (rm *RolloutManager) fetchConcurrently(ctx context.Context, bucket, key, packagePath string) error {
+	file, err := os.Create(packagePath)
+	if err != nil {
+		return fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer file.Close()
+
+	var writer io.WriterAt = file
+	if rm.bandwidth != nil {
+		writer = rm.bandwidth.WriterAt(ctx, file)
+	}
+	written, err := rm.downloader.Download(ctx, writer, &s3.GetObjectInput{
+		Bucket: aws.String(bucket),
+		Key:    aws.String(key),
+	})
+	rm.usage.s3Bytes += written
+	rm.meterDownload(written)
+	if err != nil {
+		return fmt.Errorf("failed to download package: %w", err)
+	}
+
+	return nil
+}
+
+// fetchRanges downloads an HTTP package as parallel byte ranges, each
+// retried on its own
+func (rm *RolloutManager) fetchRanges(ctx context.Context, packageURL string, size int64, packagePath string) error {
+	file, err := os.Create(packagePath)
+	if err != nil {
+		return fmt.Errorf("failed to create package file: %w", err)
+	}
+	defer file.Close()
+
+	if err := file.Truncate(size); err != nil {
+		return fmt.Errorf("failed to allocate package file: %w", err)
+	}
+
+	var writer io.WriterAt = file
+	if rm.bandwidth != nil {
+		writer = rm.bandwidth.WriterAt(ctx, file)
+	}
+	written, err := transfer.DownloadRanges(ctx, rm.httpClient, packageURL, size, writer, *rm.transfer, rm.backoff)
+	rm.meterDownload(written)
+	if err != nil {
+		return fmt.Errorf("failed to download package: %w", err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+// copyHashed copies src to dst and returns the SHA-256 of what was copied,
+// so a download is verified without reading the file back
+func copyHashed(dst io.Writer, src io.Reader) (int64, string, error) {
+	hash := sha256.New()
+	written, err := io.Copy(io.MultiWriter(dst, hash), src)
+	if err != nil {
+		return written, "", err
+	}
+	return written, fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
@@ -0,0 +1,77 @@
+package rollout
+
+import (
+	"fmt"
+	"time"
+)
+
+// What the fleet server's PhaseController does when a phase outlives its
+// Duration, set per plan in RolloutPlan.PhaseExpiry
+const (
+	// PhaseExpiryHold keeps the rollout in the phase until the next phase is
+	// approved; the final phase completes. This is the default.
+	PhaseExpiryHold = "hold"
+
+	// PhaseExpiryAdvance moves on to the next phase, or completes the rollout
+	PhaseExpiryAdvance = "advance"
+
+	// PhaseExpiryFail fails the rollout unless the phase reached its
+	// percentage, in which case it advances
+	PhaseExpiryFail = "fail"
+)
+
+// ValidPhaseExpiry reports whether expiry is a known PhaseExpiry value; empty
+// means PhaseExpiryHold
+func ValidPhaseExpiry(expiry string) bool {
+	switch expiry {
+	case "", PhaseExpiryHold, PhaseExpiryAdvance, PhaseExpiryFail:
+		return true
+	}
+	return false
+}
+
+// ExpiryAction returns the plan's phase expiry behaviour, applying the default
+func (p RolloutPlan) ExpiryAction() string {
+	if p.PhaseExpiry == "" {
+		return PhaseExpiryHold
+	}
+	return p.PhaseExpiry
+}
+
+// ParseDuration parses the phase Duration, e.g. "4h"; empty or zero means the
+// phase has no time limit
+func (p RolloutPhase) ParseDuration() (time.Duration, error) {
+	if p.Duration == "" {
+		return 0, nil
+	}
+
+	duration, err := time.ParseDuration(p.Duration)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration %q for phase %s: %w", p.Duration, p.ID, err)
+	}
+	if duration < 0 {
+		return 0, fmt.Errorf("negative duration %q for phase %s", p.Duration, p.ID)
+	}
+
+	return duration, nil
+}
+
+// ExpiresAt returns when the phase's Duration runs out, counted from its
+// StartTime or, after a pause, from ResumedAt. It returns false for phases
+// with no time limit or that haven't started.
+func (p RolloutPhase) ExpiresAt() (time.Time, bool) {
+	duration, err := p.ParseDuration()
+	if err != nil || duration == 0 {
+		return time.Time{}, false
+	}
+
+	start := p.StartTime
+	if p.ResumedAt.After(start) {
+		start = p.ResumedAt
+	}
+	if start.IsZero() {
+		return time.Time{}, false
+	}
+
+	return start.Add(duration), true
+}
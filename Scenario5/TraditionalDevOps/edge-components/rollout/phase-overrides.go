@@ -0,0 +1,37 @@
+package rollout
+
+// PhaseOverride replaces phase parameters for the devices of one group, e.g.
+// approval and stricter thresholds for "hospital" devices or a faster ramp
+// for "lab"; unset fields keep the phase's values
+type PhaseOverride struct {
+	Percentage      *float64           `json:"percentage,omitempty" dynamodbav:"Percentage,omitempty"`
+	RequireApproval *bool              `json:"requireApproval,omitempty" dynamodbav:"RequireApproval,omitempty"`
+	Thresholds      map[string]float64 `json:"thresholds,omitempty" dynamodbav:"Thresholds,omitempty"` // merged over the phase's thresholds
+}
+
+// ForGroup returns the phase as it applies to devices in group
+func (p RolloutPhase) ForGroup(group string) RolloutPhase {
+	override, ok := p.GroupOverrides[group]
+	if !ok {
+		return p
+	}
+
+	if override.Percentage != nil {
+		p.Percentage = *override.Percentage
+	}
+	if override.RequireApproval != nil {
+		p.RequireApproval = *override.RequireApproval
+	}
+	if len(override.Thresholds) > 0 {
+		thresholds := make(map[string]float64, len(p.Thresholds)+len(override.Thresholds))
+		for metric, threshold := range p.Thresholds {
+			thresholds[metric] = threshold
+		}
+		for metric, threshold := range override.Thresholds {
+			thresholds[metric] = threshold
+		}
+		p.Thresholds = thresholds
+	}
+
+	return p
+}
@@ -0,0 +1,132 @@
+package rollout
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/publisher"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// SignatureVerifier checks signatures made with the fleet's signing keys,
+// e.g. a keymanager.KeyRing kept up to date through the sync channel
+type SignatureVerifier interface {
+	// Verify returns an error unless signature is a valid signature over data by keyID
+	Verify(keyID string, data, signature []byte) error
+}
+
+// SigningPayload returns the bytes a plan signature covers: the fields that
+// decide what a device installs and which devices may install it, none of
+// which change as the rollout progresses
+func (p RolloutPlan) SigningPayload() []byte {
+	payload, _ := json.Marshal(struct {
+		ID           string `json:"id"`
+		TenantID     string `json:"tenantId"`
+		Version      string `json:"version"`
+		PackageURL   string `json:"packageUrl"`
+		PackageHash  string `json:"packageHash"`
+		ArtifactName string `json:"artifactName"`
+		ConfigHash   string `json:"configHash,omitempty"`
+
+		Artifacts       []BundleArtifact `json:"artifacts,omitempty"`
+		RequiredFeature string           `json:"requiredFeature,omitempty"`
+		Packages        []ArchPackage    `json:"packages,omitempty"`
+	}{p.ID, p.TenantID, p.Version, p.PackageURL, p.PackageHash, p.ArtifactName, configHash(p.ConfigPayload), p.Artifacts, p.RequiredFeature, p.Packages})
+	return payload
+}
+
+// verifyPlan rejects plans that are unsigned or not signed by a trusted key
+func (rm *RolloutManager) verifyPlan(rollout *RolloutPlan) error {
+	if rollout.Signature == "" {
+		return fmt.Errorf("rollout %s is not signed", rollout.ID)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(rollout.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode rollout signature: %w", err)
+	}
+
+	if err := rm.verifier.Verify(rollout.SigningKeyID, rollout.SigningPayload(), signature); err != nil {
+		return fmt.Errorf("rollout %s signature verification failed: %w", rollout.ID, err)
+	}
+
+	return nil
+}
+
+// verifyArtifact rejects registry artifacts that are unsigned or not signed by a trusted key
+func (rm *RolloutManager) verifyArtifact(artifact *publisher.Artifact) error {
+	_, name := tenant.Split(artifact.Name)
+	if artifact.Signature == "" {
+		return fmt.Errorf("artifact %s@%s is not signed", name, artifact.Version)
+	}
+
+	digest, err := hex.DecodeString(artifact.SHA256)
+	if err != nil {
+		return fmt.Errorf("invalid artifact digest: %w", err)
+	}
+
+	signature, err := base64.StdEncoding.DecodeString(artifact.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode artifact signature: %w", err)
+	}
+
+	// The publisher signs the raw SHA-256 digest
+	if err := rm.verifier.Verify(artifact.KeyID, digest, signature); err != nil {
+		return fmt.Errorf("artifact %s@%s signature verification failed: %w", name, artifact.Version, err)
+	}
+
+	return nil
+}
+
+// resolvePackage returns the package location for a rollout, resolving
+// registry artifacts and checking signatures when a verifier is configured
+func (rm *RolloutManager) resolvePackage(ctx context.Context, rollout *RolloutPlan) (string, string, error) {
+	if rm.verifier != nil {
+		if err := rm.verifyPlan(rollout); err != nil {
+			return "", "", err
+		}
+	}
+
+	// Multi-architecture rollouts carry a package per architecture
+	packageURL, packageHash, artifactName, err := rollout.PackageFor(rm.architecture)
+	if err != nil {
+		return "", "", err
+	}
+
+	if artifactName == "" {
+		return packageURL, packageHash, nil
+	}
+
+	return rm.resolveArtifact(ctx, artifactName, rollout.Version)
+}
+
+// resolveArtifact resolves a registry artifact to its immutable package
+// location, checking its signature when a verifier is configured
+func (rm *RolloutManager) resolveArtifact(ctx context.Context, name, version string) (string, string, error) {
+	artifact, err := publisher.Resolve(ctx, rm.dynamoClient, rm.artifactTableName, tenant.Key(rm.tenantID, name), version)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve artifact: %w", err)
+	}
+
+	if rm.verifier != nil {
+		if err := rm.verifyArtifact(artifact); err != nil {
+			return "", "", err
+		}
+	}
+
+	return artifact.URL, artifact.SHA256, nil
+}
+
+// Helper functions
+
+// configHash returns the SHA-256 of a config payload, or "" for package rollouts
+func configHash(payload string) string {
+	if payload == "" {
+		return ""
+	}
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(payload)))
+}
@@ -0,0 +1,47 @@
+package rollout
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/agentproto"
+)
+
+// PreApplyHook runs before a device applies an update and can refuse it, e.g.
+// a bootintegrity.BootChainVerifier that checks secure boot and dm-verity
+type PreApplyHook interface {
+	// PreApply returns an error to refuse the update; wrap ErrIntegrityFailed
+	// when the device's boot chain has been tampered with
+	PreApply(rolloutID, version string) error
+}
+
+// RegisterPreApplyHook registers a hook that runs before every update,
+// including config-only and bundle rollouts
+func (rm *RolloutManager) RegisterPreApplyHook(hook PreApplyHook) {
+	rm.preApplyHooks = append(rm.preApplyHooks, hook)
+}
+
+// RegisterPreApplyHook registers a hook that runs before every update and config command
+func (a *GRPCAgent) RegisterPreApplyHook(hook PreApplyHook) {
+	a.preApplyHooks = append(a.preApplyHooks, hook)
+}
+
+// runPreApplyHooks runs hooks in registration order, stopping at the first refusal
+func runPreApplyHooks(hooks []PreApplyHook, rolloutID, version string) error {
+	for _, hook := range hooks {
+		if err := hook.PreApply(rolloutID, version); err != nil {
+			return fmt.Errorf("update refused before applying: %w", err)
+		}
+	}
+	return nil
+}
+
+// refusedStatus is the status the gRPC agent reports for an update a hook refused:
+// integrity-failed for a tampered boot chain, so the fleet can tell it from
+// an update that failed to apply
+func refusedStatus(err error) string {
+	if errors.Is(err, ErrIntegrityFailed) {
+		return agentproto.StatusIntegrityFailed
+	}
+	return agentproto.StatusFailed
+}
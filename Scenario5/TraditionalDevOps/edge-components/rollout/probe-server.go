@@ -0,0 +1,195 @@
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// ProbeCheck reports an internal condition; a nil error is healthy
+type ProbeCheck func(ctx context.Context) error
+
+// ProbeResult is the body of a probe response
+type ProbeResult struct {
+	Status string            `json:"status"` // ok or failing
+	Checks map[string]string `json:"checks"` // check name -> ok or the error
+}
+
+// ProbeServer serves /healthz and /readyz for orchestrators running the agent
+// as a container or DaemonSet. Liveness checks should only fail when the
+// agent is wedged and a restart helps, e.g. its check loop stopped; readiness
+// checks cover dependencies, e.g. the local store and AWS.
+type ProbeServer struct {
+	httpServer  *http.Server
+	mux         *http.ServeMux
+	timeout     time.Duration
+	liveness    map[string]ProbeCheck
+	readiness   map[string]ProbeCheck
+	checksMutex sync.RWMutex
+}
+
+// ProbeServerConfig contains configuration for the ProbeServer
+type ProbeServerConfig struct {
+	ListenAddr string        // defaults to :8086
+	Timeout    time.Duration // per-check timeout; defaults to 5 seconds
+}
+
+// NewProbeServer creates a new ProbeServer
+func NewProbeServer(config ProbeServerConfig) *ProbeServer {
+	if config.ListenAddr == "" {
+		config.ListenAddr = ":8086"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 5 * time.Second
+	}
+
+	ps := &ProbeServer{
+		mux:       http.NewServeMux(),
+		timeout:   config.Timeout,
+		liveness:  make(map[string]ProbeCheck),
+		readiness: make(map[string]ProbeCheck),
+	}
+
+	ps.mux.HandleFunc("GET /healthz", ps.handler(ps.liveness))
+	ps.mux.HandleFunc("GET /readyz", ps.handler(ps.readiness))
+
+	ps.httpServer = &http.Server{
+		Addr:              config.ListenAddr,
+		Handler:           ps.mux,
+		ReadHeaderTimeout: 5 * time.Second,
+	}
+
+	return ps
+}
+
+// AddLivenessCheck registers a check served by /healthz
+func (ps *ProbeServer) AddLivenessCheck(name string, check ProbeCheck) {
+	ps.checksMutex.Lock()
+	defer ps.checksMutex.Unlock()
+	ps.liveness[name] = check
+}
+
+// AddReadinessCheck registers a check served by /readyz
+func (ps *ProbeServer) AddReadinessCheck(name string, check ProbeCheck) {
+	ps.checksMutex.Lock()
+	defer ps.checksMutex.Unlock()
+	ps.readiness[name] = check
+}
+
+// ServeLocalStatus serves the manager's LocalStatus at GET /rollout, for a
+// site health aggregator on the gateway
+func (ps *ProbeServer) ServeLocalStatus(rm *RolloutManager) {
+	ps.mux.HandleFunc("GET /rollout", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(rm.LocalStatus())
+	})
+}
+
+// ListenAndServe starts serving the probes
+func (ps *ProbeServer) ListenAndServe() error {
+	return ps.httpServer.ListenAndServe()
+}
+
+// Shutdown gracefully stops the server
+func (ps *ProbeServer) Shutdown(ctx context.Context) error {
+	return ps.httpServer.Shutdown(ctx)
+}
+
+// handler runs a set of checks concurrently and answers 200 or 503
+func (ps *ProbeServer) handler(checks map[string]ProbeCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		ps.checksMutex.RLock()
+		names := make([]string, 0, len(checks))
+		for name := range checks {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		errs := make([]error, len(names))
+
+		var wg sync.WaitGroup
+		for i, name := range names {
+			wg.Add(1)
+			go func(i int, check ProbeCheck) {
+				defer wg.Done()
+				ctx, cancel := context.WithTimeout(r.Context(), ps.timeout)
+				defer cancel()
+				errs[i] = check(ctx)
+			}(i, checks[name])
+		}
+		ps.checksMutex.RUnlock()
+		wg.Wait()
+
+		result := ProbeResult{Status: "ok", Checks: make(map[string]string, len(names))}
+		for i, name := range names {
+			result.Checks[name] = "ok"
+			if errs[i] != nil {
+				result.Status = "failing"
+				result.Checks[name] = errs[i].Error()
+			}
+		}
+
+		status := http.StatusOK
+		if result.Status != "ok" {
+			status = http.StatusServiceUnavailable
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(result)
+	}
+}
+
+// CheckLoop is a liveness check failing when the update check loop hasn't
+// run for maxAge, e.g. because a check is stuck; zero allows three idle
+// polling intervals
+func (rm *RolloutManager) CheckLoop(maxAge time.Duration) ProbeCheck {
+	if maxAge == 0 {
+		maxAge = 3 * rm.polls.idleInterval
+	}
+
+	return func(ctx context.Context) error {
+		rm.rolloutMutex.RLock()
+		last := rm.lastCheckTime
+		now := rm.clock.Now()
+		rm.rolloutMutex.RUnlock()
+
+		if age := now.Sub(last); age > maxAge {
+			return fmt.Errorf("check loop last ran %s ago", age.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// CheckAWS is a readiness check reading this device's record from DynamoDB
+func (rm *RolloutManager) CheckAWS() ProbeCheck {
+	return func(ctx context.Context) error {
+		_, err := rm.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+			TableName: aws.String(rm.deviceTableName),
+			Key: map[string]types.AttributeValue{
+				"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
+			},
+			ProjectionExpression: aws.String("DeviceID"),
+		})
+		if err != nil {
+			return fmt.Errorf("DynamoDB unreachable: %w", err)
+		}
+		return nil
+	}
+}
+
+// markCheckLoop records that the check loop is running
+func (rm *RolloutManager) markCheckLoop() {
+	rm.rolloutMutex.Lock()
+	rm.lastCheckTime = rm.clock.Now()
+	rm.rolloutMutex.Unlock()
+}
@@ -2,20 +2,27 @@ package rollout
 
 import (
 	"context"
-	"encoding/json"
+	"crypto/sha256"
+	"errors"
 	"fmt"
-	"log"
-	"math/rand"
+	"io"
+	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
 	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/google/uuid"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/backoff"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/bandwidth"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/transfer"
 )
 
 // RolloutPhase represents a phase in the progressive rollout
@@ -26,8 +33,16 @@ type RolloutPhase struct {
 	Duration        string    `json:"duration"`
 	RequireApproval bool      `json:"requireApproval"`
 	Approved        bool      `json:"approved"`
+	ApprovedBy      string    `json:"approvedBy"`
+	ApprovedAt      time.Time `json:"approvedAt"`
+	CanaryVerdict   string    `json:"canaryVerdict,omitempty"` // pass, fail or inconclusive, set by the fleet server
+	ResumedAt       time.Time `json:"resumedAt,omitempty"`     // last resume after a pause; earlier anomalies are acknowledged
 	Metrics         []string  `json:"metrics"`
-	Thresholds      map[string]float64 `json:"thresholds"`
+	Thresholds      map[string]float64 `json:"thresholds"` // tuning parameters such as failure_rate and canary_alpha; limits on metrics go in Gate
+	GroupOverrides  map[string]PhaseOverride `json:"groupOverrides,omitempty" dynamodbav:"GroupOverrides,omitempty"` // by device group
+	Regions         []string `json:"regions,omitempty" dynamodbav:"Regions,omitempty"` // opened by this phase and kept open after it
+	Window          string   `json:"window,omitempty" dynamodbav:"Window,omitempty"`   // "HH:MM-HH:MM" in each device's local time; a device's MaintenanceWindow replaces it
+	Gate            string   `json:"gate,omitempty" dynamodbav:"Gate,omitempty"`       // condition on the canary cohort's telemetry, e.g. "error_rate < 0.01"; see package gate
 }
 
 // RolloutPlan represents a complete progressive rollout plan
@@ -38,7 +53,7 @@ type RolloutPlan struct {
 	Version        string         `json:"version"`
 	CreatedAt      time.Time      `json:"createdAt"`
 	UpdatedAt      time.Time      `json:"updatedAt"`
-	Status         string         `json:"status"` // pending, in-progress, completed, failed, rolled-back
+	Status         string         `json:"status"` // pending, in-progress, paused, completed, failed, rolled-back, aborted
 	Phases         []RolloutPhase `json:"phases"`
 	CurrentPhase   int            `json:"currentPhase"`
 	PackageURL     string         `json:"packageUrl"`
@@ -46,26 +61,159 @@ type RolloutPlan struct {
 	TargetGroups   []string       `json:"targetGroups"`
 	RollbackPlan   string         `json:"rollbackPlan"`
 	CreatedBy      string         `json:"createdBy"`
+
+	// Scheduling: ScheduledStart is a wall-clock time ("2006-01-02T15:04") in
+	// ScheduleTimezone; BlackoutDates ("2006-01-02") push the start to the next allowed day
+	ScheduledStart   string   `json:"scheduledStart,omitempty"`
+	ScheduleTimezone string   `json:"scheduleTimezone,omitempty"`
+	BlackoutDates    []string `json:"blackoutDates,omitempty"`
+
+	// ArtifactName references a registry artifact published at Version; when
+	// set, PackageURL and PackageHash are resolved from the artifact table
+	ArtifactName string `json:"artifactName,omitempty"`
+
+	// TenantID scopes the rollout to one tenant's devices; empty in
+	// single-tenant deployments, and omitted so the tenant index stays sparse
+	TenantID string `json:"tenantId,omitempty" dynamodbav:"TenantID,omitempty"`
+
+	// MinHealthScore keeps devices whose health score (0-100, computed by the
+	// fleet server's HealthScorer) is below it, or not yet scored, out of the rollout
+	MinHealthScore float64 `json:"minHealthScore,omitempty" dynamodbav:"MinHealthScore,omitempty"`
+
+	// PhaseExpiry is what happens when a phase outlives its Duration: hold
+	// (the default), advance or fail; see PhaseExpiryHold
+	PhaseExpiry string `json:"phaseExpiry,omitempty" dynamodbav:"PhaseExpiry,omitempty"`
+
+	// ConfirmWithin, a duration, makes devices keep an applied update only
+	// if it is confirmed in time, by an operator or by the application
+	// through RolloutManager.Confirm; otherwise they roll it back
+	ConfirmWithin string `json:"confirmWithin,omitempty" dynamodbav:"ConfirmWithin,omitempty"`
+
+	// TargetRegions limits the rollout to devices in these regions; phases
+	// with Regions then open them wave by wave, e.g. in FollowTheSun order
+	TargetRegions []string `json:"targetRegions,omitempty" dynamodbav:"TargetRegions,omitempty"`
+
+	// BusinessHours ("08:00-18:00", Monday to Friday in the device's
+	// timezone) defers updates while a device's business day is on
+	BusinessHours string `json:"businessHours,omitempty" dynamodbav:"BusinessHours,omitempty"`
+
+	// Signature is a base64 signature over SigningPayload by SigningKeyID,
+	// checked by devices configured with a SignatureVerifier
+	Signature    string `json:"signature,omitempty" dynamodbav:"Signature,omitempty"`
+	SigningKeyID string `json:"signingKeyId,omitempty" dynamodbav:"SigningKeyID,omitempty"`
+
+	// ConfigPayload makes this a config-only rollout: instead of a package,
+	// devices pass the payload to their ConfigAppliers as Version
+	ConfigPayload string `json:"configPayload,omitempty" dynamodbav:"ConfigPayload,omitempty"`
+
+	// Artifacts makes this a bundle: instead of one package, devices apply
+	// every artifact with the handlers registered for its kind, as a single
+	// update with one status
+	Artifacts []BundleArtifact `json:"artifacts,omitempty" dynamodbav:"Artifacts,omitempty"`
+
+	// HealthPolicy replaces "every health check must pass" after the update
+	// with weights, a quorum, and critical and advisory checks
+	HealthPolicy *HealthPolicy `json:"healthPolicy,omitempty" dynamodbav:"HealthPolicy,omitempty"`
+
+	// ExecutionARN is the AWS Step Functions execution that drives the
+	// rollout's phases; the fleet server sets it when orchestration starts
+	ExecutionARN string `json:"executionArn,omitempty" dynamodbav:"ExecutionARN,omitempty"`
+
+	// Revision counts writes to the rollout record; the fleet server only
+	// writes a record at the revision it read, so concurrent changes conflict
+	// instead of overwriting each other
+	Revision int64 `json:"revision,omitempty" dynamodbav:"Revision,omitempty"`
+
+	// ExpiresAt is the Unix time after which DynamoDB's TTL deletes a
+	// finished rollout; the fleet server's LifecycleManager sets it
+	ExpiresAt int64 `json:"expiresAt,omitempty" dynamodbav:"ExpiresAt,omitempty"`
+
+	// Critical rollouts, such as security fixes, still download on devices
+	// that have used up their bandwidth cap; others wait for the next period
+	Critical bool `json:"critical,omitempty" dynamodbav:"Critical,omitempty"`
+
+	// RequiredFeature limits the rollout to devices whose license grants the
+	// feature; see WithEntitlements
+	RequiredFeature string `json:"requiredFeature,omitempty" dynamodbav:"RequiredFeature,omitempty"`
+
+	// PollInterval, a duration, is how often targeted devices check for
+	// updates while the rollout is theirs, replacing their CheckInterval
+	PollInterval string `json:"pollInterval,omitempty" dynamodbav:"PollInterval,omitempty"`
+
+	// PackageFormat (e.g. "tar.gz") and SchemaVersion of the package or
+	// config payload; devices whose advertised Capabilities lack them skip
+	// the rollout instead of failing to install it
+	PackageFormat string `json:"packageFormat,omitempty" dynamodbav:"PackageFormat,omitempty"`
+	SchemaVersion int    `json:"schemaVersion,omitempty" dynamodbav:"SchemaVersion,omitempty"`
+
+	// Packages makes this a multi-architecture rollout: instead of the
+	// plan's own package, each device installs the entry for its architecture
+	Packages []ArchPackage `json:"packages,omitempty" dynamodbav:"Packages,omitempty"`
+
+	// CohortBy, a device tag key such as "hardwareRevision", makes phase
+	// percentages apply within each cohort of devices sharing the tag's
+	// value, so early phases reach every variant; see RankCohort
+	CohortBy string `json:"cohortBy,omitempty" dynamodbav:"CohortBy,omitempty"`
+}
+
+// Expired reports whether the rollout has outlived its ExpiresAt; TTL
+// deletion lags by up to two days, so readers skip expired records
+func (p RolloutPlan) Expired(now time.Time) bool {
+	return p.ExpiresAt != 0 && now.Unix() >= p.ExpiresAt
 }
 
 // RolloutManager handles progressive rollouts to edge devices
 type RolloutManager struct {
-	dynamoClient       *dynamodb.Client
-	s3Client           *s3.Client
+	dynamoClient       DynamoDBAPI
+	s3Client           S3API
 	deviceID           string
+	tenantID           string
 	deviceGroup        string
 	deviceTags         map[string]string
 	rolloutTableName   string
 	deviceTableName    string
+	artifactTableName  string
 	updateBasePath     string
 	currentRollout     *RolloutPlan
 	rolloutMutex       sync.RWMutex
 	updateHandlers     []UpdateHandler
+	preApplyHooks      []PreApplyHook
+	bundleHandlers     map[string][]UpdateHandler // by bundle artifact kind
 	telemetryReporters []TelemetryReporter
 	healthChecks       []HealthCheck
+	healthCheckNames   []string // parallel to healthChecks, for health policies
+	lastHealth         string   // summary of the last health policy evaluation
 	lastCheckTime      time.Time
+	lastReport         localReport // last update status reported, for LocalStatus
 	checkInterval      time.Duration
 	checkTimer         *time.Timer
+	usage              rolloutUsage
+	polls              *pollSchedule
+	verifier           SignatureVerifier
+	configAppliers     []ConfigApplier
+	logger             Logger
+	clock              Clock
+	httpClient         *http.Client
+	backoff            backoff.Policy
+	bandwidth          *bandwidth.Limiter
+	meter              *bandwidth.Meter    // set by WithMeter
+	bandwidthReported  time.Time           // last usage report from the check loop
+	entitlements       EntitlementChecker  // set by WithEntitlements
+	attestation        *AttestationConfig  // set by WithAttestation
+	downloader         *manager.Downloader // set by WithTransfer, for s3:// packages
+	transfer           *transfer.Options   // set by WithTransfer, for ranged http(s) downloads
+	applying           string // rollout whose update is being applied
+	cancelApply        context.CancelCauseFunc
+	applyMutex         sync.Mutex
+	confirmation       *pendingConfirmation // applied update awaiting confirmation
+	confirmTimer       *time.Timer
+	confirmMutex       sync.Mutex
+	statusSequence     *statusSequence // orders status reports so stale ones can't overwrite newer ones
+	deviceRetention    time.Duration   // set by WithDeviceRetention
+	degraded           *degradedMode   // set by WithDegradedMode
+	longPoll           *longPoll       // set by WithLongPoll
+	declared           *Capabilities   // set by WithCapabilities
+	architecture       string          // set by WithArchitecture
 }
 
 // UpdateHandler is an interface for handling updates
@@ -94,43 +242,35 @@ type HealthCheck interface {
 
 // RolloutConfig contains configuration for the RolloutManager
 type RolloutConfig struct {
-	DynamoClient     *dynamodb.Client
-	S3Client         *s3.Client
+	DynamoClient     DynamoDBAPI // *dynamodb.Client, or a fake in tests
+	S3Client         S3API       // *s3.Client, or a fake in tests
 	DeviceID         string
+	TenantID         string
 	DeviceGroup      string
 	DeviceTags       map[string]string
-	RolloutTableName string
-	DeviceTableName  string
-	UpdateBasePath   string
-	CheckInterval    time.Duration
+	RolloutTableName  string
+	DeviceTableName   string
+	ArtifactTableName string
+	UpdateBasePath    string
+	CheckInterval     time.Duration // polling interval while a rollout targets the device
+
+	// Adaptive polling keeps large fleets from throttling the rollout table
+	IdleCheckInterval time.Duration // polling interval while no rollout targets the device; defaults to 5x CheckInterval
+	PollJitter        float64       // random +/- fraction applied to each interval; defaults to 0.2
+	PlanCacheTTL      time.Duration // how long a found rollout is reused without querying; defaults to 2x CheckInterval
+	QueryBudget       int           // rollout queries per QueryBudgetWindow, randomized by +/-25% per device; 0 is unlimited
+	QueryBudgetWindow time.Duration // defaults to an hour
+	MaxQueryPages     int           // result pages read per rollout query, following LastEvaluatedKey; defaults to 10
+
+	// Verifier, when set, rejects rollouts and registry artifacts that are
+	// not signed by a trusted key
+	Verifier SignatureVerifier
 }
 
-// NewRolloutManager creates a new RolloutManager
+// NewRolloutManager creates a new RolloutManager; it is NewManager with only
+// WithConfig, kept for existing callers
 func NewRolloutManager(config RolloutConfig) (*RolloutManager, error) {
-	// Create update directory if it doesn't exist
-	if err := os.MkdirAll(config.UpdateBasePath, 0755); err != nil {
-		return nil, fmt.Errorf("failed to create update directory: %w", err)
-	}
-
-	rm := &RolloutManager{
-		dynamoClient:       config.DynamoClient,
-		s3Client:           config.S3Client,
-		deviceID:           config.DeviceID,
-		deviceGroup:        config.DeviceGroup,
-		deviceTags:         config.DeviceTags,
-		rolloutTableName:   config.RolloutTableName,
-		deviceTableName:    config.DeviceTableName,
-		updateBasePath:     config.UpdateBasePath,
-		updateHandlers:     make([]UpdateHandler, 0),
-		telemetryReporters: make([]TelemetryReporter, 0),
-		healthChecks:       make([]HealthCheck, 0),
-		checkInterval:      config.CheckInterval,
-	}
-
-	// Start the check timer
-	rm.checkTimer = time.AfterFunc(rm.checkInterval, rm.checkForUpdates)
-
-	return rm, nil
+	return NewManager(WithConfig(config))
 }
 
 // RegisterUpdateHandler registers a handler for updates
@@ -145,34 +285,41 @@ func (rm *RolloutManager) RegisterTelemetryReporter(reporter TelemetryReporter)
 
 // RegisterHealthCheck registers a health check
 func (rm *RolloutManager) RegisterHealthCheck(check HealthCheck) {
-	rm.healthChecks = append(rm.healthChecks, check)
+	rm.RegisterNamedHealthCheck(fmt.Sprintf("check-%d", len(rm.healthChecks)+1), check)
 }
 
 // checkForUpdates checks for available updates
 func (rm *RolloutManager) checkForUpdates() {
+	rm.markCheckLoop()
 	defer func() {
-		// Reschedule the check
-		rm.checkTimer.Reset(rm.checkInterval)
+		// Reschedule the check, slower while no rollout targets this device
+		rm.markCheckLoop()
+		rm.checkTimer.Reset(rm.polls.next())
 	}()
-
-	// Get device information
-	deviceInfo, err := rm.getDeviceInfo()
-	if err != nil {
-		log.Printf("Failed to get device info: %v", err)
+	
+	// Report the bandwidth meter, even while updates are deferred
+	rm.reportBandwidth()
+	
+	// No other update while one awaits confirmation
+	if rm.checkConfirmation() {
 		return
 	}
 
-	// Check if there's an active rollout for this device
-	rollout, err := rm.getActiveRollout(deviceInfo)
+	// Find the active rollout, from the cache while it is fresh
+	rollout, err := rm.findActiveRollout()
 	if err != nil {
-		log.Printf("Failed to get active rollout: %v", err)
+		rm.logger.Printf("Failed to get active rollout: %v", err)
 		return
 	}
 
 	if rollout == nil {
 		// No active rollout
+		rm.usage.discard()
 		return
 	}
+	
+	// Charge the poll to the rollout it found
+	rm.usage.attribute(rollout.ID)
 
 	// Update current rollout
 	rm.rolloutMutex.Lock()
@@ -181,123 +328,103 @@ func (rm *RolloutManager) checkForUpdates() {
 
 	// Check if we should apply this update
 	if rm.shouldApplyUpdate(rollout) {
-		if err := rm.applyUpdate(rollout); err != nil {
-			log.Printf("Failed to apply update: %v", err)
+		if err := rm.applyUpdate(rollout); errors.Is(err, ErrRolloutAborted) {
+			rm.logger.Printf("Update aborted: %v", err)
+			
+			// Report the abort; the aborted update is already undone
+			if err := rm.reportUpdateStatus(rollout.ID, "aborted", err.Error()); err != nil {
+				rm.logger.Printf("Failed to report update abort: %v", err)
+			}
+			rm.polls.record(nil, time.Time{})
+			rm.polls.finished(rollout.ID)
+		} else if errors.Is(err, ErrIntegrityFailed) {
+			rm.logger.Printf("Update refused: %v", err)
+			
+			// Nothing was applied, so there is nothing to roll back
+			if err := rm.reportUpdateStatus(rollout.ID, "integrity-failed", err.Error()); err != nil {
+				rm.logger.Printf("Failed to report update integrity failure: %v", err)
+			}
+			rm.polls.finished(rollout.ID)
+		} else if err != nil {
+			rm.logger.Printf("Failed to apply update: %v", err)
 			
 			// Report failure
 			if err := rm.reportUpdateStatus(rollout.ID, "failed", err.Error()); err != nil {
-				log.Printf("Failed to report update failure: %v", err)
+				rm.logger.Printf("Failed to report update failure: %v", err)
 			}
 			
 			// Attempt rollback
-			if err := rm.rollbackUpdate(); err != nil {
-				log.Printf("Failed to rollback update: %v", err)
+			rollback := rm.rollbackUpdate
+			if rollout.IsConfigOnly() {
+				rollback = rm.rollbackConfig
+			} else if rollout.IsBundle() {
+				rollback = func() error { return rm.rollbackBundle(bundleKinds(rollout.Artifacts)) }
 			}
-		} else {
-			// Report success
-			if err := rm.reportUpdateStatus(rollout.ID, "success", ""); err != nil {
-				log.Printf("Failed to report update success: %v", err)
+			if err := rollback(); err != nil {
+				rm.logger.Printf("Failed to rollback update: %v", err)
 			}
-		}
-	}
-}
-
-// getDeviceInfo retrieves information about this device
-func (rm *RolloutManager) getDeviceInfo() (map[string]interface{}, error) {
-	result, err := rm.dynamoClient.GetItem(context.Background(), &dynamodb.GetItemInput{
-		TableName: aws.String(rm.deviceTableName),
-		Key: map[string]types.AttributeValue{
-			"DeviceID": &types.AttributeValueMemberS{Value: rm.deviceID},
-		},
-	})
-	
-	if err != nil {
-		return nil, fmt.Errorf("failed to get device info: %w", err)
-	}
-	
-	if result.Item == nil {
-		return nil, fmt.Errorf("device not found: %s", rm.deviceID)
-	}
-	
-	// Convert DynamoDB item to map
-	deviceInfo := make(map[string]interface{})
-	
-	for k, v := range result.Item {
-		switch av := v.(type) {
-		case *types.AttributeValueMemberS:
-			deviceInfo[k] = av.Value
-		case *types.AttributeValueMemberN:
-			deviceInfo[k] = av.Value
-		case *types.AttributeValueMemberBOOL:
-			deviceInfo[k] = av.Value
-		case *types.AttributeValueMemberM:
-			// Handle map attributes
-			mapAttr := make(map[string]interface{})
-			for mk, mv := range av.Value {
-				if mvs, ok := mv.(*types.AttributeValueMemberS); ok {
-					mapAttr[mk] = mvs.Value
-				}
+		} else if window, _ := rollout.ConfirmationWindow(); window > 0 {
+			// Keep the update only if it is confirmed in time
+			if err := rm.awaitConfirmation(rollout, window); err != nil {
+				rm.logger.Printf("Failed to report update awaiting confirmation: %v", err)
 			}
-			deviceInfo[k] = mapAttr
-		case *types.AttributeValueMemberL:
-			// Handle list attributes
-			listAttr := make([]interface{}, 0, len(av.Value))
-			for _, lv := range av.Value {
-				if lvs, ok := lv.(*types.AttributeValueMemberS); ok {
-					listAttr = append(listAttr, lvs.Value)
-				}
+		} else {
+			// Report success with the health policy's result
+			if err := rm.reportUpdateStatus(rollout.ID, "success", rm.healthSummary()); err != nil {
+				rm.logger.Printf("Failed to report update success: %v", err)
 			}
-			deviceInfo[k] = listAttr
+			rm.polls.finished(rollout.ID)
 		}
 	}
-	
-	return deviceInfo, nil
 }
 
 // getActiveRollout gets the active rollout for this device
-func (rm *RolloutManager) getActiveRollout(deviceInfo map[string]interface{}) (*RolloutPlan, error) {
+func (rm *RolloutManager) getActiveRollout(deviceInfo *DeviceInfo) (*RolloutPlan, error) {
 	// Query for active rollouts that target this device's group
-	result, err := rm.dynamoClient.Query(context.Background(), &dynamodb.QueryInput{
+	input := &dynamodb.QueryInput{
 		TableName:              aws.String(rm.rolloutTableName),
 		IndexName:              aws.String("StatusIndex"),
 		KeyConditionExpression: aws.String("Status = :status"),
 		ExpressionAttributeValues: map[string]types.AttributeValue{
 			":status": &types.AttributeValueMemberS{Value: "in-progress"},
 		},
-	})
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	}
 	
-	if err != nil {
-		return nil, fmt.Errorf("failed to query active rollouts: %w", err)
+	// Tenant devices only see their tenant's rollouts; the index is keyed on
+	// TenantID so IAM LeadingKeys conditions can enforce this
+	if rm.tenantID != "" {
+		input.IndexName = aws.String("TenantStatusIndex")
+		input.KeyConditionExpression = aws.String("TenantID = :tenant AND #status = :status")
+		input.ExpressionAttributeNames = map[string]string{"#status": "Status"}
+		input.ExpressionAttributeValues[":tenant"] = &types.AttributeValueMemberS{Value: rm.tenantID}
 	}
 	
-	if len(result.Items) == 0 {
-		return nil, nil
+	items, err := rm.queryAllPages(input)
+	if err != nil {
+		return nil, err
 	}
 	
 	// Find a rollout that targets this device
-	for _, item := range result.Items {
+	for _, item := range items {
+		// The whole record is unmarshaled, as the fleet server wrote it, so
+		// every field a plan carries reaches the eligibility checks and the
+		// signature payload
 		var rollout RolloutPlan
-		
-		// Extract rollout ID
-		if id, ok := item["ID"].(*types.AttributeValueMemberS); ok {
-			rollout.ID = id.Value
-		} else {
+		if err := attributevalue.UnmarshalMap(item, &rollout); err != nil {
+			rm.logger.Printf("Failed to unmarshal rollout: %v", err)
 			continue
 		}
 		
-		// Extract target groups
-		if targetGroups, ok := item["TargetGroups"].(*types.AttributeValueMemberL); ok {
-			for _, tg := range targetGroups.Value {
-				if tgs, ok := tg.(*types.AttributeValueMemberS); ok {
-					rollout.TargetGroups = append(rollout.TargetGroups, tgs.Value)
-				}
-			}
+		if rollout.ID == "" {
+			continue
 		}
 		
-		// Check if this device is in the target group
+		// Check if this device is in the target group, including dynamic groups
+		// materialized into the device record by the grouping service
 		isTargeted := false
 		for _, group := range rollout.TargetGroups {
-			if group == rm.deviceGroup || group == "all" {
+			if group == rm.deviceGroup || group == "all" || deviceInfo.InDynamicGroup(group) {
 				isTargeted = true
 				break
 			}
@@ -307,69 +434,15 @@ func (rm *RolloutManager) getActiveRollout(deviceInfo map[string]interface{}) (*
 			continue
 		}
 		
-		// Extract other rollout details
-		if name, ok := item["Name"].(*types.AttributeValueMemberS); ok {
-			rollout.Name = name.Value
-		}
-		
-		if desc, ok := item["Description"].(*types.AttributeValueMemberS); ok {
-			rollout.Description = desc.Value
-		}
-		
-		if version, ok := item["Version"].(*types.AttributeValueMemberS); ok {
-			rollout.Version = version.Value
-		}
-		
-		if status, ok := item["Status"].(*types.AttributeValueMemberS); ok {
-			rollout.Status = status.Value
-		}
-		
-		if packageURL, ok := item["PackageURL"].(*types.AttributeValueMemberS); ok {
-			rollout.PackageURL = packageURL.Value
-		}
-		
-		if packageHash, ok := item["PackageHash"].(*types.AttributeValueMemberS); ok {
-			rollout.PackageHash = packageHash.Value
-		}
-		
-		if currentPhase, ok := item["CurrentPhase"].(*types.AttributeValueMemberN); ok {
-			phase, _ := parseInt(currentPhase.Value)
-			rollout.CurrentPhase = phase
+		// Devices outside the rollout's regions aren't targeted
+		if !rollout.TargetsRegion(deviceInfo.Region) {
+			continue
 		}
 		
-		// Extract phases
-		if phasesAttr, ok := item["Phases"].(*types.AttributeValueMemberL); ok {
-			for _, phaseAttr := range phasesAttr.Value {
-				if phaseMap, ok := phaseAttr.(*types.AttributeValueMemberM); ok {
-					var phase RolloutPhase
-					
-					if id, ok := phaseMap.Value["ID"].(*types.AttributeValueMemberS); ok {
-						phase.ID = id.Value
-					}
-					
-					if pct, ok := phaseMap.Value["Percentage"].(*types.AttributeValueMemberN); ok {
-						phase.Percentage, _ = parseFloat(pct.Value)
-					}
-					
-					if startTime, ok := phaseMap.Value["StartTime"].(*types.AttributeValueMemberS); ok {
-						phase.StartTime, _ = time.Parse(time.RFC3339, startTime.Value)
-					}
-					
-					if duration, ok := phaseMap.Value["Duration"].(*types.AttributeValueMemberS); ok {
-						phase.Duration = duration.Value
-					}
-					
-					if reqApproval, ok := phaseMap.Value["RequireApproval"].(*types.AttributeValueMemberBOOL); ok {
-						phase.RequireApproval = reqApproval.Value
-					}
-					
-					if approved, ok := phaseMap.Value["Approved"].(*types.AttributeValueMemberBOOL); ok {
-						phase.Approved = approved.Value
-					}
-					
-					rollout.Phases = append(rollout.Phases, phase)
-				}
-			}
+		// Devices below the rollout's minimum health score wait until they recover
+		if !deviceInfo.MeetsHealthScore(rollout.MinHealthScore) {
+			rm.logger.Printf("Device health score is below %.1f required by rollout %s", rollout.MinHealthScore, rollout.ID)
+			continue
 		}
 		
 		return &rollout, nil
@@ -380,121 +453,193 @@ func (rm *RolloutManager) getActiveRollout(deviceInfo map[string]interface{}) (*
 
 // shouldApplyUpdate determines if this device should apply the update
 func (rm *RolloutManager) shouldApplyUpdate(rollout *RolloutPlan) bool {
-	// Check if we're already on this version
-	currentVersion, err := rm.getCurrentVersion()
+	err := rm.CheckEligibility(rollout)
+	if err != nil && !isExpectedSkip(err) {
+		rm.logger.Printf("Failed to check rollout eligibility: %v", err)
+	}
+	return err == nil
+}
+
+// CheckEligibility returns nil when this device should apply the rollout now,
+// and otherwise why not: ErrUpToDate, ErrNotSelected, ErrPhaseNotApproved,
+// ErrBusinessHours, ErrOutsideWindow, ErrBandwidthCap, ErrNotEntitled,
+// ErrIncompatible, ErrFenced, or a lookup error such as ErrDeviceNotFound
+func (rm *RolloutManager) CheckEligibility(rollout *RolloutPlan) error {
+	// Check if we're already on this version; config rollouts track their own version
+	getVersion := rm.getCurrentVersion
+	if rollout.IsConfigOnly() {
+		getVersion = rm.getConfigVersion
+	}
+	currentVersion, err := getVersion()
 	if err != nil {
-		log.Printf("Failed to get current version: %v", err)
-		return false
+		return err
 	}
 	
 	if currentVersion == rollout.Version {
-		return false
+		return fmt.Errorf("%w: %s", ErrUpToDate, rollout.Version)
 	}
 	
 	// Check if we're in the current phase's percentage
 	if rollout.CurrentPhase >= len(rollout.Phases) {
-		return false
+		return fmt.Errorf("%w: rollout %s has no phase %d", ErrNotSelected, rollout.ID, rollout.CurrentPhase)
 	}
 	
-	currentPhase := rollout.Phases[rollout.CurrentPhase]
+	// Group overrides adjust the phase for this device's group
+	currentPhase := rollout.Phases[rollout.CurrentPhase].ForGroup(rm.deviceGroup)
 	
 	// Check if the phase requires approval and hasn't been approved
 	if currentPhase.RequireApproval && !currentPhase.Approved {
-		return false
+		return fmt.Errorf("%w: phase %s of rollout %s", ErrPhaseNotApproved, currentPhase.ID, rollout.ID)
+	}
+	
+	// Licensed rollouts skip devices whose license doesn't grant the feature
+	if err := rm.checkEntitlement(rollout); err != nil {
+		return err
+	}
+	
+	// Devices without a handler, format or schema the rollout needs skip it
+	if err := rm.checkCapabilities(rollout); err != nil {
+		return err
+	}
+	
+	// Multi-architecture rollouts skip devices they carry no package for
+	if err := rm.checkArchitecture(rollout); err != nil {
+		return err
 	}
 	
 	// Use device ID to deterministically decide if we're in the percentage
 	// This ensures the same devices get updated in each phase
-	h := fnv.New32a()
-	h.Write([]byte(rm.deviceID))
-	hash := h.Sum32()
+	devicePercentile := DevicePercentile(rm.deviceID)
+	
+	// Cohorted rollouts use the device's rank within its cohort instead
+	if rollout.CohortBy != "" {
+		deviceInfo, err := rm.deviceInfoOrCached()
+		if err != nil {
+			return err
+		}
+		devicePercentile = rollout.PercentileFor(rm.deviceID, deviceInfo.CohortPercentiles)
+	}
+	
+	if devicePercentile > currentPhase.Percentage {
+		return fmt.Errorf("%w: device percentile %.0f is above %.1f%%", ErrNotSelected, devicePercentile, currentPhase.Percentage)
+	}
+	
+	// Regional waves, business hours and maintenance windows depend on
+	// where the device is and its own record
+	deviceInfo, err := rm.deviceInfoOrCached()
+	if err != nil {
+		return err
+	}
+	
+	// The fleet fences devices whose attestation failed out of every rollout
+	if deviceInfo.Fenced {
+		return fmt.Errorf("%w: %s", ErrFenced, deviceInfo.FencedReason)
+	}
+	
+	if !rollout.RegionOpen(deviceInfo.Region) {
+		return fmt.Errorf("%w: region %q not yet reached by rollout %s", ErrNotSelected, deviceInfo.Region, rollout.ID)
+	}
 	
-	// Convert hash to a percentage (0-100)
-	devicePercentile := float64(hash % 100)
+	now := rm.clock.Now()
+	if rollout.InBusinessHours(now, deviceInfo.Timezone, time.Local) {
+		return fmt.Errorf("%w: %s", ErrBusinessHours, rollout.BusinessHours)
+	}
 	
-	return devicePercentile <= currentPhase.Percentage
+	if window := currentPhase.WindowFor(deviceInfo.MaintenanceWindow); !InWindow(window, now, deviceInfo.Timezone, time.Local) {
+		return fmt.Errorf("%w: %s", ErrOutsideWindow, window)
+	}
+	
+	// A device past its bandwidth cap only downloads critical rollouts
+	return rm.checkBandwidthCap(rollout)
 }
 
-// applyUpdate applies an update
+// applyUpdate applies an update; an abort while it runs undoes what was
+// applied and returns ErrRolloutAborted
 func (rm *RolloutManager) applyUpdate(rollout *RolloutPlan) error {
+	// A device that can't attest its boot chain applies nothing
+	if err := rm.attestUpdate(rollout); err != nil {
+		return err
+	}
+	
+	// Hooks such as boot chain verification can refuse the update
+	if err := runPreApplyHooks(rm.preApplyHooks, rollout.ID, rollout.Version); err != nil {
+		return err
+	}
+	
+	if rollout.IsConfigOnly() {
+		return rm.applyConfig(rollout)
+	}
+	
+	ctx, finish := rm.beginApply(rollout.ID)
+	defer finish()
+	
+	if rollout.IsBundle() {
+		return rm.applyBundle(ctx, rollout)
+	}
+	
+	// Resolve the package, checking signatures when a verifier is configured
+	packageURL, packageHash, err := rm.resolvePackage(ctx, rollout)
+	if err != nil {
+		return err
+	}
+	
 	// Download the update package
-	packagePath, err := rm.downloadUpdatePackage(rollout.PackageURL, rollout.PackageHash)
+	packagePath, err := rm.downloadUpdatePackage(ctx, packageURL, packageHash)
 	if err != nil {
 		return fmt.Errorf("failed to download update package: %w", err)
 	}
 	
-	// Validate the update with all handlers
-	for _, handler := range rm.updateHandlers {
-		if err := handler.ValidateUpdate(packagePath); err != nil {
-			return fmt.Errorf("update validation failed: %w", err)
-		}
+	// Validate and apply the update with all handlers
+	if err := runHandlers(ctx, rm.updateHandlers, packagePath, rollout.Version); err != nil {
+		return err
 	}
 	
-	// Apply the update with all handlers
-	for _, handler := range rm.updateHandlers {
-		if err := handler.HandleUpdate(packagePath, rollout.Version); err != nil {
-			return fmt.Errorf("update application failed: %w", err)
-		}
+	// Perform health checks under the rollout's policy
+	if err := rm.checkRolloutHealth(rollout); err != nil {
+		return err
 	}
 	
-	// Perform health checks
-	healthy, err := rm.performHealthChecks()
-	if err != nil || !healthy {
-		return fmt.Errorf("health check failed after update: %w", err)
+	// An abort during the health checks still undoes the update
+	if err := aborted(ctx); err != nil {
+		return abortHandlers(rm.updateHandlers, err, packagePath)
 	}
 	
 	return nil
 }
 
 // downloadUpdatePackage downloads an update package
-func (rm *RolloutManager) downloadUpdatePackage(packageURL, expectedHash string) (string, error) {
+func (rm *RolloutManager) downloadUpdatePackage(ctx context.Context, packageURL, expectedHash string) (string, error) {
 	// Extract the package name from the URL
 	packageName := filepath.Base(packageURL)
 	packagePath := filepath.Join(rm.updateBasePath, packageName)
 	
-	// Parse the S3 URL
-	// Assuming format: s3://bucket-name/path/to/package
-	s3URL := packageURL[5:] // Remove "s3://"
-	parts := strings.SplitN(s3URL, "/", 2)
-	if len(parts) != 2 {
-		return "", fmt.Errorf("invalid S3 URL format: %s", packageURL)
-	}
-	
-	bucketName := parts[0]
-	objectKey := parts[1]
-	
-	// Download the package
-	result, err := rm.s3Client.GetObject(context.Background(), &s3.GetObjectInput{
-		Bucket: aws.String(bucketName),
-		Key:    aws.String(objectKey),
+	// Download the package, retrying transient failures
+	var hash string
+	err := backoff.Retry(ctx, rm.backoff, func() error {
+		var err error
+		hash, err = rm.fetchPackage(ctx, packageURL, packagePath)
+		return err
 	})
-	
-	if err != nil {
-		return "", fmt.Errorf("failed to download package: %w", err)
-	}
-	
-	// Create the file
-	file, err := os.Create(packagePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to create package file: %w", err)
+	if abortErr := aborted(ctx); abortErr != nil {
+		os.Remove(packagePath)
+		return "", abortErr
 	}
-	defer file.Close()
-	
-	// Copy the data
-	_, err = io.Copy(file, result.Body)
 	if err != nil {
-		return "", fmt.Errorf("failed to write package file: %w", err)
+		return "", err
 	}
 	
-	// Verify the hash
-	hash, err := calculateFileHash(packagePath)
-	if err != nil {
-		return "", fmt.Errorf("failed to calculate package hash: %w", err)
+	// Verify the hash, computed during the download unless it was written
+	// out of order
+	if hash == "" {
+		hash, err = calculateFileHash(packagePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to calculate package hash: %w", err)
+		}
 	}
 	
 	if hash != expectedHash {
 		os.Remove(packagePath)
-		return "", fmt.Errorf("package hash mismatch: expected %s, got %s", expectedHash, hash)
+		return "", fmt.Errorf("%w: expected %s, got %s", ErrHashMismatch, expectedHash, hash)
 	}
 	
 	return packagePath, nil
@@ -527,73 +672,116 @@ func (rm *RolloutManager) rollbackUpdate() error {
 	return nil
 }
 
-// reportUpdateStatus reports the status of an update
+// reportUpdateStatus reports the status of an update, with the usage the
+// device incurred for the rollout
 func (rm *RolloutManager) reportUpdateStatus(rolloutID, status, message string) error {
-	_, err := rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
+	rm.recordReport(rolloutID, status)
+	
+	sequence, err := rm.statusSequence.next(rm.clock.Now())
+	if err != nil {
+		return fmt.Errorf("failed to record status sequence: %w", err)
+	}
+	
+	values := rm.usage.attributeValues()
+	values[":status"] = &types.AttributeValueMemberS{Value: status}
+	values[":rolloutID"] = &types.AttributeValueMemberS{Value: rolloutID}
+	values[":time"] = &types.AttributeValueMemberS{Value: rm.clock.Now().UTC().Format(time.RFC3339)}
+	values[":message"] = &types.AttributeValueMemberS{Value: message}
+	values[":sequence"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(sequence, 10)}
+	
+	expression := "SET UpdateStatus = :status, LastUpdateID = :rolloutID, LastUpdateTime = :time, LastUpdateMessage = :message, StatusSequence = :sequence, " + usageExpression
+	
+	// The bandwidth meter rides along with every report
+	if rm.meter != nil {
+		for name, value := range rm.bandwidthValues() {
+			values[name] = value
+		}
+		expression += ", " + bandwidthExpression
+	}
+	
+	// A TPM quote bound to this report lets the fleet verify the device's boot chain
+	if rm.attestation != nil {
+		for name, value := range rm.attestationValues(rolloutID, status, sequence) {
+			values[name] = value
+		}
+		expression += ", " + attestationExpression
+	}
+	
+	// Each report pushes back when a device that goes quiet is deleted
+	if rm.deviceRetention > 0 {
+		expression += ", ExpiresAt = :expiresAt"
+		values[":expiresAt"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(rm.clock.Now().Add(rm.deviceRetention).Unix(), 10)}
+	}
+	
+	// Only final statuses are counted
+	if counter := updateCounter(status); counter != "" {
+		expression += " ADD " + counter + " :one"
+		values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+	}
+	
+	_, err = rm.dynamoClient.UpdateItem(context.Background(), &dynamodb.UpdateItemInput{
 		TableName: aws.String(rm.deviceTableName),
 		Key: map[string]types.AttributeValue{
-			"DeviceID": &types.AttributeValueMemberS{Value: rm.deviceID},
-		},
-		UpdateExpression: aws.String("SET UpdateStatus = :status, LastUpdateID = :rolloutID, LastUpdateTime = :time, LastUpdateMessage = :message"),
-		ExpressionAttributeValues: map[string]types.AttributeValue{
-			":status":   &types.AttributeValueMemberS{Value: status},
-			":rolloutID": &types.AttributeValueMemberS{Value: rolloutID},
-			":time":     &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
-			":message":  &types.AttributeValueMemberS{Value: message},
+			"DeviceID": &types.AttributeValueMemberS{Value: tenant.Key(rm.tenantID, rm.deviceID)},
 		},
+		UpdateExpression:          aws.String(expression),
+		ConditionExpression:       aws.String(statusSequenceCondition),
+		ExpressionAttributeValues: values,
 	})
 	
+	// A newer report, or this one on an earlier attempt, is already stored
+	if superseded(err) {
+		rm.logger.Printf("Status %s for rollout %s superseded by a newer report", status, rolloutID)
+		return nil
+	}
+	
 	return err
 }
 
 // getCurrentVersion gets the current version of the device
 func (rm *RolloutManager) getCurrentVersion() (string, error) {
-	result, err := rm.dynamoClient.GetItem(context.Background(), &dynamodb.GetItemInput{
-		TableName: aws.String(rm.deviceTableName),
-		Key: map[string]types.AttributeValue{
-			"DeviceID": &types.AttributeValueMemberS{Value: rm.deviceID},
-		},
-		ProjectionExpression: aws.String("CurrentVersion"),
-	})
-	
+	info, err := rm.loadDeviceInfo(context.Background(), "CurrentVersion")
 	if err != nil {
 		return "", fmt.Errorf("failed to get current version: %w", err)
 	}
 	
-	if result.Item == nil {
-		return "", fmt.Errorf("device not found: %s", rm.deviceID)
-	}
-	
-	if version, ok := result.Item["CurrentVersion"].(*types.AttributeValueMemberS); ok {
-		return version.Value, nil
+	if info.CurrentVersion == "" {
+		return "", fmt.Errorf("current version not found")
 	}
 	
-	return "", fmt.Errorf("current version not found")
+	return info.CurrentVersion, nil
 }
 
 // Close stops the rollout manager
 func (rm *RolloutManager) Close() {
+	rm.stopLongPoll()
 	if rm.checkTimer != nil {
 		rm.checkTimer.Stop()
 	}
+	
+	// A pending confirmation stays recorded and resumes on the next start
+	rm.confirmMutex.Lock()
+	if rm.confirmTimer != nil {
+		rm.confirmTimer.Stop()
+		rm.confirmTimer = nil
+	}
+	rm.confirmMutex.Unlock()
 }
 
 // Helper functions
 
-func parseInt(s string) (int, error) {
-	i, err := strconv.Atoi(s)
-	if err != nil {
-		return 0, err
-	}
-	return i, nil
-}
-
-func parseFloat(s string) (float64, error) {
-	f, err := strconv.ParseFloat(s, 64)
-	if err != nil {
-		return 0, err
-	}
-	return f, nil
+// updateCounter names the device attribute counting updates with a final status;
+// the fleet server's health score is built from these counts
+func updateCounter(status string) string {
+	switch status {
+	case "success":
+		return "UpdatesSucceeded"
+	case "aborted":
+		return "UpdatesAborted"
+	case "failed":
+		return "UpdatesFailed"
+	}
+	return ""
 }
 
 func calculateFileHash(filePath string) (string, error) {
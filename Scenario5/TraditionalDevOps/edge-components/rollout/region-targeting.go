@@ -0,0 +1,186 @@
+package rollout
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// TargetsRegion reports whether the plan's TargetRegions include region; a
+// plan without TargetRegions targets every region
+func (p RolloutPlan) TargetsRegion(region string) bool {
+	return len(p.TargetRegions) == 0 || contains(p.TargetRegions, region)
+}
+
+// Regional reports whether the plan's phases progress region by region
+func (p RolloutPlan) Regional() bool {
+	for _, phase := range p.Phases {
+		if len(phase.Regions) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// RegionOpen reports whether the current phase reaches devices in region.
+// Regions a phase lists stay open in the phases after it; when no phase
+// lists regions, every region is open from the first phase.
+func (p RolloutPlan) RegionOpen(region string) bool {
+	if !p.Regional() {
+		return true
+	}
+	for i := 0; i <= p.CurrentPhase && i < len(p.Phases); i++ {
+		if contains(p.Phases[i].Regions, region) {
+			return true
+		}
+	}
+	return false
+}
+
+// InBusinessHours reports whether now falls within the plan's BusinessHours
+// in timezone, an IANA name; an empty or unknown timezone falls back to
+// fallback. Weekends are outside business hours.
+func (p RolloutPlan) InBusinessHours(now time.Time, timezone string, fallback *time.Location) bool {
+	if p.BusinessHours == "" {
+		return false
+	}
+
+	start, end, err := ParseBusinessHours(p.BusinessHours)
+	if err != nil {
+		return false
+	}
+
+	local := now.In(location(timezone, fallback))
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return false
+	}
+
+	return inClockRange(local, start, end)
+}
+
+// ParseBusinessHours parses "HH:MM-HH:MM" into minutes after midnight
+func ParseBusinessHours(hours string) (int, int, error) {
+	return parseClockRange("business hours", hours)
+}
+
+// FollowTheSun orders regions, given by IANA timezone, so that each is
+// updated after its business day: regions already past it at from come
+// first, the rest in the order their business hours end. Ties keep region
+// name order.
+func FollowTheSun(timezones map[string]string, businessHours string, from time.Time) ([]string, error) {
+	start, end, err := ParseBusinessHours(businessHours)
+	if err != nil {
+		return nil, err
+	}
+
+	ready := make(map[string]time.Time, len(timezones))
+	regions := make([]string, 0, len(timezones))
+	for region, timezone := range timezones {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return nil, fmt.Errorf("unknown timezone %q for region %s: %w", timezone, region, err)
+		}
+		ready[region] = offHoursFrom(from.In(loc), start, end)
+		regions = append(regions, region)
+	}
+
+	sort.Slice(regions, func(i, j int) bool {
+		a, b := ready[regions[i]], ready[regions[j]]
+		if !a.Equal(b) {
+			return a.Before(b)
+		}
+		return regions[i] < regions[j]
+	})
+
+	return regions, nil
+}
+
+// Helper functions
+
+// offHoursFrom returns the first time at or after local that is outside the
+// weekday business hours [start, end), in minutes after midnight
+func offHoursFrom(local time.Time, start, end int) time.Time {
+	if local.Weekday() == time.Saturday || local.Weekday() == time.Sunday {
+		return local
+	}
+
+	midnight := time.Date(local.Year(), local.Month(), local.Day(), 0, 0, 0, 0, local.Location())
+	minute := local.Hour()*60 + local.Minute()
+
+	switch {
+	case start < end && (minute < start || minute >= end):
+		return local
+	case start < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	case minute >= end && minute < start:
+		return local
+	case minute < end:
+		return midnight.Add(time.Duration(end) * time.Minute)
+	}
+
+	// Overnight hours that began this evening end tomorrow, or at midnight
+	// when tomorrow is a Saturday
+	tomorrow := midnight.AddDate(0, 0, 1)
+	if tomorrow.Weekday() == time.Saturday {
+		return tomorrow
+	}
+	return tomorrow.Add(time.Duration(end) * time.Minute)
+}
+
+// parseClockRange parses "HH:MM-HH:MM" into minutes after midnight; the
+// range runs past midnight when it ends before it starts
+func parseClockRange(kind, value string) (int, int, error) {
+	from, to, ok := strings.Cut(value, "-")
+	if !ok {
+		return 0, 0, fmt.Errorf("invalid %s %q: want HH:MM-HH:MM", kind, value)
+	}
+
+	start, err := time.Parse("15:04", strings.TrimSpace(from))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s %q: %w", kind, value, err)
+	}
+	end, err := time.Parse("15:04", strings.TrimSpace(to))
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid %s %q: %w", kind, value, err)
+	}
+	if start.Equal(end) {
+		return 0, 0, fmt.Errorf("invalid %s %q: empty range", kind, value)
+	}
+
+	return start.Hour()*60 + start.Minute(), end.Hour()*60 + end.Minute(), nil
+}
+
+// inClockRange reports whether local's time of day is within [start, end),
+// in minutes after midnight
+func inClockRange(local time.Time, start, end int) bool {
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// Overnight, e.g. "22:00-06:00"
+	return minute >= start || minute < end
+}
+
+// location loads an IANA timezone, falling back when it is empty or unknown
+func location(timezone string, fallback *time.Location) *time.Location {
+	if timezone != "" {
+		if loc, err := time.LoadLocation(timezone); err == nil {
+			return loc
+		}
+	}
+	if fallback == nil {
+		return time.UTC
+	}
+	return fallback
+}
+
+// contains reports whether values include value
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
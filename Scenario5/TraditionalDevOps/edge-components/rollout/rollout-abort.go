@@ -0,0 +1,158 @@
+package rollout
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// abortPollInterval is how often a device applying an update re-reads its
+// rollout's status to notice an abort
+const abortPollInterval = 15 * time.Second
+
+// Abort cancels the update being applied for rolloutID, e.g. when a LAN
+// broker relays the abort; it returns false when no such update is running.
+// The device then cleans up as for an abort noticed while polling.
+func (rm *RolloutManager) Abort(rolloutID string) bool {
+	rm.applyMutex.Lock()
+	defer rm.applyMutex.Unlock()
+
+	if rm.applying != rolloutID || rm.cancelApply == nil {
+		return false
+	}
+	rm.cancelApply(fmt.Errorf("%w: %s", ErrRolloutAborted, rolloutID))
+	return true
+}
+
+// beginApply returns the context an update for the rollout is applied
+// under, cancelled by Abort or once the rollout's status turns aborted, and
+// the function that ends it
+func (rm *RolloutManager) beginApply(rolloutID string) (context.Context, func()) {
+	ctx, cancel := context.WithCancelCause(context.Background())
+
+	rm.applyMutex.Lock()
+	rm.applying = rolloutID
+	rm.cancelApply = cancel
+	rm.applyMutex.Unlock()
+
+	go rm.watchAbort(ctx, rolloutID)
+
+	return ctx, func() {
+		rm.applyMutex.Lock()
+		rm.applying = ""
+		rm.cancelApply = nil
+		rm.applyMutex.Unlock()
+		cancel(nil)
+	}
+}
+
+// watchAbort polls the rollout's status until the update ends, aborting it
+// when the fleet server has
+func (rm *RolloutManager) watchAbort(ctx context.Context, rolloutID string) {
+	ticker := time.NewTicker(abortPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		status, err := rm.getRolloutStatus(ctx, rolloutID)
+		if err != nil {
+			if ctx.Err() == nil {
+				rm.logger.Printf("Failed to check status of rollout %s: %v", rolloutID, err)
+			}
+			continue
+		}
+
+		if status == "aborted" {
+			rm.logger.Printf("Rollout %s was aborted; cancelling the update", rolloutID)
+			rm.Abort(rolloutID)
+			return
+		}
+	}
+}
+
+// getRolloutStatus reads the status of a rollout
+func (rm *RolloutManager) getRolloutStatus(ctx context.Context, rolloutID string) (string, error) {
+	if IsDesiredState(rolloutID) {
+		return rm.desiredStateStatus(ctx, rolloutID)
+	}
+
+	result, err := rm.dynamoClient.GetItem(ctx, &dynamodb.GetItemInput{
+		TableName: aws.String(rm.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		ProjectionExpression:     aws.String("#status"),
+		ExpressionAttributeNames: map[string]string{"#status": "Status"},
+		ReturnConsumedCapacity:   types.ReturnConsumedCapacityTotal,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to get rollout status: %w", err)
+	}
+	rm.usage.addCapacity(result.ConsumedCapacity)
+
+	if status, ok := result.Item["Status"].(*types.AttributeValueMemberS); ok {
+		return status.Value, nil
+	}
+
+	return "", fmt.Errorf("rollout %s not found", rolloutID)
+}
+
+// Helper functions
+
+// aborted returns why ctx was cancelled, e.g. a wrapped ErrRolloutAborted, or
+// nil while the update may continue
+func aborted(ctx context.Context) error {
+	if ctx.Err() == nil {
+		return nil
+	}
+	return context.Cause(ctx)
+}
+
+// runHandlers validates and applies a package with every handler, checking
+// for an abort between steps; on abort the handlers that already applied are
+// rolled back and the staged package is deleted
+func runHandlers(ctx context.Context, handlers []UpdateHandler, packagePath, version string) error {
+	for _, handler := range handlers {
+		if err := handler.ValidateUpdate(packagePath); err != nil {
+			return fmt.Errorf("update validation failed: %w", err)
+		}
+	}
+
+	for i, handler := range handlers {
+		if err := aborted(ctx); err != nil {
+			return abortHandlers(handlers[:i], err, packagePath)
+		}
+
+		if err := handler.HandleUpdate(packagePath, version); err != nil {
+			return fmt.Errorf("update application failed: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// abortHandlers rolls back the handlers that applied an aborted update, the
+// last applied first, and deletes its staged packages
+func abortHandlers(applied []UpdateHandler, cause error, packagePaths ...string) error {
+	for _, packagePath := range packagePaths {
+		os.Remove(packagePath)
+	}
+
+	for i := len(applied) - 1; i >= 0; i-- {
+		if err := applied[i].RollbackUpdate(); err != nil {
+			return fmt.Errorf("%w; rollback failed: %v", cause, err)
+		}
+	}
+
+	return cause
+}
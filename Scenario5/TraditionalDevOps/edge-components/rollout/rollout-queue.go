@@ -0,0 +1,214 @@
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sqs"
+	sqstypes "github.com/aws/aws-sdk-go-v2/service/sqs/types"
+)
+
+// DefaultQueuePrefix starts the names of the per-group rollout queues
+const DefaultQueuePrefix = "edge-rollouts-"
+
+// queueTenantSeparator joins a tenant ID and a group in queue names; tenant
+// IDs can't contain it and QueueName replaces it in groups
+const queueTenantSeparator = "_"
+
+// RolloutNotice is the message the fleet server puts on a group's rollout
+// queue when a rollout targeting the group changes. It only says what
+// changed; devices still read the plan from the rollout table.
+type RolloutNotice struct {
+	RolloutID    string    `json:"rolloutId"`
+	TenantID     string    `json:"tenantId,omitempty"`
+	Status       string    `json:"status"`
+	CurrentPhase int       `json:"currentPhase"`
+	Revision     int64     `json:"revision"`
+	Timestamp    time.Time `json:"timestamp"`
+}
+
+// QueueName returns the name of a device group's rollout queue, e.g.
+// "edge-rollouts-acme_kiosks". Characters SQS doesn't allow in queue names
+// become hyphens, and names are cut to the 80 characters SQS allows.
+func QueueName(prefix, tenantID, group string) string {
+	sanitized := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '-'
+	}, group)
+
+	name := prefix + sanitized
+	if tenantID != "" {
+		name = prefix + tenantID + queueTenantSeparator + sanitized
+	}
+	if len(name) > 80 {
+		name = name[:80]
+	}
+	return name
+}
+
+// QueueTenant returns the tenant of a rollout queue name under prefix, and
+// false for names that aren't rollout queues
+func QueueTenant(prefix, name string) (string, bool) {
+	rest, ok := strings.CutPrefix(name, prefix)
+	if !ok || rest == "" {
+		return "", false
+	}
+	tenantID, _, found := strings.Cut(rest, queueTenantSeparator)
+	if !found {
+		return "", true
+	}
+	return tenantID, true
+}
+
+// QueueWatcher long-polls a device group's rollout queue and hands each batch
+// of notices to OnNotice, e.g. RolloutManager.CheckNow or the LAN broker's
+// AnnounceNow, so devices can poll the rollout table far less often. SQS
+// gives each message to one receiver, so run one watcher per queue: on the
+// site's gateway device, which relays changes over the LAN.
+type QueueWatcher struct {
+	sqsClient     SQSAPI
+	queueURL      string
+	onNotice      func([]RolloutNotice)
+	waitTime      int32
+	retryInterval time.Duration
+	cancel        context.CancelFunc
+	done          chan struct{}
+}
+
+// QueueWatcherConfig contains configuration for the QueueWatcher
+type QueueWatcherConfig struct {
+	SQSClient     SQSAPI // *sqs.Client, or a fake in tests
+	QueueURL      string // looked up from QueuePrefix, TenantID and DeviceGroup when empty
+	QueuePrefix   string // defaults to DefaultQueuePrefix
+	TenantID      string
+	DeviceGroup   string
+	OnNotice      func([]RolloutNotice)
+	WaitTime      time.Duration // long-poll wait; defaults to 20 seconds, the SQS maximum
+	RetryInterval time.Duration // pause after a failed receive; defaults to 30 seconds
+}
+
+// NewQueueWatcher resolves the group's queue and starts watching it
+func NewQueueWatcher(config QueueWatcherConfig) (*QueueWatcher, error) {
+	if config.QueuePrefix == "" {
+		config.QueuePrefix = DefaultQueuePrefix
+	}
+	if config.WaitTime == 0 {
+		config.WaitTime = 20 * time.Second
+	}
+	if config.RetryInterval == 0 {
+		config.RetryInterval = 30 * time.Second
+	}
+
+	if config.OnNotice == nil {
+		return nil, errors.New("OnNotice is required")
+	}
+	if config.WaitTime < time.Second || config.WaitTime > 20*time.Second {
+		return nil, fmt.Errorf("wait time %s must be between 1s and 20s", config.WaitTime)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+
+	queueURL := config.QueueURL
+	if queueURL == "" {
+		if config.DeviceGroup == "" {
+			cancel()
+			return nil, errors.New("a queue URL or a device group is required")
+		}
+
+		result, err := config.SQSClient.GetQueueUrl(ctx, &sqs.GetQueueUrlInput{
+			QueueName: aws.String(QueueName(config.QueuePrefix, config.TenantID, config.DeviceGroup)),
+		})
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to look up rollout queue: %w", err)
+		}
+		queueURL = aws.ToString(result.QueueUrl)
+	}
+
+	qw := &QueueWatcher{
+		sqsClient:     config.SQSClient,
+		queueURL:      queueURL,
+		onNotice:      config.OnNotice,
+		waitTime:      int32(config.WaitTime / time.Second),
+		retryInterval: config.RetryInterval,
+		cancel:        cancel,
+		done:          make(chan struct{}),
+	}
+
+	go qw.watch(ctx)
+
+	return qw, nil
+}
+
+// watch receives notices until the watcher is closed
+func (qw *QueueWatcher) watch(ctx context.Context) {
+	defer close(qw.done)
+
+	for ctx.Err() == nil {
+		result, err := qw.sqsClient.ReceiveMessage(ctx, &sqs.ReceiveMessageInput{
+			QueueUrl:            aws.String(qw.queueURL),
+			MaxNumberOfMessages: 10,
+			WaitTimeSeconds:     qw.waitTime,
+		})
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			log.Printf("Failed to receive rollout notices: %v", err)
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(qw.retryInterval):
+			}
+			continue
+		}
+
+		if len(result.Messages) == 0 {
+			continue
+		}
+
+		notices := make([]RolloutNotice, 0, len(result.Messages))
+		entries := make([]sqstypes.DeleteMessageBatchRequestEntry, 0, len(result.Messages))
+		for i, message := range result.Messages {
+			var notice RolloutNotice
+			if err := json.Unmarshal([]byte(aws.ToString(message.Body)), &notice); err != nil {
+				log.Printf("Dropping unreadable rollout notice: %v", err)
+			} else {
+				notices = append(notices, notice)
+			}
+
+			entries = append(entries, sqstypes.DeleteMessageBatchRequestEntry{
+				Id:            aws.String(strconv.Itoa(i)),
+				ReceiptHandle: message.ReceiptHandle,
+			})
+		}
+
+		if len(notices) > 0 {
+			qw.onNotice(notices)
+		}
+
+		// Notices are only hints, so one delivered again costs a query at most
+		if _, err := qw.sqsClient.DeleteMessageBatch(ctx, &sqs.DeleteMessageBatchInput{
+			QueueUrl: aws.String(qw.queueURL),
+			Entries:  entries,
+		}); err != nil && ctx.Err() == nil {
+			log.Printf("Failed to delete rollout notices: %v", err)
+		}
+	}
+}
+
+// Close stops watching and waits for an outstanding receive to return
+func (qw *QueueWatcher) Close() {
+	qw.cancel()
+	<-qw.done
+}
@@ -0,0 +1,171 @@
+package rollout
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// RolloutTemplate is a reusable rollout plan whose string fields may contain
+// ${param} placeholders; a target group of exactly "${groups}" expands to
+// the comma-separated groups parameter
+type RolloutTemplate struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description"`
+	Parameters  []string          `json:"parameters"` // required parameters
+	Defaults    map[string]string `json:"defaults"`   // optional parameters and their values
+	Plan        RolloutPlan       `json:"plan"`
+}
+
+// BuiltinTemplates returns the standard rollout templates
+func BuiltinTemplates() map[string]RolloutTemplate {
+	defaults := func(extra map[string]string) map[string]string {
+		values := map[string]string{
+			"name":        "${version}",
+			"packageUrl":  "",
+			"packageHash": "",
+			"artifact":    "",
+		}
+		for k, v := range extra {
+			values[k] = v
+		}
+		return values
+	}
+
+	plan := func(phases ...RolloutPhase) RolloutPlan {
+		return RolloutPlan{
+			Name:         "${name}",
+			Version:      "${version}",
+			PackageURL:   "${packageUrl}",
+			PackageHash:  "${packageHash}",
+			ArtifactName: "${artifact}",
+			TargetGroups: []string{"${groups}"},
+			Phases:       phases,
+		}
+	}
+
+	return map[string]RolloutTemplate{
+		"canary": {
+			Name:        "canary",
+			Description: "Small canary cohort, approval-gated ramp, then the whole fleet",
+			Parameters:  []string{"version", "groups"},
+			Defaults:    defaults(map[string]string{"soak": "4h"}),
+			Plan: plan(
+				RolloutPhase{ID: "canary", Percentage: 5, Duration: "${soak}", Metrics: []string{"cpu_usage_percent", "memory_used_percent"}},
+				RolloutPhase{ID: "ramp", Percentage: 25, Duration: "${soak}", RequireApproval: true},
+				RolloutPhase{ID: "full", Percentage: 100, Duration: "0s"},
+			),
+		},
+		"big-bang": {
+			Name:        "big-bang",
+			Description: "Every targeted device at once, for urgent fixes",
+			Parameters:  []string{"version", "groups"},
+			Defaults:    defaults(nil),
+			Plan: plan(
+				RolloutPhase{ID: "all", Percentage: 100, Duration: "0s", RequireApproval: true},
+			),
+		},
+		"slow-ramp": {
+			Name:        "slow-ramp",
+			Description: "Six phases over several days for high-risk changes",
+			Parameters:  []string{"version", "groups"},
+			Defaults:    defaults(map[string]string{"soak": "24h"}),
+			Plan: plan(
+				RolloutPhase{ID: "pilot", Percentage: 1, Duration: "${soak}"},
+				RolloutPhase{ID: "early", Percentage: 5, Duration: "${soak}"},
+				RolloutPhase{ID: "tenth", Percentage: 10, Duration: "${soak}"},
+				RolloutPhase{ID: "quarter", Percentage: 25, Duration: "${soak}", RequireApproval: true},
+				RolloutPhase{ID: "half", Percentage: 50, Duration: "${soak}"},
+				RolloutPhase{ID: "full", Percentage: 100, Duration: "0s"},
+			),
+		},
+	}
+}
+
+// Instantiate builds a rollout plan from the template, substituting params;
+// it fails on missing required parameters and on placeholders with no value
+func (t RolloutTemplate) Instantiate(params map[string]string) (RolloutPlan, error) {
+	values := make(map[string]string, len(t.Defaults)+len(params))
+	for k, v := range t.Defaults {
+		values[k] = v
+	}
+	for k, v := range params {
+		values[k] = v
+	}
+
+	missing := make([]string, 0)
+	for _, name := range t.Parameters {
+		if values[name] == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return RolloutPlan{}, fmt.Errorf("template %s: missing parameters: %s", t.Name, strings.Join(missing, ", "))
+	}
+
+	unknown := make(map[string]bool)
+	expand := func(s string) string {
+		// Defaults may reference other parameters, e.g. name defaulting to ${version}
+		for i := 0; i < 4 && strings.Contains(s, "${"); i++ {
+			s = os.Expand(s, func(name string) string {
+				value, ok := values[name]
+				if !ok {
+					unknown[name] = true
+				}
+				return value
+			})
+		}
+		return s
+	}
+
+	plan := t.Plan
+	plan.Name = expand(plan.Name)
+	plan.Description = expand(plan.Description)
+	plan.Version = expand(plan.Version)
+	plan.PackageURL = expand(plan.PackageURL)
+	plan.PackageHash = expand(plan.PackageHash)
+	plan.ArtifactName = expand(plan.ArtifactName)
+	plan.RollbackPlan = expand(plan.RollbackPlan)
+	plan.ScheduledStart = expand(plan.ScheduledStart)
+	plan.ScheduleTimezone = expand(plan.ScheduleTimezone)
+
+	plan.TargetGroups = make([]string, 0, len(t.Plan.TargetGroups))
+	for _, group := range t.Plan.TargetGroups {
+		if group == "${groups}" {
+			for _, g := range strings.Split(values["groups"], ",") {
+				if g = strings.TrimSpace(g); g != "" {
+					plan.TargetGroups = append(plan.TargetGroups, g)
+				}
+			}
+			continue
+		}
+		plan.TargetGroups = append(plan.TargetGroups, expand(group))
+	}
+
+	// Copy phases so instances never share slices or maps with the template
+	plan.Phases = make([]RolloutPhase, len(t.Plan.Phases))
+	for i, phase := range t.Plan.Phases {
+		phase.ID = expand(phase.ID)
+		phase.Duration = expand(phase.Duration)
+		phase.Gate = expand(phase.Gate)
+		phase.Metrics = append([]string(nil), phase.Metrics...)
+		thresholds := make(map[string]float64, len(phase.Thresholds))
+		for metric, threshold := range phase.Thresholds {
+			thresholds[metric] = threshold
+		}
+		phase.Thresholds = thresholds
+		plan.Phases[i] = phase
+	}
+
+	if len(unknown) > 0 {
+		names := make([]string, 0, len(unknown))
+		for name := range unknown {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		return RolloutPlan{}, fmt.Errorf("template %s: no value for parameters: %s", t.Name, strings.Join(names, ", "))
+	}
+
+	return plan, nil
+}
@@ -0,0 +1,69 @@
+package rollout
+
+import (
+	"strconv"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// rolloutUsage counts the AWS usage a device incurs for one rollout. The
+// device reports the totals with its final update status, and the fleet
+// server's CostAccountant rolls them up per rollout.
+type rolloutUsage struct {
+	rolloutID  string
+	s3Bytes    int64
+	readUnits  float64
+	writeUnits float64
+
+	// Capacity consumed by a poll before it is known which rollout, if any, it found
+	pendingRead  float64
+	pendingWrite float64
+}
+
+// addCapacity records DynamoDB capacity consumed by a request
+func (u *rolloutUsage) addCapacity(consumed *types.ConsumedCapacity) {
+	if consumed == nil {
+		return
+	}
+
+	// Provisioned tables report read and write units; on-demand tables may only report the total
+	read, write := aws.ToFloat64(consumed.ReadCapacityUnits), aws.ToFloat64(consumed.WriteCapacityUnits)
+	if read == 0 && write == 0 {
+		read = aws.ToFloat64(consumed.CapacityUnits)
+	}
+
+	u.pendingRead += read
+	u.pendingWrite += write
+}
+
+// attribute charges the pending capacity to a rollout, starting new totals
+// when the device moves on to a different rollout
+func (u *rolloutUsage) attribute(rolloutID string) {
+	if u.rolloutID != rolloutID {
+		*u = rolloutUsage{rolloutID: rolloutID, pendingRead: u.pendingRead, pendingWrite: u.pendingWrite}
+	}
+
+	u.readUnits += u.pendingRead
+	u.writeUnits += u.pendingWrite
+	u.discard()
+}
+
+// discard drops pending capacity that belongs to no rollout
+func (u *rolloutUsage) discard() {
+	u.pendingRead = 0
+	u.pendingWrite = 0
+}
+
+// attributeValues returns the usage totals as device record values
+func (u *rolloutUsage) attributeValues() map[string]types.AttributeValue {
+	return map[string]types.AttributeValue{
+		":usageRollout": &types.AttributeValueMemberS{Value: u.rolloutID},
+		":usageBytes":   &types.AttributeValueMemberN{Value: strconv.FormatInt(u.s3Bytes, 10)},
+		":usageRead":    &types.AttributeValueMemberN{Value: strconv.FormatFloat(u.readUnits, 'f', -1, 64)},
+		":usageWrite":   &types.AttributeValueMemberN{Value: strconv.FormatFloat(u.writeUnits, 'f', -1, 64)},
+	}
+}
+
+// usageExpression sets the device record usage attributes from attributeValues
+const usageExpression = "UsageRolloutID = :usageRollout, UsageS3Bytes = :usageBytes, UsageReadUnits = :usageRead, UsageWriteUnits = :usageWrite"
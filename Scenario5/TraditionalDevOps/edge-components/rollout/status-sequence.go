@@ -0,0 +1,65 @@
+package rollout
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// sequenceFile in the update directory keeps the last status sequence
+// across restarts
+const sequenceFile = "status-sequence"
+
+// statusSequenceCondition only lets a status report through if it is newer
+// than the last one written, so a retried or delayed report can't overwrite
+// a later status. A report retried after its write succeeded fails it too,
+// which keeps the status counters from counting it twice.
+const statusSequenceCondition = "attribute_not_exists(StatusSequence) OR StatusSequence < :sequence"
+
+// statusSequence numbers a device's status reports. Numbers are the report
+// time in microseconds, kept increasing when the clock steps back.
+type statusSequence struct {
+	path  string
+	last  int64
+	mutex sync.Mutex
+}
+
+// newStatusSequence resumes the sequence recorded in dir
+func newStatusSequence(dir string) *statusSequence {
+	s := &statusSequence{path: filepath.Join(dir, sequenceFile)}
+	if data, err := os.ReadFile(s.path); err == nil {
+		s.last, _ = strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+	}
+	return s
+}
+
+// next returns the number for a report made now; it is recorded before it
+// is returned, so a restart can't reuse it
+func (s *statusSequence) next(now time.Time) (int64, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	sequence := now.UnixMicro()
+	if sequence <= s.last {
+		sequence = s.last + 1
+	}
+	if err := os.WriteFile(s.path, []byte(strconv.FormatInt(sequence, 10)), 0644); err != nil {
+		return 0, err
+	}
+	s.last = sequence
+	return sequence, nil
+}
+
+// Helper functions
+
+// superseded reports whether a status write failed statusSequenceCondition
+func superseded(err error) bool {
+	var conditionFailed *types.ConditionalCheckFailedException
+	return errors.As(err, &conditionFailed)
+}
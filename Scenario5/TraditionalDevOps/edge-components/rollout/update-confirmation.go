@@ -0,0 +1,237 @@
+package rollout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// confirmationFile records the update awaiting confirmation in the update
+// base path, so its deadline survives a restart or a reboot by the update
+const confirmationFile = "pending-confirmation.json"
+
+// pendingConfirmation is an applied update that is rolled back unless it is
+// confirmed by Deadline
+type pendingConfirmation struct {
+	RolloutID  string    `json:"rolloutId"`
+	Version    string    `json:"version"`
+	ConfigOnly bool      `json:"configOnly,omitempty"`
+	Kinds      []string  `json:"kinds,omitempty"` // artifact kinds of a bundle
+	Deadline   time.Time `json:"deadline"`
+}
+
+// ConfirmationWindow parses ConfirmWithin; zero means updates are final once
+// their health checks pass
+func (p RolloutPlan) ConfirmationWindow() (time.Duration, error) {
+	if p.ConfirmWithin == "" {
+		return 0, nil
+	}
+
+	window, err := time.ParseDuration(p.ConfirmWithin)
+	if err != nil {
+		return 0, fmt.Errorf("invalid confirmWithin %q: %w", p.ConfirmWithin, err)
+	}
+	if window < 0 {
+		return 0, fmt.Errorf("negative confirmWithin %q", p.ConfirmWithin)
+	}
+
+	return window, nil
+}
+
+// Confirm keeps the update applied for rolloutID, e.g. once the application
+// passes its own self-test after the update. It returns
+// ErrNoPendingConfirmation when that update isn't awaiting confirmation.
+func (rm *RolloutManager) Confirm(rolloutID string) error {
+	rm.confirmMutex.Lock()
+	pending := rm.confirmation
+	if pending == nil || pending.RolloutID != rolloutID {
+		rm.confirmMutex.Unlock()
+		return fmt.Errorf("%w: %s", ErrNoPendingConfirmation, rolloutID)
+	}
+	rm.clearConfirmation()
+	rm.confirmMutex.Unlock()
+
+	rm.logger.Printf("Update to version %s for rollout %s confirmed", pending.Version, rolloutID)
+	rm.polls.finished(rolloutID)
+
+	if err := rm.reportUpdateStatus(rolloutID, "success", "confirmed"); err != nil {
+		return fmt.Errorf("failed to report confirmation: %w", err)
+	}
+	return nil
+}
+
+// PendingConfirmation returns the rollout whose update awaits confirmation
+// and the deadline, or false when none does
+func (rm *RolloutManager) PendingConfirmation() (string, time.Time, bool) {
+	rm.confirmMutex.Lock()
+	defer rm.confirmMutex.Unlock()
+
+	if rm.confirmation == nil {
+		return "", time.Time{}, false
+	}
+	return rm.confirmation.RolloutID, rm.confirmation.Deadline, true
+}
+
+// awaitConfirmation holds an applied update until it is confirmed, rolling
+// it back at the end of the window
+func (rm *RolloutManager) awaitConfirmation(rollout *RolloutPlan, window time.Duration) error {
+	pending := &pendingConfirmation{
+		RolloutID:  rollout.ID,
+		Version:    rollout.Version,
+		ConfigOnly: rollout.IsConfigOnly(),
+		Kinds:      bundleKinds(rollout.Artifacts),
+		Deadline:   rm.clock.Now().Add(window),
+	}
+
+	// The in-memory deadline still applies if the record can't be written
+	if err := saveConfirmation(rm.confirmationPath(), pending); err != nil {
+		rm.logger.Printf("Failed to record pending confirmation: %v", err)
+	}
+
+	rm.confirmMutex.Lock()
+	rm.confirmation = pending
+	rm.armConfirmation()
+	rm.confirmMutex.Unlock()
+
+	return rm.reportUpdateStatus(rollout.ID, "awaiting-confirmation", "confirm by "+pending.Deadline.UTC().Format(time.RFC3339))
+}
+
+// checkConfirmation runs in place of the update check while an update awaits
+// confirmation: it confirms the update once an operator has, and rolls it
+// back when the deadline passed or the rollout was aborted. It returns false
+// when no update awaits confirmation.
+func (rm *RolloutManager) checkConfirmation() bool {
+	rm.confirmMutex.Lock()
+	pending := rm.confirmation
+	if pending != nil && rm.confirmTimer == nil {
+		// Loaded at startup; the deadline kept running meanwhile
+		rm.armConfirmation()
+	}
+	rm.confirmMutex.Unlock()
+
+	if pending == nil {
+		return false
+	}
+
+	if !rm.clock.Now().Before(pending.Deadline) {
+		rm.expireConfirmation(pending.RolloutID, "was not confirmed in time")
+		return true
+	}
+
+	ctx := context.Background()
+
+	info, err := rm.loadDeviceInfo(ctx, "ConfirmedUpdateID")
+	if err != nil {
+		rm.logger.Printf("Failed to check for update confirmation: %v", err)
+	} else if info.ConfirmedUpdateID == pending.RolloutID {
+		if err := rm.Confirm(pending.RolloutID); err != nil && !errors.Is(err, ErrNoPendingConfirmation) {
+			rm.logger.Printf("Failed to confirm update: %v", err)
+		}
+		return true
+	}
+
+	if status, err := rm.getRolloutStatus(ctx, pending.RolloutID); err == nil && status == "aborted" {
+		rm.expireConfirmation(pending.RolloutID, "was aborted before it was confirmed")
+	}
+
+	return true
+}
+
+// expireConfirmation rolls back an update that was not confirmed and reports
+// it failed
+func (rm *RolloutManager) expireConfirmation(rolloutID, reason string) {
+	rm.confirmMutex.Lock()
+	pending := rm.confirmation
+	if pending == nil || pending.RolloutID != rolloutID {
+		rm.confirmMutex.Unlock()
+		return
+	}
+	rm.clearConfirmation()
+	rm.confirmMutex.Unlock()
+
+	rollback := rm.rollbackUpdate
+	if pending.ConfigOnly {
+		rollback = rm.rollbackConfig
+	} else if len(pending.Kinds) > 0 {
+		rollback = func() error { return rm.rollbackBundle(pending.Kinds) }
+	}
+
+	message := fmt.Sprintf("update to version %s %s; rolled back", pending.Version, reason)
+	if err := rollback(); err != nil {
+		message = fmt.Sprintf("update to version %s %s; rollback failed: %v", pending.Version, reason, err)
+	}
+	rm.logger.Printf("Rollout %s: %s", rolloutID, message)
+	rm.polls.finished(rolloutID)
+
+	if err := rm.reportUpdateStatus(rolloutID, "failed", message); err != nil {
+		rm.logger.Printf("Failed to report unconfirmed update: %v", err)
+	}
+}
+
+// armConfirmation starts the deadline timer; confirmMutex must be held
+func (rm *RolloutManager) armConfirmation() {
+	rolloutID := rm.confirmation.RolloutID
+	rm.confirmTimer = time.AfterFunc(rm.confirmation.Deadline.Sub(rm.clock.Now()), func() {
+		rm.expireConfirmation(rolloutID, "was not confirmed in time")
+	})
+}
+
+// clearConfirmation drops the pending confirmation; confirmMutex must be held
+func (rm *RolloutManager) clearConfirmation() {
+	if rm.confirmTimer != nil {
+		rm.confirmTimer.Stop()
+		rm.confirmTimer = nil
+	}
+	rm.confirmation = nil
+
+	if err := os.Remove(rm.confirmationPath()); err != nil && !os.IsNotExist(err) {
+		rm.logger.Printf("Failed to remove pending confirmation record: %v", err)
+	}
+}
+
+// confirmationPath returns where the pending confirmation is recorded
+func (rm *RolloutManager) confirmationPath() string {
+	return filepath.Join(rm.updateBasePath, confirmationFile)
+}
+
+// Helper functions
+
+// loadConfirmation reads a recorded pending confirmation; nil when none is recorded
+func loadConfirmation(path string) (*pendingConfirmation, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pending confirmation: %w", err)
+	}
+
+	var pending pendingConfirmation
+	if err := json.Unmarshal(data, &pending); err != nil {
+		return nil, fmt.Errorf("failed to parse pending confirmation: %w", err)
+	}
+
+	return &pending, nil
+}
+
+// saveConfirmation records a pending confirmation, replacing the file atomically
+func saveConfirmation(path string, pending *pendingConfirmation) error {
+	data, err := json.Marshal(pending)
+	if err != nil {
+		return fmt.Errorf("failed to marshal pending confirmation: %w", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write pending confirmation: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write pending confirmation: %w", err)
+	}
+
+	return nil
+}
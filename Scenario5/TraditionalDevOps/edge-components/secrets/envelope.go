@@ -0,0 +1,158 @@
+package secrets
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+	kmstypes "github.com/aws/aws-sdk-go-v2/service/kms/types"
+)
+
+// Envelope is a KMS-wrapped secrets payload addressed to a single device
+type Envelope struct {
+	DeviceID         string    `json:"deviceId"`
+	KeyID            string    `json:"keyId"`
+	EncryptedDataKey []byte    `json:"encryptedDataKey"`
+	Nonce            []byte    `json:"nonce"`
+	Ciphertext       []byte    `json:"ciphertext"`
+	CreatedAt        time.Time `json:"createdAt"`
+}
+
+// Secret is a single named secret inside an envelope
+type Secret struct {
+	Name      string    `json:"name"`
+	Scope     string    `json:"scope"`
+	Value     []byte    `json:"value"`
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Sealer wraps secrets for individual devices using KMS envelope encryption
+type Sealer struct {
+	kmsClient *kms.Client
+	keyID     string
+}
+
+// NewSealer creates a new Sealer using the given KMS key
+func NewSealer(kmsClient *kms.Client, keyID string) *Sealer {
+	return &Sealer{
+		kmsClient: kmsClient,
+		keyID:     keyID,
+	}
+}
+
+// Seal encrypts secrets with a fresh data key bound to the device through the KMS encryption context
+func (s *Sealer) Seal(ctx context.Context, deviceID string, secrets []Secret) ([]byte, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal secrets: %w", err)
+	}
+
+	dataKey, err := s.kmsClient.GenerateDataKey(ctx, &kms.GenerateDataKeyInput{
+		KeyId:             aws.String(s.keyID),
+		KeySpec:           kmstypes.DataKeySpecAes256,
+		EncryptionContext: encryptionContext(deviceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %w", err)
+	}
+
+	nonce, ciphertext, err := encrypt(dataKey.Plaintext, plaintext)
+	if err != nil {
+		return nil, err
+	}
+
+	envelope := Envelope{
+		DeviceID:         deviceID,
+		KeyID:            s.keyID,
+		EncryptedDataKey: dataKey.CiphertextBlob,
+		Nonce:            nonce,
+		Ciphertext:       ciphertext,
+		CreatedAt:        time.Now().UTC(),
+	}
+
+	return json.Marshal(envelope)
+}
+
+// openEnvelope unwraps the data key with KMS and decrypts the secrets
+func openEnvelope(ctx context.Context, kmsClient *kms.Client, deviceID string, data []byte) ([]Secret, error) {
+	var envelope Envelope
+	if err := json.Unmarshal(data, &envelope); err != nil {
+		return nil, fmt.Errorf("failed to parse envelope: %w", err)
+	}
+
+	if envelope.DeviceID != deviceID {
+		return nil, fmt.Errorf("envelope addressed to %s, not %s", envelope.DeviceID, deviceID)
+	}
+
+	dataKey, err := kmsClient.Decrypt(ctx, &kms.DecryptInput{
+		KeyId:             aws.String(envelope.KeyID),
+		CiphertextBlob:    envelope.EncryptedDataKey,
+		EncryptionContext: encryptionContext(deviceID),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+
+	plaintext, err := decrypt(dataKey.Plaintext, envelope.Nonce, envelope.Ciphertext)
+	if err != nil {
+		return nil, err
+	}
+
+	var secrets []Secret
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("failed to parse secrets: %w", err)
+	}
+
+	return secrets, nil
+}
+
+// Helper functions
+
+func encryptionContext(deviceID string) map[string]string {
+	return map[string]string{"device-id": deviceID}
+}
+
+func encrypt(key, plaintext []byte) ([]byte, []byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %w", err)
+	}
+
+	return nonce, gcm.Seal(nil, nonce, plaintext, nil), nil
+}
+
+func decrypt(key, nonce, ciphertext []byte) ([]byte, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create cipher: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create GCM: %w", err)
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt: %w", err)
+	}
+
+	return plaintext, nil
+}
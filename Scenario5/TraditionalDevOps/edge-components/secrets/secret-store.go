@@ -0,0 +1,251 @@
+package secrets
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/kms"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+)
+
+const (
+	// secretKeyPrefix prefixes every secret in the local store
+	secretKeyPrefix = "secret/"
+
+	// nonceSize is the AES-GCM nonce size used for local encryption
+	nonceSize = 12
+)
+
+// SecretStore receives secret envelopes through the sync pipeline and stores them encrypted at rest
+type SecretStore struct {
+	store      kvstore.KVStore
+	kmsClient  *kms.Client
+	deviceID   string
+	localKey   []byte
+	storeMutex sync.RWMutex
+}
+
+// StoreConfig contains configuration for the SecretStore
+type StoreConfig struct {
+	DeviceID       string
+	BadgerDBPath   string
+	StorageBackend string // badger (default) or bolt
+	BoltDBPath     string
+
+	// LocalKeyPath holds the key that encrypts secrets at rest. It's stored
+	// unwrapped, so it only protects against copies of the database alone;
+	// keep it on a different volume from BadgerDBPath/BoltDBPath, ideally one
+	// that is itself encrypted or TPM-sealed
+	LocalKeyPath string
+	KMSClient    *kms.Client
+}
+
+// ScopedSecrets exposes the secrets of a single scope to an in-process consumer
+type ScopedSecrets struct {
+	store *SecretStore
+	scope string
+}
+
+// NewSecretStore creates a new SecretStore
+func NewSecretStore(config StoreConfig) (*SecretStore, error) {
+	localKey, err := loadOrCreateLocalKey(config.LocalKeyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	// Open the local store for encrypted secret storage
+	storePath := config.BadgerDBPath
+	if config.StorageBackend == kvstore.BackendBolt {
+		storePath = config.BoltDBPath
+	}
+	store, err := kvstore.Open(config.StorageBackend, storePath)
+	if err != nil {
+		return nil, err
+	}
+
+	return &SecretStore{
+		store:     store,
+		kmsClient: config.KMSClient,
+		deviceID:  config.DeviceID,
+		localKey:  localKey,
+	}, nil
+}
+
+// ProcessUpdate unwraps a secrets envelope delivered by the SyncManager and stores its contents;
+// the envelope carries the device's full secret set, so secrets missing from it are deleted
+func (ss *SecretStore) ProcessUpdate(key string, data []byte) error {
+	secrets, err := openEnvelope(context.Background(), ss.kmsClient, ss.deviceID, data)
+	if err != nil {
+		return fmt.Errorf("failed to open secrets envelope %s: %w", key, err)
+	}
+
+	ss.storeMutex.Lock()
+	defer ss.storeMutex.Unlock()
+
+	entries := make(map[string][]byte, len(secrets))
+	for _, secret := range secrets {
+		value, err := json.Marshal(secret)
+		if err != nil {
+			return fmt.Errorf("failed to marshal secret %s: %w", secret.Name, err)
+		}
+
+		nonce, ciphertext, err := encrypt(ss.localKey, value)
+		if err != nil {
+			return err
+		}
+
+		entries[string(storageKey(secret.Scope, secret.Name))] = append(nonce, ciphertext...)
+	}
+
+	if err := ss.store.SetBatch(entries); err != nil {
+		return fmt.Errorf("failed to store secrets from %s: %w", key, err)
+	}
+
+	// Remove secrets revoked since the previous envelope
+	var revoked [][]byte
+	err = ss.store.Iterate([]byte(secretKeyPrefix), func(storedKey, _ []byte) error {
+		if _, ok := entries[string(storedKey)]; !ok {
+			revoked = append(revoked, append([]byte(nil), storedKey...))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list stored secrets: %w", err)
+	}
+
+	for _, storedKey := range revoked {
+		if err := ss.store.Delete(storedKey); err != nil {
+			return fmt.Errorf("failed to delete revoked secret %s: %w", storedKey, err)
+		}
+	}
+
+	return nil
+}
+
+// GetLocalChanges returns nothing; secrets only flow from the cloud to the device
+func (ss *SecretStore) GetLocalChanges() (map[string][]byte, error) {
+	return nil, nil
+}
+
+// MergeConflicts always prefers the remote envelope
+func (ss *SecretStore) MergeConflicts(localData, remoteData []byte) ([]byte, error) {
+	return remoteData, nil
+}
+
+// Scope returns an accessor restricted to the secrets of a single scope
+func (ss *SecretStore) Scope(scope string) *ScopedSecrets {
+	return &ScopedSecrets{
+		store: ss,
+		scope: scope,
+	}
+}
+
+// Get returns the value of a secret in this scope
+func (s *ScopedSecrets) Get(name string) ([]byte, error) {
+	secret, err := s.store.get(s.scope, name)
+	if err != nil {
+		return nil, err
+	}
+
+	if !secret.ExpiresAt.IsZero() && time.Now().After(secret.ExpiresAt) {
+		return nil, fmt.Errorf("secret %s/%s expired at %s", s.scope, name, secret.ExpiresAt.Format(time.RFC3339))
+	}
+
+	return secret.Value, nil
+}
+
+// List returns the names of all secrets in this scope
+func (s *ScopedSecrets) List() ([]string, error) {
+	prefix := storageKey(s.scope, "")
+	names := make([]string, 0)
+
+	s.store.storeMutex.RLock()
+	defer s.store.storeMutex.RUnlock()
+
+	err := s.store.store.Iterate(prefix, func(key, _ []byte) error {
+		name := strings.TrimPrefix(string(key), string(prefix))
+		// Skip secrets of nested scopes such as "a/b" when listing "a"
+		if strings.Contains(name, "/") {
+			return nil
+		}
+		names = append(names, name)
+		return nil
+	})
+
+	return names, err
+}
+
+// get loads and decrypts a secret from the local store
+func (ss *SecretStore) get(scope, name string) (*Secret, error) {
+	ss.storeMutex.RLock()
+	defer ss.storeMutex.RUnlock()
+
+	stored, err := ss.store.Get(storageKey(scope, name))
+	if err == kvstore.ErrKeyNotFound {
+		return nil, fmt.Errorf("secret not found: %s/%s", scope, name)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read secret %s/%s: %w", scope, name, err)
+	}
+
+	if len(stored) < nonceSize {
+		return nil, fmt.Errorf("corrupt secret %s/%s", scope, name)
+	}
+
+	plaintext, err := decrypt(ss.localKey, stored[:nonceSize], stored[nonceSize:])
+	if err != nil {
+		return nil, err
+	}
+
+	var secret Secret
+	if err := json.Unmarshal(plaintext, &secret); err != nil {
+		return nil, fmt.Errorf("failed to parse secret %s/%s: %w", scope, name, err)
+	}
+
+	return &secret, nil
+}
+
+// Close closes the SecretStore and releases resources
+func (ss *SecretStore) Close() error {
+	return ss.store.Close()
+}
+
+// Helper functions
+
+func storageKey(scope, name string) []byte {
+	return []byte(fmt.Sprintf("%s%s/%s", secretKeyPrefix, scope, name))
+}
+
+func loadOrCreateLocalKey(path string) ([]byte, error) {
+	if key, err := ioutil.ReadFile(path); err == nil {
+		if len(key) != 32 {
+			return nil, fmt.Errorf("invalid local key length in %s", path)
+		}
+		return key, nil
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, fmt.Errorf("failed to generate local key: %w", err)
+	}
+
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write local key: %w", err)
+	}
+
+	return key, nil
+}
@@ -0,0 +1,354 @@
+package simulator
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// VirtualDevice mimics a device running RolloutManager and SyncManager
+type VirtualDevice struct {
+	ID            string
+	Group         string
+	Region        string
+	DynamicGroups []string
+	Version       string
+	FailureRate   float64
+	SyncBytes     int
+	rng           *rand.Rand
+}
+
+// Check performs one rollout check the way RolloutManager.checkForUpdates does,
+// returning the update status it reported, if any
+func (vd *VirtualDevice) Check(ctx context.Context, store Store) (string, error) {
+	plans, err := store.ActiveRollouts(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	for _, plan := range plans {
+		if !vd.targeted(plan) {
+			continue
+		}
+
+		if !vd.shouldApply(plan) {
+			return "", nil
+		}
+
+		if vd.rng.Float64() < vd.FailureRate {
+			return "failed", store.ReportStatus(ctx, vd.ID, plan.ID, "failed", "simulated update failure", "")
+		}
+
+		vd.Version = plan.Version
+		return "success", store.ReportStatus(ctx, vd.ID, plan.ID, "success", "", plan.Version)
+	}
+
+	return "", nil
+}
+
+// Sync performs one offline-sync upload the way SyncManager.syncLoop does
+func (vd *VirtualDevice) Sync(ctx context.Context, store Store) error {
+	if vd.SyncBytes == 0 {
+		return nil
+	}
+
+	key := fmt.Sprintf("devices/%s/data/telemetry/%d", vd.ID, time.Now().UnixNano())
+	return store.PutSyncObject(ctx, vd.ID, key, vd.SyncBytes)
+}
+
+// targeted reports whether a plan targets this device
+func (vd *VirtualDevice) targeted(plan rollout.RolloutPlan) bool {
+	if !plan.TargetsRegion(vd.Region) {
+		return false
+	}
+	for _, group := range plan.TargetGroups {
+		if group == vd.Group || group == "all" {
+			return true
+		}
+		for _, dg := range vd.DynamicGroups {
+			if group == dg {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// shouldApply mirrors RolloutManager.shouldApplyUpdate
+func (vd *VirtualDevice) shouldApply(plan rollout.RolloutPlan) bool {
+	if vd.Version == plan.Version || plan.CurrentPhase >= len(plan.Phases) || !plan.RegionOpen(vd.Region) {
+		return false
+	}
+
+	phase := plan.Phases[plan.CurrentPhase].ForGroup(vd.Group)
+	if phase.RequireApproval && !phase.Approved {
+		return false
+	}
+
+	return rollout.DevicePercentile(vd.ID) <= phase.Percentage
+}
+
+// PhaseResult summarizes device behaviour during one phase of a simulated rollout
+type PhaseResult struct {
+	PhaseID    string        `json:"phaseId"`
+	Percentage float64       `json:"percentage"`
+	Checks     int64         `json:"checks"`
+	Updated    int           `json:"updated"`
+	Failed     int           `json:"failed"`
+	Errors     int64         `json:"errors"`
+	Duration   time.Duration `json:"duration"`
+	Store      StoreStats    `json:"store"`
+}
+
+// Result summarizes a simulated rollout
+type Result struct {
+	RolloutID       string        `json:"rolloutId"`
+	Devices         int           `json:"devices"`
+	Phases          []PhaseResult `json:"phases"`
+	OnVersion       int           `json:"onVersion"`
+	FailedDevices   int           `json:"failedDevices"`
+	Duration        time.Duration `json:"duration"`
+	ReadsPerSecond  float64       `json:"readsPerSecond"`
+	WritesPerSecond float64       `json:"writesPerSecond"`
+}
+
+// Simulator drives a fleet of virtual devices through a rollout plan
+type Simulator struct {
+	store         Store
+	devices       []*VirtualDevice
+	checkInterval time.Duration
+	syncInterval  time.Duration
+	phaseDuration time.Duration
+	autoApprove   bool
+	concurrency   int
+}
+
+// SimulatorConfig contains configuration for the Simulator
+type SimulatorConfig struct {
+	Store          Store
+	Devices        int
+	Groups         []string
+	Regions        []string // spread across devices like Groups; none leaves devices without a region
+	InitialVersion string
+	FailureRate    float64
+	GroupFailure   map[string]float64 // overrides FailureRate per group
+	SyncBytes      int
+	CheckInterval  time.Duration
+	SyncInterval   time.Duration
+	PhaseDuration  time.Duration // simulated time spent in each phase
+	AutoApprove    bool
+	Concurrency    int
+	Seed           int64
+}
+
+// NewSimulator creates a Simulator with a fleet of virtual devices spread evenly across groups
+func NewSimulator(config SimulatorConfig) (*Simulator, error) {
+	if config.Store == nil {
+		return nil, fmt.Errorf("a store is required")
+	}
+
+	if config.Devices <= 0 || config.CheckInterval <= 0 || config.PhaseDuration <= 0 {
+		return nil, fmt.Errorf("devices, check interval and phase duration must be positive")
+	}
+
+	groups := config.Groups
+	if len(groups) == 0 {
+		groups = []string{"default"}
+	}
+
+	concurrency := config.Concurrency
+	if concurrency <= 0 {
+		concurrency = 64
+	}
+
+	s := &Simulator{
+		store:         config.Store,
+		devices:       make([]*VirtualDevice, 0, config.Devices),
+		checkInterval: config.CheckInterval,
+		syncInterval:  config.SyncInterval,
+		phaseDuration: config.PhaseDuration,
+		autoApprove:   config.AutoApprove,
+		concurrency:   concurrency,
+	}
+
+	for i := 0; i < config.Devices; i++ {
+		group := groups[i%len(groups)]
+
+		failureRate := config.FailureRate
+		if rate, ok := config.GroupFailure[group]; ok {
+			failureRate = rate
+		}
+
+		region := ""
+		if len(config.Regions) > 0 {
+			region = config.Regions[i%len(config.Regions)]
+		}
+
+		s.devices = append(s.devices, &VirtualDevice{
+			ID:          fmt.Sprintf("sim-%s-%06d", group, i),
+			Group:       group,
+			Region:      region,
+			Version:     config.InitialVersion,
+			FailureRate: failureRate,
+			SyncBytes:   config.SyncBytes,
+			rng:         rand.New(rand.NewSource(config.Seed + int64(i))),
+		})
+	}
+
+	return s, nil
+}
+
+// Run simulates a rollout phase by phase; the simulation advances in virtual
+// time, one check round per check interval, so thousands of devices can be
+// driven through hours of rollout in seconds
+func (s *Simulator) Run(ctx context.Context, plan rollout.RolloutPlan) (*Result, error) {
+	started := time.Now()
+	result := &Result{
+		RolloutID: plan.ID,
+		Devices:   len(s.devices),
+		Phases:    make([]PhaseResult, 0, len(plan.Phases)),
+	}
+
+	rounds := int(s.phaseDuration / s.checkInterval)
+	if rounds < 1 {
+		rounds = 1
+	}
+
+	syncEvery := 0
+	if s.syncInterval > 0 {
+		syncEvery = int(s.syncInterval / s.checkInterval)
+		if syncEvery < 1 {
+			syncEvery = 1
+		}
+	}
+
+	failed := make(map[string]bool)
+
+	for i, phase := range plan.Phases {
+		if err := s.store.SetPhase(ctx, plan.ID, i, s.autoApprove); err != nil {
+			return nil, fmt.Errorf("failed to start phase %s: %w", phase.ID, err)
+		}
+
+		before := s.store.Stats()
+		phaseStart := time.Now()
+		phaseResult := PhaseResult{PhaseID: phase.ID, Percentage: phase.Percentage}
+
+		for round := 0; round < rounds; round++ {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+
+			outcomes := s.round(ctx, syncEvery > 0 && round%syncEvery == 0)
+			phaseResult.Checks += int64(len(s.devices))
+			phaseResult.Errors += outcomes.errors
+			phaseResult.Updated += outcomes.updated
+			phaseResult.Failed += outcomes.failed
+			for _, id := range outcomes.failedIDs {
+				failed[id] = true
+			}
+		}
+
+		phaseResult.Duration = time.Since(phaseStart)
+		phaseResult.Store = diffStats(s.store.Stats(), before)
+		result.Phases = append(result.Phases, phaseResult)
+
+		log.Printf("Simulated phase %s (%.0f%%): %d updated, %d failed, %d store errors",
+			phase.ID, phase.Percentage, phaseResult.Updated, phaseResult.Failed, phaseResult.Errors)
+	}
+
+	if err := s.store.SetPhase(ctx, plan.ID, len(plan.Phases), false); err != nil {
+		return nil, fmt.Errorf("failed to complete rollout: %w", err)
+	}
+
+	for _, device := range s.devices {
+		if device.Version == plan.Version {
+			result.OnVersion++
+		}
+	}
+	result.FailedDevices = len(failed)
+	result.Duration = time.Since(started)
+
+	// Rates are per second of simulated time, which is what table capacity must sustain
+	stats := s.store.Stats()
+	simulated := (time.Duration(rounds*len(plan.Phases)) * s.checkInterval).Seconds()
+	if simulated > 0 {
+		result.ReadsPerSecond = float64(stats.Reads) / simulated
+		result.WritesPerSecond = float64(stats.Writes) / simulated
+	}
+
+	return result, nil
+}
+
+// roundOutcome counts the results of one check round
+type roundOutcome struct {
+	updated   int
+	failed    int
+	errors    int64
+	failedIDs []string
+}
+
+// round runs one check (and optionally one sync) on every device using a bounded worker pool
+func (s *Simulator) round(ctx context.Context, doSync bool) roundOutcome {
+	var (
+		outcome roundOutcome
+		mutex   sync.Mutex
+		wg      sync.WaitGroup
+	)
+
+	work := make(chan *VirtualDevice)
+	for w := 0; w < s.concurrency; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for device := range work {
+				status, err := device.Check(ctx, s.store)
+				if err == nil && doSync {
+					err = device.Sync(ctx, s.store)
+				}
+
+				mutex.Lock()
+				switch {
+				case err != nil:
+					outcome.errors++
+				case status == "success":
+					outcome.updated++
+				case status == "failed":
+					outcome.failed++
+					outcome.failedIDs = append(outcome.failedIDs, device.ID)
+				}
+				mutex.Unlock()
+			}
+		}()
+	}
+
+	for _, device := range s.devices {
+		work <- device
+	}
+	close(work)
+	wg.Wait()
+
+	sort.Strings(outcome.failedIDs)
+	return outcome
+}
+
+// Helper functions
+
+func diffStats(after, before StoreStats) StoreStats {
+	return StoreStats{
+		Reads:         after.Reads - before.Reads,
+		Writes:        after.Writes - before.Writes,
+		SyncUploads:   after.SyncUploads - before.SyncUploads,
+		SyncBytes:     after.SyncBytes - before.SyncBytes,
+		ReadCapacity:  after.ReadCapacity - before.ReadCapacity,
+		WriteCapacity: after.WriteCapacity - before.WriteCapacity,
+		Throttled:     after.Throttled - before.Throttled,
+		Errors:        after.Errors - before.Errors,
+		TotalLatency:  after.TotalLatency - before.TotalLatency,
+	}
+}
@@ -0,0 +1,367 @@
+package simulator
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// Store is the backend virtual devices talk to in place of DynamoDB and S3
+type Store interface {
+	// ActiveRollouts returns the in-progress rollouts, as RolloutManager.getActiveRollout queries them
+	ActiveRollouts(ctx context.Context) ([]rollout.RolloutPlan, error)
+
+	// ReportStatus records a device's update outcome, as RolloutManager.reportUpdateStatus does
+	ReportStatus(ctx context.Context, deviceID, rolloutID, status, message, version string) error
+
+	// SetPhase moves a rollout to a phase, optionally approving it
+	SetPhase(ctx context.Context, rolloutID string, phase int, approve bool) error
+
+	// PutSyncObject records an offline-sync upload
+	PutSyncObject(ctx context.Context, deviceID, key string, size int) error
+
+	// Stats returns the operation counters accumulated so far
+	Stats() StoreStats
+}
+
+// StoreStats counts store operations and the capacity they consumed
+type StoreStats struct {
+	Reads         int64         `json:"reads"`
+	Writes        int64         `json:"writes"`
+	SyncUploads   int64         `json:"syncUploads"`
+	SyncBytes     int64         `json:"syncBytes"`
+	ReadCapacity  float64       `json:"readCapacity"`
+	WriteCapacity float64       `json:"writeCapacity"`
+	Throttled     int64         `json:"throttled"`
+	Errors        int64         `json:"errors"`
+	TotalLatency  time.Duration `json:"totalLatency"`
+}
+
+// MemoryStore is an in-memory Store that estimates the DynamoDB capacity the
+// same traffic would consume
+type MemoryStore struct {
+	rollouts map[string]*rollout.RolloutPlan
+	devices  map[string]map[string]string
+	stats    StoreStats
+	mutex    sync.Mutex
+}
+
+// NewMemoryStore creates a MemoryStore seeded with rollout plans
+func NewMemoryStore(plans ...rollout.RolloutPlan) *MemoryStore {
+	ms := &MemoryStore{
+		rollouts: make(map[string]*rollout.RolloutPlan),
+		devices:  make(map[string]map[string]string),
+	}
+
+	for i := range plans {
+		plan := plans[i]
+		ms.rollouts[plan.ID] = &plan
+	}
+
+	return ms
+}
+
+// ActiveRollouts returns copies of the in-progress rollouts
+func (ms *MemoryStore) ActiveRollouts(ctx context.Context) ([]rollout.RolloutPlan, error) {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	active := make([]rollout.RolloutPlan, 0)
+	for _, plan := range ms.rollouts {
+		if plan.Status != "in-progress" {
+			continue
+		}
+		copied := *plan
+		copied.Phases = append([]rollout.RolloutPhase{}, plan.Phases...)
+		active = append(active, copied)
+
+		// An eventually consistent query costs 0.5 RCU per 4 KB
+		ms.stats.ReadCapacity += 0.5 * capacityUnits(estimatePlanSize(plan), 4096)
+	}
+	ms.stats.Reads++
+
+	return active, nil
+}
+
+// ReportStatus records a device's update outcome
+func (ms *MemoryStore) ReportStatus(ctx context.Context, deviceID, rolloutID, status, message, version string) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	device := ms.devices[deviceID]
+	if device == nil {
+		device = make(map[string]string)
+		ms.devices[deviceID] = device
+	}
+
+	device["UpdateStatus"] = status
+	device["LastUpdateID"] = rolloutID
+	device["LastUpdateMessage"] = message
+	device["LastUpdateTime"] = time.Now().UTC().Format(time.RFC3339)
+	if version != "" {
+		device["CurrentVersion"] = version
+	}
+
+	size := len(deviceID)
+	for k, v := range device {
+		size += len(k) + len(v)
+	}
+
+	ms.stats.Writes++
+	ms.stats.WriteCapacity += capacityUnits(size, 1024)
+
+	return nil
+}
+
+// SetPhase moves a rollout to a phase
+func (ms *MemoryStore) SetPhase(ctx context.Context, rolloutID string, phase int, approve bool) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	plan, ok := ms.rollouts[rolloutID]
+	if !ok {
+		return fmt.Errorf("rollout not found: %s", rolloutID)
+	}
+
+	if phase >= len(plan.Phases) {
+		plan.Status = "completed"
+	} else {
+		plan.CurrentPhase = phase
+		if approve {
+			plan.Phases[phase].Approved = true
+		}
+	}
+
+	ms.stats.Writes++
+	ms.stats.WriteCapacity += capacityUnits(estimatePlanSize(plan), 1024)
+
+	return nil
+}
+
+// PutSyncObject counts an offline-sync upload
+func (ms *MemoryStore) PutSyncObject(ctx context.Context, deviceID, key string, size int) error {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	ms.stats.SyncUploads++
+	ms.stats.SyncBytes += int64(size)
+
+	return nil
+}
+
+// Stats returns the accumulated counters
+func (ms *MemoryStore) Stats() StoreStats {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+	return ms.stats
+}
+
+// DeviceStatus returns the recorded update status of a device
+func (ms *MemoryStore) DeviceStatus(deviceID string) map[string]string {
+	ms.mutex.Lock()
+	defer ms.mutex.Unlock()
+
+	status := make(map[string]string)
+	for k, v := range ms.devices[deviceID] {
+		status[k] = v
+	}
+	return status
+}
+
+// DynamoStore sends virtual device traffic to real DynamoDB tables and
+// records the capacity DynamoDB reports as consumed
+type DynamoStore struct {
+	dynamoClient     *dynamodb.Client
+	rolloutTableName string
+	deviceTableName  string
+	stats            StoreStats
+	mutex            sync.Mutex
+}
+
+// NewDynamoStore creates a new DynamoStore
+func NewDynamoStore(dynamoClient *dynamodb.Client, rolloutTableName, deviceTableName string) *DynamoStore {
+	return &DynamoStore{
+		dynamoClient:     dynamoClient,
+		rolloutTableName: rolloutTableName,
+		deviceTableName:  deviceTableName,
+	}
+}
+
+// ActiveRollouts queries the rollout table's StatusIndex
+func (ds *DynamoStore) ActiveRollouts(ctx context.Context) ([]rollout.RolloutPlan, error) {
+	start := time.Now()
+	result, err := ds.dynamoClient.Query(ctx, &dynamodb.QueryInput{
+		TableName:              aws.String(ds.rolloutTableName),
+		IndexName:              aws.String("StatusIndex"),
+		KeyConditionExpression: aws.String("#status = :status"),
+		ExpressionAttributeNames: map[string]string{
+			"#status": "Status",
+		},
+		ExpressionAttributeValues: map[string]types.AttributeValue{
+			":status": &types.AttributeValueMemberS{Value: "in-progress"},
+		},
+		ReturnConsumedCapacity: types.ReturnConsumedCapacityTotal,
+	})
+	ds.record(false, start, err, func() *types.ConsumedCapacity {
+		if result == nil {
+			return nil
+		}
+		return result.ConsumedCapacity
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to query active rollouts: %w", err)
+	}
+
+	var plans []rollout.RolloutPlan
+	if err := attributevalue.UnmarshalListOfMaps(result.Items, &plans); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal rollouts: %w", err)
+	}
+
+	return plans, nil
+}
+
+// ReportStatus writes a device's update outcome to the device table
+func (ds *DynamoStore) ReportStatus(ctx context.Context, deviceID, rolloutID, status, message, version string) error {
+	expression := "SET UpdateStatus = :status, LastUpdateID = :rolloutID, LastUpdateTime = :time, LastUpdateMessage = :message"
+	values := map[string]types.AttributeValue{
+		":status":    &types.AttributeValueMemberS{Value: status},
+		":rolloutID": &types.AttributeValueMemberS{Value: rolloutID},
+		":time":      &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+		":message":   &types.AttributeValueMemberS{Value: message},
+	}
+	if version != "" {
+		expression += ", CurrentVersion = :version"
+		values[":version"] = &types.AttributeValueMemberS{Value: version}
+	}
+
+	start := time.Now()
+	result, err := ds.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(ds.deviceTableName),
+		Key: map[string]types.AttributeValue{
+			"DeviceID": &types.AttributeValueMemberS{Value: deviceID},
+		},
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeValues: values,
+		ReturnConsumedCapacity:    types.ReturnConsumedCapacityTotal,
+	})
+	ds.record(true, start, err, func() *types.ConsumedCapacity {
+		if result == nil {
+			return nil
+		}
+		return result.ConsumedCapacity
+	})
+
+	return err
+}
+
+// SetPhase updates the rollout's current phase and approval
+func (ds *DynamoStore) SetPhase(ctx context.Context, rolloutID string, phase int, approve bool) error {
+	expression := "SET CurrentPhase = :phase, UpdatedAt = :time"
+	values := map[string]types.AttributeValue{
+		":phase": &types.AttributeValueMemberN{Value: fmt.Sprintf("%d", phase)},
+		":time":  &types.AttributeValueMemberS{Value: time.Now().UTC().Format(time.RFC3339)},
+	}
+	if approve {
+		expression += fmt.Sprintf(", Phases[%d].Approved = :true", phase)
+		values[":true"] = &types.AttributeValueMemberBOOL{Value: true}
+	}
+	// Bump the revision like the fleet server does, so its writes conflict
+	expression += " ADD Revision :one"
+	values[":one"] = &types.AttributeValueMemberN{Value: "1"}
+
+	start := time.Now()
+	result, err := ds.dynamoClient.UpdateItem(ctx, &dynamodb.UpdateItemInput{
+		TableName: aws.String(ds.rolloutTableName),
+		Key: map[string]types.AttributeValue{
+			"ID": &types.AttributeValueMemberS{Value: rolloutID},
+		},
+		UpdateExpression:          aws.String(expression),
+		ExpressionAttributeValues: values,
+		ReturnConsumedCapacity:    types.ReturnConsumedCapacityTotal,
+	})
+	ds.record(true, start, err, func() *types.ConsumedCapacity {
+		if result == nil {
+			return nil
+		}
+		return result.ConsumedCapacity
+	})
+
+	return err
+}
+
+// PutSyncObject counts an offline-sync upload; sync traffic is not sent to S3
+func (ds *DynamoStore) PutSyncObject(ctx context.Context, deviceID, key string, size int) error {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.stats.SyncUploads++
+	ds.stats.SyncBytes += int64(size)
+
+	return nil
+}
+
+// Stats returns the accumulated counters
+func (ds *DynamoStore) Stats() StoreStats {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+	return ds.stats
+}
+
+// record accumulates the outcome of a DynamoDB call
+func (ds *DynamoStore) record(write bool, start time.Time, err error, consumed func() *types.ConsumedCapacity) {
+	ds.mutex.Lock()
+	defer ds.mutex.Unlock()
+
+	ds.stats.TotalLatency += time.Since(start)
+
+	if write {
+		ds.stats.Writes++
+	} else {
+		ds.stats.Reads++
+	}
+
+	if err != nil {
+		var throttled *types.ProvisionedThroughputExceededException
+		if errors.As(err, &throttled) {
+			ds.stats.Throttled++
+		} else {
+			ds.stats.Errors++
+		}
+		return
+	}
+
+	if capacity := consumed(); capacity != nil && capacity.CapacityUnits != nil {
+		if write {
+			ds.stats.WriteCapacity += *capacity.CapacityUnits
+		} else {
+			ds.stats.ReadCapacity += *capacity.CapacityUnits
+		}
+	}
+}
+
+// Helper functions
+
+func capacityUnits(size, unit int) float64 {
+	units := (size + unit - 1) / unit
+	if units < 1 {
+		units = 1
+	}
+	return float64(units)
+}
+
+func estimatePlanSize(plan *rollout.RolloutPlan) int {
+	size := len(plan.ID) + len(plan.Name) + len(plan.Description) + len(plan.Version) + len(plan.PackageURL) + len(plan.PackageHash) + 128
+	for _, g := range plan.TargetGroups {
+		size += len(g)
+	}
+	return size + 96*len(plan.Phases)
+}
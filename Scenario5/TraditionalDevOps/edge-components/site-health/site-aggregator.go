@@ -0,0 +1,334 @@
+package sitehealth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+)
+
+// Site statuses, from best to worst
+const (
+	StatusOK       = "ok"       // every device reachable and healthy
+	StatusDegraded = "degraded" // some devices unhealthy or unreachable
+	StatusFailing  = "failing"  // fewer than half of the devices healthy
+)
+
+// DeviceHealth is the last poll of one LAN device
+type DeviceHealth struct {
+	DeviceID      string               `json:"deviceId"`
+	Address       string               `json:"address"`
+	Reachable     bool                 `json:"reachable"`
+	Healthy       bool                 `json:"healthy"`
+	Checks        map[string]string    `json:"checks,omitempty"`  // liveness check name -> ok or the error
+	Rollout       *rollout.LocalStatus `json:"rollout,omitempty"` // nil when the device doesn't serve it
+	Error         string               `json:"error,omitempty"`
+	CheckedAt     time.Time            `json:"checkedAt"`
+	LastReachable *time.Time           `json:"lastReachable,omitempty"`
+}
+
+// SiteSummary is the health of every device at a site
+type SiteSummary struct {
+	SiteID      string                    `json:"siteId"`
+	GatewayID   string                    `json:"gatewayId"`
+	Status      string                    `json:"status"`
+	GeneratedAt time.Time                 `json:"generatedAt"`
+	Devices     int                       `json:"devices"`
+	Healthy     int                       `json:"healthy"`
+	Unhealthy   int                       `json:"unhealthy"`
+	Unreachable int                       `json:"unreachable"`
+	Rollouts    map[string]map[string]int `json:"rollouts"` // rollout ID -> last update status -> devices
+	DeviceList  []DeviceHealth            `json:"deviceList"`
+}
+
+// SiteAggregator runs on a gateway and polls every LAN device's probe server
+// for /healthz and its rollout status, so the cloud, or an operator who can
+// only reach the gateway, gets one summary for the whole site. Devices serve
+// their rollout status with ProbeServer.ServeLocalStatus.
+type SiteAggregator struct {
+	siteID     string
+	gatewayID  string
+	httpClient *http.Client
+	devices    map[string]string // device ID -> probe server base URL
+	health     map[string]DeviceHealth
+	interval   time.Duration
+	mutex      sync.RWMutex
+	pollMutex  sync.Mutex
+	timer      *time.Timer
+}
+
+// SiteAggregatorConfig contains configuration for the SiteAggregator
+type SiteAggregatorConfig struct {
+	SiteID     string
+	GatewayID  string
+	Devices    map[string]string // device ID -> probe server base URL, e.g. http://10.0.0.12:8086
+	HTTPClient *http.Client      // defaults to a client with a 5 second timeout
+	Interval   time.Duration     // defaults to 30 seconds
+}
+
+// NewSiteAggregator creates a new SiteAggregator and starts polling
+func NewSiteAggregator(config SiteAggregatorConfig) *SiteAggregator {
+	if config.HTTPClient == nil {
+		config.HTTPClient = &http.Client{Timeout: 5 * time.Second}
+	}
+	if config.Interval == 0 {
+		config.Interval = 30 * time.Second
+	}
+
+	sa := &SiteAggregator{
+		siteID:     config.SiteID,
+		gatewayID:  config.GatewayID,
+		httpClient: config.HTTPClient,
+		devices:    make(map[string]string),
+		health:     make(map[string]DeviceHealth),
+		interval:   config.Interval,
+	}
+	sa.SetDevices(config.Devices)
+
+	// Start the poll timer
+	sa.timer = time.AfterFunc(0, sa.pollLoop)
+
+	return sa
+}
+
+// pollLoop polls the site's devices and reschedules itself
+func (sa *SiteAggregator) pollLoop() {
+	defer func() {
+		// Reschedule the poll
+		sa.timer.Reset(sa.interval)
+	}()
+
+	sa.Poll(context.Background())
+}
+
+// SetDevices replaces the devices to poll, e.g. when a device joins the
+// site; devices no longer listed are dropped from the summary
+func (sa *SiteAggregator) SetDevices(devices map[string]string) {
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+
+	sa.devices = make(map[string]string, len(devices))
+	for deviceID, address := range devices {
+		sa.devices[deviceID] = strings.TrimSuffix(address, "/")
+	}
+	for deviceID := range sa.health {
+		if _, ok := sa.devices[deviceID]; !ok {
+			delete(sa.health, deviceID)
+		}
+	}
+}
+
+// Poll checks every device concurrently and records the results
+func (sa *SiteAggregator) Poll(ctx context.Context) {
+	sa.pollMutex.Lock()
+	defer sa.pollMutex.Unlock()
+
+	sa.mutex.RLock()
+	devices := make(map[string]string, len(sa.devices))
+	for deviceID, address := range sa.devices {
+		devices[deviceID] = address
+	}
+	sa.mutex.RUnlock()
+
+	results := make(chan DeviceHealth, len(devices))
+	var wg sync.WaitGroup
+	for deviceID, address := range devices {
+		wg.Add(1)
+		go func(deviceID, address string) {
+			defer wg.Done()
+			results <- sa.check(ctx, deviceID, address)
+		}(deviceID, address)
+	}
+	wg.Wait()
+	close(results)
+
+	sa.mutex.Lock()
+	defer sa.mutex.Unlock()
+
+	for health := range results {
+		// Skip devices removed while the poll ran
+		if _, ok := sa.devices[health.DeviceID]; !ok {
+			continue
+		}
+
+		// Log only when a device becomes unreachable or comes back
+		previous, seen := sa.health[health.DeviceID]
+		switch {
+		case !health.Reachable:
+			health.LastReachable = previous.LastReachable
+			if !seen || previous.Reachable {
+				log.Printf("Site device %s unreachable: %s", health.DeviceID, health.Error)
+			}
+		case seen && !previous.Reachable:
+			log.Printf("Site device %s reachable again", health.DeviceID)
+		}
+		sa.health[health.DeviceID] = health
+	}
+}
+
+// Summary returns the site's health from the last poll; devices not polled
+// yet count as unreachable
+func (sa *SiteAggregator) Summary() SiteSummary {
+	sa.mutex.RLock()
+	defer sa.mutex.RUnlock()
+
+	summary := SiteSummary{
+		SiteID:      sa.siteID,
+		GatewayID:   sa.gatewayID,
+		GeneratedAt: time.Now().UTC(),
+		Devices:     len(sa.devices),
+		Rollouts:    make(map[string]map[string]int),
+		DeviceList:  make([]DeviceHealth, 0, len(sa.devices)),
+	}
+
+	for deviceID, address := range sa.devices {
+		health, ok := sa.health[deviceID]
+		if !ok {
+			health = DeviceHealth{DeviceID: deviceID, Address: address, Error: "not polled yet"}
+		}
+		summary.DeviceList = append(summary.DeviceList, health)
+
+		switch {
+		case !health.Reachable:
+			summary.Unreachable++
+		case health.Healthy:
+			summary.Healthy++
+		default:
+			summary.Unhealthy++
+		}
+
+		if health.Rollout != nil && health.Rollout.UpdateRolloutID != "" {
+			counts := summary.Rollouts[health.Rollout.UpdateRolloutID]
+			if counts == nil {
+				counts = make(map[string]int)
+				summary.Rollouts[health.Rollout.UpdateRolloutID] = counts
+			}
+			counts[health.Rollout.UpdateStatus]++
+		}
+	}
+
+	sort.Slice(summary.DeviceList, func(i, j int) bool {
+		return summary.DeviceList[i].DeviceID < summary.DeviceList[j].DeviceID
+	})
+
+	switch {
+	case summary.Healthy == summary.Devices:
+		summary.Status = StatusOK
+	case 2*summary.Healthy < summary.Devices:
+		summary.Status = StatusFailing
+	default:
+		summary.Status = StatusDegraded
+	}
+
+	return summary
+}
+
+// Handler serves the summary upstream: GET /site/health answers 503 while
+// the site is failing, and GET /site/devices/{id} returns one device
+func (sa *SiteAggregator) Handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /site/health", func(w http.ResponseWriter, r *http.Request) {
+		summary := sa.Summary()
+
+		status := http.StatusOK
+		if summary.Status == StatusFailing {
+			status = http.StatusServiceUnavailable
+		}
+		writeJSON(w, status, summary)
+	})
+
+	mux.HandleFunc("GET /site/devices/{id}", func(w http.ResponseWriter, r *http.Request) {
+		for _, health := range sa.Summary().DeviceList {
+			if health.DeviceID == r.PathValue("id") {
+				writeJSON(w, http.StatusOK, health)
+				return
+			}
+		}
+		writeJSON(w, http.StatusNotFound, map[string]string{"error": "device not found: " + r.PathValue("id")})
+	})
+
+	return mux
+}
+
+// Close stops polling
+func (sa *SiteAggregator) Close() {
+	if sa.timer != nil {
+		sa.timer.Stop()
+	}
+}
+
+// check polls one device's liveness probe and rollout status
+func (sa *SiteAggregator) check(ctx context.Context, deviceID, address string) DeviceHealth {
+	now := time.Now().UTC()
+	health := DeviceHealth{DeviceID: deviceID, Address: address, CheckedAt: now}
+
+	// A failing probe answers 503 with the same body
+	var probe rollout.ProbeResult
+	status, err := sa.getJSON(ctx, address+"/healthz", &probe)
+	if err != nil {
+		health.Error = err.Error()
+		return health
+	}
+	if status != http.StatusOK && status != http.StatusServiceUnavailable {
+		health.Error = fmt.Sprintf("healthz returned %d", status)
+		return health
+	}
+
+	health.Reachable = true
+	health.LastReachable = &now
+	health.Healthy = status == http.StatusOK && probe.Status == "ok"
+	health.Checks = probe.Checks
+
+	// Devices without ServeLocalStatus answer 404; their rollout status is left out
+	var local rollout.LocalStatus
+	status, err = sa.getJSON(ctx, address+"/rollout", &local)
+	switch {
+	case err != nil:
+		health.Error = "rollout status: " + err.Error()
+	case status == http.StatusOK:
+		health.Rollout = &local
+	}
+
+	return health
+}
+
+// getJSON fetches url and decodes a JSON body into out, returning the status
+func (sa *SiteAggregator) getJSON(ctx context.Context, url string, out interface{}) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	resp, err := sa.httpClient.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to reach device: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.StatusCode, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return resp.StatusCode, fmt.Errorf("failed to decode response: %w", err)
+	}
+
+	return resp.StatusCode, nil
+}
+
+// Helper functions
+
+func writeJSON(w http.ResponseWriter, status int, body interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(body); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
@@ -0,0 +1,148 @@
+package systemmetrics
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+// TelemetryReporter is an interface for reporting telemetry data; it matches the
+// rollout package's TelemetryReporter so the same reporters can be registered with both
+type TelemetryReporter interface {
+	// ReportMetrics reports metrics for rollout monitoring
+	ReportMetrics(metrics []string) error
+}
+
+// Sample is a single snapshot of system metrics
+type Sample struct {
+	Timestamp time.Time          `json:"timestamp"`
+	Values    map[string]float64 `json:"values"`
+}
+
+// Collector periodically samples system metrics and publishes them to telemetry reporters
+type Collector struct {
+	deviceID        string
+	sampler         *sampler
+	reporters       []TelemetryReporter
+	reportersMutex  sync.RWMutex
+	lastSample      *Sample
+	sampleMutex     sync.RWMutex
+	collectInterval time.Duration
+	collectTimer    *time.Timer
+}
+
+// CollectorConfig contains configuration for the Collector
+type CollectorConfig struct {
+	DeviceID        string
+	DiskPaths       []string
+	NetInterfaces   []string
+	CollectInterval time.Duration
+}
+
+// NewCollector creates a new Collector
+func NewCollector(config CollectorConfig) (*Collector, error) {
+	diskPaths := config.DiskPaths
+	if len(diskPaths) == 0 {
+		diskPaths = []string{"/"}
+	}
+
+	c := &Collector{
+		deviceID:        config.DeviceID,
+		sampler:         newSampler(diskPaths, config.NetInterfaces),
+		reporters:       make([]TelemetryReporter, 0),
+		collectInterval: config.CollectInterval,
+	}
+
+	// Take an initial sample so counters have a baseline for rate calculations
+	if _, err := c.sampler.sample(); err != nil {
+		return nil, fmt.Errorf("failed to take initial sample: %w", err)
+	}
+
+	// Start the collection timer
+	c.collectTimer = time.AfterFunc(c.collectInterval, c.collect)
+
+	return c, nil
+}
+
+// RegisterTelemetryReporter registers a reporter for collected metrics
+func (c *Collector) RegisterTelemetryReporter(reporter TelemetryReporter) {
+	c.reportersMutex.Lock()
+	defer c.reportersMutex.Unlock()
+	c.reporters = append(c.reporters, reporter)
+}
+
+// collect samples system metrics and publishes them
+func (c *Collector) collect() {
+	defer func() {
+		// Reschedule the collection
+		c.collectTimer.Reset(c.collectInterval)
+	}()
+
+	sample, err := c.Collect()
+	if err != nil {
+		log.Printf("Failed to collect system metrics: %v", err)
+		return
+	}
+
+	metrics := FormatMetrics(sample)
+
+	c.reportersMutex.RLock()
+	defer c.reportersMutex.RUnlock()
+
+	for _, reporter := range c.reporters {
+		if err := reporter.ReportMetrics(metrics); err != nil {
+			log.Printf("Failed to report system metrics: %v", err)
+		}
+	}
+}
+
+// Collect takes a sample of system metrics immediately
+func (c *Collector) Collect() (*Sample, error) {
+	values, err := c.sampler.sample()
+	if err != nil {
+		return nil, err
+	}
+
+	sample := &Sample{
+		Timestamp: time.Now().UTC(),
+		Values:    values,
+	}
+
+	c.sampleMutex.Lock()
+	c.lastSample = sample
+	c.sampleMutex.Unlock()
+
+	return sample, nil
+}
+
+// LastSample returns the most recent sample
+func (c *Collector) LastSample() *Sample {
+	c.sampleMutex.RLock()
+	defer c.sampleMutex.RUnlock()
+	return c.lastSample
+}
+
+// Close stops the collector
+func (c *Collector) Close() {
+	if c.collectTimer != nil {
+		c.collectTimer.Stop()
+	}
+}
+
+// FormatMetrics renders a sample as "name=value" metric strings sorted by name
+func FormatMetrics(sample *Sample) []string {
+	names := make([]string, 0, len(sample.Values))
+	for name := range sample.Values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	metrics := make([]string, 0, len(names))
+	for _, name := range names {
+		metrics = append(metrics, fmt.Sprintf("%s=%g", name, sample.Values[name]))
+	}
+
+	return metrics
+}
@@ -0,0 +1,83 @@
+package systemmetrics
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// DynamoReporter writes "name=value" metrics to a telemetry table keyed by
+// DeviceID and Timestamp, where the fleet server's canary analyzer reads them
+type DynamoReporter struct {
+	dynamoClient       *dynamodb.Client
+	telemetryTableName string
+	deviceID           string
+	retention          time.Duration
+}
+
+// DynamoReporterConfig contains configuration for the DynamoReporter
+type DynamoReporterConfig struct {
+	DynamoClient       *dynamodb.Client
+	TelemetryTableName string
+	DeviceID           string
+	TenantID           string        // prefixes the DeviceID key so IAM LeadingKeys conditions apply
+	Retention          time.Duration // sets the ExpiresAt TTL attribute when non-zero
+}
+
+// NewDynamoReporter creates a new DynamoReporter
+func NewDynamoReporter(config DynamoReporterConfig) *DynamoReporter {
+	return &DynamoReporter{
+		dynamoClient:       config.DynamoClient,
+		telemetryTableName: config.TelemetryTableName,
+		deviceID:           tenant.Key(config.TenantID, config.DeviceID),
+		retention:          config.Retention,
+	}
+}
+
+// ReportMetrics writes one telemetry item containing every parseable metric
+func (dr *DynamoReporter) ReportMetrics(metrics []string) error {
+	values := make(map[string]types.AttributeValue, len(metrics))
+	for _, metric := range metrics {
+		name, value, ok := strings.Cut(metric, "=")
+		if !ok {
+			continue
+		}
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			continue
+		}
+		values[name] = &types.AttributeValueMemberN{Value: value}
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	now := time.Now().UTC()
+	item := map[string]types.AttributeValue{
+		"DeviceID":  &types.AttributeValueMemberS{Value: dr.deviceID},
+		"Timestamp": &types.AttributeValueMemberS{Value: now.Format(time.RFC3339Nano)},
+		"Metrics":   &types.AttributeValueMemberM{Value: values},
+	}
+
+	if dr.retention > 0 {
+		item["ExpiresAt"] = &types.AttributeValueMemberN{Value: strconv.FormatInt(now.Add(dr.retention).Unix(), 10)}
+	}
+
+	_, err := dr.dynamoClient.PutItem(context.Background(), &dynamodb.PutItemInput{
+		TableName: aws.String(dr.telemetryTableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write telemetry: %w", err)
+	}
+
+	return nil
+}
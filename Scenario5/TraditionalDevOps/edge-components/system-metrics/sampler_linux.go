@@ -0,0 +1,274 @@
+//go:build linux
+
+package systemmetrics
+
+import (
+	"bufio"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// cpuTimes holds aggregate CPU jiffies from /proc/stat
+type cpuTimes struct {
+	idle  uint64
+	total uint64
+}
+
+// netCounters holds interface byte counters from /proc/net/dev
+type netCounters struct {
+	rxBytes uint64
+	txBytes uint64
+}
+
+// sampler reads system metrics from procfs, sysfs and statfs
+type sampler struct {
+	diskPaths     []string
+	netInterfaces map[string]bool
+	prevCPU       *cpuTimes
+	prevNet       map[string]netCounters
+	prevTime      time.Time
+	sampleMutex   sync.Mutex
+}
+
+// newSampler creates a new sampler; an empty interface list samples every non-loopback interface
+func newSampler(diskPaths, netInterfaces []string) *sampler {
+	s := &sampler{
+		diskPaths: diskPaths,
+		prevNet:   make(map[string]netCounters),
+	}
+
+	if len(netInterfaces) > 0 {
+		s.netInterfaces = make(map[string]bool)
+		for _, iface := range netInterfaces {
+			s.netInterfaces[iface] = true
+		}
+	}
+
+	return s
+}
+
+// sample reads the current metric values
+func (s *sampler) sample() (map[string]float64, error) {
+	s.sampleMutex.Lock()
+	defer s.sampleMutex.Unlock()
+
+	now := time.Now()
+	values := make(map[string]float64)
+
+	cpu, err := readCPUTimes()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read CPU times: %w", err)
+	}
+	if s.prevCPU != nil && cpu.total > s.prevCPU.total {
+		totalDelta := float64(cpu.total - s.prevCPU.total)
+		idleDelta := float64(cpu.idle - s.prevCPU.idle)
+		values["cpu_usage_percent"] = 100 * (totalDelta - idleDelta) / totalDelta
+	}
+	s.prevCPU = cpu
+
+	if load, err := readLoadAverage(); err == nil {
+		values["load_average_1m"] = load
+	}
+
+	memTotal, memAvailable, err := readMemInfo()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read memory info: %w", err)
+	}
+	values["memory_available_bytes"] = float64(memAvailable)
+	if memTotal > 0 {
+		values["memory_used_percent"] = 100 * float64(memTotal-memAvailable) / float64(memTotal)
+	}
+
+	for _, path := range s.diskPaths {
+		var stat syscall.Statfs_t
+		if err := syscall.Statfs(path, &stat); err != nil {
+			continue
+		}
+
+		total := float64(stat.Blocks) * float64(stat.Bsize)
+		free := float64(stat.Bavail) * float64(stat.Bsize)
+		if total > 0 {
+			values["disk_used_percent."+metricSuffix(path)] = 100 * (total - free) / total
+			values["disk_free_bytes."+metricSuffix(path)] = free
+		}
+	}
+
+	if temp, ok := readMaxTemperature(); ok {
+		values["temperature_celsius"] = temp
+	}
+
+	counters, err := readNetCounters()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read network counters: %w", err)
+	}
+	elapsed := now.Sub(s.prevTime).Seconds()
+	for iface, c := range counters {
+		if s.netInterfaces != nil && !s.netInterfaces[iface] {
+			continue
+		}
+		if s.netInterfaces == nil && iface == "lo" {
+			continue
+		}
+
+		values["net_rx_bytes_total."+iface] = float64(c.rxBytes)
+		values["net_tx_bytes_total."+iface] = float64(c.txBytes)
+
+		if prev, ok := s.prevNet[iface]; ok && elapsed > 0 && c.rxBytes >= prev.rxBytes && c.txBytes >= prev.txBytes {
+			values["net_rx_bytes_per_sec."+iface] = float64(c.rxBytes-prev.rxBytes) / elapsed
+			values["net_tx_bytes_per_sec."+iface] = float64(c.txBytes-prev.txBytes) / elapsed
+		}
+	}
+	s.prevNet = counters
+	s.prevTime = now
+
+	return values, nil
+}
+
+// Helper functions
+
+func readCPUTimes() (*cpuTimes, error) {
+	file, err := os.Open("/proc/stat")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || fields[0] != "cpu" {
+			continue
+		}
+
+		times := &cpuTimes{}
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseUint(field, 10, 64)
+			if err != nil {
+				return nil, err
+			}
+			times.total += v
+			// idle and iowait
+			if i == 3 || i == 4 {
+				times.idle += v
+			}
+		}
+		return times, nil
+	}
+
+	return nil, fmt.Errorf("cpu line not found in /proc/stat")
+}
+
+func readLoadAverage() (float64, error) {
+	data, err := ioutil.ReadFile("/proc/loadavg")
+	if err != nil {
+		return 0, err
+	}
+
+	fields := strings.Fields(string(data))
+	if len(fields) == 0 {
+		return 0, fmt.Errorf("empty /proc/loadavg")
+	}
+
+	return strconv.ParseFloat(fields[0], 64)
+}
+
+func readMemInfo() (uint64, uint64, error) {
+	file, err := os.Open("/proc/meminfo")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer file.Close()
+
+	var total, available uint64
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+
+		v, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			continue
+		}
+
+		switch fields[0] {
+		case "MemTotal:":
+			total = v * 1024
+		case "MemAvailable:":
+			available = v * 1024
+		}
+	}
+
+	return total, available, scanner.Err()
+}
+
+func readMaxTemperature() (float64, bool) {
+	zones, err := filepath.Glob("/sys/class/thermal/thermal_zone*/temp")
+	if err != nil || len(zones) == 0 {
+		return 0, false
+	}
+
+	max, found := 0.0, false
+	for _, zone := range zones {
+		data, err := ioutil.ReadFile(zone)
+		if err != nil {
+			continue
+		}
+
+		milli, err := strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+		if err != nil {
+			continue
+		}
+
+		if temp := milli / 1000; !found || temp > max {
+			max, found = temp, true
+		}
+	}
+
+	return max, found
+}
+
+func readNetCounters() (map[string]netCounters, error) {
+	file, err := os.Open("/proc/net/dev")
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	counters := make(map[string]netCounters)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := scanner.Text()
+		colon := strings.Index(line, ":")
+		if colon < 0 {
+			continue
+		}
+
+		iface := strings.TrimSpace(line[:colon])
+		fields := strings.Fields(line[colon+1:])
+		if len(fields) < 9 {
+			continue
+		}
+
+		rx, _ := strconv.ParseUint(fields[0], 10, 64)
+		tx, _ := strconv.ParseUint(fields[8], 10, 64)
+		counters[iface] = netCounters{rxBytes: rx, txBytes: tx}
+	}
+
+	return counters, scanner.Err()
+}
+
+func metricSuffix(path string) string {
+	if path == "/" {
+		return "root"
+	}
+	return strings.Trim(strings.ReplaceAll(path, "/", "_"), "_")
+}
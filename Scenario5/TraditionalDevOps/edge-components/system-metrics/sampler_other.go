@@ -0,0 +1,21 @@
+//go:build !linux
+
+package systemmetrics
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// sampler is unavailable outside Linux
+type sampler struct{}
+
+// newSampler creates a sampler that always fails
+func newSampler(diskPaths, netInterfaces []string) *sampler {
+	return &sampler{}
+}
+
+// sample reports that system metrics aren't supported on this platform
+func (s *sampler) sample() (map[string]float64, error) {
+	return nil, fmt.Errorf("system metrics collection is not supported on %s", runtime.GOOS)
+}
@@ -0,0 +1,298 @@
+package systemmetrics
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/firehose"
+	firehosetypes "github.com/aws/aws-sdk-go-v2/service/firehose/types"
+	"github.com/aws/aws-sdk-go-v2/service/kinesis"
+	kinesistypes "github.com/aws/aws-sdk-go-v2/service/kinesis/types"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// maxStreamBatch is the most records Kinesis PutRecords and Firehose
+// PutRecordBatch accept in one request
+const maxStreamBatch = 500
+
+// StreamReporter batches "name=value" metrics into JSON records on a Kinesis
+// data stream or a Firehose delivery stream, for near-real-time analytics in
+// a downstream warehouse without per-device CloudWatch costs. Each record is
+// one newline-terminated JSON object, so Firehose delivers JSON lines.
+type StreamReporter struct {
+	sink          streamSink
+	deviceID      string
+	tenantID      string
+	batchSize     int
+	maxRecords    int
+	flushInterval time.Duration
+	flushTimer    *time.Timer
+	pending       [][]byte
+	dropped       int
+	bufferMutex   sync.Mutex
+	flushMutex    sync.Mutex
+}
+
+// StreamReporterConfig contains configuration for the StreamReporter; set
+// either StreamName or DeliveryStreamName
+type StreamReporterConfig struct {
+	KinesisClient      *kinesis.Client
+	StreamName         string // Kinesis data stream; records are partitioned by device
+	FirehoseClient     *firehose.Client
+	DeliveryStreamName string // Firehose delivery stream
+	DeviceID           string
+	TenantID           string
+	BatchSize          int           // records per request; defaults to 100, at most 500
+	MaxRecords         int           // records kept while the stream is unreachable; defaults to 10000, the oldest are dropped beyond it
+	FlushInterval      time.Duration // defaults to 10 seconds
+}
+
+// streamRecord is the JSON shape of one report on the stream
+type streamRecord struct {
+	DeviceID  string             `json:"deviceId"`
+	TenantID  string             `json:"tenantId,omitempty"`
+	Timestamp time.Time          `json:"timestamp"`
+	Metrics   map[string]float64 `json:"metrics"`
+}
+
+// streamSink sends a batch of records and returns the ones that were rejected
+type streamSink interface {
+	put(ctx context.Context, records [][]byte) ([][]byte, error)
+}
+
+// NewStreamReporter creates a new StreamReporter and starts flushing
+func NewStreamReporter(config StreamReporterConfig) (*StreamReporter, error) {
+	if config.BatchSize == 0 {
+		config.BatchSize = 100
+	}
+	if config.MaxRecords == 0 {
+		config.MaxRecords = 10000
+	}
+	if config.FlushInterval == 0 {
+		config.FlushInterval = 10 * time.Second
+	}
+
+	if config.BatchSize < 0 || config.BatchSize > maxStreamBatch {
+		return nil, fmt.Errorf("batch size %d must be between 1 and %d", config.BatchSize, maxStreamBatch)
+	}
+	if config.MaxRecords < config.BatchSize {
+		return nil, fmt.Errorf("max records %d must be at least the batch size %d", config.MaxRecords, config.BatchSize)
+	}
+
+	deviceID := tenant.Key(config.TenantID, config.DeviceID)
+
+	var sink streamSink
+	switch {
+	case config.StreamName != "" && config.DeliveryStreamName != "":
+		return nil, errors.New("set either a Kinesis stream name or a Firehose delivery stream name, not both")
+	case config.StreamName != "":
+		sink = &kinesisSink{client: config.KinesisClient, streamName: config.StreamName, partitionKey: deviceID}
+	case config.DeliveryStreamName != "":
+		sink = &firehoseSink{client: config.FirehoseClient, deliveryStreamName: config.DeliveryStreamName}
+	default:
+		return nil, errors.New("a Kinesis stream name or a Firehose delivery stream name is required")
+	}
+
+	sr := &StreamReporter{
+		sink:          sink,
+		deviceID:      deviceID,
+		tenantID:      config.TenantID,
+		batchSize:     config.BatchSize,
+		maxRecords:    config.MaxRecords,
+		flushInterval: config.FlushInterval,
+		pending:       make([][]byte, 0, config.BatchSize),
+	}
+
+	// Start the flush timer
+	sr.flushTimer = time.AfterFunc(sr.flushInterval, sr.flushLoop)
+
+	return sr, nil
+}
+
+// ReportMetrics queues one record containing every parseable metric; a full
+// batch is sent right away, the rest with the next flush
+func (sr *StreamReporter) ReportMetrics(metrics []string) error {
+	values := make(map[string]float64, len(metrics))
+	for _, metric := range metrics {
+		name, value, ok := strings.Cut(metric, "=")
+		if !ok {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			continue
+		}
+		values[name] = parsed
+	}
+
+	if len(values) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(streamRecord{
+		DeviceID:  sr.deviceID,
+		TenantID:  sr.tenantID,
+		Timestamp: time.Now().UTC(),
+		Metrics:   values,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal telemetry record: %w", err)
+	}
+
+	sr.bufferMutex.Lock()
+	sr.pending = append(sr.pending, append(data, '\n'))
+	sr.trim()
+	full := len(sr.pending) >= sr.batchSize
+	sr.bufferMutex.Unlock()
+
+	if full {
+		sr.flushTimer.Reset(0)
+	}
+
+	return nil
+}
+
+// flushLoop sends the queued records and reschedules itself
+func (sr *StreamReporter) flushLoop() {
+	defer func() {
+		// Reschedule the flush
+		sr.flushTimer.Reset(sr.flushInterval)
+	}()
+
+	if err := sr.Flush(context.Background()); err != nil {
+		log.Printf("Failed to flush telemetry stream: %v", err)
+	}
+}
+
+// Flush sends the queued records in batches. Records that fail are kept, in
+// order, for the next flush.
+func (sr *StreamReporter) Flush(ctx context.Context) error {
+	sr.flushMutex.Lock()
+	defer sr.flushMutex.Unlock()
+
+	for {
+		sr.bufferMutex.Lock()
+		n := len(sr.pending)
+		if n > sr.batchSize {
+			n = sr.batchSize
+		}
+		batch := append([][]byte(nil), sr.pending[:n]...)
+		sr.bufferMutex.Unlock()
+
+		if len(batch) == 0 {
+			return nil
+		}
+
+		failed, err := sr.sink.put(ctx, batch)
+		if err != nil {
+			return err
+		}
+
+		// Only Flush removes records, so the batch is still at the front
+		sr.bufferMutex.Lock()
+		sr.pending = append(failed, sr.pending[n:]...)
+		sr.trim()
+		sr.bufferMutex.Unlock()
+
+		if len(failed) > 0 {
+			return fmt.Errorf("%d of %d telemetry records were rejected", len(failed), len(batch))
+		}
+	}
+}
+
+// Close stops the flush timer and sends the queued records
+func (sr *StreamReporter) Close() error {
+	if sr.flushTimer != nil {
+		sr.flushTimer.Stop()
+	}
+	return sr.Flush(context.Background())
+}
+
+// trim drops the oldest records beyond the limit; the caller holds bufferMutex
+func (sr *StreamReporter) trim() {
+	excess := len(sr.pending) - sr.maxRecords
+	if excess <= 0 {
+		return
+	}
+
+	sr.pending = append(sr.pending[:0], sr.pending[excess:]...)
+	sr.dropped += excess
+	log.Printf("Telemetry stream buffer full; dropped %d records (%d in total)", excess, sr.dropped)
+}
+
+// kinesisSink puts records on a Kinesis data stream
+type kinesisSink struct {
+	client       *kinesis.Client
+	streamName   string
+	partitionKey string
+}
+
+func (k *kinesisSink) put(ctx context.Context, records [][]byte) ([][]byte, error) {
+	entries := make([]kinesistypes.PutRecordsRequestEntry, len(records))
+	for i, record := range records {
+		entries[i] = kinesistypes.PutRecordsRequestEntry{
+			Data:         record,
+			PartitionKey: aws.String(k.partitionKey),
+		}
+	}
+
+	result, err := k.client.PutRecords(ctx, &kinesis.PutRecordsInput{
+		StreamName: aws.String(k.streamName),
+		Records:    entries,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put records to Kinesis: %w", err)
+	}
+
+	var failed [][]byte
+	if aws.ToInt32(result.FailedRecordCount) > 0 {
+		for i, entry := range result.Records {
+			if entry.ErrorCode != nil && i < len(records) {
+				failed = append(failed, records[i])
+			}
+		}
+	}
+
+	return failed, nil
+}
+
+// firehoseSink puts records on a Firehose delivery stream
+type firehoseSink struct {
+	client             *firehose.Client
+	deliveryStreamName string
+}
+
+func (f *firehoseSink) put(ctx context.Context, records [][]byte) ([][]byte, error) {
+	batch := make([]firehosetypes.Record, len(records))
+	for i, record := range records {
+		batch[i] = firehosetypes.Record{Data: record}
+	}
+
+	result, err := f.client.PutRecordBatch(ctx, &firehose.PutRecordBatchInput{
+		DeliveryStreamName: aws.String(f.deliveryStreamName),
+		Records:            batch,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to put records to Firehose: %w", err)
+	}
+
+	var failed [][]byte
+	if aws.ToInt32(result.FailedPutCount) > 0 {
+		for i, response := range result.RequestResponses {
+			if response.ErrorCode != nil && i < len(records) {
+				failed = append(failed, records[i])
+			}
+		}
+	}
+
+	return failed, nil
+}
@@ -0,0 +1,265 @@
+package telemetrybuffer
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// How an AggregatingReporter rolls up a metric's values over a window
+const (
+	AggregateSummary = "summary" // the mean as the metric, plus .min, .max and percentiles; the default
+	AggregateMean    = "mean"
+	AggregateSum     = "sum" // for counters reported as increments
+	AggregateMax     = "max"
+	AggregateLast    = "last" // for gauges and tags, e.g. experiment variants
+)
+
+// SamplesMetric is added to every rollup: the number of reports it covers
+const SamplesMetric = "telemetry_samples"
+
+// AggregationRule sets the aggregation of the metrics whose names start with Prefix
+type AggregationRule struct {
+	Prefix string
+	Mode   string
+}
+
+// AggregatingReporter wraps a TelemetryReporter and sends one rollup per
+// interval instead of every report, so high-frequency metrics don't turn into
+// thousands of uploads per device per hour. By default a metric's rollup is
+// its mean under its own name, which the canary analyzer compares as before,
+// plus name.min, name.max and name.p50/p95/p99. Non-numeric metrics keep their
+// last value.
+type AggregatingReporter struct {
+	reporter      TelemetryReporter
+	interval      time.Duration
+	rules         []AggregationRule
+	defaultMode   string
+	percentiles   []float64
+	values        map[string][]float64
+	text          map[string]string
+	order         []string // metric names in first-seen order
+	samples       int
+	flushTimer    *time.Timer
+	windowMutex   sync.Mutex
+	reporterMutex sync.Mutex
+}
+
+// AggregatingReporterConfig contains configuration for the AggregatingReporter
+type AggregatingReporterConfig struct {
+	Reporter    TelemetryReporter // e.g. a BufferedReporter, so rollups survive being offline
+	Interval    time.Duration     // rollup window; defaults to 1 minute
+	Rules       []AggregationRule // the first rule whose prefix matches wins
+	DefaultMode string            // for metrics no rule matches; defaults to summary
+	Percentiles []float64         // reported by summary; defaults to 50, 95 and 99
+}
+
+// NewAggregatingReporter creates a new AggregatingReporter
+func NewAggregatingReporter(config AggregatingReporterConfig) (*AggregatingReporter, error) {
+	if config.Interval == 0 {
+		config.Interval = time.Minute
+	}
+	if config.DefaultMode == "" {
+		config.DefaultMode = AggregateSummary
+	}
+	if config.Percentiles == nil {
+		config.Percentiles = []float64{50, 95, 99}
+	}
+
+	if err := validateMode(config.DefaultMode); err != nil {
+		return nil, err
+	}
+	for _, rule := range config.Rules {
+		if err := validateMode(rule.Mode); err != nil {
+			return nil, fmt.Errorf("rule for %s: %w", rule.Prefix, err)
+		}
+	}
+	for _, p := range config.Percentiles {
+		if p <= 0 || p > 100 {
+			return nil, fmt.Errorf("percentile %g must be in (0, 100]", p)
+		}
+	}
+
+	ar := &AggregatingReporter{
+		reporter:    config.Reporter,
+		interval:    config.Interval,
+		rules:       config.Rules,
+		defaultMode: config.DefaultMode,
+		percentiles: config.Percentiles,
+	}
+	ar.reset()
+
+	// Start the rollup timer
+	ar.flushTimer = time.AfterFunc(ar.interval, ar.flushLoop)
+
+	return ar, nil
+}
+
+// ReportMetrics adds a report's metrics to the current window
+func (ar *AggregatingReporter) ReportMetrics(metrics []string) error {
+	ar.windowMutex.Lock()
+	defer ar.windowMutex.Unlock()
+
+	for _, metric := range metrics {
+		name, value, ok := strings.Cut(metric, "=")
+		if !ok {
+			continue
+		}
+
+		if _, seen := ar.values[name]; !seen {
+			if _, seen := ar.text[name]; !seen {
+				ar.order = append(ar.order, name)
+			}
+		}
+
+		number, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			ar.text[name] = value
+			continue
+		}
+		ar.values[name] = append(ar.values[name], number)
+	}
+	ar.samples++
+
+	return nil
+}
+
+// Flush sends the current window's rollup now and starts a new window
+func (ar *AggregatingReporter) Flush() error {
+	ar.windowMutex.Lock()
+	rollup := ar.rollup()
+	ar.reset()
+	ar.windowMutex.Unlock()
+
+	if len(rollup) == 0 {
+		return nil
+	}
+
+	ar.reporterMutex.Lock()
+	defer ar.reporterMutex.Unlock()
+	return ar.reporter.ReportMetrics(rollup)
+}
+
+// Close stops the timer and sends the last, partial window
+func (ar *AggregatingReporter) Close() error {
+	if ar.flushTimer != nil {
+		ar.flushTimer.Stop()
+	}
+	return ar.Flush()
+}
+
+// flushLoop sends a rollup every interval
+func (ar *AggregatingReporter) flushLoop() {
+	defer ar.flushTimer.Reset(ar.interval)
+
+	if err := ar.Flush(); err != nil {
+		log.Printf("Failed to report telemetry rollup: %v", err)
+	}
+}
+
+// rollup renders the window as metrics; callers hold windowMutex
+func (ar *AggregatingReporter) rollup() []string {
+	if ar.samples == 0 {
+		return nil
+	}
+
+	metrics := make([]string, 0, len(ar.order)+1)
+	for _, name := range ar.order {
+		values, numeric := ar.values[name]
+		if !numeric {
+			metrics = append(metrics, name+"="+ar.text[name])
+			continue
+		}
+
+		switch ar.mode(name) {
+		case AggregateMean:
+			metrics = append(metrics, formatMetric(name, mean(values)))
+		case AggregateSum:
+			metrics = append(metrics, formatMetric(name, sum(values)))
+		case AggregateMax:
+			sorted := sortedCopy(values)
+			metrics = append(metrics, formatMetric(name, sorted[len(sorted)-1]))
+		case AggregateLast:
+			metrics = append(metrics, formatMetric(name, values[len(values)-1]))
+		default:
+			sorted := sortedCopy(values)
+			metrics = append(metrics,
+				formatMetric(name, mean(values)),
+				formatMetric(name+".min", sorted[0]),
+				formatMetric(name+".max", sorted[len(sorted)-1]),
+			)
+			for _, p := range ar.percentiles {
+				metrics = append(metrics, formatMetric(name+".p"+strconv.FormatFloat(p, 'g', -1, 64), percentile(sorted, p)))
+			}
+		}
+	}
+
+	return append(metrics, SamplesMetric+"="+strconv.Itoa(ar.samples))
+}
+
+// mode returns the aggregation of a metric
+func (ar *AggregatingReporter) mode(name string) string {
+	for _, rule := range ar.rules {
+		if strings.HasPrefix(name, rule.Prefix) {
+			return rule.Mode
+		}
+	}
+	return ar.defaultMode
+}
+
+// reset starts a new window; callers hold windowMutex
+func (ar *AggregatingReporter) reset() {
+	ar.values = make(map[string][]float64)
+	ar.text = make(map[string]string)
+	ar.order = nil
+	ar.samples = 0
+}
+
+// Helper functions
+
+// validateMode checks an aggregation mode
+func validateMode(mode string) error {
+	switch mode {
+	case AggregateSummary, AggregateMean, AggregateSum, AggregateMax, AggregateLast:
+		return nil
+	default:
+		return fmt.Errorf("unknown aggregation mode: %s", mode)
+	}
+}
+
+// formatMetric renders a "name=value" metric
+func formatMetric(name string, value float64) string {
+	return fmt.Sprintf("%s=%g", name, value)
+}
+
+// percentile returns the nearest-rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
+
+func sortedCopy(values []float64) []float64 {
+	sorted := append([]float64(nil), values...)
+	sort.Float64s(sorted)
+	return sorted
+}
+
+func sum(values []float64) float64 {
+	total := 0.0
+	for _, value := range values {
+		total += value
+	}
+	return total
+}
+
+func mean(values []float64) float64 {
+	return sum(values) / float64(len(values))
+}
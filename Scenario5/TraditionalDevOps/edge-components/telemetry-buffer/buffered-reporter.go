@@ -0,0 +1,354 @@
+package telemetrybuffer
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+)
+
+// Local store keys
+const (
+	// queuePrefix is followed by a big-endian sequence number, so reports
+	// iterate in the order they were made
+	queuePrefix = "telemetry/queue/"
+
+	// statsKey holds the drop counters
+	statsKey = "telemetry/stats"
+)
+
+// Metrics added to reports flushed from the buffer
+const (
+	// QueuedAtMetric is the Unix time a buffered report was made
+	QueuedAtMetric = "telemetry_queued_at"
+
+	// DroppedMetric counts reports dropped since the last one delivered
+	DroppedMetric = "telemetry_dropped_reports"
+)
+
+// TelemetryReporter is an interface for reporting telemetry data; it matches
+// the rollout package's TelemetryReporter
+type TelemetryReporter interface {
+	// ReportMetrics reports metrics for rollout monitoring
+	ReportMetrics(metrics []string) error
+}
+
+// Stats counts what the buffer holds and what it had to drop
+type Stats struct {
+	Queued          int    `json:"queued"`
+	Flushed         uint64 `json:"flushed"`
+	DroppedOverflow uint64 `json:"droppedOverflow"` // oldest reports dropped to stay within MaxReports
+	DroppedExpired  uint64 `json:"droppedExpired"`  // reports older than MaxAge
+	Unreported      uint64 `json:"unreported"`      // drops not yet announced with DroppedMetric
+}
+
+// queuedReport is a report waiting in the buffer
+type queuedReport struct {
+	Metrics  []string  `json:"metrics"`
+	QueuedAt time.Time `json:"queuedAt"`
+}
+
+// BufferedReporter wraps a TelemetryReporter so reports made while the device
+// is offline aren't lost: failed reports are queued in the local store and
+// flushed, oldest first, when the device reconnects. The queue is bounded by
+// count and age; dropped reports are counted and announced upstream.
+type BufferedReporter struct {
+	reporter      TelemetryReporter
+	store         kvstore.KVStore
+	maxReports    int
+	maxAge        time.Duration
+	retryInterval time.Duration
+	retryTimer    *time.Timer
+	nextSequence  uint64
+	stats         Stats
+	isOnline      bool
+	bufferMutex   sync.Mutex
+	flushMutex    sync.Mutex
+}
+
+// BufferedReporterConfig contains configuration for the BufferedReporter
+type BufferedReporterConfig struct {
+	Reporter       TelemetryReporter // e.g. the GRPCAgent or a DynamoReporter
+	StorageBackend string            // badger (default), bolt or memory
+	StoragePath    string
+	MaxReports     int           // defaults to 10000; the oldest are dropped beyond it
+	MaxAge         time.Duration // defaults to 24 hours; older reports are dropped unsent
+	RetryInterval  time.Duration // how often to retry the reporter while reports are queued; defaults to 1 minute
+}
+
+// NewBufferedReporter creates a new BufferedReporter, restoring reports
+// queued before a restart
+func NewBufferedReporter(config BufferedReporterConfig) (*BufferedReporter, error) {
+	if config.MaxReports == 0 {
+		config.MaxReports = 10000
+	}
+	if config.MaxAge == 0 {
+		config.MaxAge = 24 * time.Hour
+	}
+	if config.RetryInterval == 0 {
+		config.RetryInterval = time.Minute
+	}
+
+	store, err := kvstore.Open(config.StorageBackend, config.StoragePath)
+	if err != nil {
+		return nil, err
+	}
+
+	br := &BufferedReporter{
+		reporter:      config.Reporter,
+		store:         store,
+		maxReports:    config.MaxReports,
+		maxAge:        config.MaxAge,
+		retryInterval: config.RetryInterval,
+		isOnline:      true,
+	}
+
+	if data, err := store.Get([]byte(statsKey)); err == nil {
+		if err := json.Unmarshal(data, &br.stats); err != nil {
+			log.Printf("Ignoring unreadable telemetry buffer stats: %v", err)
+		}
+	}
+
+	err = store.Iterate([]byte(queuePrefix), func(key, value []byte) error {
+		br.stats.Queued++
+		br.nextSequence = sequence(key) + 1
+		return nil
+	})
+	if err != nil {
+		store.Close()
+		return nil, fmt.Errorf("failed to read telemetry buffer: %w", err)
+	}
+
+	// Start retrying
+	br.retryTimer = time.AfterFunc(br.retryInterval, br.retryLoop)
+
+	return br, nil
+}
+
+// ReportMetrics reports metrics, or queues them if the device is offline,
+// the reporter fails or older reports are still queued. It only fails if
+// the report can't be queued.
+func (br *BufferedReporter) ReportMetrics(metrics []string) error {
+	br.bufferMutex.Lock()
+	direct := br.isOnline && br.stats.Queued == 0
+	br.bufferMutex.Unlock()
+
+	if direct {
+		err := br.reporter.ReportMetrics(metrics)
+		if err == nil {
+			return nil
+		}
+		log.Printf("Failed to report telemetry, buffering: %v", err)
+	}
+
+	return br.enqueue(queuedReport{Metrics: metrics, QueuedAt: time.Now().UTC()})
+}
+
+// SetOnlineStatus records whether the device can reach the reporter,
+// flushing the buffer when it reconnects
+func (br *BufferedReporter) SetOnlineStatus(online bool) {
+	br.bufferMutex.Lock()
+	wasOnline := br.isOnline
+	br.isOnline = online
+	br.bufferMutex.Unlock()
+
+	if !wasOnline && online {
+		go func() {
+			if err := br.Flush(context.Background()); err != nil {
+				log.Printf("Failed to flush telemetry buffer: %v", err)
+			}
+		}()
+	}
+}
+
+// Flush sends queued reports oldest first, stopping at the first failure so
+// reports stay in order; reports older than MaxAge are dropped
+func (br *BufferedReporter) Flush(ctx context.Context) error {
+	br.flushMutex.Lock()
+	defer br.flushMutex.Unlock()
+
+	keys := make([][]byte, 0)
+	reports := make([]queuedReport, 0)
+	err := br.store.Iterate([]byte(queuePrefix), func(key, value []byte) error {
+		var report queuedReport
+		if err := json.Unmarshal(value, &report); err != nil {
+			log.Printf("Dropping unreadable telemetry report %x: %v", key, err)
+		}
+		keys = append(keys, append([]byte(nil), key...))
+		reports = append(reports, report)
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read telemetry buffer: %w", err)
+	}
+
+	cutoff := time.Now().Add(-br.maxAge)
+	for i, report := range reports {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		if report.QueuedAt.Before(cutoff) || len(report.Metrics) == 0 {
+			br.remove(keys[i], func(stats *Stats) {
+				stats.DroppedExpired++
+				stats.Unreported++
+			})
+			continue
+		}
+
+		br.bufferMutex.Lock()
+		unreported := br.stats.Unreported
+		br.bufferMutex.Unlock()
+
+		metrics := append([]string(nil), report.Metrics...)
+		metrics = append(metrics, QueuedAtMetric+"="+strconv.FormatInt(report.QueuedAt.Unix(), 10))
+		if unreported > 0 {
+			metrics = append(metrics, DroppedMetric+"="+strconv.FormatUint(unreported, 10))
+		}
+
+		if err := br.reporter.ReportMetrics(metrics); err != nil {
+			return fmt.Errorf("failed to flush telemetry, %d reports still queued: %w", len(reports)-i, err)
+		}
+
+		br.remove(keys[i], func(stats *Stats) {
+			stats.Flushed++
+			stats.Unreported -= unreported
+		})
+	}
+
+	return nil
+}
+
+// Stats returns the buffer's counters
+func (br *BufferedReporter) Stats() Stats {
+	br.bufferMutex.Lock()
+	defer br.bufferMutex.Unlock()
+	return br.stats
+}
+
+// Close stops retrying and closes the local store; queued reports are kept
+// for the next start
+func (br *BufferedReporter) Close() error {
+	if br.retryTimer != nil {
+		br.retryTimer.Stop()
+	}
+	return br.store.Close()
+}
+
+// enqueue stores a report, dropping the oldest if the buffer is full
+func (br *BufferedReporter) enqueue(report queuedReport) error {
+	value, err := json.Marshal(report)
+	if err != nil {
+		return fmt.Errorf("failed to marshal telemetry report: %w", err)
+	}
+
+	br.bufferMutex.Lock()
+	defer br.bufferMutex.Unlock()
+
+	if err := br.store.Set(queueKey(br.nextSequence), value); err != nil {
+		return fmt.Errorf("failed to buffer telemetry report: %w", err)
+	}
+	br.nextSequence++
+	br.stats.Queued++
+
+	for br.stats.Queued > br.maxReports {
+		oldest, err := br.oldestKey()
+		if err != nil || oldest == nil {
+			break
+		}
+		if err := br.store.Delete(oldest); err != nil {
+			log.Printf("Failed to drop buffered telemetry report: %v", err)
+			break
+		}
+		br.stats.Queued--
+		br.stats.DroppedOverflow++
+		br.stats.Unreported++
+	}
+
+	br.saveStats()
+	return nil
+}
+
+// remove deletes a queued report and updates the counters
+func (br *BufferedReporter) remove(key []byte, update func(stats *Stats)) {
+	br.bufferMutex.Lock()
+	defer br.bufferMutex.Unlock()
+
+	if err := br.store.Delete(key); err != nil {
+		log.Printf("Failed to remove telemetry report from the buffer: %v", err)
+		return
+	}
+	// The report may already have been dropped by enqueue
+	if br.stats.Queued > 0 {
+		br.stats.Queued--
+	}
+	update(&br.stats)
+	br.saveStats()
+}
+
+// oldestKey returns the key of the oldest queued report, or nil
+func (br *BufferedReporter) oldestKey() ([]byte, error) {
+	var oldest []byte
+	err := br.store.Iterate([]byte(queuePrefix), func(key, value []byte) error {
+		oldest = append([]byte(nil), key...)
+		return errStopIteration
+	})
+	if err != nil && err != errStopIteration {
+		return nil, err
+	}
+	return oldest, nil
+}
+
+// saveStats persists the drop counters; callers hold bufferMutex
+func (br *BufferedReporter) saveStats() {
+	data, err := json.Marshal(br.stats)
+	if err == nil {
+		err = br.store.Set([]byte(statsKey), data)
+	}
+	if err != nil {
+		log.Printf("Failed to save telemetry buffer stats: %v", err)
+	}
+}
+
+// retryLoop flushes queued reports while online
+func (br *BufferedReporter) retryLoop() {
+	defer br.retryTimer.Reset(br.retryInterval)
+
+	br.bufferMutex.Lock()
+	pending := br.isOnline && br.stats.Queued > 0
+	br.bufferMutex.Unlock()
+
+	if pending {
+		if err := br.Flush(context.Background()); err != nil {
+			log.Printf("Failed to flush telemetry buffer: %v", err)
+		}
+	}
+}
+
+// Helper functions
+
+// errStopIteration ends an Iterate call early
+var errStopIteration = errors.New("stop iteration")
+
+// queueKey returns the store key for a sequence number
+func queueKey(sequence uint64) []byte {
+	key := make([]byte, len(queuePrefix)+8)
+	copy(key, queuePrefix)
+	binary.BigEndian.PutUint64(key[len(queuePrefix):], sequence)
+	return key
+}
+
+// sequence returns the sequence number of a queue key
+func sequence(key []byte) uint64 {
+	if len(key) != len(queuePrefix)+8 {
+		return 0
+	}
+	return binary.BigEndian.Uint64(key[len(queuePrefix):])
+}
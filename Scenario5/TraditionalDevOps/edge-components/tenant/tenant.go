@@ -0,0 +1,110 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+)
+
+// Separator joins a tenant ID and an item ID in partition keys, so IAM
+// policies can restrict access with a dynamodb:LeadingKeys condition on
+// "<tenant>#*"
+const Separator = "#"
+
+// Header carries the tenant ID on fleet API requests; it must be set by a
+// trusted authenticating proxy, never taken directly from end users
+const Header = "X-Tenant-ID"
+
+// ErrInvalid is returned for tenant IDs that cannot be used in keys or prefixes
+var ErrInvalid = errors.New("invalid tenant ID")
+
+// idPattern keeps tenant IDs safe in partition keys, S3 prefixes and IAM policy variables
+var idPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+type contextKey struct{}
+
+// Validate checks that a tenant ID is well formed
+func Validate(tenantID string) error {
+	if !idPattern.MatchString(tenantID) {
+		return fmt.Errorf("%w: %q", ErrInvalid, tenantID)
+	}
+	return nil
+}
+
+// Key scopes an ID to a tenant; the empty tenant leaves the ID unchanged so
+// single-tenant deployments keep their existing keys
+func Key(tenantID, id string) string {
+	if tenantID == "" {
+		return id
+	}
+	return tenantID + Separator + id
+}
+
+// Split separates a tenant-scoped key into tenant and ID
+func Split(key string) (string, string) {
+	tenantID, id, ok := strings.Cut(key, Separator)
+	if !ok {
+		return "", key
+	}
+	return tenantID, id
+}
+
+// S3Prefix returns the object key prefix for a tenant, e.g. "tenants/acme/"
+func S3Prefix(tenantID string) string {
+	if tenantID == "" {
+		return ""
+	}
+	return "tenants/" + tenantID + "/"
+}
+
+// WithTenant returns a context carrying a tenant ID
+func WithTenant(ctx context.Context, tenantID string) context.Context {
+	return context.WithValue(ctx, contextKey{}, tenantID)
+}
+
+// FromContext returns the tenant ID carried by a context, or "" for none
+func FromContext(ctx context.Context) string {
+	tenantID, _ := ctx.Value(contextKey{}).(string)
+	return tenantID
+}
+
+// Allowed reports whether a request scoped to tenantID may see an item
+// owned by itemTenant; unscoped (operator) requests see everything
+func Allowed(tenantID, itemTenant string) bool {
+	return tenantID == "" || tenantID == itemTenant
+}
+
+// Middleware reads the tenant header into the request context, rejecting
+// malformed IDs and, when required, requests without one
+func Middleware(next http.Handler, required bool) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		tenantID := r.Header.Get(Header)
+
+		if tenantID == "" {
+			if required {
+				writeError(w, http.StatusUnauthorized, "tenant is required")
+				return
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if err := Validate(tenantID); err != nil {
+			writeError(w, http.StatusBadRequest, "invalid tenant")
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithTenant(r.Context(), tenantID)))
+	})
+}
+
+// Helper functions
+
+func writeError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "{\"error\":%q}\n", message)
+}
@@ -0,0 +1,388 @@
+package testkit
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/feature/dynamodb/attributevalue"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+
+	fleetserver "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/fleet-server"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// Table names used by NewFleetDynamoDB and CreateFleetTables
+const (
+	RolloutTable      = "edge-rollouts-test"
+	DeviceTable       = "edge-devices-test"
+	ApprovalTable     = "edge-approvals-test"
+	AuditTable        = "edge-audit-test"
+	ArtifactTable     = "edge-artifacts-test"
+	GroupTable        = "edge-groups-test"
+	TelemetryTable    = "edge-telemetry-test"
+	UsageTable        = "edge-usage-test"
+	NotificationTable = "edge-notifications-test"
+)
+
+// fleetTables describes every table and index the fleet components use
+var fleetTables = []struct {
+	name    string
+	key     KeySchema
+	indexes map[string]KeySchema
+}{
+	{RolloutTable, KeySchema{HashKey: "ID"}, map[string]KeySchema{
+		"StatusIndex":       {HashKey: "Status"},
+		"TenantStatusIndex": {HashKey: "TenantID", RangeKey: "Status"},
+	}},
+	{DeviceTable, KeySchema{HashKey: "DeviceID"}, map[string]KeySchema{
+		fleetserver.VersionIndex: {HashKey: "CurrentVersion"},
+		fleetserver.UpdateIndex:  {HashKey: "LastUpdateID", RangeKey: "UpdateStatus"},
+		fleetserver.GroupIndex:   {HashKey: "DeviceGroup"},
+	}},
+	{ApprovalTable, KeySchema{HashKey: "ID"}, nil},
+	{AuditTable, KeySchema{HashKey: "ID"}, nil},
+	{ArtifactTable, KeySchema{HashKey: "Name", RangeKey: "Version"}, nil},
+	{GroupTable, KeySchema{HashKey: "Name"}, nil},
+	{TelemetryTable, KeySchema{HashKey: "DeviceID", RangeKey: "Timestamp"}, nil},
+	{UsageTable, KeySchema{HashKey: "RolloutID", RangeKey: "DeviceID"}, nil},
+	{NotificationTable, KeySchema{HashKey: "ID"}, nil},
+}
+
+// ttlTables have TTL enabled on fleetserver.ExpiresAtAttribute
+var ttlTables = map[string]bool{
+	RolloutTable:      true,
+	DeviceTable:       true,
+	TelemetryTable:    true,
+	UsageTable:        true,
+	NotificationTable: true,
+}
+
+// The fakes can stand in for the managers' AWS clients
+var (
+	_ rollout.DynamoDBAPI = (*FakeDynamoDB)(nil)
+	_ rollout.S3API       = (*FakeS3)(nil)
+	_ offlineSync.S3API   = (*FakeS3)(nil)
+)
+
+// ItemWriter is satisfied by both *dynamodb.Client and *FakeDynamoDB
+type ItemWriter interface {
+	PutItem(ctx context.Context, input *dynamodb.PutItemInput, optFns ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error)
+}
+
+// NewFleetDynamoDB creates a FakeDynamoDB with every fleet table and index
+func NewFleetDynamoDB() *FakeDynamoDB {
+	fake := NewFakeDynamoDB()
+	for _, table := range fleetTables {
+		fake.CreateTable(table.name, table.key)
+		for name, key := range table.indexes {
+			fake.CreateIndex(table.name, name, key)
+		}
+	}
+	return fake
+}
+
+// RolloutPlanBuilder builds rollout plan fixtures
+type RolloutPlanBuilder struct {
+	plan rollout.RolloutPlan
+}
+
+// NewRolloutPlan starts a rollout plan fixture that is in progress and targets all devices
+func NewRolloutPlan(id, version string) *RolloutPlanBuilder {
+	now := time.Now().UTC().Truncate(time.Second)
+	return &RolloutPlanBuilder{plan: rollout.RolloutPlan{
+		ID:           id,
+		Name:         id,
+		Version:      version,
+		Status:       "in-progress",
+		CreatedAt:    now,
+		UpdatedAt:    now,
+		TargetGroups: []string{"all"},
+		PackageURL:   fmt.Sprintf("s3://edge-artifacts-test/%s/%s/package.tar.gz", id, version),
+		CreatedBy:    "testkit",
+	}}
+}
+
+// Status sets the rollout status
+func (b *RolloutPlanBuilder) Status(status string) *RolloutPlanBuilder {
+	b.plan.Status = status
+	return b
+}
+
+// TargetGroups replaces the target groups
+func (b *RolloutPlanBuilder) TargetGroups(groups ...string) *RolloutPlanBuilder {
+	b.plan.TargetGroups = groups
+	return b
+}
+
+// Tenant scopes the rollout to a tenant
+func (b *RolloutPlanBuilder) Tenant(tenantID string) *RolloutPlanBuilder {
+	b.plan.TenantID = tenantID
+	return b
+}
+
+// Package sets the package URL and hash
+func (b *RolloutPlanBuilder) Package(url, hash string) *RolloutPlanBuilder {
+	b.plan.PackageURL = url
+	b.plan.PackageHash = hash
+	return b
+}
+
+// Phase appends a phase; thresholds are given as alternating key/value pairs
+func (b *RolloutPlanBuilder) Phase(percentage float64, requireApproval bool, thresholds ...interface{}) *RolloutPlanBuilder {
+	phase := rollout.RolloutPhase{
+		ID:              fmt.Sprintf("phase-%d", len(b.plan.Phases)+1),
+		Percentage:      percentage,
+		StartTime:       b.plan.CreatedAt,
+		Duration:        "1h",
+		RequireApproval: requireApproval,
+		Thresholds:      make(map[string]float64),
+	}
+
+	for i := 0; i+1 < len(thresholds); i += 2 {
+		key, _ := thresholds[i].(string)
+		switch v := thresholds[i+1].(type) {
+		case float64:
+			phase.Thresholds[key] = v
+		case int:
+			phase.Thresholds[key] = float64(v)
+		}
+	}
+
+	b.plan.Phases = append(b.plan.Phases, phase)
+	return b
+}
+
+// Metrics sets the canary metrics of the most recently added phase
+func (b *RolloutPlanBuilder) Metrics(metrics ...string) *RolloutPlanBuilder {
+	if n := len(b.plan.Phases); n > 0 {
+		b.plan.Phases[n-1].Metrics = metrics
+	}
+	return b
+}
+
+// Gate sets the gate expression of the most recently added phase
+func (b *RolloutPlanBuilder) Gate(expression string) *RolloutPlanBuilder {
+	if n := len(b.plan.Phases); n > 0 {
+		b.plan.Phases[n-1].Gate = expression
+	}
+	return b
+}
+
+// CurrentPhase sets the active phase index
+func (b *RolloutPlanBuilder) CurrentPhase(phase int) *RolloutPlanBuilder {
+	b.plan.CurrentPhase = phase
+	return b
+}
+
+// Schedule sets the scheduled start, timezone and blackout dates
+func (b *RolloutPlanBuilder) Schedule(start, timezone string, blackoutDates ...string) *RolloutPlanBuilder {
+	b.plan.ScheduledStart = start
+	b.plan.ScheduleTimezone = timezone
+	b.plan.BlackoutDates = blackoutDates
+	return b
+}
+
+// Regions limits the rollout to regions and, for each region in order, adds
+// a full phase that opens it
+func (b *RolloutPlanBuilder) Regions(regions ...string) *RolloutPlanBuilder {
+	b.plan.TargetRegions = regions
+	for _, region := range regions {
+		b.plan.Phases = append(b.plan.Phases, rollout.RolloutPhase{
+			ID:         fmt.Sprintf("phase-%d", len(b.plan.Phases)+1),
+			Percentage: 100,
+			StartTime:  b.plan.CreatedAt,
+			Duration:   "1h",
+			Regions:    []string{region},
+		})
+	}
+	return b
+}
+
+// Window sets the device-local update window of the most recently added phase
+func (b *RolloutPlanBuilder) Window(window string) *RolloutPlanBuilder {
+	if n := len(b.plan.Phases); n > 0 {
+		b.plan.Phases[n-1].Window = window
+	}
+	return b
+}
+
+// BusinessHours sets the device-local hours during which updates are deferred
+func (b *RolloutPlanBuilder) BusinessHours(hours string) *RolloutPlanBuilder {
+	b.plan.BusinessHours = hours
+	return b
+}
+
+// Critical marks the rollout critical, so it bypasses bandwidth caps
+func (b *RolloutPlanBuilder) Critical() *RolloutPlanBuilder {
+	b.plan.Critical = true
+	return b
+}
+
+// Build returns the rollout plan
+func (b *RolloutPlanBuilder) Build() rollout.RolloutPlan {
+	plan := b.plan
+	plan.Phases = append([]rollout.RolloutPhase{}, b.plan.Phases...)
+	return plan
+}
+
+// Put writes the rollout plan to a rollout table
+func (b *RolloutPlanBuilder) Put(ctx context.Context, client ItemWriter, tableName string) (rollout.RolloutPlan, error) {
+	plan := b.Build()
+	return plan, putItem(ctx, client, tableName, plan)
+}
+
+// DeviceBuilder builds device record fixtures
+type DeviceBuilder struct {
+	device fleetserver.DeviceRecord
+}
+
+// NewDevice starts a device record fixture
+func NewDevice(id string) *DeviceBuilder {
+	return &DeviceBuilder{device: fleetserver.DeviceRecord{
+		DeviceID:    id,
+		DeviceGroup: "default",
+		Region:      "us-east-1",
+		Tags:        make(map[string]string),
+	}}
+}
+
+// Tenant scopes the device to a tenant by prefixing its partition key
+func (b *DeviceBuilder) Tenant(tenantID string) *DeviceBuilder {
+	_, id := tenant.Split(b.device.DeviceID)
+	b.device.DeviceID = tenant.Key(tenantID, id)
+	return b
+}
+
+// Group sets the device group
+func (b *DeviceBuilder) Group(group string) *DeviceBuilder {
+	b.device.DeviceGroup = group
+	return b
+}
+
+// Region sets the device region
+func (b *DeviceBuilder) Region(region string) *DeviceBuilder {
+	b.device.Region = region
+	return b
+}
+
+// Timezone sets the device's IANA timezone
+func (b *DeviceBuilder) Timezone(timezone string) *DeviceBuilder {
+	b.device.Timezone = timezone
+	return b
+}
+
+// MaintenanceWindow sets the device-local hours the device accepts updates in
+func (b *DeviceBuilder) MaintenanceWindow(window string) *DeviceBuilder {
+	b.device.MaintenanceWindow = window
+	return b
+}
+
+// Version sets the device's current version
+func (b *DeviceBuilder) Version(version string) *DeviceBuilder {
+	b.device.CurrentVersion = version
+	return b
+}
+
+// Tag sets a device tag
+func (b *DeviceBuilder) Tag(key, value string) *DeviceBuilder {
+	b.device.Tags[key] = value
+	return b
+}
+
+// DynamicGroups sets the materialized dynamic groups
+func (b *DeviceBuilder) DynamicGroups(groups ...string) *DeviceBuilder {
+	b.device.DynamicGroups = groups
+	return b
+}
+
+// Updated records the outcome of the device's last update
+func (b *DeviceBuilder) Updated(rolloutID, status, message string) *DeviceBuilder {
+	b.device.LastUpdateID = rolloutID
+	b.device.UpdateStatus = status
+	b.device.LastUpdateMessage = message
+	b.device.LastUpdateTime = time.Now().UTC().Format(time.RFC3339)
+	return b
+}
+
+// HealthScore sets the device's stored health score
+func (b *DeviceBuilder) HealthScore(score float64) *DeviceBuilder {
+	b.device.HealthScore = &score
+	return b
+}
+
+// Usage sets the AWS usage the device reported for a rollout
+func (b *DeviceBuilder) Usage(rolloutID string, s3Bytes int64, readUnits, writeUnits float64) *DeviceBuilder {
+	b.device.UsageRolloutID = rolloutID
+	b.device.UsageS3Bytes = s3Bytes
+	b.device.UsageReadUnits = readUnits
+	b.device.UsageWriteUnits = writeUnits
+	return b
+}
+
+// Build returns the device record
+func (b *DeviceBuilder) Build() fleetserver.DeviceRecord {
+	device := b.device
+	device.Tags = make(map[string]string, len(b.device.Tags))
+	for k, v := range b.device.Tags {
+		device.Tags[k] = v
+	}
+	return device
+}
+
+// Put writes the device record to a device table
+func (b *DeviceBuilder) Put(ctx context.Context, client ItemWriter, tableName string) (fleetserver.DeviceRecord, error) {
+	device := b.Build()
+	return device, putItem(ctx, client, tableName, device)
+}
+
+// Fleet writes count devices named <prefix>-NNNN spread evenly across groups on a version
+func Fleet(ctx context.Context, client ItemWriter, tableName, prefix, version string, count int, groups ...string) ([]fleetserver.DeviceRecord, error) {
+	if len(groups) == 0 {
+		groups = []string{"default"}
+	}
+
+	devices := make([]fleetserver.DeviceRecord, 0, count)
+	for i := 0; i < count; i++ {
+		device, err := NewDevice(fmt.Sprintf("%s-%04d", prefix, i)).
+			Group(groups[i%len(groups)]).
+			Version(version).
+			Put(ctx, client, tableName)
+		if err != nil {
+			return nil, err
+		}
+		devices = append(devices, device)
+	}
+
+	return devices, nil
+}
+
+// Helper functions
+
+func putItem(ctx context.Context, client ItemWriter, tableName string, value interface{}) error {
+	item, err := attributevalue.MarshalMap(value)
+	if err != nil {
+		return fmt.Errorf("failed to marshal fixture: %w", err)
+	}
+
+	// Drop empty strings so sparse secondary index keys stay absent, as devices write them
+	for name, attr := range item {
+		if s, ok := attr.(*types.AttributeValueMemberS); ok && s.Value == "" {
+			delete(item, name)
+		}
+	}
+
+	_, err = client.PutItem(ctx, &dynamodb.PutItemInput{
+		TableName: aws.String(tableName),
+		Item:      item,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write fixture: %w", err)
+	}
+
+	return nil
+}
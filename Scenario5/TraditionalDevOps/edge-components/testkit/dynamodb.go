@@ -0,0 +1,501 @@
+package testkit
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// KeySchema names the partition and optional sort key of a table or index
+type KeySchema struct {
+	HashKey  string
+	RangeKey string
+}
+
+// fakeTable holds the items and secondary indexes of one table
+type fakeTable struct {
+	key     KeySchema
+	indexes map[string]KeySchema
+	items   map[string]map[string]types.AttributeValue
+}
+
+// FakeDynamoDB is an in-memory DynamoDB with the same method set as
+// *dynamodb.Client for the operations this repository uses; it evaluates
+// key condition, filter, condition and update expressions, supports global
+// secondary indexes and paginates with Limit and ExclusiveStartKey
+type FakeDynamoDB struct {
+	tables   map[string]*fakeTable
+	calls    map[string]int
+	pageSize int32
+	mutex    sync.Mutex
+}
+
+// NewFakeDynamoDB creates an empty FakeDynamoDB
+func NewFakeDynamoDB() *FakeDynamoDB {
+	return &FakeDynamoDB{
+		tables: make(map[string]*fakeTable),
+		calls:  make(map[string]int),
+	}
+}
+
+// CreateTable creates a table with the given key schema
+func (f *FakeDynamoDB) CreateTable(name string, key KeySchema) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.tables[name] = &fakeTable{
+		key:     key,
+		indexes: make(map[string]KeySchema),
+		items:   make(map[string]map[string]types.AttributeValue),
+	}
+}
+
+// CreateIndex adds a global secondary index to a table
+func (f *FakeDynamoDB) CreateIndex(tableName, indexName string, key KeySchema) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if table, ok := f.tables[tableName]; ok {
+		table.indexes[indexName] = key
+	}
+}
+
+// SetPageSize caps every Query and Scan page at size items, as DynamoDB's
+// 1MB page limit does for large tables, so tests can check that callers
+// follow LastEvaluatedKey; 0 removes the cap
+func (f *FakeDynamoDB) SetPageSize(size int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.pageSize = int32(size)
+}
+
+// Items returns copies of every item in a table
+func (f *FakeDynamoDB) Items(tableName string) []map[string]types.AttributeValue {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	table, ok := f.tables[tableName]
+	if !ok {
+		return nil
+	}
+
+	items := make([]map[string]types.AttributeValue, 0, len(table.items))
+	for _, item := range table.items {
+		items = append(items, copyItem(item))
+	}
+	sortItems(items, table.key)
+
+	return items
+}
+
+// Calls returns how many times an operation was invoked, e.g. Calls("UpdateItem")
+func (f *FakeDynamoDB) Calls(operation string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[operation]
+}
+
+// GetItem implements dynamodb.GetItemAPIClient
+func (f *FakeDynamoDB) GetItem(ctx context.Context, input *dynamodb.GetItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.GetItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["GetItem"]++
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := itemKey(table.key, input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	output := &dynamodb.GetItemOutput{}
+	if item, ok := table.items[key]; ok {
+		output.Item = project(copyItem(item), input.ProjectionExpression, input.ExpressionAttributeNames)
+	}
+
+	return output, nil
+}
+
+// PutItem implements the PutItem operation
+func (f *FakeDynamoDB) PutItem(ctx context.Context, input *dynamodb.PutItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.PutItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["PutItem"]++
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := itemKey(table.key, input.Item)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := table.items[key]
+	if err := checkCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, existing); err != nil {
+		return nil, err
+	}
+
+	table.items[key] = copyItem(input.Item)
+
+	output := &dynamodb.PutItemOutput{}
+	if input.ReturnValues == types.ReturnValueAllOld && existing != nil {
+		output.Attributes = copyItem(existing)
+	}
+
+	return output, nil
+}
+
+// UpdateItem implements the UpdateItem operation
+func (f *FakeDynamoDB) UpdateItem(ctx context.Context, input *dynamodb.UpdateItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.UpdateItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["UpdateItem"]++
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := itemKey(table.key, input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := table.items[key]
+	if err := checkCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, existing); err != nil {
+		return nil, err
+	}
+
+	updated := copyItem(input.Key)
+	if existing != nil {
+		updated = copyItem(existing)
+	}
+
+	ec := &expressionContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+	if err := ec.applyUpdate(aws.ToString(input.UpdateExpression), updated); err != nil {
+		return nil, validationError(err)
+	}
+
+	table.items[key] = updated
+
+	output := &dynamodb.UpdateItemOutput{}
+	switch input.ReturnValues {
+	case types.ReturnValueAllNew, types.ReturnValueUpdatedNew:
+		output.Attributes = copyItem(updated)
+	case types.ReturnValueAllOld, types.ReturnValueUpdatedOld:
+		if existing != nil {
+			output.Attributes = copyItem(existing)
+		}
+	}
+
+	return output, nil
+}
+
+// DeleteItem implements the DeleteItem operation
+func (f *FakeDynamoDB) DeleteItem(ctx context.Context, input *dynamodb.DeleteItemInput, _ ...func(*dynamodb.Options)) (*dynamodb.DeleteItemOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["DeleteItem"]++
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := itemKey(table.key, input.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	existing := table.items[key]
+	if err := checkCondition(input.ConditionExpression, input.ExpressionAttributeNames, input.ExpressionAttributeValues, existing); err != nil {
+		return nil, err
+	}
+
+	delete(table.items, key)
+
+	output := &dynamodb.DeleteItemOutput{}
+	if input.ReturnValues == types.ReturnValueAllOld && existing != nil {
+		output.Attributes = existing
+	}
+
+	return output, nil
+}
+
+// Query implements dynamodb.QueryAPIClient
+func (f *FakeDynamoDB) Query(ctx context.Context, input *dynamodb.QueryInput, _ ...func(*dynamodb.Options)) (*dynamodb.QueryOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["Query"]++
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	schema := table.key
+	if input.IndexName != nil {
+		index, ok := table.indexes[*input.IndexName]
+		if !ok {
+			return nil, validationError(fmt.Errorf("table %s has no index %s", *input.TableName, *input.IndexName))
+		}
+		schema = index
+	}
+
+	ec := &expressionContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+
+	matched := make([]map[string]types.AttributeValue, 0)
+	for _, item := range table.items {
+		// Sparse indexes only contain items that have the index key attributes
+		if item[schema.HashKey] == nil || (schema.RangeKey != "" && item[schema.RangeKey] == nil) {
+			continue
+		}
+
+		ok, err := ec.evaluateCondition(aws.ToString(input.KeyConditionExpression), item)
+		if err != nil {
+			return nil, validationError(err)
+		}
+		if ok {
+			matched = append(matched, item)
+		}
+	}
+
+	// Order by the table key first so index items with equal keys keep a
+	// stable order across pages, as DynamoDB's do
+	sortItems(matched, table.key)
+	sortItems(matched, schema)
+	if input.ScanIndexForward != nil && !*input.ScanIndexForward {
+		for i, j := 0, len(matched)-1; i < j; i, j = i+1, j-1 {
+			matched[i], matched[j] = matched[j], matched[i]
+		}
+	}
+
+	page, lastKey, err := paginate(matched, table.key, schema, input.ExclusiveStartKey, f.limit(input.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	items, scanned, err := filterItems(ec, page, input.FilterExpression, input.ProjectionExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.QueryOutput{
+		Items:            items,
+		Count:            int32(len(items)),
+		ScannedCount:     scanned,
+		LastEvaluatedKey: lastKey,
+	}, nil
+}
+
+// Scan implements dynamodb.ScanAPIClient
+func (f *FakeDynamoDB) Scan(ctx context.Context, input *dynamodb.ScanInput, _ ...func(*dynamodb.Options)) (*dynamodb.ScanOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["Scan"]++
+
+	table, err := f.table(input.TableName)
+	if err != nil {
+		return nil, err
+	}
+
+	all := make([]map[string]types.AttributeValue, 0, len(table.items))
+	for _, item := range table.items {
+		all = append(all, item)
+	}
+	sortItems(all, table.key)
+
+	page, lastKey, err := paginate(all, table.key, table.key, input.ExclusiveStartKey, f.limit(input.Limit))
+	if err != nil {
+		return nil, err
+	}
+
+	ec := &expressionContext{names: input.ExpressionAttributeNames, values: input.ExpressionAttributeValues}
+	items, scanned, err := filterItems(ec, page, input.FilterExpression, input.ProjectionExpression)
+	if err != nil {
+		return nil, err
+	}
+
+	return &dynamodb.ScanOutput{
+		Items:            items,
+		Count:            int32(len(items)),
+		ScannedCount:     scanned,
+		LastEvaluatedKey: lastKey,
+	}, nil
+}
+
+// limit applies the page size cap to a request's Limit
+func (f *FakeDynamoDB) limit(limit *int32) *int32 {
+	if f.pageSize > 0 && (limit == nil || *limit <= 0 || *limit > f.pageSize) {
+		return aws.Int32(f.pageSize)
+	}
+	return limit
+}
+
+// table looks up a table by name
+func (f *FakeDynamoDB) table(name *string) (*fakeTable, error) {
+	table, ok := f.tables[aws.ToString(name)]
+	if !ok {
+		return nil, &types.ResourceNotFoundException{Message: aws.String("Requested resource not found: " + aws.ToString(name))}
+	}
+	return table, nil
+}
+
+// Helper functions
+
+// itemKey renders the primary key of an item as a map key
+func itemKey(schema KeySchema, item map[string]types.AttributeValue) (string, error) {
+	hash, ok := item[schema.HashKey]
+	if !ok {
+		return "", validationError(fmt.Errorf("missing key attribute %s", schema.HashKey))
+	}
+
+	key := scalarString(hash)
+	if schema.RangeKey != "" {
+		rangeValue, ok := item[schema.RangeKey]
+		if !ok {
+			return "", validationError(fmt.Errorf("missing key attribute %s", schema.RangeKey))
+		}
+		key += "\x00" + scalarString(rangeValue)
+	}
+
+	return key, nil
+}
+
+func scalarString(value types.AttributeValue) string {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberS:
+		return "S:" + v.Value
+	case *types.AttributeValueMemberN:
+		return "N:" + v.Value
+	case *types.AttributeValueMemberB:
+		return "B:" + string(v.Value)
+	}
+	return fmt.Sprintf("%T", value)
+}
+
+// sortItems orders items by hash key then range key, as DynamoDB returns query results
+func sortItems(items []map[string]types.AttributeValue, schema KeySchema) {
+	sort.SliceStable(items, func(i, j int) bool {
+		if c := compareValues(items[i][schema.HashKey], items[j][schema.HashKey]); c != 0 {
+			return c < 0
+		}
+		if schema.RangeKey == "" {
+			return false
+		}
+		return compareValues(items[i][schema.RangeKey], items[j][schema.RangeKey]) < 0
+	})
+}
+
+// paginate applies ExclusiveStartKey and Limit to ordered items
+func paginate(items []map[string]types.AttributeValue, tableKey, schema KeySchema, startKey map[string]types.AttributeValue, limit *int32) ([]map[string]types.AttributeValue, map[string]types.AttributeValue, error) {
+	start := 0
+	if len(startKey) > 0 {
+		want, err := itemKey(tableKey, startKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i, item := range items {
+			if key, _ := itemKey(tableKey, item); key == want {
+				start = i + 1
+				break
+			}
+		}
+	}
+
+	end := len(items)
+	if limit != nil && *limit > 0 && start+int(*limit) < end {
+		end = start + int(*limit)
+	}
+
+	page := items[start:end]
+
+	var lastKey map[string]types.AttributeValue
+	if end < len(items) && len(page) > 0 {
+		last := page[len(page)-1]
+		lastKey = make(map[string]types.AttributeValue)
+		for _, name := range []string{tableKey.HashKey, tableKey.RangeKey, schema.HashKey, schema.RangeKey} {
+			if name != "" && last[name] != nil {
+				lastKey[name] = copyValue(last[name])
+			}
+		}
+	}
+
+	return page, lastKey, nil
+}
+
+// filterItems applies a filter and projection to a page of items, returning copies
+func filterItems(ec *expressionContext, page []map[string]types.AttributeValue, filter, projection *string) ([]map[string]types.AttributeValue, int32, error) {
+	items := make([]map[string]types.AttributeValue, 0, len(page))
+	for _, item := range page {
+		ok, err := ec.evaluateCondition(aws.ToString(filter), item)
+		if err != nil {
+			return nil, 0, validationError(err)
+		}
+		if ok {
+			items = append(items, project(copyItem(item), projection, ec.names))
+		}
+	}
+	return items, int32(len(page)), nil
+}
+
+// project keeps only the top-level attributes named in a projection expression
+func project(item map[string]types.AttributeValue, projection *string, names map[string]string) map[string]types.AttributeValue {
+	if projection == nil || item == nil {
+		return item
+	}
+
+	projected := make(map[string]types.AttributeValue)
+	for _, attr := range strings.Split(*projection, ",") {
+		attr = strings.TrimSpace(attr)
+		if i := strings.IndexAny(attr, ".["); i >= 0 {
+			attr = attr[:i]
+		}
+		if resolved, ok := names[attr]; ok {
+			attr = resolved
+		}
+		if value, ok := item[attr]; ok {
+			projected[attr] = value
+		}
+	}
+	return projected
+}
+
+// checkCondition evaluates a condition expression against the existing item
+func checkCondition(condition *string, names map[string]string, values map[string]types.AttributeValue, existing map[string]types.AttributeValue) error {
+	if condition == nil {
+		return nil
+	}
+
+	item := existing
+	if item == nil {
+		item = map[string]types.AttributeValue{}
+	}
+
+	ec := &expressionContext{names: names, values: values}
+	ok, err := ec.evaluateCondition(*condition, item)
+	if err != nil {
+		return validationError(err)
+	}
+	if !ok {
+		return &types.ConditionalCheckFailedException{Message: aws.String("The conditional request failed")}
+	}
+
+	return nil
+}
+
+// validationError wraps an expression error the way DynamoDB reports it
+func validationError(err error) error {
+	return fmt.Errorf("ValidationException: %w", err)
+}
@@ -0,0 +1,663 @@
+package testkit
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+)
+
+// expressionContext resolves placeholders in DynamoDB expressions
+type expressionContext struct {
+	names  map[string]string
+	values map[string]types.AttributeValue
+}
+
+// pathElement is one step of a document path: a map key or a list index
+type pathElement struct {
+	name  string
+	index int
+	isIdx bool
+}
+
+// tokenize splits an expression into paths, placeholders, operators and punctuation
+func tokenize(expression string) []string {
+	tokens := make([]string, 0)
+	runes := []rune(expression)
+
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '(' || r == ')' || r == ',' || r == '+' || r == '-':
+			tokens = append(tokens, string(r))
+			i++
+		case r == '<' || r == '>' || r == '=':
+			if i+1 < len(runes) && (runes[i+1] == '=' || (r == '<' && runes[i+1] == '>')) {
+				tokens = append(tokens, string(runes[i:i+2]))
+				i += 2
+			} else {
+				tokens = append(tokens, string(r))
+				i++
+			}
+		default:
+			start := i
+			for i < len(runes) && isPathRune(runes[i]) {
+				i++
+			}
+			if i == start {
+				// Unknown character; emit it so the parser reports a useful error
+				i++
+			}
+			tokens = append(tokens, string(runes[start:i]))
+		}
+	}
+
+	return tokens
+}
+
+func isPathRune(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || r == '_' || r == '#' || r == ':' || r == '.' || r == '[' || r == ']'
+}
+
+// parsePath splits a document path such as `#a.Phases[2].Approved` into resolved elements
+func (ec *expressionContext) parsePath(path string) ([]pathElement, error) {
+	elements := make([]pathElement, 0)
+
+	for _, segment := range strings.Split(path, ".") {
+		name := segment
+		indexes := ""
+		if i := strings.Index(segment, "["); i >= 0 {
+			name, indexes = segment[:i], segment[i:]
+		}
+
+		if strings.HasPrefix(name, "#") {
+			resolved, ok := ec.names[name]
+			if !ok {
+				return nil, fmt.Errorf("undefined expression attribute name %s", name)
+			}
+			name = resolved
+		}
+		if name == "" {
+			return nil, fmt.Errorf("invalid document path %q", path)
+		}
+		elements = append(elements, pathElement{name: name})
+
+		for indexes != "" {
+			end := strings.Index(indexes, "]")
+			if !strings.HasPrefix(indexes, "[") || end < 0 {
+				return nil, fmt.Errorf("invalid document path %q", path)
+			}
+			n, err := strconv.Atoi(indexes[1:end])
+			if err != nil {
+				return nil, fmt.Errorf("invalid list index in %q", path)
+			}
+			elements = append(elements, pathElement{index: n, isIdx: true})
+			indexes = indexes[end+1:]
+		}
+	}
+
+	return elements, nil
+}
+
+// operand resolves a placeholder or document path against an item
+func (ec *expressionContext) operand(item map[string]types.AttributeValue, token string) (types.AttributeValue, error) {
+	if strings.HasPrefix(token, ":") {
+		value, ok := ec.values[token]
+		if !ok {
+			return nil, fmt.Errorf("undefined expression attribute value %s", token)
+		}
+		return value, nil
+	}
+
+	path, err := ec.parsePath(token)
+	if err != nil {
+		return nil, err
+	}
+	return getPath(item, path), nil
+}
+
+// getPath reads a document path, returning nil when any step is missing
+func getPath(item map[string]types.AttributeValue, path []pathElement) types.AttributeValue {
+	var current types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+
+	for _, element := range path {
+		switch v := current.(type) {
+		case *types.AttributeValueMemberM:
+			if element.isIdx {
+				return nil
+			}
+			current = v.Value[element.name]
+		case *types.AttributeValueMemberL:
+			if !element.isIdx || element.index >= len(v.Value) {
+				return nil
+			}
+			current = v.Value[element.index]
+		default:
+			return nil
+		}
+		if current == nil {
+			return nil
+		}
+	}
+
+	return current
+}
+
+// setPath writes a document path, creating the final map entry or appending past the end of a list
+func setPath(item map[string]types.AttributeValue, path []pathElement, value types.AttributeValue) error {
+	var parent types.AttributeValue = &types.AttributeValueMemberM{Value: item}
+
+	for i, element := range path {
+		last := i == len(path)-1
+
+		switch p := parent.(type) {
+		case *types.AttributeValueMemberM:
+			if element.isIdx {
+				return fmt.Errorf("cannot index a map")
+			}
+			if last {
+				p.Value[element.name] = value
+				return nil
+			}
+			next, ok := p.Value[element.name]
+			if !ok {
+				return fmt.Errorf("document path %s does not exist", element.name)
+			}
+			parent = next
+		case *types.AttributeValueMemberL:
+			if !element.isIdx {
+				return fmt.Errorf("cannot access a list by name")
+			}
+			if last {
+				if element.index >= len(p.Value) {
+					p.Value = append(p.Value, value)
+				} else {
+					p.Value[element.index] = value
+				}
+				return nil
+			}
+			if element.index >= len(p.Value) {
+				return fmt.Errorf("list index %d out of range", element.index)
+			}
+			parent = p.Value[element.index]
+		default:
+			return fmt.Errorf("document path traverses a scalar")
+		}
+	}
+
+	return nil
+}
+
+// removePath deletes a document path
+func removePath(item map[string]types.AttributeValue, path []pathElement) {
+	if len(path) == 0 {
+		return
+	}
+
+	parent := getPath(item, path[:len(path)-1])
+	if len(path) == 1 {
+		parent = &types.AttributeValueMemberM{Value: item}
+	}
+
+	last := path[len(path)-1]
+	switch p := parent.(type) {
+	case *types.AttributeValueMemberM:
+		delete(p.Value, last.name)
+	case *types.AttributeValueMemberL:
+		if last.isIdx && last.index < len(p.Value) {
+			p.Value = append(p.Value[:last.index], p.Value[last.index+1:]...)
+		}
+	}
+}
+
+// conditionParser evaluates condition, filter and key condition expressions
+type conditionParser struct {
+	ctx    *expressionContext
+	item   map[string]types.AttributeValue
+	tokens []string
+	pos    int
+}
+
+// evaluateCondition reports whether an item satisfies a condition expression
+func (ec *expressionContext) evaluateCondition(expression string, item map[string]types.AttributeValue) (bool, error) {
+	if strings.TrimSpace(expression) == "" {
+		return true, nil
+	}
+
+	p := &conditionParser{ctx: ec, item: item, tokens: tokenize(expression)}
+	result, err := p.or()
+	if err != nil {
+		return false, err
+	}
+	if p.pos != len(p.tokens) {
+		return false, fmt.Errorf("unexpected token %q in %q", p.tokens[p.pos], expression)
+	}
+
+	return result, nil
+}
+
+func (p *conditionParser) peek() string {
+	if p.pos < len(p.tokens) {
+		return p.tokens[p.pos]
+	}
+	return ""
+}
+
+func (p *conditionParser) next() string {
+	token := p.peek()
+	p.pos++
+	return token
+}
+
+func (p *conditionParser) expect(token string) error {
+	if got := p.next(); got != token {
+		return fmt.Errorf("expected %q, got %q", token, got)
+	}
+	return nil
+}
+
+func (p *conditionParser) or() (bool, error) {
+	left, err := p.and()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(p.peek(), "OR") {
+		p.next()
+		right, err := p.and()
+		if err != nil {
+			return false, err
+		}
+		left = left || right
+	}
+	return left, nil
+}
+
+func (p *conditionParser) and() (bool, error) {
+	left, err := p.factor()
+	if err != nil {
+		return false, err
+	}
+	for strings.EqualFold(p.peek(), "AND") {
+		p.next()
+		right, err := p.factor()
+		if err != nil {
+			return false, err
+		}
+		left = left && right
+	}
+	return left, nil
+}
+
+func (p *conditionParser) factor() (bool, error) {
+	token := p.next()
+
+	switch {
+	case strings.EqualFold(token, "NOT"):
+		result, err := p.factor()
+		return !result, err
+
+	case token == "(":
+		result, err := p.or()
+		if err != nil {
+			return false, err
+		}
+		return result, p.expect(")")
+
+	case token == "attribute_exists" || token == "attribute_not_exists":
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		value, err := p.ctx.operand(p.item, p.next())
+		if err != nil {
+			return false, err
+		}
+		if err := p.expect(")"); err != nil {
+			return false, err
+		}
+		return (value != nil) == (token == "attribute_exists"), nil
+
+	case token == "begins_with" || token == "contains":
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		subject, err := p.ctx.operand(p.item, p.next())
+		if err != nil {
+			return false, err
+		}
+		if err := p.expect(","); err != nil {
+			return false, err
+		}
+		operand, err := p.ctx.operand(p.item, p.next())
+		if err != nil {
+			return false, err
+		}
+		if err := p.expect(")"); err != nil {
+			return false, err
+		}
+		if token == "begins_with" {
+			s, sok := subject.(*types.AttributeValueMemberS)
+			o, ook := operand.(*types.AttributeValueMemberS)
+			return sok && ook && strings.HasPrefix(s.Value, o.Value), nil
+		}
+		return containsValue(subject, operand), nil
+	}
+
+	left, err := p.ctx.operand(p.item, token)
+	if err != nil {
+		return false, err
+	}
+
+	operator := p.next()
+	if strings.EqualFold(operator, "BETWEEN") {
+		low, err := p.ctx.operand(p.item, p.next())
+		if err != nil {
+			return false, err
+		}
+		if token := p.next(); !strings.EqualFold(token, "AND") {
+			return false, fmt.Errorf("expected AND in BETWEEN, got %q", token)
+		}
+		high, err := p.ctx.operand(p.item, p.next())
+		if err != nil {
+			return false, err
+		}
+		return left != nil && compareValues(left, low) >= 0 && compareValues(left, high) <= 0, nil
+	}
+
+	if strings.EqualFold(operator, "IN") {
+		if err := p.expect("("); err != nil {
+			return false, err
+		}
+		found := false
+		for {
+			candidate, err := p.ctx.operand(p.item, p.next())
+			if err != nil {
+				return false, err
+			}
+			if left != nil && compareValues(left, candidate) == 0 {
+				found = true
+			}
+			if p.peek() != "," {
+				break
+			}
+			p.next()
+		}
+		return found, p.expect(")")
+	}
+
+	right, err := p.ctx.operand(p.item, p.next())
+	if err != nil {
+		return false, err
+	}
+
+	if left == nil || right == nil {
+		return operator == "<>" && (left == nil) != (right == nil), nil
+	}
+
+	cmp := compareValues(left, right)
+	switch operator {
+	case "=":
+		return cmp == 0, nil
+	case "<>":
+		return cmp != 0, nil
+	case "<":
+		return cmp < 0, nil
+	case "<=":
+		return cmp <= 0, nil
+	case ">":
+		return cmp > 0, nil
+	case ">=":
+		return cmp >= 0, nil
+	}
+
+	return false, fmt.Errorf("unsupported operator %q", operator)
+}
+
+// applyUpdate applies an update expression to an item in place
+func (ec *expressionContext) applyUpdate(expression string, item map[string]types.AttributeValue) error {
+	tokens := tokenize(expression)
+	action := ""
+
+	for i := 0; i < len(tokens); {
+		switch strings.ToUpper(tokens[i]) {
+		case "SET", "REMOVE", "ADD", "DELETE":
+			action = strings.ToUpper(tokens[i])
+			i++
+			continue
+		case ",":
+			i++
+			continue
+		}
+
+		path, err := ec.parsePath(tokens[i])
+		if err != nil {
+			return err
+		}
+		i++
+
+		switch action {
+		case "SET":
+			if i >= len(tokens) || tokens[i] != "=" {
+				return fmt.Errorf("expected = after %s in SET", tokens[i-1])
+			}
+			value, consumed, err := ec.updateValue(item, tokens[i+1:])
+			if err != nil {
+				return err
+			}
+			i += 1 + consumed
+			if err := setPath(item, path, value); err != nil {
+				return err
+			}
+
+		case "REMOVE":
+			removePath(item, path)
+
+		case "ADD":
+			if i >= len(tokens) {
+				return fmt.Errorf("missing value for ADD")
+			}
+			value, err := ec.operand(item, tokens[i])
+			if err != nil {
+				return err
+			}
+			i++
+			current := getPath(item, path)
+			if current == nil {
+				if err := setPath(item, path, copyValue(value)); err != nil {
+					return err
+				}
+				continue
+			}
+			sum, err := arithmetic(current, value, "+")
+			if err != nil {
+				return err
+			}
+			if err := setPath(item, path, sum); err != nil {
+				return err
+			}
+
+		default:
+			return fmt.Errorf("unsupported update action in %q", expression)
+		}
+	}
+
+	return nil
+}
+
+// updateValue parses a SET value: an operand, `a + b`, `a - b`, if_not_exists or list_append
+func (ec *expressionContext) updateValue(item map[string]types.AttributeValue, tokens []string) (types.AttributeValue, int, error) {
+	if len(tokens) == 0 {
+		return nil, 0, fmt.Errorf("missing SET value")
+	}
+
+	left, consumed, err := ec.updateTerm(item, tokens)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	if consumed < len(tokens) && (tokens[consumed] == "+" || tokens[consumed] == "-") {
+		right, more, err := ec.updateTerm(item, tokens[consumed+1:])
+		if err != nil {
+			return nil, 0, err
+		}
+		result, err := arithmetic(left, right, tokens[consumed])
+		return result, consumed + 1 + more, err
+	}
+
+	return copyValue(left), consumed, nil
+}
+
+func (ec *expressionContext) updateTerm(item map[string]types.AttributeValue, tokens []string) (types.AttributeValue, int, error) {
+	if len(tokens) >= 6 && (tokens[0] == "if_not_exists" || tokens[0] == "list_append") && tokens[1] == "(" && tokens[3] == "," && tokens[5] == ")" {
+		a, err := ec.operand(item, tokens[2])
+		if err != nil {
+			return nil, 0, err
+		}
+		b, err := ec.operand(item, tokens[4])
+		if err != nil {
+			return nil, 0, err
+		}
+
+		if tokens[0] == "if_not_exists" {
+			if a != nil {
+				return a, 6, nil
+			}
+			return b, 6, nil
+		}
+
+		la, aok := a.(*types.AttributeValueMemberL)
+		lb, bok := b.(*types.AttributeValueMemberL)
+		if !aok || !bok {
+			return nil, 0, fmt.Errorf("list_append requires two lists")
+		}
+		joined := append(append([]types.AttributeValue{}, la.Value...), lb.Value...)
+		return &types.AttributeValueMemberL{Value: joined}, 6, nil
+	}
+
+	value, err := ec.operand(item, tokens[0])
+	if err != nil {
+		return nil, 0, err
+	}
+	if value == nil {
+		return nil, 0, fmt.Errorf("document path %s does not exist", tokens[0])
+	}
+	return value, 1, nil
+}
+
+// Helper functions
+
+// compareValues orders two attribute values of the same scalar type
+func compareValues(a, b types.AttributeValue) int {
+	switch av := a.(type) {
+	case *types.AttributeValueMemberS:
+		if bv, ok := b.(*types.AttributeValueMemberS); ok {
+			return strings.Compare(av.Value, bv.Value)
+		}
+	case *types.AttributeValueMemberN:
+		if bv, ok := b.(*types.AttributeValueMemberN); ok {
+			x, _ := strconv.ParseFloat(av.Value, 64)
+			y, _ := strconv.ParseFloat(bv.Value, 64)
+			switch {
+			case x < y:
+				return -1
+			case x > y:
+				return 1
+			}
+			return 0
+		}
+	}
+
+	if reflect.DeepEqual(a, b) {
+		return 0
+	}
+	return -2
+}
+
+func containsValue(subject, operand types.AttributeValue) bool {
+	switch s := subject.(type) {
+	case *types.AttributeValueMemberS:
+		o, ok := operand.(*types.AttributeValueMemberS)
+		return ok && strings.Contains(s.Value, o.Value)
+	case *types.AttributeValueMemberSS:
+		o, ok := operand.(*types.AttributeValueMemberS)
+		if !ok {
+			return false
+		}
+		for _, v := range s.Value {
+			if v == o.Value {
+				return true
+			}
+		}
+	case *types.AttributeValueMemberL:
+		for _, v := range s.Value {
+			if compareValues(v, operand) == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func arithmetic(a, b types.AttributeValue, operator string) (types.AttributeValue, error) {
+	an, aok := a.(*types.AttributeValueMemberN)
+	bn, bok := b.(*types.AttributeValueMemberN)
+	if !aok || !bok {
+		return nil, fmt.Errorf("arithmetic requires numbers")
+	}
+
+	x, err := strconv.ParseFloat(an.Value, 64)
+	if err != nil {
+		return nil, err
+	}
+	y, err := strconv.ParseFloat(bn.Value, 64)
+	if err != nil {
+		return nil, err
+	}
+
+	if operator == "-" {
+		y = -y
+	}
+
+	return &types.AttributeValueMemberN{Value: strconv.FormatFloat(x+y, 'f', -1, 64)}, nil
+}
+
+// copyValue deep-copies an attribute value so stored items never alias caller data
+func copyValue(value types.AttributeValue) types.AttributeValue {
+	switch v := value.(type) {
+	case *types.AttributeValueMemberM:
+		return &types.AttributeValueMemberM{Value: copyItem(v.Value)}
+	case *types.AttributeValueMemberL:
+		list := make([]types.AttributeValue, len(v.Value))
+		for i, element := range v.Value {
+			list[i] = copyValue(element)
+		}
+		return &types.AttributeValueMemberL{Value: list}
+	case *types.AttributeValueMemberS:
+		return &types.AttributeValueMemberS{Value: v.Value}
+	case *types.AttributeValueMemberN:
+		return &types.AttributeValueMemberN{Value: v.Value}
+	case *types.AttributeValueMemberBOOL:
+		return &types.AttributeValueMemberBOOL{Value: v.Value}
+	case *types.AttributeValueMemberNULL:
+		return &types.AttributeValueMemberNULL{Value: v.Value}
+	case *types.AttributeValueMemberB:
+		return &types.AttributeValueMemberB{Value: append([]byte{}, v.Value...)}
+	case *types.AttributeValueMemberSS:
+		return &types.AttributeValueMemberSS{Value: append([]string{}, v.Value...)}
+	case *types.AttributeValueMemberNS:
+		return &types.AttributeValueMemberNS{Value: append([]string{}, v.Value...)}
+	}
+	return value
+}
+
+func copyItem(item map[string]types.AttributeValue) map[string]types.AttributeValue {
+	copied := make(map[string]types.AttributeValue, len(item))
+	for k, v := range item {
+		copied[k] = copyValue(v)
+	}
+	return copied
+}
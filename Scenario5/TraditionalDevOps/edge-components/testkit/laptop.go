@@ -0,0 +1,88 @@
+package testkit
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/kvstore"
+	offlineSync "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/offline-sync"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/rollout"
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/tenant"
+)
+
+// LaptopBucket is the bucket packages are published to in laptop mode
+const LaptopBucket = "edge-artifacts-test"
+
+// Laptop wires every backend the managers need in memory, for demos, unit
+// tests and development with no cloud access: FakeDynamoDB is the rollout
+// and device store, FakeS3 holds packages, and a MemoryTransport stands in
+// for the sync bucket. Stores use the memory KVStore backend.
+type Laptop struct {
+	Dynamo *FakeDynamoDB
+	S3     *FakeS3
+	Sync   *offlineSync.MemoryTransport
+	dir    string
+}
+
+// NewLaptop creates the in-memory backends; dir holds the files the managers
+// still write to disk, i.e. downloaded packages and the sync cache
+func NewLaptop(dir string) *Laptop {
+	return &Laptop{
+		Dynamo: NewFleetDynamoDB(),
+		S3:     NewFakeS3(),
+		Sync:   offlineSync.NewMemoryTransport(),
+		dir:    dir,
+	}
+}
+
+// RolloutConfig returns a RolloutManager configuration for a device
+func (l *Laptop) RolloutConfig(deviceID string) rollout.RolloutConfig {
+	return rollout.RolloutConfig{
+		DynamoClient:      l.Dynamo,
+		S3Client:          l.S3,
+		DeviceID:          deviceID,
+		DeviceGroup:       "all",
+		RolloutTableName:  RolloutTable,
+		DeviceTableName:   DeviceTable,
+		ArtifactTableName: ArtifactTable,
+		UpdateBasePath:    filepath.Join(l.dir, deviceID, "updates"),
+		CheckInterval:     10 * time.Second,
+	}
+}
+
+// SyncConfig returns a SyncManager configuration for a device
+func (l *Laptop) SyncConfig(deviceID string) offlineSync.SyncConfig {
+	return offlineSync.SyncConfig{
+		DeviceID:       deviceID,
+		LocalCachePath: filepath.Join(l.dir, deviceID, "cache"),
+		SyncInterval:   10 * time.Second,
+		StorageBackend: kvstore.BackendMemory,
+		Transport:      l.Sync,
+	}
+}
+
+// AddDevice registers a device record, so rollouts can target it
+func (l *Laptop) AddDevice(ctx context.Context, device *DeviceBuilder) error {
+	_, err := device.Put(ctx, l.Dynamo, DeviceTable)
+	return err
+}
+
+// PublishRollout stores package as the plan's package and writes the plan,
+// so devices polling the rollout table pick it up
+func (l *Laptop) PublishRollout(ctx context.Context, plan *RolloutPlanBuilder, pkg []byte) (rollout.RolloutPlan, error) {
+	built := plan.Build()
+	key := fmt.Sprintf("%s/%s/package.tar.gz", built.ID, built.Version)
+	l.S3.SetObject(LaptopBucket, key, pkg)
+
+	plan.Package(fmt.Sprintf("s3://%s/%s", LaptopBucket, key), fmt.Sprintf("%x", sha256.Sum256(pkg)))
+	return plan.Put(ctx, l.Dynamo, RolloutTable)
+}
+
+// DeliverUpdate publishes a sync update to a device, as the fleet server would
+func (l *Laptop) DeliverUpdate(ctx context.Context, deviceID, name, dataType string, data []byte) error {
+	prefix := fmt.Sprintf("%sdevices/%s/", tenant.S3Prefix(""), deviceID)
+	return offlineSync.DeliverUpdate(ctx, l.Sync, prefix, name, dataType, data)
+}
@@ -0,0 +1,191 @@
+package testkit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb"
+	"github.com/aws/aws-sdk-go-v2/service/dynamodb/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+
+	fleetserver "github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/fleet-server"
+)
+
+// Environment variables that enable the localstack/minio integration path
+const (
+	// LocalstackEndpointEnv points DynamoDB (and S3 when MinioEndpointEnv is unset) at localstack, e.g. http://localhost:4566
+	LocalstackEndpointEnv = "TESTKIT_LOCALSTACK_ENDPOINT"
+
+	// MinioEndpointEnv points S3 at minio, e.g. http://localhost:9000
+	MinioEndpointEnv = "TESTKIT_MINIO_ENDPOINT"
+)
+
+// LocalConfig contains connection settings for localstack and minio
+type LocalConfig struct {
+	DynamoEndpoint string
+	S3Endpoint     string
+	Region         string
+	AccessKey      string
+	SecretKey      string
+}
+
+// LocalConfigFromEnv reads endpoints from the environment, returning false when
+// localstack is not configured so callers can skip integration tests
+func LocalConfigFromEnv() (LocalConfig, bool) {
+	endpoint := os.Getenv(LocalstackEndpointEnv)
+	if endpoint == "" {
+		return LocalConfig{}, false
+	}
+
+	s3Endpoint := os.Getenv(MinioEndpointEnv)
+	if s3Endpoint == "" {
+		s3Endpoint = endpoint
+	}
+
+	return LocalConfig{
+		DynamoEndpoint: endpoint,
+		S3Endpoint:     s3Endpoint,
+		Region:         "us-east-1",
+		AccessKey:      valueOrEnv("TESTKIT_ACCESS_KEY", "test"),
+		SecretKey:      valueOrEnv("TESTKIT_SECRET_KEY", "test"),
+	}, true
+}
+
+// NewLocalClients creates real SDK clients pointed at localstack and minio
+func NewLocalClients(ctx context.Context, local LocalConfig) (*dynamodb.Client, *s3.Client, error) {
+	cfg, err := config.LoadDefaultConfig(ctx,
+		config.WithRegion(local.Region),
+		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(local.AccessKey, local.SecretKey, "")),
+	)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+
+	dynamoClient := dynamodb.NewFromConfig(cfg, func(o *dynamodb.Options) {
+		o.BaseEndpoint = aws.String(local.DynamoEndpoint)
+	})
+
+	s3Client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		o.BaseEndpoint = aws.String(local.S3Endpoint)
+		// minio and localstack serve buckets by path rather than virtual host
+		o.UsePathStyle = true
+	})
+
+	return dynamoClient, s3Client, nil
+}
+
+// CreateFleetTables creates every fleet table and index, waiting until they are active
+func CreateFleetTables(ctx context.Context, client *dynamodb.Client) error {
+	for _, table := range fleetTables {
+		input := &dynamodb.CreateTableInput{
+			TableName:   aws.String(table.name),
+			BillingMode: types.BillingModePayPerRequest,
+			KeySchema:   keySchemaElements(table.key),
+		}
+
+		attributes := map[string]bool{}
+		addAttributes(attributes, table.key)
+
+		for name, key := range table.indexes {
+			addAttributes(attributes, key)
+			input.GlobalSecondaryIndexes = append(input.GlobalSecondaryIndexes, types.GlobalSecondaryIndex{
+				IndexName:  aws.String(name),
+				KeySchema:  keySchemaElements(key),
+				Projection: &types.Projection{ProjectionType: types.ProjectionTypeAll},
+			})
+		}
+
+		for name := range attributes {
+			input.AttributeDefinitions = append(input.AttributeDefinitions, types.AttributeDefinition{
+				AttributeName: aws.String(name),
+				AttributeType: types.ScalarAttributeTypeS,
+			})
+		}
+
+		_, err := client.CreateTable(ctx, input)
+		var inUse *types.ResourceInUseException
+		if err != nil && !errors.As(err, &inUse) {
+			return fmt.Errorf("failed to create table %s: %w", table.name, err)
+		}
+		created := err == nil
+
+		waiter := dynamodb.NewTableExistsWaiter(client)
+		if err := waiter.Wait(ctx, &dynamodb.DescribeTableInput{TableName: aws.String(table.name)}, time.Minute); err != nil {
+			return fmt.Errorf("table %s did not become active: %w", table.name, err)
+		}
+
+		// Enabling TTL twice fails, so tables that already existed are left alone
+		if created && ttlTables[table.name] {
+			_, err := client.UpdateTimeToLive(ctx, &dynamodb.UpdateTimeToLiveInput{
+				TableName: aws.String(table.name),
+				TimeToLiveSpecification: &types.TimeToLiveSpecification{
+					AttributeName: aws.String(fleetserver.ExpiresAtAttribute),
+					Enabled:       aws.Bool(true),
+				},
+			})
+			if err != nil {
+				return fmt.Errorf("failed to enable TTL on table %s: %w", table.name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// DeleteFleetTables removes every fleet table
+func DeleteFleetTables(ctx context.Context, client *dynamodb.Client) error {
+	for _, table := range fleetTables {
+		_, err := client.DeleteTable(ctx, &dynamodb.DeleteTableInput{TableName: aws.String(table.name)})
+		var notFound *types.ResourceNotFoundException
+		if err != nil && !errors.As(err, &notFound) {
+			return fmt.Errorf("failed to delete table %s: %w", table.name, err)
+		}
+	}
+	return nil
+}
+
+// CreateBucket creates an S3 bucket, ignoring buckets that already exist
+func CreateBucket(ctx context.Context, client *s3.Client, bucket string) error {
+	_, err := client.CreateBucket(ctx, &s3.CreateBucketInput{Bucket: aws.String(bucket)})
+
+	var owned *s3types.BucketAlreadyOwnedByYou
+	var exists *s3types.BucketAlreadyExists
+	if err != nil && !errors.As(err, &owned) && !errors.As(err, &exists) {
+		return fmt.Errorf("failed to create bucket %s: %w", bucket, err)
+	}
+
+	return nil
+}
+
+// Helper functions
+
+func keySchemaElements(key KeySchema) []types.KeySchemaElement {
+	elements := []types.KeySchemaElement{
+		{AttributeName: aws.String(key.HashKey), KeyType: types.KeyTypeHash},
+	}
+	if key.RangeKey != "" {
+		elements = append(elements, types.KeySchemaElement{AttributeName: aws.String(key.RangeKey), KeyType: types.KeyTypeRange})
+	}
+	return elements
+}
+
+func addAttributes(attributes map[string]bool, key KeySchema) {
+	attributes[key.HashKey] = true
+	if key.RangeKey != "" {
+		attributes[key.RangeKey] = true
+	}
+}
+
+func valueOrEnv(key, fallback string) string {
+	if value := os.Getenv(key); value != "" {
+		return value
+	}
+	return fallback
+}
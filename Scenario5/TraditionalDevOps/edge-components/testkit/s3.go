@@ -0,0 +1,269 @@
+package testkit
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+)
+
+// fakeObject is a stored S3 object
+type fakeObject struct {
+	data         []byte
+	metadata     map[string]string
+	contentType  string
+	etag         string
+	lastModified time.Time
+}
+
+// FakeS3 is an in-memory S3 with the same method set as *s3.Client for the
+// operations this repository uses; buckets are created on first write
+type FakeS3 struct {
+	buckets map[string]map[string]*fakeObject
+	calls   map[string]int
+	mutex   sync.Mutex
+}
+
+// NewFakeS3 creates an empty FakeS3
+func NewFakeS3() *FakeS3 {
+	return &FakeS3{
+		buckets: make(map[string]map[string]*fakeObject),
+		calls:   make(map[string]int),
+	}
+}
+
+// Object returns the body of a stored object
+func (f *FakeS3) Object(bucket, key string) ([]byte, bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	object, ok := f.buckets[bucket][key]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte{}, object.data...), true
+}
+
+// SetObject stores an object directly, bypassing PutObject
+func (f *FakeS3) SetObject(bucket, key string, data []byte) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.store(bucket, key, data, nil, "")
+}
+
+// Keys returns the sorted keys in a bucket with a prefix
+func (f *FakeS3) Keys(bucket, prefix string) []string {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	keys := make([]string, 0)
+	for key := range f.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Calls returns how many times an operation was invoked, e.g. Calls("PutObject")
+func (f *FakeS3) Calls(operation string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.calls[operation]
+}
+
+// PutObject implements the PutObject operation, honouring IfNoneMatch "*"
+func (f *FakeS3) PutObject(ctx context.Context, input *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var data []byte
+	if input.Body != nil {
+		read, err := io.ReadAll(input.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read body: %w", err)
+		}
+		data = read
+	}
+
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["PutObject"]++
+
+	bucket, key := aws.ToString(input.Bucket), aws.ToString(input.Key)
+	if aws.ToString(input.IfNoneMatch) == "*" {
+		if _, exists := f.buckets[bucket][key]; exists {
+			return nil, &smithy.GenericAPIError{Code: "PreconditionFailed", Message: "At least one of the pre-conditions you specified did not hold"}
+		}
+	}
+
+	object := f.store(bucket, key, data, input.Metadata, aws.ToString(input.ContentType))
+
+	return &s3.PutObjectOutput{ETag: aws.String(object.etag)}, nil
+}
+
+// GetObject implements the GetObject operation
+func (f *FakeS3) GetObject(ctx context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["GetObject"]++
+
+	object, ok := f.buckets[aws.ToString(input.Bucket)][aws.ToString(input.Key)]
+	if !ok {
+		return nil, &types.NoSuchKey{Message: aws.String("The specified key does not exist.")}
+	}
+
+	return &s3.GetObjectOutput{
+		Body:          io.NopCloser(bytes.NewReader(append([]byte{}, object.data...))),
+		ContentLength: aws.Int64(int64(len(object.data))),
+		ContentType:   aws.String(object.contentType),
+		ETag:          aws.String(object.etag),
+		LastModified:  aws.Time(object.lastModified),
+		Metadata:      copyMetadata(object.metadata),
+	}, nil
+}
+
+// HeadObject implements the HeadObject operation
+func (f *FakeS3) HeadObject(ctx context.Context, input *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["HeadObject"]++
+
+	object, ok := f.buckets[aws.ToString(input.Bucket)][aws.ToString(input.Key)]
+	if !ok {
+		return nil, &types.NotFound{Message: aws.String("Not Found")}
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength: aws.Int64(int64(len(object.data))),
+		ContentType:   aws.String(object.contentType),
+		ETag:          aws.String(object.etag),
+		LastModified:  aws.Time(object.lastModified),
+		Metadata:      copyMetadata(object.metadata),
+	}, nil
+}
+
+// DeleteObject implements the DeleteObject operation
+func (f *FakeS3) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, _ ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["DeleteObject"]++
+
+	delete(f.buckets[aws.ToString(input.Bucket)], aws.ToString(input.Key))
+
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+// ListObjectsV2 implements s3.ListObjectsV2APIClient, including Delimiter and continuation tokens
+func (f *FakeS3) ListObjectsV2(ctx context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.calls["ListObjectsV2"]++
+
+	bucket := aws.ToString(input.Bucket)
+	prefix := aws.ToString(input.Prefix)
+	delimiter := aws.ToString(input.Delimiter)
+
+	keys := make([]string, 0)
+	for key := range f.buckets[bucket] {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	maxKeys := 1000
+	if input.MaxKeys != nil && *input.MaxKeys > 0 {
+		maxKeys = int(*input.MaxKeys)
+	}
+
+	after := aws.ToString(input.ContinuationToken)
+	if after == "" {
+		after = aws.ToString(input.StartAfter)
+	}
+
+	output := &s3.ListObjectsV2Output{
+		Name:   input.Bucket,
+		Prefix: input.Prefix,
+	}
+	seenPrefixes := make(map[string]bool)
+	count := 0
+
+	for _, key := range keys {
+		if after != "" && key <= after {
+			continue
+		}
+
+		if count == maxKeys {
+			output.IsTruncated = aws.Bool(true)
+			output.NextContinuationToken = aws.String(after)
+			break
+		}
+
+		if delimiter != "" {
+			if i := strings.Index(key[len(prefix):], delimiter); i >= 0 {
+				common := key[:len(prefix)+i+len(delimiter)]
+				if !seenPrefixes[common] {
+					seenPrefixes[common] = true
+					output.CommonPrefixes = append(output.CommonPrefixes, types.CommonPrefix{Prefix: aws.String(common)})
+					count++
+				}
+				after = key
+				continue
+			}
+		}
+
+		object := f.buckets[bucket][key]
+		output.Contents = append(output.Contents, types.Object{
+			Key:          aws.String(key),
+			Size:         aws.Int64(int64(len(object.data))),
+			ETag:         aws.String(object.etag),
+			LastModified: aws.Time(object.lastModified),
+		})
+		count++
+		after = key
+	}
+
+	output.KeyCount = aws.Int32(int32(count))
+	if output.IsTruncated == nil {
+		output.IsTruncated = aws.Bool(false)
+	}
+
+	return output, nil
+}
+
+// store writes an object; the caller holds the mutex
+func (f *FakeS3) store(bucket, key string, data []byte, metadata map[string]string, contentType string) *fakeObject {
+	if f.buckets[bucket] == nil {
+		f.buckets[bucket] = make(map[string]*fakeObject)
+	}
+
+	object := &fakeObject{
+		data:         append([]byte{}, data...),
+		metadata:     copyMetadata(metadata),
+		contentType:  contentType,
+		etag:         fmt.Sprintf("\"%x\"", md5.Sum(data)),
+		lastModified: time.Now().UTC(),
+	}
+	f.buckets[bucket][key] = object
+
+	return object
+}
+
+// Helper functions
+
+func copyMetadata(metadata map[string]string) map[string]string {
+	copied := make(map[string]string, len(metadata))
+	for k, v := range metadata {
+		copied[k] = v
+	}
+	return copied
+}
@@ -0,0 +1,154 @@
+package transfer
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/adil-faiyaz98/devops-scenarios/Scenario5/TraditionalDevOps/edge-components/backoff"
+)
+
+// ErrRangesUnsupported is returned by ProbeSize when the server doesn't
+// serve byte ranges, so the object must be downloaded as one stream
+var ErrRangesUnsupported = errors.New("server does not support range requests")
+
+// ProbeSize returns the size of an HTTP object by requesting its first byte.
+// A ranged GET is used instead of HEAD because presigned URLs are only
+// signed for GET.
+func ProbeSize(ctx context.Context, client *http.Client, url string) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", "bytes=0-0")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to probe %s: %w", redact(url), err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, ErrRangesUnsupported
+	}
+
+	// Content-Range: bytes 0-0/<size>
+	_, total, ok := strings.Cut(resp.Header.Get("Content-Range"), "/")
+	size, err := strconv.ParseInt(total, 10, 64)
+	if !ok || err != nil || size <= 0 {
+		return 0, ErrRangesUnsupported
+	}
+
+	return size, nil
+}
+
+// DownloadRanges downloads an HTTP object of the given size as PartSize byte
+// ranges, Concurrency at a time, writing each at its offset. Each range is
+// retried on its own under policy, so a dropped connection on a satellite or
+// cellular link costs one part rather than the whole download. It returns the
+// bytes written, including those of retried attempts.
+func DownloadRanges(ctx context.Context, client *http.Client, url string, size int64, w io.WriterAt, o Options, policy backoff.Policy) (int64, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	offsets := make(chan int64)
+	go func() {
+		defer close(offsets)
+		for offset := int64(0); offset < size; offset += o.PartSize {
+			select {
+			case offsets <- offset:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var written atomic.Int64
+	var firstErr error
+	var errMutex sync.Mutex
+	var wg sync.WaitGroup
+	for i := 0; i < o.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for offset := range offsets {
+				end := offset + o.PartSize - 1
+				if end >= size {
+					end = size - 1
+				}
+
+				err := backoff.Retry(ctx, policy, func() error {
+					n, err := fetchRange(ctx, client, url, offset, end, w)
+					written.Add(n)
+					return err
+				})
+				if err != nil {
+					errMutex.Lock()
+					if firstErr == nil {
+						firstErr = fmt.Errorf("failed to download bytes %d-%d: %w", offset, end, err)
+					}
+					errMutex.Unlock()
+					cancel()
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return written.Load(), firstErr
+}
+
+// fetchRange downloads bytes start to end, inclusive, into w at start
+func fetchRange(ctx context.Context, client *http.Client, url string, start, end int64, w io.WriterAt) (int64, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", start, end))
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return 0, fmt.Errorf("unexpected status for range request: %s", resp.Status)
+	}
+
+	n, err := io.Copy(&offsetWriter{writer: w, offset: start}, io.LimitReader(resp.Body, end-start+1))
+	if err != nil {
+		return n, err
+	}
+	if n != end-start+1 {
+		return n, fmt.Errorf("short range: got %d of %d bytes", n, end-start+1)
+	}
+	return n, nil
+}
+
+// Helper functions
+
+// offsetWriter writes sequentially into a WriterAt from an offset
+type offsetWriter struct {
+	writer io.WriterAt
+	offset int64
+}
+
+func (w *offsetWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.WriteAt(p, w.offset)
+	w.offset += int64(n)
+	return n, err
+}
+
+// redact drops the query string, which carries a presigned URL's signature
+func redact(url string) string {
+	return strings.SplitN(url, "?", 2)[0]
+}
@@ -0,0 +1,54 @@
+package transfer
+
+import (
+	"errors"
+
+	"github.com/aws/aws-sdk-go-v2/feature/s3/manager"
+)
+
+// minPartSize is the smallest part S3 accepts in a multipart upload
+const minPartSize = manager.MinUploadPartSize
+
+// Options tune the S3 transfer manager, which moves large objects as
+// concurrent ranged downloads and multipart uploads instead of one stream
+type Options struct {
+	PartSize    int64 // bytes per part; objects up to this size take a single request
+	Concurrency int   // parts in flight per object
+}
+
+// Default returns the options used when transfers are enabled without
+// tuning: 16 MiB parts, 5 at a time
+func Default() Options {
+	return Options{
+		PartSize:    16 * 1024 * 1024,
+		Concurrency: 5,
+	}
+}
+
+// Validate checks that the options are usable
+func (o Options) Validate() error {
+	switch {
+	case o.PartSize < minPartSize:
+		return errors.New("transfer part size must be at least 5 MiB")
+	case o.Concurrency < 1:
+		return errors.New("transfer concurrency must be at least 1")
+	}
+	return nil
+}
+
+// NewDownloader creates a transfer manager downloader for client
+func NewDownloader(client manager.DownloadAPIClient, o Options) *manager.Downloader {
+	return manager.NewDownloader(client, func(d *manager.Downloader) {
+		d.PartSize = o.PartSize
+		d.Concurrency = o.Concurrency
+	})
+}
+
+// NewUploader creates a transfer manager uploader for client; it needs the
+// multipart upload calls as well as PutObject, e.g. a *s3.Client
+func NewUploader(client manager.UploadAPIClient, o Options) *manager.Uploader {
+	return manager.NewUploader(client, func(u *manager.Uploader) {
+		u.PartSize = o.PartSize
+		u.Concurrency = o.Concurrency
+	})
+}
@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Blue/green slots; exactly one serves traffic at a time
+const (
+	SlotBlue  = "blue"
+	SlotGreen = "green"
+)
+
+// BlueGreenHandler is an UpdateHandler that runs a new version of a
+// containerized service beside the live one, verifies its health, then
+// flips an in-process TCP switch to it. The previous slot keeps running
+// until the next update, so switching back is immediate.
+type BlueGreenHandler struct {
+	basePath      string
+	runtime       string
+	name          string
+	image         string
+	containerPort int
+	slotPorts     map[string]int
+	runArgs       []string
+	healthPath    string
+	startTimeout  time.Duration
+	verifyPeriod  time.Duration
+	activeSlot    string
+	previousSlot  string
+	staged        map[string]string // package path -> loaded image reference
+	target        atomic.Value      // backend address new connections are proxied to
+	shadow        atomic.Pointer[shadowSession]
+	shadowPeriod  time.Duration
+	shadowRate    float64
+	criteria      shadowCriteria
+	lastShadow    *ShadowResult
+	listener      net.Listener
+	httpClient    *http.Client
+	handlerMutex  sync.Mutex
+}
+
+// BlueGreenHandlerConfig contains configuration for the BlueGreenHandler
+type BlueGreenHandlerConfig struct {
+	BasePath      string
+	Runtime       string // docker-compatible CLI, docker by default
+	Name          string // containers are named <Name>-blue and <Name>-green
+	Image         string // repository the package's image is tagged under; tag is the version
+	ContainerPort int
+	BluePort      int // host ports the slots publish on 127.0.0.1
+	GreenPort     int
+	ListenAddr    string   // entrypoint clients connect to; proxied to the active slot
+	RunArgs       []string // extra arguments for the runtime's run command, e.g. --env-file
+	HealthPath    string   // HTTP path probed on the slot; a TCP connect when empty
+	StartTimeout  time.Duration
+	VerifyPeriod  time.Duration // health is re-checked this long after the switch
+
+	// ShadowPeriod dark-launches the new slot before the switch: a sample of
+	// live connections is mirrored to it and its responses discarded, and the
+	// switch only happens if its error rate and first-byte latency hold up
+	// against the live slot. Zero disables the shadow phase.
+	ShadowPeriod           time.Duration
+	ShadowSampleRate       float64 // fraction of connections mirrored; all when zero
+	ShadowMinConnections   int     // mirrored connections needed for a verdict, 20 by default
+	ShadowMaxErrorIncrease float64 // allowed error rate above live, 0.01 by default
+	ShadowMaxLatencyRatio  float64 // allowed first-byte latency relative to live, 1.5 by default
+}
+
+// NewBlueGreenHandler creates a new BlueGreenHandler and starts the switch on the active slot
+func NewBlueGreenHandler(config BlueGreenHandlerConfig) (*BlueGreenHandler, error) {
+	if err := os.MkdirAll(config.BasePath, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create blue/green directory: %w", err)
+	}
+
+	runtime := config.Runtime
+	if runtime == "" {
+		runtime = "docker"
+	}
+
+	startTimeout := config.StartTimeout
+	if startTimeout == 0 {
+		startTimeout = 2 * time.Minute
+	}
+
+	criteria := shadowCriteria{
+		minConnections:   config.ShadowMinConnections,
+		maxErrorIncrease: config.ShadowMaxErrorIncrease,
+		maxLatencyRatio:  config.ShadowMaxLatencyRatio,
+	}
+	if criteria.minConnections == 0 {
+		criteria.minConnections = 20
+	}
+	if criteria.maxErrorIncrease == 0 {
+		criteria.maxErrorIncrease = 0.01
+	}
+	if criteria.maxLatencyRatio == 0 {
+		criteria.maxLatencyRatio = 1.5
+	}
+
+	shadowRate := config.ShadowSampleRate
+	if shadowRate == 0 {
+		shadowRate = 1
+	}
+
+	bg := &BlueGreenHandler{
+		basePath:      config.BasePath,
+		runtime:       runtime,
+		name:          config.Name,
+		image:         config.Image,
+		containerPort: config.ContainerPort,
+		slotPorts:     map[string]int{SlotBlue: config.BluePort, SlotGreen: config.GreenPort},
+		runArgs:       config.RunArgs,
+		healthPath:    config.HealthPath,
+		startTimeout:  startTimeout,
+		verifyPeriod:  config.VerifyPeriod,
+		shadowPeriod:  config.ShadowPeriod,
+		shadowRate:    shadowRate,
+		criteria:      criteria,
+		staged:        make(map[string]string),
+		httpClient:    &http.Client{Timeout: 5 * time.Second},
+	}
+
+	// The active slot survives restarts so the switch comes back pointing at it
+	if data, err := os.ReadFile(bg.slotFile()); err == nil {
+		bg.activeSlot = strings.TrimSpace(string(data))
+		bg.previousSlot = otherSlot(bg.activeSlot)
+	}
+	if bg.activeSlot == "" {
+		bg.activeSlot = SlotBlue
+	}
+	bg.target.Store(bg.slotAddr(bg.activeSlot))
+
+	listener, err := net.Listen("tcp", config.ListenAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to listen on %s: %w", config.ListenAddr, err)
+	}
+	bg.listener = listener
+
+	go bg.serve()
+
+	return bg, nil
+}
+
+// ValidateUpdate loads the package's container image and checks it is tagged under the configured repository
+func (bg *BlueGreenHandler) ValidateUpdate(packagePath string) error {
+	out, err := bg.run("load", "-i", packagePath)
+	if err != nil {
+		return fmt.Errorf("failed to load container image: %w", err)
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		ref, ok := strings.CutPrefix(strings.TrimSpace(line), "Loaded image: ")
+		if ok && strings.HasPrefix(ref, bg.image+":") {
+			bg.handlerMutex.Lock()
+			bg.staged[packagePath] = ref
+			bg.handlerMutex.Unlock()
+			return nil
+		}
+	}
+
+	return fmt.Errorf("package does not contain an image tagged under %s", bg.image)
+}
+
+// HandleUpdate starts the version on the idle slot, verifies it, switches
+// traffic to it, and switches straight back if it fails after the switch
+func (bg *BlueGreenHandler) HandleUpdate(packagePath string, version string) error {
+	bg.handlerMutex.Lock()
+	defer bg.handlerMutex.Unlock()
+
+	image, ok := bg.staged[packagePath]
+	if !ok {
+		image = bg.image + ":" + version
+	}
+	delete(bg.staged, packagePath)
+
+	idle := otherSlot(bg.activeSlot)
+
+	// The idle slot may still hold the version before last
+	bg.removeSlot(idle)
+
+	args := []string{"run", "-d", "--name", bg.containerName(idle), "--restart", "unless-stopped",
+		"-p", fmt.Sprintf("127.0.0.1:%d:%d", bg.slotPorts[idle], bg.containerPort),
+		"--label", "edge.version=" + version}
+	args = append(args, bg.runArgs...)
+	args = append(args, image)
+
+	if _, err := bg.run(args...); err != nil {
+		bg.removeSlot(idle)
+		return fmt.Errorf("failed to start %s slot: %w", idle, err)
+	}
+
+	if err := bg.waitHealthy(idle, bg.startTimeout); err != nil {
+		bg.removeSlot(idle)
+		return fmt.Errorf("%s slot did not become healthy: %w", idle, err)
+	}
+
+	if bg.shadowPeriod > 0 {
+		result := bg.runShadow(idle)
+		log.Printf("Shadow of %s version %s on %s: %s (live %+v, shadow %+v)", bg.name, version, idle, result.Reason, result.Live, result.Shadow)
+		if !result.Passed {
+			bg.removeSlot(idle)
+			return fmt.Errorf("shadow comparison failed: %s", result.Reason)
+		}
+	}
+
+	previous := bg.activeSlot
+	if err := bg.switchTo(idle); err != nil {
+		bg.removeSlot(idle)
+		return err
+	}
+	bg.previousSlot = previous
+	log.Printf("Switched %s traffic from %s to %s (version %s)", bg.name, previous, idle, version)
+
+	if bg.verifyPeriod > 0 {
+		if err := bg.verify(idle); err != nil {
+			if rbErr := bg.rollback(); rbErr != nil {
+				log.Printf("Failed to switch %s back to %s: %v", bg.name, previous, rbErr)
+			}
+			return fmt.Errorf("%s slot failed after the switch: %w", idle, err)
+		}
+	}
+
+	return nil
+}
+
+// RollbackUpdate switches traffic back to the previous slot
+func (bg *BlueGreenHandler) RollbackUpdate() error {
+	bg.handlerMutex.Lock()
+	defer bg.handlerMutex.Unlock()
+	return bg.rollback()
+}
+
+// ActiveSlot returns the slot currently receiving traffic
+func (bg *BlueGreenHandler) ActiveSlot() string {
+	bg.handlerMutex.Lock()
+	defer bg.handlerMutex.Unlock()
+	return bg.activeSlot
+}
+
+// LastShadowResult returns the comparison from the most recent shadow phase, if any
+func (bg *BlueGreenHandler) LastShadowResult() *ShadowResult {
+	bg.handlerMutex.Lock()
+	defer bg.handlerMutex.Unlock()
+	return bg.lastShadow
+}
+
+// Close stops accepting connections on the entrypoint; the containers keep running
+func (bg *BlueGreenHandler) Close() error {
+	return bg.listener.Close()
+}
+
+// rollback flips back to the previous slot and removes the failed one
+func (bg *BlueGreenHandler) rollback() error {
+	if bg.previousSlot == "" {
+		return fmt.Errorf("no previous slot to switch back to")
+	}
+
+	failed := bg.activeSlot
+
+	// The previous container may have been stopped by a restart of the runtime
+	if _, err := bg.run("start", bg.containerName(bg.previousSlot)); err != nil {
+		return fmt.Errorf("failed to start %s slot: %w", bg.previousSlot, err)
+	}
+
+	if err := bg.switchTo(bg.previousSlot); err != nil {
+		return err
+	}
+	log.Printf("Switched %s traffic back from %s to %s", bg.name, failed, bg.previousSlot)
+
+	bg.previousSlot = ""
+	bg.removeSlot(failed)
+
+	return nil
+}
+
+// runShadow mirrors sampled live traffic to a slot for the shadow period and
+// compares how it fared against the live slot
+func (bg *BlueGreenHandler) runShadow(slot string) ShadowResult {
+	session := &shadowSession{addr: bg.slotAddr(slot), sampleRate: bg.shadowRate}
+
+	bg.shadow.Store(session)
+	time.Sleep(bg.shadowPeriod)
+	bg.shadow.Store(nil)
+
+	result := session.result(bg.criteria)
+	bg.lastShadow = &result
+	return result
+}
+
+// switchTo points new connections at a slot and records it as active
+func (bg *BlueGreenHandler) switchTo(slot string) error {
+	tmp := bg.slotFile() + ".tmp"
+	if err := os.WriteFile(tmp, []byte(slot+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to record active slot: %w", err)
+	}
+	if err := os.Rename(tmp, bg.slotFile()); err != nil {
+		return fmt.Errorf("failed to record active slot: %w", err)
+	}
+
+	bg.target.Store(bg.slotAddr(slot))
+	bg.activeSlot = slot
+
+	return nil
+}
+
+// waitHealthy polls a slot until it passes a health check or the timeout expires
+func (bg *BlueGreenHandler) waitHealthy(slot string, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+
+	for {
+		err := bg.checkSlot(slot)
+		if err == nil {
+			return nil
+		}
+		if time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(2 * time.Second)
+	}
+}
+
+// verify re-checks the active slot for the verify period after the switch
+func (bg *BlueGreenHandler) verify(slot string) error {
+	deadline := time.Now().Add(bg.verifyPeriod)
+
+	for time.Now().Before(deadline) {
+		time.Sleep(5 * time.Second)
+		if err := bg.checkSlot(slot); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkSlot probes the slot's health endpoint, or just connects when none is configured
+func (bg *BlueGreenHandler) checkSlot(slot string) error {
+	addr := bg.slotAddr(slot)
+
+	if bg.healthPath == "" {
+		conn, err := net.DialTimeout("tcp", addr, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		return conn.Close()
+	}
+
+	resp, err := bg.httpClient.Get("http://" + addr + bg.healthPath)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("health check returned %s", resp.Status)
+	}
+
+	return nil
+}
+
+// serve accepts entrypoint connections and proxies each to the slot active when it arrived
+func (bg *BlueGreenHandler) serve() {
+	for {
+		conn, err := bg.listener.Accept()
+		if err != nil {
+			if !errors.Is(err, net.ErrClosed) {
+				log.Printf("Blue/green entrypoint stopped: %v", err)
+			}
+			return
+		}
+
+		go bg.proxy(conn, bg.target.Load().(string), bg.shadow.Load())
+	}
+}
+
+// proxy copies a client connection to and from a backend; during a shadow
+// phase, sampled connections are also mirrored to the shadow slot
+func (bg *BlueGreenHandler) proxy(client net.Conn, backendAddr string, session *shadowSession) {
+	defer client.Close()
+
+	start := time.Now()
+	if session != nil && !session.sampled() {
+		session = nil
+	}
+
+	backend, err := net.DialTimeout("tcp", backendAddr, 5*time.Second)
+	if err != nil {
+		log.Printf("Failed to connect to %s backend %s: %v", bg.name, backendAddr, err)
+		if session != nil {
+			session.live.record(0, false, err)
+		}
+		return
+	}
+	defer backend.Close()
+
+	var upstream io.Writer = backend
+	closeMirror := func() {}
+	if session != nil {
+		var mirror io.Writer
+		mirror, closeMirror = session.mirror(start)
+		upstream = io.MultiWriter(backend, mirror)
+	}
+
+	response := &firstByteWriter{w: client, start: start}
+	var responseErr error
+	requestDone := make(chan struct{})
+	responseDone := make(chan struct{})
+	go func() {
+		io.Copy(upstream, client)
+		closeMirror()
+		close(requestDone)
+	}()
+	go func() {
+		_, responseErr = io.Copy(response, backend)
+		close(responseDone)
+	}()
+
+	// Either side finishing ends the connection
+	clientClosed := false
+	select {
+	case <-requestDone:
+		clientClosed = true
+	case <-responseDone:
+	}
+	client.Close()
+	backend.Close()
+	<-requestDone
+	<-responseDone
+
+	if session != nil {
+		if clientClosed {
+			// The response copy was cut short by our own close
+			responseErr = nil
+		}
+		session.live.record(response.first, response.wrote, responseErr)
+	}
+}
+
+// removeSlot force-removes a slot's container, ignoring a missing one
+func (bg *BlueGreenHandler) removeSlot(slot string) {
+	if _, err := bg.run("rm", "-f", bg.containerName(slot)); err != nil && !strings.Contains(err.Error(), "No such container") {
+		log.Printf("Failed to remove %s slot: %v", slot, err)
+	}
+}
+
+// run executes a container runtime command
+func (bg *BlueGreenHandler) run(args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), bg.startTimeout+time.Minute)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, bg.runtime, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", bg.runtime, args[0], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+func (bg *BlueGreenHandler) containerName(slot string) string {
+	return bg.name + "-" + slot
+}
+
+func (bg *BlueGreenHandler) slotAddr(slot string) string {
+	return net.JoinHostPort("127.0.0.1", strconv.Itoa(bg.slotPorts[slot]))
+}
+
+func (bg *BlueGreenHandler) slotFile() string {
+	return filepath.Join(bg.basePath, "active-slot")
+}
+
+// Helper functions
+
+func otherSlot(slot string) string {
+	if slot == SlotBlue {
+		return SlotGreen
+	}
+	return SlotBlue
+}
@@ -0,0 +1,227 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// defaultK3sKubeconfig is where K3s writes its admin kubeconfig
+const defaultK3sKubeconfig = "/etc/rancher/k3s/k3s.yaml"
+
+// K3sHandler is an UpdateHandler that applies Kubernetes manifests or a Helm chart to a local K3s cluster
+type K3sHandler struct {
+	basePath       string
+	kubeconfig     string
+	namespace      string
+	releaseName    string
+	rolloutTimeout time.Duration
+	currentDir     string
+	previousDir    string
+	handlerMutex   sync.Mutex
+}
+
+// K3sHandlerConfig contains configuration for the K3sHandler
+type K3sHandlerConfig struct {
+	BasePath       string
+	Kubeconfig     string
+	Namespace      string
+	ReleaseName    string // Helm release name, used when the package contains a Chart.yaml
+	RolloutTimeout time.Duration
+}
+
+// NewK3sHandler creates a new K3sHandler
+func NewK3sHandler(config K3sHandlerConfig) (*K3sHandler, error) {
+	if err := os.MkdirAll(filepath.Join(config.BasePath, "versions"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create manifest directory: %w", err)
+	}
+
+	kubeconfig := config.Kubeconfig
+	if kubeconfig == "" {
+		kubeconfig = defaultK3sKubeconfig
+	}
+
+	namespace := config.Namespace
+	if namespace == "" {
+		namespace = "default"
+	}
+
+	kh := &K3sHandler{
+		basePath:       config.BasePath,
+		kubeconfig:     kubeconfig,
+		namespace:      namespace,
+		releaseName:    config.ReleaseName,
+		rolloutTimeout: config.RolloutTimeout,
+	}
+
+	// The last applied bundle survives restarts so we can roll back to it
+	if target, err := os.Readlink(filepath.Join(kh.basePath, "current")); err == nil {
+		kh.currentDir = target
+	}
+
+	return kh, nil
+}
+
+// ValidateUpdate extracts the bundle and performs a server-side dry run
+func (kh *K3sHandler) ValidateUpdate(packagePath string) error {
+	stagingDir := kh.stagingDir(packagePath)
+	os.RemoveAll(stagingDir)
+
+	if err := extractTarGz(packagePath, stagingDir); err != nil {
+		return fmt.Errorf("failed to extract manifest bundle: %w", err)
+	}
+
+	var err error
+	if isHelmChart(stagingDir) {
+		_, err = kh.helm("lint", stagingDir)
+	} else {
+		_, err = kh.kubectl("apply", "--dry-run=server", "-R", "-f", stagingDir)
+	}
+
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return fmt.Errorf("manifest bundle validation failed: %w", err)
+	}
+
+	return nil
+}
+
+// HandleUpdate applies the bundle and waits for its workloads to roll out
+func (kh *K3sHandler) HandleUpdate(packagePath string, version string) error {
+	kh.handlerMutex.Lock()
+	defer kh.handlerMutex.Unlock()
+
+	versionDir := filepath.Join(kh.basePath, "versions", version)
+	os.RemoveAll(versionDir)
+	if err := os.Rename(kh.stagingDir(packagePath), versionDir); err != nil {
+		return fmt.Errorf("failed to move manifest bundle into place: %w", err)
+	}
+
+	kh.previousDir = kh.currentDir
+
+	if err := kh.apply(versionDir); err != nil {
+		if rbErr := kh.rollback(); rbErr != nil {
+			log.Printf("Failed to roll back manifests: %v", rbErr)
+		}
+		return err
+	}
+
+	kh.currentDir = versionDir
+	if _, err := swapSymlink(filepath.Join(kh.basePath, "current"), versionDir); err != nil {
+		log.Printf("Failed to record current manifest bundle: %v", err)
+	}
+
+	return nil
+}
+
+// RollbackUpdate re-applies the previously applied bundle
+func (kh *K3sHandler) RollbackUpdate() error {
+	kh.handlerMutex.Lock()
+	defer kh.handlerMutex.Unlock()
+	return kh.rollback()
+}
+
+// rollback re-applies the previous bundle, or rolls back the Helm release
+func (kh *K3sHandler) rollback() error {
+	if kh.previousDir == "" {
+		return fmt.Errorf("no previous manifest bundle to roll back to")
+	}
+
+	if isHelmChart(kh.previousDir) {
+		if _, err := kh.helm("rollback", kh.releaseName, "--namespace", kh.namespace, "--wait",
+			"--timeout", kh.rolloutTimeout.String()); err != nil {
+			return fmt.Errorf("helm rollback failed: %w", err)
+		}
+	} else if err := kh.apply(kh.previousDir); err != nil {
+		return fmt.Errorf("failed to re-apply previous manifests: %w", err)
+	}
+
+	kh.currentDir = kh.previousDir
+	if _, err := swapSymlink(filepath.Join(kh.basePath, "current"), kh.previousDir); err != nil {
+		log.Printf("Failed to record current manifest bundle: %v", err)
+	}
+
+	return nil
+}
+
+// apply applies a bundle directory and waits for rollout
+func (kh *K3sHandler) apply(bundleDir string) error {
+	if isHelmChart(bundleDir) {
+		if _, err := kh.helm("upgrade", "--install", kh.releaseName, bundleDir, "--namespace", kh.namespace,
+			"--wait", "--timeout", kh.rolloutTimeout.String()); err != nil {
+			return fmt.Errorf("helm upgrade failed: %w", err)
+		}
+		return nil
+	}
+
+	out, err := kh.kubectl("apply", "--namespace", kh.namespace, "-R", "-f", bundleDir, "-o", "name")
+	if err != nil {
+		return fmt.Errorf("kubectl apply failed: %w", err)
+	}
+
+	// Wait for every workload in the bundle to finish rolling out
+	for _, resource := range strings.Fields(string(out)) {
+		if !isWorkload(resource) {
+			continue
+		}
+
+		if _, err := kh.kubectl("rollout", "status", resource, "--namespace", kh.namespace,
+			"--timeout", kh.rolloutTimeout.String()); err != nil {
+			return fmt.Errorf("rollout of %s did not complete: %w", resource, err)
+		}
+	}
+
+	return nil
+}
+
+// kubectl runs a kubectl command against the K3s cluster
+func (kh *K3sHandler) kubectl(args ...string) ([]byte, error) {
+	return kh.run("kubectl", append([]string{"--kubeconfig", kh.kubeconfig}, args...)...)
+}
+
+// helm runs a helm command against the K3s cluster
+func (kh *K3sHandler) helm(args ...string) ([]byte, error) {
+	return kh.run("helm", append([]string{"--kubeconfig", kh.kubeconfig}, args...)...)
+}
+
+// run executes a command, bounding it by the rollout timeout plus a margin
+func (kh *K3sHandler) run(name string, args ...string) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), kh.rolloutTimeout+time.Minute)
+	defer cancel()
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s %s: %w: %s", name, args[2], err, strings.TrimSpace(stderr.String()))
+	}
+
+	return out, nil
+}
+
+// stagingDir returns the staging directory for a package
+func (kh *K3sHandler) stagingDir(packagePath string) string {
+	return filepath.Join(kh.basePath, "staging", filepath.Base(packagePath))
+}
+
+// Helper functions
+
+func isHelmChart(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, "Chart.yaml"))
+	return err == nil
+}
+
+func isWorkload(resource string) bool {
+	return strings.HasPrefix(resource, "deployment.apps/") ||
+		strings.HasPrefix(resource, "statefulset.apps/") ||
+		strings.HasPrefix(resource, "daemonset.apps/")
+}
@@ -0,0 +1,314 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// modelManifestFile is the manifest every model package must contain at its root
+const modelManifestFile = "model.json"
+
+// ModelManifest describes the contents of a model package
+type ModelManifest struct {
+	Name      string            `json:"name"`
+	Version   string            `json:"version"`
+	Format    string            `json:"format"` // onnx, tflite, savedmodel, torchscript
+	Files     map[string]string `json:"files"`  // relative path -> sha256
+	Signature string            `json:"signature"`
+}
+
+// GoldenCase is a known input with its expected model output
+type GoldenCase struct {
+	Name      string    `json:"name"`
+	Input     []byte    `json:"input"`
+	Expected  []float64 `json:"expected"`
+	Tolerance float64   `json:"tolerance"`
+}
+
+// InferenceRunner is an interface for running inference against a model directory
+type InferenceRunner interface {
+	// Infer runs a single inference and returns the model output
+	Infer(modelDir string, input []byte) ([]float64, error)
+}
+
+// ModelHandler is an UpdateHandler that deploys ML models with signature and inference validation
+type ModelHandler struct {
+	basePath       string
+	servingLink    string
+	allowedFormats map[string]bool
+	publicKey      ed25519.PublicKey
+	runner         InferenceRunner
+	goldenCases    []GoldenCase
+	minAccuracy    float64
+	maxLatency     time.Duration
+	previousTarget string
+	handlerMutex   sync.Mutex
+}
+
+// ModelHandlerConfig contains configuration for the ModelHandler
+type ModelHandlerConfig struct {
+	BasePath       string
+	ServingLink    string
+	AllowedFormats []string
+	PublicKey      ed25519.PublicKey
+	Runner         InferenceRunner
+	GoldenCases    []GoldenCase
+	MinAccuracy    float64
+	MaxLatency     time.Duration
+}
+
+// ModelCheckResult summarizes a smoke test run against golden inputs
+type ModelCheckResult struct {
+	Passed     int           `json:"passed"`
+	Total      int           `json:"total"`
+	Accuracy   float64       `json:"accuracy"`
+	P95Latency time.Duration `json:"p95Latency"`
+}
+
+// CommandRunner runs inference by executing a command with the model directory as its
+// last argument, the input on stdin and a JSON array of outputs on stdout
+type CommandRunner struct {
+	Command string
+	Args    []string
+	Timeout time.Duration
+}
+
+// Infer executes the inference command
+func (cr *CommandRunner) Infer(modelDir string, input []byte) ([]float64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), cr.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, cr.Command, append(cr.Args, modelDir)...)
+	cmd.Stdin = bytes.NewReader(input)
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("inference command failed: %w", err)
+	}
+
+	var output []float64
+	if err := json.Unmarshal(out, &output); err != nil {
+		return nil, fmt.Errorf("failed to parse inference output: %w", err)
+	}
+
+	return output, nil
+}
+
+// NewModelHandler creates a new ModelHandler
+func NewModelHandler(config ModelHandlerConfig) (*ModelHandler, error) {
+	if err := os.MkdirAll(filepath.Join(config.BasePath, "versions"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create model directory: %w", err)
+	}
+
+	allowed := make(map[string]bool)
+	for _, format := range config.AllowedFormats {
+		allowed[format] = true
+	}
+
+	return &ModelHandler{
+		basePath:       config.BasePath,
+		servingLink:    config.ServingLink,
+		allowedFormats: allowed,
+		publicKey:      config.PublicKey,
+		runner:         config.Runner,
+		goldenCases:    config.GoldenCases,
+		minAccuracy:    config.MinAccuracy,
+		maxLatency:     config.MaxLatency,
+	}, nil
+}
+
+// ValidateUpdate extracts the package to staging and verifies format, signature and inference results
+func (mh *ModelHandler) ValidateUpdate(packagePath string) error {
+	stagingDir := mh.stagingDir(packagePath)
+	os.RemoveAll(stagingDir)
+
+	if err := extractTarGz(packagePath, stagingDir); err != nil {
+		return fmt.Errorf("failed to extract model package: %w", err)
+	}
+
+	if _, err := mh.verifyModel(stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		return err
+	}
+
+	if _, err := mh.smokeTest(stagingDir); err != nil {
+		os.RemoveAll(stagingDir)
+		return err
+	}
+
+	return nil
+}
+
+// HandleUpdate moves the validated model into place and atomically swaps the serving directory
+func (mh *ModelHandler) HandleUpdate(packagePath string, version string) error {
+	mh.handlerMutex.Lock()
+	defer mh.handlerMutex.Unlock()
+
+	stagingDir := mh.stagingDir(packagePath)
+	versionDir := filepath.Join(mh.basePath, "versions", version)
+
+	os.RemoveAll(versionDir)
+	if err := os.Rename(stagingDir, versionDir); err != nil {
+		return fmt.Errorf("failed to move model into place: %w", err)
+	}
+
+	previous, err := swapSymlink(mh.servingLink, versionDir)
+	if err != nil {
+		return err
+	}
+	mh.previousTarget = previous
+
+	// Re-check through the serving path so we validate what clients will actually load
+	if _, err := mh.smokeTest(mh.servingLink); err != nil {
+		if rbErr := mh.rollback(); rbErr != nil {
+			log.Printf("Failed to roll back model after failed check: %v", rbErr)
+		}
+		return fmt.Errorf("post-swap model check failed: %w", err)
+	}
+
+	return nil
+}
+
+// RollbackUpdate points the serving directory back at the previous model
+func (mh *ModelHandler) RollbackUpdate() error {
+	mh.handlerMutex.Lock()
+	defer mh.handlerMutex.Unlock()
+	return mh.rollback()
+}
+
+// rollback restores the previous serving target
+func (mh *ModelHandler) rollback() error {
+	if mh.previousTarget == "" {
+		return fmt.Errorf("no previous model to roll back to")
+	}
+
+	if _, err := swapSymlink(mh.servingLink, mh.previousTarget); err != nil {
+		return fmt.Errorf("failed to restore previous model: %w", err)
+	}
+
+	return nil
+}
+
+// verifyModel checks the manifest format, file hashes and signature
+func (mh *ModelHandler) verifyModel(modelDir string) (*ModelManifest, error) {
+	data, err := ioutil.ReadFile(filepath.Join(modelDir, modelManifestFile))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read model manifest: %w", err)
+	}
+
+	var manifest ModelManifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("failed to parse model manifest: %w", err)
+	}
+
+	if len(mh.allowedFormats) > 0 && !mh.allowedFormats[manifest.Format] {
+		return nil, fmt.Errorf("model format %q is not allowed", manifest.Format)
+	}
+
+	if len(manifest.Files) == 0 {
+		return nil, fmt.Errorf("model manifest lists no files")
+	}
+
+	for name, expected := range manifest.Files {
+		actual, err := fileSHA256(filepath.Join(modelDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to hash model file %s: %w", name, err)
+		}
+		if actual != expected {
+			return nil, fmt.Errorf("model file %s hash mismatch: expected %s, got %s", name, expected, actual)
+		}
+	}
+
+	if mh.publicKey != nil {
+		signature, err := base64.StdEncoding.DecodeString(manifest.Signature)
+		if err != nil {
+			return nil, fmt.Errorf("failed to decode model signature: %w", err)
+		}
+
+		if !ed25519.Verify(mh.publicKey, manifestDigest(&manifest), signature) {
+			return nil, fmt.Errorf("model signature verification failed")
+		}
+	}
+
+	return &manifest, nil
+}
+
+// smokeTest runs the golden inputs through the model and checks accuracy and latency
+func (mh *ModelHandler) smokeTest(modelDir string) (*ModelCheckResult, error) {
+	result := &ModelCheckResult{Total: len(mh.goldenCases)}
+	if mh.runner == nil || len(mh.goldenCases) == 0 {
+		return result, nil
+	}
+
+	latencies := make([]time.Duration, 0, len(mh.goldenCases))
+	for _, golden := range mh.goldenCases {
+		start := time.Now()
+		output, err := mh.runner.Infer(modelDir, golden.Input)
+		latencies = append(latencies, time.Since(start))
+
+		if err != nil {
+			log.Printf("Inference failed for golden case %s: %v", golden.Name, err)
+			continue
+		}
+
+		if withinTolerance(output, golden.Expected, golden.Tolerance) {
+			result.Passed++
+		}
+	}
+
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	result.P95Latency = latencies[int(math.Ceil(0.95*float64(len(latencies))))-1]
+	result.Accuracy = float64(result.Passed) / float64(result.Total)
+
+	if result.Accuracy < mh.minAccuracy {
+		return result, fmt.Errorf("model accuracy %.3f below minimum %.3f", result.Accuracy, mh.minAccuracy)
+	}
+
+	if mh.maxLatency > 0 && result.P95Latency > mh.maxLatency {
+		return result, fmt.Errorf("model p95 latency %s exceeds maximum %s", result.P95Latency, mh.maxLatency)
+	}
+
+	return result, nil
+}
+
+// stagingDir returns the staging directory for a package
+func (mh *ModelHandler) stagingDir(packagePath string) string {
+	return filepath.Join(mh.basePath, "staging", filepath.Base(packagePath))
+}
+
+// Helper functions
+
+// manifestDigest returns the bytes covered by the manifest signature
+func manifestDigest(manifest *ModelManifest) []byte {
+	unsigned := *manifest
+	unsigned.Signature = ""
+	data, _ := json.Marshal(unsigned)
+	return data
+}
+
+func withinTolerance(actual, expected []float64, tolerance float64) bool {
+	if len(actual) != len(expected) {
+		return false
+	}
+
+	for i := range actual {
+		if math.Abs(actual[i]-expected[i]) > tolerance {
+			return false
+		}
+	}
+
+	return true
+}
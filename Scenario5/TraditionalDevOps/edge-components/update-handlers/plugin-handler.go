@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Commands of the plugin protocol, passed as the plugin's first argument
+const (
+	PluginValidate = "validate"
+	PluginApply    = "apply"
+	PluginRollback = "rollback"
+)
+
+// PluginRequest is written to a plugin's stdin as JSON
+type PluginRequest struct {
+	Command     string `json:"command"`
+	PackagePath string `json:"packagePath,omitempty"` // validate and apply
+	Version     string `json:"version,omitempty"`     // apply
+	StateDir    string `json:"stateDir"`              // the plugin's own directory, kept across calls
+}
+
+// PluginResponse is what a plugin may print to stdout as JSON. Without it,
+// exit code 0 means success and any other code failure; with it, OK decides.
+type PluginResponse struct {
+	OK      *bool  `json:"ok"`
+	Message string `json:"message,omitempty"`
+}
+
+// PluginHandler is an UpdateHandler that runs an out-of-process plugin, so
+// handlers can be written in any language and run in a sandbox: a clean
+// environment, their own state directory, a timeout and, on Linux, another
+// user and process group.
+type PluginHandler struct {
+	name         string
+	path         string
+	args         []string
+	env          []string
+	stateDir     string
+	timeout      time.Duration
+	uid          int
+	gid          int
+	handlerMutex sync.Mutex
+}
+
+// PluginHandlerConfig contains configuration for the PluginHandler
+type PluginHandlerConfig struct {
+	Path     string
+	Name     string        // for errors and logs; defaults to the executable's name
+	Args     []string      // passed after the command
+	Env      []string      // KEY=value; the plugin sees only these, PATH, HOME and TMPDIR
+	StateDir string        // created 0700; HOME and the working directory of the plugin
+	Timeout  time.Duration // per call, 10 minutes by default
+	UID      int           // run as this user and group on Linux; 0 keeps the agent's
+	GID      int
+}
+
+// NewPluginHandler creates a new PluginHandler
+func NewPluginHandler(config PluginHandlerConfig) (*PluginHandler, error) {
+	info, err := os.Stat(config.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat plugin: %w", err)
+	}
+	if !info.Mode().IsRegular() || info.Mode().Perm()&0111 == 0 {
+		return nil, fmt.Errorf("plugin %s is not an executable file", config.Path)
+	}
+
+	if config.StateDir == "" {
+		return nil, fmt.Errorf("a plugin state directory is required")
+	}
+	if err := os.MkdirAll(filepath.Join(config.StateDir, "tmp"), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create plugin state directory: %w", err)
+	}
+	if config.UID != 0 {
+		if err := chownTree(config.StateDir, config.UID, config.GID); err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Name == "" {
+		config.Name = filepath.Base(config.Path)
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 10 * time.Minute
+	}
+
+	return &PluginHandler{
+		name:     config.Name,
+		path:     config.Path,
+		args:     config.Args,
+		env:      config.Env,
+		stateDir: config.StateDir,
+		timeout:  config.Timeout,
+		uid:      config.UID,
+		gid:      config.GID,
+	}, nil
+}
+
+// ValidateUpdate asks the plugin to validate a package
+func (h *PluginHandler) ValidateUpdate(packagePath string) error {
+	return h.call(PluginRequest{Command: PluginValidate, PackagePath: packagePath})
+}
+
+// HandleUpdate asks the plugin to apply a package
+func (h *PluginHandler) HandleUpdate(packagePath string, version string) error {
+	return h.call(PluginRequest{Command: PluginApply, PackagePath: packagePath, Version: version})
+}
+
+// RollbackUpdate asks the plugin to roll back its last update
+func (h *PluginHandler) RollbackUpdate() error {
+	return h.call(PluginRequest{Command: PluginRollback})
+}
+
+// call runs the plugin for one command and interprets its response
+func (h *PluginHandler) call(request PluginRequest) error {
+	h.handlerMutex.Lock()
+	defer h.handlerMutex.Unlock()
+
+	request.StateDir = h.stateDir
+	input, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to encode plugin request: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), h.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(h.path, append([]string{request.Command}, h.args...)...)
+	cmd.Dir = h.stateDir
+	cmd.Env = append([]string{
+		"PATH=" + os.Getenv("PATH"),
+		"HOME=" + h.stateDir,
+		"TMPDIR=" + filepath.Join(h.stateDir, "tmp"),
+	}, h.env...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &limitedWriter{buffer: &stdout, limit: 64 * 1024}
+	cmd.Stderr = &limitedWriter{buffer: &stderr, limit: 64 * 1024}
+	if err := sandbox(cmd, h.uid, h.gid); err != nil {
+		return fmt.Errorf("plugin %s: %w", h.name, err)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("failed to start plugin %s: %w", h.name, err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	var runErr error
+	select {
+	case runErr = <-done:
+	case <-ctx.Done():
+		// Kill whatever the plugin started too, so nothing outlives the call
+		killSandbox(cmd)
+		<-done
+		return fmt.Errorf("plugin %s %s timed out after %s", h.name, request.Command, h.timeout)
+	}
+
+	ok, message := runErr == nil, strings.TrimSpace(stderr.String())
+	var response PluginResponse
+	if out := bytes.TrimSpace(stdout.Bytes()); len(out) > 0 && json.Unmarshal(out, &response) == nil {
+		if response.OK != nil {
+			ok = *response.OK
+		}
+		if response.Message != "" {
+			message = response.Message
+		}
+	}
+
+	if !ok {
+		if message == "" && runErr != nil {
+			message = runErr.Error()
+		}
+		return fmt.Errorf("plugin %s %s failed: %s", h.name, request.Command, message)
+	}
+	return nil
+}
+
+// Helper functions
+
+// chownTree hands a directory tree to the plugin's user
+func chownTree(root string, uid, gid int) error {
+	err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		return os.Lchown(path, uid, gid)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to hand plugin state directory to uid %d: %w", uid, err)
+	}
+	return nil
+}
+
+// limitedWriter keeps the first limit bytes written and discards the rest;
+// it reports every write as complete, so a chatty plugin isn't failed with
+// a short write
+type limitedWriter struct {
+	buffer *bytes.Buffer
+	limit  int
+}
+
+func (w *limitedWriter) Write(p []byte) (int, error) {
+	if remaining := w.limit - w.buffer.Len(); remaining > 0 {
+		if len(p) > remaining {
+			w.buffer.Write(p[:remaining])
+		} else {
+			w.buffer.Write(p)
+		}
+	}
+	return len(p), nil
+}
@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+)
+
+func TestLimitedWriterDiscardsOverflow(t *testing.T) {
+	tests := []struct {
+		name   string
+		writes []int
+		want   int
+	}{
+		{name: "under the limit", writes: []int{1000}, want: 1000},
+		{name: "write crosses the limit", writes: []int{1000, 64 * 1024}, want: 64 * 1024},
+		{name: "write past the limit", writes: []int{64 * 1024, 10}, want: 64 * 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buffer bytes.Buffer
+			w := &limitedWriter{buffer: &buffer, limit: 64 * 1024}
+
+			for _, size := range tt.writes {
+				n, err := w.Write(make([]byte, size))
+				if n != size || err != nil {
+					t.Fatalf("Write(%d bytes) = %d, %v; want %d, nil", size, n, err, size)
+				}
+			}
+			if buffer.Len() != tt.want {
+				t.Errorf("kept %d bytes, want %d", buffer.Len(), tt.want)
+			}
+		})
+	}
+}
+
+func TestLimitedWriterChattyPlugin(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+
+	// An unaligned first write makes a later one cross the limit
+	var stderr bytes.Buffer
+	cmd := exec.Command("sh", "-c", "printf x >&2; head -c 200000 /dev/zero >&2")
+	cmd.Stderr = &limitedWriter{buffer: &stderr, limit: 64 * 1024}
+
+	if err := cmd.Run(); err != nil {
+		t.Fatalf("Run: %v", err)
+	}
+	if stderr.Len() != 64*1024 {
+		t.Errorf("kept %d bytes, want %d", stderr.Len(), 64*1024)
+	}
+}
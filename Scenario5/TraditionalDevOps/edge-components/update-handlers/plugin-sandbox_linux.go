@@ -0,0 +1,26 @@
+//go:build linux
+
+package handlers
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// sandbox runs the plugin in its own process group, killed with the agent,
+// and as uid:gid when uid isn't 0
+func sandbox(cmd *exec.Cmd, uid, gid int) error {
+	cmd.SysProcAttr = &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if uid != 0 {
+		cmd.SysProcAttr.Credential = &syscall.Credential{Uid: uint32(uid), Gid: uint32(gid)}
+	}
+	return nil
+}
+
+// killSandbox kills the plugin's whole process group
+func killSandbox(cmd *exec.Cmd) {
+	syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package handlers
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// sandbox can't switch users outside Linux; plugins run as the agent
+func sandbox(cmd *exec.Cmd, uid, gid int) error {
+	if uid != 0 {
+		return fmt.Errorf("running plugins as another user is not supported on %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// killSandbox kills the plugin process
+func killSandbox(cmd *exec.Cmd) {
+	cmd.Process.Kill()
+}
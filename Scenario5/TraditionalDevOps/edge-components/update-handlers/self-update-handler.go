@@ -0,0 +1,396 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selfUpdateStateFile records the boot handshake across restarts
+const selfUpdateStateFile = "self-update.json"
+
+// SelfUpdateState is the persisted state of the agent's own binary
+type SelfUpdateState struct {
+	Version         string    `json:"version"`
+	Target          string    `json:"target"`
+	PreviousVersion string    `json:"previousVersion"`
+	PreviousTarget  string    `json:"previousTarget"`
+	Pending         bool      `json:"pending"`                // the current binary hasn't confirmed a healthy boot yet
+	Attempts        int       `json:"attempts"`               // boots of the pending binary so far
+	RevertedFrom    string    `json:"revertedFrom,omitempty"` // version reverted after it failed to confirm
+	UpdatedAt       time.Time `json:"updatedAt"`
+}
+
+// SelfUpdateHandler is an UpdateHandler for the agent's own binary. A new
+// binary is staged beside the running one and the link the service manager
+// starts is swapped to it, then the agent restarts into it. The new binary
+// must call Boot on start and ConfirmHealthy once it is healthy; if it crashes
+// on start MaxBootAttempts times, or doesn't confirm within BootTimeout, the
+// link is swapped back and the agent restarts into the previous binary.
+type SelfUpdateHandler struct {
+	basePath        string
+	binaryLink      string
+	binaryName      string
+	versionArgs     []string
+	startTimeout    time.Duration
+	bootTimeout     time.Duration
+	maxBootAttempts int
+	restartDelay    time.Duration
+	restart         func()
+	bootTimer       *time.Timer
+	handlerMutex    sync.Mutex
+}
+
+// SelfUpdateHandlerConfig contains configuration for the SelfUpdateHandler
+type SelfUpdateHandlerConfig struct {
+	BasePath        string
+	BinaryLink      string   // symlink the service manager executes, e.g. /opt/edge-agent/bin/agent
+	BinaryName      string   // binary inside the package, agent by default; a package that isn't a tar.gz is the binary itself
+	VersionArgs     []string // arguments for a start check of a staged binary, -version by default
+	StartTimeout    time.Duration
+	BootTimeout     time.Duration // time the new binary has to confirm a healthy boot, 5 minutes by default
+	MaxBootAttempts int           // boots of a pending binary before reverting, 3 by default
+	RestartDelay    time.Duration // delay before restarting into the new binary, so the update can be reported
+	Restart         func()        // restarts the agent; exits for the service manager to restart it by default
+}
+
+// NewSelfUpdateHandler creates a new SelfUpdateHandler
+func NewSelfUpdateHandler(config SelfUpdateHandlerConfig) (*SelfUpdateHandler, error) {
+	if config.BinaryLink == "" {
+		return nil, fmt.Errorf("binary link is required")
+	}
+
+	if err := os.MkdirAll(filepath.Join(config.BasePath, "versions"), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create agent version directory: %w", err)
+	}
+
+	if config.BinaryName == "" {
+		config.BinaryName = "agent"
+	}
+	if config.VersionArgs == nil {
+		config.VersionArgs = []string{"-version"}
+	}
+	if config.StartTimeout == 0 {
+		config.StartTimeout = 10 * time.Second
+	}
+	if config.BootTimeout == 0 {
+		config.BootTimeout = 5 * time.Minute
+	}
+	if config.MaxBootAttempts == 0 {
+		config.MaxBootAttempts = 3
+	}
+	if config.RestartDelay == 0 {
+		config.RestartDelay = 5 * time.Second
+	}
+	if config.Restart == nil {
+		config.Restart = func() { os.Exit(0) }
+	}
+
+	return &SelfUpdateHandler{
+		basePath:        config.BasePath,
+		binaryLink:      config.BinaryLink,
+		binaryName:      config.BinaryName,
+		versionArgs:     config.VersionArgs,
+		startTimeout:    config.StartTimeout,
+		bootTimeout:     config.BootTimeout,
+		maxBootAttempts: config.MaxBootAttempts,
+		restartDelay:    config.RestartDelay,
+		restart:         config.Restart,
+	}, nil
+}
+
+// ValidateUpdate stages the new binary and checks that it starts
+func (su *SelfUpdateHandler) ValidateUpdate(packagePath string) error {
+	stagingDir := su.stagingDir(packagePath)
+	os.RemoveAll(stagingDir)
+
+	binary, err := su.stage(packagePath, stagingDir)
+	if err != nil {
+		os.RemoveAll(stagingDir)
+		return err
+	}
+
+	if err := su.checkStarts(binary); err != nil {
+		os.RemoveAll(stagingDir)
+		return err
+	}
+
+	return nil
+}
+
+// HandleUpdate swaps the binary link to the staged binary and schedules a
+// restart into it; the update is pending until the new binary confirms
+func (su *SelfUpdateHandler) HandleUpdate(packagePath string, version string) error {
+	su.handlerMutex.Lock()
+	defer su.handlerMutex.Unlock()
+
+	stagingDir := su.stagingDir(packagePath)
+
+	// The version names a directory under versions
+	if version == "" || version == "." || version == ".." || strings.ContainsAny(version, `/\`) {
+		os.RemoveAll(stagingDir)
+		return fmt.Errorf("invalid agent version %q", version)
+	}
+
+	state, err := su.loadState()
+	if err != nil {
+		return err
+	}
+	if state.Pending {
+		return fmt.Errorf("agent %s has not confirmed its boot yet", state.Version)
+	}
+
+	// Replacing the running version would delete the binary the link points at
+	versionDir := filepath.Join(su.basePath, "versions", version)
+	current, _ := os.Readlink(su.binaryLink)
+	if version == state.Version || (current != "" && filepath.Dir(current) == versionDir) {
+		os.RemoveAll(stagingDir)
+		return fmt.Errorf("agent %s is already running", version)
+	}
+
+	os.RemoveAll(versionDir)
+	if err := os.Rename(stagingDir, versionDir); err != nil {
+		return fmt.Errorf("failed to move agent binary into place: %w", err)
+	}
+	target := filepath.Join(versionDir, su.binaryName)
+
+	// Record the pending update before the swap, so a crash in between reverts
+	next := &SelfUpdateState{
+		Version:         version,
+		Target:          target,
+		PreviousVersion: state.Version,
+		PreviousTarget:  state.Target,
+		Pending:         true,
+	}
+	if next.PreviousTarget == "" {
+		next.PreviousTarget, _ = os.Readlink(su.binaryLink)
+	}
+	if err := su.saveState(next); err != nil {
+		return err
+	}
+
+	if _, err := swapSymlink(su.binaryLink, target); err != nil {
+		su.saveState(state)
+		return err
+	}
+
+	log.Printf("Agent %s staged; restarting in %s", version, su.restartDelay)
+	time.AfterFunc(su.restartDelay, su.restart)
+
+	return nil
+}
+
+// RollbackUpdate swaps the binary link back to the previous binary and restarts into it
+func (su *SelfUpdateHandler) RollbackUpdate() error {
+	su.handlerMutex.Lock()
+	defer su.handlerMutex.Unlock()
+
+	state, err := su.loadState()
+	if err != nil {
+		return err
+	}
+
+	if err := su.revert(state); err != nil {
+		return err
+	}
+
+	time.AfterFunc(su.restartDelay, su.restart)
+	return nil
+}
+
+// Boot runs the boot handshake and must be called early in the agent's
+// start. A pending binary gets MaxBootAttempts starts and BootTimeout to call
+// ConfirmHealthy before the previous binary is restored. The returned state
+// tells a reverted agent which version failed, so it can report it.
+func (su *SelfUpdateHandler) Boot() (*SelfUpdateState, error) {
+	su.handlerMutex.Lock()
+	defer su.handlerMutex.Unlock()
+
+	state, err := su.loadState()
+	if err != nil {
+		return nil, err
+	}
+
+	if !state.Pending {
+		return state, nil
+	}
+
+	// Count the attempt before anything else, so crash loops are counted too
+	state.Attempts++
+	if err := su.saveState(state); err != nil {
+		return nil, err
+	}
+
+	if state.Attempts > su.maxBootAttempts {
+		log.Printf("Agent %s failed to confirm after %d boots; reverting to %s", state.Version, state.Attempts-1, state.PreviousVersion)
+		if err := su.revert(state); err != nil {
+			return nil, err
+		}
+		su.restart()
+		return state, nil
+	}
+
+	su.bootTimer = time.AfterFunc(su.bootTimeout, func() {
+		su.handlerMutex.Lock()
+		defer su.handlerMutex.Unlock()
+
+		current, err := su.loadState()
+		if err != nil || !current.Pending {
+			return
+		}
+
+		log.Printf("Agent %s did not report healthy within %s; reverting to %s", current.Version, su.bootTimeout, current.PreviousVersion)
+		if err := su.revert(current); err != nil {
+			log.Printf("Failed to revert agent: %v", err)
+			return
+		}
+		su.restart()
+	})
+
+	return state, nil
+}
+
+// ConfirmHealthy completes the boot handshake of a pending binary, keeping it
+func (su *SelfUpdateHandler) ConfirmHealthy() error {
+	su.handlerMutex.Lock()
+	defer su.handlerMutex.Unlock()
+
+	if su.bootTimer != nil {
+		su.bootTimer.Stop()
+		su.bootTimer = nil
+	}
+
+	state, err := su.loadState()
+	if err != nil {
+		return err
+	}
+	if !state.Pending {
+		return nil
+	}
+
+	state.Pending = false
+	state.Attempts = 0
+	state.RevertedFrom = ""
+	if err := su.saveState(state); err != nil {
+		return err
+	}
+
+	log.Printf("Agent %s confirmed healthy", state.Version)
+	return nil
+}
+
+// revert swaps the link back to the previous binary and records the revert
+func (su *SelfUpdateHandler) revert(state *SelfUpdateState) error {
+	if state.PreviousTarget == "" {
+		return fmt.Errorf("no previous agent binary to revert to")
+	}
+
+	if _, err := swapSymlink(su.binaryLink, state.PreviousTarget); err != nil {
+		return fmt.Errorf("failed to restore previous agent binary: %w", err)
+	}
+
+	return su.saveState(&SelfUpdateState{
+		Version:      state.PreviousVersion,
+		Target:       state.PreviousTarget,
+		RevertedFrom: state.Version,
+	})
+}
+
+// stage places the package's binary in the staging directory
+func (su *SelfUpdateHandler) stage(packagePath, stagingDir string) (string, error) {
+	binary := filepath.Join(stagingDir, su.binaryName)
+
+	if err := extractTarGz(packagePath, stagingDir); err != nil {
+		// Not an archive; the package is the binary itself
+		os.RemoveAll(stagingDir)
+		if err := os.MkdirAll(stagingDir, 0755); err != nil {
+			return "", fmt.Errorf("failed to create staging directory: %w", err)
+		}
+
+		data, err := ioutil.ReadFile(packagePath)
+		if err != nil {
+			return "", fmt.Errorf("failed to read agent package: %w", err)
+		}
+		if err := ioutil.WriteFile(binary, data, 0755); err != nil {
+			return "", fmt.Errorf("failed to stage agent binary: %w", err)
+		}
+		return binary, nil
+	}
+
+	info, err := os.Stat(binary)
+	if err != nil {
+		return "", fmt.Errorf("package has no %s binary: %w", su.binaryName, err)
+	}
+	if err := os.Chmod(binary, info.Mode()|0755); err != nil {
+		return "", fmt.Errorf("failed to make agent binary executable: %w", err)
+	}
+
+	return binary, nil
+}
+
+// checkStarts runs the staged binary with the version arguments
+func (su *SelfUpdateHandler) checkStarts(binary string) error {
+	if len(su.versionArgs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), su.startTimeout)
+	defer cancel()
+
+	out, err := exec.CommandContext(ctx, binary, su.versionArgs...).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("staged agent binary failed to start: %w: %s", err, out)
+	}
+
+	return nil
+}
+
+// loadState reads the persisted state; a missing file is an unmanaged install
+func (su *SelfUpdateHandler) loadState() (*SelfUpdateState, error) {
+	data, err := ioutil.ReadFile(filepath.Join(su.basePath, selfUpdateStateFile))
+	if os.IsNotExist(err) {
+		return &SelfUpdateState{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read self-update state: %w", err)
+	}
+
+	var state SelfUpdateState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("failed to parse self-update state: %w", err)
+	}
+
+	return &state, nil
+}
+
+// saveState atomically replaces the persisted state
+func (su *SelfUpdateHandler) saveState(state *SelfUpdateState) error {
+	state.UpdatedAt = time.Now().UTC()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal self-update state: %w", err)
+	}
+
+	path := filepath.Join(su.basePath, selfUpdateStateFile)
+	if err := ioutil.WriteFile(path+".tmp", data, 0644); err != nil {
+		return fmt.Errorf("failed to write self-update state: %w", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		return fmt.Errorf("failed to write self-update state: %w", err)
+	}
+
+	return nil
+}
+
+func (su *SelfUpdateHandler) stagingDir(packagePath string) string {
+	return filepath.Join(su.basePath, "staging", filepath.Base(packagePath))
+}
@@ -0,0 +1,93 @@
+package handlers
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelfUpdateRejectsVersion(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not installed")
+	}
+
+	base := t.TempDir()
+	link := filepath.Join(base, "agent")
+	su, err := NewSelfUpdateHandler(SelfUpdateHandlerConfig{
+		BasePath:     base,
+		BinaryLink:   link,
+		RestartDelay: time.Hour,
+		Restart:      func() {},
+	})
+	if err != nil {
+		t.Fatalf("NewSelfUpdateHandler: %v", err)
+	}
+
+	// The package is the binary itself
+	pkg := filepath.Join(t.TempDir(), "agent-package")
+	if err := os.WriteFile(pkg, []byte("#!/bin/sh\nexit 0\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The running version, installed before the handler managed the link
+	running := filepath.Join(base, "versions", "1.0.0", "agent")
+	if err := os.MkdirAll(filepath.Dir(running), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(running, []byte("running"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(running, link); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		version string
+		wantErr string
+	}{
+		{name: "empty", version: "", wantErr: `invalid agent version ""`},
+		{name: "parent directory", version: "..", wantErr: `invalid agent version ".."`},
+		{name: "path separator", version: "../1.0.0", wantErr: `invalid agent version "../1.0.0"`},
+		{name: "running version", version: "1.0.0", wantErr: "agent 1.0.0 is already running"},
+		{name: "new version", version: "1.1.0"},
+		{name: "running version after update", version: "1.1.0", wantErr: "agent 1.1.0 is already running"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := su.ValidateUpdate(pkg); err != nil {
+				t.Fatalf("ValidateUpdate: %v", err)
+			}
+
+			err := su.HandleUpdate(pkg, tt.version)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("HandleUpdate(%q): %v", tt.version, err)
+				}
+				if err := su.ConfirmHealthy(); err != nil {
+					t.Fatalf("ConfirmHealthy: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("HandleUpdate(%q) error = %v, want %q", tt.version, err, tt.wantErr)
+			}
+
+			// A refused update leaves the running binary and clears the staging directory
+			target, err := os.Readlink(link)
+			if err != nil {
+				t.Fatalf("Readlink: %v", err)
+			}
+			if _, err := os.Stat(target); err != nil {
+				t.Errorf("running binary: %v", err)
+			}
+			if _, err := os.Stat(su.stagingDir(pkg)); !os.IsNotExist(err) {
+				t.Errorf("staging directory left behind: %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// SlotTraffic summarizes the connections one slot served during a shadow period
+type SlotTraffic struct {
+	Connections   int           `json:"connections"`
+	ErrorRate     float64       `json:"errorRate"`
+	MeanFirstByte time.Duration `json:"meanFirstByte"` // connection start to first response byte
+}
+
+// ShadowResult compares a shadow slot's handling of mirrored traffic with the live slot
+type ShadowResult struct {
+	Live    SlotTraffic `json:"live"`
+	Shadow  SlotTraffic `json:"shadow"`
+	Dropped int         `json:"dropped"` // mirrored connections abandoned because the shadow fell behind
+	Passed  bool        `json:"passed"`
+	Reason  string      `json:"reason"`
+}
+
+// shadowCriteria decide whether a shadow slot may take live traffic
+type shadowCriteria struct {
+	minConnections   int
+	maxErrorIncrease float64
+	maxLatencyRatio  float64
+}
+
+// shadowSession mirrors a sample of live connections to a shadow slot
+// whose responses are discarded
+type shadowSession struct {
+	addr       string
+	sampleRate float64
+	live       trafficStats
+	shadow     trafficStats
+	dropped    atomic.Int64
+}
+
+// trafficStats accumulates connection outcomes
+type trafficStats struct {
+	mutex       sync.Mutex
+	connections int
+	errors      int
+	responded   int
+	firstByte   time.Duration
+}
+
+// sampled reports whether a new connection should be mirrored
+func (ss *shadowSession) sampled() bool {
+	return ss.sampleRate >= 1 || rand.Float64() < ss.sampleRate
+}
+
+// mirror dials the shadow slot and returns a writer that forwards client
+// bytes to it without ever blocking the live connection; close signals the
+// end of client input. The shadow's outcome is recorded when it finishes.
+func (ss *shadowSession) mirror(start time.Time) (io.Writer, func()) {
+	chunks := make(chan []byte, 64)
+	m := &mirrorWriter{chunks: chunks, session: ss}
+
+	go func() {
+		conn, err := net.DialTimeout("tcp", ss.addr, 5*time.Second)
+		if err != nil {
+			ss.shadow.record(0, false, err)
+			for range chunks {
+			}
+			return
+		}
+		defer conn.Close()
+
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			firstByte, responded, err := readFirstByte(conn, start)
+			ss.shadow.record(firstByte, responded, err)
+		}()
+
+		for chunk := range chunks {
+			if _, err := conn.Write(chunk); err != nil {
+				break
+			}
+		}
+		if tcp, ok := conn.(*net.TCPConn); ok {
+			tcp.CloseWrite()
+		}
+
+		select {
+		case <-done:
+		case <-time.After(30 * time.Second):
+		}
+	}()
+
+	return m, m.close
+}
+
+// result compares the live and shadow traffic seen so far
+func (ss *shadowSession) result(criteria shadowCriteria) ShadowResult {
+	result := ShadowResult{
+		Live:    ss.live.snapshot(),
+		Shadow:  ss.shadow.snapshot(),
+		Dropped: int(ss.dropped.Load()),
+	}
+
+	switch {
+	case result.Shadow.Connections < criteria.minConnections:
+		result.Reason = fmt.Sprintf("only %d mirrored connections, need %d", result.Shadow.Connections, criteria.minConnections)
+	case result.Shadow.ErrorRate > result.Live.ErrorRate+criteria.maxErrorIncrease:
+		result.Reason = fmt.Sprintf("error rate %.3f against %.3f live", result.Shadow.ErrorRate, result.Live.ErrorRate)
+	case result.Live.MeanFirstByte > 0 &&
+		float64(result.Shadow.MeanFirstByte) > float64(result.Live.MeanFirstByte)*criteria.maxLatencyRatio:
+		result.Reason = fmt.Sprintf("first-byte latency %s against %s live", result.Shadow.MeanFirstByte, result.Live.MeanFirstByte)
+	default:
+		result.Passed = true
+		result.Reason = "shadow matched live traffic"
+	}
+
+	return result
+}
+
+// record adds one connection's outcome
+func (ts *trafficStats) record(firstByte time.Duration, responded bool, err error) {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	ts.connections++
+	if err != nil {
+		ts.errors++
+	}
+	if responded {
+		ts.responded++
+		ts.firstByte += firstByte
+	}
+}
+
+// snapshot summarizes the recorded connections
+func (ts *trafficStats) snapshot() SlotTraffic {
+	ts.mutex.Lock()
+	defer ts.mutex.Unlock()
+
+	traffic := SlotTraffic{Connections: ts.connections}
+	if ts.connections > 0 {
+		traffic.ErrorRate = float64(ts.errors) / float64(ts.connections)
+	}
+	if ts.responded > 0 {
+		traffic.MeanFirstByte = ts.firstByte / time.Duration(ts.responded)
+	}
+	return traffic
+}
+
+// mirrorWriter queues client bytes for the shadow, abandoning the mirror
+// rather than blocking when the shadow falls behind
+type mirrorWriter struct {
+	chunks  chan []byte
+	session *shadowSession
+	closed  bool
+	mutex   sync.Mutex
+}
+
+func (m *mirrorWriter) Write(p []byte) (int, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.closed {
+		return len(p), nil
+	}
+
+	select {
+	case m.chunks <- append([]byte(nil), p...):
+	default:
+		m.session.dropped.Add(1)
+		m.closed = true
+		close(m.chunks)
+	}
+
+	return len(p), nil
+}
+
+func (m *mirrorWriter) close() {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if !m.closed {
+		m.closed = true
+		close(m.chunks)
+	}
+}
+
+// firstByteWriter records when the first response byte passes through
+type firstByteWriter struct {
+	w     io.Writer
+	start time.Time
+	first time.Duration
+	wrote bool
+}
+
+func (f *firstByteWriter) Write(p []byte) (int, error) {
+	if !f.wrote && len(p) > 0 {
+		f.wrote = true
+		f.first = time.Since(f.start)
+	}
+	return f.w.Write(p)
+}
+
+// Helper functions
+
+// readFirstByte drains a shadow response, timing its first byte
+func readFirstByte(conn net.Conn, start time.Time) (time.Duration, bool, error) {
+	counter := &firstByteWriter{w: io.Discard, start: start}
+	_, err := io.Copy(counter, conn)
+	if errors.Is(err, net.ErrClosed) {
+		err = nil
+	}
+	return counter.first, counter.wrote, err
+}
@@ -0,0 +1,133 @@
+package validation
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var (
+	// tableNamePattern is DynamoDB's table naming rule
+	tableNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,255}$`)
+
+	// bucketNamePattern is S3's bucket naming rule, without the IP address and prefix exceptions
+	bucketNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+)
+
+// FieldError is one invalid configuration field
+type FieldError struct {
+	Field   string `json:"field"`   // e.g. CheckInterval
+	Problem string `json:"problem"` // what is wrong
+	Fix     string `json:"fix"`     // how to correct it
+}
+
+func (e FieldError) Error() string {
+	if e.Fix == "" {
+		return fmt.Sprintf("%s %s", e.Field, e.Problem)
+	}
+	return fmt.Sprintf("%s %s; %s", e.Field, e.Problem, e.Fix)
+}
+
+// Error lists every invalid field of a configuration, so all of them can be
+// fixed at once; match it with errors.As to inspect the fields
+type Error struct {
+	Config string       `json:"config"` // e.g. RolloutConfig
+	Fields []FieldError `json:"fields"`
+}
+
+func (e *Error) Error() string {
+	problems := make([]string, len(e.Fields))
+	for i, field := range e.Fields {
+		problems[i] = field.Error()
+	}
+	return fmt.Sprintf("invalid %s: %s", e.Config, strings.Join(problems, "; "))
+}
+
+// Has reports whether a field is invalid
+func (e *Error) Has(field string) bool {
+	for _, f := range e.Fields {
+		if f.Field == field {
+			return true
+		}
+	}
+	return false
+}
+
+// Checker collects field errors for one configuration
+type Checker struct {
+	err Error
+}
+
+// NewChecker creates a Checker for the named configuration
+func NewChecker(config string) *Checker {
+	return &Checker{err: Error{Config: config}}
+}
+
+// Add records an invalid field
+func (c *Checker) Add(field, problem, fix string) {
+	c.err.Fields = append(c.err.Fields, FieldError{Field: field, Problem: problem, Fix: fix})
+}
+
+// Check records an invalid field when ok is false
+func (c *Checker) Check(ok bool, field, problem, fix string) {
+	if !ok {
+		c.Add(field, problem, fix)
+	}
+}
+
+// Err returns an *Error listing the invalid fields, or nil when there are none
+func (c *Checker) Err() error {
+	if len(c.err.Fields) == 0 {
+		return nil
+	}
+	err := c.err
+	return &err
+}
+
+// TableName checks a DynamoDB table name
+func (c *Checker) TableName(field, name string, required bool) {
+	switch {
+	case name == "" && required:
+		c.Add(field, "is required", "set it to the DynamoDB table name")
+	case name != "" && !tableNamePattern.MatchString(name):
+		c.Add(field, fmt.Sprintf("%q is not a valid DynamoDB table name", name), "use 3-255 letters, digits, '_', '-' or '.'")
+	}
+}
+
+// BucketName checks an S3 bucket name
+func (c *Checker) BucketName(field, name string, required bool) {
+	switch {
+	case name == "" && required:
+		c.Add(field, "is required", "set it to the S3 bucket name")
+	case name != "" && (!bucketNamePattern.MatchString(name) || strings.Contains(name, "..")):
+		c.Add(field, fmt.Sprintf("%q is not a valid S3 bucket name", name), "use 3-63 lowercase letters, digits, '-' or '.'")
+	}
+}
+
+// WritableDir checks that path is, or can be created as, a writable directory
+func (c *Checker) WritableDir(field, path string) {
+	if path == "" {
+		c.Add(field, "is required", "set it to a directory the agent can write")
+		return
+	}
+	if err := writableDir(path); err != nil {
+		c.Add(field, fmt.Sprintf("%q is not writable: %v", path, err), "create it or give the agent's user write access")
+	}
+}
+
+// Helper functions
+
+func writableDir(path string) error {
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return err
+	}
+
+	probe, err := ioutil.TempFile(path, ".write-check-")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}